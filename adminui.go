@@ -0,0 +1,320 @@
+package memongo
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// outputTailLines is how many lines of mongod's output the admin UI shows
+const outputTailLines = 50
+
+// outputTail keeps the last lines a process wrote, for the admin UI. If it
+// has a name, each line starts with it, e.g. "[rs0-m1] ".
+type outputTail struct {
+	name    string
+	mu      sync.Mutex
+	lines   []string
+	partial []byte
+}
+
+func (t *outputTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(t.partial[:i])
+		if t.name != "" {
+			line = "[" + t.name + "] " + line
+		}
+		t.lines = append(t.lines, line)
+		t.partial = t.partial[i+1:]
+	}
+	if len(t.lines) > outputTailLines {
+		t.lines = append([]string(nil), t.lines[len(t.lines)-outputTailLines:]...)
+	}
+
+	return len(p), nil
+}
+
+// recent returns the last lines written, oldest first. t may be nil.
+func (t *outputTail) recent() []string {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]string(nil), t.lines...)
+}
+
+// adminCollection is a collection listed by the admin UI
+type adminCollection struct {
+	Name      string
+	Documents int64
+}
+
+// adminDatabase is a database listed by the admin UI
+type adminDatabase struct {
+	Name        string
+	Collections []adminCollection
+}
+
+// adminUI serves a page showing what's in a server, with a button to stop
+// it. Every request needs the token.
+type adminUI struct {
+	server     *Server
+	token      string
+	httpServer *http.Server
+	url        string
+
+	// databases lists the server's databases; tests replace it
+	databases func(ctx context.Context) ([]adminDatabase, error)
+}
+
+// newAdminUI returns the admin UI of server, guarded by a random token
+func newAdminUI(server *Server) (*adminUI, error) {
+	token := make([]byte, 16)
+	_, err := rand.Read(token)
+	if err != nil {
+		return nil, fmt.Errorf("error generating an admin UI token: %w", err)
+	}
+
+	return &adminUI{
+		server:    server,
+		token:     hex.EncodeToString(token),
+		databases: server.adminDatabases,
+	}, nil
+}
+
+// startAdminUI binds addr and starts serving the admin UI of server in the
+// background
+func startAdminUI(server *Server, addr string) (*adminUI, error) {
+	a, err := newAdminUI(server)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error starting admin UI listener on %s: %w", addr, err)
+	}
+
+	a.url = fmt.Sprintf("http://%s/?token=%s", listener.Addr().String(), a.token)
+	a.httpServer = &http.Server{
+		Handler:           a.handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	goTracked("admin UI listener on "+addr, func() {
+		err := a.httpServer.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			server.logger.Warnf("admin UI listener on %s failed: %s", addr, err)
+		}
+	})
+
+	server.logger.Infof("Serving the admin UI at %s", a.url)
+
+	return a, nil
+}
+
+// stop shuts down the listener. a may be nil.
+func (a *adminUI) stop() {
+	if a == nil || a.httpServer == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = a.httpServer.Shutdown(ctx)
+}
+
+func (a *adminUI) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", a.serveIndex)
+	mux.HandleFunc("/stop", a.serveStop)
+
+	return a.requireToken(mux)
+}
+
+// requireToken rejects requests without the token, in the query or a form
+func (a *adminUI) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.FormValue("token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			http.Error(w, "missing or wrong token", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminPage is what the admin page template shows
+type adminPage struct {
+	Token      string
+	Running    bool
+	Version    string
+	ReplicaSet string
+	DBPath     string
+	URI        string
+	Revealed   bool
+	Databases  []adminDatabase
+	Error      string
+	Log        []string
+}
+
+func (a *adminUI) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	s := a.server
+	page := adminPage{
+		Token:      a.token,
+		Running:    s.checkRunning() == nil,
+		Version:    s.version,
+		ReplicaSet: s.ReplicaSetName(),
+		DBPath:     s.DBPath(),
+		URI:        RedactURI(s.URI()),
+		Revealed:   r.FormValue("reveal") != "",
+	}
+	if page.Revealed {
+		page.URI = s.URI()
+	}
+
+	s.mu.Lock()
+	page.Log = s.proc.output.recent()
+	s.mu.Unlock()
+	if !s.opts.DisableRedaction {
+		for i, line := range page.Log {
+			page.Log[i] = redactText(line)
+		}
+	}
+
+	if page.Running {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		databases, err := a.databases(ctx)
+		cancel()
+		if err != nil {
+			page.Error = s.opts.redactError(err).Error()
+		}
+		page.Databases = databases
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := adminTemplate.Execute(w, page)
+	if err != nil {
+		s.logger.Warnf("error rendering the admin UI: %s", err)
+	}
+}
+
+func (a *adminUI) serveStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "stop needs a POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Stop shuts this listener down, which waits for the request to finish
+	goTracked("admin UI stop", a.server.Stop)
+
+	http.Redirect(w, r, "/?token="+url.QueryEscape(a.token), http.StatusSeeOther)
+}
+
+// adminDatabases lists every database and collection, with estimated
+// document counts
+func (s *Server) adminDatabases(ctx context.Context) ([]adminDatabase, error) {
+	client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	names, err := client.ListDatabaseNames(ctx, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing databases: %w", err)
+	}
+	sort.Strings(names)
+
+	databases := make([]adminDatabase, 0, len(names))
+	for _, name := range names {
+		db := client.Database(name)
+		collections, err := db.ListCollectionNames(ctx, bson.D{})
+		if err != nil {
+			return databases, fmt.Errorf("error listing the collections of %s: %w", name, err)
+		}
+		sort.Strings(collections)
+
+		listed := adminDatabase{Name: name}
+		for _, coll := range collections {
+			count, err := db.Collection(coll).EstimatedDocumentCount(ctx)
+			if err != nil {
+				return databases, fmt.Errorf("error counting the documents in %s.%s: %w", name, coll, err)
+			}
+			listed.Collections = append(listed.Collections, adminCollection{Name: coll, Documents: count})
+		}
+		databases = append(databases, listed)
+	}
+
+	return databases, nil
+}
+
+// AdminUIURL returns the URL of the admin UI, including its token, or "" if
+// Options.AdminUIAddr wasn't set
+func (s *Server) AdminUIURL() string {
+	if s.admin == nil {
+		return ""
+	}
+
+	return s.admin.url
+}
+
+var adminTemplate = template.Must(template.New("admin").Parse(`<!DOCTYPE html>
+<html>
+<head><title>memongo</title></head>
+<body>
+<h1>memongo</h1>
+<table>
+<tr><th>Status</th><td>{{if .Running}}running{{else}}stopped{{end}}</td></tr>
+<tr><th>Version</th><td>{{.Version}}</td></tr>
+{{if .ReplicaSet}}<tr><th>Replica set</th><td>{{.ReplicaSet}}</td></tr>{{end}}
+<tr><th>Data directory</th><td>{{.DBPath}}</td></tr>
+<tr><th>URI</th><td><code>{{.URI}}</code>{{if not .Revealed}} <a href="/?token={{.Token}}&amp;reveal=1">reveal</a>{{end}}</td></tr>
+</table>
+{{if .Running}}
+<form method="post" action="/stop"><input type="hidden" name="token" value="{{.Token}}"><button type="submit">Stop</button></form>
+{{end}}
+<h2>Databases</h2>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+{{range .Databases}}
+<h3>{{.Name}}</h3>
+<ul>{{range .Collections}}<li>{{.Name}}: {{.Documents}} documents</li>{{else}}<li>no collections</li>{{end}}</ul>
+{{end}}
+<h2>Recent mongod output</h2>
+<pre>{{range .Log}}{{.}}
+{{end}}</pre>
+</body>
+</html>
+`))
@@ -0,0 +1,218 @@
+package memongo
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// IgnoredOption is an option that was given, but has no effect with the
+// other options
+type IgnoredOption struct {
+	// Option is the name of the Options field, e.g. "ReplicaSetName"
+	Option string
+
+	// Reason explains why it's ignored
+	Reason string
+}
+
+func (o IgnoredOption) String() string {
+	return fmt.Sprintf("%s is ignored: %s", o.Option, o.Reason)
+}
+
+// applicabilityRule describes when an option has an effect. An option that's
+// given when it doesn't apply is ignored.
+type applicabilityRule struct {
+	option string
+	given  func(opts *Options) bool
+
+	// applies reports whether the option has an effect. caps is nil if the
+	// MongoDB version isn't known yet, which only rules with needsVersion
+	// look at.
+	applies      func(opts *Options, caps *versionCapabilities) bool
+	needsVersion bool
+
+	reason string
+}
+
+// applicabilityRules lists every combination of options in which an option
+// is silently ignored. Options that are rejected outright, such as
+// SRVDNSPort without SRVDomain, are checked by Validate instead.
+var applicabilityRules = []applicabilityRule{
+	{
+		option:  "ReplicaSetName",
+		given:   func(opts *Options) bool { return opts.ReplicaSetName != "" },
+		applies: isReplicaSet,
+		reason:  "the server is standalone; set ShouldUseReplica",
+	},
+	{
+		option:  "ReplicaSetReadyTimeout",
+		given:   func(opts *Options) bool { return opts.ReplicaSetReadyTimeout != 0 },
+		applies: isReplicaSet,
+		reason:  "the server is standalone; set ShouldUseReplica",
+	},
+	{
+		option:  "ReplicaSetRetryPolicy",
+		given:   func(opts *Options) bool { return opts.ReplicaSetRetryPolicy != nil },
+		applies: isReplicaSet,
+		reason:  "the server is standalone; set ShouldUseReplica",
+	},
+	{
+		option:       "WiredTigerCacheSizeGB",
+		given:        func(opts *Options) bool { return opts.WiredTigerCacheSizeGB != 0 },
+		applies:      usesWiredTiger,
+		needsVersion: true,
+		reason:       "standalone servers before MongoDB 6.1 use the ephemeralForTest storage engine, which has no WiredTiger cache",
+	},
+	{
+		option:       "WiredTigerCacheSizePct",
+		given:        func(opts *Options) bool { return opts.WiredTigerCacheSizePct != 0 },
+		applies:      usesWiredTiger,
+		needsVersion: true,
+		reason:       "standalone servers before MongoDB 6.1 use the ephemeralForTest storage engine, which has no WiredTiger cache",
+	},
+	{
+		option:       "CompactOnInterval",
+		given:        func(opts *Options) bool { return opts.CompactOnInterval != 0 },
+		applies:      usesWiredTiger,
+		needsVersion: true,
+		reason:       "standalone servers before MongoDB 6.1 use the ephemeralForTest storage engine, which keeps data in memory",
+	},
+	{
+		option:  "CachePath",
+		given:   func(opts *Options) bool { return opts.downloadOptions().CachePath != "" },
+		applies: downloadsMongod,
+		reason:  "MongodBin is run instead of a downloaded mongod",
+	},
+	{
+		option:  "DownloadURL",
+		given:   func(opts *Options) bool { return opts.downloadOptions().DownloadURL != "" },
+		applies: downloadsMongod,
+		reason:  "MongodBin is run instead of a downloaded mongod",
+	},
+	{
+		option:  "Offline",
+		given:   func(opts *Options) bool { return opts.downloadOptions().Offline },
+		applies: downloadsMongod,
+		reason:  "MongodBin is run instead of a downloaded mongod",
+	},
+	{
+		option:  "Downloader",
+		given:   func(opts *Options) bool { return opts.Downloader != nil },
+		applies: downloadsMongod,
+		reason:  "MongodBin is run instead of a downloaded mongod",
+	},
+	{
+		option: "RequireExactDistroMatch",
+		given:  func(opts *Options) bool { return opts.RequireExactDistroMatch },
+		applies: func(opts *Options, caps *versionCapabilities) bool {
+			return downloadsMongod(opts, caps) && opts.downloadOptions().DownloadURL == "" && os.Getenv("MEMONGO_DOWNLOAD_URL") == ""
+		},
+		reason: "mongod isn't downloaded for this platform with MongodBin or DownloadURL",
+	},
+	{
+		option: "PortRange",
+		given:  func(opts *Options) bool { return opts.PortRange != [2]int{} },
+		applies: func(opts *Options, _ *versionCapabilities) bool {
+			return opts.Port == 0 && len(opts.ReplicaMemberPorts) == 0 &&
+				opts.PortReservation == nil && opts.PortReservationProvider == nil
+		},
+		reason: "Port, ReplicaMemberPorts, PortReservation or PortReservationProvider gives the port",
+	},
+	{
+		option: "StartRetryPolicy",
+		given:  func(opts *Options) bool { return opts.StartRetryPolicy != nil },
+		applies: func(opts *Options, _ *versionCapabilities) bool {
+			return opts.StartRetries > 0
+		},
+		reason: "starting isn't retried without StartRetries",
+	},
+	{
+		option: "HealthHTTPStrict",
+		given:  func(opts *Options) bool { return opts.HealthHTTPStrict },
+		applies: func(opts *Options, _ *versionCapabilities) bool {
+			return opts.HealthHTTPAddr != ""
+		},
+		reason: "there are no health probes without HealthHTTPAddr",
+	},
+	{
+		option: "SeedBulkOptions",
+		given:  func(opts *Options) bool { return opts.SeedBulkOptions != nil },
+		applies: func(opts *Options, _ *versionCapabilities) bool {
+			return len(opts.Seed) > 0 || opts.SeedDir != "" || len(opts.SeedGenerated) > 0
+		},
+		reason: "there's nothing to seed without Seed, SeedDir or SeedGenerated",
+	},
+	{
+		option: "OnQuotaExceeded",
+		given:  func(opts *Options) bool { return opts.OnQuotaExceeded != nil },
+		applies: func(opts *Options, _ *versionCapabilities) bool {
+			return opts.MaxDBPathBytes > 0
+		},
+		reason: "there's no quota without MaxDBPathBytes",
+	},
+}
+
+func isReplicaSet(opts *Options, _ *versionCapabilities) bool {
+	return opts.ShouldUseReplica || opts.ReadOnly
+}
+
+// usesWiredTiger mirrors the choice of storage engine in mongodArgs
+func usesWiredTiger(opts *Options, caps *versionCapabilities) bool {
+	return opts.ShouldUseReplica || opts.ReadOnly || !caps.ephemeralForTest
+}
+
+func downloadsMongod(opts *Options, _ *versionCapabilities) bool {
+	return opts.MongodBin == "" && os.Getenv("MEMONGO_MONGOD_BIN") == ""
+}
+
+// ignoredOptions returns the options in opts that are ignored. It must be
+// called before defaults are filled in. If caps is nil, the rules that depend
+// on the MongoDB version are skipped; if onlyVersion is set, the others are.
+func (opts *Options) ignoredOptions(caps *versionCapabilities, onlyVersion bool) []IgnoredOption {
+	var ignored []IgnoredOption
+	for _, rule := range applicabilityRules {
+		if (rule.needsVersion && caps == nil) || (onlyVersion && !rule.needsVersion) {
+			continue
+		}
+		if rule.given(opts) && !rule.applies(opts, caps) {
+			ignored = append(ignored, IgnoredOption{Option: rule.option, Reason: rule.reason})
+		}
+	}
+
+	return ignored
+}
+
+// knownCapabilities returns the capabilities of the MongoDB version the
+// options ask for, or nil if they don't give one
+func (opts *Options) knownCapabilities() *versionCapabilities {
+	version := opts.MongoVersion
+	if version == "" && len(opts.ReplicaMemberVersions) > 0 {
+		version = opts.ReplicaMemberVersions[0]
+	}
+	if version == "" {
+		return nil
+	}
+
+	caps, err := capabilitiesForVersion(version)
+	if err != nil {
+		return nil
+	}
+
+	return &caps
+}
+
+// ignoredOptionsError returns an error wrapping ErrIgnoredOption that lists
+// ignored, or nil if it's empty
+func ignoredOptionsError(ignored []IgnoredOption) error {
+	if len(ignored) == 0 {
+		return nil
+	}
+
+	reasons := make([]string, len(ignored))
+	for i, o := range ignored {
+		reasons[i] = o.String()
+	}
+
+	return fmt.Errorf("%w with Strict: %s", ErrIgnoredOption, strings.Join(reasons, "; "))
+}
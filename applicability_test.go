@@ -0,0 +1,249 @@
+package memongo
+
+import (
+	"testing"
+
+	"github.com/100mslive/memongo/v2/mongobin"
+	"github.com/100mslive/memongo/v2/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnoredOptions(t *testing.T) {
+	caps60, err := capabilitiesForVersion("6.0.0")
+	require.NoError(t, err)
+	caps80, err := capabilitiesForVersion("8.0.0")
+	require.NoError(t, err)
+
+	// Each rule, with the option ignored and applied
+	tests := map[string]struct {
+		opts *Options
+		caps *versionCapabilities
+
+		expected []string
+	}{
+		"ReplicaSetName standalone": {
+			opts:     &Options{ReplicaSetName: "rs1"},
+			expected: []string{"ReplicaSetName"},
+		},
+		"ReplicaSetName replica set": {
+			opts: &Options{ReplicaSetName: "rs1", ShouldUseReplica: true},
+		},
+		"ReplicaSetName read-only": {
+			opts: &Options{ReplicaSetName: "rs1", ReadOnly: true},
+		},
+		"ReplicaSetReadyTimeout standalone": {
+			opts:     &Options{ReplicaSetReadyTimeout: 1},
+			expected: []string{"ReplicaSetReadyTimeout"},
+		},
+		"ReplicaSetReadyTimeout replica set": {
+			opts: &Options{ReplicaSetReadyTimeout: 1, ShouldUseReplica: true},
+		},
+		"WiredTigerCacheSizeGB ephemeralForTest": {
+			opts:     &Options{WiredTigerCacheSizeGB: 1},
+			caps:     &caps60,
+			expected: []string{"WiredTigerCacheSizeGB"},
+		},
+		"WiredTigerCacheSizeGB wiredTiger": {
+			opts: &Options{WiredTigerCacheSizeGB: 1},
+			caps: &caps80,
+		},
+		"WiredTigerCacheSizeGB replica set": {
+			opts: &Options{WiredTigerCacheSizeGB: 1, ShouldUseReplica: true},
+			caps: &caps60,
+		},
+		"WiredTigerCacheSizeGB unknown version": {
+			opts: &Options{WiredTigerCacheSizeGB: 1},
+		},
+		"WiredTigerCacheSizePct ephemeralForTest": {
+			opts:     &Options{WiredTigerCacheSizePct: 10},
+			caps:     &caps60,
+			expected: []string{"WiredTigerCacheSizePct"},
+		},
+		"WiredTigerCacheSizePct wiredTiger": {
+			opts: &Options{WiredTigerCacheSizePct: 10},
+			caps: &caps80,
+		},
+		"CompactOnInterval ephemeralForTest": {
+			opts:     &Options{CompactOnInterval: 1},
+			caps:     &caps60,
+			expected: []string{"CompactOnInterval"},
+		},
+		"CompactOnInterval wiredTiger": {
+			opts: &Options{CompactOnInterval: 1},
+			caps: &caps80,
+		},
+		"CachePath with MongodBin": {
+			opts:     &Options{CachePath: "/cache", MongodBin: "/bin/true"},
+			expected: []string{"CachePath"},
+		},
+		"CachePath downloading": {
+			opts: &Options{CachePath: "/cache"},
+		},
+		"DownloadURL with MongodBin": {
+			opts:     &Options{DownloadURL: "https://example.com/mongodb.tgz", MongodBin: "/bin/true"},
+			expected: []string{"DownloadURL"},
+		},
+		"DownloadURL downloading": {
+			opts: &Options{DownloadURL: "https://example.com/mongodb.tgz"},
+		},
+		"Offline with MongodBin": {
+			opts:     &Options{Offline: true, MongodBin: "/bin/true"},
+			expected: []string{"Offline"},
+		},
+		"Offline downloading": {
+			opts: &Options{Offline: true},
+		},
+		"Downloader with MongodBin": {
+			opts:     &Options{DownloadOptions: DownloadOptions{Downloader: mongobin.HTTPDownloader{}}, MongodBin: "/bin/true"},
+			expected: []string{"Downloader"},
+		},
+		"Downloader downloading": {
+			opts: &Options{DownloadOptions: DownloadOptions{Downloader: mongobin.HTTPDownloader{}}},
+		},
+		"RequireExactDistroMatch with DownloadURL": {
+			opts:     &Options{DownloadOptions: DownloadOptions{RequireExactDistroMatch: true, DownloadURL: "https://example.com/mongodb.tgz"}},
+			expected: []string{"RequireExactDistroMatch"},
+		},
+		"RequireExactDistroMatch downloading": {
+			opts: &Options{DownloadOptions: DownloadOptions{RequireExactDistroMatch: true}},
+		},
+		"PortRange with Port": {
+			opts:     &Options{PortRange: [2]int{20000, 20100}, Port: 27017},
+			expected: []string{"PortRange"},
+		},
+		"PortRange with ReplicaMemberPorts": {
+			opts:     &Options{PortRange: [2]int{20000, 20100}, ReplicaMemberPorts: []int{27017}},
+			expected: []string{"PortRange"},
+		},
+		"PortRange with PortReservationProvider": {
+			opts:     &Options{PortRange: [2]int{20000, 20100}, PortReservationProvider: FreePorts{}},
+			expected: []string{"PortRange"},
+		},
+		"ReplicaSetRetryPolicy standalone": {
+			opts:     &Options{ReplicaSetRetryPolicy: retry.Constant{}},
+			expected: []string{"ReplicaSetRetryPolicy"},
+		},
+		"ReplicaSetRetryPolicy with a replica set": {
+			opts: &Options{ReplicaSetRetryPolicy: retry.Constant{}, ShouldUseReplica: true},
+		},
+		"StartRetryPolicy without StartRetries": {
+			opts:     &Options{StartRetryPolicy: retry.Constant{}},
+			expected: []string{"StartRetryPolicy"},
+		},
+		"StartRetryPolicy with StartRetries": {
+			opts: &Options{StartRetryPolicy: retry.Constant{}, StartRetries: 2},
+		},
+		"PortRange picking a port": {
+			opts: &Options{PortRange: [2]int{20000, 20100}},
+		},
+		"HealthHTTPStrict without HealthHTTPAddr": {
+			opts:     &Options{HealthHTTPStrict: true},
+			expected: []string{"HealthHTTPStrict"},
+		},
+		"HealthHTTPStrict with HealthHTTPAddr": {
+			opts: &Options{HealthHTTPStrict: true, HealthHTTPAddr: "localhost:0"},
+		},
+		"SeedBulkOptions without seeds": {
+			opts:     &Options{SeedBulkOptions: &SeedBulkOptions{}},
+			expected: []string{"SeedBulkOptions"},
+		},
+		"SeedBulkOptions with Seed": {
+			opts: &Options{SeedBulkOptions: &SeedBulkOptions{}, Seed: []SeedCollection{{Database: "db", Collection: "c"}}},
+		},
+		"SeedBulkOptions with SeedDir": {
+			opts: &Options{SeedBulkOptions: &SeedBulkOptions{}, SeedDir: "testdata"},
+		},
+		"SeedBulkOptions with SeedGenerated": {
+			opts: &Options{SeedBulkOptions: &SeedBulkOptions{}, SeedGenerated: []GeneratedCollection{{Database: "db", Collection: "c"}}},
+		},
+		"OnQuotaExceeded without MaxDBPathBytes": {
+			opts:     &Options{OnQuotaExceeded: func(error) {}},
+			expected: []string{"OnQuotaExceeded"},
+		},
+		"OnQuotaExceeded with MaxDBPathBytes": {
+			opts: &Options{OnQuotaExceeded: func(error) {}, MaxDBPathBytes: 1 << 30},
+		},
+		"several": {
+			opts:     &Options{ReplicaSetName: "rs1", WiredTigerCacheSizeGB: 1, CachePath: "/cache", MongodBin: "/bin/true"},
+			caps:     &caps60,
+			expected: []string{"ReplicaSetName", "WiredTigerCacheSizeGB", "CachePath"},
+		},
+	}
+
+	covered := map[string]bool{}
+	for name, test := range tests {
+		for _, option := range test.expected {
+			covered[option] = true
+		}
+		t.Run(name, func(t *testing.T) {
+			var names []string
+			for _, o := range test.opts.ignoredOptions(test.caps, false) {
+				assert.NotEmpty(t, o.Reason)
+				names = append(names, o.Option)
+			}
+			assert.Equal(t, test.expected, names)
+		})
+	}
+
+	for _, rule := range applicabilityRules {
+		assert.True(t, covered[rule.option], "no test ignores %s", rule.option)
+	}
+}
+
+func TestIgnoredOptionsOnlyVersion(t *testing.T) {
+	caps60, err := capabilitiesForVersion("6.0.0")
+	require.NoError(t, err)
+
+	opts := &Options{ReplicaSetName: "rs1", WiredTigerCacheSizeGB: 1}
+	assert.Equal(t, []IgnoredOption{{
+		Option: "WiredTigerCacheSizeGB",
+		Reason: "standalone servers before MongoDB 6.1 use the ephemeralForTest storage engine, which has no WiredTiger cache",
+	}}, opts.ignoredOptions(&caps60, true))
+}
+
+func TestValidateStrict(t *testing.T) {
+	opts := &Options{MongodBin: "/bin/true", ReplicaSetName: "rs1", CachePath: "/cache"}
+	assert.NoError(t, opts.Validate())
+
+	opts.Strict = true
+	err := opts.Validate()
+	assert.ErrorIs(t, err, ErrIgnoredOption)
+	assert.EqualError(t, err, "options have no effect with Strict: "+
+		"ReplicaSetName is ignored: the server is standalone; set ShouldUseReplica; "+
+		"CachePath is ignored: MongodBin is run instead of a downloaded mongod")
+
+	// The engine is known from MongoVersion
+	opts = &Options{MongoVersion: "6.0.0", WiredTigerCacheSizeGB: 1, Strict: true}
+	err = opts.Validate()
+	assert.ErrorIs(t, err, ErrIgnoredOption)
+	assert.Contains(t, err.Error(), "WiredTigerCacheSizeGB is ignored")
+
+	opts = &Options{MongoVersion: "8.0.0", WiredTigerCacheSizeGB: 1, Strict: true}
+	assert.NoError(t, opts.Validate())
+}
+
+func TestStrictFromEnv(t *testing.T) {
+	t.Setenv("MEMONGO_STRICT", "1")
+
+	_, err := (&Options{MongodBin: "/bin/true", ReplicaSetName: "rs1"}).EffectiveOptions()
+	assert.ErrorIs(t, err, ErrIgnoredOption)
+
+	t.Setenv("MEMONGO_STRICT", "yes")
+	_, err = (&Options{MongodBin: "/bin/true"}).EffectiveOptions()
+	assert.EqualError(t, err, `error parsing MEMONGO_STRICT: "yes" is not a boolean`)
+}
+
+func TestIgnoredOptionsAfterDefaults(t *testing.T) {
+	// Defaults such as ReplicaSetName and Port don't count as given when
+	// effective options are used again
+	opts := &Options{MongodBin: "/bin/true", CachePath: "/cache"}
+	effective, err := opts.EffectiveOptions()
+	require.NoError(t, err)
+	assert.Equal(t, []IgnoredOption{{Option: "CachePath", Reason: "MongodBin is run instead of a downloaded mongod"}}, effective.ignored)
+
+	effective.Strict = true
+	again, err := effective.EffectiveOptions()
+	require.NoError(t, err)
+	assert.Equal(t, effective.ignored, again.ignored)
+}
@@ -0,0 +1,262 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// appStackCheckCollection is the collection StartAppStack checks that
+// transactions work in. It's dropped afterwards.
+const appStackCheckCollection = "memongo_transaction_check"
+
+// AppStackConfig configures StartAppStack
+type AppStackConfig struct {
+	// Version is the MongoDB version to run. Transactions need 4.0 or later.
+	Version string
+
+	// AppDBName is the application's database. Defaults to "app".
+	AppDBName string
+
+	// AppUser and AppPassword are the credentials of the application user,
+	// which can read and write AppDBName. Default to "app" and a random
+	// password.
+	AppUser     string
+	AppPassword string
+
+	// RootUser and RootPassword are the credentials of a user with the root
+	// role. Default to "root" and a random password.
+	RootUser     string
+	RootPassword string
+
+	// Options, if given, configure the server further. ShouldUseReplica and
+	// Auth are always set, and Version, if given, replaces MongoVersion.
+	Options *Options
+}
+
+// AppStack is the server most services test against: a single-node replica
+// set with auth, a root user, and an application user, on which transactions
+// have been checked to work
+type AppStack struct {
+	// Server is the underlying server. Its Client authenticates as the root
+	// user.
+	Server *Server
+
+	// AdminURI connects as the root user
+	AdminURI string
+
+	// AppURI connects as the application user, with the application database
+	// as the default database. It names the replica set, so retryable writes
+	// and transactions work.
+	AppURI string
+
+	// Client is connected with AppURI. It's disconnected by Stop.
+	Client *mongo.Client
+}
+
+// StartAppStack starts a single-node replica set with auth, creates a root
+// user and an application user with readWrite on the application database,
+// and checks that transactions commit, so tests get a server configured like
+// production in one call. Stop the stack with Stop.
+func StartAppStack(ctx context.Context, cfg AppStackConfig) (*AppStack, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.fillDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &Options{}
+	if cfg.Options != nil {
+		opts = cfg.Options.clone()
+	}
+	opts.ShouldUseReplica = true
+	opts.Auth = true
+	if cfg.Version != "" {
+		opts.MongoVersion = cfg.Version
+
+		// Fail before downloading anything
+		caps, err := featureCapabilities(cfg.Version, true)
+		if err != nil {
+			return nil, err
+		}
+		if !caps.Transactions {
+			return nil, fmt.Errorf("StartAppStack needs transactions, which need MongoDB 4.0 or later, not %s", cfg.Version)
+		}
+	}
+
+	server, err := startServer(opts)
+	if err != nil {
+		return nil, &ServerError{Op: "start", Err: err}
+	}
+
+	stack, err := setUpAppStack(ctx, server, cfg)
+	if err != nil {
+		_ = stopServer(server)
+		return nil, opts.redactError(err)
+	}
+
+	return stack, nil
+}
+
+// setUpAppStack creates the users on a freshly started server and checks
+// transactions
+func setUpAppStack(ctx context.Context, server *Server, cfg AppStackConfig) (*AppStack, error) {
+	caps, err := server.Capabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !caps.Transactions {
+		return nil, fmt.Errorf("StartAppStack needs transactions, which need MongoDB 4.0 or later, not %s", caps.Version)
+	}
+
+	// The localhost exception allows creating the first user without
+	// authenticating
+	client, err := server.connect()
+	if err != nil {
+		return nil, err
+	}
+	err = createUser(ctx, client.Database("admin"), cfg.RootUser, cfg.RootPassword, bson.D{{Key: "role", Value: "root"}, {Key: "db", Value: "admin"}})
+	_ = client.Disconnect(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	server.setCredential(cfg.RootUser, cfg.RootPassword)
+
+	stack := &AppStack{
+		Server:   server,
+		AdminURI: server.credentialedURI(cfg.RootUser, cfg.RootPassword, "", "admin"),
+		AppURI:   server.credentialedURI(cfg.AppUser, cfg.AppPassword, cfg.AppDBName, cfg.AppDBName),
+	}
+
+	admin, err := mongo.Connect(options.Client().ApplyURI(stack.AdminURI))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	err = createUser(ctx, admin.Database(cfg.AppDBName), cfg.AppUser, cfg.AppPassword, bson.D{{Key: "role", Value: "readWrite"}, {Key: "db", Value: cfg.AppDBName}})
+	_ = admin.Disconnect(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	stack.Client, err = mongo.Connect(options.Client().ApplyURI(stack.AppURI))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	err = checkTransactions(ctx, stack.Client.Database(cfg.AppDBName))
+	if err != nil {
+		_ = stack.Client.Disconnect(context.Background())
+		return nil, err
+	}
+	server.logger.Debugf("Started app stack with database %s and user %s", cfg.AppDBName, cfg.AppUser)
+
+	return stack, nil
+}
+
+// Stop disconnects Client and stops the server. It may be called more than
+// once.
+func (a *AppStack) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Disconnecting an already disconnected client fails harmlessly
+	_ = a.Client.Disconnect(ctx)
+	a.Server.Stop()
+}
+
+func (cfg *AppStackConfig) fillDefaults() error {
+	switch cfg.AppDBName {
+	case "":
+		cfg.AppDBName = "app"
+	case "admin", "local", "config":
+		return fmt.Errorf("invalid AppDBName %q: it's reserved by MongoDB", cfg.AppDBName)
+	}
+
+	if cfg.AppUser == "" {
+		cfg.AppUser = "app"
+	}
+	if cfg.AppPassword == "" {
+		cfg.AppPassword = RandomDatabase()
+	}
+	if cfg.RootUser == "" {
+		cfg.RootUser = "root"
+	}
+	if cfg.RootPassword == "" {
+		cfg.RootPassword = RandomDatabase()
+	}
+	if cfg.AppUser == cfg.RootUser {
+		return fmt.Errorf("AppUser and RootUser must differ, both are %q", cfg.AppUser)
+	}
+
+	return nil
+}
+
+// credentialedURI returns a URI that authenticates as user against
+// authSource, with db as the default database if it's given. It names the
+// replica set, like URIWithReadPreference.
+func (s *Server) credentialedURI(user string, password string, db string, authSource string) string {
+	uri := mongoURI(s.addr()).credentials(user, password).db(db).param("authSource", authSource)
+	if s.isReplicaSet {
+		uri.param("replicaSet", s.replicaSetName)
+	}
+
+	return uri.String()
+}
+
+func createUser(ctx context.Context, db *mongo.Database, user string, password string, role bson.D) error {
+	err := db.RunCommand(ctx, bson.D{
+		{Key: "createUser", Value: user},
+		{Key: "pwd", Value: password},
+		{Key: "roles", Value: bson.A{role}},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("error creating user %s in %s: %w", user, db.Name(), err)
+	}
+
+	return nil
+}
+
+// checkTransactions commits a transaction in db, to make sure they work with
+// the user and URI the application will use. Collections can't be created in
+// a transaction before MongoDB 4.4, so the collection is created first.
+func checkTransactions(ctx context.Context, db *mongo.Database) error {
+	err := db.CreateCollection(ctx, appStackCheckCollection)
+	if err != nil {
+		return fmt.Errorf("error checking transactions: %w", err)
+	}
+	coll := db.Collection(appStackCheckCollection)
+	defer func() {
+		_ = coll.Drop(context.Background())
+	}()
+
+	sess, err := db.Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("error starting session: %w", err)
+	}
+	defer sess.EndSession(context.Background())
+
+	_, err = sess.WithTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		return coll.InsertOne(ctx, bson.D{{Key: "committed", Value: true}})
+	})
+	if err != nil {
+		return fmt.Errorf("error checking transactions: %w", err)
+	}
+
+	n, err := coll.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return fmt.Errorf("error checking transactions: %w", err)
+	}
+	if n != 1 {
+		return fmt.Errorf("error checking transactions: the committed document wasn't found")
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package memongo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// StartForBenchmark starts a server for use in a benchmark. Startup time is
+// excluded from the benchmark's timings, the server is stopped when the
+// benchmark finishes, and unless opts sets a LogLevel only warnings are
+// logged so output doesn't scale with b.N. opts may be nil.
+//
+// The benchmark is failed if the server can't be started.
+func StartForBenchmark(b *testing.B, opts *Options) *Server {
+	b.Helper()
+
+	benchOpts := benchmarkOptions(opts)
+	server, err := StartWithOptions(&benchOpts)
+	if err != nil {
+		b.Fatalf("error starting MongoDB: %s", err)
+	}
+
+	b.Cleanup(func() {
+		b.StopTimer()
+		server.Stop()
+	})
+
+	b.ResetTimer()
+
+	return server
+}
+
+// benchmarkOptions returns a copy of opts, which may be nil, that only logs
+// warnings unless it sets a LogLevel
+func benchmarkOptions(opts *Options) Options {
+	benchOpts := Options{}
+	if opts != nil {
+		benchOpts = *opts
+	}
+	if benchOpts.LogLevel == 0 {
+		benchOpts.LogLevel = memongolog.LogLevelWarn
+	}
+
+	return benchOpts
+}
+
+// ResetTimerAfterWarmup runs warmup against the server (to build indexes,
+// prime caches, etc.) with the benchmark timer stopped, then resets the
+// timer so only the work after warm-up is measured.
+//
+// The benchmark is failed if warmup returns an error.
+func (s *Server) ResetTimerAfterWarmup(b *testing.B, warmup func(*mongo.Client) error) {
+	b.Helper()
+	b.StopTimer()
+
+	ctx := context.Background()
+	client, err := s.connect()
+	if err != nil {
+		b.Fatalf("error connecting to MongoDB: %s", err)
+	}
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+
+	err = warmup(client)
+	if err != nil {
+		b.Fatalf("error warming up MongoDB: %s", err)
+	}
+
+	b.ResetTimer()
+	b.StartTimer()
+}
@@ -0,0 +1,122 @@
+package memongo_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/100mslive/memongo/v2"
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func BenchmarkFindByIndexedField(b *testing.B) {
+	server := memongo.StartForBenchmark(b, &memongo.Options{MongoVersion: "8.0.0"})
+
+	ctx := context.Background()
+	client, err := mongo.Connect(options.Client().ApplyURI(server.URI()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Disconnect(ctx)
+
+	dbName := memongo.RandomDatabase()
+	coll := client.Database(dbName).Collection("users")
+
+	// Build the index and load the data outside of the timed section
+	server.ResetTimerAfterWarmup(b, func(client *mongo.Client) error {
+		coll := client.Database(dbName).Collection("users")
+
+		_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "email", Value: 1}}})
+		if err != nil {
+			return err
+		}
+
+		docs := make([]interface{}, 1000)
+		for i := range docs {
+			docs[i] = bson.D{{Key: "email", Value: i}}
+		}
+		_, err = coll.InsertMany(ctx, docs)
+		return err
+	})
+
+	for i := 0; i < b.N; i++ {
+		err := coll.FindOne(ctx, bson.D{{Key: "email", Value: i % 1000}}).Err()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSeed(b *testing.B) {
+	server := memongo.StartForBenchmark(b, &memongo.Options{MongoVersion: "8.0.0"})
+
+	docs := make([]interface{}, 20000)
+	for i := range docs {
+		docs[i] = bson.D{{Key: "n", Value: i}}
+	}
+
+	modes := map[string]*memongo.SeedBulkOptions{
+		"default": nil,
+		"bulk":    {Unordered: true, Workers: 4},
+	}
+	for name, bulk := range modes {
+		b.Run(name, func(b *testing.B) {
+			ctx := context.Background()
+			for i := 0; i < b.N; i++ {
+				seed := []memongo.SeedCollection{{Database: memongo.RandomDatabase(), Collection: "docs", Documents: docs}}
+
+				var err error
+				if bulk == nil {
+					err = server.Seed(ctx, seed)
+				} else {
+					err = server.SeedBulk(ctx, seed, *bulk)
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkStartDiskless compares starting and stopping a replica set with
+// its data directory on disk and with Diskless
+func BenchmarkStartDiskless(b *testing.B) {
+	for _, diskless := range []bool{false, true} {
+		name := "disk"
+		if diskless {
+			name = "diskless"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				server, err := memongo.StartWithOptions(&memongo.Options{
+					MongoVersion:     "8.0.0",
+					ShouldUseReplica: true,
+					Diskless:         diskless,
+					LogLevel:         memongolog.LogLevelWarn,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				server.Stop()
+			}
+		})
+	}
+}
+
+func TestBenchmarkOptions(t *testing.T) {
+	// StartForBenchmark takes nil options, unlike StartWithOptions
+	assert.Equal(t, memongo.Options{LogLevel: memongolog.LogLevelWarn}, memongo.BenchmarkOptions(nil))
+
+	opts := &memongo.Options{MongoVersion: "8.0.0", LogLevel: memongolog.LogLevelDebug}
+	benchOpts := memongo.BenchmarkOptions(opts)
+	assert.Equal(t, *opts, benchOpts)
+
+	// The caller's options aren't modified
+	opts.LogLevel = 0
+	assert.Equal(t, memongolog.LogLevel(memongolog.LogLevelWarn), memongo.BenchmarkOptions(opts).LogLevel)
+	assert.Equal(t, memongolog.LogLevel(0), opts.LogLevel)
+}
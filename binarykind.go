@@ -0,0 +1,96 @@
+package memongo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+)
+
+// otherBinaries match the --version output of MongoDB programs that aren't
+// mongod, capturing the name to report
+var otherBinaries = []*regexp.Regexp{
+	regexp.MustCompile(`(mongos) version (v\d+\.\d+\.\d+)`),
+	regexp.MustCompile(`MongoDB (shell) version (v\d+\.\d+\.\d+)`),
+	// The database tools, e.g. "mongodump version: 100.9.4"
+	regexp.MustCompile(`(?m)^(mongo\w+) version: (\S+)`),
+}
+
+// identifyBinary returns the program and version that --version output
+// belongs to if it's a MongoDB program other than mongod, e.g.
+// "mongos v7.0.2", or ""
+func identifyBinary(out []byte) string {
+	for _, re := range otherBinaries {
+		match := re.FindSubmatch(out)
+		if match == nil {
+			continue
+		}
+		name := string(match[1])
+		if name == "shell" {
+			name = "the mongo shell"
+		}
+		return name + " " + string(match[2])
+	}
+
+	return ""
+}
+
+// checkExecutable returns an error wrapping ErrNotExecutable if binPath
+// isn't a file that can be run
+func checkExecutable(binPath string) error {
+	info, err := os.Stat(binPath)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return fmt.Errorf("%w: %s is a directory", ErrNotExecutable, binPath)
+	}
+	// Windows has no execute bit
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("%w: %s has mode %s; chmod +x it", ErrNotExecutable, binPath, info.Mode().Perm())
+	}
+
+	return nil
+}
+
+// isScript returns whether the file at path starts with a #! line
+func isScript(path string) bool {
+	//nolint:gosec
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	prefix := make([]byte, 2)
+	_, err = io.ReadFull(f, prefix)
+	return err == nil && bytes.Equal(prefix, []byte("#!"))
+}
+
+// checkMongodBinary checks that a user-supplied binary is mongod, given the
+// result of running it with --version, so a wrong one fails at once rather
+// than as a startup timeout. Binaries that don't report a version are
+// allowed, but scripts aren't: a wrapper script must pass --version through
+// to mongod.
+func checkMongodBinary(binPath string, versionErr error) error {
+	// This explains a failure to run the binary better than versionErr
+	err := checkExecutable(binPath)
+	if err != nil {
+		return err
+	}
+
+	if versionErr == nil || errors.Is(versionErr, ErrWrongBinaryKind) {
+		return versionErr
+	}
+
+	if isScript(binPath) {
+		return fmt.Errorf("%w: %s doesn't report a mongod version (%s); point MongodBin at mongod itself, or have the script exec it",
+			ErrScriptBinary, binPath, versionErr)
+	}
+
+	return nil
+}
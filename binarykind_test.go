@@ -0,0 +1,117 @@
+package memongo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckMongodBinary(t *testing.T) {
+	logger := memongolog.New(nil, memongolog.LogLevelSilent)
+
+	tests := map[string]struct {
+		contents     string
+		mode         os.FileMode
+		mongoVersion string
+
+		expectedVersion string
+		expectedErr     error
+		expectedMessage string
+	}{
+		"mongod": {
+			contents:        "#!/bin/sh\necho 'db version v7.0.2'\n",
+			mode:            0700,
+			expectedVersion: "7.0.2",
+		},
+		"mongod with MongoVersion": {
+			contents:        "#!/bin/sh\necho 'db version v7.0.2'\n",
+			mode:            0700,
+			mongoVersion:    "8.0.0",
+			expectedVersion: "8.0.0",
+		},
+		"mongos": {
+			contents:        "#!/bin/sh\necho 'mongos version v7.0.2'\necho 'Build Info: {}'\n",
+			mode:            0700,
+			expectedErr:     ErrWrongBinaryKind,
+			expectedMessage: "is mongos v7.0.2",
+		},
+		"mongos with MongoVersion": {
+			contents:        "#!/bin/sh\necho 'mongos version v7.0.2'\n",
+			mode:            0700,
+			mongoVersion:    "7.0.2",
+			expectedErr:     ErrWrongBinaryKind,
+			expectedMessage: "is mongos v7.0.2",
+		},
+		"legacy shell": {
+			contents:        "#!/bin/sh\necho 'MongoDB shell version v4.4.0'\n",
+			mode:            0700,
+			expectedErr:     ErrWrongBinaryKind,
+			expectedMessage: "is the mongo shell v4.4.0",
+		},
+		"database tool": {
+			contents:        "#!/bin/sh\necho 'mongodump version: 100.9.4'\necho 'git version: abc'\n",
+			mode:            0700,
+			expectedErr:     ErrWrongBinaryKind,
+			expectedMessage: "is mongodump 100.9.4",
+		},
+		"script that doesn't exec mongod": {
+			contents:        "#!/bin/sh\nexit 0\n",
+			mode:            0700,
+			expectedErr:     ErrScriptBinary,
+			expectedMessage: "have the script exec it",
+		},
+		"failing script": {
+			contents:        "#!/bin/sh\nexit 1\n",
+			mode:            0700,
+			mongoVersion:    "8.0.0",
+			expectedErr:     ErrScriptBinary,
+			expectedMessage: "exit status 1",
+		},
+		"not executable": {
+			contents:        "#!/bin/sh\necho 'db version v7.0.2'\n",
+			mode:            0600,
+			expectedErr:     ErrNotExecutable,
+			expectedMessage: "has mode -rw-------",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			binPath := filepath.Join(t.TempDir(), "mongod")
+			require.NoError(t, os.WriteFile(binPath, []byte(test.contents), test.mode))
+
+			_, version, err := (&Options{MongodBin: binPath, MongoVersion: test.mongoVersion}).capabilities(binPath, logger)
+			if test.expectedErr == nil {
+				require.NoError(t, err)
+				assert.Equal(t, test.expectedVersion, version)
+				return
+			}
+			assert.ErrorIs(t, err, test.expectedErr)
+			assert.Contains(t, err.Error(), test.expectedMessage)
+		})
+	}
+}
+
+func TestCheckMongodBinaryDirectory(t *testing.T) {
+	dir := t.TempDir()
+	_, _, err := (&Options{MongodBin: dir}).capabilities(dir, memongolog.New(nil, memongolog.LogLevelSilent))
+	assert.ErrorIs(t, err, ErrNotExecutable)
+	assert.Contains(t, err.Error(), "is a directory")
+}
+
+func TestWrongBinaryKindWhenDownloaded(t *testing.T) {
+	// A download that holds mongos fails too, rather than assuming a version
+	binPath := writeScript(t, `echo "mongos version v7.0.2"`)
+	_, _, err := (&Options{DownloadURL: "https://example.com/mongodb.tgz"}).capabilities(binPath, memongolog.New(nil, memongolog.LogLevelSilent))
+	assert.ErrorIs(t, err, ErrWrongBinaryKind)
+}
+
+func TestIdentifyBinary(t *testing.T) {
+	assert.Equal(t, "", identifyBinary([]byte("db version v8.0.0\n")))
+	assert.Equal(t, "", identifyBinary([]byte("2.3.0\n")))
+	assert.Equal(t, "mongos v8.0.0", identifyBinary([]byte("mongos version v8.0.0\nBuild Info: {}\n")))
+}
@@ -0,0 +1,223 @@
+package memongo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slowestStartsKept is how many of the slowest starts TimeSpent names
+const slowestStartsKept = 5
+
+// Phases of starting a server that TimeSpent accounts for
+const (
+	// PhaseQueued is time waiting under SetMaxConcurrentStarts or
+	// Options.StartConcurrency
+	PhaseQueued = "queued"
+
+	// PhaseStarting is the rest of a successful start up to the replica set
+	// being initiated, including any download and retries
+	PhaseStarting = "starting"
+
+	// PhasePortWait is time connecting to mongod's port after it reported
+	// listening
+	PhasePortWait = "port wait"
+
+	// PhaseSeeding is time inserting Options.Seed, SeedDir and SeedGenerated
+	PhaseSeeding = "seeding"
+
+	// PhaseFailed is time spent in starts that failed
+	PhaseFailed = "failed"
+)
+
+// PhaseTime is how many starts went through a phase, and how long they spent
+// in it altogether
+type PhaseTime struct {
+	Count    int
+	Duration time.Duration
+}
+
+// SlowStart is one of the slowest starts
+type SlowStart struct {
+	// Description is the start's StartReport.Summary, or its error if it
+	// failed
+	Description string
+
+	Duration time.Duration
+}
+
+// TimeSpent is how long StartWithOptions has taken in this process, added up
+// over every call, including concurrent ones
+type TimeSpent struct {
+	Total time.Duration
+
+	// Starts counts every call, and Downloads and CacheHits the ones that
+	// downloaded mongod or found it in the cache
+	Starts    int
+	Downloads int
+	CacheHits int
+
+	// Phases breaks the time down by phase, such as PhaseQueued
+	Phases map[string]PhaseTime
+
+	// Slowest are the slowest starts, slowest first
+	Slowest []SlowStart
+}
+
+// String summarizes where the time went, e.g. "12 starts took 1m4s (1
+// downloads, 11 cache hits): starting 11 × 52s, queued 3 × 9s, ...; slowest:
+// ..."
+func (t TimeSpent) String() string {
+	phases := make([]string, 0, len(t.Phases))
+	for phase := range t.Phases {
+		phases = append(phases, phase)
+	}
+	sort.Slice(phases, func(i, j int) bool {
+		di, dj := t.Phases[phases[i]].Duration, t.Phases[phases[j]].Duration
+		if di != dj {
+			return di > dj
+		}
+		return phases[i] < phases[j]
+	})
+
+	parts := make([]string, len(phases))
+	for i, phase := range phases {
+		parts[i] = fmt.Sprintf("%s %d × %s", phase, t.Phases[phase].Count, t.Phases[phase].Duration.Round(time.Millisecond))
+	}
+	summary := fmt.Sprintf("%d starts took %s (%d downloads, %d cache hits)", t.Starts, t.Total.Round(time.Millisecond), t.Downloads, t.CacheHits)
+	if len(parts) > 0 {
+		summary += ": " + strings.Join(parts, ", ")
+	}
+
+	if len(t.Slowest) > 0 {
+		slowest := make([]string, len(t.Slowest))
+		for i, start := range t.Slowest {
+			slowest[i] = fmt.Sprintf("%s in %s", start.Description, start.Duration.Round(time.Millisecond))
+		}
+		summary += "; slowest: " + strings.Join(slowest, "; ")
+	}
+
+	return summary
+}
+
+// timeBudget is the time spent starting servers in this process, and the
+// limit on it
+var timeBudget = struct {
+	sync.Mutex
+	limit time.Duration
+	spent TimeSpent
+}{spent: TimeSpent{Phases: map[string]PhaseTime{}}}
+
+// SetGlobalTimeBudget limits how long StartWithOptions may take in this
+// process altogether: once GlobalTimeSpent's Total reaches d, starting
+// another server fails at once with an error wrapping ErrTimeBudgetExceeded,
+// which says where the time went. Starts already running finish. d <= 0
+// removes the limit, which is the default.
+func SetGlobalTimeBudget(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	timeBudget.Lock()
+	defer timeBudget.Unlock()
+
+	timeBudget.limit = d
+}
+
+// GlobalTimeSpent returns how long StartWithOptions has taken in this
+// process since it started or ResetGlobalTimeSpent was called, with or
+// without a budget
+func GlobalTimeSpent() TimeSpent {
+	timeBudget.Lock()
+	defer timeBudget.Unlock()
+
+	spent := timeBudget.spent
+	spent.Phases = make(map[string]PhaseTime, len(timeBudget.spent.Phases))
+	for phase, t := range timeBudget.spent.Phases {
+		spent.Phases[phase] = t
+	}
+	spent.Slowest = append([]SlowStart(nil), timeBudget.spent.Slowest...)
+
+	return spent
+}
+
+// ResetGlobalTimeSpent forgets the time spent so far, so the budget starts
+// over
+func ResetGlobalTimeSpent() {
+	timeBudget.Lock()
+	defer timeBudget.Unlock()
+
+	timeBudget.spent = TimeSpent{Phases: map[string]PhaseTime{}}
+}
+
+// checkTimeBudget returns an error if the time budget has been used up
+func checkTimeBudget() error {
+	timeBudget.Lock()
+	defer timeBudget.Unlock()
+
+	if timeBudget.limit == 0 || timeBudget.spent.Total < timeBudget.limit {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s of %s used: %s", ErrTimeBudgetExceeded, timeBudget.spent.Total.Round(time.Millisecond), timeBudget.limit, timeBudget.spent)
+}
+
+// recordStart adds a successful start to the time spent
+func recordStart(report StartReport, total time.Duration) {
+	phases := map[string]time.Duration{
+		PhaseQueued:   report.QueueTime,
+		PhasePortWait: report.PortWait,
+		PhaseSeeding:  report.SeedDuration,
+		PhaseStarting: report.Duration - report.QueueTime - report.PortWait,
+	}
+
+	timeBudget.Lock()
+	defer timeBudget.Unlock()
+
+	spent := &timeBudget.spent
+	spent.Total += total
+	spent.Starts++
+	if report.Downloaded {
+		spent.Downloads++
+	}
+	if report.CacheHit {
+		spent.CacheHits++
+	}
+	for phase, d := range phases {
+		if d > 0 {
+			addPhaseLocked(phase, d)
+		}
+	}
+	addSlowStartLocked(SlowStart{Description: report.Summary(), Duration: total})
+}
+
+// recordFailedStart adds a start that failed with err to the time spent
+func recordFailedStart(err error, total time.Duration) {
+	timeBudget.Lock()
+	defer timeBudget.Unlock()
+
+	timeBudget.spent.Total += total
+	timeBudget.spent.Starts++
+	addPhaseLocked(PhaseFailed, total)
+	addSlowStartLocked(SlowStart{Description: "failed: " + err.Error(), Duration: total})
+}
+
+func addPhaseLocked(phase string, d time.Duration) {
+	t := timeBudget.spent.Phases[phase]
+	t.Count++
+	t.Duration += d
+	timeBudget.spent.Phases[phase] = t
+}
+
+func addSlowStartLocked(start SlowStart) {
+	slowest := append(timeBudget.spent.Slowest, start)
+	sort.SliceStable(slowest, func(i, j int) bool {
+		return slowest[i].Duration > slowest[j].Duration
+	})
+	if len(slowest) > slowestStartsKept {
+		slowest = slowest[:slowestStartsKept]
+	}
+	timeBudget.spent.Slowest = slowest
+}
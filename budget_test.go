@@ -0,0 +1,150 @@
+package memongo
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withTimeBudget runs the test with a fresh time budget of d, restoring the
+// previous one after
+func withTimeBudget(t *testing.T, d time.Duration) {
+	timeBudget.Lock()
+	limit, spent := timeBudget.limit, timeBudget.spent
+	timeBudget.Unlock()
+	t.Cleanup(func() {
+		timeBudget.Lock()
+		timeBudget.limit, timeBudget.spent = limit, spent
+		timeBudget.Unlock()
+	})
+
+	ResetGlobalTimeSpent()
+	SetGlobalTimeBudget(d)
+}
+
+func TestGlobalTimeSpent(t *testing.T) {
+	withTimeBudget(t, 0)
+
+	recordStart(StartReport{
+		Version:   "8.0.0",
+		URI:       "mongodb://127.0.0.1:1234",
+		CacheHit:  true,
+		Duration:  3 * time.Second,
+		QueueTime: time.Second,
+		PortWait:  100 * time.Millisecond,
+	}, 4*time.Second)
+	recordStart(StartReport{Downloaded: true, Duration: 10 * time.Second, SeedDuration: 2 * time.Second}, 12*time.Second)
+	recordFailedStart(errors.New("mongod exited"), time.Second)
+
+	spent := GlobalTimeSpent()
+	assert.Equal(t, 17*time.Second, spent.Total)
+	assert.Equal(t, 3, spent.Starts)
+	assert.Equal(t, 1, spent.Downloads)
+	assert.Equal(t, 1, spent.CacheHits)
+	assert.Equal(t, map[string]PhaseTime{
+		PhaseQueued:   {Count: 1, Duration: time.Second},
+		PhasePortWait: {Count: 1, Duration: 100 * time.Millisecond},
+		PhaseStarting: {Count: 2, Duration: 11900 * time.Millisecond},
+		PhaseSeeding:  {Count: 1, Duration: 2 * time.Second},
+		PhaseFailed:   {Count: 1, Duration: time.Second},
+	}, spent.Phases)
+	require.Len(t, spent.Slowest, 3)
+	assert.Equal(t, 12*time.Second, spent.Slowest[0].Duration)
+	assert.Equal(t, "failed: mongod exited", spent.Slowest[2].Description)
+
+	assert.Equal(t, "3 starts took 17s (1 downloads, 1 cache hits): starting 2 × 11.9s, seeding 1 × 2s, failed 1 × 1s, queued 1 × 1s, port wait 1 × 100ms; "+
+		"slowest: mongod ready at  (downloaded, 10s) in 12s; mongod 8.0.0 ready at mongodb://127.0.0.1:1234 (cache hit, queued 1s, 3s) in 4s; failed: mongod exited in 1s", spent.String())
+
+	// The result is a copy
+	spent.Phases[PhaseQueued] = PhaseTime{}
+	assert.Equal(t, time.Second, GlobalTimeSpent().Phases[PhaseQueued].Duration)
+
+	ResetGlobalTimeSpent()
+	assert.Equal(t, "0 starts took 0s (0 downloads, 0 cache hits)", GlobalTimeSpent().String())
+}
+
+func TestGlobalTimeSpentSlowest(t *testing.T) {
+	withTimeBudget(t, 0)
+
+	for i := 1; i <= 2*slowestStartsKept; i++ {
+		recordStart(StartReport{}, time.Duration(i)*time.Second)
+	}
+
+	slowest := GlobalTimeSpent().Slowest
+	require.Len(t, slowest, slowestStartsKept)
+	assert.Equal(t, time.Duration(2*slowestStartsKept)*time.Second, slowest[0].Duration)
+	assert.Equal(t, time.Duration(slowestStartsKept+1)*time.Second, slowest[slowestStartsKept-1].Duration)
+}
+
+func TestGlobalTimeBudget(t *testing.T) {
+	withTimeBudget(t, 10*time.Second)
+
+	recordStart(StartReport{}, 9*time.Second)
+	require.NoError(t, checkTimeBudget())
+
+	recordFailedStart(errors.New("mongod exited"), time.Second)
+	err := checkTimeBudget()
+	require.True(t, errors.Is(err, ErrTimeBudgetExceeded))
+	assert.Contains(t, err.Error(), "10s of 10s used")
+	assert.Contains(t, err.Error(), "failed: mongod exited in 1s")
+
+	// Starting fails at once, without counting
+	_, err = StartWithOptions(&Options{MongoVersion: "8.0.0"})
+	require.True(t, errors.Is(err, ErrTimeBudgetExceeded))
+	assert.Equal(t, 2, GlobalTimeSpent().Starts)
+
+	// Raising the budget, removing it or resetting the time spent lets
+	// servers start again
+	SetGlobalTimeBudget(time.Minute)
+	assert.NoError(t, checkTimeBudget())
+	SetGlobalTimeBudget(10 * time.Second)
+	require.Error(t, checkTimeBudget())
+	SetGlobalTimeBudget(-1)
+	assert.NoError(t, checkTimeBudget())
+	SetGlobalTimeBudget(10 * time.Second)
+	ResetGlobalTimeSpent()
+	assert.NoError(t, checkTimeBudget())
+}
+
+func TestGlobalTimeSpentConcurrent(t *testing.T) {
+	withTimeBudget(t, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				recordStart(StartReport{Duration: time.Second}, time.Second)
+			} else {
+				recordFailedStart(errors.New("failed"), time.Second)
+			}
+			_ = checkTimeBudget()
+			_ = GlobalTimeSpent()
+		}(i)
+	}
+	wg.Wait()
+
+	spent := GlobalTimeSpent()
+	assert.Equal(t, 20, spent.Starts)
+	assert.Equal(t, 20*time.Second, spent.Total)
+	assert.Equal(t, 10, spent.Phases[PhaseFailed].Count)
+}
+
+func TestStartRecordsTimeSpent(t *testing.T) {
+	withTimeBudget(t, 0)
+
+	_, err := StartWithOptions(&Options{MongoVersion: "8.0.0", Offline: true, CachePath: t.TempDir(), LogLevel: memongolog.LogLevelSilent})
+	require.Error(t, err)
+
+	spent := GlobalTimeSpent()
+	assert.Equal(t, 1, spent.Starts)
+	assert.Equal(t, 1, spent.Phases[PhaseFailed].Count)
+	require.Len(t, spent.Slowest, 1)
+	assert.Contains(t, spent.Slowest[0].Description, "failed: ")
+}
@@ -0,0 +1,110 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// BuildInfo describes how the running mongod binary was built, as reported by
+// the buildInfo command. It's useful when reproducing upstream MongoDB bugs,
+// which often depend on the exact build.
+type BuildInfo struct {
+	// Version is the MongoDB version, e.g. "8.0.0"
+	Version string `bson:"version"`
+
+	// VersionArray is the version as numbers: major, minor, patch and a
+	// release candidate number
+	VersionArray []int `bson:"versionArray"`
+
+	// GitVersion is the commit the binary was built from
+	GitVersion string `bson:"gitVersion"`
+
+	// Modules are the extra modules built in, e.g. "enterprise"
+	Modules []string `bson:"modules"`
+
+	// Allocator is the memory allocator, e.g. "tcmalloc" or "system"
+	Allocator string `bson:"allocator"`
+
+	// Debug is true for assertion-enabled debug builds
+	Debug bool `bson:"debug"`
+
+	// MaxBSONObjectSize is the largest document the server accepts, in bytes
+	MaxBSONObjectSize int `bson:"maxBsonObjectSize"`
+}
+
+// IsEnterprise returns true if the binary includes the enterprise modules
+func (b BuildInfo) IsEnterprise() bool {
+	for _, module := range b.Modules {
+		if module == "enterprise" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BuildInfo returns how the server's mongod binary was built. It's looked up
+// on the first call and cached. buildInfo doesn't need authentication, so
+// this works with Options.Auth before any user is created.
+func (s *Server) BuildInfo(ctx context.Context) (BuildInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.cachedBuildInfo(ctx)
+}
+
+// MaxBSONObjectSize returns the largest document the server accepts, in
+// bytes
+func (s *Server) MaxBSONObjectSize(ctx context.Context) (int, error) {
+	info, err := s.BuildInfo(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.MaxBSONObjectSize, nil
+}
+
+// cachedBuildInfo returns the server's build info, looking it up if it
+// hasn't been yet. s.mu must be held.
+func (s *Server) cachedBuildInfo(ctx context.Context) (BuildInfo, error) {
+	if s.buildInfo != nil {
+		return *s.buildInfo, nil
+	}
+
+	client, err := s.connect()
+	if err != nil {
+		return BuildInfo{}, err
+	}
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+
+	raw, err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Raw()
+	if err != nil {
+		return BuildInfo{}, fmt.Errorf("error running buildInfo: %w", err)
+	}
+
+	info, err := decodeBuildInfo(raw)
+	if err != nil {
+		return BuildInfo{}, err
+	}
+
+	s.buildInfo = &info
+	return info, nil
+}
+
+func decodeBuildInfo(raw bson.Raw) (BuildInfo, error) {
+	var info BuildInfo
+	err := bson.Unmarshal(raw, &info)
+	if err != nil {
+		return BuildInfo{}, fmt.Errorf("error decoding buildInfo: %w", err)
+	}
+
+	if len(info.VersionArray) < 3 {
+		return BuildInfo{}, fmt.Errorf("buildInfo returned an invalid versionArray %v", info.VersionArray)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,41 @@
+package memongo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestDecodeBuildInfo(t *testing.T) {
+	raw, err := bson.Marshal(bson.D{
+		{Key: "version", Value: "8.0.0"},
+		{Key: "gitVersion", Value: "d7cd03b239ac39a3c7d63f7145e91aca36f93db6"},
+		{Key: "modules", Value: bson.A{"enterprise"}},
+		{Key: "allocator", Value: "tcmalloc-google"},
+		{Key: "versionArray", Value: bson.A{int32(8), int32(0), int32(0), int32(0)}},
+		{Key: "debug", Value: false},
+		{Key: "maxBsonObjectSize", Value: int32(16777216)},
+		{Key: "ok", Value: 1.0},
+	})
+	require.NoError(t, err)
+
+	info, err := decodeBuildInfo(raw)
+	require.NoError(t, err)
+	assert.Equal(t, BuildInfo{
+		Version:           "8.0.0",
+		VersionArray:      []int{8, 0, 0, 0},
+		GitVersion:        "d7cd03b239ac39a3c7d63f7145e91aca36f93db6",
+		Modules:           []string{"enterprise"},
+		Allocator:         "tcmalloc-google",
+		Debug:             false,
+		MaxBSONObjectSize: 16777216,
+	}, info)
+	assert.True(t, info.IsEnterprise())
+
+	raw, err = bson.Marshal(bson.D{{Key: "version", Value: "8.0.0"}, {Key: "versionArray", Value: bson.A{int32(8)}}})
+	require.NoError(t, err)
+	_, err = decodeBuildInfo(raw)
+	assert.EqualError(t, err, "buildInfo returned an invalid versionArray [8]")
+}
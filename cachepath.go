@@ -0,0 +1,86 @@
+package memongo
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// cachePathEnv is how the default cache path is found, so tests can fake the
+// operating system
+type cachePathEnv struct {
+	goos   string
+	getenv func(key string) string
+
+	// userCacheDir is os.UserCacheDir
+	userCacheDir func() (string, error)
+
+	// populated reports whether dir holds anything, i.e. earlier downloads
+	populated func(dir string) bool
+}
+
+var defaultCachePathEnv = cachePathEnv{
+	goos:         runtime.GOOS,
+	getenv:       os.Getenv,
+	userCacheDir: os.UserCacheDir,
+	populated: func(dir string) bool {
+		entries, err := os.ReadDir(dir)
+		return err == nil && len(entries) > 0
+	},
+}
+
+// resolveCachePath defaults DownloadOptions.CachePath from the environment,
+// or to the user's cache directory
+func (opts *Options) resolveCachePath() {
+	d := opts.downloadOptions()
+	if d.CachePath == "" {
+		d.CachePath = defaultCachePath(defaultCachePathEnv)
+	}
+	opts.setDownloadOptions(d)
+}
+
+// defaultCachePath returns MEMONGO_CACHE_PATH, $XDG_CACHE_HOME/memongo, or
+// memongo in the user's cache directory: ~/.cache on Linux,
+// ~/Library/Caches on macOS, and %LocalAppData% on Windows. A cache at the
+// location earlier releases used, which differs when HOME is set on Windows
+// or unset elsewhere, is kept using as long as it has anything in it.
+func defaultCachePath(env cachePathEnv) string {
+	if p := env.getenv("MEMONGO_CACHE_PATH"); p != "" {
+		return p
+	}
+	if xdg := env.getenv("XDG_CACHE_HOME"); xdg != "" {
+		return filepath.Join(xdg, "memongo")
+	}
+
+	legacy := legacyCachePath(env)
+	cacheDir, err := env.userCacheDir()
+	if err != nil {
+		// Neither HOME nor, on Windows, LocalAppData is set
+		if legacy != "" && env.populated(legacy) {
+			return legacy
+		}
+		return filepath.Join(os.TempDir(), "memongo")
+	}
+
+	current := filepath.Join(cacheDir, "memongo")
+	if legacy != "" && legacy != current && !env.populated(current) && env.populated(legacy) {
+		return legacy
+	}
+
+	return current
+}
+
+// legacyCachePath returns the default cache path of earlier releases, which
+// joined HOME with the Linux or macOS cache directory on every platform, or
+// "" if HOME isn't set
+func legacyCachePath(env cachePathEnv) string {
+	home := env.getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	if env.goos == "darwin" {
+		return filepath.Join(home, "Library", "Caches", "memongo")
+	}
+
+	return filepath.Join(home, ".cache", "memongo")
+}
@@ -0,0 +1,120 @@
+package memongo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCachePathEnv fakes goos with the environment variables in vars, and
+// os.UserCacheDir's behaviour on goos. Only the directories in populated have
+// anything in them.
+func fakeCachePathEnv(goos string, vars map[string]string, populated ...string) cachePathEnv {
+	return cachePathEnv{
+		goos:   goos,
+		getenv: func(key string) string { return vars[key] },
+		userCacheDir: func() (string, error) {
+			switch goos {
+			case "windows":
+				if vars["LocalAppData"] == "" {
+					return "", errors.New("%LocalAppData% is not defined")
+				}
+				return vars["LocalAppData"], nil
+			case "darwin":
+				if vars["HOME"] == "" {
+					return "", errors.New("$HOME is not defined")
+				}
+				return filepath.Join(vars["HOME"], "Library", "Caches"), nil
+			default:
+				if vars["XDG_CACHE_HOME"] != "" {
+					return vars["XDG_CACHE_HOME"], nil
+				}
+				if vars["HOME"] == "" {
+					return "", errors.New("neither $XDG_CACHE_HOME nor $HOME are defined")
+				}
+				return filepath.Join(vars["HOME"], ".cache"), nil
+			}
+		},
+		populated: func(dir string) bool {
+			for _, p := range populated {
+				if p == dir {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+func TestDefaultCachePath(t *testing.T) {
+	localAppData := filepath.Join("C:", "Users", "dev", "AppData", "Local")
+	gitBashHome := filepath.Join("C:", "Users", "dev")
+
+	tests := map[string]struct {
+		env      cachePathEnv
+		expected string
+	}{
+		"MEMONGO_CACHE_PATH": {
+			env:      fakeCachePathEnv("linux", map[string]string{"MEMONGO_CACHE_PATH": "/ci/cache", "XDG_CACHE_HOME": "/xdg", "HOME": "/home/dev"}),
+			expected: "/ci/cache",
+		},
+		"XDG_CACHE_HOME": {
+			env:      fakeCachePathEnv("darwin", map[string]string{"XDG_CACHE_HOME": "/xdg", "HOME": "/Users/dev"}),
+			expected: filepath.Join("/xdg", "memongo"),
+		},
+		"linux": {
+			env:      fakeCachePathEnv("linux", map[string]string{"HOME": "/home/dev"}),
+			expected: filepath.Join("/home/dev", ".cache", "memongo"),
+		},
+		"darwin": {
+			env:      fakeCachePathEnv("darwin", map[string]string{"HOME": "/Users/dev"}),
+			expected: filepath.Join("/Users/dev", "Library", "Caches", "memongo"),
+		},
+		"windows": {
+			env:      fakeCachePathEnv("windows", map[string]string{"LocalAppData": localAppData}),
+			expected: filepath.Join(localAppData, "memongo"),
+		},
+		"windows with HOME": {
+			env:      fakeCachePathEnv("windows", map[string]string{"LocalAppData": localAppData, "HOME": gitBashHome}),
+			expected: filepath.Join(localAppData, "memongo"),
+		},
+		"windows with a populated legacy cache": {
+			env:      fakeCachePathEnv("windows", map[string]string{"LocalAppData": localAppData, "HOME": gitBashHome}, filepath.Join(gitBashHome, ".cache", "memongo")),
+			expected: filepath.Join(gitBashHome, ".cache", "memongo"),
+		},
+		"windows with both caches populated": {
+			env: fakeCachePathEnv("windows", map[string]string{"LocalAppData": localAppData, "HOME": gitBashHome},
+				filepath.Join(gitBashHome, ".cache", "memongo"), filepath.Join(localAppData, "memongo")),
+			expected: filepath.Join(localAppData, "memongo"),
+		},
+		"windows without LocalAppData": {
+			env:      fakeCachePathEnv("windows", map[string]string{}),
+			expected: filepath.Join(os.TempDir(), "memongo"),
+		},
+		"linux without HOME": {
+			env:      fakeCachePathEnv("linux", map[string]string{}),
+			expected: filepath.Join(os.TempDir(), "memongo"),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, defaultCachePath(test.env))
+		})
+	}
+}
+
+func TestResolveCachePathKeepsCachePath(t *testing.T) {
+	t.Setenv("MEMONGO_CACHE_PATH", "/from/env")
+
+	opts := &Options{CachePath: "/given"}
+	opts.resolveCachePath()
+	assert.Equal(t, "/given", opts.CachePath)
+
+	opts = &Options{}
+	opts.resolveCachePath()
+	assert.Equal(t, "/from/env", opts.CachePath)
+}
@@ -0,0 +1,135 @@
+package memongo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+)
+
+// minWiredTigerCacheSizeGB is the smallest cache mongod accepts
+const minWiredTigerCacheSizeGB = 0.25
+
+// maxWiredTigerCacheSizePct is the largest share of memory mongod accepts for
+// the cache
+const maxWiredTigerCacheSizePct = 80
+
+// systemMemoryBytes returns the total memory of the machine. It's a variable
+// so tests can fake it.
+var systemMemoryBytes = detectSystemMemory
+
+func validateWiredTigerCacheSize(opts *Options) error {
+	if opts.WiredTigerCacheSizeGB < 0 || (opts.WiredTigerCacheSizeGB > 0 && opts.WiredTigerCacheSizeGB < minWiredTigerCacheSizeGB) {
+		return fmt.Errorf("invalid WiredTigerCacheSizeGB %g: mongod needs at least %g", opts.WiredTigerCacheSizeGB, minWiredTigerCacheSizeGB)
+	}
+
+	if opts.WiredTigerCacheSizePct < 0 || opts.WiredTigerCacheSizePct > maxWiredTigerCacheSizePct {
+		return fmt.Errorf("invalid WiredTigerCacheSizePct %g: must be within 0-%d", opts.WiredTigerCacheSizePct, maxWiredTigerCacheSizePct)
+	}
+
+	if opts.WiredTigerCacheSizeGB > 0 && opts.WiredTigerCacheSizePct > 0 {
+		return fmt.Errorf("cannot use both WiredTigerCacheSizeGB and WiredTigerCacheSizePct")
+	}
+
+	return nil
+}
+
+// resolveWiredTigerCacheSize works out the cache size in GB for versions of
+// mongod that don't accept WiredTigerCacheSizePct, from the system's memory
+func (opts *Options) resolveWiredTigerCacheSize(caps versionCapabilities, logger *memongolog.Logger) error {
+	if opts.WiredTigerCacheSizePct == 0 || caps.cacheSizePct {
+		return nil
+	}
+
+	memory, err := systemMemoryBytes()
+	if err != nil {
+		return fmt.Errorf("error applying WiredTigerCacheSizePct: %w; set WiredTigerCacheSizeGB instead", err)
+	}
+
+	opts.resolvedCacheSizeGB = cacheSizeGBFromPct(opts.WiredTigerCacheSizePct, memory)
+	logger.Debugf("mongod doesn't support --wiredTigerCacheSizePct; using a cache of %sGB, %g%% of %d bytes of memory",
+		formatCacheSizeGB(opts.resolvedCacheSizeGB), opts.WiredTigerCacheSizePct, memory)
+
+	return nil
+}
+
+// cacheSizeGBFromPct returns pct percent of memory in GB, no smaller than the
+// minimum mongod accepts
+func cacheSizeGBFromPct(pct float64, memory uint64) float64 {
+	gb := float64(memory) * pct / 100 / (1 << 30)
+	if gb < minWiredTigerCacheSizeGB {
+		return minWiredTigerCacheSizeGB
+	}
+
+	// mongod rounds to the megabyte anyway
+	return float64(int64(gb*1024)) / 1024
+}
+
+// formatCacheSizeGB formats a size for --wiredTigerCacheSizeGB, never in
+// scientific notation and without rounding it
+func formatCacheSizeGB(gb float64) string {
+	return strconv.FormatFloat(gb, 'f', -1, 64)
+}
+
+// wiredTigerCacheArgs returns the mongod arguments setting the cache size
+func wiredTigerCacheArgs(opts *Options, caps versionCapabilities) []string {
+	switch {
+	case opts.WiredTigerCacheSizeGB > 0:
+		return []string{"--wiredTigerCacheSizeGB", formatCacheSizeGB(opts.WiredTigerCacheSizeGB)}
+	case opts.WiredTigerCacheSizePct > 0 && caps.cacheSizePct:
+		return []string{"--wiredTigerCacheSizePct", strconv.FormatFloat(opts.WiredTigerCacheSizePct, 'f', -1, 64)}
+	case opts.resolvedCacheSizeGB > 0:
+		return []string{"--wiredTigerCacheSizeGB", formatCacheSizeGB(opts.resolvedCacheSizeGB)}
+	default:
+		return nil
+	}
+}
+
+func detectSystemMemory() (uint64, error) {
+	switch runtime.GOOS {
+	case "linux":
+		return linuxMemTotal("/proc/meminfo")
+	case "darwin":
+		out, err := exec.Command("sysctl", "-n", "hw.memsize").Output()
+		if err != nil {
+			return 0, fmt.Errorf("error running sysctl: %w", err)
+		}
+		return strconv.ParseUint(strings.TrimSpace(string(out)), 10, 64)
+	default:
+		return 0, fmt.Errorf("can't detect the system memory on %s", runtime.GOOS)
+	}
+}
+
+// linuxMemTotal reads MemTotal from a /proc/meminfo file
+func linuxMemTotal(path string) (uint64, error) {
+	//nolint:gosec
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || fields[0] != "MemTotal:" || fields[2] != "kB" {
+			continue
+		}
+
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid MemTotal in %s: %w", path, err)
+		}
+		return kb << 10, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, fmt.Errorf("no MemTotal in %s", path)
+}
@@ -0,0 +1,88 @@
+package memongo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateWiredTigerCacheSize(t *testing.T) {
+	tests := map[string]struct {
+		opts          Options
+		expectedError string
+	}{
+		"unset":   {opts: Options{}},
+		"minimum": {opts: Options{WiredTigerCacheSizeGB: 0.25}},
+		"percent": {opts: Options{WiredTigerCacheSizePct: 10}},
+		"too small": {
+			opts:          Options{WiredTigerCacheSizeGB: 0.1},
+			expectedError: "invalid WiredTigerCacheSizeGB 0.1: mongod needs at least 0.25",
+		},
+		"negative": {
+			opts:          Options{WiredTigerCacheSizeGB: -1},
+			expectedError: "invalid WiredTigerCacheSizeGB -1: mongod needs at least 0.25",
+		},
+		"percent too large": {
+			opts:          Options{WiredTigerCacheSizePct: 90},
+			expectedError: "invalid WiredTigerCacheSizePct 90: must be within 0-80",
+		},
+		"both": {
+			opts:          Options{WiredTigerCacheSizeGB: 1, WiredTigerCacheSizePct: 10},
+			expectedError: "cannot use both WiredTigerCacheSizeGB and WiredTigerCacheSizePct",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateWiredTigerCacheSize(&test.opts)
+			if test.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.expectedError)
+			}
+		})
+	}
+}
+
+func TestWiredTigerCacheArgs(t *testing.T) {
+	withPct, err := capabilitiesForVersion("8.0.0")
+	require.NoError(t, err)
+	withoutPct, err := capabilitiesForVersion("7.0.2")
+	require.NoError(t, err)
+
+	// Sizes are never rounded or in scientific notation
+	assert.Equal(t, []string{"--wiredTigerCacheSizeGB", "0.255"}, wiredTigerCacheArgs(&Options{WiredTigerCacheSizeGB: 0.255}, withPct))
+	assert.Equal(t, []string{"--wiredTigerCacheSizeGB", "10000000"}, wiredTigerCacheArgs(&Options{WiredTigerCacheSizeGB: 1e7}, withPct))
+	assert.Nil(t, wiredTigerCacheArgs(&Options{}, withPct))
+
+	assert.Equal(t, []string{"--wiredTigerCacheSizePct", "12.5"}, wiredTigerCacheArgs(&Options{WiredTigerCacheSizePct: 12.5}, withPct))
+
+	// Older versions get a size computed from the system's memory
+	defer func(f func() (uint64, error)) { systemMemoryBytes = f }(systemMemoryBytes)
+	systemMemoryBytes = func() (uint64, error) { return 16 << 30, nil }
+
+	opts := &Options{WiredTigerCacheSizePct: 12.5}
+	require.NoError(t, opts.resolveWiredTigerCacheSize(withoutPct, memongolog.New(nil, memongolog.LogLevelSilent)))
+	assert.Equal(t, []string{"--wiredTigerCacheSizeGB", "2"}, wiredTigerCacheArgs(opts, withoutPct))
+}
+
+func TestCacheSizeGBFromPct(t *testing.T) {
+	// Sizes are rounded down to the megabyte
+	assert.Equal(t, 1638.0/1024, cacheSizeGBFromPct(10, 16<<30))
+	assert.Equal(t, 0.25, cacheSizeGBFromPct(1, 1<<30), "should be no smaller than mongod's minimum")
+}
+
+func TestLinuxMemTotal(t *testing.T) {
+	memory, err := linuxMemTotal(filepath.Join("testdata", "meminfo", "meminfo"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(16303488)<<10, memory)
+
+	empty := filepath.Join(t.TempDir(), "meminfo")
+	require.NoError(t, os.WriteFile(empty, []byte("MemFree: 1 kB\n"), 0600))
+	_, err = linuxMemTotal(empty)
+	assert.EqualError(t, err, "no MemTotal in "+empty)
+}
@@ -0,0 +1,229 @@
+package memongo
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/100mslive/memongo/v2/mongobin"
+)
+
+// versionCapabilities describes how a range of MongoDB versions behaves, so
+// the flags we pass and the way we read mongod's output match the version
+// that's actually being run.
+type versionCapabilities struct {
+	// minVersion is the first version (inclusive) these capabilities apply to
+	minVersion []int
+
+	// ephemeralForTest is true if the ephemeralForTest storage engine is
+	// available. It was removed in 6.1.
+	ephemeralForTest bool
+
+	// noJournal is true if mongod accepts --nojournal. Journaling can't be
+	// turned off starting in 6.1.
+	noJournal bool
+
+	// cacheSizePct is true if mongod accepts --wiredTigerCacheSizePct. It
+	// was added in 8.0.
+	cacheSizePct bool
+
+	// quiesce is true if replica set members and mongos wait for a quiesce
+	// period, 15 seconds by default, when they're asked to shut down with
+	// SIGTERM. It was added in 5.0.
+	quiesce bool
+
+	// autoSplit is true if mongos splits chunks as they grow, unless the
+	// autosplit document of config.settings turns it off. Auto-splitting
+	// was removed in 6.0.3, and the setting does nothing from then.
+	autoSplit bool
+
+	// reReady matches the log line mongod prints once it accepts connections,
+	// capturing the port. Starting in 4.4, mongod logs structured JSON.
+	reReady *regexp.Regexp
+}
+
+var (
+	reReadyLegacy     = regexp.MustCompile(`waiting for connections on port (\d+)`)
+	reReadyStructured = regexp.MustCompile(`"msg":"waiting for connections".*"port":(\d+)`)
+)
+
+// capabilityTable is sorted by minVersion. Versions below the first entry are
+// not supported.
+var capabilityTable = []versionCapabilities{
+	{
+		minVersion:       []int{3, 2, 0},
+		ephemeralForTest: true,
+		noJournal:        true,
+		autoSplit:        true,
+		reReady:          reReadyLegacy,
+	},
+	{
+		minVersion:       []int{4, 4, 0},
+		ephemeralForTest: true,
+		noJournal:        true,
+		autoSplit:        true,
+		reReady:          reReadyStructured,
+	},
+	{
+		minVersion:       []int{5, 0, 0},
+		ephemeralForTest: true,
+		noJournal:        true,
+		quiesce:          true,
+		autoSplit:        true,
+		reReady:          reReadyStructured,
+	},
+	{
+		minVersion:       []int{6, 1, 0},
+		ephemeralForTest: false,
+		noJournal:        false,
+		quiesce:          true,
+		reReady:          reReadyStructured,
+	},
+	{
+		minVersion:       []int{8, 0, 0},
+		ephemeralForTest: false,
+		noJournal:        false,
+		cacheSizePct:     true,
+		quiesce:          true,
+		reReady:          reReadyStructured,
+	},
+}
+
+// unknownVersionCapabilities are used when we can't tell what version of
+// mongod we're running. They assume a recent version, but accept both log
+// formats.
+var unknownVersionCapabilities = versionCapabilities{
+	ephemeralForTest: false,
+	noJournal:        false,
+	reReady:          reReady,
+}
+
+// capabilitiesForVersion returns the capabilities of the given MongoDB
+// version, or an error explaining why the version isn't supported
+func capabilitiesForVersion(version string) (versionCapabilities, error) {
+	parsed, err := mongobin.ParseVersion(version)
+	if err != nil {
+		return versionCapabilities{}, err
+	}
+
+	if !versionAtLeast(parsed, capabilityTable[0].minVersion) {
+		return versionCapabilities{}, fmt.Errorf("MongoDB version %s is not supported, the minimum is %s", version, formatVersion(capabilityTable[0].minVersion))
+	}
+
+	caps := capabilityTable[0]
+	for _, c := range capabilityTable[1:] {
+		if versionAtLeast(parsed, c.minVersion) {
+			caps = c
+		}
+	}
+
+	return caps, nil
+}
+
+func versionAtLeast(a []int, b []int) bool {
+	for i := 0; i < 3; i++ {
+		if a[i] != b[i] {
+			return a[i] > b[i]
+		}
+	}
+
+	return true
+}
+
+func formatVersion(v []int) string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+var reVersionOutput = regexp.MustCompile(`db version v(\d+\.\d+\.\d+)`)
+
+// detectBinaryVersion runs mongod --version to find out what version a
+// user-supplied binary is
+func (opts *Options) detectBinaryVersion(binPath string) (string, error) {
+	program, args := opts.mongodCommandLine(binPath, "--version")
+
+	// binPath is the mongod binary we're about to run anyway
+	//nolint:gosec
+	out, err := exec.Command(program, args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("error running %s --version: %s", binPath, err)
+	}
+
+	match := reVersionOutput.FindSubmatch(out)
+	if match == nil {
+		if other := identifyBinary(out); other != "" {
+			return "", fmt.Errorf("%w: %s is %s", ErrWrongBinaryKind, binPath, other)
+		}
+		return "", fmt.Errorf("could not find a version number in the output of %s --version", binPath)
+	}
+
+	return string(match[1]), nil
+}
+
+// capabilities returns the capabilities of the mongod at binPath, asking the
+// binary for its version if MongoVersion wasn't given. It also returns the
+// version, which is empty if it couldn't be detected. MongodBin is always
+// asked, to check that it's mongod.
+func (opts *Options) capabilities(binPath string, logger *memongolog.Logger) (versionCapabilities, string, error) {
+	version := opts.MongoVersion
+	if version == "" || opts.MongodBin != "" {
+		detected, err := opts.detectBinaryVersion(binPath)
+		if opts.MongodBin != "" {
+			err := checkMongodBinary(binPath, err)
+			if err != nil {
+				return versionCapabilities{}, "", err
+			}
+		}
+		if errors.Is(err, ErrWrongBinaryKind) {
+			return versionCapabilities{}, "", err
+		}
+
+		if version == "" {
+			if err != nil {
+				logger.Warnf("%s; assuming a recent version of MongoDB", err)
+				return unknownVersionCapabilities, "", nil
+			}
+
+			logger.Debugf("Detected MongoDB version %s", detected)
+			version = detected
+		}
+	}
+
+	caps, err := capabilitiesForVersion(version)
+	return caps, version, err
+}
+
+// Capabilities describes which MongoDB features a running server supports
+type Capabilities struct {
+	// Version is the MongoDB version of the server
+	Version string
+
+	// Transactions is true if multi-document transactions are available.
+	// They need a replica set and MongoDB 4.0.
+	Transactions bool
+
+	// ChangeStreams is true if change streams are available. They need a
+	// replica set and MongoDB 3.6.
+	ChangeStreams bool
+
+	// TimeSeries is true if time series collections are available. They were
+	// added in MongoDB 5.0.
+	TimeSeries bool
+}
+
+// featureCapabilities computes the Capabilities of the given version, running
+// as a replica set or standalone
+func featureCapabilities(version string, replicaSet bool) (Capabilities, error) {
+	parsed, err := mongobin.ParseVersion(version)
+	if err != nil {
+		return Capabilities{}, err
+	}
+
+	return Capabilities{
+		Version:       version,
+		Transactions:  replicaSet && versionAtLeast(parsed, []int{4, 0, 0}),
+		ChangeStreams: replicaSet && versionAtLeast(parsed, []int{3, 6, 0}),
+		TimeSeries:    versionAtLeast(parsed, []int{5, 0, 0}),
+	}, nil
+}
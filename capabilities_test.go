@@ -0,0 +1,250 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilitiesForVersion(t *testing.T) {
+	tests := map[string]struct {
+		version string
+
+		expectEphemeralForTest bool
+		expectNoJournal        bool
+		expectStructuredLogs   bool
+		expectAutoSplit        bool
+		expectedError          string
+	}{
+		"3.2": {
+			version:                "3.2.0",
+			expectEphemeralForTest: true,
+			expectNoJournal:        true,
+			expectAutoSplit:        true,
+		},
+		"4.2": {
+			version:                "4.2.24",
+			expectEphemeralForTest: true,
+			expectNoJournal:        true,
+			expectAutoSplit:        true,
+		},
+		"4.4": {
+			version:                "4.4.0",
+			expectEphemeralForTest: true,
+			expectNoJournal:        true,
+			expectStructuredLogs:   true,
+			expectAutoSplit:        true,
+		},
+		"5.0": {
+			version:                "5.0.0",
+			expectEphemeralForTest: true,
+			expectNoJournal:        true,
+			expectStructuredLogs:   true,
+			expectAutoSplit:        true,
+		},
+		"6.0": {
+			version:                "6.0.4",
+			expectEphemeralForTest: true,
+			expectNoJournal:        true,
+			expectStructuredLogs:   true,
+			expectAutoSplit:        true,
+		},
+		"6.1": {
+			version:              "6.1.0",
+			expectStructuredLogs: true,
+		},
+		"7.0": {
+			version:              "7.0.0",
+			expectStructuredLogs: true,
+		},
+		"8.0": {
+			version:              "8.0.0",
+			expectStructuredLogs: true,
+		},
+		"too old": {
+			version:       "3.0.15",
+			expectedError: "memongo does not support MongoDB version \"3.0.15\": Only Mongo version 3.2 and above are supported",
+		},
+		"malformed": {
+			version:       "8.0",
+			expectedError: "memongo does not support MongoDB version \"8.0\": MongoDB version number must be in the form x.y.z",
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			caps, err := capabilitiesForVersion(test.version)
+			if test.expectedError != "" {
+				require.EqualError(t, err, test.expectedError)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, test.expectEphemeralForTest, caps.ephemeralForTest)
+			assert.Equal(t, test.expectNoJournal, caps.noJournal)
+			assert.Equal(t, test.expectAutoSplit, caps.autoSplit)
+			if test.expectStructuredLogs {
+				assert.Equal(t, reReadyStructured, caps.reReady)
+			} else {
+				assert.Equal(t, reReadyLegacy, caps.reReady)
+			}
+		})
+	}
+}
+
+func TestReadyRegexes(t *testing.T) {
+	legacy := "2020-01-01T00:00:00.000+0000 I  NETWORK  [initandlisten] waiting for connections on port 27017"
+	structured := `{"t":{"$date":"2024-01-01T00:00:00.000+00:00"},"s":"I",  "c":"NETWORK",  "id":23016,   "ctx":"listener","msg":"Waiting for connections","attr":{"port":27017,"ssl":"off"}}`
+
+	for _, line := range []string{legacy, structured} {
+		match := reReady.FindStringSubmatch(strings.ToLower(line))
+		require.NotNil(t, match)
+		assert.Equal(t, "27017", match[1])
+	}
+
+	match := reReadyLegacy.FindStringSubmatch(strings.ToLower(legacy))
+	require.NotNil(t, match)
+	assert.Equal(t, "27017", match[1])
+	assert.Nil(t, reReadyLegacy.FindStringSubmatch(strings.ToLower(structured)))
+
+	match = reReadyStructured.FindStringSubmatch(strings.ToLower(structured))
+	require.NotNil(t, match)
+	assert.Equal(t, "27017", match[1])
+	assert.Nil(t, reReadyStructured.FindStringSubmatch(strings.ToLower(legacy)))
+}
+
+func writeScript(t *testing.T, contents string) string {
+	binPath := filepath.Join(t.TempDir(), "mongod")
+	require.NoError(t, os.WriteFile(binPath, []byte("#!/bin/sh\n"+contents+"\n"), 0700))
+	return binPath
+}
+
+func TestCapabilitiesDetectsBinaryVersion(t *testing.T) {
+	logger := memongolog.New(nil, memongolog.LogLevelSilent)
+
+	binPath := writeScript(t, `echo "db version v5.0.3"; echo "Build Info: {}"`)
+	caps, version, err := (&Options{MongodBin: binPath}).capabilities(binPath, logger)
+	require.NoError(t, err)
+	assert.Equal(t, "5.0.3", version)
+	assert.True(t, caps.ephemeralForTest)
+	assert.Equal(t, reReadyStructured, caps.reReady)
+
+	// An explicit version wins over detection
+	caps, version, err = (&Options{MongodBin: binPath, MongoVersion: "8.0.0"}).capabilities(binPath, logger)
+	require.NoError(t, err)
+	assert.Equal(t, "8.0.0", version)
+	assert.False(t, caps.ephemeralForTest)
+
+	// A binary that doesn't report a version gets the defaults
+	binPath = writeScript(t, `echo "hello"`)
+	caps, version, err = (&Options{DownloadURL: "https://example.com/mongodb.tgz"}).capabilities(binPath, logger)
+	require.NoError(t, err)
+	assert.Empty(t, version)
+	assert.Equal(t, unknownVersionCapabilities, caps)
+
+	// but MongodBin can't be a script that doesn't
+	_, _, err = (&Options{MongodBin: binPath}).capabilities(binPath, logger)
+	assert.ErrorIs(t, err, ErrScriptBinary)
+}
+
+func TestFeatureCapabilities(t *testing.T) {
+	tests := map[string]struct {
+		version    string
+		replicaSet bool
+
+		expected Capabilities
+	}{
+		"3.6 standalone": {
+			version:  "3.6.0",
+			expected: Capabilities{Version: "3.6.0"},
+		},
+		"3.6 replica set": {
+			version:    "3.6.0",
+			replicaSet: true,
+			expected:   Capabilities{Version: "3.6.0", ChangeStreams: true},
+		},
+		"4.4 replica set": {
+			version:    "4.4.0",
+			replicaSet: true,
+			expected:   Capabilities{Version: "4.4.0", Transactions: true, ChangeStreams: true},
+		},
+		"8.0 standalone": {
+			version:  "8.0.0",
+			expected: Capabilities{Version: "8.0.0", TimeSeries: true},
+		},
+		"8.0 replica set": {
+			version:    "8.0.0",
+			replicaSet: true,
+			expected:   Capabilities{Version: "8.0.0", Transactions: true, ChangeStreams: true, TimeSeries: true},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			caps, err := featureCapabilities(test.version, test.replicaSet)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, caps)
+		})
+	}
+}
+
+func TestServerCapabilitiesAfterStop(t *testing.T) {
+	// The version is known, so the server isn't contacted
+	server := &Server{isReplicaSet: true, version: "7.0.0"}
+
+	caps, err := server.Capabilities(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Capabilities{Version: "7.0.0", Transactions: true, ChangeStreams: true, TimeSeries: true}, caps)
+}
+
+func TestValidate(t *testing.T) {
+	assert.EqualError(t, (&Options{MongoVersion: "2.6.0", MongodBin: "/bin/true"}).Validate(),
+		"memongo does not support MongoDB version \"2.6.0\": Only Mongo version 3.2 and above are supported")
+
+	assert.NoError(t, (&Options{MongoVersion: "4.4.0", MongodBin: "/bin/true"}).Validate())
+	assert.NoError(t, (&Options{MongodBin: "/bin/true"}).Validate())
+
+	os.Unsetenv("MEMONGO_MONGOD_BIN")
+	os.Unsetenv("MEMONGO_DOWNLOAD_URL")
+	assert.EqualError(t, (&Options{}).Validate(), "one of MongoVersion, DownloadURL, or MongodBin must be given")
+}
+
+func TestParseReplicaSetNameMismatch(t *testing.T) {
+	legacy := "2020-01-01T00:00:00.000+0000 W  REPL     [replexec-0] Local replica set configuration document reports set name of rs0, but command line reports other; waiting for reconfig or remote heartbeat"
+	structured := `{"t":{"$date":"2024-01-01T00:00:00.000+00:00"},"s":"W",  "c":"REPL",     "id":21405,   "ctx":"initandlisten","msg":"Local replica set configuration document set name differs from command line set name; waiting for reconfig or remote heartbeat","attr":{"localConfigSetName":"rs0","commandLineSetName":"other"}}`
+
+	for _, line := range []string{legacy, structured} {
+		names, ok := parseReplicaSetNameMismatch(line)
+		require.True(t, ok)
+		assert.Equal(t, [2]string{"rs0", "other"}, names)
+	}
+
+	_, ok := parseReplicaSetNameMismatch(`{"msg":"Waiting for connections","attr":{"port":27017}}`)
+	assert.False(t, ok)
+
+	ch := make(chan [2]string, 1)
+	assert.NoError(t, replicaSetNameMismatch(ch, "/tmp/db"))
+
+	ch <- [2]string{"rs0", "other"}
+	err := replicaSetNameMismatch(ch, "/tmp/db")
+	assert.ErrorIs(t, err, ErrReplicaSetNameMismatch)
+	assert.Contains(t, err.Error(), `holds replica set "rs0", but ReplicaSetName is "other"`)
+}
+
+func TestValidateReplicaSetName(t *testing.T) {
+	for _, name := range []string{"rs0", "my-set_1.a"} {
+		assert.NoError(t, (&Options{MongodBin: "/bin/true", ReplicaSetName: name}).Validate(), name)
+	}
+
+	for _, name := range []string{"rs/0", "a b", "a,b", "ünïcode"} {
+		assert.EqualError(t, (&Options{MongodBin: "/bin/true", ReplicaSetName: name}).Validate(),
+			fmt.Sprintf("invalid replica set name %q: may only contain letters, digits, '-', '_' and '.'", name))
+	}
+}
@@ -0,0 +1,173 @@
+package memongo
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/event"
+)
+
+// capturedCommandLimit is how many commands are kept when
+// Options.CaptureCommands is set. Older commands are dropped first.
+const capturedCommandLimit = 10000
+
+// CapturedCommand is a command sent by the client returned by Server.Client,
+// recorded when Options.CaptureCommands is set
+type CapturedCommand struct {
+	// Name is the command name, e.g. "find" or "insert"
+	Name string
+
+	// Database is the database the command ran against
+	Database string
+
+	// Command is a copy of the command document, with passwords and
+	// authentication payloads redacted
+	Command bson.Raw
+
+	// Started is when the command was sent
+	Started time.Time
+
+	// Duration is how long the command took. It's 0 while the command is
+	// still running.
+	Duration time.Duration
+
+	// Finished is true once the command has succeeded or failed
+	Finished bool
+
+	// Failure is the error message if the command failed, or empty
+	Failure string
+}
+
+// CommandFilter selects captured commands. Empty fields match anything.
+type CommandFilter struct {
+	Name     string
+	Database string
+}
+
+func (f CommandFilter) matches(cmd CapturedCommand) bool {
+	return (f.Name == "" || f.Name == cmd.Name) && (f.Database == "" || f.Database == cmd.Database)
+}
+
+// commandCapture records the commands seen by a CommandMonitor
+type commandCapture struct {
+	mu       sync.Mutex
+	commands []CapturedCommand
+	// pending maps request IDs of running commands to their index in commands
+	pending map[int64]int
+}
+
+func newCommandCapture() *commandCapture {
+	return &commandCapture{pending: map[int64]int{}}
+}
+
+func (c *commandCapture) monitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			c.started(e)
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			c.finished(e.RequestID, e.Duration, "")
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			c.finished(e.RequestID, e.Duration, e.Failure.Error())
+		},
+	}
+}
+
+func (c *commandCapture) started(e *event.CommandStartedEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.commands) >= capturedCommandLimit {
+		c.commands = c.commands[1:]
+		for id, i := range c.pending {
+			if i == 0 {
+				delete(c.pending, id)
+			} else {
+				c.pending[id] = i - 1
+			}
+		}
+	}
+
+	c.commands = append(c.commands, CapturedCommand{
+		Name:     e.CommandName,
+		Database: e.DatabaseName,
+		Command:  RedactCommand(e.Command),
+		Started:  time.Now(),
+	})
+	c.pending[e.RequestID] = len(c.commands) - 1
+}
+
+func (c *commandCapture) finished(requestID int64, duration time.Duration, failure string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i, ok := c.pending[requestID]
+	if !ok {
+		return
+	}
+	delete(c.pending, requestID)
+
+	c.commands[i].Duration = duration
+	c.commands[i].Finished = true
+	c.commands[i].Failure = failure
+}
+
+func (c *commandCapture) filter(filter CommandFilter) []CapturedCommand {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var matched []CapturedCommand
+	for _, cmd := range c.commands {
+		if filter.matches(cmd) {
+			matched = append(matched, cmd)
+		}
+	}
+
+	return matched
+}
+
+func (c *commandCapture) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.commands = nil
+	c.pending = map[int64]int{}
+}
+
+// CapturedCommands returns the commands sent by the client returned by
+// Client that match filter, oldest first. It returns nil unless
+// Options.CaptureCommands is set.
+func (s *Server) CapturedCommands(filter CommandFilter) []CapturedCommand {
+	if s.capture == nil {
+		return nil
+	}
+
+	return s.capture.filter(filter)
+}
+
+// ResetCapturedCommands forgets all captured commands
+func (s *Server) ResetCapturedCommands() {
+	if s.capture != nil {
+		s.capture.reset()
+	}
+}
+
+// AssertCommandCount fails the test unless exactly n commands named name
+// have been captured. It requires Options.CaptureCommands.
+func (s *Server) AssertCommandCount(tb testing.TB, name string, n int) {
+	tb.Helper()
+
+	if s.capture == nil {
+		tb.Fatalf("AssertCommandCount requires Options.CaptureCommands")
+		return
+	}
+
+	commands := s.CapturedCommands(CommandFilter{Name: name})
+	if len(commands) != n {
+		tb.Errorf("expected %d %s commands, but %d were captured", n, name, len(commands))
+	}
+}
@@ -0,0 +1,69 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/event"
+)
+
+func mustMarshal(t *testing.T, doc interface{}) bson.Raw {
+	raw, err := bson.Marshal(doc)
+	require.NoError(t, err)
+	return raw
+}
+
+func TestCommandCapture(t *testing.T) {
+	capture := newCommandCapture()
+	monitor := capture.monitor()
+	ctx := context.Background()
+
+	find := mustMarshal(t, bson.D{{Key: "find", Value: "users"}, {Key: "filter", Value: bson.D{{Key: "name", Value: "ada"}}}})
+	monitor.Started(ctx, &event.CommandStartedEvent{Command: find, DatabaseName: "app", CommandName: "find", RequestID: 1})
+	monitor.Started(ctx, &event.CommandStartedEvent{Command: mustMarshal(t, bson.D{{Key: "insert", Value: "users"}}), DatabaseName: "app", CommandName: "insert", RequestID: 2})
+
+	// The driver reuses command buffers, so captured commands are copies
+	find[len(find)-4] = 'X'
+
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: event.CommandFinishedEvent{RequestID: 1, Duration: time.Millisecond}})
+	monitor.Failed(ctx, &event.CommandFailedEvent{CommandFinishedEvent: event.CommandFinishedEvent{RequestID: 2, Duration: 2 * time.Millisecond}, Failure: errors.New("duplicate key")})
+
+	finds := capture.filter(CommandFilter{Name: "find"})
+	require.Len(t, finds, 1)
+	assert.Equal(t, "app", finds[0].Database)
+	assert.Equal(t, time.Millisecond, finds[0].Duration)
+	assert.True(t, finds[0].Finished)
+	assert.Empty(t, finds[0].Failure)
+	assert.Equal(t, "ada", finds[0].Command.Lookup("filter", "name").StringValue())
+
+	inserts := capture.filter(CommandFilter{Name: "insert", Database: "app"})
+	require.Len(t, inserts, 1)
+	assert.Equal(t, "duplicate key", inserts[0].Failure)
+
+	assert.Len(t, capture.filter(CommandFilter{}), 2)
+	assert.Empty(t, capture.filter(CommandFilter{Database: "other"}))
+
+	capture.reset()
+	assert.Empty(t, capture.filter(CommandFilter{}))
+}
+
+func TestCommandCaptureLimit(t *testing.T) {
+	capture := newCommandCapture()
+	cmd := mustMarshal(t, bson.D{{Key: "ping", Value: 1}})
+
+	for i := 0; i < capturedCommandLimit+10; i++ {
+		capture.started(&event.CommandStartedEvent{Command: cmd, CommandName: "ping", RequestID: int64(i)})
+	}
+	// The last command is still pending after the oldest were dropped
+	capture.finished(int64(capturedCommandLimit+9), time.Second, "")
+
+	commands := capture.filter(CommandFilter{})
+	require.Len(t, commands, capturedCommandLimit)
+	assert.True(t, commands[len(commands)-1].Finished)
+	assert.False(t, commands[0].Finished)
+}
@@ -0,0 +1,127 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// CausalOrderError is returned by AssertCausalOrder when a causally
+// consistent read didn't observe the write before it. That shouldn't happen
+// on a correctly configured replica set, so it points to a problem with the
+// test server's topology or configuration rather than with the code under
+// test.
+type CausalOrderError struct {
+	// WriteOperationTime is the operation time of the write's session after
+	// the write, which the read waited for with afterClusterTime
+	WriteOperationTime bson.Timestamp
+
+	// ReadOperationTime is the operation time of the read's session after
+	// the read
+	ReadOperationTime bson.Timestamp
+
+	// ReplicaSetName is the name of the server's replica set
+	ReplicaSetName string
+}
+
+func (e *CausalOrderError) Error() string {
+	return fmt.Sprintf("causally consistent read didn't observe the write before it: the write's operation time was %d.%d, "+
+		"the read waited for it with afterClusterTime and ran at %d.%d on replica set %s; "+
+		"check that both ran against the same replica set with a read concern that allows afterClusterTime",
+		e.WriteOperationTime.T, e.WriteOperationTime.I, e.ReadOperationTime.T, e.ReadOperationTime.I, e.ReplicaSetName)
+}
+
+// WithCausalSession calls fn with a context carrying a causally consistent
+// session of the client returned by Client, so reads in fn observe the
+// writes made before them in fn. It needs a replica set, and returns an
+// error wrapping ErrNotReplicaSet otherwise.
+func (s *Server) WithCausalSession(ctx context.Context, fn func(context.Context) error) error {
+	sess, err := s.startCausalSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(context.Background())
+
+	return mongo.WithSession(ctx, sess, fn)
+}
+
+// AssertCausalOrder checks that the server orders operations causally: it
+// calls write in one causally consistent session, then read in a second one
+// that waits for the first session's operation time. read must report
+// whether it observed what write did. If it didn't, AssertCausalOrder
+// returns a *CausalOrderError.
+//
+// Like WithCausalSession, it needs a replica set.
+func (s *Server) AssertCausalOrder(ctx context.Context, write func(context.Context) error, read func(context.Context) (bool, error)) error {
+	writeSess, err := s.startCausalSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer writeSess.EndSession(context.Background())
+
+	err = mongo.WithSession(ctx, writeSess, write)
+	if err != nil {
+		return fmt.Errorf("error running write: %w", err)
+	}
+
+	writeTime := writeSess.OperationTime()
+	if writeTime == nil {
+		return fmt.Errorf("write didn't run any operations in its session")
+	}
+
+	readSess, err := s.startCausalSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer readSess.EndSession(context.Background())
+
+	err = readSess.AdvanceClusterTime(writeSess.ClusterTime())
+	if err != nil {
+		return fmt.Errorf("error advancing the read session's cluster time: %w", err)
+	}
+	err = readSess.AdvanceOperationTime(writeTime)
+	if err != nil {
+		return fmt.Errorf("error advancing the read session's operation time: %w", err)
+	}
+
+	var observed bool
+	err = mongo.WithSession(ctx, readSess, func(ctx context.Context) error {
+		var err error
+		observed, err = read(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error running read: %w", err)
+	}
+
+	if !observed {
+		causalErr := &CausalOrderError{WriteOperationTime: *writeTime, ReplicaSetName: s.replicaSetName}
+		if readTime := readSess.OperationTime(); readTime != nil {
+			causalErr.ReadOperationTime = *readTime
+		}
+		return causalErr
+	}
+
+	return nil
+}
+
+func (s *Server) startCausalSession(ctx context.Context) (*mongo.Session, error) {
+	if !s.isReplicaSet {
+		return nil, fmt.Errorf("cannot start a causally consistent session: %w", ErrNotReplicaSet)
+	}
+
+	client, err := s.Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := client.StartSession(options.Session().SetCausalConsistency(true))
+	if err != nil {
+		return nil, fmt.Errorf("error starting session: %w", err)
+	}
+
+	return sess, nil
+}
@@ -0,0 +1,31 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestCausalNeedsReplicaSet(t *testing.T) {
+	server := &Server{}
+	ctx := context.Background()
+
+	err := server.WithCausalSession(ctx, func(context.Context) error { return nil })
+	assert.True(t, errors.Is(err, ErrNotReplicaSet))
+
+	err = server.AssertCausalOrder(ctx, func(context.Context) error { return nil }, func(context.Context) (bool, error) { return true, nil })
+	assert.True(t, errors.Is(err, ErrNotReplicaSet))
+}
+
+func TestCausalOrderError(t *testing.T) {
+	err := &CausalOrderError{
+		WriteOperationTime: bson.Timestamp{T: 100, I: 2},
+		ReadOperationTime:  bson.Timestamp{T: 100, I: 1},
+		ReplicaSetName:     "rs0",
+	}
+	assert.Contains(t, err.Error(), "the write's operation time was 100.2")
+	assert.Contains(t, err.Error(), "ran at 100.1 on replica set rs0")
+}
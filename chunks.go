@@ -0,0 +1,216 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// commandNotFoundCodes are the error codes of a command the server doesn't
+// have
+var commandNotFoundCodes = []int{
+	59, // CommandNotFound
+}
+
+// DisableBalancer stops the balancer of a sharded cluster, and
+// auto-splitting on versions that have it, and returns once config.settings
+// has the balancer off. The cluster starts with it off unless
+// Options.EnableBalancer is set. It returns an error wrapping ErrNotSharded
+// unless the server was started with Options.Sharded.
+func (s *Server) DisableBalancer(ctx context.Context) error {
+	if s.cluster == nil {
+		return fmt.Errorf("cannot stop the balancer: %w", ErrNotSharded)
+	}
+
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	settings := client.Database("config").Collection("settings")
+	upsert := options.UpdateOne().SetUpsert(true)
+
+	// balancerStop was added in 3.4. Before then, sh.stopBalancer updated
+	// config.settings itself.
+	err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "balancerStop", Value: 1}}).Err()
+	if hasErrorCode(err, commandNotFoundCodes) {
+		_, err = settings.UpdateOne(ctx, bson.D{{Key: "_id", Value: "balancer"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "stopped", Value: true}}}}, upsert)
+	}
+	if err != nil {
+		return fmt.Errorf("error stopping the balancer: %w", err)
+	}
+
+	s.mu.Lock()
+	caps := s.caps
+	s.mu.Unlock()
+	if caps.autoSplit {
+		_, err = settings.UpdateOne(ctx, bson.D{{Key: "_id", Value: "autosplit"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "enabled", Value: false}}}}, upsert)
+		if err != nil {
+			return fmt.Errorf("error turning off auto-splitting: %w", err)
+		}
+	}
+
+	var balancer struct {
+		Mode    string `bson:"mode"`
+		Stopped bool   `bson:"stopped"`
+	}
+	err = settings.FindOne(ctx, bson.D{{Key: "_id", Value: "balancer"}}).Decode(&balancer)
+	if err != nil {
+		return fmt.Errorf("error checking that the balancer stopped: %w", err)
+	}
+	if !balancer.Stopped && balancer.Mode != "off" {
+		return fmt.Errorf("the balancer is still running: config.settings has mode %q", balancer.Mode)
+	}
+
+	return nil
+}
+
+// EnableSharding enables sharding for the database db, which makes a shard
+// its primary shard. From 6.0 ShardCollection does this itself. It returns
+// once config.databases lists db, or an error wrapping ErrNotSharded unless
+// the server was started with Options.Sharded.
+func (s *Server) EnableSharding(ctx context.Context, db string) error {
+	if s.cluster == nil {
+		return fmt.Errorf("cannot enable sharding for %s: %w", db, ErrNotSharded)
+	}
+
+	client, err := s.Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "enableSharding", Value: db}}).Err()
+	if err != nil {
+		return fmt.Errorf("error enabling sharding for %s: %w", db, err)
+	}
+
+	n, err := client.Database("config").Collection("databases").CountDocuments(ctx, bson.D{{Key: "_id", Value: db}})
+	if err != nil {
+		return fmt.Errorf("error checking that sharding is enabled for %s: %w", db, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("sharding wasn't enabled for %s: config.databases doesn't list it", db)
+	}
+
+	return nil
+}
+
+// MoveChunk moves the chunk of the sharded collection ns that contains the
+// document matching find, which has the shard key's fields, to the shard
+// toShard, e.g. "shard1", and waits for the documents to be deleted from the
+// shard it was on. It returns once config.chunks has the chunk on toShard.
+// Chunks of hashed shard keys can't be found by a document this way.
+func (s *Server) MoveChunk(ctx context.Context, ns string, find bson.D, toShard string) error {
+	client, err := s.chunkClient(ctx, ns)
+	if err != nil {
+		return err
+	}
+
+	err = client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "moveChunk", Value: ns},
+		{Key: "find", Value: find},
+		{Key: "to", Value: toShard},
+		{Key: "_waitForDelete", Value: true},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("error moving the chunk of %s containing %s to %s: %w", ns, find, toShard, err)
+	}
+
+	filter, err := chunksFilter(ctx, client, ns)
+	if err != nil {
+		return err
+	}
+	filter = append(filter, bson.E{Key: "min", Value: bson.D{{Key: "$lte", Value: find}}}, bson.E{Key: "max", Value: bson.D{{Key: "$gt", Value: find}}})
+
+	var chunk struct {
+		Shard string `bson:"shard"`
+	}
+	err = client.Database("config").Collection("chunks").FindOne(ctx, filter).Decode(&chunk)
+	if err != nil {
+		return fmt.Errorf("error checking where the chunk of %s containing %s is: %w", ns, find, err)
+	}
+	if chunk.Shard != toShard {
+		return fmt.Errorf("the chunk of %s containing %s is on %s, not %s", ns, find, chunk.Shard, toShard)
+	}
+
+	return nil
+}
+
+// SplitAt splits the chunk of the sharded collection ns that contains the
+// shard key value middle in two, so that a chunk starts at middle. It
+// returns once config.chunks has that chunk.
+func (s *Server) SplitAt(ctx context.Context, ns string, middle bson.D) error {
+	client, err := s.chunkClient(ctx, ns)
+	if err != nil {
+		return err
+	}
+
+	err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "split", Value: ns}, {Key: "middle", Value: middle}}).Err()
+	if err != nil {
+		return fmt.Errorf("error splitting %s at %s: %w", ns, middle, err)
+	}
+
+	filter, err := chunksFilter(ctx, client, ns)
+	if err != nil {
+		return err
+	}
+	n, err := client.Database("config").Collection("chunks").CountDocuments(ctx, append(filter, bson.E{Key: "min", Value: middle}))
+	if err != nil {
+		return fmt.Errorf("error checking that %s was split at %s: %w", ns, middle, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s wasn't split at %s: config.chunks has no chunk starting there", ns, middle)
+	}
+
+	return nil
+}
+
+// chunkClient returns the client of the sharded cluster for changing the
+// chunks of the collection ns
+func (s *Server) chunkClient(ctx context.Context, ns string) (*mongo.Client, error) {
+	if s.cluster == nil {
+		return nil, fmt.Errorf("cannot change the chunks of %s: %w", ns, ErrNotSharded)
+	}
+
+	_, err := splitNamespace(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Client(ctx)
+}
+
+// chunksFilter returns the filter of config.chunks for the sharded
+// collection ns, which config.collections must list. Chunks name their
+// collection by namespace before 5.0, and by UUID from then.
+func chunksFilter(ctx context.Context, client *mongo.Client, ns string) (bson.D, error) {
+	var collection struct {
+		UUID bson.RawValue `bson:"uuid"`
+	}
+	err := client.Database("config").Collection("collections").FindOne(ctx, bson.D{
+		{Key: "_id", Value: ns},
+		{Key: "dropped", Value: bson.D{{Key: "$ne", Value: true}}},
+	}).Decode(&collection)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%s isn't sharded: config.collections doesn't list it", ns)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error looking up %s in config.collections: %w", ns, err)
+	}
+
+	if collection.UUID.Type == 0 {
+		return bson.D{{Key: "ns", Value: ns}}, nil
+	}
+
+	return bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "ns", Value: ns}},
+		bson.D{{Key: "uuid", Value: collection.UUID}},
+	}}}, nil
+}
@@ -0,0 +1,78 @@
+package memongo
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// CleanupPolicy is when Stop removes something the server created
+type CleanupPolicy int
+
+const (
+	// CleanupAlways removes it whenever the server is stopped. It's the
+	// default.
+	CleanupAlways CleanupPolicy = iota
+
+	// CleanupOnSuccess removes it unless the server failed: a mongod exited
+	// without being stopped, the server was stopped for exceeding
+	// MaxDBPathBytes, or MarkFailed was called
+	CleanupOnSuccess
+
+	// CleanupNever keeps it
+	CleanupNever
+)
+
+// Cleanup configures what Stop removes
+type Cleanup struct {
+	// RemoveDBPath is when the data directories of the server and of the
+	// replica set members added with AddReplicaMember are removed
+	RemoveDBPath CleanupPolicy
+}
+
+func (p CleanupPolicy) validate() error {
+	if p < CleanupAlways || p > CleanupNever {
+		return fmt.Errorf("invalid cleanup policy %d", p)
+	}
+
+	return nil
+}
+
+// shouldRemove returns whether to remove something under the policy, given
+// whether the server failed
+func (p CleanupPolicy) shouldRemove(failed bool) bool {
+	switch p {
+	case CleanupOnSuccess:
+		return !failed
+	case CleanupNever:
+		return false
+	default:
+		return true
+	}
+}
+
+// MarkFailed records that the tests using the server failed, so that Stop
+// keeps what Options.Cleanup says to keep on failure
+func (s *Server) MarkFailed() {
+	atomic.StoreInt32(&s.failed, 1)
+}
+
+// hasFailed returns whether the server has failed, given the reason it's
+// being stopped. It must be called before its processes are stopped.
+func (s *Server) hasFailed(reason error) bool {
+	if reason != nil || atomic.LoadInt32(&s.failed) == 1 {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.proc.hasExited() {
+		return true
+	}
+	for _, member := range s.members {
+		if member.hasExited() {
+			return true
+		}
+	}
+
+	return s.cluster.hasFailed()
+}
@@ -0,0 +1,187 @@
+package memongo
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanupPolicy(t *testing.T) {
+	tests := map[string]struct {
+		policy    CleanupPolicy
+		failed    bool
+		shouldRem bool
+	}{
+		"always, success":     {policy: CleanupAlways, failed: false, shouldRem: true},
+		"always, failure":     {policy: CleanupAlways, failed: true, shouldRem: true},
+		"on success, success": {policy: CleanupOnSuccess, failed: false, shouldRem: true},
+		"on success, failure": {policy: CleanupOnSuccess, failed: true, shouldRem: false},
+		"never, success":      {policy: CleanupNever, failed: false, shouldRem: false},
+		"never, failure":      {policy: CleanupNever, failed: true, shouldRem: false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.shouldRem, test.policy.shouldRemove(test.failed))
+		})
+	}
+
+	assert.Error(t, CleanupPolicy(3).validate())
+	assert.Error(t, CleanupPolicy(-1).validate())
+}
+
+// fakeProcess starts a sleep process standing in for mongod, with a real
+// data directory
+func fakeProcess(t *testing.T) *mongodProcess {
+	return fakeProcessOf(t, exec.Command("sleep", "60"))
+}
+
+// fakeProcessOf starts cmd standing in for mongod, with a real data
+// directory
+func fakeProcessOf(t *testing.T, cmd *exec.Cmd) *mongodProcess {
+	dbDir, err := os.MkdirTemp(t.TempDir(), "memongo")
+	require.NoError(t, err)
+
+	require.NoError(t, cmd.Start())
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	return &mongodProcess{cmd: cmd, dbDir: dbDir, exited: exited, stopping: new(int32)}
+}
+
+func fakeServer(t *testing.T, cleanup Cleanup) *Server {
+	proc := fakeProcess(t)
+
+	return &Server{
+		proc:    proc,
+		dbDir:   proc.dbDir,
+		host:    "127.0.0.1",
+		port:    1,
+		logger:  memongolog.New(nil, memongolog.LogLevelSilent),
+		events:  newEventBus(nil),
+		opts:    Options{Cleanup: cleanup},
+		members: map[int]*mongodProcess{},
+		stopped: make(chan struct{}),
+	}
+}
+
+func TestStopCleanup(t *testing.T) {
+	tests := map[string]struct {
+		policy CleanupPolicy
+		fail   func(*testing.T, *Server)
+		kept   bool
+	}{
+		"default":                       {kept: false},
+		"on success":                    {policy: CleanupOnSuccess, kept: false},
+		"on success, marked failed":     {policy: CleanupOnSuccess, fail: markFailed, kept: true},
+		"on success, unexpected exit":   {policy: CleanupOnSuccess, fail: killMongod, kept: true},
+		"on success, quota exceeded":    {policy: CleanupOnSuccess, fail: stopForQuota, kept: true},
+		"on success, member exited":     {policy: CleanupOnSuccess, fail: addExitedMember, kept: true},
+		"on success, failed after stop": {policy: CleanupOnSuccess, fail: markFailedAfterStop, kept: false},
+		"always, marked failed":         {policy: CleanupAlways, fail: markFailed, kept: false},
+		"always, member exited":         {policy: CleanupAlways, fail: addExitedMember, kept: false},
+		"never":                         {policy: CleanupNever, kept: true},
+		"never, quota exceeded":         {policy: CleanupNever, fail: stopForQuota, kept: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := fakeServer(t, Cleanup{RemoveDBPath: test.policy})
+			if test.fail != nil {
+				test.fail(t, server)
+			}
+			server.Stop()
+
+			_, err := os.Stat(server.DBPath())
+			if test.kept {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, os.IsNotExist(err), "data directory wasn't removed")
+			}
+		})
+	}
+}
+
+func markFailed(t *testing.T, s *Server) {
+	s.MarkFailed()
+}
+
+func killMongod(t *testing.T, s *Server) {
+	require.NoError(t, s.proc.cmd.Process.Kill())
+	<-s.proc.exited
+}
+
+func stopForQuota(t *testing.T, s *Server) {
+	s.stop(errors.New("quota exceeded"))
+}
+
+func addExitedMember(t *testing.T, s *Server) {
+	member := fakeProcess(t)
+	require.NoError(t, member.cmd.Process.Kill())
+	<-member.exited
+	s.members[1] = member
+}
+
+// markFailedAfterStop marks the server failed too late to make a difference
+func markFailedAfterStop(t *testing.T, s *Server) {
+	s.Stop()
+	s.MarkFailed()
+}
+
+func TestStopWithContext(t *testing.T) {
+	// mongod is asked to shut down, and does
+	server := fakeServer(t, Cleanup{})
+	member := fakeProcess(t)
+	server.members[1] = member
+	start := time.Now()
+	require.NoError(t, server.StopWithContext(context.Background()))
+	assert.Less(t, time.Since(start), gracefulStopTimeout)
+	for _, proc := range []*mongodProcess{server.proc, member} {
+		status := proc.cmd.ProcessState.Sys().(syscall.WaitStatus)
+		assert.Equal(t, syscall.SIGTERM, status.Signal())
+		_, err := os.Stat(proc.dbDir)
+		assert.True(t, os.IsNotExist(err), "data directory wasn't removed")
+	}
+
+	// A mongod that doesn't shut down is killed once ctx is done
+	server = fakeServer(t, Cleanup{})
+	cmd := exec.Command("sh", "-c", "trap '' TERM; echo ready; exec sleep 60")
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	server.proc = fakeProcessOf(t, cmd)
+	server.dbDir = server.proc.dbDir
+	_, err = bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	require.NoError(t, server.StopWithContext(ctx))
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	status := server.proc.cmd.ProcessState.Sys().(syscall.WaitStatus)
+	assert.Equal(t, syscall.SIGKILL, status.Signal())
+	_, err = os.Stat(server.dbDir)
+	assert.True(t, os.IsNotExist(err), "data directory wasn't removed")
+
+	// Errors are returned, by later calls too
+	server = fakeServer(t, Cleanup{})
+	file, err := os.Create(filepath.Join(t.TempDir(), "recording"))
+	require.NoError(t, err)
+	server.recorder = newCommandRecorder(file, false)
+	require.NoError(t, file.Close())
+	err = server.StopWithContext(context.Background())
+	assert.Contains(t, err.Error(), "error writing command recording")
+	assert.Equal(t, err, server.StopWithContext(context.Background()))
+}
@@ -0,0 +1,100 @@
+package memongo
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// faketimeLibraries are where distributions install libfaketime
+var faketimeLibraries = []string{
+	"/usr/lib/x86_64-linux-gnu/faketime/libfaketime.so.1",
+	"/usr/lib/aarch64-linux-gnu/faketime/libfaketime.so.1",
+	"/usr/lib/faketime/libfaketime.so.1",
+	"/usr/lib64/faketime/libfaketime.so.1",
+	"/usr/local/lib/faketime/libfaketime.so.1",
+}
+
+// ClockWrapper runs mongod with a skewed or accelerated clock using
+// libfaketime, for testing TTL indexes and session expiry without waiting in
+// real time. It's best effort: mongod has no supported way to override its
+// clock, and libfaketime is only supported on Linux.
+type ClockWrapper struct {
+	// Library is the path to libfaketime.so.1. Defaults to the first one
+	// found in the usual install locations.
+	Library string
+
+	// Offset is added to the time mongod sees. It's rounded to whole
+	// seconds.
+	Offset time.Duration
+
+	// Speed is how many times faster than real time mongod's clock runs,
+	// e.g. 60 for a minute per second. Defaults to 1.
+	Speed float64
+}
+
+func (c *ClockWrapper) validate() error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("ClockWrapper is only supported on Linux")
+	}
+	if c.Speed < 0 {
+		return fmt.Errorf("invalid ClockWrapper.Speed %g: must not be negative", c.Speed)
+	}
+	if c.Library != "" {
+		_, err := os.Stat(c.Library)
+		if err != nil {
+			return fmt.Errorf("invalid ClockWrapper.Library: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// library returns the path of libfaketime to preload
+func (c *ClockWrapper) library() (string, error) {
+	if c.Library != "" {
+		return c.Library, nil
+	}
+
+	for _, path := range faketimeLibraries {
+		_, err := os.Stat(path)
+		if err == nil {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("libfaketime not found in %v; install it or set ClockWrapper.Library", faketimeLibraries)
+}
+
+// faketimeSpec returns the FAKETIME value for the wrapper, e.g. "+3600 x60"
+func (c *ClockWrapper) faketimeSpec() string {
+	spec := fmt.Sprintf("%+d", int64(c.Offset.Round(time.Second)/time.Second))
+	if c.Speed != 0 && c.Speed != 1 {
+		spec += " x" + strconv.FormatFloat(c.Speed, 'f', -1, 64)
+	}
+
+	return spec
+}
+
+// mongodEnv returns the environment to run mongod with, or nil to inherit
+// this process's
+func (opts *Options) mongodEnv() ([]string, error) {
+	if opts.ClockWrapper == nil {
+		return nil, nil
+	}
+
+	library, err := opts.ClockWrapper.library()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(os.Environ(),
+		"LD_PRELOAD="+library,
+		"FAKETIME="+opts.ClockWrapper.faketimeSpec(),
+		// mongod's timers and condition variables use the monotonic
+		// clock, and stall if it's skewed
+		"DONT_FAKE_MONOTONIC=1",
+	), nil
+}
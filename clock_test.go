@@ -0,0 +1,58 @@
+package memongo
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaketimeSpec(t *testing.T) {
+	tests := map[string]struct {
+		clock    ClockWrapper
+		expected string
+	}{
+		"zero":        {ClockWrapper{}, "+0"},
+		"ahead":       {ClockWrapper{Offset: time.Hour}, "+3600"},
+		"behind":      {ClockWrapper{Offset: -90 * time.Second}, "-90"},
+		"rounded":     {ClockWrapper{Offset: 1500 * time.Millisecond}, "+2"},
+		"accelerated": {ClockWrapper{Offset: time.Minute, Speed: 60}, "+60 x60"},
+		"slowed":      {ClockWrapper{Speed: 0.5}, "+0 x0.5"},
+		"real speed":  {ClockWrapper{Speed: 1}, "+0"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.clock.faketimeSpec())
+		})
+	}
+}
+
+func TestClockWrapperValidate(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		assert.EqualError(t, (&ClockWrapper{}).validate(), "ClockWrapper is only supported on Linux")
+		return
+	}
+
+	assert.NoError(t, (&ClockWrapper{Speed: 10}).validate())
+	assert.EqualError(t, (&ClockWrapper{Speed: -1}).validate(), "invalid ClockWrapper.Speed -1: must not be negative")
+	assert.Error(t, (&ClockWrapper{Library: "/nonexistent/libfaketime.so.1"}).validate())
+}
+
+func TestMongodEnv(t *testing.T) {
+	env, err := (&Options{}).mongodEnv()
+	require.NoError(t, err)
+	assert.Nil(t, env, "mongod should inherit the environment without a ClockWrapper")
+
+	library := filepath.Join(t.TempDir(), "libfaketime.so.1")
+	require.NoError(t, os.WriteFile(library, nil, 0600))
+
+	env, err = (&Options{ClockWrapper: &ClockWrapper{Library: library, Offset: time.Hour, Speed: 2}}).mongodEnv()
+	require.NoError(t, err)
+	assert.Subset(t, env, []string{"LD_PRELOAD=" + library, "FAKETIME=+3600 x2", "DONT_FAKE_MONOTONIC=1"})
+	assert.Greater(t, len(env), 3, "mongod should also get this process's environment")
+}
@@ -0,0 +1,198 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// systemDatabases hold MongoDB's own data, which automatic compaction leaves
+// alone
+var systemDatabases = map[string]bool{"admin": true, "local": true, "config": true}
+
+// Compact runs compact on the collection coll in db, so WiredTiger returns
+// the space freed by deleted documents to the filesystem. The server's
+// storage engine must be wiredTiger.
+//
+// On a replica set, compact runs on the primary. From MongoDB 4.4, it
+// doesn't block reads and writes there; before, it blocks the whole primary
+// until it finishes, which is acceptable for a test server but can make
+// concurrent operations time out.
+func (s *Server) Compact(ctx context.Context, db string, coll string) error {
+	if s.storageEngine != "wiredTiger" {
+		return fmt.Errorf("cannot compact %s.%s: compact needs the wiredTiger storage engine, but the server uses %s", db, coll, s.storageEngine)
+	}
+
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	return s.compact(ctx, client, db, coll)
+}
+
+func (s *Server) compact(ctx context.Context, client *mongo.Client, db string, coll string) error {
+	cmd := bson.D{{Key: "compact", Value: coll}}
+	if s.isReplicaSet {
+		info, err := s.BuildInfo(ctx)
+		if err != nil {
+			return err
+		}
+		// Before 4.4, compact refuses to run on a primary without force
+		if !versionAtLeast(info.VersionArray, []int{4, 4, 0}) {
+			cmd = append(cmd, bson.E{Key: "force", Value: true})
+		}
+	}
+
+	var result struct {
+		BytesFreed int64 `bson:"bytesFreed"`
+	}
+	err := client.Database(db).RunCommand(ctx, cmd).Decode(&result)
+	if err != nil {
+		return fmt.Errorf("error compacting %s.%s: %w", db, coll, err)
+	}
+	s.logger.Debugf("Compacted %s.%s, freeing %d bytes", db, coll, result.BytesFreed)
+
+	return nil
+}
+
+// DatabaseSizes returns the size on disk in bytes of each database, as
+// reported by listDatabases
+func (s *Server) DatabaseSizes(ctx context.Context) (map[string]int64, error) {
+	client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	result, err := client.ListDatabases(ctx, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing databases: %w", err)
+	}
+
+	sizes := make(map[string]int64, len(result.Databases))
+	for _, db := range result.Databases {
+		sizes[db.Name] = db.SizeOnDisk
+	}
+
+	return sizes, nil
+}
+
+// compactUserCollections compacts every collection outside the system
+// databases, unless the server is busy
+func (s *Server) compactUserCollections(ctx context.Context) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	busy, err := serverBusy(ctx, client)
+	if err != nil {
+		return err
+	}
+	if busy {
+		s.logger.Debugf("Skipping compaction while the server is busy")
+		return nil
+	}
+
+	dbs, err := client.ListDatabaseNames(ctx, bson.D{})
+	if err != nil {
+		return fmt.Errorf("error listing databases: %w", err)
+	}
+
+	for _, db := range dbs {
+		if systemDatabases[db] {
+			continue
+		}
+
+		specs, err := client.Database(db).ListCollectionSpecifications(ctx, bson.D{})
+		if err != nil {
+			return fmt.Errorf("error listing collections in %s: %w", db, err)
+		}
+		for _, coll := range compactableCollections(specs) {
+			err := s.compact(ctx, client, db, coll)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// compactableCollections returns the names of the collections in specs that
+// compact can run on: not views, time series collections' views or system
+// collections
+func compactableCollections(specs []mongo.CollectionSpecification) []string {
+	var names []string
+	for _, spec := range specs {
+		if spec.Type != "collection" || strings.HasPrefix(spec.Name, "system.") {
+			continue
+		}
+		names = append(names, spec.Name)
+	}
+
+	return names
+}
+
+// serverBusy returns whether clients are running operations that hold the
+// global lock, according to serverStatus
+func serverBusy(ctx context.Context, client *mongo.Client) (bool, error) {
+	var status struct {
+		GlobalLock struct {
+			ActiveClients struct {
+				Readers int `bson:"readers"`
+				Writers int `bson:"writers"`
+			} `bson:"activeClients"`
+		} `bson:"globalLock"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "serverStatus", Value: 1}}).Decode(&status)
+	if err != nil {
+		return false, fmt.Errorf("error running serverStatus: %w", err)
+	}
+
+	active := status.GlobalLock.ActiveClients
+	return active.Readers+active.Writers > 0, nil
+}
+
+// compactPeriodically compacts user collections every
+// Options.CompactOnInterval until done is closed
+func (s *Server) compactPeriodically(done <-chan struct{}) {
+	ticker := time.NewTicker(s.opts.CompactOnInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		err := s.compactUserCollections(ctx)
+		stopped := ctx.Err() != nil
+		cancel()
+		if err != nil && !stopped {
+			s.logger.Warnf("error compacting collections: %s", err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package memongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestCompactableCollections(t *testing.T) {
+	specs := []mongo.CollectionSpecification{
+		{Name: "users", Type: "collection"},
+		{Name: "active_users", Type: "view"},
+		{Name: "metrics", Type: "timeseries"},
+		{Name: "system.buckets.metrics", Type: "collection"},
+		{Name: "system.views", Type: "collection"},
+		{Name: "orders", Type: "collection"},
+	}
+
+	assert.Equal(t, []string{"users", "orders"}, compactableCollections(specs))
+	assert.Empty(t, compactableCollections(nil))
+}
+
+func TestCompactNeedsWiredTiger(t *testing.T) {
+	server := &Server{storageEngine: "ephemeralForTest"}
+	err := server.Compact(context.Background(), "app", "users")
+	assert.EqualError(t, err, "cannot compact app.users: compact needs the wiredTiger storage engine, but the server uses ephemeralForTest")
+}
+
+func TestValidateCompactOnInterval(t *testing.T) {
+	assert.EqualError(t, (&Options{MongodBin: "/bin/true", CompactOnInterval: -time.Second}).Validate(),
+		"invalid CompactOnInterval -1s: must not be negative")
+	assert.NoError(t, (&Options{MongodBin: "/bin/true", CompactOnInterval: time.Hour}).Validate())
+}
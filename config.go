@@ -1,35 +1,178 @@
 package memongo
 
 import (
+	"context"
+	"crypto/rand"
 	"fmt"
 	"log"
+	"math/big"
 	"net"
 	"os"
-	"path"
+	"regexp"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/100mslive/memongo/v2/memongolog"
 	"github.com/100mslive/memongo/v2/mongobin"
+	"github.com/100mslive/memongo/v2/retry"
 )
 
-// Options is the configuration options for a launched MongoDB binary
+// Options is the configuration options for a launched MongoDB binary.
+// StartWithOptions doesn't modify them, so one Options value can be shared by
+// any number of servers; Server.EffectiveOptions returns the values a server
+// was started with.
 type Options struct {
 	// ShouldUseReplica indicates whether a replica should be used. If this is not specified,
 	// no replica will be used and mongo server will be run as standalone.
 	ShouldUseReplica bool
 
 	// ReplicaSetName is the name of the replica set. Defaults to "rs0".
-	// Only used when ShouldUseReplica is true.
+	// Only used when ShouldUseReplica is true. It may contain letters, digits,
+	// '-', '_' and '.'.
 	ReplicaSetName string
 
+	// ReplicaMembers is the number of mongods in the replica set, each with
+	// its own port and data directory, for testing elections, failover and
+	// read preference routing. Defaults to 1. The set is initiated with all
+	// of them, and member 0, which initiates it, is its first primary. With
+	// more than one member, URI names every member and the set. StartupTimeout
+	// bounds starting all of them, not each one. Members past the seventh
+	// don't vote, as MongoDB allows at most seven voting members. Requires
+	// ShouldUseReplica.
+	ReplicaMembers int
+
+	// ReplicaSetReadyTimeout bounds how long initiating the replica set and
+	// waiting for a primary may take, including retries. Defaults to
+	// StartupTimeout.
+	ReplicaSetReadyTimeout time.Duration
+
+	// ReplicaSetRetryPolicy decides how long to wait between retries of
+	// replSetInitiate and replSetReconfig when they fail transiently, within
+	// ReplicaSetReadyTimeout. Defaults to an exponential backoff from 50ms to
+	// 1s.
+	ReplicaSetRetryPolicy retry.Policy
+
+	// DeferReplicaSetInitiation starts mongod as a replica set member, but
+	// doesn't initiate the set, so it can be initiated later with a custom
+	// configuration by Server.InitiateReplicaSet. Requires ShouldUseReplica.
+	DeferReplicaSetInitiation bool
+
+	// ReplicaMemberPorts pins each replica set member to a port, in member
+	// order, instead of picking free ports. It must have an element for each
+	// of the ReplicaMembers when set; the first port is used like Port.
+	// Starting fails with ErrPortInUse if a pinned port is taken.
+	ReplicaMemberPorts []int
+
+	// ReplicaMemberTags sets the replica set tags of each member, in member
+	// order, for testing read preference tag routing. Like ReplicaMemberPorts,
+	// it must have an element for each of the ReplicaMembers when set.
+	ReplicaMemberTags []map[string]string
+
+	// ReplicaMemberVersions sets the MongoDB version of each replica set
+	// member, in member order, for testing the mixed-version window of an
+	// upgrade. Each version is downloaded. Like ReplicaMemberPorts, it must
+	// have an element for each of the ReplicaMembers when set, and the first
+	// version is used like MongoVersion; it must be of the oldest release, as
+	// the set starts with that member's feature compatibility version.
+	// Members added with AddReplicaMember pick theirs with
+	// MemberOptions.Version. Versions more than one major release apart are
+	// rejected, as MongoDB doesn't support them in one replica set.
+	ReplicaMemberVersions []string
+
+	// ReplicaSetKeyFile is an existing keyfile the replica set members
+	// authenticate to each other with when Auth is set, instead of one
+	// memongo generates, so that mongods started elsewhere can join with
+	// AddExternalMember. It's left in place by Stop.
+	ReplicaSetKeyFile string
+
+	// AdvertisedReplicaHost is the host the replica set configuration names
+	// the server by, instead of the address clients connect to, so that
+	// members on other hosts or in containers can reach it. mongod listens
+	// on it as well as on localhost, so it must resolve to this machine.
+	AdvertisedReplicaHost string
+
+	// Sharded starts a sharded cluster instead of a single mongod: a config
+	// server, NumShards shards, each a single-member replica set with its own
+	// port and data directory, and a mongos routing to them, which URI points
+	// at and which listens on Port. mongos comes from the same download as
+	// mongod, or from next to MongodBin. Stop shuts down the mongos, then the
+	// shards, then the config server. See Server.ShardCollection. Can't be
+	// used with ShouldUseReplica, Auth, ReadOnly or CompactOnInterval.
+	Sharded bool
+
+	// NumShards is the number of shards a Sharded cluster has. Defaults to
+	// 1. Requires Sharded.
+	NumShards int
+
+	// MongosCount is the number of mongos routers a Sharded cluster has, for
+	// testing how clients fail over between them. The first listens on Port
+	// and the others on ports of their own. URI names them all. Defaults to
+	// 1. See Server.MongosURIs and Server.StopMongos. Requires Sharded.
+	MongosCount int
+
+	// EnableBalancer leaves the balancer of a Sharded cluster running. By
+	// default it's stopped once the shards are added, along with
+	// auto-splitting on versions that have it, so chunks only split or move
+	// when a test asks with Server.SplitAt or Server.MoveChunk, and counts
+	// don't change mid-test. Server.DisableBalancer stops it later. Requires
+	// Sharded.
+	EnableBalancer bool
+
+	// AllowDirectShardAccess lets tests reach past mongos to the config
+	// server and the shards of a Sharded cluster, with Server.ConfigClient,
+	// Server.ShardReplicaSets and the like, e.g. to check config.chunks.
+	// Writing to them directly bypasses mongos and can corrupt the
+	// cluster's sharding metadata. Requires Sharded.
+	AllowDirectShardAccess bool
+
 	// Port to run MongoDB on. If this is not specified, a random (OS-assigned)
 	// port will be used
 	Port int
 
+	// PreferHostname makes URIs use localhost rather than the literal
+	// address mongod listens on, e.g. 127.0.0.1. Names are more readable, but
+	// on machines where localhost resolves to an address mongod doesn't
+	// listen on first, connecting may fail.
+	PreferHostname bool
+
+	// EnableIPv6 makes mongod listen on the IPv6 loopback address ::1 as
+	// well as 127.0.0.1
+	EnableIPv6 bool
+
+	// PortRange restricts the automatically chosen port to the inclusive
+	// range [PortRange[0], PortRange[1]], e.g. for CI agents whose firewall
+	// only opens some ports. Ignored if Port is given. Can also be set with
+	// MEMONGO_PORT_RANGE="20000-20100".
+	PortRange [2]int
+
+	// PortReservation is a port leased from an external port manager, which
+	// memongo runs mongod on instead of picking a port itself. It's released
+	// once mongod has bound it, or once starting fails. Cannot be used with
+	// ReplicaMemberPorts.
+	PortReservation PortReservation
+
+	// PortReservationProvider leases a port for each mongod memongo starts
+	// without a port given, including the replica set members added by
+	// Server.AddReplicaMember, so they're all coordinated with an external
+	// port manager. FreePorts adapts memongo's own port picking.
+	PortReservationProvider PortReservationProvider
+
+	// DownloadOptions configures where mongod is downloaded from and
+	// cached: CachePath, DownloadURL, Offline, Downloader,
+	// RequireExactDistroMatch, and the shared ReadOnlyCachePaths with
+	// CopyFromReadOnlyCache. CachePath defaults to the system cache
+	// location, and DownloadURL to the build of MongoVersion for this
+	// platform. The fields of the same names on Options, which predate it,
+	// take precedence where they're set.
+	DownloadOptions
+
 	// Path to the cache for downloaded mongod binaries. Defaults to the
 	// system cache location.
+	//
+	// Deprecated: use DownloadOptions.CachePath.
 	CachePath string
 
 	// If DownloadURL and MongodBin are not given, this version of MongoDB will
@@ -38,21 +181,111 @@ type Options struct {
 
 	// If given, mongod will be downloaded from this URL instead of the
 	// auto-detected URL based on the current platform and MongoVersion
+	//
+	// Deprecated: use DownloadOptions.DownloadURL.
 	DownloadURL string
 
 	// If given, this binary will be run instead of downloading a mongod binary
 	MongodBin string
 
+	// DynamicLinkerPath runs mongod through this dynamic linker (e.g. the
+	// glibc ld-linux-x86-64.so.2 of a Nix store), for systems like NixOS
+	// where the linker the downloaded binaries expect doesn't exist. Can also
+	// be set with MEMONGO_DYNAMIC_LINKER.
+	DynamicLinkerPath string
+
+	// If set, never download mongod: starting fails unless the binary is
+	// already in the cache (or MongodBin is given).
+	//
+	// Deprecated: use DownloadOptions.Offline.
+	Offline bool
+
+	// Directory to create temporary files (the dbpath and keyfiles) in.
+	// Defaults to the system temp directory.
+	TempDirBase string
+
+	// Directory to create the data directories in instead of TempDirBase,
+	// such as a scratch disk with more room than a tmpfs /tmp. Each server
+	// still gets its own uniquely named directory in it, which
+	// Server.DBPath returns. It must exist and be writable.
+	DataDir string
+
+	// Diskless keeps the server's data off the disk, for tests that only
+	// connect or never write much, so there's no data directory to write
+	// and remove. With a MongoDB Enterprise mongod, it uses the inMemory
+	// storage engine. Otherwise, the data directory is put on a tmpfs, such
+	// as DataDir or TempDirBase if it's one, or /dev/shm, and the WiredTiger cache is
+	// the smallest mongod accepts unless it's set; without a tmpfs, a
+	// warning is logged and the data directory is on disk as usual.
+	// Server.IsDiskless reports which was done. Can't be used with
+	// CompactOnInterval, or with Cleanup.RemoveDBPath set to CleanupNever.
+	Diskless bool
+
+	// If set, don't check that the dbpath is on a filesystem mongod can
+	// lock files on. Starting fails early with ErrUnsuitableFilesystem on
+	// NFS, SMB and similar network and virtual filesystems otherwise.
+	SkipFilesystemCheck bool
+
 	// Logger for printing messages. Defaults to printing to stdout.
 	Logger *log.Logger
 
 	// A LogLevel to log at. Defaults to LogLevelInfo.
 	LogLevel memongolog.LogLevel
 
+	// LogFormat is how messages are written: memongolog.FormatText,
+	// FormatPretty (colored, for terminals) or FormatJSON (one object per
+	// line, for CI). Defaults to FormatPretty if Logger isn't set and stdout
+	// is a terminal, and FormatText otherwise. Can also be set with
+	// MEMONGO_LOG_FORMAT=text|pretty|json.
+	LogFormat memongolog.Format
+
+	// LogSummaryOnly holds back the messages logged while the server starts,
+	// and logs a single line from its StartReport at the info level instead,
+	// e.g. "mongod 8.0.0 ready at mongodb://127.0.0.1:54321 (cache hit,
+	// 1.8s)". If starting fails, the held messages are written after all.
+	LogSummaryOnly bool
+
+	// DisableRedaction keeps passwords, URI credentials and Authorization
+	// headers in log messages, errors and RecordTo recordings, which are
+	// otherwise masked (see RedactURI and RedactCommand). It's for debugging
+	// locally; don't set it where output may be shared.
+	DisableRedaction bool
+
 	// How long to wait for mongod to start up and report a port number. Does
 	// not include download time, only startup time. Defaults to 10 seconds.
 	StartupTimeout time.Duration
 
+	// AdaptiveStartupTimeout makes StartupTimeout the longest mongod may go
+	// without logging progress while it starts up, e.g. through recovery,
+	// index builds or initial sync, rather than a fixed deadline. Starting
+	// still fails after StartupHardTimeout.
+	AdaptiveStartupTimeout bool
+
+	// StartupHardTimeout bounds how long startup may take in total with
+	// AdaptiveStartupTimeout. Defaults to 2 minutes.
+	StartupHardTimeout time.Duration
+
+	// Clock, if given, decides when StartupTimeout and StartupHardTimeout
+	// expire, for testing timeout handling deterministically; see
+	// memongotest.FakeClock
+	Clock Clock
+
+	// SRVDomain, if set, makes memongo serve DNS SRV and TXT records for the
+	// domain, e.g. "memongo.test", from a DNS server on 127.0.0.1, so clients
+	// can connect with Server.SRVURI. See Server.SRVClientOptions.
+	SRVDomain string
+
+	// SRVDNSPort is the UDP port of the DNS server for SRVDomain. Defaults to
+	// a free port.
+	SRVDNSPort int
+
+	// Once mongod reports that it's listening, memongo dials its port until
+	// a connection succeeds, backing off exponentially between attempts up
+	// to StartupPollInterval. Each attempt waits up to StartupDialTimeout.
+	// Default to 100 milliseconds and 1 second.
+	StartupPollInterval time.Duration
+	StartupDialTimeout  time.Duration
+
 	// If set, pass the --auth flag to mongod. This will allow tests to setup
 	// authentication.
 	Auth bool
@@ -61,10 +294,639 @@ type Options struct {
 	// This is useful to limit memory usage in test environments.
 	// Only applies when using WiredTiger storage engine (MongoDB 7.0+ or replica sets).
 	// If not set, MongoDB uses its default (typically 50% of RAM minus 1GB).
+	// mongod needs at least 0.25.
 	WiredTigerCacheSizeGB float64
+
+	// WiredTigerCacheSizePct sets the maximum size of the WiredTiger cache as
+	// a percentage of the system's memory, up to 80. MongoDB 8.0 takes it
+	// as is; for earlier versions the size in GB is worked out from the
+	// memory memongo detects. Can't be used with WiredTigerCacheSizeGB.
+	WiredTigerCacheSizePct float64
+
+	// HealthHTTPAddr, if given, is an address (e.g. "localhost:8081") on which
+	// memongo serves HTTP probes for processes that don't speak the MongoDB
+	// wire protocol: /ready returns 200 once the server is ready to accept
+	// connections (503 before that and after Stop), /live returns 200 while
+	// the mongod process is running, and /info returns a JSON document with
+	// the uri, version and replicaSet of the server.
+	HealthHTTPAddr string
+
+	// If set, failing to listen on HealthHTTPAddr fails startup. Otherwise a
+	// warning is logged and the server starts without health probes.
+	HealthHTTPStrict bool
+
+	// AdminUIAddr, if given, is an address (e.g. "localhost:8082") on which
+	// memongo serves a small admin page for a server shared during local
+	// development: its status, URI, databases and collections with document
+	// counts, the recent output of mongod, and a button to stop it. Every
+	// request needs a random token, which is logged at startup as part of
+	// the page's URL; see Server.AdminUIURL.
+	AdminUIAddr string
+
+	// DebugBundleOnFailure, if given, is a directory Stop writes a debug
+	// bundle into, as Server.WriteDebugBundle does, if the server failed in
+	// the sense of CleanupOnSuccess. It's named after the data directory.
+	DebugBundleOnFailure string
+
+	// DebugBundleDiagnosticDataBytes, if positive, makes debug bundles
+	// include mongod's diagnostic.data directory, skipping files that would
+	// take it past this many bytes
+	DebugBundleDiagnosticDataBytes int64
+
+	// EventSink, if given, is called synchronously with every lifecycle event
+	// of the server. It's an alternative to Server.Events() that also sees
+	// events from a failed startup, and never drops events.
+	EventSink func(Event)
+
+	// EnableTestCommands starts mongod with enableTestCommands=1, which the
+	// failpoint helpers like Server.InjectCommandError need
+	EnableTestCommands bool
+
+	// CursorTimeout is how long idle cursors live before the server closes
+	// them (cursorTimeoutMillis). The server checks for idle cursors every
+	// second when it's set, rather than every 4 seconds. Defaults to the
+	// server's 10 minutes.
+	CursorTimeout time.Duration
+
+	// ReadOnly makes the server reject writes once it's started and seeded,
+	// like a database in a maintenance window: inserts, updates and deletes
+	// fail with NotWritablePrimary, while reads work. It runs the server as
+	// a replica set whose only member is stepped down to a secondary, so it
+	// implies ShouldUseReplica.
+	ReadOnly bool
+
+	// TTLMonitorInterval is how often the TTL monitor deletes expired
+	// documents (ttlMonitorSleepSecs), in whole seconds. Set it to a second
+	// to use Server.TriggerTTLPass. Defaults to the server's 60 seconds.
+	TTLMonitorInterval time.Duration
+
+	// ClockWrapper, if given, runs mongod with a skewed or accelerated clock
+	// using libfaketime. Linux only.
+	ClockWrapper *ClockWrapper
+
+	// EnforceMaxTimeMS makes every operation that sets maxTimeMS fail with
+	// MaxTimeMSExpired, however quickly it runs, so tests of timeout handling
+	// don't depend on timing. It turns on enableTestCommands.
+	EnforceMaxTimeMS bool
+
+	// CaptureCommands records the commands sent by the client returned by
+	// Server.Client, for Server.CapturedCommands
+	CaptureCommands bool
+
+	// Seed is a list of collections to create and fill once the server is
+	// up, before StartWithOptions returns. See Server.Seed.
+	Seed []SeedCollection
+
+	// SeedDir is a directory of JSON files to seed the server from, after
+	// Seed. See LoadSeedDir for the layout.
+	SeedDir string
+
+	// SeedGenerated is a list of collections to fill with documents generated
+	// from GeneratorSeed, after Seed and SeedDir. Generated documents are
+	// always inserted in bulk. See Server.SeedGenerated.
+	SeedGenerated []GeneratedCollection
+
+	// GeneratorSeed seeds the generator of SeedGenerated's documents. The
+	// same seed always gives the same documents.
+	GeneratorSeed int64
+
+	// SeedBulkOptions, if given, makes Seed and SeedDir insert documents in
+	// bulk, as Server.SeedBulk does, and configures how SeedGenerated's
+	// documents are inserted
+	SeedBulkOptions *SeedBulkOptions
+
+	// StartRetries is how many more times to try starting mongod if it fails
+	// for a transient reason: ErrStartupTimeout, ErrPortInUse or
+	// mongobin.ErrTransientDownload. Each failed attempt is cleaned up before
+	// the next, and an automatically chosen port is replaced. Other errors,
+	// such as invalid options or mongod rejecting its configuration, are
+	// never retried. Defaults to 0.
+	StartRetries int
+
+	// StartRetryPolicy decides how long to wait before each of the
+	// StartRetries retries, and may give up sooner. By default, starting is
+	// retried at once.
+	StartRetryPolicy retry.Policy
+
+	// StartConcurrency limits how many mongod processes may be starting at
+	// once in this process, counting this one, overriding
+	// SetMaxConcurrentStarts. Starting waits for a slot after mongod is
+	// downloaded. Defaults to 0, for the SetMaxConcurrentStarts limit.
+	StartConcurrency int
+
+	// MaxDBPathBytes limits the size of the data directory, so a runaway
+	// test can't fill the disk. It's checked every second; the first time
+	// it's exceeded, OnQuotaExceeded is called with an error wrapping
+	// ErrDiskQuotaExceeded. 0 means no limit.
+	MaxDBPathBytes int64
+
+	// OnQuotaExceeded is called when the data directory grows past
+	// MaxDBPathBytes
+	OnQuotaExceeded func(error)
+
+	// StopOnQuotaExceeded stops the server when the data directory grows past
+	// MaxDBPathBytes
+	StopOnQuotaExceeded bool
+
+	// CompactOnInterval, if given, runs compact on every collection outside
+	// the admin, local and config databases this often, so a long-lived
+	// server doesn't keep the space of deleted documents. Runs are skipped
+	// while clients are reading or writing. Needs the wiredTiger storage
+	// engine. See Server.Compact.
+	CompactOnInterval time.Duration
+
+	// EnvDatabase is the database Server.Env and Server.WriteEnvFile export
+	// as MONGODB_DATABASE, for processes that take their database name
+	// separately from the URI. It's left out if empty.
+	EnvDatabase string
+
+	// KeepEnvFiles keeps the files written by Server.WriteEnvFile when the
+	// server is stopped, rather than removing them
+	KeepEnvFiles bool
+
+	// Cleanup configures what Stop removes. By default, everything is
+	// removed.
+	Cleanup Cleanup
+
+	// Strict makes Validate fail with ErrIgnoredOption if an option is
+	// given that has no effect with the others, such as ReplicaSetName
+	// without ShouldUseReplica, rather than logging a warning and listing
+	// it in StartReport.IgnoredOptions. Options that depend on the MongoDB
+	// version, when only MongodBin gives it, make starting fail instead. Can
+	// also be set with MEMONGO_STRICT=1.
+	Strict bool
+
+	// resolvedCacheSizeGB is the cache size worked out from
+	// WiredTigerCacheSizePct, for versions that don't accept it
+	resolvedCacheSizeGB float64
+
+	// portAllocated is set if fillDefaults picked Port, so a retried start
+	// can pick another one
+	portAllocated bool
+
+	// mongodBinFromEnv is set if fillDefaults took MongodBin from
+	// MEMONGO_MONGOD_BIN
+	mongodBinFromEnv bool
+
+	// portLease is the reservation Port was leased with, from
+	// PortReservation or PortReservationProvider
+	portLease *portLease
+
+	// ignored are the options fillDefaults found to be ignored, and
+	// defaulted is set once it has filled in defaults, which would otherwise
+	// count as given if the options were used again
+	ignored   []IgnoredOption
+	defaulted bool
+
+	// disklessStrategy is how a Diskless server keeps its data off the
+	// disk, and dbDirBase is the tmpfs its data directory is on, if any
+	disklessStrategy DisklessStrategy
+	dbDirBase        string
+
+	// clusterRole is "configsvr" or "shardsvr" for the mongods of a Sharded
+	// cluster, which are started with it as a flag
+	clusterRole string
+
+	// downloadCandidates are the downloads resolveDownloadURL found for
+	// MongoVersion, best first, of which DownloadURL is the first until
+	// resolveDownloadCandidate picks one that exists
+	downloadCandidates []mongobin.Candidate
+}
+
+// Validate checks that the options describe a server memongo can start,
+// returning an error with the reason if they don't. StartWithOptions calls
+// Validate, but it can also be called up front to fail fast.
+func (opts *Options) Validate() error {
+	err := opts.validateSettings()
+	if err != nil {
+		return err
+	}
+
+	return opts.validateDownload()
+}
+
+// validateSettings checks the options that don't depend on the platform
+func (opts *Options) validateSettings() error {
+	if opts.MongoVersion != "" {
+		_, err := capabilitiesForVersion(opts.MongoVersion)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := opts.validateDownloadOptions()
+	if err != nil {
+		return err
+	}
+
+	if opts.Strict && !opts.defaulted {
+		err := ignoredOptionsError(opts.ignoredOptions(opts.knownCapabilities(), false))
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.DeferReplicaSetInitiation && !opts.ShouldUseReplica {
+		return fmt.Errorf("cannot use DeferReplicaSetInitiation without ShouldUseReplica")
+	}
+
+	if opts.ReadOnly && opts.DeferReplicaSetInitiation {
+		return fmt.Errorf("cannot use ReadOnly with DeferReplicaSetInitiation")
+	}
+
+	if opts.DynamicLinkerPath != "" {
+		_, err := os.Stat(opts.DynamicLinkerPath)
+		if err != nil {
+			return fmt.Errorf("invalid DynamicLinkerPath: %w", err)
+		}
+	}
+
+	if opts.StartupHardTimeout != 0 && !opts.AdaptiveStartupTimeout {
+		return fmt.Errorf("cannot use StartupHardTimeout without AdaptiveStartupTimeout")
+	}
+
+	if opts.StartupHardTimeout < 0 || (opts.StartupHardTimeout > 0 && opts.StartupHardTimeout < opts.StartupTimeout) {
+		return fmt.Errorf("invalid StartupHardTimeout %s: must be at least StartupTimeout", opts.StartupHardTimeout)
+	}
+
+	if opts.StartRetries < 0 {
+		return fmt.Errorf("invalid StartRetries %d: must not be negative", opts.StartRetries)
+	}
+
+	if opts.SRVDomain != "" {
+		err := validateSRVDomain(opts.SRVDomain)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.SRVDNSPort != 0 && opts.SRVDomain == "" {
+		return fmt.Errorf("cannot use SRVDNSPort without SRVDomain")
+	}
+
+	if opts.SRVDNSPort < 0 || opts.SRVDNSPort > 65535 {
+		return fmt.Errorf("invalid SRVDNSPort %d: must be between 0 and 65535", opts.SRVDNSPort)
+	}
+
+	if opts.SeedBulkOptions != nil {
+		err := opts.SeedBulkOptions.validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.StartConcurrency < 0 {
+		return fmt.Errorf("invalid StartConcurrency %d: must not be negative", opts.StartConcurrency)
+	}
+
+	if opts.MaxDBPathBytes < 0 {
+		return fmt.Errorf("invalid MaxDBPathBytes %d: must not be negative", opts.MaxDBPathBytes)
+	}
+
+	if opts.StopOnQuotaExceeded && opts.MaxDBPathBytes == 0 {
+		return fmt.Errorf("cannot use StopOnQuotaExceeded without MaxDBPathBytes")
+	}
+
+	if opts.StartupPollInterval < 0 {
+		return fmt.Errorf("invalid StartupPollInterval %s: must not be negative", opts.StartupPollInterval)
+	}
+
+	if opts.StartupDialTimeout < 0 {
+		return fmt.Errorf("invalid StartupDialTimeout %s: must not be negative", opts.StartupDialTimeout)
+	}
+
+	if opts.CursorTimeout < 0 || (opts.CursorTimeout > 0 && opts.CursorTimeout < time.Millisecond) {
+		return fmt.Errorf("invalid CursorTimeout %s: must be at least 1ms", opts.CursorTimeout)
+	}
+
+	err = validateWiredTigerCacheSize(opts)
+	if err != nil {
+		return err
+	}
+
+	err = validateGeneratedCollections(opts.SeedGenerated)
+	if err != nil {
+		return err
+	}
+
+	err = opts.Cleanup.RemoveDBPath.validate()
+	if err != nil {
+		return fmt.Errorf("invalid Cleanup.RemoveDBPath: %w", err)
+	}
+
+	if opts.CompactOnInterval < 0 {
+		return fmt.Errorf("invalid CompactOnInterval %s: must not be negative", opts.CompactOnInterval)
+	}
+
+	if opts.TTLMonitorInterval < 0 || (opts.TTLMonitorInterval > 0 && opts.TTLMonitorInterval%time.Second != 0) {
+		return fmt.Errorf("invalid TTLMonitorInterval %s: must be a whole number of seconds", opts.TTLMonitorInterval)
+	}
+
+	if opts.ClockWrapper != nil {
+		err := opts.ClockWrapper.validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.ReplicaSetName != "" {
+		err := validateReplicaSetName(opts.ReplicaSetName)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Diskless {
+		err := opts.validateDiskless()
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Sharded || opts.NumShards != 0 || opts.MongosCount != 0 || opts.EnableBalancer || opts.AllowDirectShardAccess {
+		err := opts.validateSharded()
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.ReplicaMembers != 0 {
+		err := opts.validateReplicaMembers()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(opts.ReplicaMemberPorts) > 0 {
+		err := opts.validateReplicaMemberPorts()
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.PortReservation != nil {
+		err := opts.validatePortReservation()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(opts.ReplicaMemberTags) > 0 {
+		err := opts.validateReplicaMemberTags()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(opts.ReplicaMemberVersions) > 0 {
+		err := opts.validateReplicaMemberVersions()
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.ReplicaSetKeyFile != "" {
+		if !opts.ShouldUseReplica || !opts.Auth {
+			return fmt.Errorf("cannot use ReplicaSetKeyFile without ShouldUseReplica and Auth")
+		}
+		_, err := readKeyFile(opts.ReplicaSetKeyFile)
+		if err != nil {
+			return fmt.Errorf("invalid ReplicaSetKeyFile: %w", err)
+		}
+	}
+
+	if opts.AdvertisedReplicaHost != "" && !opts.ShouldUseReplica {
+		return fmt.Errorf("cannot use AdvertisedReplicaHost without ShouldUseReplica")
+	}
+
+	if opts.PortRange != [2]int{} {
+		err := validatePortRange(opts.PortRange)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateDownload checks that there's a mongod to run: either one was given,
+// or one can be downloaded for this platform
+func (opts *Options) validateDownload() error {
+	d := opts.downloadOptions()
+	needsDownload := opts.MongodBin == "" && os.Getenv("MEMONGO_MONGOD_BIN") == "" &&
+		d.DownloadURL == "" && os.Getenv("MEMONGO_DOWNLOAD_URL") == ""
+	version := opts.MongoVersion
+	if version == "" && len(opts.ReplicaMemberVersions) > 0 {
+		version = opts.ReplicaMemberVersions[0]
+	}
+
+	if needsDownload {
+		if version == "" {
+			return fmt.Errorf("one of MongoVersion, DownloadURL, or MongodBin must be given")
+		}
+
+		// Make sure there's a build of this version for the current platform.
+		// Apple Silicon always uses the x86_64 build.
+		_, err := downloadCandidates(version, d.RequireExactDistroMatch)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EffectiveOptions returns a copy of the options with environment variables
+// and defaults applied, as StartWithOptions would use them. Explicitly set
+// fields take precedence over environment variables, which take precedence
+// over the built-in defaults. Boolean environment variables can only turn
+// options on.
+//
+// If no port is given, the returned options contain a free port chosen at
+// the time of the call, unless PortReservationProvider is set: its port is
+// only leased when the server starts.
+func (opts *Options) EffectiveOptions() (*Options, error) {
+	effective := opts.clone()
+	err := effective.fillDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	return effective, nil
+}
+
+// clone returns a copy of the options that shares no slices or maps with
+// them, so defaulting and later changes to either don't affect the other.
+// Seed documents and callbacks are shared, since they're never modified.
+func (opts *Options) clone() *Options {
+	c := *opts
+
+	if opts.ReplicaMemberPorts != nil {
+		c.ReplicaMemberPorts = append([]int(nil), opts.ReplicaMemberPorts...)
+	}
+	if opts.ReadOnlyCachePaths != nil {
+		c.ReadOnlyCachePaths = append([]string(nil), opts.ReadOnlyCachePaths...)
+	}
+	if opts.ReplicaMemberVersions != nil {
+		c.ReplicaMemberVersions = append([]string(nil), opts.ReplicaMemberVersions...)
+	}
+	if opts.ReplicaMemberTags != nil {
+		c.ReplicaMemberTags = make([]map[string]string, len(opts.ReplicaMemberTags))
+		for i, tags := range opts.ReplicaMemberTags {
+			if tags == nil {
+				continue
+			}
+			c.ReplicaMemberTags[i] = make(map[string]string, len(tags))
+			for k, v := range tags {
+				c.ReplicaMemberTags[i][k] = v
+			}
+		}
+	}
+	if opts.Seed != nil {
+		c.Seed = append([]SeedCollection(nil), opts.Seed...)
+	}
+	if opts.downloadCandidates != nil {
+		c.downloadCandidates = append([]mongobin.Candidate(nil), opts.downloadCandidates...)
+	}
+	if opts.SeedGenerated != nil {
+		c.SeedGenerated = append([]GeneratedCollection(nil), opts.SeedGenerated...)
+	}
+	if opts.ClockWrapper != nil {
+		clock := *opts.ClockWrapper
+		c.ClockWrapper = &clock
+	}
+	if opts.SeedBulkOptions != nil {
+		bulk := *opts.SeedBulkOptions
+		c.SeedBulkOptions = &bulk
+	}
+
+	return &c
+}
+
+// applyEnv fills in options that weren't explicitly set from environment
+// variables. Malformed values are an error, rather than being ignored.
+func (opts *Options) applyEnv() error {
+	if opts.MongoVersion == "" {
+		opts.MongoVersion = os.Getenv("MEMONGO_MONGO_VERSION")
+	}
+
+	if opts.LogLevel == 0 {
+		if env := os.Getenv("MEMONGO_LOG_LEVEL"); env != "" {
+			level, err := parseLogLevel(env)
+			if err != nil {
+				return fmt.Errorf("error parsing MEMONGO_LOG_LEVEL: %s", err)
+			}
+			opts.LogLevel = level
+		}
+	}
+
+	if opts.LogFormat == memongolog.FormatAuto {
+		if env := os.Getenv("MEMONGO_LOG_FORMAT"); env != "" {
+			format, err := memongolog.ParseFormat(env)
+			if err != nil {
+				return fmt.Errorf("error parsing MEMONGO_LOG_FORMAT: %s", err)
+			}
+			opts.LogFormat = format
+		}
+	}
+
+	if opts.StartupTimeout == 0 {
+		if env := os.Getenv("MEMONGO_STARTUP_TIMEOUT"); env != "" {
+			timeout, err := time.ParseDuration(env)
+			if err != nil {
+				return fmt.Errorf("error parsing MEMONGO_STARTUP_TIMEOUT: %s", err)
+			}
+			opts.StartupTimeout = timeout
+		}
+	}
+
+	boolEnvs := []struct {
+		name  string
+		field *bool
+	}{
+		{"MEMONGO_SHOULD_USE_REPLICA", &opts.ShouldUseReplica},
+		{"MEMONGO_AUTH", &opts.Auth},
+		{"MEMONGO_OFFLINE", &opts.DownloadOptions.Offline},
+		{"MEMONGO_REQUIRE_EXACT_DISTRO_MATCH", &opts.DownloadOptions.RequireExactDistroMatch},
+		{"MEMONGO_STRICT", &opts.Strict},
+	}
+	for _, env := range boolEnvs {
+		value := os.Getenv(env.name)
+		if value == "" {
+			continue
+		}
+
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %q is not a boolean", env.name, value)
+		}
+		if b {
+			*env.field = true
+		}
+	}
+
+	if opts.TempDirBase == "" {
+		opts.TempDirBase = os.Getenv("MEMONGO_TMPDIR")
+	}
+
+	if opts.DynamicLinkerPath == "" {
+		opts.DynamicLinkerPath = os.Getenv("MEMONGO_DYNAMIC_LINKER")
+	}
+
+	return nil
 }
 
 func (opts *Options) fillDefaults() error {
+	err := opts.applyEnv()
+	if err != nil {
+		return err
+	}
+
+	err = opts.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = checkDataDir(opts.DataDir)
+	if err != nil {
+		return err
+	}
+
+	if !opts.defaulted {
+		opts.ignored = opts.ignoredOptions(opts.knownCapabilities(), false)
+	}
+
+	if opts.MongoVersion == "" && len(opts.ReplicaMemberVersions) > 0 {
+		opts.MongoVersion = opts.ReplicaMemberVersions[0]
+	}
+
+	if opts.StartupTimeout == 0 {
+		opts.StartupTimeout = 10 * time.Second
+	}
+
+	if opts.ReplicaSetReadyTimeout == 0 {
+		opts.ReplicaSetReadyTimeout = opts.StartupTimeout
+	}
+
+	if opts.StartupPollInterval == 0 {
+		opts.StartupPollInterval = defaultStartupPollInterval
+	}
+
+	if opts.StartupDialTimeout == 0 {
+		opts.StartupDialTimeout = defaultStartupDialTimeout
+	}
+
+	if opts.AdaptiveStartupTimeout && opts.StartupHardTimeout == 0 {
+		opts.StartupHardTimeout = defaultStartupHardTimeout
+		if opts.StartupHardTimeout < opts.StartupTimeout {
+			opts.StartupHardTimeout = opts.StartupTimeout
+		}
+	}
+
+	if opts.ReadOnly {
+		opts.ShouldUseReplica = true
+	}
+
 	// Set default replica set name
 	if opts.ReplicaSetName == "" {
 		opts.ReplicaSetName = "rs0"
@@ -72,53 +934,44 @@ func (opts *Options) fillDefaults() error {
 
 	if opts.MongodBin == "" {
 		opts.MongodBin = os.Getenv("MEMONGO_MONGOD_BIN")
+		opts.mongodBinFromEnv = opts.MongodBin != ""
 	}
 	if opts.MongodBin == "" {
 		// The user didn't give us a local path to a binary. That means we need
 		// a download URL and a cache path.
-
-		// Determine the cache path
-		if opts.CachePath == "" {
-			opts.CachePath = os.Getenv("MEMONGO_CACHE_PATH")
-		}
-		if opts.CachePath == "" && os.Getenv("XDG_CACHE_HOME") != "" {
-			opts.CachePath = path.Join(os.Getenv("XDG_CACHE_HOME"), "memongo")
+		opts.resolveCachePath()
+		err := opts.resolveDownloadURL()
+		if err != nil {
+			return err
 		}
-		if opts.CachePath == "" {
-			if runtime.GOOS == "darwin" {
-				opts.CachePath = path.Join(os.Getenv("HOME"), "Library", "Caches", "memongo")
-			} else {
-				opts.CachePath = path.Join(os.Getenv("HOME"), ".cache", "memongo")
+	}
+
+	// Determine the port number. Pinned member ports must be free, since
+	// picking another port would defeat the point of pinning them.
+	if len(opts.ReplicaMemberPorts) > 0 {
+		for _, port := range opts.ReplicaMemberPorts {
+			err := checkPortFree(port)
+			if err != nil {
+				return err
 			}
 		}
 
-		// Determine the download URL
-		if opts.DownloadURL == "" {
-			opts.DownloadURL = os.Getenv("MEMONGO_DOWNLOAD_URL")
-		}
-		if opts.DownloadURL == "" {
-			if opts.MongoVersion == "" {
-				return fmt.Errorf("one of MongoVersion, DownloadURL, or MongodBin must be given")
-			}
+		opts.Port = opts.ReplicaMemberPorts[0]
+	}
 
-			// Auto-detect Apple Silicon and use x86_64 binary via Rosetta 2
-			if runtime.GOOS == "darwin" && runtime.GOARCH == "arm64" {
-				opts.DownloadURL = getAppleSiliconDownloadURL(opts.MongoVersion)
-			} else {
-				spec, err := mongobin.MakeDownloadSpec(opts.MongoVersion)
-				if err != nil {
-					return err
-				}
-				opts.DownloadURL = spec.GetDownloadURL()
-			}
-		}
+	if opts.PortReservation != nil && opts.portLease == nil {
+		opts.portLease = &portLease{reservation: opts.PortReservation}
+		opts.Port = opts.PortReservation.Port()
 	}
 
-	// Determine the port number
-	if opts.Port == 0 {
-		mongoVersionEnv := os.Getenv("MEMONGO_MONGOD_PORT")
-		if mongoVersionEnv != "" {
-			port, err := strconv.Atoi(mongoVersionEnv)
+	// A port from PortReservationProvider is leased when starting, so
+	// EffectiveOptions doesn't lease one it never releases
+	leased := opts.PortReservationProvider != nil
+
+	if opts.Port == 0 && !leased {
+		portEnv := os.Getenv("MEMONGO_MONGOD_PORT")
+		if portEnv != "" {
+			port, err := strconv.Atoi(portEnv)
 
 			if err != nil {
 				return fmt.Errorf("error parsing MEMONGO_MONGOD_PORT: %s", err)
@@ -128,38 +981,522 @@ func (opts *Options) fillDefaults() error {
 		}
 	}
 
-	if opts.Port == 0 {
-		port, err := getFreePort()
+	if opts.Port == 0 && opts.PortRange == [2]int{} && !leased {
+		portRangeEnv := os.Getenv("MEMONGO_PORT_RANGE")
+		if portRangeEnv != "" {
+			portRange, err := parsePortRange(portRangeEnv)
+			if err != nil {
+				return fmt.Errorf("error parsing MEMONGO_PORT_RANGE: %s", err)
+			}
+
+			opts.PortRange = portRange
+		}
+	}
+
+	if opts.Port == 0 && !leased {
+		port, err := allocatePort(opts.PortRange)
 		if err != nil {
-			return fmt.Errorf("error finding a free port: %s", err)
+			return fmt.Errorf("error finding a free port: %w", err)
 		}
 
 		opts.Port = port
-
-		if opts.StartupTimeout == 0 {
-			opts.StartupTimeout = 10 * time.Second
-		}
+		opts.portAllocated = true
 	}
 
+	opts.defaulted = true
+
 	return nil
 }
 
 func (opts *Options) getLogger() *memongolog.Logger {
-	return memongolog.New(opts.Logger, opts.LogLevel)
+	logger := memongolog.NewWithFormat(opts.Logger, opts.LogLevel, opts.LogFormat)
+	if !opts.DisableRedaction {
+		logger = logger.WithRedaction(redactText)
+	}
+
+	return logger
 }
 
-func (opts *Options) getOrDownloadBinPath() (string, error) {
+// downloadLocks holds a lock per download URL and cache path, so servers
+// starting concurrently with a cold cache only download once. Each lock is a
+// channel with room for one token, so waiting for it can be given up.
+var downloadLocks sync.Map
+
+// lockDownload waits for the lock on d's download, or until ctx is done. It
+// returns the function that releases the lock.
+func lockDownload(ctx context.Context, d DownloadOptions) (func(), error) {
+	lock, _ := downloadLocks.LoadOrStore(d.DownloadURL+"\x00"+d.CachePath, make(chan struct{}, 1))
+	ch := lock.(chan struct{})
+	select {
+	case ch <- struct{}{}:
+		return func() { <-ch }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("gave up waiting for another download of %s: %w", d.DownloadURL, ctx.Err())
+	}
+}
+
+// resolveDownloadURL defaults DownloadOptions.DownloadURL from the
+// environment, or to the best candidate download of MongoVersion for this
+// platform. Which candidate actually exists is only checked when mongod is
+// downloaded, by resolveDownloadCandidate.
+func (opts *Options) resolveDownloadURL() error {
+	d := opts.downloadOptions()
+	if d.DownloadURL == "" {
+		d.DownloadURL = os.Getenv("MEMONGO_DOWNLOAD_URL")
+	}
+	opts.setDownloadOptions(d)
+	if d.DownloadURL != "" {
+		return nil
+	}
+	if opts.MongoVersion == "" {
+		return fmt.Errorf("one of MongoVersion, DownloadURL, or MongodBin must be given")
+	}
+
+	return opts.useDownloadCandidates(opts.MongoVersion)
+}
+
+// useDownloadCandidates sets DownloadOptions.DownloadURL to the best
+// candidate download of version for this platform, and keeps the others to
+// fall back to
+func (opts *Options) useDownloadCandidates(version string) error {
+	d := opts.downloadOptions()
+	candidates, err := downloadCandidates(version, d.RequireExactDistroMatch)
+	if err != nil {
+		return err
+	}
+	d.DownloadURL = candidates[0].URL
+	opts.setDownloadOptions(d)
+	opts.downloadCandidates = candidates
+
+	return nil
+}
+
+// downloadCandidates returns the candidate downloads of version for this
+// platform, best first. With exact, the fallbacks are left out, and it's an
+// error if there's no exact match.
+func downloadCandidates(version string, exact bool) ([]mongobin.Candidate, error) {
+	// Auto-detect Apple Silicon and use x86_64 binary via Rosetta 2
+	if runtime.GOOS == "darwin" && runtime.GOARCH == "arm64" {
+		return []mongobin.Candidate{{URL: getAppleSiliconDownloadURL(version)}}, nil
+	}
+
+	candidates, err := mongobin.DownloadCandidates(version)
+	if err != nil {
+		return nil, err
+	}
+	if !exact {
+		return candidates, nil
+	}
+
+	return exactCandidates(version, candidates)
+}
+
+// exactCandidates returns the candidates that aren't fallbacks, or an error
+// listing the fallbacks if there are none
+func exactCandidates(version string, candidates []mongobin.Candidate) ([]mongobin.Candidate, error) {
+	var exact []mongobin.Candidate
+	var fallbacks []string
+	for _, c := range candidates {
+		if c.Fallback == "" {
+			exact = append(exact, c)
+		} else {
+			fallbacks = append(fallbacks, c.Fallback)
+		}
+	}
+	if len(exact) == 0 {
+		return nil, fmt.Errorf("there's no MongoDB %s build for this exact platform, and RequireExactDistroMatch is set; the fallbacks would be: %s", version, strings.Join(fallbacks, ", "))
+	}
+
+	return exact, nil
+}
+
+// resolveDownloadCandidate picks the download to use from the candidates
+// resolveDownloadURL found: the first that's already cached, or else the
+// first that exists on the download server. It logs a warning if that's a
+// fallback.
+func (opts *Options) resolveDownloadCandidate(ctx context.Context, logger *memongolog.Logger) error {
+	if len(opts.downloadCandidates) == 0 {
+		return nil
+	}
+
+	d := opts.downloadOptions()
+	var chosen *mongobin.Candidate
+	for i, c := range opts.downloadCandidates {
+		cached, err := mongobin.IsCached(DownloadOptions{CachePath: d.CachePath, DownloadURL: c.URL, ReadOnlyCachePaths: d.ReadOnlyCachePaths})
+		if err != nil {
+			return err
+		}
+		if cached {
+			chosen = &opts.downloadCandidates[i]
+			break
+		}
+	}
+
+	switch {
+	case chosen != nil:
+	case len(opts.downloadCandidates) == 1 || d.Offline:
+		// There's nothing to choose between, or we can't check. Offline
+		// reports the first candidate as missing from the cache.
+		chosen = &opts.downloadCandidates[0]
+	default:
+		c, err := mongobin.DefaultResolver.ResolveContext(ctx, opts.downloadCandidates)
+		if err != nil {
+			return err
+		}
+		chosen = &c
+	}
+
+	if chosen.Fallback != "" {
+		logger.Warnf("No exact MongoDB build for this platform; using the %s from %s", chosen.Fallback, chosen.URL)
+	}
+	d.DownloadURL = chosen.URL
+	opts.setDownloadOptions(d)
+	opts.downloadCandidates = nil
+
+	return nil
+}
+
+// getOrDownloadBinPath returns the path to mongod, and whether it was found
+// in the download cache
+func (opts *Options) getOrDownloadBinPath(ctx context.Context, events *eventBus, logger *memongolog.Logger) (string, bool, error) {
 	if opts.MongodBin != "" {
-		return opts.MongodBin, nil
+		return opts.MongodBin, false, nil
+	}
+
+	err := opts.resolveDownloadCandidate(ctx, logger)
+	if err != nil {
+		return "", false, err
+	}
+
+	d := opts.downloadOptions()
+	unlock, err := lockDownload(ctx, d)
+	if err != nil {
+		return "", false, err
 	}
+	defer unlock()
 
-	// Download or fetch from cache
-	binPath, err := mongobin.GetOrDownloadMongod(opts.DownloadURL, opts.CachePath, opts.getLogger())
+	cached, err := mongobin.IsCached(d)
 	if err != nil {
-		return "", err
+		return "", false, err
+	}
+	downloading := !cached && !d.Offline
+	if downloading {
+		events.emit(EventDownloadStarted, 0, nil)
+	}
+
+	// Download or fetch from cache. Offline fails here if it isn't cached.
+	binPath, err := mongobin.GetOrDownloadContext(ctx, d, logger)
+	if downloading {
+		events.emit(EventDownloadFinished, 0, err)
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return binPath, cached, nil
+}
+
+// defaultStartupHardTimeout is the default Options.StartupHardTimeout
+const defaultStartupHardTimeout = 2 * time.Minute
+
+// defaultStartupPollInterval and defaultStartupDialTimeout are the defaults
+// of Options.StartupPollInterval and Options.StartupDialTimeout
+const (
+	defaultStartupPollInterval = 100 * time.Millisecond
+	defaultStartupDialTimeout  = time.Second
+)
+
+// portReservationTTL is how long a port handed out by allocatePort isn't
+// handed out again. A free port is found by listening on it and closing the
+// listener, so until mongod binds it, another server starting concurrently
+// could be given the same port.
+const portReservationTTL = time.Minute
+
+// maxPortAttempts is how many free ports allocatePort tries before giving up
+// because they're all reserved
+const maxPortAttempts = 100
+
+var (
+	reservedPortsMu sync.Mutex
+	reservedPorts   = map[int]time.Time{}
+)
+
+// reservePort returns false if the port was handed out recently, and
+// otherwise reserves it
+func reservePort(port int) bool {
+	reservedPortsMu.Lock()
+	defer reservedPortsMu.Unlock()
+
+	now := time.Now()
+	for p, reservedAt := range reservedPorts {
+		if now.Sub(reservedAt) > portReservationTTL {
+			delete(reservedPorts, p)
+		}
+	}
+
+	if _, ok := reservedPorts[port]; ok {
+		return false
+	}
+
+	reservedPorts[port] = now
+	return true
+}
+
+// isPortReserved reports whether the port was handed out recently
+func isPortReserved(port int) bool {
+	reservedPortsMu.Lock()
+	defer reservedPortsMu.Unlock()
+
+	reservedAt, ok := reservedPorts[port]
+	return ok && time.Since(reservedAt) <= portReservationTTL
+}
+
+// allocatePort picks a free port, from portRange if it's set, that hasn't
+// been handed out to another server recently
+func allocatePort(portRange [2]int) (int, error) {
+	for i := 0; i < maxPortAttempts; i++ {
+		var port int
+		var err error
+		if portRange == [2]int{} {
+			port, err = getFreePort()
+		} else {
+			port, err = getFreePortInRange(portRange)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if reservePort(port) {
+			return port, nil
+		}
+	}
+
+	if portRange != [2]int{} {
+		return 0, fmt.Errorf("%w %d-%d", ErrNoFreePortInRange, portRange[0], portRange[1])
+	}
+
+	return 0, fmt.Errorf("could not find a port that isn't reserved by another server after %d attempts", maxPortAttempts)
+}
+
+func validatePortRange(portRange [2]int) error {
+	if portRange[0] < 1 || portRange[1] > 65535 || portRange[0] > portRange[1] {
+		return fmt.Errorf("invalid port range %d-%d: must be within 1-65535, with the lower bound first", portRange[0], portRange[1])
+	}
+
+	return nil
+}
+
+// maxReplicaMembers and maxVotingMembers are the most members, and voting
+// members, MongoDB allows in a replica set
+const (
+	maxReplicaMembers = 50
+	maxVotingMembers  = 7
+)
+
+// replicaMemberCount returns the number of members in the replica set opts
+// start
+func (opts *Options) replicaMemberCount() int {
+	if opts.ReplicaMembers == 0 {
+		return 1
+	}
+
+	return opts.ReplicaMembers
+}
+
+func (opts *Options) validateReplicaMembers() error {
+	if !opts.ShouldUseReplica {
+		return fmt.Errorf("cannot use ReplicaMembers without ShouldUseReplica")
+	}
+
+	if opts.ReplicaMembers < 1 || opts.ReplicaMembers > maxReplicaMembers {
+		return fmt.Errorf("invalid ReplicaMembers %d: must be within 1-%d", opts.ReplicaMembers, maxReplicaMembers)
+	}
+
+	return nil
+}
+
+func (opts *Options) validateReplicaMemberPorts() error {
+	if !opts.ShouldUseReplica {
+		return fmt.Errorf("cannot use ReplicaMemberPorts without ShouldUseReplica")
+	}
+
+	if len(opts.ReplicaMemberPorts) != opts.replicaMemberCount() {
+		return fmt.Errorf("the replica set has %d members, but ReplicaMemberPorts has %d ports", opts.replicaMemberCount(), len(opts.ReplicaMemberPorts))
+	}
+
+	for _, port := range opts.ReplicaMemberPorts {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid replica member port %d: must be within 1-65535", port)
+		}
+	}
+
+	if opts.Port != 0 && opts.Port != opts.ReplicaMemberPorts[0] {
+		return fmt.Errorf("port %d conflicts with ReplicaMemberPorts %v", opts.Port, opts.ReplicaMemberPorts)
+	}
+
+	return nil
+}
+
+func (opts *Options) validateReplicaMemberVersions() error {
+	if !opts.ShouldUseReplica {
+		return fmt.Errorf("cannot use ReplicaMemberVersions without ShouldUseReplica")
+	}
+
+	if len(opts.ReplicaMemberVersions) != opts.replicaMemberCount() {
+		return fmt.Errorf("the replica set has %d members, but ReplicaMemberVersions has %d versions", opts.replicaMemberCount(), len(opts.ReplicaMemberVersions))
+	}
+
+	if opts.MongodBin != "" || opts.DownloadURL != "" {
+		return fmt.Errorf("cannot use ReplicaMemberVersions with MongodBin or DownloadURL: each version is downloaded")
+	}
+
+	for _, version := range opts.ReplicaMemberVersions {
+		_, err := capabilitiesForVersion(version)
+		if err != nil {
+			return fmt.Errorf("invalid ReplicaMemberVersions: %w", err)
+		}
+	}
+
+	if opts.MongoVersion != "" && opts.MongoVersion != opts.ReplicaMemberVersions[0] {
+		return fmt.Errorf("MongoVersion %s conflicts with ReplicaMemberVersions %v", opts.MongoVersion, opts.ReplicaMemberVersions)
+	}
+
+	err := validateVersionSkew(opts.ReplicaMemberVersions)
+	if err != nil {
+		return err
+	}
+
+	oldest, _, _ := releaseRange(opts.ReplicaMemberVersions)
+	first, _ := releaseIndex(opts.ReplicaMemberVersions[0])
+	if first != oldest {
+		return fmt.Errorf("ReplicaMemberVersions %v must start with the oldest release: the replica set starts with member 0's feature compatibility version", opts.ReplicaMemberVersions)
+	}
+
+	return nil
+}
+
+func (opts *Options) validateReplicaMemberTags() error {
+	if !opts.ShouldUseReplica {
+		return fmt.Errorf("cannot use ReplicaMemberTags without ShouldUseReplica")
+	}
+
+	if len(opts.ReplicaMemberTags) != opts.replicaMemberCount() {
+		return fmt.Errorf("the replica set has %d members, but ReplicaMemberTags has %d tag sets", opts.replicaMemberCount(), len(opts.ReplicaMemberTags))
+	}
+
+	for _, tags := range opts.ReplicaMemberTags {
+		for k, v := range tags {
+			// These separate tags in a connection string's readPreferenceTags
+			if strings.ContainsAny(k, ":,") || strings.ContainsAny(v, ":,") {
+				return fmt.Errorf("invalid replica member tag %s:%s: tags can't contain ':' or ','", k, v)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkPortFree returns ErrPortInUse if nothing can listen on port, naming
+// the process that holds it if possible
+func checkPortFree(port int) error {
+	l, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+	if err != nil {
+		return fmt.Errorf("%w: %d%s", ErrPortInUse, port, describePortOwner(port))
+	}
+	_ = l.Close()
+
+	return nil
+}
+
+// checkDataDir returns an error if Options.DataDir is set but isn't a
+// directory data directories can be created in
+func checkDataDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("DataDir %s doesn't exist: create it before starting the server", dir)
+	}
+	if err != nil {
+		return fmt.Errorf("error checking DataDir: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("DataDir %s isn't a directory", dir)
+	}
+
+	f, err := os.CreateTemp(dir, ".memongo-check-")
+	if err != nil {
+		return fmt.Errorf("DataDir %s isn't writable: %w", dir, err)
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+
+	return nil
+}
+
+// reReplicaSetName matches the replica set names we accept. mongod itself is
+// more lenient, but names with '/', ',' or spaces break connection strings.
+var reReplicaSetName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func validateReplicaSetName(name string) error {
+	if !reReplicaSetName.MatchString(name) {
+		return fmt.Errorf("invalid replica set name %q: may only contain letters, digits, '-', '_' and '.'", name)
+	}
+
+	return nil
+}
+
+func parsePortRange(s string) ([2]int, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return [2]int{}, fmt.Errorf("port range %q must be in the form low-high", s)
+	}
+
+	low, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return [2]int{}, fmt.Errorf("port range %q must be in the form low-high", s)
+	}
+
+	high, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return [2]int{}, fmt.Errorf("port range %q must be in the form low-high", s)
+	}
+
+	portRange := [2]int{low, high}
+	return portRange, validatePortRange(portRange)
+}
+
+// getFreePortInRange probes the ports in the range for one that's free. It
+// starts at a random port so that concurrent callers don't all race for the
+// first one.
+func getFreePortInRange(portRange [2]int) (int, error) {
+	size := portRange[1] - portRange[0] + 1
+
+	offset, err := rand.Int(rand.Reader, big.NewInt(int64(size)))
+	if err != nil {
+		return 0, fmt.Errorf("error getting a random int: %s", err)
+	}
+
+	for i := 0; i < size; i++ {
+		port := portRange[0] + (int(offset.Int64())+i)%size
+		// A reserved port is free until mongod binds it, but is no more
+		// available than one that's in use
+		if isPortReserved(port) {
+			continue
+		}
+
+		l, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+		if err != nil {
+			continue
+		}
+		_ = l.Close()
+
+		return port, nil
 	}
 
-	return binPath, nil
+	return 0, fmt.Errorf("%w %d-%d", ErrNoFreePortInRange, portRange[0], portRange[1])
 }
 
 func getFreePort() (int, error) {
@@ -0,0 +1,561 @@
+package memongo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/100mslive/memongo/v2/mongobin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := map[string]struct {
+		input string
+
+		expectedRange [2]int
+		expectedError string
+	}{
+		"valid": {
+			input:         "20000-20100",
+			expectedRange: [2]int{20000, 20100},
+		},
+		"single port": {
+			input:         "20000-20000",
+			expectedRange: [2]int{20000, 20000},
+		},
+		"spaces": {
+			input:         " 20000 - 20100 ",
+			expectedRange: [2]int{20000, 20100},
+		},
+		"missing dash": {
+			input:         "20000",
+			expectedError: `port range "20000" must be in the form low-high`,
+		},
+		"not a number": {
+			input:         "20000-abc",
+			expectedError: `port range "20000-abc" must be in the form low-high`,
+		},
+		"reversed": {
+			input:         "20100-20000",
+			expectedError: "invalid port range 20100-20000: must be within 1-65535, with the lower bound first",
+		},
+		"too high": {
+			input:         "65000-70000",
+			expectedError: "invalid port range 65000-70000: must be within 1-65535, with the lower bound first",
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			portRange, err := parsePortRange(test.input)
+			if test.expectedError != "" {
+				require.EqualError(t, err, test.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedRange, portRange)
+		})
+	}
+}
+
+func TestGetFreePortInRange(t *testing.T) {
+	// Find a free port and block it
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer l.Close()
+	taken := l.Addr().(*net.TCPAddr).Port
+
+	_, err = getFreePortInRange([2]int{taken, taken})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrNoFreePortInRange))
+	assert.Contains(t, err.Error(), "no free port in range")
+
+	l.Close()
+	port, err := getFreePortInRange([2]int{taken, taken})
+	require.NoError(t, err)
+	assert.Equal(t, taken, port)
+
+	// Ports handed out to another server are skipped, until the reservation
+	// expires
+	require.True(t, reservePort(taken))
+	_, err = getFreePortInRange([2]int{taken, taken})
+	assert.True(t, errors.Is(err, ErrNoFreePortInRange))
+
+	reservedPortsMu.Lock()
+	reservedPorts[taken] = time.Now().Add(-2 * portReservationTTL)
+	reservedPortsMu.Unlock()
+	port, err = getFreePortInRange([2]int{taken, taken})
+	require.NoError(t, err)
+	assert.Equal(t, taken, port)
+}
+
+func TestFillDefaultsPortRange(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	free := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	opts := &Options{MongodBin: "/bin/true", PortRange: [2]int{free, free}}
+	require.NoError(t, opts.fillDefaults())
+	assert.Equal(t, free, opts.Port)
+
+	os.Setenv("MEMONGO_PORT_RANGE", "abc")
+	defer os.Unsetenv("MEMONGO_PORT_RANGE")
+
+	opts = &Options{MongodBin: "/bin/true"}
+	require.EqualError(t, opts.fillDefaults(), `error parsing MEMONGO_PORT_RANGE: port range "abc" must be in the form low-high`)
+
+	// An explicit port wins over the range
+	opts = &Options{MongodBin: "/bin/true", Port: 12345}
+	require.NoError(t, opts.fillDefaults())
+	assert.Equal(t, 12345, opts.Port)
+
+	assert.EqualError(t, (&Options{MongodBin: "/bin/true", PortRange: [2]int{0, 10}}).Validate(),
+		"invalid port range 0-10: must be within 1-65535, with the lower bound first")
+}
+
+func TestLockDownload(t *testing.T) {
+	d := DownloadOptions{DownloadURL: "https://example.com/" + t.Name() + ".tgz", CachePath: t.TempDir()}
+	unlock, err := lockDownload(context.Background(), d)
+	require.NoError(t, err)
+
+	// Waiting behind another download is given up once ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = lockDownload(ctx, d)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "gave up waiting for another download of "+d.DownloadURL)
+
+	// Another cache path has its own lock
+	other := d
+	other.CachePath = t.TempDir()
+	unlockOther, err := lockDownload(context.Background(), other)
+	require.NoError(t, err)
+	unlockOther()
+
+	unlock()
+	unlock, err = lockDownload(context.Background(), d)
+	require.NoError(t, err)
+	unlock()
+}
+
+func TestFillDefaultsDataDir(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{MongodBin: "/bin/true", DataDir: dir, TempDirBase: t.TempDir()}
+	require.NoError(t, opts.fillDefaults())
+	assert.Equal(t, dir, opts.dataDirBase())
+
+	// The write check leaves nothing behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	missing := filepath.Join(dir, "missing")
+	opts = &Options{MongodBin: "/bin/true", DataDir: missing}
+	assert.EqualError(t, opts.fillDefaults(), "DataDir "+missing+" doesn't exist: create it before starting the server")
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+	opts = &Options{MongodBin: "/bin/true", DataDir: file}
+	assert.EqualError(t, opts.fillDefaults(), "DataDir "+file+" isn't a directory")
+
+	if os.Geteuid() != 0 {
+		readOnly := filepath.Join(dir, "read-only")
+		require.NoError(t, os.Mkdir(readOnly, 0o500))
+		opts = &Options{MongodBin: "/bin/true", DataDir: readOnly}
+		err = opts.fillDefaults()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "DataDir "+readOnly+" isn't writable")
+	}
+}
+
+func TestReplicaMemberOptions(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	busy := l.Addr().(*net.TCPAddr).Port
+	defer l.Close()
+
+	l2, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	free := l2.Addr().(*net.TCPAddr).Port
+	l2.Close()
+
+	opts := &Options{MongodBin: "/bin/true", ShouldUseReplica: true, ReplicaMemberPorts: []int{free}}
+	require.NoError(t, opts.fillDefaults())
+	assert.Equal(t, free, opts.Port)
+
+	opts = &Options{MongodBin: "/bin/true", ShouldUseReplica: true, ReplicaMemberPorts: []int{busy}}
+	err = opts.fillDefaults()
+	require.ErrorIs(t, err, ErrPortInUse)
+	assert.True(t, strings.HasPrefix(err.Error(), fmt.Sprintf("port in use: %d", busy)))
+
+	tests := map[string]struct {
+		opts          Options
+		expectedError string
+	}{
+		"not a replica set": {
+			opts:          Options{ReplicaMemberPorts: []int{free}},
+			expectedError: "cannot use ReplicaMemberPorts without ShouldUseReplica",
+		},
+		"too many ports": {
+			opts:          Options{ShouldUseReplica: true, ReplicaMemberPorts: []int{free, free + 1}},
+			expectedError: "the replica set has 1 members, but ReplicaMemberPorts has 2 ports",
+		},
+		"invalid port": {
+			opts:          Options{ShouldUseReplica: true, ReplicaMemberPorts: []int{70000}},
+			expectedError: "invalid replica member port 70000: must be within 1-65535",
+		},
+		"tags not a replica set": {
+			opts:          Options{ReplicaMemberTags: []map[string]string{{"a": "b"}}},
+			expectedError: "cannot use ReplicaMemberTags without ShouldUseReplica",
+		},
+		"too many tag sets": {
+			opts:          Options{ShouldUseReplica: true, ReplicaMemberTags: []map[string]string{{"a": "b"}, {"a": "c"}}},
+			expectedError: "the replica set has 1 members, but ReplicaMemberTags has 2 tag sets",
+		},
+		"invalid tag": {
+			opts:          Options{ShouldUseReplica: true, ReplicaMemberTags: []map[string]string{{"a": "b,c"}}},
+			expectedError: "invalid replica member tag a:b,c: tags can't contain ':' or ','",
+		},
+		"negative cursor timeout": {
+			opts:          Options{CursorTimeout: -time.Second},
+			expectedError: "invalid CursorTimeout -1s: must be at least 1ms",
+		},
+		"read only deferred": {
+			opts:          Options{ShouldUseReplica: true, ReadOnly: true, DeferReplicaSetInitiation: true},
+			expectedError: "cannot use ReadOnly with DeferReplicaSetInitiation",
+		},
+		"fractional TTL monitor interval": {
+			opts:          Options{TTLMonitorInterval: 1500 * time.Millisecond},
+			expectedError: "invalid TTLMonitorInterval 1.5s: must be a whole number of seconds",
+		},
+		"invalid cleanup policy": {
+			opts:          Options{Cleanup: Cleanup{RemoveDBPath: 7}},
+			expectedError: "invalid Cleanup.RemoveDBPath: invalid cleanup policy 7",
+		},
+		"conflicting port": {
+			opts:          Options{ShouldUseReplica: true, Port: 1234, ReplicaMemberPorts: []int{1235}},
+			expectedError: "port 1234 conflicts with ReplicaMemberPorts [1235]",
+		},
+		"members not a replica set": {
+			opts:          Options{ReplicaMembers: 3},
+			expectedError: "cannot use ReplicaMembers without ShouldUseReplica",
+		},
+		"too many members": {
+			opts:          Options{ShouldUseReplica: true, ReplicaMembers: 51},
+			expectedError: "invalid ReplicaMembers 51: must be within 1-50",
+		},
+		"a port short": {
+			opts:          Options{ShouldUseReplica: true, ReplicaMembers: 3, ReplicaMemberPorts: []int{free, free + 1}},
+			expectedError: "the replica set has 3 members, but ReplicaMemberPorts has 2 ports",
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			opts := test.opts
+			opts.MongodBin = "/bin/true"
+			assert.EqualError(t, opts.Validate(), test.expectedError)
+		})
+	}
+
+	opts = &Options{MongodBin: "/bin/true", ShouldUseReplica: true, ReplicaMembers: 3, ReplicaMemberTags: []map[string]string{{"dc": "a"}, {"dc": "b"}, {"dc": "c"}}}
+	assert.NoError(t, opts.Validate())
+}
+
+func TestValidateReplicaMemberVersions(t *testing.T) {
+	opts := &Options{ShouldUseReplica: true, ReplicaMembers: 2, ReplicaMemberVersions: []string{"7.0.2", "8.0.0"}}
+	assert.NoError(t, opts.Validate())
+
+	opts.ReplicaMemberVersions = []string{"8.0.0", "7.0.2"}
+	assert.EqualError(t, opts.Validate(), "ReplicaMemberVersions [8.0.0 7.0.2] must start with the oldest release: the replica set starts with member 0's feature compatibility version")
+}
+
+func TestReservePort(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	assert.True(t, reservePort(port))
+	assert.False(t, reservePort(port))
+
+	// Reservations expire
+	reservedPortsMu.Lock()
+	reservedPorts[port] = time.Now().Add(-2 * portReservationTTL)
+	reservedPortsMu.Unlock()
+	assert.True(t, reservePort(port))
+}
+
+func TestAllocatePortConcurrently(t *testing.T) {
+	const n = 50
+
+	ports := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			port, err := allocatePort([2]int{})
+			assert.NoError(t, err)
+			ports <- port
+		}()
+	}
+	wg.Wait()
+	close(ports)
+
+	seen := map[int]bool{}
+	for port := range ports {
+		assert.False(t, seen[port], "port %d was allocated twice", port)
+		seen[port] = true
+	}
+}
+
+func TestEffectiveOptionsPrecedence(t *testing.T) {
+	t.Setenv("MEMONGO_MONGO_VERSION", "7.0.2")
+	t.Setenv("MEMONGO_LOG_LEVEL", "debug")
+	t.Setenv("MEMONGO_STARTUP_TIMEOUT", "45s")
+	t.Setenv("MEMONGO_SHOULD_USE_REPLICA", "true")
+	t.Setenv("MEMONGO_AUTH", "1")
+	t.Setenv("MEMONGO_OFFLINE", "false")
+	t.Setenv("MEMONGO_TMPDIR", "/scratch")
+	t.Setenv("MEMONGO_MONGOD_PORT", "23456")
+
+	// Environment variables override defaults
+	effective, err := (&Options{MongodBin: "/bin/true"}).EffectiveOptions()
+	require.NoError(t, err)
+	assert.Equal(t, "7.0.2", effective.MongoVersion)
+	assert.Equal(t, memongolog.LogLevel(memongolog.LogLevelDebug), effective.LogLevel)
+	assert.Equal(t, 45*time.Second, effective.StartupTimeout)
+	assert.True(t, effective.ShouldUseReplica)
+	assert.True(t, effective.Auth)
+	assert.False(t, effective.Offline)
+	assert.Equal(t, "/scratch", effective.TempDirBase)
+	assert.Equal(t, 23456, effective.Port)
+	assert.Equal(t, "rs0", effective.ReplicaSetName)
+
+	// Explicit fields override environment variables
+	opts := &Options{
+		MongodBin:      "/bin/true",
+		MongoVersion:   "8.0.0",
+		LogLevel:       memongolog.LogLevelWarn,
+		StartupTimeout: 5 * time.Second,
+		TempDirBase:    "/fast",
+		Port:           34567,
+	}
+	effective, err = opts.EffectiveOptions()
+	require.NoError(t, err)
+	assert.Equal(t, "8.0.0", effective.MongoVersion)
+	assert.Equal(t, memongolog.LogLevel(memongolog.LogLevelWarn), effective.LogLevel)
+	assert.Equal(t, 5*time.Second, effective.StartupTimeout)
+	assert.Equal(t, "/fast", effective.TempDirBase)
+	assert.Equal(t, 34567, effective.Port)
+
+	// The caller's options aren't modified
+	assert.Equal(t, "", opts.ReplicaSetName)
+	assert.False(t, opts.ShouldUseReplica)
+}
+
+func TestEffectiveOptionsDefaults(t *testing.T) {
+	effective, err := (&Options{MongodBin: "/bin/true", Port: 1234}).EffectiveOptions()
+	require.NoError(t, err)
+
+	// The startup timeout defaults even when a port is given
+	assert.Equal(t, 10*time.Second, effective.StartupTimeout)
+	assert.Equal(t, "rs0", effective.ReplicaSetName)
+	assert.Equal(t, "", effective.TempDirBase)
+	assert.False(t, effective.ShouldUseReplica)
+
+	// Read-only servers are replica sets
+	effective, err = (&Options{MongodBin: "/bin/true", Port: 1234, ReadOnly: true}).EffectiveOptions()
+	require.NoError(t, err)
+	assert.True(t, effective.ShouldUseReplica)
+}
+
+func TestEffectiveOptionsEnvErrors(t *testing.T) {
+	tests := map[string]struct {
+		name  string
+		value string
+
+		expectedError string
+	}{
+		"log level": {
+			name:          "MEMONGO_LOG_LEVEL",
+			value:         "loud",
+			expectedError: `error parsing MEMONGO_LOG_LEVEL: invalid log level "loud": must be one of debug, info, warn, silent`,
+		},
+		"startup timeout": {
+			name:          "MEMONGO_STARTUP_TIMEOUT",
+			value:         "10",
+			expectedError: `error parsing MEMONGO_STARTUP_TIMEOUT: time: missing unit in duration "10"`,
+		},
+		"replica": {
+			name:          "MEMONGO_SHOULD_USE_REPLICA",
+			value:         "yes",
+			expectedError: `error parsing MEMONGO_SHOULD_USE_REPLICA: "yes" is not a boolean`,
+		},
+		"auth": {
+			name:          "MEMONGO_AUTH",
+			value:         "on",
+			expectedError: `error parsing MEMONGO_AUTH: "on" is not a boolean`,
+		},
+		"offline": {
+			name:          "MEMONGO_OFFLINE",
+			value:         "2",
+			expectedError: `error parsing MEMONGO_OFFLINE: "2" is not a boolean`,
+		},
+		"port": {
+			name:          "MEMONGO_MONGOD_PORT",
+			value:         "abc",
+			expectedError: `error parsing MEMONGO_MONGOD_PORT: strconv.Atoi: parsing "abc": invalid syntax`,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			t.Setenv(test.name, test.value)
+
+			_, err := (&Options{MongodBin: "/bin/true"}).EffectiveOptions()
+			require.EqualError(t, err, test.expectedError)
+		})
+	}
+}
+
+func TestOfflineWithoutCachedBinary(t *testing.T) {
+	opts := &Options{
+		Offline:     true,
+		DownloadURL: "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-ubuntu2204-8.0.0.tgz",
+		CachePath:   t.TempDir(),
+	}
+
+	_, _, err := opts.getOrDownloadBinPath(context.Background(), newEventBus(nil), opts.getLogger())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not in the cache")
+	assert.Contains(t, err.Error(), "downloads are disabled by Offline")
+}
+
+func TestClone(t *testing.T) {
+	opts := &Options{
+		ReplicaMemberPorts: []int{1234},
+		ReplicaMemberTags:  []map[string]string{{"dc": "east"}, nil},
+		Seed:               []SeedCollection{{Database: "app", Collection: "users"}},
+		ClockWrapper:       &ClockWrapper{Speed: 2},
+	}
+	opts.ReadOnlyCachePaths = []string{"/mnt/cache"}
+
+	c := opts.clone()
+	assert.Equal(t, opts, c)
+
+	c.ReplicaMemberPorts[0] = 1
+	c.ReplicaMemberTags[0]["dc"] = "west"
+	c.Seed[0].Database = "other"
+	c.ClockWrapper.Speed = 3
+	c.ReadOnlyCachePaths[0] = "/other"
+
+	assert.Equal(t, []int{1234}, opts.ReplicaMemberPorts)
+	assert.Equal(t, []map[string]string{{"dc": "east"}, nil}, opts.ReplicaMemberTags)
+	assert.Equal(t, "app", opts.Seed[0].Database)
+	assert.Equal(t, 2.0, opts.ClockWrapper.Speed)
+	assert.Equal(t, []string{"/mnt/cache"}, opts.ReadOnlyCachePaths)
+}
+
+func TestStartWithOptionsSharedOptions(t *testing.T) {
+	opts := &Options{
+		MongodBin:        "/nonexistent/mongod",
+		ShouldUseReplica: true,
+		LogLevel:         memongolog.LogLevelSilent,
+	}
+
+	// Starting fails, but only after defaults are applied, which must not
+	// touch the shared options
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := StartWithOptions(opts)
+			assert.Error(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, &Options{
+		MongodBin:        "/nonexistent/mongod",
+		ShouldUseReplica: true,
+		LogLevel:         memongolog.LogLevelSilent,
+	}, opts)
+}
+
+func TestExactCandidates(t *testing.T) {
+	candidates := []mongobin.Candidate{
+		{URL: "https://example.com/ubuntu2204.tgz"},
+		{URL: "https://example.com/ubuntu2004.tgz", Fallback: "ubuntu2004 build for ubuntu 22"},
+	}
+
+	exact, err := exactCandidates("8.0.0", candidates)
+	require.NoError(t, err)
+	assert.Equal(t, candidates[:1], exact)
+
+	_, err = exactCandidates("8.0.0", candidates[1:])
+	assert.EqualError(t, err, "there's no MongoDB 8.0.0 build for this exact platform, and RequireExactDistroMatch is set; the fallbacks would be: ubuntu2004 build for ubuntu 22")
+}
+
+func TestResolveDownloadCandidate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ubuntu2004.tgz" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	resolver := mongobin.DefaultResolver
+	mongobin.DefaultResolver = &mongobin.Resolver{Client: server.Client()}
+	defer func() {
+		mongobin.DefaultResolver = resolver
+	}()
+
+	candidates := []mongobin.Candidate{
+		{URL: server.URL + "/ubuntu2204.tgz"},
+		{URL: server.URL + "/ubuntu2004.tgz", Fallback: "ubuntu2004 build for ubuntu 24"},
+	}
+
+	t.Run("fallback", func(t *testing.T) {
+		var out bytes.Buffer
+		opts := &Options{CachePath: t.TempDir(), DownloadURL: candidates[0].URL, downloadCandidates: candidates}
+
+		require.NoError(t, opts.resolveDownloadCandidate(context.Background(), memongolog.New(log.New(&out, "", 0), memongolog.LogLevelWarn)))
+		assert.Equal(t, candidates[1].URL, opts.DownloadURL)
+		assert.Contains(t, out.String(), "using the ubuntu2004 build for ubuntu 24 from "+candidates[1].URL)
+	})
+
+	t.Run("none exist", func(t *testing.T) {
+		opts := &Options{CachePath: t.TempDir(), downloadCandidates: candidates[:1]}
+		opts.downloadCandidates = append(opts.downloadCandidates, mongobin.Candidate{URL: server.URL + "/ubuntu1804.tgz"})
+
+		err := opts.resolveDownloadCandidate(context.Background(), memongolog.New(nil, memongolog.LogLevelSilent))
+		assert.ErrorIs(t, err, mongobin.ErrNoBuildFound)
+		assert.Contains(t, err.Error(), server.URL+"/ubuntu2204.tgz, "+server.URL+"/ubuntu1804.tgz")
+	})
+
+	t.Run("offline", func(t *testing.T) {
+		opts := &Options{CachePath: t.TempDir(), Offline: true, downloadCandidates: candidates}
+
+		require.NoError(t, opts.resolveDownloadCandidate(context.Background(), memongolog.New(nil, memongolog.LogLevelSilent)))
+		assert.Equal(t, candidates[0].URL, opts.DownloadURL)
+	})
+}
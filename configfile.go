@@ -0,0 +1,243 @@
+package memongo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is the schema of a memongo config file. Durations and sizes are
+// strings, so they can be written as "30s" or "256MB".
+type configFile struct {
+	MongoVersion        string `json:"mongoVersion" yaml:"mongoVersion"`
+	ShouldUseReplica    bool   `json:"shouldUseReplica" yaml:"shouldUseReplica"`
+	ReplicaSetName      string `json:"replicaSetName" yaml:"replicaSetName"`
+	ReplicaMembers      int    `json:"replicaMembers" yaml:"replicaMembers"`
+	Sharded             bool   `json:"sharded" yaml:"sharded"`
+	NumShards           int    `json:"numShards" yaml:"numShards"`
+	Port                int    `json:"port" yaml:"port"`
+	PortRange           []int  `json:"portRange" yaml:"portRange"`
+	CachePath           string `json:"cachePath" yaml:"cachePath"`
+	DownloadURL         string `json:"downloadURL" yaml:"downloadURL"`
+	MongodBin           string `json:"mongodBin" yaml:"mongodBin"`
+	LogLevel            string `json:"logLevel" yaml:"logLevel"`
+	LogFormat           string `json:"logFormat" yaml:"logFormat"`
+	StartupTimeout      string `json:"startupTimeout" yaml:"startupTimeout"`
+	Auth                bool   `json:"auth" yaml:"auth"`
+	Diskless            bool   `json:"diskless" yaml:"diskless"`
+	WiredTigerCacheSize string `json:"wiredTigerCacheSize" yaml:"wiredTigerCacheSize"`
+	HealthHTTPAddr      string `json:"healthHTTPAddr" yaml:"healthHTTPAddr"`
+	HealthHTTPStrict    bool   `json:"healthHTTPStrict" yaml:"healthHTTPStrict"`
+	Strict              bool   `json:"strict" yaml:"strict"`
+}
+
+// LoadOptions reads Options from a YAML (.yaml, .yml) or JSON (.json) file.
+// Unknown fields are an error. ${VAR} references are replaced with the value
+// of the environment variable VAR, which must be set. Durations are written
+// like "30s", and wiredTigerCacheSize like "256MB" or "1GB".
+//
+// See testdata/config/memongo.yaml for an example.
+func LoadOptions(path string) (*Options, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".yaml" && ext != ".yml" && ext != ".json" {
+		return nil, fmt.Errorf("config file %s must have a .yaml, .yml, or .json extension", path)
+	}
+
+	//nolint:gosec
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	contents, err = interpolateEnv(contents)
+	if err != nil {
+		return nil, fmt.Errorf("error in config file %s: %w", path, err)
+	}
+
+	var file configFile
+	if ext == ".json" {
+		decoder := json.NewDecoder(bytes.NewReader(contents))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(contents))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	opts, err := file.toOptions()
+	if err != nil {
+		return nil, fmt.Errorf("error in config file %s: %w", path, err)
+	}
+
+	return opts, nil
+}
+
+// StartFromConfig starts a server with the options from the config file at
+// path (see LoadOptions). Non-zero fields of overrides take precedence over
+// the file; overrides may be nil.
+func StartFromConfig(path string, overrides *Options) (*Server, error) {
+	opts, err := LoadOptions(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if overrides != nil {
+		mergeOptions(opts, overrides)
+	}
+
+	return StartWithOptions(opts)
+}
+
+// mergeOptions copies every non-zero field of overrides onto opts
+func mergeOptions(opts *Options, overrides *Options) {
+	dst := reflect.ValueOf(opts).Elem()
+	src := reflect.ValueOf(overrides).Elem()
+
+	for i := 0; i < src.NumField(); i++ {
+		if !src.Field(i).IsZero() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+}
+
+var reEnvReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+func interpolateEnv(contents []byte) ([]byte, error) {
+	var missing []string
+
+	result := reEnvReference.ReplaceAllFunc(contents, func(ref []byte) []byte {
+		name := string(reEnvReference.FindSubmatch(ref)[1])
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+
+		return []byte(value)
+	})
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("environment variables referenced but not set: %s", strings.Join(missing, ", "))
+	}
+
+	return result, nil
+}
+
+func (file *configFile) toOptions() (*Options, error) {
+	opts := &Options{
+		MongoVersion:     file.MongoVersion,
+		ShouldUseReplica: file.ShouldUseReplica,
+		ReplicaSetName:   file.ReplicaSetName,
+		ReplicaMembers:   file.ReplicaMembers,
+		Sharded:          file.Sharded,
+		NumShards:        file.NumShards,
+		Port:             file.Port,
+		MongodBin:        file.MongodBin,
+		Auth:             file.Auth,
+		Diskless:         file.Diskless,
+		HealthHTTPAddr:   file.HealthHTTPAddr,
+		HealthHTTPStrict: file.HealthHTTPStrict,
+		Strict:           file.Strict,
+		DownloadOptions: DownloadOptions{
+			CachePath:   file.CachePath,
+			DownloadURL: file.DownloadURL,
+		},
+	}
+
+	if file.PortRange != nil {
+		if len(file.PortRange) != 2 {
+			return nil, fmt.Errorf("portRange must have exactly two elements, got %d", len(file.PortRange))
+		}
+		opts.PortRange = [2]int{file.PortRange[0], file.PortRange[1]}
+	}
+
+	if file.LogLevel != "" {
+		level, err := parseLogLevel(file.LogLevel)
+		if err != nil {
+			return nil, err
+		}
+		opts.LogLevel = level
+	}
+
+	if file.LogFormat != "" {
+		format, err := memongolog.ParseFormat(file.LogFormat)
+		if err != nil {
+			return nil, err
+		}
+		opts.LogFormat = format
+	}
+
+	if file.StartupTimeout != "" {
+		timeout, err := time.ParseDuration(file.StartupTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid startupTimeout: %w", err)
+		}
+		opts.StartupTimeout = timeout
+	}
+
+	if file.WiredTigerCacheSize != "" {
+		size, err := parseSize(file.WiredTigerCacheSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid wiredTigerCacheSize: %w", err)
+		}
+		opts.WiredTigerCacheSizeGB = float64(size) / (1 << 30)
+	}
+
+	return opts, nil
+}
+
+func parseLogLevel(s string) (memongolog.LogLevel, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return memongolog.LogLevelDebug, nil
+	case "info":
+		return memongolog.LogLevelInfo, nil
+	case "warn":
+		return memongolog.LogLevelWarn, nil
+	case "silent":
+		return memongolog.LogLevelSilent, nil
+	default:
+		return 0, fmt.Errorf("invalid log level %q: must be one of debug, info, warn, silent", s)
+	}
+}
+
+var reSize = regexp.MustCompile(`^\s*([0-9]+(?:\.[0-9]+)?)\s*([KMGT]?B)?\s*$`)
+
+var sizeUnits = map[string]float64{
+	"":   1,
+	"B":  1,
+	"KB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+	"TB": 1 << 40,
+}
+
+// parseSize parses a size like "256MB" or "1.5GB" into bytes. Units are
+// powers of 1024; a bare number is bytes.
+func parseSize(s string) (int64, error) {
+	match := reSize.FindStringSubmatch(strings.ToUpper(s))
+	if match == nil {
+		return 0, fmt.Errorf("size %q must be a number followed by B, KB, MB, GB, or TB", s)
+	}
+
+	n, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("size %q must be a number followed by B, KB, MB, GB, or TB", s)
+	}
+
+	return int64(n * sizeUnits[match[2]]), nil
+}
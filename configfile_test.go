@@ -0,0 +1,99 @@
+package memongo
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOptions(t *testing.T) {
+	os.Setenv("MONGO_VERSION", "7.0.2")
+	defer os.Unsetenv("MONGO_VERSION")
+
+	expected := &Options{
+		MongoVersion:          "7.0.2",
+		ShouldUseReplica:      true,
+		ReplicaSetName:        "rs0",
+		Auth:                  true,
+		PortRange:             [2]int{20000, 20100},
+		LogLevel:              memongolog.LogLevelWarn,
+		LogFormat:             memongolog.FormatJSON,
+		StartupTimeout:        30 * time.Second,
+		WiredTigerCacheSizeGB: 0.25,
+	}
+
+	for _, path := range []string{"testdata/config/memongo.yaml", "testdata/config/memongo.json"} {
+		t.Run(path, func(t *testing.T) {
+			opts, err := LoadOptions(path)
+			require.NoError(t, err)
+			assert.Equal(t, expected, opts)
+		})
+	}
+}
+
+func TestLoadOptionsErrors(t *testing.T) {
+	tests := map[string]string{
+		"testdata/config/unknown-field.yaml": "error parsing config file testdata/config/unknown-field.yaml: yaml: unmarshal errors:\n  line 2: field shouldUseReplicaSet not found in type memongo.configFile",
+		"testdata/config/unknown-field.json": `error parsing config file testdata/config/unknown-field.json: json: unknown field "shouldUseReplicaSet"`,
+		"testdata/config/bad-duration.yaml":  `error in config file testdata/config/bad-duration.yaml: invalid startupTimeout: time: unknown unit " seconds" in duration "30 seconds"`,
+		"testdata/config/bad-size.yaml":      `error in config file testdata/config/bad-size.yaml: invalid wiredTigerCacheSize: size "lots" must be a number followed by B, KB, MB, GB, or TB`,
+		"testdata/config/bad-type.yaml":      "error parsing config file testdata/config/bad-type.yaml: yaml: unmarshal errors:\n  line 2: cannot unmarshal !!str `twenty` into int",
+		"testdata/config/missing-env.yaml":   "error in config file testdata/config/missing-env.yaml: environment variables referenced but not set: MEMONGO_TEST_UNSET_VARIABLE",
+		"testdata/config/memongo.toml":       "config file testdata/config/memongo.toml must have a .yaml, .yml, or .json extension",
+	}
+
+	for path, expectedError := range tests {
+		t.Run(path, func(t *testing.T) {
+			_, err := LoadOptions(path)
+			require.EqualError(t, err, expectedError)
+		})
+	}
+
+	_, err := LoadOptions("testdata/config/does-not-exist.yaml")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestMergeOptions(t *testing.T) {
+	opts := &Options{
+		MongoVersion:   "7.0.2",
+		ReplicaSetName: "rs0",
+		LogLevel:       memongolog.LogLevelWarn,
+	}
+
+	mergeOptions(opts, &Options{
+		MongoVersion: "8.0.0",
+		Port:         1234,
+	})
+
+	assert.Equal(t, &Options{
+		MongoVersion:   "8.0.0",
+		ReplicaSetName: "rs0",
+		LogLevel:       memongolog.LogLevelWarn,
+		Port:           1234,
+	}, opts)
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"256MB": 256 << 20,
+		"1GB":   1 << 30,
+		"1.5gb": 3 << 29,
+		"512":   512,
+		"10 KB": 10 << 10,
+	}
+
+	for input, expected := range tests {
+		size, err := parseSize(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, size, input)
+	}
+
+	_, err := parseSize("-1MB")
+	assert.Error(t, err)
+}
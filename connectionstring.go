@@ -0,0 +1,73 @@
+package memongo
+
+import "strings"
+
+// URIOption changes a connection string built by ConnectionString
+type URIOption func(*connString)
+
+// URIDatabase sets the connection string's default database, the path
+// segment before its query parameters
+func URIDatabase(name string) URIOption {
+	return func(c *connString) {
+		c.db(name)
+	}
+}
+
+// URICredentials makes clients authenticate as user with password, against
+// authSource if it's given
+func URICredentials(user string, password string, authSource string) URIOption {
+	return func(c *connString) {
+		c.credentials(user, password)
+		if authSource != "" {
+			c.setParam("authSource", authSource)
+		}
+	}
+}
+
+// URIParam sets a query parameter such as retryWrites or appName, replacing
+// the one ConnectionString sets if it has the same key
+func URIParam(key string, value string) URIOption {
+	return func(c *connString) {
+		c.setParam(key, value)
+	}
+}
+
+// ConnectionString returns a connection string that can be passed as is to
+// mongo.Connect. Unlike URI, it names the replica set of a single-node
+// replica set too, with replicaSet, names every mongos of a sharded cluster
+// of more than one of Options.MongosCount, and connects to anything else
+// with directConnection=true. opts add a database, credentials and other
+// parameters.
+func (s *Server) ConnectionString(opts ...URIOption) string {
+	var uri *connString
+	if s.isReplicaSet {
+		uri = mongoURI(s.uriHosts()...).param("replicaSet", s.replicaSetName)
+	} else if s.opts.mongosCount() > 1 {
+		uri = mongoURI(s.mongosHosts()...)
+	} else {
+		uri = mongoURI(s.addr()).param("directConnection", "true")
+	}
+	for _, opt := range opts {
+		opt(uri)
+	}
+
+	return uri.String()
+}
+
+// URIWithDatabase returns ConnectionString with db as the default database
+func (s *Server) URIWithDatabase(db string) string {
+	return s.ConnectionString(URIDatabase(db))
+}
+
+// setParam sets a query parameter, replacing any with the same key, which
+// the driver compares case-insensitively
+func (c *connString) setParam(key string, value string) *connString {
+	for i, p := range c.params {
+		if strings.EqualFold(p.key, key) {
+			c.params[i] = uriParam{key: key, value: value}
+			return c
+		}
+	}
+
+	return c.param(key, value)
+}
@@ -0,0 +1,139 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	lockFileName          = "mongod.lock"
+	diagnosticDataDirName = "diagnostic.data"
+)
+
+// DataLayout describes the files in a server's data directory, so tools can
+// find what to copy or collect without knowing mongod's internals. Paths are
+// absolute.
+type DataLayout struct {
+	// Root is the data directory (see Server.DBPath)
+	Root string
+
+	// StorageEngine is the storage engine mongod runs, "wiredTiger",
+	// "ephemeralForTest" or "inMemory"
+	StorageEngine string
+
+	// LockFile is mongod's lock file, which holds its process ID while it
+	// runs. It's the one file that should never be copied.
+	LockFile string
+
+	// WiredTigerFiles are the files holding the data, sorted, including the
+	// journal. It's empty for ephemeralForTest, which keeps data in memory.
+	WiredTigerFiles []string
+
+	// DiagnosticDataDir holds mongod's full-time diagnostic data capture
+	// (FTDC) files, or is empty if mongod hasn't created it
+	DiagnosticDataDir string
+
+	// LogFile is mongod's log file. memongo reads mongod's log from its
+	// stdout, so this is empty unless mongod was told to log to a file.
+	LogFile string
+
+	// FsyncLocked is true if writes were locked with fsyncLock when the
+	// layout was read
+	FsyncLocked bool
+}
+
+// SafeToCopyWhileRunning reports whether the data files can be copied
+// without stopping the server and get a consistent copy. That's the case if
+// the data is on disk and writes are locked with fsyncLock.
+func (l DataLayout) SafeToCopyWhileRunning() bool {
+	return l.StorageEngine == "wiredTiger" && l.FsyncLocked
+}
+
+// DataFiles describes the server's data directory
+func (s *Server) DataFiles(ctx context.Context) (DataLayout, error) {
+	locked, err := s.fsyncLocked(ctx)
+	if err != nil {
+		return DataLayout{}, fmt.Errorf("error checking for fsyncLock: %w", err)
+	}
+
+	layout, err := readDataLayout(s.dbDir, s.storageEngine)
+	if err != nil {
+		return DataLayout{}, err
+	}
+	layout.FsyncLocked = locked
+
+	return layout, nil
+}
+
+func readDataLayout(dbDir string, storageEngine string) (DataLayout, error) {
+	layout := DataLayout{
+		Root:          dbDir,
+		StorageEngine: storageEngine,
+		LockFile:      filepath.Join(dbDir, lockFileName),
+	}
+
+	diagnosticDataDir := filepath.Join(dbDir, diagnosticDataDirName)
+	if info, err := os.Stat(diagnosticDataDir); err == nil && info.IsDir() {
+		layout.DiagnosticDataDir = diagnosticDataDir
+	}
+
+	if storageEngine != "wiredTiger" {
+		return layout, nil
+	}
+
+	err := filepath.WalkDir(dbDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// mongod creates and removes files while it runs
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			if path == diagnosticDataDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if path == layout.LockFile {
+			return nil
+		}
+
+		layout.WiredTigerFiles = append(layout.WiredTigerFiles, path)
+		return nil
+	})
+	if err != nil {
+		return DataLayout{}, fmt.Errorf("error reading data directory: %w", err)
+	}
+	sort.Strings(layout.WiredTigerFiles)
+
+	return layout, nil
+}
+
+// fsyncLocked asks the server whether writes are locked with fsyncLock
+func (s *Server) fsyncLocked(ctx context.Context) (bool, error) {
+	client, err := s.connect()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+
+	// currentOp only includes fsyncLock while writes are locked
+	var result struct {
+		FsyncLock bool `bson:"fsyncLock"`
+	}
+	err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "currentOp", Value: 1}, {Key: "$all", Value: false}}).Decode(&result)
+	if err != nil {
+		return false, err
+	}
+
+	return result.FsyncLock, nil
+}
@@ -0,0 +1,43 @@
+package memongo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadDataLayout(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"mongod.lock", "WiredTiger", "collection-0.wt", "journal/WiredTigerLog.0000000001", "diagnostic.data/metrics.2024-05-01T10-00-00Z-00000"} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, os.WriteFile(path, nil, 0600))
+	}
+
+	layout, err := readDataLayout(dir, "wiredTiger")
+	require.NoError(t, err)
+	assert.Equal(t, DataLayout{
+		Root:              dir,
+		StorageEngine:     "wiredTiger",
+		LockFile:          filepath.Join(dir, "mongod.lock"),
+		DiagnosticDataDir: filepath.Join(dir, "diagnostic.data"),
+		WiredTigerFiles: []string{
+			filepath.Join(dir, "WiredTiger"),
+			filepath.Join(dir, "collection-0.wt"),
+			filepath.Join(dir, "journal", "WiredTigerLog.0000000001"),
+		},
+	}, layout)
+	assert.False(t, layout.SafeToCopyWhileRunning())
+
+	layout.FsyncLocked = true
+	assert.True(t, layout.SafeToCopyWhileRunning())
+
+	layout, err = readDataLayout(dir, "ephemeralForTest")
+	require.NoError(t, err)
+	assert.Empty(t, layout.WiredTigerFiles)
+	layout.FsyncLocked = true
+	assert.False(t, layout.SafeToCopyWhileRunning())
+}
@@ -0,0 +1,317 @@
+// Package datagen generates reproducible pseudo-random documents from a
+// schema, for property-style tests that need realistic data of any size.
+// The same seed and schemas always give the same documents, byte for byte,
+// on any platform.
+//
+// memongo seeds a server with generated documents through
+// memongo.Options.SeedGenerated.
+package datagen
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Field is a field of the generated documents and how its values are
+// generated
+type Field struct {
+	Name string
+	Spec Spec
+}
+
+// Schema lists the fields of the generated documents, in order. It's a
+// slice rather than a map so that field order, and which random numbers
+// each field gets, don't depend on map iteration.
+type Schema []Field
+
+// Validate checks that every field has a name, which is unique, and a valid
+// spec
+func (s Schema) Validate() error {
+	seen := map[string]bool{}
+	for _, field := range s {
+		if field.Name == "" {
+			return fmt.Errorf("schema fields must have a name")
+		}
+		if seen[field.Name] {
+			return fmt.Errorf("schema field %s is given more than once", field.Name)
+		}
+		seen[field.Name] = true
+
+		if field.Spec == nil {
+			return fmt.Errorf("schema field %s has no spec", field.Name)
+		}
+		err := field.Spec.validate()
+		if err != nil {
+			return fmt.Errorf("invalid spec for schema field %s: %w", field.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Generator generates documents. Documents it generates for a collection
+// can be referred to from documents generated after them with Ref. A
+// Generator isn't safe for concurrent use.
+type Generator struct {
+	rng *rand.Rand
+
+	// ids holds the _id of every document generated, by collection
+	ids map[string][]interface{}
+}
+
+// New returns a Generator seeded with seed
+func New(seed int64) *Generator {
+	return &Generator{
+		// math/rand's sequence for a seed is fixed across platforms and Go
+		// releases
+		//nolint:gosec
+		rng: rand.New(rand.NewSource(seed)),
+		ids: map[string][]interface{}{},
+	}
+}
+
+// Generate generates n documents for collection from schema, calling fn
+// with each in turn, so large datasets needn't be held in memory. It stops
+// at the first error fn returns. If schema has an _id field, the ids are
+// kept for Ref.
+func (g *Generator) Generate(collection string, schema Schema, n int, fn func(bson.D) error) error {
+	err := schema.Validate()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < n; i++ {
+		doc := make(bson.D, len(schema))
+		for j, field := range schema {
+			value, err := field.Spec.generate(g, i)
+			if err != nil {
+				return fmt.Errorf("error generating %s.%s: %w", collection, field.Name, err)
+			}
+			doc[j] = bson.E{Key: field.Name, Value: value}
+			if field.Name == "_id" {
+				g.ids[collection] = append(g.ids[collection], value)
+			}
+		}
+
+		err := fn(doc)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Documents generates n documents for collection from schema, ready for
+// InsertMany
+func (g *Generator) Documents(collection string, schema Schema, n int) ([]interface{}, error) {
+	docs := make([]interface{}, 0, n)
+	err := g.Generate(collection, schema, n, func(doc bson.D) error {
+		docs = append(docs, doc)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return docs, nil
+}
+
+// Spec generates the values of a field. The specs are IntRange, Sequence,
+// StringPattern, ObjectID, DateRange, OneOf, Ref and Func.
+type Spec interface {
+	generate(g *Generator, index int) (interface{}, error)
+	validate() error
+}
+
+// IntRange generates int64s between Min and Max, inclusive
+type IntRange struct {
+	Min int64
+	Max int64
+}
+
+func (r IntRange) generate(g *Generator, _ int) (interface{}, error) {
+	return r.Min + g.rng.Int63n(r.Max-r.Min+1), nil
+}
+
+func (r IntRange) validate() error {
+	if r.Max < r.Min {
+		return fmt.Errorf("invalid IntRange: Max %d is less than Min %d", r.Max, r.Min)
+	}
+	if r.Max-r.Min+1 <= 0 {
+		return fmt.Errorf("invalid IntRange: %d-%d is too wide", r.Min, r.Max)
+	}
+
+	return nil
+}
+
+// Sequence generates the int64s Start, Start+1, ..., one per document. It
+// doesn't use the random numbers, so it makes readable _ids.
+type Sequence struct {
+	Start int64
+}
+
+func (s Sequence) generate(_ *Generator, index int) (interface{}, error) {
+	return s.Start + int64(index), nil
+}
+
+func (s Sequence) validate() error {
+	return nil
+}
+
+const (
+	patternDigits  = "0123456789"
+	patternLetters = "abcdefghijklmnopqrstuvwxyz"
+)
+
+// StringPattern generates strings from Pattern, in which '#' is replaced by
+// a random digit, '?' by a random lowercase letter, and '*' by either.
+// Other characters, and characters escaped with '\', are kept, e.g.
+// "user-????@example.com" or "\#####".
+type StringPattern struct {
+	Pattern string
+}
+
+func (p StringPattern) generate(g *Generator, _ int) (interface{}, error) {
+	out := make([]rune, 0, len(p.Pattern))
+	escaped := false
+	for _, r := range p.Pattern {
+		if escaped {
+			out = append(out, r)
+			escaped = false
+			continue
+		}
+
+		switch r {
+		case '\\':
+			escaped = true
+		case '#':
+			out = append(out, rune(patternDigits[g.rng.Intn(len(patternDigits))]))
+		case '?':
+			out = append(out, rune(patternLetters[g.rng.Intn(len(patternLetters))]))
+		case '*':
+			chars := patternDigits + patternLetters
+			out = append(out, rune(chars[g.rng.Intn(len(chars))]))
+		default:
+			out = append(out, r)
+		}
+	}
+
+	return string(out), nil
+}
+
+func (p StringPattern) validate() error {
+	if p.Pattern == "" {
+		return fmt.Errorf("invalid StringPattern: Pattern must be given")
+	}
+	if (len(p.Pattern)-len(trimTrailingBackslashes(p.Pattern)))%2 == 1 {
+		return fmt.Errorf("invalid StringPattern %q: it ends with an unfinished escape", p.Pattern)
+	}
+
+	return nil
+}
+
+// trimTrailingBackslashes returns s without the backslashes it ends with
+func trimTrailingBackslashes(s string) string {
+	for len(s) > 0 && s[len(s)-1] == '\\' {
+		s = s[:len(s)-1]
+	}
+
+	return s
+}
+
+// ObjectID generates ObjectIDs from random bytes. Unlike bson.NewObjectID,
+// they don't depend on the time or the machine.
+type ObjectID struct{}
+
+func (ObjectID) generate(g *Generator, _ int) (interface{}, error) {
+	var id bson.ObjectID
+	// Read from a math/rand.Rand never fails
+	_, _ = g.rng.Read(id[:])
+
+	return id, nil
+}
+
+func (ObjectID) validate() error {
+	return nil
+}
+
+// DateRange generates dates between From and To, inclusive, with the
+// millisecond precision BSON stores
+type DateRange struct {
+	From time.Time
+	To   time.Time
+}
+
+func (r DateRange) generate(g *Generator, _ int) (interface{}, error) {
+	from, to := r.From.UnixMilli(), r.To.UnixMilli()
+	return bson.DateTime(from + g.rng.Int63n(to-from+1)), nil
+}
+
+func (r DateRange) validate() error {
+	if r.To.Before(r.From) {
+		return fmt.Errorf("invalid DateRange: To %s is before From %s", r.To, r.From)
+	}
+
+	return nil
+}
+
+// OneOf generates one of Values, each as likely as the others
+type OneOf struct {
+	Values []interface{}
+}
+
+func (o OneOf) generate(g *Generator, _ int) (interface{}, error) {
+	return o.Values[g.rng.Intn(len(o.Values))], nil
+}
+
+func (o OneOf) validate() error {
+	if len(o.Values) == 0 {
+		return fmt.Errorf("invalid OneOf: Values must not be empty")
+	}
+
+	return nil
+}
+
+// Ref generates the _id of a random document generated earlier for
+// Collection by the same Generator, for references between collections
+type Ref struct {
+	Collection string
+}
+
+func (r Ref) generate(g *Generator, _ int) (interface{}, error) {
+	ids := g.ids[r.Collection]
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no documents with an _id were generated for %s to refer to", r.Collection)
+	}
+
+	return ids[g.rng.Intn(len(ids))], nil
+}
+
+func (r Ref) validate() error {
+	if r.Collection == "" {
+		return fmt.Errorf("invalid Ref: Collection must be given")
+	}
+
+	return nil
+}
+
+// Func generates values with a function, for anything the other specs
+// can't express. For the documents to be reproducible, it must only use r
+// for randomness.
+type Func func(r *rand.Rand) interface{}
+
+func (f Func) generate(g *Generator, _ int) (interface{}, error) {
+	return f(g.rng), nil
+}
+
+func (f Func) validate() error {
+	if f == nil {
+		return fmt.Errorf("invalid Func: it's nil")
+	}
+
+	return nil
+}
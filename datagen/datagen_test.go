@@ -0,0 +1,201 @@
+package datagen
+
+import (
+	"bytes"
+	"flag"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+var (
+	userSchema = Schema{
+		{Name: "_id", Spec: ObjectID{}},
+		{Name: "email", Spec: StringPattern{Pattern: "user-????##@example.com"}},
+		{Name: "age", Spec: IntRange{Min: 18, Max: 90}},
+		{Name: "plan", Spec: OneOf{Values: []interface{}{"free", "pro", "team"}}},
+		{Name: "createdAt", Spec: DateRange{
+			From: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC),
+		}},
+	}
+
+	orderSchema = Schema{
+		{Name: "_id", Spec: Sequence{Start: 1000}},
+		{Name: "user", Spec: Ref{Collection: "users"}},
+		{Name: "sku", Spec: StringPattern{Pattern: `\#***-#`}},
+		{Name: "quantity", Spec: Func(func(r *rand.Rand) interface{} { return int32(1 + r.Intn(5)) })},
+	}
+)
+
+// generateSample generates users and orders referring to them, as
+// canonical extended JSON, one document per line
+func generateSample(t testing.TB, seed int64) []byte {
+	out := &bytes.Buffer{}
+	gen := New(seed)
+	write := func(doc bson.D) error {
+		line, err := bson.MarshalExtJSON(doc, true, false)
+		if err != nil {
+			return err
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+		return nil
+	}
+
+	require.NoError(t, gen.Generate("users", userSchema, 5, write))
+	require.NoError(t, gen.Generate("orders", orderSchema, 5, write))
+
+	return out.Bytes()
+}
+
+func TestGenerateGolden(t *testing.T) {
+	out := generateSample(t, 42)
+
+	golden := filepath.Join("testdata", "sample.golden")
+	if *update {
+		require.NoError(t, os.WriteFile(golden, out, 0600))
+	}
+
+	expected, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(out))
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	assert.Equal(t, generateSample(t, 7), generateSample(t, 7))
+	assert.NotEqual(t, generateSample(t, 7), generateSample(t, 8))
+
+	// The raw BSON matches too
+	first, err := New(7).Documents("users", userSchema, 100)
+	require.NoError(t, err)
+	second, err := New(7).Documents("users", userSchema, 100)
+	require.NoError(t, err)
+	for i := range first {
+		a, err := bson.Marshal(first[i])
+		require.NoError(t, err)
+		b, err := bson.Marshal(second[i])
+		require.NoError(t, err)
+		assert.Equal(t, a, b)
+	}
+}
+
+func TestGenerateValues(t *testing.T) {
+	gen := New(1)
+	users, err := gen.Documents("users", userSchema, 200)
+	require.NoError(t, err)
+	ids := map[bson.ObjectID]bool{}
+	for _, doc := range users {
+		user := doc.(bson.D)
+		assert.Equal(t, []string{"_id", "email", "age", "plan", "createdAt"}, keys(user))
+		ids[user[0].Value.(bson.ObjectID)] = true
+		assert.Regexp(t, `^user-[a-z]{4}[0-9]{2}@example\.com$`, user[1].Value)
+		age := user[2].Value.(int64)
+		assert.True(t, age >= 18 && age <= 90, age)
+		assert.Contains(t, []interface{}{"free", "pro", "team"}, user[3].Value)
+		created := user[4].Value.(bson.DateTime).Time()
+		assert.False(t, created.Before(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+		assert.False(t, created.After(time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)))
+	}
+	assert.Len(t, ids, 200)
+
+	orders, err := gen.Documents("orders", orderSchema, 50)
+	require.NoError(t, err)
+	for i, doc := range orders {
+		order := doc.(bson.D)
+		assert.Equal(t, int64(1000+i), order[0].Value)
+		assert.True(t, ids[order[1].Value.(bson.ObjectID)], "orders refer to generated users")
+		assert.Regexp(t, `^#[0-9a-z]{3}-[0-9]$`, order[2].Value)
+	}
+}
+
+func keys(doc bson.D) []string {
+	names := make([]string, len(doc))
+	for i, e := range doc {
+		names[i] = e.Key
+	}
+	return names
+}
+
+func TestGenerateRefWithoutDocuments(t *testing.T) {
+	_, err := New(1).Documents("orders", orderSchema, 1)
+	assert.EqualError(t, err, "error generating orders.user: no documents with an _id were generated for users to refer to")
+}
+
+func TestSchemaValidate(t *testing.T) {
+	tests := map[string]struct {
+		schema        Schema
+		expectedError string
+	}{
+		"no name": {
+			schema:        Schema{{Spec: ObjectID{}}},
+			expectedError: "schema fields must have a name",
+		},
+		"duplicate": {
+			schema:        Schema{{Name: "a", Spec: ObjectID{}}, {Name: "a", Spec: ObjectID{}}},
+			expectedError: "schema field a is given more than once",
+		},
+		"no spec": {
+			schema:        Schema{{Name: "a"}},
+			expectedError: "schema field a has no spec",
+		},
+		"IntRange": {
+			schema:        Schema{{Name: "a", Spec: IntRange{Min: 2, Max: 1}}},
+			expectedError: "invalid spec for schema field a: invalid IntRange: Max 1 is less than Min 2",
+		},
+		"StringPattern": {
+			schema:        Schema{{Name: "a", Spec: StringPattern{Pattern: `id\`}}},
+			expectedError: `invalid spec for schema field a: invalid StringPattern "id\\": it ends with an unfinished escape`,
+		},
+		"DateRange": {
+			schema:        Schema{{Name: "a", Spec: DateRange{From: time.Unix(1, 0), To: time.Unix(0, 0)}}},
+			expectedError: "invalid spec for schema field a: invalid DateRange: To",
+		},
+		"OneOf": {
+			schema:        Schema{{Name: "a", Spec: OneOf{}}},
+			expectedError: "invalid spec for schema field a: invalid OneOf: Values must not be empty",
+		},
+		"Ref": {
+			schema:        Schema{{Name: "a", Spec: Ref{}}},
+			expectedError: "invalid spec for schema field a: invalid Ref: Collection must be given",
+		},
+		"Func": {
+			schema:        Schema{{Name: "a", Spec: Func(nil)}},
+			expectedError: "invalid spec for schema field a: invalid Func: it's nil",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.schema.Validate()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expectedError)
+		})
+	}
+
+	assert.NoError(t, userSchema.Validate())
+	assert.NoError(t, Schema{{Name: "a", Spec: StringPattern{Pattern: `\\`}}}.Validate())
+}
+
+func BenchmarkGenerate(b *testing.B) {
+	schema := Schema{
+		{Name: "_id", Spec: ObjectID{}},
+		{Name: "n", Spec: IntRange{Min: 0, Max: 1000}},
+		{Name: "s", Spec: StringPattern{Pattern: "????-####"}},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := New(1).Generate("c", schema, 10000, func(bson.D) error { return nil })
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package memongo
+
+import "sort"
+
+// MemberPath is the data directory of a replica set member, or of a
+// standalone server
+type MemberPath struct {
+	// Member is the member's index: 0 for the mongod memongo started, or the
+	// index AddReplicaMember returned. For a sharded cluster, it's the index
+	// of a mongos.
+	Member int
+
+	// Role is "standalone", or what the member was started as: "primary"
+	// for member 0, and "secondary" or "hidden" for the others. Elections
+	// may have changed it since. For a sharded cluster, it's "mongos", and
+	// "configsvr" or "shardsvr" for the config server and the shards.
+	Role string
+
+	// ReplicaSet is the name of the replica set of a sharded cluster's
+	// config server or shard, e.g. "shard0"
+	ReplicaSet string
+
+	// Path is the data directory
+	Path string
+}
+
+// DBPaths returns the data directories of the server and of the replica set
+// members added with AddReplicaMember, by member index, for tools that
+// collect or measure them. Unlike DBPath, it covers every member. What
+// becomes of the directories when the server is stopped is up to
+// Options.Cleanup. For a sharded cluster, the directories of the mongos, by
+// index, are followed by the config server's and then the shards'.
+func (s *Server) DBPaths() []MemberPath {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	primary := MemberPath{Member: 0, Role: "standalone", Path: s.dbDir}
+	if s.isReplicaSet {
+		primary.Role = "primary"
+	}
+	if s.cluster != nil {
+		primary.Role = "mongos"
+	}
+	paths := []MemberPath{primary}
+	for index, proc := range s.members {
+		role := "secondary"
+		if proc.hidden {
+			role = "hidden"
+		}
+		paths = append(paths, MemberPath{Member: index, Role: role, Path: proc.dbDir})
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return paths[i].Member < paths[j].Member
+	})
+
+	if s.cluster != nil {
+		s.cluster.mu.Lock()
+		for i, router := range s.cluster.routers {
+			paths = append(paths, MemberPath{Member: i + 1, Role: "mongos", Path: router.dbDir})
+		}
+		s.cluster.mu.Unlock()
+	}
+	for _, server := range s.cluster.servers() {
+		paths = append(paths, MemberPath{Member: 0, Role: server.opts.clusterRole, ReplicaSet: server.replicaSetName, Path: server.dbDir})
+	}
+
+	return paths
+}
+
+// dbPathList returns the paths of DBPaths
+func (s *Server) dbPathList() []string {
+	paths := s.DBPaths()
+	dirs := make([]string, len(paths))
+	for i, p := range paths {
+		dirs[i] = p.Path
+	}
+
+	return dirs
+}
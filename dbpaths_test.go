@@ -0,0 +1,39 @@
+package memongo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerDBPaths(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir(), t.TempDir()}
+	for i, dir := range dirs {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "collection.wt"), make([]byte, 10*(i+1)), 0600))
+	}
+
+	s := &Server{
+		dbDir:        dirs[0],
+		isReplicaSet: true,
+		members: map[int]*mongodProcess{
+			3: {member: 3, dbDir: dirs[2], hidden: true},
+			1: {member: 1, dbDir: dirs[1]},
+		},
+	}
+	assert.Equal(t, []MemberPath{
+		{Member: 0, Role: "primary", Path: dirs[0]},
+		{Member: 1, Role: "secondary", Path: dirs[1]},
+		{Member: 3, Role: "hidden", Path: dirs[2]},
+	}, s.DBPaths())
+	assert.Equal(t, dirs[0], s.DBPath())
+
+	usage, err := s.DiskUsage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(60), usage)
+
+	standalone := &Server{dbDir: dirs[0]}
+	assert.Equal(t, []MemberPath{{Member: 0, Role: "standalone", Path: dirs[0]}}, standalone.DBPaths())
+}
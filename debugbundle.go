@@ -0,0 +1,281 @@
+package memongo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// debugBundleCommands is how many of the most recent captured commands a
+// debug bundle includes
+const debugBundleCommands = 100
+
+// debugBundleTimeout bounds the commands run to write a bundle when a failed
+// server is stopped, since its mongod may be gone
+var debugBundleTimeout = 10 * time.Second
+
+// bundleEntry is a file in a debug bundle
+type bundleEntry struct {
+	name string
+	data []byte
+
+	// binary is set for files that are copied as is, without redaction
+	binary bool
+}
+
+// WriteDebugBundle writes a tar.gz to path holding what's useful to debug a
+// failed test, so CI can keep one artifact:
+//
+//   - options.txt: the effective options
+//   - start-report.json: the StartReport
+//   - mongod.log: the last lines mongod printed, and mongod-member-N.log for
+//     each member added with AddReplicaMember
+//   - startup-warnings.json, server-status.json and, for a replica set,
+//     repl-set-status.json: the output of getLog startupWarnings,
+//     serverStatus and replSetGetStatus
+//   - commands.jsonl: the last 100 captured commands, with
+//     Options.CaptureCommands
+//   - diagnostic.data/: mongod's diagnostic data, up to
+//     Options.DebugBundleDiagnosticDataBytes, if that's set
+//   - errors.txt: the errors running the commands above, such as when mongod
+//     has exited
+//
+// Secrets are redacted unless Options.DisableRedaction is set. Entries are
+// written in a fixed order with fixed timestamps. Commands that fail are
+// recorded in errors.txt rather than failing the bundle, which works after
+// Stop too, with what's left.
+func (s *Server) WriteDebugBundle(ctx context.Context, path string) error {
+	entries := s.debugBundleEntries(ctx)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		data := entry.data
+		if !entry.binary && !s.opts.DisableRedaction {
+			data = []byte(redactText(string(data)))
+		}
+
+		err := tw.WriteHeader(&tar.Header{
+			Name:    entry.name,
+			Mode:    0o644,
+			Size:    int64(len(data)),
+			ModTime: time.Unix(0, 0),
+		})
+		if err == nil {
+			_, err = tw.Write(data)
+		}
+		if err != nil {
+			return fmt.Errorf("error writing %s to the debug bundle: %w", entry.name, err)
+		}
+	}
+	err := tw.Close()
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("error writing the debug bundle: %w", err)
+	}
+
+	err = os.WriteFile(path, buf.Bytes(), 0o644)
+	if err != nil {
+		return fmt.Errorf("error writing the debug bundle: %w", err)
+	}
+
+	return nil
+}
+
+// debugBundleEntries collects the files of a debug bundle
+func (s *Server) debugBundleEntries(ctx context.Context) []bundleEntry {
+	var entries []bundleEntry
+	var errs []string
+	add := func(name string, data []byte, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err))
+			return
+		}
+		entries = append(entries, bundleEntry{name: name, data: data})
+	}
+
+	add("options.txt", []byte(fmt.Sprintf("%+v\n", *s.EffectiveOptions())), nil)
+
+	report := s.StartReport()
+	attemptErrors := make([]string, len(report.AttemptErrors))
+	for i, err := range report.AttemptErrors {
+		attemptErrors[i] = err.Error()
+	}
+	data, err := json.MarshalIndent(struct {
+		StartReport
+		AttemptErrors []string
+	}{report, attemptErrors}, "", "  ")
+	add("start-report.json", data, err)
+
+	s.mu.Lock()
+	add("mongod.log", outputLines(s.proc.output), nil)
+	indexes := make([]int, 0, len(s.members))
+	for index := range s.members {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		add(fmt.Sprintf("mongod-member-%d.log", index), outputLines(s.members[index].output), nil)
+	}
+	s.mu.Unlock()
+
+	client, err := mongo.Connect(options.Client().ApplyURI(s.DirectURI()))
+	if err != nil {
+		add("server", nil, fmt.Errorf("failed to connect: %w", err))
+	} else {
+		defer func() {
+			_ = client.Disconnect(context.Background())
+		}()
+
+		admin := client.Database("admin")
+		data, err = runForBundle(ctx, admin, bson.D{{Key: "getLog", Value: "startupWarnings"}})
+		add("startup-warnings.json", data, err)
+		data, err = runForBundle(ctx, admin, bson.D{{Key: "serverStatus", Value: 1}})
+		add("server-status.json", data, err)
+		if s.isReplicaSet {
+			data, err = runForBundle(ctx, admin, bson.D{{Key: "replSetGetStatus", Value: 1}})
+			add("repl-set-status.json", data, err)
+		}
+	}
+
+	if s.capture != nil {
+		data, err = capturedCommandLines(s.CapturedCommands(CommandFilter{}))
+		add("commands.jsonl", data, err)
+	}
+
+	if s.opts.DebugBundleDiagnosticDataBytes > 0 {
+		files, err := diagnosticData(s.dbDir, s.opts.DebugBundleDiagnosticDataBytes)
+		if err != nil {
+			add("diagnostic.data", nil, err)
+		}
+		entries = append(entries, files...)
+	}
+
+	if len(errs) > 0 {
+		entries = append(entries, bundleEntry{name: "errors.txt", data: []byte(strings.Join(errs, "\n") + "\n")})
+	}
+
+	return entries
+}
+
+// outputLines returns the lines kept by tail, one per line
+func outputLines(tail *outputTail) []byte {
+	var buf bytes.Buffer
+	for _, line := range tail.recent() {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes()
+}
+
+// runForBundle runs cmd and returns its result as indented extended JSON
+func runForBundle(ctx context.Context, db *mongo.Database, cmd bson.D) ([]byte, error) {
+	raw, err := db.RunCommand(ctx, cmd).Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.MarshalExtJSONIndent(raw, false, false, "", "  ")
+}
+
+// capturedCommandLines returns the last debugBundleCommands of commands as
+// extended JSON, one per line
+func capturedCommandLines(commands []CapturedCommand) ([]byte, error) {
+	if len(commands) > debugBundleCommands {
+		commands = commands[len(commands)-debugBundleCommands:]
+	}
+
+	var buf bytes.Buffer
+	for _, cmd := range commands {
+		line, err := bson.MarshalExtJSON(bson.D{
+			{Key: "name", Value: cmd.Name},
+			{Key: "database", Value: cmd.Database},
+			{Key: "started", Value: cmd.Started},
+			{Key: "durationMS", Value: cmd.Duration.Milliseconds()},
+			{Key: "finished", Value: cmd.Finished},
+			{Key: "failure", Value: cmd.Failure},
+			{Key: "command", Value: cmd.Command},
+		}, false, false)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding a captured %s command: %w", cmd.Name, err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), nil
+}
+
+// diagnosticData returns the files in the diagnostic.data directory of
+// dbDir, in name order, skipping any that would take the total past
+// maxBytes. It returns none if the directory doesn't exist.
+func diagnosticData(dbDir string, maxBytes int64) ([]bundleEntry, error) {
+	dir := filepath.Join(dbDir, "diagnostic.data")
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []bundleEntry
+	var total int64
+	for _, file := range files {
+		if !file.Type().IsRegular() {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return entries, err
+		}
+		if total+info.Size() > maxBytes {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return entries, err
+		}
+		total += int64(len(data))
+		entries = append(entries, bundleEntry{name: "diagnostic.data/" + file.Name(), data: data, binary: true})
+	}
+
+	return entries, nil
+}
+
+// writeFailureBundle writes a debug bundle into Options.DebugBundleOnFailure
+// for a server that failed, before it's stopped
+func (s *Server) writeFailureBundle() {
+	err := os.MkdirAll(s.opts.DebugBundleOnFailure, 0o755)
+	if err != nil {
+		s.logger.Warnf("error creating the debug bundle directory: %s", err)
+		return
+	}
+
+	path := filepath.Join(s.opts.DebugBundleOnFailure, "memongo-"+filepath.Base(s.dbDir)+".tar.gz")
+	ctx, cancel := context.WithTimeout(context.Background(), debugBundleTimeout)
+	defer cancel()
+	err = s.WriteDebugBundle(ctx, path)
+	if err != nil {
+		s.logger.Warnf("%s", err)
+		return
+	}
+	s.logger.Warnf("The server failed; wrote a debug bundle to %s", path)
+}
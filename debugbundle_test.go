@@ -0,0 +1,143 @@
+package memongo
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/event"
+)
+
+// readBundle returns the files in the debug bundle at path, in order
+func readBundle(t *testing.T, path string) ([]string, map[string]string) {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+
+	var names []string
+	files := map[string]string{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		names = append(names, header.Name)
+		files[header.Name] = string(data)
+	}
+
+	return names, files
+}
+
+func TestWriteDebugBundle(t *testing.T) {
+	server := fakeServer(t, Cleanup{})
+	defer server.Stop()
+	server.opts.DownloadURL = "https://ada:" + sentinelSecret + "@downloads.example.com/mongodb.tgz"
+	server.proc.output = &outputTail{}
+	_, _ = server.proc.output.Write([]byte("connecting to mongodb://ada:" + sentinelSecret + "@db.example.com\n"))
+	server.capture = newCommandCapture()
+	server.capture.started(&event.CommandStartedEvent{
+		CommandName:  "createUser",
+		DatabaseName: "admin",
+		Command:      mustMarshal(t, bson.D{{Key: "createUser", Value: "ada"}, {Key: "pwd", Value: sentinelSecret}}),
+	})
+
+	path := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, server.WriteDebugBundle(ctx, path))
+
+	names, files := readBundle(t, path)
+	assert.Equal(t, []string{"options.txt", "start-report.json", "mongod.log", "commands.jsonl", "errors.txt"}, names)
+	assert.Contains(t, files["options.txt"], "downloads.example.com")
+	assert.Contains(t, files["mongod.log"], "db.example.com")
+	assert.Contains(t, files["commands.jsonl"], `"name":"createUser"`)
+	assert.Contains(t, files["errors.txt"], "server-status.json")
+	for name, data := range files {
+		assert.NotContains(t, data, sentinelSecret, name)
+	}
+
+	// Without redaction the secrets are kept
+	server.opts.DisableRedaction = true
+	require.NoError(t, server.WriteDebugBundle(ctx, path))
+	_, files = readBundle(t, path)
+	assert.Contains(t, files["mongod.log"], sentinelSecret)
+}
+
+func TestCapturedCommandLines(t *testing.T) {
+	var commands []CapturedCommand
+	for i := 0; i < debugBundleCommands+50; i++ {
+		commands = append(commands, CapturedCommand{Name: fmt.Sprintf("cmd%d", i), Command: mustMarshal(t, bson.D{{Key: "ping", Value: 1}})})
+	}
+
+	data, err := capturedCommandLines(commands)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, debugBundleCommands)
+	assert.Contains(t, lines[0], `"name":"cmd50"`)
+	assert.Contains(t, lines[len(lines)-1], fmt.Sprintf(`"name":"cmd%d"`, debugBundleCommands+49))
+}
+
+func TestDiagnosticData(t *testing.T) {
+	dbDir := t.TempDir()
+	entries, err := diagnosticData(dbDir, 100)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	dir := filepath.Join(dbDir, "diagnostic.data")
+	require.NoError(t, os.Mkdir(dir, 0o755))
+	for name, size := range map[string]int{"metrics.1": 10, "metrics.2": 30, "metrics.interim": 10} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0o644))
+	}
+
+	// metrics.2 would take it past the cap
+	entries, err = diagnosticData(dbDir, 25)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.name)
+		assert.True(t, entry.binary)
+	}
+	assert.Equal(t, []string{"diagnostic.data/metrics.1", "diagnostic.data/metrics.interim"}, names)
+}
+
+func TestDebugBundleOnFailure(t *testing.T) {
+	defer func(timeout time.Duration) { debugBundleTimeout = timeout }(debugBundleTimeout)
+	debugBundleTimeout = 100 * time.Millisecond
+
+	for _, failed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("failed %t", failed), func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "bundles")
+			server := fakeServer(t, Cleanup{})
+			server.opts.DebugBundleOnFailure = dir
+			if failed {
+				server.MarkFailed()
+			}
+			server.Stop()
+
+			path := filepath.Join(dir, "memongo-"+filepath.Base(server.dbDir)+".tar.gz")
+			if !failed {
+				assert.NoFileExists(t, path)
+				return
+			}
+			names, _ := readBundle(t, path)
+			assert.Contains(t, names, "start-report.json")
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package memongo
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+)
+
+// DisklessStrategy is how a server started with Options.Diskless keeps its
+// data off the disk
+type DisklessStrategy string
+
+const (
+	// DisklessNone means the server writes to disk: it wasn't started with
+	// Diskless, or no tmpfs was found to put its data directory on
+	DisklessNone DisklessStrategy = ""
+
+	// DisklessInMemory means the server runs the inMemory storage engine of
+	// MongoDB Enterprise
+	DisklessInMemory DisklessStrategy = "inMemory"
+
+	// DisklessTmpfs means the server's data directory is on a tmpfs, with
+	// the smallest WiredTiger cache mongod accepts
+	DisklessTmpfs DisklessStrategy = "tmpfs"
+)
+
+// reEnterpriseModule matches the modules line of mongod --version for an
+// Enterprise build, in the JSON build info of 3.4 and later or the plain
+// text of earlier versions
+var reEnterpriseModule = regexp.MustCompile(`"modules"\s*:\s*\[[^\]]*"enterprise"|modules: enterprise`)
+
+// tmpfsCandidates returns the directories that are usually on a tmpfs, in
+// order of preference. It's a variable so tests can fake it.
+var tmpfsCandidates = func() []string {
+	return []string{"/dev/shm", os.Getenv("XDG_RUNTIME_DIR")}
+}
+
+// resolveDiskless picks how a Diskless server keeps its data off the disk,
+// recording it in disklessStrategy, and where its data directory goes in
+// dbDirBase
+func (opts *Options) resolveDiskless(binPath string, logger *memongolog.Logger) {
+	if !opts.Diskless {
+		return
+	}
+
+	if opts.isEnterpriseBinary(binPath) {
+		opts.disklessStrategy = DisklessInMemory
+		logger.Debugf("Diskless: using the inMemory storage engine")
+		return
+	}
+
+	dir := opts.DataDir
+	if dir == "" {
+		dir = opts.TempDirBase
+	}
+	if dir == "" {
+		for _, candidate := range tmpfsCandidates() {
+			if candidate != "" && isTmpfs(candidate) {
+				dir = candidate
+				break
+			}
+		}
+	}
+	if dir == "" || !isTmpfs(dir) {
+		logger.Warnf("Diskless: mongod isn't an Enterprise build with the inMemory storage engine, and no tmpfs was found for the data directory (set DataDir to one); the data directory is on disk")
+		return
+	}
+
+	opts.disklessStrategy = DisklessTmpfs
+	opts.dbDirBase = dir
+	if opts.WiredTigerCacheSizeGB == 0 && opts.WiredTigerCacheSizePct == 0 {
+		opts.resolvedCacheSizeGB = minWiredTigerCacheSizeGB
+	}
+	logger.Debugf("Diskless: putting the data directory on the tmpfs at %s", dir)
+}
+
+func (opts *Options) validateDiskless() error {
+	if opts.CompactOnInterval > 0 {
+		return fmt.Errorf("cannot use Diskless with CompactOnInterval")
+	}
+
+	// What's kept wouldn't be the server's data
+	if opts.Cleanup.RemoveDBPath == CleanupNever {
+		return fmt.Errorf("cannot use Diskless with Cleanup.RemoveDBPath CleanupNever: there's no data on disk to keep")
+	}
+
+	return nil
+}
+
+// isTmpfs returns whether dir is on a tmpfs
+func isTmpfs(dir string) bool {
+	fsType, err := statFilesystem(dir)
+	return err == nil && fsType == "tmpfs"
+}
+
+// isEnterpriseBinary returns whether the mongod at binPath is an Enterprise
+// build, which has the inMemory storage engine
+func (opts *Options) isEnterpriseBinary(binPath string) bool {
+	program, args := opts.mongodCommandLine(binPath, "--version")
+
+	// binPath is the mongod binary we're about to run anyway
+	//nolint:gosec
+	out, err := exec.Command(program, args...).Output()
+	if err != nil {
+		return false
+	}
+
+	return reEnterpriseModule.Match(out)
+}
+
+// dataDirBase returns the directory data directories are created in
+func (opts *Options) dataDirBase() string {
+	if opts.dbDirBase != "" {
+		return opts.dbDirBase
+	}
+	if opts.DataDir != "" {
+		return opts.DataDir
+	}
+
+	return opts.TempDirBase
+}
+
+// IsDiskless returns how the server keeps its data off the disk, if it was
+// started with Options.Diskless: DisklessInMemory or DisklessTmpfs. It
+// returns DisklessNone otherwise, or if Diskless found no way to.
+func (s *Server) IsDiskless() DisklessStrategy {
+	return s.opts.disklessStrategy
+}
@@ -0,0 +1,131 @@
+package memongo
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTmpfs makes dir the only tmpfs, and the only tmpfs candidate
+func fakeTmpfs(t *testing.T, dir string) {
+	candidates := tmpfsCandidates
+	statFilesystem = func(path string) (string, error) {
+		if path == dir {
+			return "tmpfs", nil
+		}
+		return "ext4", nil
+	}
+	tmpfsCandidates = func() []string {
+		return []string{"", dir}
+	}
+	t.Cleanup(func() {
+		statFilesystem = filesystemType
+		tmpfsCandidates = candidates
+	})
+}
+
+// argAfter returns the argument after flag in args, or "" if there's none
+func argAfter(args []string, flag string) string {
+	for i, arg := range args[:len(args)-1] {
+		if arg == flag {
+			return args[i+1]
+		}
+	}
+
+	return ""
+}
+
+func TestResolveDisklessTmpfs(t *testing.T) {
+	shm := t.TempDir()
+	fakeTmpfs(t, shm)
+	binPath := writeScript(t, `echo "db version v7.0.2"; echo 'Build Info: {"modules": [], "version": "7.0.2"}'`)
+	logger := memongolog.New(nil, memongolog.LogLevelSilent)
+	caps, err := capabilitiesForVersion("7.0.2")
+	require.NoError(t, err)
+
+	opts := &Options{Diskless: true}
+	opts.resolveDiskless(binPath, logger)
+	assert.Equal(t, DisklessTmpfs, opts.disklessStrategy)
+	assert.Equal(t, shm, opts.dataDirBase())
+	args, engine := mongodArgs(opts, caps, "/dev/shm/db", 27017, "", "")
+	assert.Equal(t, "wiredTiger", engine)
+	assert.Equal(t, "0.25", argAfter(args, "--wiredTigerCacheSizeGB"))
+
+	// A cache size that's given is kept
+	opts = &Options{Diskless: true, WiredTigerCacheSizeGB: 1}
+	opts.resolveDiskless(binPath, logger)
+	assert.Equal(t, DisklessTmpfs, opts.disklessStrategy)
+	assert.Zero(t, opts.resolvedCacheSizeGB)
+
+	// TempDirBase is used if it's a tmpfs
+	other := t.TempDir()
+	fakeTmpfs(t, other)
+	opts = &Options{Diskless: true, TempDirBase: other}
+	opts.resolveDiskless(binPath, logger)
+	assert.Equal(t, other, opts.dataDirBase())
+
+	// DataDir is used rather than TempDirBase
+	opts = &Options{Diskless: true, DataDir: other, TempDirBase: t.TempDir()}
+	opts.resolveDiskless(binPath, logger)
+	assert.Equal(t, DisklessTmpfs, opts.disklessStrategy)
+	assert.Equal(t, other, opts.dataDirBase())
+
+	opts = &Options{TempDirBase: other}
+	opts.resolveDiskless(binPath, logger)
+	assert.Equal(t, DisklessNone, opts.disklessStrategy)
+	assert.Zero(t, opts.resolvedCacheSizeGB)
+}
+
+func TestResolveDisklessNoTmpfs(t *testing.T) {
+	fakeTmpfs(t, "/nonexistent")
+	binPath := writeScript(t, `echo "db version v7.0.2"`)
+	out := &bytes.Buffer{}
+	logger := memongolog.New(log.New(out, "", 0), memongolog.LogLevelWarn)
+
+	// A TempDirBase on disk isn't replaced
+	base := t.TempDir()
+	opts := &Options{Diskless: true, TempDirBase: base}
+	opts.resolveDiskless(binPath, logger)
+	assert.Equal(t, DisklessNone, opts.disklessStrategy)
+	assert.Equal(t, base, opts.dataDirBase())
+	assert.Contains(t, out.String(), "no tmpfs was found")
+}
+
+func TestResolveDisklessEnterprise(t *testing.T) {
+	fakeTmpfs(t, "/nonexistent")
+	binPath := writeScript(t, `echo "db version v7.0.2"; echo 'Build Info: {'; echo '    "modules": ['; echo '        "enterprise"'; echo '    ],'; echo '}'`)
+	caps, err := capabilitiesForVersion("7.0.2")
+	require.NoError(t, err)
+
+	opts := &Options{Diskless: true, ShouldUseReplica: true, ReplicaSetName: "rs0"}
+	opts.resolveDiskless(binPath, memongolog.New(nil, memongolog.LogLevelSilent))
+	assert.Equal(t, DisklessInMemory, opts.disklessStrategy)
+
+	args, engine := mongodArgs(opts, caps, "/tmp/db", 27017, "", "")
+	assert.Equal(t, "inMemory", engine)
+	assert.Equal(t, "inMemory", argAfter(args, "--storageEngine"))
+	assert.Contains(t, args, "--bind_ip")
+	assert.NotContains(t, args, "--wiredTigerCacheSizeGB")
+
+	server := &Server{opts: *opts}
+	assert.Equal(t, DisklessInMemory, server.IsDiskless())
+
+	// Versions before 3.4 don't print JSON
+	assert.True(t, reEnterpriseModule.MatchString("modules: enterprise"))
+	assert.False(t, reEnterpriseModule.MatchString(`"modules": []`))
+}
+
+func TestValidateDiskless(t *testing.T) {
+	opts := &Options{MongodBin: "/bin/true", Diskless: true}
+	require.NoError(t, opts.Validate())
+
+	opts.CompactOnInterval = 1
+	assert.EqualError(t, opts.Validate(), "cannot use Diskless with CompactOnInterval")
+
+	opts = &Options{MongodBin: "/bin/true", Diskless: true, Cleanup: Cleanup{RemoveDBPath: CleanupNever}}
+	assert.EqualError(t, opts.Validate(), "cannot use Diskless with Cleanup.RemoveDBPath CleanupNever: there's no data on disk to keep")
+}
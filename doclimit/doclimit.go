@@ -0,0 +1,86 @@
+// Package doclimit generates documents of exact BSON sizes, for testing how
+// code handles the server's document and batch size limits without
+// hand-rolling padding each time.
+//
+// (It isn't called testdata, since the go tool ignores directories by that
+// name.)
+package doclimit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/100mslive/memongo/v2"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// MinDocumentSize is the size of the smallest document GenerateDocumentOfSize
+// returns: one with an _id and an empty padding field
+const MinDocumentSize = 32
+
+// PadField is the field GenerateDocumentOfSize pads documents with
+const PadField = "pad"
+
+// paddingOverhead is the encoded size of a document with an ObjectID _id and
+// an empty PadField: the length prefix (4), the _id element (1 type + 4 name
+// + 12 value), the padding element (1 type + 4 name + 4 string length + 1
+// terminator), and the terminator (1)
+const paddingOverhead = 4 + 17 + 10 + 1
+
+// GenerateDocumentOfSize returns a document with a new ObjectID _id whose
+// BSON encoding is exactly size bytes, padded with a string in PadField.
+// Sizes below MinDocumentSize give a document of MinDocumentSize bytes.
+func GenerateDocumentOfSize(size int) bson.D {
+	padding := size - paddingOverhead
+	if padding < 0 {
+		padding = 0
+	}
+
+	return bson.D{
+		{Key: "_id", Value: bson.NewObjectID()},
+		{Key: PadField, Value: strings.Repeat("x", padding)},
+	}
+}
+
+// GenerateDocuments returns n documents of size bytes each, with distinct
+// _ids, ready for InsertMany. n larger than the server's maxWriteBatchSize
+// (100,000) makes the driver split the insert into several batches.
+func GenerateDocuments(n int, size int) []interface{} {
+	docs := make([]interface{}, n)
+	for i := range docs {
+		docs[i] = GenerateDocumentOfSize(size)
+	}
+
+	return docs
+}
+
+// InsertAtLimit inserts a document of exactly the server's maximum document
+// size into coll, which should succeed
+func InsertAtLimit(ctx context.Context, server *memongo.Server, coll *mongo.Collection) error {
+	return insertRelativeToLimit(ctx, server, coll, 0)
+}
+
+// InsertOverLimit tries to insert a document one byte larger than the
+// server's maximum document size into coll, and returns the error it fails
+// with, as the driver or server reported it. It returns an error saying so
+// if the insert succeeds.
+func InsertOverLimit(ctx context.Context, server *memongo.Server, coll *mongo.Collection) error {
+	err := insertRelativeToLimit(ctx, server, coll, 1)
+	if err == nil {
+		return fmt.Errorf("inserting a document over the maximum size into %s succeeded", coll.Name())
+	}
+
+	return err
+}
+
+func insertRelativeToLimit(ctx context.Context, server *memongo.Server, coll *mongo.Collection, delta int) error {
+	limit, err := server.MaxBSONObjectSize(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = coll.InsertOne(ctx, GenerateDocumentOfSize(limit+delta))
+	return err
+}
@@ -0,0 +1,37 @@
+package doclimit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestGenerateDocumentOfSize(t *testing.T) {
+	for _, size := range []int{MinDocumentSize, 33, 100, 4096, 1 << 20, 16<<20 - 1, 16 << 20, 16<<20 + 1} {
+		raw, err := bson.Marshal(GenerateDocumentOfSize(size))
+		require.NoError(t, err)
+		assert.Len(t, raw, size, "size %d", size)
+	}
+
+	raw, err := bson.Marshal(GenerateDocumentOfSize(1))
+	require.NoError(t, err)
+	assert.Len(t, raw, MinDocumentSize)
+}
+
+func TestGenerateDocuments(t *testing.T) {
+	docs := GenerateDocuments(100, 64)
+	require.Len(t, docs, 100)
+
+	ids := map[bson.ObjectID]bool{}
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		require.NoError(t, err)
+		assert.Len(t, raw, 64)
+
+		id := bson.Raw(raw).Lookup("_id").ObjectID()
+		assert.False(t, ids[id], "_ids should be distinct")
+		ids[id] = true
+	}
+}
@@ -0,0 +1,429 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/100mslive/memongo/v2/mongobin"
+)
+
+// DoctorStatus is the outcome of one of Doctor's checks
+type DoctorStatus string
+
+const (
+	// DoctorOK means the check passed
+	DoctorOK DoctorStatus = "ok"
+
+	// DoctorWarn means starting a server may work, but could run into
+	// trouble
+	DoctorWarn DoctorStatus = "warn"
+
+	// DoctorFail means starting a server will fail
+	DoctorFail DoctorStatus = "fail"
+
+	// DoctorSkipped means the check doesn't apply, or couldn't be run
+	DoctorSkipped DoctorStatus = "skipped"
+)
+
+// DoctorCheck is the result of one of Doctor's checks
+type DoctorCheck struct {
+	// Name identifies the check, e.g. "download" or "disk space"
+	Name string
+
+	Status DoctorStatus
+
+	// Detail explains the status
+	Detail string
+}
+
+// DoctorReport is what Doctor found out about the environment
+type DoctorReport struct {
+	// GOOS and GOARCH are the platform memongo is running on, and Distro the
+	// Linux distro it detected, as in PlatformSpec.Distro
+	GOOS   string
+	GOARCH string
+	Distro string
+
+	// DownloadURL is where mongod would be downloaded from, if it isn't
+	// given with MongodBin
+	DownloadURL string
+
+	// BinPath is the mongod that would be run, if it's known without
+	// downloading it
+	BinPath string
+
+	// Cached is true if mongod is already in the download cache
+	Cached bool
+
+	Checks []DoctorCheck
+}
+
+// OK returns whether none of the checks failed
+func (r *DoctorReport) OK() bool {
+	for _, c := range r.Checks {
+		if c.Status == DoctorFail {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String renders the report for people to read, one check per line
+func (r *DoctorReport) String() string {
+	var b strings.Builder
+	for _, c := range r.Checks {
+		fmt.Fprintf(&b, "%-9s %s: %s\n", "["+string(c.Status)+"]", c.Name, c.Detail)
+	}
+
+	return b.String()
+}
+
+func (r *DoctorReport) add(name string, status DoctorStatus, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, DoctorCheck{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+// doctorMinFreeSpace is the free space below which Doctor warns about the
+// temp directory
+const doctorMinFreeSpace = 1 << 30
+
+// doctorMinOpenFiles is the open file limit below which Doctor warns. mongod
+// keeps a file open per collection and index, and one per connection.
+const doctorMinOpenFiles = 1024
+
+// doctorEnv is how Doctor inspects the machine, so tests can fake it
+type doctorEnv struct {
+	// head requests url and returns the response status code
+	head func(ctx context.Context, url string) (int, error)
+
+	// freeSpace returns the bytes available to us on path's filesystem
+	freeSpace func(path string) (uint64, error)
+
+	// openFileLimit returns the soft limit on open files
+	openFileLimit func() (uint64, error)
+
+	// missingLibraries returns the shared libraries binPath needs that
+	// can't be found
+	missingLibraries func(binPath string) ([]string, error)
+
+	// binaryVersion returns the version mongod at binPath reports
+	binaryVersion func(opts *Options, binPath string) (string, error)
+}
+
+var defaultDoctorEnv = doctorEnv{
+	head:             headStatus,
+	freeSpace:        freeSpace,
+	openFileLimit:    openFileLimit,
+	missingLibraries: missingLibraries,
+	binaryVersion: func(opts *Options, binPath string) (string, error) {
+		return opts.detectBinaryVersion(binPath)
+	},
+}
+
+// Doctor checks whether a server could be started with opts, without
+// starting one: which build would be downloaded and whether it can be, the
+// state of the download cache, the shared libraries mongod needs, free disk
+// space, the open file limit and free ports. Each check's result is in the
+// report; print it with String.
+//
+// The error is only non-nil if opts are invalid.
+func Doctor(ctx context.Context, opts *Options) (*DoctorReport, error) {
+	return doctor(ctx, opts, defaultDoctorEnv)
+}
+
+func doctor(ctx context.Context, opts *Options, env doctorEnv) (*DoctorReport, error) {
+	opts = opts.clone()
+	err := opts.applyEnv()
+	if err != nil {
+		return nil, err
+	}
+	// Problems with the platform are reported by checkPlatform instead
+	err = opts.validateSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &DoctorReport{GOOS: mongobin.GoOS, GOARCH: mongobin.GoArch}
+	r.checkPlatform(opts)
+	r.checkDownload(ctx, opts, env)
+	r.checkBinary(opts, env)
+	r.checkDiskSpace(opts, env)
+	r.checkOpenFiles(env)
+	r.checkPorts(opts)
+
+	if !opts.DisableRedaction {
+		r.DownloadURL = RedactURI(r.DownloadURL)
+		for i := range r.Checks {
+			r.Checks[i].Detail = redactText(r.Checks[i].Detail)
+		}
+	}
+
+	return r, nil
+}
+
+func (r *DoctorReport) checkPlatform(opts *Options) {
+	if opts.MongodBin != "" || opts.downloadOptions().DownloadURL != "" {
+		r.add("platform", DoctorSkipped, "%s/%s; not needed with MongodBin or DownloadURL", r.GOOS, r.GOARCH)
+		return
+	}
+	if opts.MongoVersion == "" {
+		r.add("platform", DoctorFail, "one of MongoVersion, DownloadURL, or MongodBin must be given")
+		return
+	}
+
+	spec, specErr := mongobin.MakeDownloadSpec(opts.MongoVersion)
+	if specErr == nil {
+		r.Distro = spec.OSName
+	}
+
+	// Apple Silicon downloads the x86_64 build, which MakeDownloadSpec
+	// doesn't know about, so Supports has the final say
+	ok, reason := Supports(opts.MongoVersion, r.GOOS, r.GOARCH, r.Distro)
+	if !ok {
+		if specErr != nil {
+			reason = specErr.Error()
+		}
+		r.add("platform", DoctorFail, "%s", reason)
+		return
+	}
+
+	r.add("platform", DoctorOK, "MongoDB %s is available for %s", opts.MongoVersion, platformName(r.GOOS, r.GOARCH, r.Distro))
+}
+
+func platformName(goos string, goarch string, distro string) string {
+	name := goos + "/" + goarch
+	if distro != "" {
+		name += " (" + distro + ")"
+	}
+	if goos == "darwin" && goarch == "arm64" {
+		name += ", using the x86_64 build under Rosetta 2"
+	}
+
+	return name
+}
+
+func (r *DoctorReport) checkDownload(ctx context.Context, opts *Options, env doctorEnv) {
+	if opts.MongodBin != "" {
+		r.BinPath = opts.MongodBin
+		r.add("download", DoctorSkipped, "using MongodBin %s", opts.MongodBin)
+		return
+	}
+
+	opts.resolveCachePath()
+	err := opts.resolveDownloadURL()
+	if err != nil {
+		r.add("download", DoctorFail, "%s", err)
+		return
+	}
+	d := opts.DownloadOptions
+	r.DownloadURL = d.DownloadURL
+
+	cached, err := mongobin.IsCached(d)
+	if err != nil {
+		r.add("download", DoctorFail, "%s", err)
+		return
+	}
+	if cached {
+		r.Cached = true
+		r.BinPath, _ = mongobin.GetOrDownload(d, opts.getLogger())
+		r.add("download", DoctorOK, "%s is in the cache at %s", d.DownloadURL, d.CachePath)
+		return
+	}
+	if d.Offline {
+		r.add("download", DoctorFail, "%s is not in the cache at %s, and downloads are disabled by Offline", d.DownloadURL, d.CachePath)
+		return
+	}
+
+	status, err := env.head(ctx, d.DownloadURL)
+	switch {
+	case err != nil:
+		r.add("download", DoctorFail, "can't reach %s: %s", d.DownloadURL, err)
+	case status != http.StatusOK:
+		r.add("download", DoctorFail, "%s returned HTTP %d", d.DownloadURL, status)
+	default:
+		r.add("download", DoctorOK, "%s is reachable; it will be downloaded to %s", d.DownloadURL, d.CachePath)
+	}
+}
+
+func (r *DoctorReport) checkBinary(opts *Options, env doctorEnv) {
+	if r.BinPath == "" {
+		r.add("binary", DoctorSkipped, "mongod hasn't been downloaded yet")
+		r.add("shared libraries", DoctorSkipped, "mongod hasn't been downloaded yet")
+		return
+	}
+
+	info, err := os.Stat(r.BinPath)
+	switch {
+	case err != nil:
+		r.add("binary", DoctorFail, "%s", err)
+	case !info.Mode().IsRegular() || info.Size() == 0:
+		r.add("binary", DoctorFail, "%s isn't a mongod binary; remove it from the cache to download it again", r.BinPath)
+	case info.Mode().Perm()&0100 == 0:
+		r.add("binary", DoctorFail, "%s isn't executable", r.BinPath)
+	default:
+		r.checkBinaryVersion(opts, env)
+	}
+
+	r.checkSharedLibraries(env)
+}
+
+func (r *DoctorReport) checkBinaryVersion(opts *Options, env doctorEnv) {
+	version, err := env.binaryVersion(opts, r.BinPath)
+	if err != nil {
+		r.add("binary", DoctorFail, "%s", err)
+		return
+	}
+	if opts.MongoVersion != "" && version != opts.MongoVersion {
+		r.add("binary", DoctorWarn, "%s is MongoDB %s, but MongoVersion is %s", r.BinPath, version, opts.MongoVersion)
+		return
+	}
+
+	r.add("binary", DoctorOK, "%s runs and is MongoDB %s", r.BinPath, version)
+}
+
+func (r *DoctorReport) checkSharedLibraries(env doctorEnv) {
+	if runtime.GOOS != "linux" {
+		r.add("shared libraries", DoctorSkipped, "only checked on Linux")
+		return
+	}
+
+	err := checkDynamicLinker(r.BinPath)
+	if err != nil {
+		r.add("shared libraries", DoctorFail, "%s", err)
+		return
+	}
+
+	missing, err := env.missingLibraries(r.BinPath)
+	switch {
+	case err != nil:
+		r.add("shared libraries", DoctorSkipped, "couldn't list them: %s", err)
+	case len(missing) > 0:
+		r.add("shared libraries", DoctorFail, "mongod needs %s, which can't be found; install them or use a build for your distro", strings.Join(missing, ", "))
+	default:
+		r.add("shared libraries", DoctorOK, "all found")
+	}
+}
+
+func (r *DoctorReport) checkDiskSpace(opts *Options, env doctorEnv) {
+	dir := opts.dataDirBase()
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	free, err := env.freeSpace(dir)
+	switch {
+	case err != nil:
+		r.add("disk space", DoctorSkipped, "couldn't check %s: %s", dir, err)
+	case free < doctorMinFreeSpace:
+		r.add("disk space", DoctorWarn, "only %s free in %s; set DataDir to a directory with more room", formatBytes(free), dir)
+	default:
+		r.add("disk space", DoctorOK, "%s free in %s", formatBytes(free), dir)
+	}
+}
+
+func (r *DoctorReport) checkOpenFiles(env doctorEnv) {
+	limit, err := env.openFileLimit()
+	switch {
+	case err != nil:
+		r.add("open files", DoctorSkipped, "%s", err)
+	case limit < doctorMinOpenFiles:
+		r.add("open files", DoctorWarn, "the limit is %d; raise it with ulimit -n if mongod runs out of file descriptors", limit)
+	default:
+		r.add("open files", DoctorOK, "the limit is %d", limit)
+	}
+}
+
+func (r *DoctorReport) checkPorts(opts *Options) {
+	switch {
+	case opts.Port != 0:
+		err := checkPortFree(opts.Port)
+		if err != nil {
+			r.add("ports", DoctorFail, "%s", err)
+			return
+		}
+		r.add("ports", DoctorOK, "port %d is free", opts.Port)
+	case opts.PortReservationProvider != nil:
+		r.add("ports", DoctorOK, "ports are leased from PortReservationProvider")
+	case opts.PortRange != [2]int{}:
+		_, err := getFreePortInRange(opts.PortRange)
+		if err != nil {
+			r.add("ports", DoctorFail, "%s", err)
+			return
+		}
+		r.add("ports", DoctorOK, "there are free ports in %d-%d", opts.PortRange[0], opts.PortRange[1])
+	default:
+		_, err := getFreePort()
+		if err != nil {
+			r.add("ports", DoctorFail, "can't find a free port: %s", err)
+			return
+		}
+		r.add("ports", DoctorOK, "free ports are available")
+	}
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// headStatus sends a HEAD request to url
+func headStatus(ctx context.Context, url string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+var reLddNotFound = regexp.MustCompile(`^\s*(\S+) => not found`)
+
+// missingLibraries asks ldd which of binPath's shared libraries can't be
+// found
+func missingLibraries(binPath string) ([]string, error) {
+	// binPath is the mongod binary we'd run anyway
+	//nolint:gosec
+	out, err := exec.Command("ldd", binPath).Output()
+	if err != nil && len(out) == 0 {
+		return nil, fmt.Errorf("error running ldd: %w", err)
+	}
+
+	return parseLddMissing(string(out)), nil
+}
+
+func parseLddMissing(out string) []string {
+	var missing []string
+	for _, line := range strings.Split(out, "\n") {
+		if match := reLddNotFound.FindStringSubmatch(line); match != nil {
+			missing = append(missing, match[1])
+		}
+	}
+
+	return missing
+}
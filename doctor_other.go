@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package memongo
+
+import "errors"
+
+var errNotChecked = errors.New("not checked on this platform")
+
+func freeSpace(path string) (uint64, error) {
+	return 0, errNotChecked
+}
+
+func openFileLimit() (uint64, error) {
+	return 0, errNotChecked
+}
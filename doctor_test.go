@@ -0,0 +1,323 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/mongobin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// healthyDoctorEnv is a machine with nothing wrong with it
+func healthyDoctorEnv() doctorEnv {
+	return doctorEnv{
+		head: func(context.Context, string) (int, error) {
+			return 200, nil
+		},
+		freeSpace: func(string) (uint64, error) {
+			return 50 << 30, nil
+		},
+		openFileLimit: func() (uint64, error) {
+			return 65536, nil
+		},
+		missingLibraries: func(string) ([]string, error) {
+			return nil, nil
+		},
+		binaryVersion: defaultDoctorEnv.binaryVersion,
+	}
+}
+
+// onUbuntu2204 makes platform detection see an amd64 Ubuntu 22.04 machine
+func onUbuntu2204(t *testing.T) {
+	t.Helper()
+
+	mongobin.GoOS = "linux"
+	mongobin.GoArch = "amd64"
+	mongobin.EtcOsRelease = "./mongobin/testdata/etc/ubuntu2204/os-release"
+	t.Cleanup(func() {
+		mongobin.EtcOsRelease = "/etc/os-release"
+		mongobin.GoOS = runtime.GOOS
+		mongobin.GoArch = runtime.GOARCH
+	})
+}
+
+func doctorCheck(t *testing.T, r *DoctorReport, name string) DoctorCheck {
+	t.Helper()
+
+	for _, c := range r.Checks {
+		if c.Name == name {
+			return c
+		}
+	}
+	t.Fatalf("no %s check in %v", name, r.Checks)
+
+	return DoctorCheck{}
+}
+
+func TestDoctorMongodBin(t *testing.T) {
+	binPath := writeScript(t, `echo "db version v8.0.0"`)
+
+	r, err := doctor(context.Background(), &Options{MongodBin: binPath, MongoVersion: "8.0.0"}, healthyDoctorEnv())
+	require.NoError(t, err)
+	assert.True(t, r.OK(), r.String())
+	assert.Equal(t, binPath, r.BinPath)
+
+	assert.Equal(t, DoctorSkipped, doctorCheck(t, r, "platform").Status)
+	assert.Equal(t, DoctorSkipped, doctorCheck(t, r, "download").Status)
+	assert.Equal(t, DoctorOK, doctorCheck(t, r, "binary").Status)
+	assert.Contains(t, doctorCheck(t, r, "binary").Detail, "is MongoDB 8.0.0")
+	assert.Equal(t, DoctorOK, doctorCheck(t, r, "disk space").Status)
+	assert.Equal(t, DoctorOK, doctorCheck(t, r, "open files").Status)
+	assert.Equal(t, DoctorOK, doctorCheck(t, r, "ports").Status)
+	if runtime.GOOS == "linux" {
+		assert.Equal(t, DoctorOK, doctorCheck(t, r, "shared libraries").Status)
+	}
+}
+
+func TestDoctorInvalidOptions(t *testing.T) {
+	_, err := doctor(context.Background(), &Options{MongodBin: "/bin/mongod", StartRetries: -1}, healthyDoctorEnv())
+	assert.Error(t, err)
+}
+
+func TestDoctorPlatform(t *testing.T) {
+	onUbuntu2204(t)
+
+	r, err := doctor(context.Background(), &Options{MongoVersion: "8.0.0", CachePath: t.TempDir()}, healthyDoctorEnv())
+	require.NoError(t, err)
+	check := doctorCheck(t, r, "platform")
+	assert.Equal(t, DoctorOK, check.Status)
+	assert.Equal(t, "MongoDB 8.0.0 is available for linux/amd64 (ubuntu2204)", check.Detail)
+	assert.Equal(t, "ubuntu2204", r.Distro)
+
+	mongobin.GoOS = "windows"
+	r, err = doctor(context.Background(), &Options{MongoVersion: "8.0.0", CachePath: t.TempDir()}, healthyDoctorEnv())
+	require.NoError(t, err)
+	assert.False(t, r.OK())
+	check = doctorCheck(t, r, "platform")
+	assert.Equal(t, DoctorFail, check.Status)
+	assert.Contains(t, check.Detail, "your platform, windows, is not supported")
+}
+
+func TestDoctorDownload(t *testing.T) {
+	onUbuntu2204(t)
+	const url = "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-ubuntu2204-8.0.0.tgz"
+
+	tests := map[string]struct {
+		status  int
+		headErr error
+		offline bool
+
+		expectedStatus DoctorStatus
+		expectedDetail string
+	}{
+		"reachable": {
+			status:         200,
+			expectedStatus: DoctorOK,
+			expectedDetail: url + " is reachable",
+		},
+		"not found": {
+			status:         404,
+			expectedStatus: DoctorFail,
+			expectedDetail: url + " returned HTTP 404",
+		},
+		"blocked network": {
+			headErr:        errors.New("dial tcp: lookup fastdl.mongodb.org: no such host"),
+			expectedStatus: DoctorFail,
+			expectedDetail: "can't reach " + url + ": dial tcp",
+		},
+		"offline": {
+			offline:        true,
+			expectedStatus: DoctorFail,
+			expectedDetail: "downloads are disabled by Offline",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			env := healthyDoctorEnv()
+			env.head = func(_ context.Context, headURL string) (int, error) {
+				assert.Equal(t, url, headURL)
+				return test.status, test.headErr
+			}
+
+			r, err := doctor(context.Background(), &Options{MongoVersion: "8.0.0", CachePath: t.TempDir(), Offline: test.offline}, env)
+			require.NoError(t, err)
+			assert.Equal(t, url, r.DownloadURL)
+			assert.False(t, r.Cached)
+
+			check := doctorCheck(t, r, "download")
+			assert.Equal(t, test.expectedStatus, check.Status)
+			assert.Contains(t, check.Detail, test.expectedDetail)
+			assert.Equal(t, DoctorSkipped, doctorCheck(t, r, "binary").Status)
+		})
+	}
+}
+
+func TestDoctorCache(t *testing.T) {
+	cachePath := t.TempDir()
+	cached, err := ImportIntoCache(cachePath, writeScript(t, `echo "db version v8.0.0"`), ImportMeta{Version: "8.0.0", DownloadURL: importURL})
+	require.NoError(t, err)
+
+	env := healthyDoctorEnv()
+	env.head = func(context.Context, string) (int, error) {
+		t.Error("cached binaries aren't downloaded")
+		return 0, nil
+	}
+
+	r, err := doctor(context.Background(), &Options{DownloadURL: importURL, CachePath: cachePath, MongoVersion: "8.0.0", Offline: true}, env)
+	require.NoError(t, err)
+	assert.True(t, r.OK(), r.String())
+	assert.True(t, r.Cached)
+	assert.Equal(t, cached, r.BinPath)
+	assert.Equal(t, DoctorOK, doctorCheck(t, r, "download").Status)
+	assert.Equal(t, DoctorOK, doctorCheck(t, r, "binary").Status)
+
+	// A cached binary that doesn't run is reported
+	require.NoError(t, os.WriteFile(cached, []byte("#!/bin/sh\nexit 1\n"), 0700))
+	r, err = doctor(context.Background(), &Options{DownloadURL: importURL, CachePath: cachePath, Offline: true}, env)
+	require.NoError(t, err)
+	assert.Equal(t, DoctorFail, doctorCheck(t, r, "binary").Status)
+}
+
+func TestDoctorBinary(t *testing.T) {
+	tests := map[string]struct {
+		contents string
+		mode     os.FileMode
+		version  string
+
+		expectedStatus DoctorStatus
+		expectedDetail string
+	}{
+		"empty": {
+			mode:           0700,
+			expectedStatus: DoctorFail,
+			expectedDetail: "isn't a mongod binary",
+		},
+		"not executable": {
+			contents:       "#!/bin/sh\n",
+			mode:           0600,
+			expectedStatus: DoctorFail,
+			expectedDetail: "isn't executable",
+		},
+		"other version": {
+			contents:       "#!/bin/sh\necho 'db version v7.0.2'\n",
+			mode:           0700,
+			version:        "8.0.0",
+			expectedStatus: DoctorWarn,
+			expectedDetail: "is MongoDB 7.0.2, but MongoVersion is 8.0.0",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			binPath := filepath.Join(t.TempDir(), "mongod")
+			require.NoError(t, os.WriteFile(binPath, []byte(test.contents), test.mode))
+
+			r, err := doctor(context.Background(), &Options{MongodBin: binPath, MongoVersion: test.version}, healthyDoctorEnv())
+			require.NoError(t, err)
+			check := doctorCheck(t, r, "binary")
+			assert.Equal(t, test.expectedStatus, check.Status)
+			assert.Contains(t, check.Detail, test.expectedDetail)
+		})
+	}
+}
+
+func TestDoctorSharedLibraries(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("shared libraries are only checked on Linux")
+	}
+
+	binPath := writeScript(t, `echo "db version v8.0.0"`)
+
+	env := healthyDoctorEnv()
+	env.missingLibraries = func(string) ([]string, error) {
+		return []string{"libcrypto.so.3", "libssl.so.3"}, nil
+	}
+	r, err := doctor(context.Background(), &Options{MongodBin: binPath}, env)
+	require.NoError(t, err)
+	assert.False(t, r.OK())
+	check := doctorCheck(t, r, "shared libraries")
+	assert.Equal(t, DoctorFail, check.Status)
+	assert.Contains(t, check.Detail, "mongod needs libcrypto.so.3, libssl.so.3")
+
+	env.missingLibraries = func(string) ([]string, error) {
+		return nil, errors.New("ldd not found")
+	}
+	r, err = doctor(context.Background(), &Options{MongodBin: binPath}, env)
+	require.NoError(t, err)
+	assert.Equal(t, DoctorSkipped, doctorCheck(t, r, "shared libraries").Status)
+}
+
+func TestParseLddMissing(t *testing.T) {
+	out := "\tlinux-vdso.so.1 (0x00007ffd)\n" +
+		"\tlibcurl.so.4 => not found\n" +
+		"\tlibc.so.6 => /lib/x86_64-linux-gnu/libc.so.6 (0x00007f)\n" +
+		"\tlibcrypto.so.3 => not found\n"
+
+	assert.Equal(t, []string{"libcurl.so.4", "libcrypto.so.3"}, parseLddMissing(out))
+	assert.Empty(t, parseLddMissing("\tstatically linked\n"))
+}
+
+func TestDoctorResources(t *testing.T) {
+	binPath := writeScript(t, `echo "db version v8.0.0"`)
+
+	env := healthyDoctorEnv()
+	env.freeSpace = func(path string) (uint64, error) {
+		assert.Equal(t, "/var/tmp/memongo", path)
+		return 300 << 20, nil
+	}
+	env.openFileLimit = func() (uint64, error) {
+		return 256, nil
+	}
+
+	r, err := doctor(context.Background(), &Options{MongodBin: binPath, TempDirBase: "/var/tmp/memongo"}, env)
+	require.NoError(t, err)
+	assert.True(t, r.OK(), "warnings don't fail the report")
+
+	check := doctorCheck(t, r, "disk space")
+	assert.Equal(t, DoctorWarn, check.Status)
+	assert.Equal(t, "only 300.0 MiB free in /var/tmp/memongo; set DataDir to a directory with more room", check.Detail)
+
+	check = doctorCheck(t, r, "open files")
+	assert.Equal(t, DoctorWarn, check.Status)
+	assert.Contains(t, check.Detail, "the limit is 256")
+}
+
+func TestDoctorPorts(t *testing.T) {
+	binPath := writeScript(t, `echo "db version v8.0.0"`)
+
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	r, err := doctor(context.Background(), &Options{MongodBin: binPath, Port: port}, healthyDoctorEnv())
+	require.NoError(t, err)
+	assert.Equal(t, DoctorFail, doctorCheck(t, r, "ports").Status)
+
+	r, err = doctor(context.Background(), &Options{MongodBin: binPath, PortRange: [2]int{port, port}}, healthyDoctorEnv())
+	require.NoError(t, err)
+	assert.Equal(t, DoctorFail, doctorCheck(t, r, "ports").Status)
+}
+
+func TestDoctorReportString(t *testing.T) {
+	r := &DoctorReport{Checks: []DoctorCheck{
+		{Name: "platform", Status: DoctorOK, Detail: "MongoDB 8.0.0 is available for linux/amd64 (ubuntu2204)"},
+		{Name: "disk space", Status: DoctorWarn, Detail: "only 300.0 MiB free in /tmp"},
+		{Name: "download", Status: DoctorSkipped, Detail: "using MongodBin /usr/bin/mongod"},
+	}}
+
+	assert.Equal(t, "[ok]      platform: MongoDB 8.0.0 is available for linux/amd64 (ubuntu2204)\n"+
+		"[warn]    disk space: only 300.0 MiB free in /tmp\n"+
+		"[skipped] download: using MongodBin /usr/bin/mongod\n", r.String())
+	assert.True(t, r.OK())
+
+	r.Checks = append(r.Checks, DoctorCheck{Name: "ports", Status: DoctorFail})
+	assert.False(t, r.OK())
+}
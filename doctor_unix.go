@@ -0,0 +1,31 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package memongo
+
+import "syscall"
+
+// freeSpace returns the bytes available to unprivileged users on path's
+// filesystem
+func freeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(path, &st)
+	if err != nil {
+		return 0, err
+	}
+
+	//nolint:unconvert // the types of the fields differ between platforms
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// openFileLimit returns the soft limit on open files
+func openFileLimit() (uint64, error) {
+	var limit syscall.Rlimit
+	err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit)
+	if err != nil {
+		return 0, err
+	}
+
+	//nolint:unconvert // the type of Cur differs between platforms
+	return uint64(limit.Cur), nil
+}
@@ -0,0 +1,50 @@
+package memongo
+
+import (
+	"fmt"
+
+	"github.com/100mslive/memongo/v2/mongobin"
+)
+
+// DownloadOptions configures where mongod is downloaded from and cached. It's
+// mongobin.DownloadOptions, so Options can be passed on to mongobin as is.
+type DownloadOptions = mongobin.DownloadOptions
+
+// downloadOptions returns the download options, with the deprecated fields
+// of Options taking precedence over DownloadOptions where they're set
+func (opts *Options) downloadOptions() DownloadOptions {
+	d := opts.DownloadOptions
+	if opts.CachePath != "" {
+		d.CachePath = opts.CachePath
+	}
+	if opts.DownloadURL != "" {
+		d.DownloadURL = opts.DownloadURL
+	}
+	if opts.Offline {
+		d.Offline = true
+	}
+
+	return d
+}
+
+// setDownloadOptions sets DownloadOptions to d, and the deprecated fields to
+// match, so code that reads them keeps working
+func (opts *Options) setDownloadOptions(d DownloadOptions) {
+	opts.DownloadOptions = d
+	opts.CachePath = d.CachePath
+	opts.DownloadURL = d.DownloadURL
+	opts.Offline = d.Offline
+}
+
+// validateDownloadOptions checks that the deprecated fields don't contradict
+// DownloadOptions
+func (opts *Options) validateDownloadOptions() error {
+	if opts.CachePath != "" && opts.DownloadOptions.CachePath != "" && opts.CachePath != opts.DownloadOptions.CachePath {
+		return fmt.Errorf("CachePath %s and DownloadOptions.CachePath %s are both given; CachePath is deprecated", opts.CachePath, opts.DownloadOptions.CachePath)
+	}
+	if opts.DownloadURL != "" && opts.DownloadOptions.DownloadURL != "" && opts.DownloadURL != opts.DownloadOptions.DownloadURL {
+		return fmt.Errorf("DownloadURL %s and DownloadOptions.DownloadURL %s are both given; DownloadURL is deprecated", opts.DownloadURL, opts.DownloadOptions.DownloadURL)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package memongo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Options that give the download settings through the deprecated fields, the
+// environment or DownloadOptions must all behave the same
+func TestDownloadOptionsCompatibility(t *testing.T) {
+	const url = "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-ubuntu2204-8.0.0.tgz"
+
+	tests := map[string]struct {
+		opts *Options
+		env  map[string]string
+
+		expected      DownloadOptions
+		expectedError string
+	}{
+		"deprecated fields": {
+			opts:     &Options{CachePath: "/cache", DownloadURL: url, Offline: true},
+			expected: DownloadOptions{CachePath: "/cache", DownloadURL: url, Offline: true},
+		},
+		"DownloadOptions": {
+			opts:     &Options{DownloadOptions: DownloadOptions{CachePath: "/cache", DownloadURL: url, Offline: true}},
+			expected: DownloadOptions{CachePath: "/cache", DownloadURL: url, Offline: true},
+		},
+		"both, agreeing": {
+			opts: &Options{
+				CachePath:       "/cache",
+				DownloadURL:     url,
+				DownloadOptions: DownloadOptions{CachePath: "/cache", DownloadURL: url},
+			},
+			expected: DownloadOptions{CachePath: "/cache", DownloadURL: url},
+		},
+		"both, split": {
+			opts:     &Options{CachePath: "/cache", DownloadOptions: DownloadOptions{DownloadURL: url, Offline: true}},
+			expected: DownloadOptions{CachePath: "/cache", DownloadURL: url, Offline: true},
+		},
+		"environment": {
+			opts: &Options{},
+			env: map[string]string{
+				"MEMONGO_CACHE_PATH":   "/cache",
+				"MEMONGO_DOWNLOAD_URL": url,
+				"MEMONGO_OFFLINE":      "1",
+			},
+			expected: DownloadOptions{CachePath: "/cache", DownloadURL: url, Offline: true},
+		},
+		"deprecated fields over the environment": {
+			opts:     &Options{CachePath: "/cache", DownloadURL: url},
+			env:      map[string]string{"MEMONGO_CACHE_PATH": "/other", "MEMONGO_DOWNLOAD_URL": "https://example.com/other.tgz"},
+			expected: DownloadOptions{CachePath: "/cache", DownloadURL: url},
+		},
+		"DownloadOptions over the environment": {
+			opts:     &Options{DownloadOptions: DownloadOptions{CachePath: "/cache", DownloadURL: url}},
+			env:      map[string]string{"MEMONGO_CACHE_PATH": "/other", "MEMONGO_DOWNLOAD_URL": "https://example.com/other.tgz"},
+			expected: DownloadOptions{CachePath: "/cache", DownloadURL: url},
+		},
+		"conflicting CachePath": {
+			opts:          &Options{CachePath: "/cache", DownloadOptions: DownloadOptions{CachePath: "/other", DownloadURL: url}},
+			expectedError: "CachePath /cache and DownloadOptions.CachePath /other are both given; CachePath is deprecated",
+		},
+		"conflicting DownloadURL": {
+			opts:          &Options{DownloadURL: url, DownloadOptions: DownloadOptions{DownloadURL: "https://example.com/other.tgz"}},
+			expectedError: "DownloadURL " + url + " and DownloadOptions.DownloadURL https://example.com/other.tgz are both given; DownloadURL is deprecated",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			for _, key := range []string{"MEMONGO_CACHE_PATH", "MEMONGO_DOWNLOAD_URL", "MEMONGO_OFFLINE"} {
+				t.Setenv(key, test.env[key])
+			}
+
+			effective, err := test.opts.EffectiveOptions()
+			if test.expectedError != "" {
+				assert.EqualError(t, err, test.expectedError)
+				return
+			}
+			require.NoError(t, err)
+
+			// Both the deprecated fields and DownloadOptions hold the result
+			assert.Equal(t, test.expected, effective.DownloadOptions)
+			assert.Equal(t, test.expected.CachePath, effective.CachePath)
+			assert.Equal(t, test.expected.DownloadURL, effective.DownloadURL)
+			assert.Equal(t, test.expected.Offline, effective.Offline)
+
+			// Filling in the defaults again changes nothing
+			again, err := effective.EffectiveOptions()
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, again.DownloadOptions)
+
+			fingerprint, err := test.opts.Fingerprint()
+			require.NoError(t, err)
+			expectedFingerprint, err := (&Options{DownloadOptions: test.expected}).Fingerprint()
+			require.NoError(t, err)
+			assert.Equal(t, expectedFingerprint, fingerprint)
+		})
+	}
+}
+
+func TestDownloadOptionsIgnored(t *testing.T) {
+	for _, opts := range []*Options{
+		{MongodBin: "/bin/true", CachePath: "/cache"},
+		{MongodBin: "/bin/true", DownloadOptions: DownloadOptions{CachePath: "/cache"}},
+	} {
+		ignored := opts.ignoredOptions(nil, false)
+		require.Len(t, ignored, 1)
+		assert.Equal(t, "CachePath", ignored[0].Option)
+	}
+}
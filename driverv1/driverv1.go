@@ -0,0 +1,111 @@
+// Package driverv1 lets services still on version 1 of the MongoDB Go driver
+// use memongo, which is built on version 2.
+//
+// It's a separate module so that the core memongo module never depends on
+// driver v1.
+package driverv1
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/100mslive/memongo/v2"
+	mongov1 "go.mongodb.org/mongo-driver/mongo"
+	optionsv1 "go.mongodb.org/mongo-driver/mongo/options"
+	readprefv1 "go.mongodb.org/mongo-driver/mongo/readpref"
+	tagv1 "go.mongodb.org/mongo-driver/tag"
+	optionsv2 "go.mongodb.org/mongo-driver/v2/mongo/options"
+	readprefv2 "go.mongodb.org/mongo-driver/v2/mongo/readpref"
+)
+
+// ClientOptionsV1 returns driver v1 client options for connecting to the
+// server
+func ClientOptionsV1(s *memongo.Server) *optionsv1.ClientOptions {
+	return optionsv1.Client().ApplyURI(URIV1(s))
+}
+
+// ClientV1 returns a connected driver v1 client for the server. The caller is
+// responsible for disconnecting it.
+func ClientV1(ctx context.Context, s *memongo.Server) (*mongov1.Client, error) {
+	client, err := mongov1.Connect(ctx, ClientOptionsV1(s))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		_ = client.Disconnect(ctx)
+		return nil, fmt.Errorf("failed to ping: %w", err)
+	}
+
+	return client, nil
+}
+
+// URIV1 returns a connection string for the server that driver v1 accepts.
+// Both drivers parse the same format, so this is the server's URI.
+func URIV1(s *memongo.Server) string {
+	return s.URI()
+}
+
+// CredentialV1 translates a driver v2 credential into its driver v1
+// equivalent. OIDC callbacks aren't translated, since their signatures
+// differ between the drivers.
+func CredentialV1(cred *optionsv2.Credential) *optionsv1.Credential {
+	if cred == nil {
+		return nil
+	}
+
+	var props map[string]string
+	if cred.AuthMechanismProperties != nil {
+		props = make(map[string]string, len(cred.AuthMechanismProperties))
+		for k, v := range cred.AuthMechanismProperties {
+			props[k] = v
+		}
+	}
+
+	return &optionsv1.Credential{
+		AuthMechanism:           cred.AuthMechanism,
+		AuthMechanismProperties: props,
+		AuthSource:              cred.AuthSource,
+		Username:                cred.Username,
+		Password:                cred.Password,
+		PasswordSet:             cred.PasswordSet,
+	}
+}
+
+// ReadPrefV1 translates a driver v2 read preference into its driver v1
+// equivalent, including tag sets and max staleness
+func ReadPrefV1(rp *readprefv2.ReadPref) (*readprefv1.ReadPref, error) {
+	if rp == nil {
+		return nil, nil
+	}
+
+	mode, err := readprefv1.ModeFromString(rp.Mode().String())
+	if err != nil {
+		return nil, err
+	}
+
+	// The primary read preference doesn't accept any options
+	if mode == readprefv1.PrimaryMode {
+		return readprefv1.Primary(), nil
+	}
+
+	var opts []readprefv1.Option
+
+	if maxStaleness, ok := rp.MaxStaleness(); ok {
+		opts = append(opts, readprefv1.WithMaxStaleness(maxStaleness))
+	}
+
+	if tagSets := rp.TagSets(); len(tagSets) > 0 {
+		setsV1 := make([]tagv1.Set, len(tagSets))
+		for i, set := range tagSets {
+			setsV1[i] = make(tagv1.Set, len(set))
+			for j, t := range set {
+				setsV1[i][j] = tagv1.Tag{Name: t.Name, Value: t.Value}
+			}
+		}
+		opts = append(opts, readprefv1.WithTagSets(setsV1...))
+	}
+
+	return readprefv1.New(mode, opts...)
+}
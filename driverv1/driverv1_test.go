@@ -0,0 +1,77 @@
+package driverv1_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/100mslive/memongo/v2"
+	"github.com/100mslive/memongo/v2/driverv1"
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	readprefv1 "go.mongodb.org/mongo-driver/mongo/readpref"
+	tagv1 "go.mongodb.org/mongo-driver/tag"
+	optionsv2 "go.mongodb.org/mongo-driver/v2/mongo/options"
+	readprefv2 "go.mongodb.org/mongo-driver/v2/mongo/readpref"
+	tagv2 "go.mongodb.org/mongo-driver/v2/tag"
+)
+
+func TestClientV1(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	client, err := driverv1.ClientV1(ctx, server)
+	require.NoError(t, err)
+	defer client.Disconnect(ctx)
+
+	_, err = client.Database(memongo.RandomDatabase()).Collection("things").InsertOne(ctx, map[string]int{"n": 1})
+	require.NoError(t, err)
+}
+
+func TestCredentialV1(t *testing.T) {
+	assert.Nil(t, driverv1.CredentialV1(nil))
+
+	cred := driverv1.CredentialV1(&optionsv2.Credential{
+		AuthMechanism:           "SCRAM-SHA-256",
+		AuthMechanismProperties: map[string]string{"foo": "bar"},
+		AuthSource:              "admin",
+		Username:                "admin",
+		Password:                "12345",
+		PasswordSet:             true,
+	})
+
+	assert.Equal(t, "SCRAM-SHA-256", cred.AuthMechanism)
+	assert.Equal(t, map[string]string{"foo": "bar"}, cred.AuthMechanismProperties)
+	assert.Equal(t, "admin", cred.AuthSource)
+	assert.Equal(t, "admin", cred.Username)
+	assert.Equal(t, "12345", cred.Password)
+	assert.True(t, cred.PasswordSet)
+}
+
+func TestReadPrefV1(t *testing.T) {
+	rp, err := driverv1.ReadPrefV1(readprefv2.Primary())
+	require.NoError(t, err)
+	assert.Equal(t, readprefv1.PrimaryMode, rp.Mode())
+
+	rp, err = driverv1.ReadPrefV1(readprefv2.Secondary(
+		readprefv2.WithMaxStaleness(90*time.Second),
+		readprefv2.WithTagSets(tagv2.Set{{Name: "workload", Value: "analytics"}}),
+	))
+	require.NoError(t, err)
+	assert.Equal(t, readprefv1.SecondaryMode, rp.Mode())
+
+	maxStaleness, ok := rp.MaxStaleness()
+	assert.True(t, ok)
+	assert.Equal(t, 90*time.Second, maxStaleness)
+	assert.Equal(t, []tagv1.Set{{{Name: "workload", Value: "analytics"}}}, rp.TagSets())
+
+	rp, err = driverv1.ReadPrefV1(nil)
+	require.NoError(t, err)
+	assert.Nil(t, rp)
+}
@@ -0,0 +1,107 @@
+package memongo
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Env returns environment variables that tell another process how to connect
+// to the server, in the KEY=value form of exec.Cmd.Env, with each name
+// prefixed by prefix: MONGODB_URI, which connects the way Server.Client
+// does, and MONGODB_DATABASE if Options.EnvDatabase is set. Once
+// StartAppStack has made Server.Client authenticate, MONGODB_USERNAME and
+// MONGODB_PASSWORD hold the same credentials, and MONGODB_URI names their
+// authSource.
+func (s *Server) Env(prefix string) []string {
+	vars := s.envVars(prefix)
+
+	env := make([]string, len(vars))
+	for i, v := range vars {
+		env[i] = v[0] + "=" + v[1]
+	}
+
+	return env
+}
+
+// WriteEnvFile writes the variables returned by Env to a dotenv file at path,
+// readable only by the current user, for processes that load their
+// configuration from one. The file is removed when the server is stopped,
+// unless Options.KeepEnvFiles is set.
+func (s *Server) WriteEnvFile(path string, prefix string) error {
+	if err := s.checkRunning(); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	for _, v := range s.envVars(prefix) {
+		fmt.Fprintf(&b, "%s=%s\n", v[0], dotenvQuote(v[1]))
+	}
+
+	// Chmod as well, in case the file already existed with wider permissions
+	err := os.WriteFile(path, []byte(b.String()), 0600)
+	if err == nil {
+		err = os.Chmod(path, 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing env file: %w", err)
+	}
+
+	if !s.opts.KeepEnvFiles {
+		s.mu.Lock()
+		s.envFiles = append(s.envFiles, path)
+		s.mu.Unlock()
+		trackPath(path, "env file")
+	}
+
+	return nil
+}
+
+// envVars returns the names and values of the variables Env exports
+func (s *Server) envVars(prefix string) [][2]string {
+	s.mu.Lock()
+	credential := s.credential
+	s.mu.Unlock()
+
+	uri := s.clientConnString()
+	if credential != nil {
+		uri.param("authSource", credential.AuthSource)
+	}
+
+	vars := [][2]string{{prefix + "MONGODB_URI", uri.String()}}
+	if s.opts.EnvDatabase != "" {
+		vars = append(vars, [2]string{prefix + "MONGODB_DATABASE", s.opts.EnvDatabase})
+	}
+	if credential != nil {
+		vars = append(vars,
+			[2]string{prefix + "MONGODB_USERNAME", credential.Username},
+			[2]string{prefix + "MONGODB_PASSWORD", credential.Password},
+		)
+	}
+
+	return vars
+}
+
+// dotenvQuote double-quotes value, escaping the characters dotenv parsers
+// treat specially inside double quotes
+func dotenvQuote(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "$", `\$`).Replace(value)
+
+	return `"` + value + `"`
+}
+
+func (s *Server) removeEnvFiles() {
+	s.mu.Lock()
+	files := s.envFiles
+	s.envFiles = nil
+	s.mu.Unlock()
+
+	for _, path := range files {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			s.logger.Warnf("error removing env file: %s", err)
+			continue
+		}
+		untrackPath(path)
+	}
+}
@@ -0,0 +1,65 @@
+package memongo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnv(t *testing.T) {
+	server := &Server{port: 1234}
+	assert.Equal(t, []string{"MONGODB_URI=mongodb://localhost:1234/?directConnection=true"}, server.Env(""))
+
+	server.opts.EnvDatabase = "app"
+	assert.Equal(t, []string{
+		"WORKER_MONGODB_URI=mongodb://localhost:1234/?directConnection=true",
+		"WORKER_MONGODB_DATABASE=app",
+	}, server.Env("WORKER_"))
+
+	// Credentials Client authenticates with are passed on
+	server.setCredential("root", "s3cret")
+	assert.Equal(t, []string{
+		"MONGODB_URI=mongodb://localhost:1234/?directConnection=true&authSource=admin",
+		"MONGODB_DATABASE=app",
+		"MONGODB_USERNAME=root",
+		"MONGODB_PASSWORD=s3cret",
+	}, server.Env(""))
+}
+
+func TestWriteEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	server := &Server{port: 1234, opts: Options{EnvDatabase: "app"}, logger: memongolog.New(nil, memongolog.LogLevelSilent)}
+
+	// An existing file loses its wider permissions
+	path := filepath.Join(dir, ".env")
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+	require.NoError(t, server.WriteEnvFile(path, "APP_"))
+
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "APP_MONGODB_URI=\"mongodb://localhost:1234/?directConnection=true\"\nAPP_MONGODB_DATABASE=\"app\"\n", string(contents))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	server.removeEnvFiles()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	// Kept files survive the server
+	server.opts.KeepEnvFiles = true
+	require.NoError(t, server.WriteEnvFile(path, ""))
+	server.removeEnvFiles()
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+}
+
+func TestDotenvQuote(t *testing.T) {
+	assert.Equal(t, `"plain"`, dotenvQuote("plain"))
+	assert.Equal(t, `"a\"b\\c\$d\ne"`, dotenvQuote("a\"b\\c$d\ne"))
+}
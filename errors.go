@@ -0,0 +1,69 @@
+package memongo
+
+import "errors"
+
+// ErrNoFreePortInRange is returned when Options.PortRange is set and every
+// port in it is in use
+var ErrNoFreePortInRange = errors.New("no free port in range")
+
+// ErrReplicaSetNameMismatch is returned when the data directory holds a
+// replica set configuration for a different set than Options.ReplicaSetName
+var ErrReplicaSetNameMismatch = errors.New("replica set name mismatch")
+
+// ErrPortInUse is returned when a port memongo was told to use is taken
+var ErrPortInUse = errors.New("port in use")
+
+// ErrStartupTimeout is returned when mongod doesn't report that it's
+// listening within Options.StartupTimeout
+var ErrStartupTimeout = errors.New("timed out waiting for mongod to start")
+
+// ErrDiskQuotaExceeded is reported when the data directory grows past
+// Options.MaxDBPathBytes
+var ErrDiskQuotaExceeded = errors.New("disk quota exceeded")
+
+// ErrMissingDynamicLinker is returned when mongod is dynamically linked
+// against a loader that doesn't exist, as happens on NixOS and other Linux
+// distributions without the usual filesystem layout
+var ErrMissingDynamicLinker = errors.New("mongod's dynamic linker is missing")
+
+// ErrNotReplicaSet is returned by helpers that need a replica set, such as
+// WithCausalSession, when the server wasn't started with ShouldUseReplica
+var ErrNotReplicaSet = errors.New("the server isn't a replica set")
+
+// ErrNotSharded is returned by helpers that need a sharded cluster, such as
+// ShardCollection, when the server wasn't started with Options.Sharded
+var ErrNotSharded = errors.New("the server isn't a sharded cluster")
+
+// ErrDirectShardAccess is returned by the helpers that reach past mongos to
+// the config server or the shards of a sharded cluster, such as
+// ConfigClient, unless Options.AllowDirectShardAccess is set
+var ErrDirectShardAccess = errors.New("direct access to the config server and shards isn't allowed without AllowDirectShardAccess")
+
+// ErrUnsuitableFilesystem is returned when the data directory is on a
+// filesystem WiredTiger can't lock its files on, such as NFS
+var ErrUnsuitableFilesystem = errors.New("unsuitable filesystem for the data directory")
+
+// ErrServerStopped is returned by Server methods called after the server has
+// been stopped
+var ErrServerStopped = errors.New("the server is stopped")
+
+// ErrIgnoredOption is returned by Validate when Options.Strict is set and an
+// option is given that has no effect with the others, such as ReplicaSetName
+// without ShouldUseReplica
+var ErrIgnoredOption = errors.New("options have no effect")
+
+// ErrWrongBinaryKind is returned when the binary memongo was given to run as
+// mongod identifies as another MongoDB program, such as mongos or the shell
+var ErrWrongBinaryKind = errors.New("not a mongod binary")
+
+// ErrNotExecutable is returned when Options.MongodBin isn't an executable
+// file
+var ErrNotExecutable = errors.New("mongod binary isn't executable")
+
+// ErrScriptBinary is returned when Options.MongodBin is a script whose
+// --version output doesn't identify mongod
+var ErrScriptBinary = errors.New("mongod binary is a script")
+
+// ErrTimeBudgetExceeded is returned by StartWithOptions once starting servers
+// has taken longer than SetGlobalTimeBudget allows
+var ErrTimeBudgetExceeded = errors.New("memongo's time budget is exceeded")
@@ -0,0 +1,150 @@
+package memongo
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies a step in the lifecycle of a server
+type EventType string
+
+const (
+	// EventDownloadStarted is emitted when mongod isn't in the cache and
+	// starts downloading
+	EventDownloadStarted EventType = "DownloadStarted"
+
+	// EventDownloadFinished is emitted when mongod has been downloaded and
+	// extracted to the cache
+	EventDownloadFinished EventType = "DownloadFinished"
+
+	// EventStarting is emitted when the mongod process is spawned
+	EventStarting EventType = "Starting"
+
+	// EventListening is emitted when mongod accepts connections
+	EventListening EventType = "Listening"
+
+	// EventPrimaryElected is emitted when a replica set member becomes primary
+	EventPrimaryElected EventType = "PrimaryElected"
+
+	// EventMemberStateChanged is emitted when memongo sees a replica set
+	// member reach a state it was waiting for, such as PRIMARY once the set
+	// is initiated, SECONDARY for a member added with AddReplicaMember, or
+	// SECONDARY after stepping down. Its State is the new state.
+	EventMemberStateChanged EventType = "MemberStateChanged"
+
+	// EventPaused is emitted when PauseMember suspends a member's mongod
+	EventPaused EventType = "Paused"
+
+	// EventResumed is emitted when ResumeMember, or Stop, resumes a member
+	// PauseMember suspended
+	EventResumed EventType = "Resumed"
+
+	// EventUnexpectedExit is emitted when mongod exits without Stop being
+	// called
+	EventUnexpectedExit EventType = "UnexpectedExit"
+
+	// EventStopping is emitted when Stop is called. Its Err is set if the
+	// server stopped itself, e.g. to ErrDiskQuotaExceeded.
+	EventStopping EventType = "Stopping"
+
+	// EventStopped is emitted when the server has been stopped and cleaned up.
+	// It's the last event; the Events channel is closed after it.
+	EventStopped EventType = "Stopped"
+)
+
+// eventBufferSize is how many events the Events channel holds before events
+// are dropped
+const eventBufferSize = 64
+
+// Event is a step in the lifecycle of a server
+type Event struct {
+	// Type is what happened
+	Type EventType
+
+	// Time is when it happened
+	Time time.Time
+
+	// Member is the index of the replica set member the event is about, or
+	// of the mongos for a sharded cluster. It's 0 for standalone servers.
+	Member int
+
+	// Err is the error that caused the event, if any (e.g. the exit status
+	// for EventUnexpectedExit)
+	Err error
+
+	// State is the replica set state the member reached, e.g. "SECONDARY",
+	// for EventMemberStateChanged
+	State string
+}
+
+// eventBus delivers events to the Events channel without ever blocking the
+// server, and to the EventSink callback
+type eventBus struct {
+	mu      sync.Mutex
+	ch      chan Event
+	sink    func(Event)
+	dropped uint64
+	closed  bool
+}
+
+func newEventBus(sink func(Event)) *eventBus {
+	return &eventBus{
+		ch:   make(chan Event, eventBufferSize),
+		sink: sink,
+	}
+}
+
+func (b *eventBus) emit(typ EventType, member int, err error) {
+	b.send(Event{
+		Type:   typ,
+		Time:   time.Now(),
+		Member: member,
+		Err:    err,
+	})
+}
+
+// emitState emits EventMemberStateChanged for member reaching state
+func (b *eventBus) emitState(member int, state string) {
+	b.send(Event{
+		Type:   EventMemberStateChanged,
+		Time:   time.Now(),
+		Member: member,
+		State:  state,
+	})
+}
+
+func (b *eventBus) send(event Event) {
+	b.mu.Lock()
+	if !b.closed {
+		select {
+		case b.ch <- event:
+		default:
+			b.dropped++
+		}
+	}
+	sink := b.sink
+	b.mu.Unlock()
+
+	if sink != nil {
+		sink(event)
+	}
+}
+
+// close closes the Events channel. Events emitted after close are only
+// delivered to the sink.
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.closed {
+		b.closed = true
+		close(b.ch)
+	}
+}
+
+func (b *eventBus) droppedCount() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.dropped
+}
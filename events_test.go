@@ -0,0 +1,88 @@
+package memongo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventBus(t *testing.T) {
+	var sunk []Event
+	bus := newEventBus(func(e Event) {
+		sunk = append(sunk, e)
+	})
+
+	bus.emit(EventStarting, 0, nil)
+	bus.emit(EventUnexpectedExit, 2, errors.New("exit status 1"))
+
+	e := <-bus.ch
+	assert.Equal(t, EventStarting, e.Type)
+	assert.Equal(t, 0, e.Member)
+	assert.False(t, e.Time.IsZero())
+
+	e = <-bus.ch
+	assert.Equal(t, EventUnexpectedExit, e.Type)
+	assert.Equal(t, 2, e.Member)
+	assert.EqualError(t, e.Err, "exit status 1")
+
+	require.Len(t, sunk, 2)
+	assert.Equal(t, EventStarting, sunk[0].Type)
+	assert.Equal(t, EventUnexpectedExit, sunk[1].Type)
+}
+
+func TestEventBusDropsWhenFull(t *testing.T) {
+	sunk := 0
+	bus := newEventBus(func(e Event) {
+		sunk++
+	})
+
+	for i := 0; i < eventBufferSize+10; i++ {
+		bus.emit(EventListening, 0, nil)
+	}
+
+	assert.Len(t, bus.ch, eventBufferSize)
+	assert.Equal(t, uint64(10), bus.droppedCount())
+
+	// The sink never misses events
+	assert.Equal(t, eventBufferSize+10, sunk)
+}
+
+func TestEventBusClose(t *testing.T) {
+	sunk := 0
+	bus := newEventBus(func(e Event) {
+		sunk++
+	})
+
+	bus.emit(EventStopped, 0, nil)
+	bus.close()
+	bus.close()
+
+	// Emitting after close doesn't panic, and still reaches the sink
+	bus.emit(EventUnexpectedExit, 0, nil)
+
+	var received []EventType
+	for e := range bus.ch {
+		received = append(received, e.Type)
+	}
+	assert.Equal(t, []EventType{EventStopped}, received)
+	assert.Equal(t, 2, sunk)
+}
+
+func TestEventBusState(t *testing.T) {
+	bus := newEventBus(nil)
+	bus.emitState(2, "SECONDARY")
+
+	e := <-bus.ch
+	assert.Equal(t, EventMemberStateChanged, e.Type)
+	assert.Equal(t, 2, e.Member)
+	assert.Equal(t, "SECONDARY", e.State)
+	assert.False(t, e.Time.IsZero())
+}
+
+func TestEventBusWithoutSink(t *testing.T) {
+	bus := newEventBus(nil)
+	bus.emit(EventStarting, 0, nil)
+	assert.Len(t, bus.ch, 1)
+}
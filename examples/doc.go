@@ -0,0 +1,13 @@
+// Package examples holds runnable examples of memongo's main features. They
+// start real servers, so running them downloads mongod; they double as
+// end-to-end tests:
+//
+//	go test -run Example ./examples
+//
+// Build with -tags offline, or run with -short, to skip them where there's
+// no network.
+//
+// Example output can't include anything that changes between runs, such as
+// ports and data directories, so the package also has helpers that redact
+// them.
+package examples
@@ -0,0 +1,221 @@
+//go:build !offline
+// +build !offline
+
+package examples_test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/100mslive/memongo/v2"
+	"github.com/100mslive/memongo/v2/examples"
+	"github.com/100mslive/memongo/v2/memongolog"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if testing.Short() {
+		// The examples download and start mongod, so -short only runs tests
+		_ = flag.Set("test.run", "^Test")
+	}
+
+	os.Exit(m.Run())
+}
+
+// Start a standalone server, connect to it and stop it again
+func Example_standalone() {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelSilent,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer server.Stop()
+
+	ctx := context.Background()
+	client, err := mongo.Connect(options.Client().ApplyURI(server.URI()))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+
+	_, err = client.Database("app").Collection("users").InsertOne(ctx, bson.M{"name": "Alice"})
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(examples.Redact(server, server.URI()))
+	fmt.Println(server.StorageEngine(), server.IsReplicaSet())
+	// Output:
+	// mongodb://127.0.0.1:PORT
+	// wiredTiger false
+}
+
+// Start a single-member replica set with auth enabled, and create the first
+// user through the localhost exception
+func Example_replicaSetWithAuth() {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:     "8.0.0",
+		LogLevel:         memongolog.LogLevelSilent,
+		ShouldUseReplica: true,
+		Auth:             true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer server.Stop()
+
+	ctx := context.Background()
+	client, err := server.Client(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	err = client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "createUser", Value: "admin"},
+		{Key: "pwd", Value: "12345"},
+		{Key: "roles", Value: bson.A{"root"}},
+	}).Err()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(server.ReplicaSetName(), server.IsAuthEnabled())
+	fmt.Println(examples.Redact(server, server.DirectURI()))
+	// Output:
+	// rs0 true
+	// mongodb://127.0.0.1:PORT/?directConnection=true
+}
+
+// Seed collections when the server starts, so tests begin with known data
+func Example_seed() {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelSilent,
+		Seed: []memongo.SeedCollection{
+			{
+				Database:   "app",
+				Collection: "settings",
+				Documents: []interface{}{
+					bson.M{"_id": "theme", "value": "dark"},
+					bson.M{"_id": "language", "value": "en"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer server.Stop()
+
+	ctx := context.Background()
+	client, err := server.Client(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	var setting struct {
+		Value string `bson:"value"`
+	}
+	err = client.Database("app").Collection("settings").FindOne(ctx, bson.M{"_id": "theme"}).Decode(&setting)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(setting.Value)
+	// Output: dark
+}
+
+// Share the server's client, and its connection pool, instead of connecting
+// in every test
+func Example_sharedClient() {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelSilent,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer server.Stop()
+
+	ctx := context.Background()
+	first, err := server.Client(ctx)
+	if err != nil {
+		panic(err)
+	}
+	second, err := server.Client(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(first == second)
+	// Output: true
+}
+
+// Connect with a username and password to a server with auth enabled
+func Example_credentials() {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelSilent,
+		Auth:         true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer server.Stop()
+
+	ctx := context.Background()
+	admin, err := server.Client(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	err = admin.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "createUser", Value: "app"},
+		{Key: "pwd", Value: "secret"},
+		{Key: "roles", Value: bson.A{bson.M{"role": "readWrite", "db": "app"}}},
+	}).Err()
+	if err != nil {
+		panic(err)
+	}
+
+	clientOpts := options.Client().ApplyURI(server.URI()).SetAuth(options.Credential{
+		Username:   "app",
+		Password:   "secret",
+		AuthSource: "admin",
+	})
+	client, err := mongo.Connect(clientOpts)
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+
+	_, err = client.Database("app").Collection("orders").InsertOne(ctx, bson.M{"total": 42})
+	if err != nil {
+		panic(err)
+	}
+
+	// Without credentials, the same write is rejected
+	anonymous, err := mongo.Connect(options.Client().ApplyURI(server.URI()))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		_ = anonymous.Disconnect(ctx)
+	}()
+
+	_, err = anonymous.Database("app").Collection("orders").InsertOne(ctx, bson.M{"total": 42})
+	fmt.Println(err != nil)
+	// Output: true
+}
@@ -0,0 +1,32 @@
+package examples
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/100mslive/memongo/v2"
+)
+
+// reHostPort matches a local host and port, e.g. in a URI
+var reHostPort = regexp.MustCompile(`\b(localhost|127\.0\.0\.1):\d+\b`)
+
+// RedactPorts replaces the port of every localhost or 127.0.0.1 address in s
+// with PORT, e.g. mongodb://localhost:41234 becomes mongodb://localhost:PORT
+func RedactPorts(s string) string {
+	return reHostPort.ReplaceAllString(s, "${1}:PORT")
+}
+
+// Redact replaces the parts of s that change each time server is started
+// with placeholders: its port becomes PORT, wherever it follows a colon, and
+// its data directory becomes DBPATH
+func Redact(server *memongo.Server, s string) string {
+	if dbPath := server.DBPath(); dbPath != "" {
+		s = strings.ReplaceAll(s, dbPath, "DBPATH")
+	}
+	if port := server.Port(); port != 0 {
+		s = regexp.MustCompile(`:`+strconv.Itoa(port)+`\b`).ReplaceAllString(s, ":PORT")
+	}
+
+	return s
+}
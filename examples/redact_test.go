@@ -0,0 +1,27 @@
+package examples
+
+import (
+	"testing"
+
+	"github.com/100mslive/memongo/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactPorts(t *testing.T) {
+	tests := map[string]string{
+		"mongodb://localhost:41234":                     "mongodb://localhost:PORT",
+		"mongodb://127.0.0.1:41234/?directConnection=1": "mongodb://127.0.0.1:PORT/?directConnection=1",
+		"localhost:1,localhost:2":                       "localhost:PORT,localhost:PORT",
+		"mongodb://example.com:27017":                   "mongodb://example.com:27017",
+		"no address here":                               "no address here",
+	}
+
+	for input, expected := range tests {
+		assert.Equal(t, expected, RedactPorts(input), input)
+	}
+}
+
+func TestRedactUnstartedServer(t *testing.T) {
+	// A server that was never started has nothing to redact
+	assert.Equal(t, "mongodb://localhost:0", Redact(&memongo.Server{}, "mongodb://localhost:0"))
+}
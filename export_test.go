@@ -0,0 +1,4 @@
+package memongo
+
+// BenchmarkOptions exposes benchmarkOptions to the memongo_test package
+var BenchmarkOptions = benchmarkOptions
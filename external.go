@@ -0,0 +1,179 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// ExternalMemberOptions configures a mongod memongo doesn't manage that's
+// added to the replica set with AddExternalMember
+type ExternalMemberOptions struct {
+	// MemberOptions configure the member as for AddReplicaMember.
+	// AdvertisedHost is ignored, since the member is named by its host and
+	// port, and WaitForSecondary is implied.
+	MemberOptions
+
+	// KeyFile is the keyfile the member was started with. It's required
+	// with Options.Auth, and must hold the same key as the server's keyfile,
+	// which can be given with Options.ReplicaSetKeyFile.
+	KeyFile string
+}
+
+// ReplicaSetConfigDocument returns the replica set's current configuration,
+// as returned by replSetGetConfig. Other harnesses can use it to start
+// mongods that join the set with AddExternalMember.
+func (s *Server) ReplicaSetConfigDocument(ctx context.Context) (bson.D, error) {
+	if !s.isReplicaSet {
+		return nil, fmt.Errorf("cannot get the replica set config: %w", ErrNotReplicaSet)
+	}
+
+	client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	return replicaSetGetConfig(ctx, client)
+}
+
+// AddExternalMember adds the mongod at hostPort, which memongo doesn't
+// manage, to the replica set and waits for it to become a secondary. The
+// mongod must have been started with the server's replica set name and, with
+// Options.Auth, the same keyfile. A warning is logged if it runs a different
+// MongoDB release than the server, which replica sets only support during
+// upgrades.
+//
+// Like AddReplicaMember, the reconfiguration is retried while another one is
+// in progress, for up to Options.ReplicaSetReadyTimeout in total. If waiting
+// for the member fails, it's left in the replica set. Stop doesn't stop
+// external members.
+func (s *Server) AddExternalMember(ctx context.Context, hostPort string, opts ExternalMemberOptions) error {
+	if !s.isReplicaSet {
+		return fmt.Errorf("cannot add a replica set member: %w", ErrNotReplicaSet)
+	}
+
+	err := opts.validate()
+	if err != nil {
+		return err
+	}
+
+	err = s.checkExternalKeyFile(opts.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.opts.ReplicaSetReadyTimeout)
+	defer cancel()
+
+	s.warnMixedVersions(ctx, hostPort)
+
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	err = s.reconfigReplicaSet(ctx, client, func(config bson.D) (bson.D, error) {
+		return addConfigMember(config, memberDocument(hostPort, opts.MemberOptions))
+	})
+	if err != nil {
+		return fmt.Errorf("error adding external replica set member %s: %w", hostPort, err)
+	}
+	s.logger.Debugf("Added external replica set member %s", hostPort)
+
+	_, err = waitForMemberState(ctx, client, hostPort, "SECONDARY")
+	if err != nil {
+		return fmt.Errorf("error waiting for external replica set member %s to become a secondary: %w", hostPort, err)
+	}
+
+	return nil
+}
+
+// checkExternalKeyFile checks that an external member's keyfile holds the
+// same key as the server's
+func (s *Server) checkExternalKeyFile(keyFile string) error {
+	if s.keyFile == "" {
+		if keyFile != "" {
+			return fmt.Errorf("the replica set doesn't use a keyfile, but the external member does: start the server with Auth and ReplicaSetKeyFile")
+		}
+		return nil
+	}
+
+	if keyFile == "" {
+		return fmt.Errorf("the replica set uses a keyfile, so the external member's KeyFile must be given")
+	}
+
+	want, err := readKeyFile(s.keyFile)
+	if err != nil {
+		return err
+	}
+	got, err := readKeyFile(keyFile)
+	if err != nil {
+		return fmt.Errorf("invalid external member KeyFile: %w", err)
+	}
+	if got != want {
+		return fmt.Errorf("the external member's keyfile %s doesn't hold the replica set's key from %s", keyFile, s.keyFile)
+	}
+
+	return nil
+}
+
+// warnMixedVersions logs a warning if the mongod at hostPort runs a
+// different release than the server. buildInfo doesn't need authentication,
+// so this works before the member has joined.
+func (s *Server) warnMixedVersions(ctx context.Context, hostPort string) {
+	ours, err := s.BuildInfo(ctx)
+	if err != nil {
+		s.logger.Debugf("couldn't check the server's version: %s", err)
+		return
+	}
+
+	theirs, err := remoteBuildInfo(ctx, hostPort)
+	if err != nil {
+		s.logger.Debugf("couldn't check the version of %s: %s", hostPort, err)
+		return
+	}
+
+	if mixedVersions(ours, theirs) {
+		s.logger.Warnf("external replica set member %s runs MongoDB %s, but the server runs %s; replica sets only support mixed versions during upgrades",
+			hostPort, theirs.Version, ours.Version)
+	}
+}
+
+func remoteBuildInfo(ctx context.Context, hostPort string) (BuildInfo, error) {
+	// hostPort is the caller's, so the URI may not be valid
+	uri := mongoURI(hostPort).param("directConnection", "true").format()
+	err := ValidateURI(uri)
+	if err != nil {
+		return BuildInfo{}, err
+	}
+
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		return BuildInfo{}, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	raw, err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Raw()
+	if err != nil {
+		return BuildInfo{}, fmt.Errorf("error running buildInfo: %w", err)
+	}
+
+	return decodeBuildInfo(raw)
+}
+
+// mixedVersions returns whether a and b are different MongoDB releases,
+// i.e. differ in their major or minor version
+func mixedVersions(a BuildInfo, b BuildInfo) bool {
+	return a.VersionArray[0] != b.VersionArray[0] || a.VersionArray[1] != b.VersionArray[1]
+}
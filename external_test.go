@@ -0,0 +1,138 @@
+package memongo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestKeyFile(t *testing.T, contents string, mode os.FileMode) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "keyfile")
+	require.NoError(t, os.WriteFile(path, []byte(contents), mode))
+
+	return path
+}
+
+func TestReadKeyFile(t *testing.T) {
+	key, err := readKeyFile(writeTestKeyFile(t, "c2VjcmV0\n a2V5\n", 0400))
+	require.NoError(t, err)
+	assert.Equal(t, "c2VjcmV0a2V5", key)
+
+	tests := map[string]struct {
+		contents string
+		mode     os.FileMode
+
+		expectedError string
+	}{
+		"readable by others": {
+			contents:      "insecurekeyfile",
+			mode:          0644,
+			expectedError: "are too open",
+		},
+		"too short": {
+			contents:      "abc\n",
+			mode:          0600,
+			expectedError: "must be 6 to 1024 characters long, not 3",
+		},
+		"too long": {
+			contents:      strings.Repeat("a", 1025),
+			mode:          0600,
+			expectedError: "must be 6 to 1024 characters long, not 1025",
+		},
+		"not base64": {
+			contents:      "not-a-key!",
+			mode:          0600,
+			expectedError: "may only contain base64 characters",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := readKeyFile(writeTestKeyFile(t, test.contents, test.mode))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expectedError)
+		})
+	}
+
+	_, err = readKeyFile(filepath.Join(t.TempDir(), "missing"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCheckExternalKeyFile(t *testing.T) {
+	ours := writeTestKeyFile(t, "sharedkey", 0400)
+	same := writeTestKeyFile(t, "sharedkey\n", 0400)
+	other := writeTestKeyFile(t, "otherkey", 0400)
+
+	server := &Server{keyFile: ours}
+	assert.NoError(t, server.checkExternalKeyFile(same))
+
+	err := server.checkExternalKeyFile(other)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "doesn't hold the replica set's key")
+
+	err = server.checkExternalKeyFile("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "KeyFile must be given")
+
+	server = &Server{}
+	assert.NoError(t, server.checkExternalKeyFile(""))
+	assert.Error(t, server.checkExternalKeyFile(same))
+}
+
+func TestMixedVersions(t *testing.T) {
+	v800 := BuildInfo{VersionArray: []int{8, 0, 0, 0}}
+	v804 := BuildInfo{VersionArray: []int{8, 0, 4, 0}}
+	v702 := BuildInfo{VersionArray: []int{7, 0, 2, 0}}
+
+	assert.False(t, mixedVersions(v800, v804))
+	assert.True(t, mixedVersions(v800, v702))
+}
+
+func TestAdvertisedReplicaHost(t *testing.T) {
+	caps, err := capabilitiesForVersion("8.0.0")
+	require.NoError(t, err)
+
+	opts := &Options{ShouldUseReplica: true, ReplicaSetName: "rs0", AdvertisedReplicaHost: "mongo.test"}
+	args, _ := mongodArgs(opts, caps, "/tmp/db", 27017, "", opts.AdvertisedReplicaHost)
+	assert.Contains(t, strings.Join(args, " "), "--bind_ip localhost,mongo.test ")
+
+	args, _ = mongodArgs(opts, caps, "/tmp/db", 27017, "", "")
+	assert.Contains(t, strings.Join(args, " "), "--bind_ip localhost ")
+
+	server := &Server{port: 27017, host: "127.0.0.1", opts: *opts}
+	assert.Equal(t, "mongo.test:27017", server.replicaHost())
+	assert.Equal(t, "mongodb://127.0.0.1:27017", server.URI())
+
+	proc := &mongodProcess{host: "127.0.0.1", port: 27018}
+	assert.Equal(t, "127.0.0.1:27018", server.memberHost(proc))
+	proc.advertisedHost = "member.test"
+	assert.Equal(t, "member.test:27018", server.memberHost(proc))
+}
+
+func TestValidateReplicaSetKeyFile(t *testing.T) {
+	keyFile := writeTestKeyFile(t, "sharedkey", 0400)
+
+	opts := &Options{MongodBin: "/bin/true", ShouldUseReplica: true, Auth: true, ReplicaSetKeyFile: keyFile}
+	assert.NoError(t, opts.Validate())
+
+	opts = &Options{MongodBin: "/bin/true", ShouldUseReplica: true, ReplicaSetKeyFile: keyFile}
+	assert.EqualError(t, opts.Validate(), "cannot use ReplicaSetKeyFile without ShouldUseReplica and Auth")
+
+	opts = &Options{MongodBin: "/bin/true", ShouldUseReplica: true, Auth: true, ReplicaSetKeyFile: writeTestKeyFile(t, "sharedkey", 0644)}
+	err := opts.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid ReplicaSetKeyFile: permissions on")
+
+	opts = &Options{MongodBin: "/bin/true", AdvertisedReplicaHost: "mongo.test"}
+	assert.EqualError(t, opts.Validate(), "cannot use AdvertisedReplicaHost without ShouldUseReplica")
+
+	// The user's keyfile is never removed
+	removeKeyFile(&Options{ReplicaSetKeyFile: keyFile}, keyFile, nil)
+	assert.FileExists(t, keyFile)
+}
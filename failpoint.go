@@ -0,0 +1,106 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// InjectionFilter narrows which commands an injected error or delay applies
+// to. Empty fields match anything.
+type InjectionFilter struct {
+	// AppName only matches commands from clients with this appName
+	AppName string
+
+	// Namespace only matches commands on this "database.collection". Older
+	// servers don't support it and reject the failpoint.
+	Namespace string
+}
+
+// ConfigureFailPoint runs the configureFailPoint command to set the failpoint
+// name with the given mode ("alwaysOn", "off", or e.g. bson.M{"times": 2})
+// and data. It requires Options.EnableTestCommands.
+func (s *Server) ConfigureFailPoint(ctx context.Context, name string, mode interface{}, data bson.D) error {
+	if !s.testCommandsEnabled() {
+		return fmt.Errorf("failpoints require Options.EnableTestCommands")
+	}
+
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+
+	cmd := bson.D{
+		{Key: "configureFailPoint", Value: name},
+		{Key: "mode", Value: mode},
+	}
+	if data != nil {
+		cmd = append(cmd, bson.E{Key: "data", Value: data})
+	}
+
+	err = client.Database("admin").RunCommand(ctx, cmd).Err()
+	if err != nil {
+		return fmt.Errorf("error configuring failpoint %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func (s *Server) testCommandsEnabled() bool {
+	return s.opts.EnableTestCommands || s.opts.EnforceMaxTimeMS
+}
+
+// InjectCommandError makes the next times runs of the given commands (e.g.
+// "find", "insert") fail with the error code. Only one injection is active at
+// a time; a new one replaces the last. It requires
+// Options.EnableTestCommands.
+func (s *Server) InjectCommandError(ctx context.Context, commands []string, code int, times int, filter ...InjectionFilter) error {
+	return s.injectFailCommand(ctx, commands, times, filter, bson.D{
+		{Key: "errorCode", Value: code},
+	})
+}
+
+// InjectCommandDelay makes the next times runs of the given commands take at
+// least delay longer. Like InjectCommandError, it replaces any active
+// injection and requires Options.EnableTestCommands.
+func (s *Server) InjectCommandDelay(ctx context.Context, commands []string, delay time.Duration, times int, filter ...InjectionFilter) error {
+	return s.injectFailCommand(ctx, commands, times, filter, bson.D{
+		{Key: "blockConnection", Value: true},
+		{Key: "blockTimeMS", Value: delay.Milliseconds()},
+	})
+}
+
+// ClearInjections turns off any injected errors or delays
+func (s *Server) ClearInjections(ctx context.Context) error {
+	return s.ConfigureFailPoint(ctx, "failCommand", "off", nil)
+}
+
+func (s *Server) injectFailCommand(ctx context.Context, commands []string, times int, filters []InjectionFilter, behavior bson.D) error {
+	if len(commands) == 0 {
+		return fmt.Errorf("at least one command must be given")
+	}
+	if times < 1 {
+		return fmt.Errorf("times must be at least 1, got %d", times)
+	}
+	if len(filters) > 1 {
+		return fmt.Errorf("at most one InjectionFilter may be given")
+	}
+
+	data := bson.D{{Key: "failCommands", Value: commands}}
+	data = append(data, behavior...)
+	if len(filters) == 1 {
+		if filters[0].AppName != "" {
+			data = append(data, bson.E{Key: "appName", Value: filters[0].AppName})
+		}
+		if filters[0].Namespace != "" {
+			data = append(data, bson.E{Key: "namespace", Value: filters[0].Namespace})
+		}
+	}
+
+	return s.ConfigureFailPoint(ctx, "failCommand", bson.D{{Key: "times", Value: times}}, data)
+}
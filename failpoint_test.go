@@ -0,0 +1,23 @@
+package memongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInjectionsRequireTestCommands(t *testing.T) {
+	ctx := context.Background()
+	server := &Server{}
+
+	assert.EqualError(t, server.InjectCommandError(ctx, []string{"find"}, 11600, 1), "failpoints require Options.EnableTestCommands")
+	assert.EqualError(t, server.InjectCommandDelay(ctx, []string{"find"}, time.Second, 1), "failpoints require Options.EnableTestCommands")
+	assert.EqualError(t, server.ClearInjections(ctx), "failpoints require Options.EnableTestCommands")
+
+	server.opts.EnableTestCommands = true
+	assert.EqualError(t, server.InjectCommandError(ctx, nil, 11600, 1), "at least one command must be given")
+	assert.EqualError(t, server.InjectCommandError(ctx, []string{"find"}, 11600, 0), "times must be at least 1, got 0")
+	assert.EqualError(t, server.InjectCommandError(ctx, []string{"find"}, 11600, 1, InjectionFilter{}, InjectionFilter{}), "at most one InjectionFilter may be given")
+}
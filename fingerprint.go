@@ -0,0 +1,147 @@
+package memongo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// fingerprintVersion is part of every fingerprint, so fingerprints change
+// if what they cover does
+const fingerprintVersion = "v1"
+
+// fingerprintFields are the options that decide how a server behaves. Ports,
+// paths, logging and timeouts don't, and neither does seed data.
+type fingerprintFields struct {
+	Version                   string              `json:"version"`
+	Binary                    string              `json:"binary"`
+	ReplicaSet                string              `json:"replicaSet"`
+	DeferReplicaSetInitiation bool                `json:"deferReplicaSetInitiation"`
+	ReplicaMemberTags         []map[string]string `json:"replicaMemberTags"`
+	ReplicaMembers            int                 `json:"replicaMembers,omitempty"`
+	Shards                    int                 `json:"shards,omitempty"`
+	Mongos                    int                 `json:"mongos,omitempty"`
+	Balancer                  bool                `json:"balancer,omitempty"`
+	Diskless                  bool                `json:"diskless,omitempty"`
+	Auth                      bool                `json:"auth"`
+	ReadOnly                  bool                `json:"readOnly"`
+	TLS                       bool                `json:"tls"`
+	WiredTigerCacheSizeGB     float64             `json:"wiredTigerCacheSizeGB"`
+	WiredTigerCacheSizePct    float64             `json:"wiredTigerCacheSizePct"`
+	EnableTestCommands        bool                `json:"enableTestCommands"`
+	EnforceMaxTimeMS          bool                `json:"enforceMaxTimeMS"`
+	CursorTimeout             time.Duration       `json:"cursorTimeout"`
+	TTLMonitorInterval        time.Duration       `json:"ttlMonitorInterval"`
+	Clock                     string              `json:"clock"`
+}
+
+// Fingerprint returns a hash of the options that decide how a server started
+// with them behaves: the MongoDB binary (by version, download URL or the
+// contents of MongodBin), the replica set topology, auth, TLS and storage
+// and server parameters. Ports, paths, logging, timeouts and seed data are
+// left out. Environment variables and defaults are applied first, so options
+// that start identical servers have the same fingerprint.
+func (opts *Options) Fingerprint() (string, error) {
+	effective := *opts
+	err := effective.applyEnv()
+	if err != nil {
+		return "", err
+	}
+	if effective.MongodBin == "" {
+		effective.MongodBin = os.Getenv("MEMONGO_MONGOD_BIN")
+	}
+	d := effective.downloadOptions()
+	if d.DownloadURL == "" {
+		d.DownloadURL = os.Getenv("MEMONGO_DOWNLOAD_URL")
+	}
+	effective.setDownloadOptions(d)
+
+	return effective.fingerprint()
+}
+
+// ConfigFingerprint returns the Fingerprint of the options the server was
+// started with
+func (s *Server) ConfigFingerprint() (string, error) {
+	return s.opts.fingerprint()
+}
+
+func (opts *Options) fingerprint() (string, error) {
+	fields := fingerprintFields{
+		Auth:                   opts.Auth,
+		ReadOnly:               opts.ReadOnly,
+		WiredTigerCacheSizeGB:  opts.WiredTigerCacheSizeGB,
+		WiredTigerCacheSizePct: opts.WiredTigerCacheSizePct,
+		EnableTestCommands:     opts.EnableTestCommands || opts.EnforceMaxTimeMS,
+		EnforceMaxTimeMS:       opts.EnforceMaxTimeMS,
+		CursorTimeout:          opts.CursorTimeout,
+		TTLMonitorInterval:     opts.TTLMonitorInterval,
+	}
+	if opts.ClockWrapper != nil {
+		fields.Clock = opts.ClockWrapper.faketimeSpec()
+	}
+
+	// A given binary is run whatever MongoVersion says, and a download URL
+	// decides the binary that's downloaded
+	switch {
+	case opts.MongodBin != "":
+		hash, err := hashBinary(opts.MongodBin)
+		if err != nil {
+			return "", fmt.Errorf("error fingerprinting MongodBin: %w", err)
+		}
+		fields.Binary = "sha256:" + hash
+	case opts.downloadOptions().DownloadURL != "":
+		fields.Binary = opts.downloadOptions().DownloadURL
+	default:
+		fields.Version = opts.MongoVersion
+	}
+
+	// ReadOnly servers are replica sets
+	if opts.ShouldUseReplica || opts.ReadOnly {
+		fields.ReplicaSet = opts.ReplicaSetName
+		if fields.ReplicaSet == "" {
+			fields.ReplicaSet = "rs0"
+		}
+		fields.DeferReplicaSetInitiation = opts.DeferReplicaSetInitiation
+		fields.ReplicaMemberTags = opts.ReplicaMemberTags
+		if opts.ReplicaMembers > 1 {
+			fields.ReplicaMembers = opts.ReplicaMembers
+		}
+	}
+	if opts.Sharded {
+		fields.Shards = opts.shardCount()
+		if opts.mongosCount() > 1 {
+			fields.Mongos = opts.mongosCount()
+		}
+		fields.Balancer = opts.EnableBalancer
+	}
+	fields.Diskless = opts.Diskless
+
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(append([]byte(fingerprintVersion+"\n"), encoded...))
+	return fingerprintVersion + ":" + hex.EncodeToString(sum[:]), nil
+}
+
+func hashFile(path string) (string, error) {
+	//nolint:gosec
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
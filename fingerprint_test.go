@@ -0,0 +1,112 @@
+package memongo
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFingerprint(t *testing.T) {
+	base := func() *Options {
+		return &Options{MongoVersion: "8.0.0", ShouldUseReplica: true}
+	}
+
+	baseFingerprint, err := base().Fingerprint()
+	require.NoError(t, err)
+
+	changed := map[string]func(*Options){
+		"MongoVersion":              func(o *Options) { o.MongoVersion = "7.0.2" },
+		"DownloadURL":               func(o *Options) { o.DownloadURL = "https://example.com/mongodb.tgz" },
+		"ShouldUseReplica":          func(o *Options) { o.ShouldUseReplica = false },
+		"ReplicaSetName":            func(o *Options) { o.ReplicaSetName = "rs1" },
+		"DeferReplicaSetInitiation": func(o *Options) { o.DeferReplicaSetInitiation = true },
+		"ReplicaMemberTags":         func(o *Options) { o.ReplicaMemberTags = []map[string]string{{"dc": "east"}} },
+		"Auth":                      func(o *Options) { o.Auth = true },
+		"ReadOnly":                  func(o *Options) { o.ReadOnly = true },
+		"WiredTigerCacheSizeGB":     func(o *Options) { o.WiredTigerCacheSizeGB = 0.5 },
+		"WiredTigerCacheSizePct":    func(o *Options) { o.WiredTigerCacheSizePct = 10 },
+		"EnableTestCommands":        func(o *Options) { o.EnableTestCommands = true },
+		"EnforceMaxTimeMS":          func(o *Options) { o.EnforceMaxTimeMS = true },
+		"CursorTimeout":             func(o *Options) { o.CursorTimeout = time.Second },
+		"TTLMonitorInterval":        func(o *Options) { o.TTLMonitorInterval = time.Second },
+		"ClockWrapper":              func(o *Options) { o.ClockWrapper = &ClockWrapper{Offset: time.Hour} },
+		"Sharded":                   func(o *Options) { o.ShouldUseReplica, o.Sharded = false, true },
+		"Diskless":                  func(o *Options) { o.Diskless = true },
+	}
+	for name, change := range changed {
+		t.Run("changes with "+name, func(t *testing.T) {
+			opts := base()
+			change(opts)
+
+			fingerprint, err := opts.Fingerprint()
+			require.NoError(t, err)
+			assert.NotEqual(t, baseFingerprint, fingerprint)
+		})
+	}
+
+	sharded, err := (&Options{MongoVersion: "8.0.0", Sharded: true}).Fingerprint()
+	require.NoError(t, err)
+	balanced, err := (&Options{MongoVersion: "8.0.0", Sharded: true, EnableBalancer: true}).Fingerprint()
+	require.NoError(t, err)
+	assert.NotEqual(t, sharded, balanced)
+	routed, err := (&Options{MongoVersion: "8.0.0", Sharded: true, MongosCount: 2}).Fingerprint()
+	require.NoError(t, err)
+	assert.NotEqual(t, sharded, routed)
+	single, err := (&Options{MongoVersion: "8.0.0", Sharded: true, MongosCount: 1}).Fingerprint()
+	require.NoError(t, err)
+	assert.Equal(t, sharded, single)
+
+	unchanged := map[string]func(*Options){
+		"default ReplicaSetName": func(o *Options) { o.ReplicaSetName = "rs0" },
+		"Port":                   func(o *Options) { o.Port = 27017 },
+		"PortRange":              func(o *Options) { o.PortRange = [2]int{20000, 20100} },
+		"CachePath":              func(o *Options) { o.CachePath = "/tmp/cache" },
+		"TempDirBase":            func(o *Options) { o.TempDirBase = "/tmp/memongo" },
+		"DataDir":                func(o *Options) { o.DataDir = "/scratch" },
+		"Logger":                 func(o *Options) { o.Logger = log.New(os.Stderr, "", 0) },
+		"LogLevel":               func(o *Options) { o.LogLevel = memongolog.LogLevelDebug },
+		"StartupTimeout":         func(o *Options) { o.StartupTimeout = time.Minute },
+		"HealthHTTPAddr":         func(o *Options) { o.HealthHTTPAddr = "localhost:8081" },
+		"Seed":                   func(o *Options) { o.Seed = []SeedCollection{{Database: "app", Collection: "users"}} },
+		"StartRetries":           func(o *Options) { o.StartRetries = 3 },
+	}
+	for name, change := range unchanged {
+		t.Run("doesn't change with "+name, func(t *testing.T) {
+			opts := base()
+			change(opts)
+
+			fingerprint, err := opts.Fingerprint()
+			require.NoError(t, err)
+			assert.Equal(t, baseFingerprint, fingerprint)
+		})
+	}
+}
+
+func TestFingerprintMongodBin(t *testing.T) {
+	dir := t.TempDir()
+	bin1 := filepath.Join(dir, "mongod1")
+	bin2 := filepath.Join(dir, "mongod2")
+	bin3 := filepath.Join(dir, "mongod3")
+	require.NoError(t, os.WriteFile(bin1, []byte("mongod 7.0.2"), 0700))
+	require.NoError(t, os.WriteFile(bin2, []byte("mongod 7.0.2"), 0700))
+	require.NoError(t, os.WriteFile(bin3, []byte("mongod 8.0.0"), 0700))
+
+	fingerprint := func(bin string) string {
+		f, err := (&Options{MongodBin: bin, MongoVersion: "7.0.2"}).Fingerprint()
+		require.NoError(t, err)
+		return f
+	}
+
+	// The binary's contents matter, not its path
+	assert.Equal(t, fingerprint(bin1), fingerprint(bin2))
+	assert.NotEqual(t, fingerprint(bin1), fingerprint(bin3))
+
+	_, err := (&Options{MongodBin: filepath.Join(dir, "missing")}).Fingerprint()
+	assert.Error(t, err)
+}
@@ -0,0 +1,78 @@
+package memongo
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// StartForTest starts a server for use in a test, and stops it when the test
+// and its subtests finish. Unless opts sets a Logger, memongo's messages and
+// mongod's output are written with t.Logf, so they're shown with the test
+// that logged them rather than interleaved on stdout. If the test fails, the
+// server is marked as failed, so Stop keeps what Options.Cleanup says to keep
+// on failure.
+//
+// The test is failed at once if the server can't be started. Tests calling
+// StartForTest may run in parallel: each server gets its own port and
+// dbpath, unless opts or MEMONGO_MONGOD_PORT sets a Port.
+func StartForTest(t *testing.T, opts *Options) *Server {
+	t.Helper()
+
+	testOpts := Options{}
+	if opts != nil {
+		testOpts = *opts
+	}
+	var out *testLogWriter
+	if testOpts.Logger == nil {
+		out = &testLogWriter{t: t}
+		testOpts.Logger = log.New(out, "", 0)
+	}
+
+	server, err := StartWithOptions(&testOpts)
+	if err != nil {
+		out.close()
+		t.Fatalf("error starting MongoDB: %s", err)
+	}
+
+	t.Cleanup(func() {
+		if t.Failed() {
+			server.MarkFailed()
+		}
+		server.Stop()
+		out.close()
+	})
+
+	return server
+}
+
+// testLogWriter writes log lines with t.Logf until it's closed. Logging
+// after a test has finished panics, and mongod's output can still be read
+// after then.
+type testLogWriter struct {
+	mu     sync.Mutex
+	t      *testing.T
+	closed bool
+}
+
+func (w *testLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.t.Logf("%s", strings.TrimSuffix(string(p), "\n"))
+	}
+
+	return len(p), nil
+}
+
+// close stops writing to the test. It does nothing on a nil writer.
+func (w *testLogWriter) close() {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+}
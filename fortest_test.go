@@ -0,0 +1,26 @@
+package memongo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTestLogWriter(t *testing.T) {
+	var w *testLogWriter
+	t.Run("logs", func(t *testing.T) {
+		w = &testLogWriter{t: t}
+		n, err := w.Write([]byte("mongod is listening\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, 20, n)
+		w.close()
+	})
+
+	// Logging to a finished test would panic
+	n, err := w.Write([]byte("mongod exited\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 14, n)
+
+	var closed *testLogWriter
+	closed.close()
+}
@@ -0,0 +1,67 @@
+package memongo
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+)
+
+// unsuitableFilesystems are filesystems WiredTiger can't reliably lock its
+// files on. mongod fails to start on them, usually after a long wait.
+var unsuitableFilesystems = map[string]bool{
+	"nfs":    true,
+	"cifs":   true,
+	"smb2":   true,
+	"9p":     true,
+	"fuse":   true,
+	"vboxsf": true,
+}
+
+// suspectFilesystems work in most setups, but not all: overlayfs in a
+// rootless container can't lock files
+var suspectFilesystems = map[string]bool{
+	"overlayfs": true,
+}
+
+// reFilesystemLock matches the error WiredTiger logs when it can't lock its
+// files. Each server gets a fresh data directory, so nothing else holds the
+// lock: the filesystem doesn't support it.
+var reFilesystemLock = regexp.MustCompile(`(wiredtiger\.lock|unable to acquire lock).*resource temporarily unavailable`)
+
+// statFilesystem returns the type of the filesystem path is on, or "" if it
+// can't tell. It's a variable so tests can fake it.
+var statFilesystem = filesystemType
+
+// unsuitableFilesystemError explains that mongod can't run with its data
+// directory dir on fsType. Either may be "" if it isn't known.
+func unsuitableFilesystemError(dir string, fsType string) error {
+	where := "the data directory"
+	if dir != "" {
+		where = dir
+	}
+	if fsType != "" {
+		where += " on " + fsType
+	}
+
+	return fmt.Errorf("%w: WiredTiger can't lock files in %s; set DataDir or TempDirBase (or MEMONGO_TMPDIR) to a directory on a local filesystem, such as /dev/shm, or set SkipFilesystemCheck", ErrUnsuitableFilesystem, where)
+}
+
+// checkFilesystem fails if dir is on a filesystem mongod can't use, and warns
+// if it's on one that it might not be able to use
+func checkFilesystem(dir string, logger *memongolog.Logger) error {
+	fsType, err := statFilesystem(dir)
+	if err != nil {
+		logger.Debugf("Couldn't detect the filesystem of %s: %s", dir, err)
+		return nil
+	}
+
+	if unsuitableFilesystems[fsType] {
+		return unsuitableFilesystemError(dir, fsType)
+	}
+	if suspectFilesystems[fsType] {
+		logger.Warnf("The data directory %s is on %s, which can't lock files in rootless containers; if mongod fails to start, set DataDir to a directory on a local filesystem", dir, fsType)
+	}
+
+	return nil
+}
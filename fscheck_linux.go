@@ -0,0 +1,32 @@
+//go:build linux
+// +build linux
+
+package memongo
+
+import "syscall"
+
+// filesystemMagic maps statfs magic numbers to filesystem names
+var filesystemMagic = map[int64]string{
+	0x6969:     "nfs",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x01021997: "9p",
+	0x65735546: "fuse",
+	0x786f4256: "vboxsf",
+	0x794c7630: "overlayfs",
+	0x01021994: "tmpfs",
+	0xef53:     "ext4",
+	0x58465342: "xfs",
+	0x9123683e: "btrfs",
+}
+
+func filesystemType(path string) (string, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(path, &st)
+	if err != nil {
+		return "", err
+	}
+
+	//nolint:unconvert // the type of Type differs between architectures
+	return filesystemMagic[int64(st.Type)], nil
+}
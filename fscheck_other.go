@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package memongo
+
+// filesystemType isn't implemented outside Linux, where the filesystems that
+// cause trouble are rare
+func filesystemType(path string) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,97 @@
+package memongo
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeFilesystem(t *testing.T, fsType string, err error) {
+	t.Helper()
+
+	statFilesystem = func(string) (string, error) {
+		return fsType, err
+	}
+	t.Cleanup(func() {
+		statFilesystem = filesystemType
+	})
+}
+
+func TestCheckFilesystem(t *testing.T) {
+	tests := map[string]struct {
+		fsType  string
+		statErr error
+
+		expectedError string
+		expectedWarn  string
+	}{
+		"local":   {fsType: "ext4"},
+		"unknown": {fsType: ""},
+		"stat fails": {
+			statErr: errors.New("no such file or directory"),
+		},
+		"nfs": {
+			fsType:        "nfs",
+			expectedError: "unsuitable filesystem for the data directory: WiredTiger can't lock files in /tmp/memongo123 on nfs; set DataDir or TempDirBase (or MEMONGO_TMPDIR) to a directory on a local filesystem, such as /dev/shm, or set SkipFilesystemCheck",
+		},
+		"overlayfs": {
+			fsType:       "overlayfs",
+			expectedWarn: "The data directory /tmp/memongo123 is on overlayfs",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			fakeFilesystem(t, test.fsType, test.statErr)
+
+			var out bytes.Buffer
+			logger := memongolog.New(log.New(&out, "", 0), memongolog.LogLevelWarn)
+
+			err := checkFilesystem("/tmp/memongo123", logger)
+			if test.expectedError != "" {
+				assert.ErrorIs(t, err, ErrUnsuitableFilesystem)
+				assert.EqualError(t, err, test.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if test.expectedWarn != "" {
+				assert.Contains(t, out.String(), test.expectedWarn)
+			} else {
+				assert.Empty(t, out.String())
+			}
+		})
+	}
+}
+
+func TestFilesystemType(t *testing.T) {
+	_, err := filesystemType(t.TempDir())
+	assert.NoError(t, err)
+}
+
+func TestStdoutHandlerFilesystemLock(t *testing.T) {
+	line := `{"t":{"$date":"2024-05-01T10:00:00.000+00:00"},"s":"E","c":"WT","id":22435,"ctx":"initandlisten","msg":"WiredTiger error message","attr":{"error":11,"message":{"msg":"__posix_file_lock:364:/tmp/memongo123/WiredTiger.lock: handle-lock: fcntl: Resource temporarily unavailable"}}}`
+
+	caps := capabilityTable[len(capabilityTable)-1]
+	stdout, errCh, readyCh, _, _ := stdoutHandler(memongolog.New(nil, memongolog.LogLevelSilent), caps.reReady)
+	defer stdout.Close()
+	go func() {
+		_, _ = stdout.Write([]byte(line + "\n"))
+	}()
+
+	select {
+	case err := <-errCh:
+		require.ErrorIs(t, err, ErrUnsuitableFilesystem)
+		assert.Contains(t, err.Error(), "WiredTiger can't lock files in the data directory;")
+	case <-readyCh:
+		t.Fatal("mongod reported ready")
+	case <-time.After(time.Second):
+		t.Fatal("no startup error")
+	}
+}
@@ -0,0 +1,123 @@
+// Package ginkgoext wires memongo into Ginkgo suites: one server for the
+// whole suite, and an isolated database for every spec.
+//
+// It's a separate module so that users of memongo who don't use Ginkgo don't
+// depend on it.
+package ginkgoext
+
+import (
+	"context"
+	"sync"
+
+	"github.com/100mslive/memongo/v2"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	mu      sync.Mutex
+	current *memongo.Server
+)
+
+// SetupSuiteServer registers a BeforeSuite that starts a server with the
+// given options, and an AfterSuite that stops it. Call it at the top level of
+// a suite file:
+//
+//	var _ = ginkgoext.SetupSuiteServer(&memongo.Options{MongoVersion: "8.0.0"})
+//
+// The server is available to specs through CurrentServer.
+func SetupSuiteServer(opts *memongo.Options) bool {
+	ginkgo.BeforeSuite(func() {
+		server, err := memongo.StartWithOptions(opts)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred(), "error starting MongoDB")
+
+		mu.Lock()
+		current = server
+		mu.Unlock()
+	})
+
+	ginkgo.AfterSuite(func() {
+		mu.Lock()
+		server := current
+		current = nil
+		mu.Unlock()
+
+		if server != nil {
+			server.Stop()
+		}
+	})
+
+	return true
+}
+
+// CurrentServer returns the server started by SetupSuiteServer, or nil if the
+// suite isn't running.
+func CurrentServer() *memongo.Server {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return current
+}
+
+// SpecDatabase is a database that's created fresh for every spec
+type SpecDatabase struct {
+	name   string
+	client *mongo.Client
+}
+
+// PerSpecDatabase registers a BeforeEach that picks a random database on the
+// suite's server, and an AfterEach that drops it. Call it in a container node,
+// and use the returned SpecDatabase in the specs:
+//
+//	var db = ginkgoext.PerSpecDatabase()
+//
+//	It("inserts", func() {
+//		_, err := db.Database().Collection("users").InsertOne(ctx, user)
+//		Expect(err).NotTo(HaveOccurred())
+//	})
+func PerSpecDatabase() *SpecDatabase {
+	db := &SpecDatabase{}
+
+	ginkgo.BeforeEach(func() {
+		server := CurrentServer()
+		gomega.Expect(server).NotTo(gomega.BeNil(), "PerSpecDatabase requires SetupSuiteServer")
+
+		client, err := mongo.Connect(options.Client().ApplyURI(server.URI()))
+		gomega.Expect(err).NotTo(gomega.HaveOccurred(), "error connecting to MongoDB")
+
+		db.name = memongo.RandomDatabase()
+		db.client = client
+	})
+
+	ginkgo.AfterEach(func() {
+		// The BeforeEach failed before connecting, and that's what the spec
+		// reports
+		if db.client == nil {
+			return
+		}
+		ctx := context.Background()
+
+		err := db.client.Database(db.name).Drop(ctx)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred(), "error dropping database %s", db.name)
+
+		err = db.client.Disconnect(ctx)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred(), "error disconnecting from MongoDB")
+
+		db.name = ""
+		db.client = nil
+	})
+
+	return db
+}
+
+// Name returns the name of the current spec's database
+func (db *SpecDatabase) Name() string {
+	return db.name
+}
+
+// Database returns a handle to the current spec's database
+func (db *SpecDatabase) Database() *mongo.Database {
+	return db.client.Database(db.name)
+}
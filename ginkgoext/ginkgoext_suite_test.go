@@ -0,0 +1,51 @@
+package ginkgoext_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/100mslive/memongo/v2"
+	"github.com/100mslive/memongo/v2/ginkgoext"
+	"github.com/100mslive/memongo/v2/memongolog"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func TestGinkgoext(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Ginkgoext Suite")
+}
+
+var _ = ginkgoext.SetupSuiteServer(&memongo.Options{
+	MongoVersion: "8.0.0",
+	LogLevel:     memongolog.LogLevelWarn,
+})
+
+var _ = Describe("PerSpecDatabase", Ordered, func() {
+	db := ginkgoext.PerSpecDatabase()
+	var previous string
+
+	It("gives each spec an empty database", func() {
+		ctx := context.Background()
+		previous = db.Name()
+
+		_, err := db.Database().Collection("things").InsertOne(ctx, bson.D{{Key: "n", Value: 1}})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("drops the previous spec's database", func() {
+		ctx := context.Background()
+		Expect(db.Name()).NotTo(Equal(previous))
+
+		client, err := mongo.Connect(options.Client().ApplyURI(ginkgoext.CurrentServer().URI()))
+		Expect(err).NotTo(HaveOccurred())
+		defer client.Disconnect(ctx)
+
+		names, err := client.ListDatabaseNames(ctx, bson.D{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names).NotTo(ContainElement(previous))
+	})
+})
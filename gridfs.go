@@ -0,0 +1,158 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// gridFSMetaSuffix is the suffix of the sidecar files holding the metadata of
+// files seeded by SeedGridFSDir
+const gridFSMetaSuffix = ".meta.json"
+
+// SeedGridFS uploads files to the GridFS bucket bucketName in database db,
+// using the map keys as filenames. It returns the IDs of the uploaded files,
+// keyed by filename.
+func (s *Server) SeedGridFS(ctx context.Context, db string, bucketName string, files map[string]io.Reader) (map[string]bson.ObjectID, error) {
+	client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+
+	bucket := client.Database(db).GridFSBucket(options.GridFSBucket().SetName(bucketName))
+
+	// Upload in a stable order, so IDs increase with the filenames
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ids := make(map[string]bson.ObjectID, len(files))
+	for _, name := range names {
+		id, err := uploadGridFSFile(ctx, bucket, name, files[name], nil)
+		if err != nil {
+			return nil, fmt.Errorf("error uploading %s to GridFS bucket %s.%s: %w", name, db, bucketName, err)
+		}
+		ids[name] = id
+	}
+
+	return ids, nil
+}
+
+// SeedGridFSDir uploads the files in a directory laid out as
+// <dir>/<bucket>/<filename> to GridFS buckets in database db, e.g.
+// testdata/gridfs/attachments/invoice.pdf. Filenames in subdirectories of a
+// bucket keep their relative path, with '/' separators. A sidecar file
+// <filename>.meta.json holding a JSON document becomes the file's metadata.
+//
+// It returns the IDs of the uploaded files, keyed by bucket and then
+// filename.
+func (s *Server) SeedGridFSDir(ctx context.Context, db string, dir string) (map[string]map[string]bson.ObjectID, error) {
+	bucketEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading GridFS seed directory: %w", err)
+	}
+
+	client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+
+	ids := map[string]map[string]bson.ObjectID{}
+	for _, bucketEntry := range bucketEntries {
+		if !bucketEntry.IsDir() {
+			continue
+		}
+
+		bucketName := bucketEntry.Name()
+		bucketDir := filepath.Join(dir, bucketName)
+		bucket := client.Database(db).GridFSBucket(options.GridFSBucket().SetName(bucketName))
+		ids[bucketName] = map[string]bson.ObjectID{}
+
+		err := filepath.WalkDir(bucketDir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || strings.HasSuffix(path, gridFSMetaSuffix) {
+				return nil
+			}
+
+			rel, err := filepath.Rel(bucketDir, path)
+			if err != nil {
+				return err
+			}
+			name := filepath.ToSlash(rel)
+
+			metadata, err := readGridFSMetadata(path + gridFSMetaSuffix)
+			if err != nil {
+				return err
+			}
+
+			//nolint:gosec
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			id, err := uploadGridFSFile(ctx, bucket, name, file, metadata)
+			if err != nil {
+				return fmt.Errorf("error uploading %s to GridFS bucket %s.%s: %w", name, db, bucketName, err)
+			}
+			ids[bucketName][name] = id
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ids, nil
+}
+
+func uploadGridFSFile(ctx context.Context, bucket *mongo.GridFSBucket, name string, source io.Reader, metadata bson.D) (bson.ObjectID, error) {
+	uploadOpts := options.GridFSUpload()
+	if metadata != nil {
+		uploadOpts.SetMetadata(metadata)
+	}
+
+	return bucket.UploadFromStream(ctx, name, source, uploadOpts)
+}
+
+// readGridFSMetadata reads a sidecar metadata file, returning nil if there
+// isn't one
+func readGridFSMetadata(path string) (bson.D, error) {
+	//nolint:gosec
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading GridFS metadata: %w", err)
+	}
+
+	var metadata bson.D
+	err = bson.UnmarshalExtJSON(contents, false, &metadata)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	return metadata, nil
+}
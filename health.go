@@ -0,0 +1,153 @@
+package memongo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+)
+
+// healthInfo is the document served at /info
+type healthInfo struct {
+	URI        string `json:"uri"`
+	Version    string `json:"version"`
+	ReplicaSet string `json:"replicaSet"`
+}
+
+// healthServer serves readiness and liveness probes over HTTP, so that
+// processes that don't speak the MongoDB wire protocol (sidecars, compose
+// healthchecks, shell scripts) can tell when the server is usable.
+type healthServer struct {
+	httpServer *http.Server
+
+	mu     sync.Mutex
+	ready  bool
+	exited <-chan struct{}
+	info   healthInfo
+}
+
+// startHealthServer binds addr and starts serving probes in the background.
+// Until setReady is called, /ready reports 503.
+func startHealthServer(addr string, logger *memongolog.Logger) (*healthServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error starting health listener on %s: %w", addr, err)
+	}
+
+	h := &healthServer{}
+	h.httpServer = &http.Server{
+		Handler:           h.handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	goTracked("health listener on "+addr, func() {
+		err := h.httpServer.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			logger.Warnf("health listener on %s failed: %s", addr, err)
+		}
+	})
+
+	logger.Debugf("Serving health probes on %s", listener.Addr().String())
+
+	return h, nil
+}
+
+func (h *healthServer) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		ready := h.ready
+		h.mu.Unlock()
+
+		writeProbe(w, ready)
+	})
+
+	mux.HandleFunc("/live", func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, h.isLive())
+	})
+
+	mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		info := h.info
+		h.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(info)
+	})
+
+	return mux
+}
+
+func writeProbe(w http.ResponseWriter, ok bool) {
+	if ok {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok\n"))
+		return
+	}
+
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = w.Write([]byte("unavailable\n"))
+}
+
+func (h *healthServer) isLive() bool {
+	h.mu.Lock()
+	exited := h.exited
+	h.mu.Unlock()
+
+	if exited == nil {
+		return false
+	}
+
+	select {
+	case <-exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// setProcess reports the mongod process as live until exited is closed
+func (h *healthServer) setProcess(exited <-chan struct{}) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.exited = exited
+}
+
+// setReady marks the server as ready and records what /info serves
+func (h *healthServer) setReady(info healthInfo) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.ready = true
+	h.info = info
+}
+
+// stop marks the server as not ready and shuts down the listener
+func (h *healthServer) stop() {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	h.ready = false
+	h.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = h.httpServer.Shutdown(ctx)
+}
@@ -0,0 +1,80 @@
+package memongo
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func probe(t *testing.T, h *healthServer, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestHealthServerLifecycle(t *testing.T) {
+	h, err := startHealthServer("localhost:0", memongolog.New(nil, memongolog.LogLevelSilent))
+	require.NoError(t, err)
+	defer h.stop()
+
+	// Before the process is started nothing is ready or live
+	assert.Equal(t, http.StatusServiceUnavailable, probe(t, h, "/ready").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, probe(t, h, "/live").Code)
+
+	// Process running, but not ready yet
+	exited := make(chan struct{})
+	h.setProcess(exited)
+	assert.Equal(t, http.StatusServiceUnavailable, probe(t, h, "/ready").Code)
+	assert.Equal(t, http.StatusOK, probe(t, h, "/live").Code)
+
+	h.setReady(healthInfo{
+		URI:        "mongodb://localhost:1234",
+		Version:    "8.0.0",
+		ReplicaSet: "rs0",
+	})
+	assert.Equal(t, http.StatusOK, probe(t, h, "/ready").Code)
+
+	rec := probe(t, h, "/info")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var info map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	assert.Equal(t, map[string]string{
+		"uri":        "mongodb://localhost:1234",
+		"version":    "8.0.0",
+		"replicaSet": "rs0",
+	}, info)
+
+	// Process exits
+	close(exited)
+	assert.Equal(t, http.StatusServiceUnavailable, probe(t, h, "/live").Code)
+
+	// Stopped
+	h.stop()
+	assert.Equal(t, http.StatusServiceUnavailable, probe(t, h, "/ready").Code)
+}
+
+func TestHealthServerAddressInUse(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	_, err = startHealthServer(l.Addr().String(), memongolog.New(nil, memongolog.LogLevelSilent))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error starting health listener")
+}
+
+func TestHealthServerNil(t *testing.T) {
+	var h *healthServer
+
+	// A nil health server is a no-op, so callers don't need to check whether
+	// health probes were enabled
+	h.setProcess(make(chan struct{}))
+	h.setReady(healthInfo{})
+	h.stop()
+}
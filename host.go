@@ -0,0 +1,111 @@
+package memongo
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+)
+
+// listening is what mongod reports once it accepts connections: its port,
+// and the addresses it listens on
+type listening struct {
+	port      int
+	addresses []string
+}
+
+var (
+	reListeningStructured = regexp.MustCompile(`"msg":"Listening on","attr":\{"address":"([^"]+)"`)
+	reListeningLegacy     = regexp.MustCompile(`\] Listening on (\S+)\s*$`)
+)
+
+// parseListeningAddress returns the IP address in line if it's mongod
+// reporting that it listens on one. Unix domain sockets are skipped.
+func parseListeningAddress(line string) (string, bool) {
+	for _, re := range []*regexp.Regexp{reListeningStructured, reListeningLegacy} {
+		if match := re.FindStringSubmatch(line); match != nil {
+			if net.ParseIP(match[1]) == nil {
+				return "", false
+			}
+			return match[1], true
+		}
+	}
+
+	return "", false
+}
+
+// listenHost returns the literal address to connect to a mongod listening
+// on addresses: the IPv4 loopback address if it's among them, then the IPv6
+// one, so that clients don't depend on what localhost resolves to. It falls
+// back on localhost if mongod didn't report its addresses.
+func listenHost(addresses []string) string {
+	var fallback string
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			continue
+		}
+
+		switch {
+		case ip.Equal(net.IPv4(127, 0, 0, 1)) || ip.Equal(net.IPv4zero):
+			return "127.0.0.1"
+		case fallback == "" && (ip.Equal(net.IPv6loopback) || ip.Equal(net.IPv6unspecified)):
+			fallback = "::1"
+		case fallback == "":
+			fallback = address
+		}
+	}
+
+	if fallback == "" {
+		return "localhost"
+	}
+
+	return fallback
+}
+
+// hostPort returns the address clients use to connect to a mongod listening
+// on host and port: host, or localhost if Options.PreferHostname is set or
+// host isn't known
+func (s *Server) hostPort(host string, port int) string {
+	if host == "" || s.opts.PreferHostname {
+		host = "localhost"
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// addr returns the address clients use to connect to the server
+func (s *Server) addr() string {
+	return s.hostPort(s.host, s.port)
+}
+
+// replicaHost returns the address the replica set configuration names the
+// server by
+func (s *Server) replicaHost() string {
+	if s.opts.AdvertisedReplicaHost != "" {
+		return net.JoinHostPort(s.opts.AdvertisedReplicaHost, strconv.Itoa(s.port))
+	}
+
+	return s.addr()
+}
+
+// memberHost returns the address the replica set configuration names a
+// member added with AddReplicaMember by
+func (s *Server) memberHost(proc *mongodProcess) string {
+	if proc.advertisedHost != "" {
+		return net.JoinHostPort(proc.advertisedHost, strconv.Itoa(proc.port))
+	}
+
+	return s.hostPort(proc.host, proc.port)
+}
+
+// Host returns the host in the server's URIs: the literal loopback address
+// mongod listens on, e.g. 127.0.0.1, or localhost with
+// Options.PreferHostname. IPv6 addresses aren't bracketed.
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.addr())
+	return host
+}
+
+func directURI(addr string) string {
+	return mongoURI(addr).param("directConnection", "true").String()
+}
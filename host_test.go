@@ -0,0 +1,85 @@
+package memongo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseListeningAddress(t *testing.T) {
+	tests := map[string]struct {
+		line    string
+		address string
+		ok      bool
+	}{
+		"structured IPv4": {
+			line:    `{"t":{"$date":"2024-05-01T10:00:05.500+00:00"},"s":"I",  "c":"NETWORK",  "id":23015,   "ctx":"listener","msg":"Listening on","attr":{"address":"127.0.0.1"}}`,
+			address: "127.0.0.1",
+			ok:      true,
+		},
+		"structured IPv6": {
+			line:    `{"t":{"$date":"2024-05-01T10:00:05.500+00:00"},"s":"I",  "c":"NETWORK",  "id":23015,   "ctx":"listener","msg":"Listening on","attr":{"address":"::1"}}`,
+			address: "::1",
+			ok:      true,
+		},
+		"structured socket": {
+			line: `{"t":{"$date":"2024-05-01T10:00:05.500+00:00"},"s":"I",  "c":"NETWORK",  "id":23015,   "ctx":"listener","msg":"Listening on","attr":{"address":"/tmp/mongodb-27017.sock"}}`,
+		},
+		"legacy IPv4": {
+			line:    "2019-08-01T10:00:02.900+0000 I  NETWORK  [initandlisten] Listening on 127.0.0.1",
+			address: "127.0.0.1",
+			ok:      true,
+		},
+		"legacy socket": {
+			line: "2019-08-01T10:00:02.900+0000 I  NETWORK  [initandlisten] Listening on /tmp/mongodb-27017.sock",
+		},
+		"other": {
+			line: `{"t":{"$date":"2024-05-01T10:00:06.000+00:00"},"s":"I",  "c":"NETWORK",  "id":23016,   "ctx":"listener","msg":"Waiting for connections","attr":{"port":27017,"ssl":"off"}}`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			address, ok := parseListeningAddress(test.line)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.address, address)
+		})
+	}
+}
+
+func TestListenHost(t *testing.T) {
+	tests := map[string]struct {
+		addresses []string
+		host      string
+	}{
+		"IPv4 only":        {addresses: []string{"127.0.0.1"}, host: "127.0.0.1"},
+		"IPv6 enabled":     {addresses: []string{"127.0.0.1", "::1"}, host: "127.0.0.1"},
+		"IPv6 first":       {addresses: []string{"::1", "127.0.0.1"}, host: "127.0.0.1"},
+		"IPv6 only":        {addresses: []string{"::1"}, host: "::1"},
+		"all IPv4":         {addresses: []string{"0.0.0.0"}, host: "127.0.0.1"},
+		"all IPv6":         {addresses: []string{"::"}, host: "::1"},
+		"specific address": {addresses: []string{"10.0.0.5"}, host: "10.0.0.5"},
+		"not reported":     {host: "localhost"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.host, listenHost(test.addresses))
+		})
+	}
+}
+
+func TestServerURIHost(t *testing.T) {
+	server := &Server{port: 1234, host: "127.0.0.1"}
+	assert.Equal(t, "mongodb://127.0.0.1:1234", server.URI())
+	assert.Equal(t, "mongodb://127.0.0.1:1234/?directConnection=true", server.DirectURI())
+	assert.Equal(t, "127.0.0.1", server.Host())
+
+	server = &Server{port: 1234, host: "::1"}
+	assert.Equal(t, "mongodb://[::1]:1234/?directConnection=true", server.DirectURI())
+	assert.Equal(t, "::1", server.Host())
+
+	server = &Server{port: 1234, host: "127.0.0.1", opts: Options{PreferHostname: true}}
+	assert.Equal(t, "mongodb://localhost:1234", server.URI())
+	assert.Equal(t, "localhost", server.Host())
+}
@@ -0,0 +1,104 @@
+package memongo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/100mslive/memongo/v2/mongobin"
+)
+
+// ImportMeta describes an artifact given to ImportIntoCache
+type ImportMeta struct {
+	// Version is the MongoDB version of the artifact. It picks the cache
+	// entry StartWithOptions uses for the same Options.MongoVersion, and the
+	// binary must report it from mongod --version.
+	Version string
+
+	// DownloadURL, if given, picks the cache entry StartWithOptions uses for
+	// the same DownloadOptions.DownloadURL instead
+	DownloadURL string
+
+	// SHA256, if given, is the hex-encoded checksum the artifact must have
+	SHA256 string
+}
+
+// ImportIntoCache puts the mongod binary from artifact, either a MongoDB
+// archive (.tgz, .tar.zst or .tar.xz) or a mongod binary, in the download
+// cache at cachePath, so starting a server with the same cache path and
+// version or download URL finds it there instead of downloading it. An empty
+// cachePath means the default one.
+//
+// The artifact is checked against meta.SHA256, and the binary must run and
+// report meta.Version. It returns the binary's path in the cache.
+func ImportIntoCache(cachePath string, artifact string, meta ImportMeta) (string, error) {
+	opts := &Options{
+		MongoVersion: meta.Version,
+		DownloadOptions: DownloadOptions{
+			DownloadURL: meta.DownloadURL,
+			CachePath:   cachePath,
+		},
+	}
+	opts.resolveCachePath()
+	err := opts.resolveDownloadURL()
+	if err != nil {
+		return "", err
+	}
+
+	if meta.SHA256 != "" {
+		err := checkSHA256(artifact, meta.SHA256)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	check := func(binPath string) error {
+		version, err := opts.detectBinaryVersion(binPath)
+		if err != nil {
+			return err
+		}
+		if meta.Version != "" && version != meta.Version {
+			return fmt.Errorf("mongod reports version %s, not %s", version, meta.Version)
+		}
+		return nil
+	}
+
+	// Don't race a download of the same URL into the same cache
+	d := opts.DownloadOptions
+	unlock, err := lockDownload(context.Background(), d)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	return mongobin.Import(artifact, d, check, memongolog.New(nil, memongolog.LogLevelSilent))
+}
+
+// checkSHA256 returns an error unless the file at path has the hex-encoded
+// checksum want
+func checkSHA256(path string, want string) error {
+	//nolint:gosec
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	got := hex.EncodeToString(hash.Sum(nil))
+	if got != strings.ToLower(want) {
+		return fmt.Errorf("checksum mismatch for %s: got sha256 %s, want %s", path, got, want)
+	}
+
+	return nil
+}
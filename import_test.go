@@ -0,0 +1,98 @@
+package memongo
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const importURL = "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-ubuntu2204-8.0.0.tgz"
+
+// writeArchive writes a .tgz holding binPath as mongodb-test/bin/mongod
+func writeArchive(t *testing.T, binPath string) string {
+	t.Helper()
+
+	content, err := os.ReadFile(binPath)
+	require.NoError(t, err)
+
+	archivePath := filepath.Join(t.TempDir(), "mongodb-test.tgz")
+	f, err := os.Create(archivePath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "mongodb-test/bin/mongod", Mode: 0755, Size: int64(len(content))}))
+	_, err = tw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	return archivePath
+}
+
+func TestImportIntoCache(t *testing.T) {
+	binPath := writeScript(t, `echo "db version v8.0.0"`)
+
+	for name, artifact := range map[string]string{
+		"binary":  binPath,
+		"archive": writeArchive(t, binPath),
+	} {
+		t.Run(name, func(t *testing.T) {
+			cachePath := t.TempDir()
+			cached, err := ImportIntoCache(cachePath, artifact, ImportMeta{Version: "8.0.0", DownloadURL: importURL})
+			require.NoError(t, err)
+
+			content, err := os.ReadFile(cached)
+			require.NoError(t, err)
+			assert.Contains(t, string(content), "db version v8.0.0")
+
+			// Starting offline finds it in the cache
+			opts := &Options{DownloadURL: importURL, CachePath: cachePath, Offline: true}
+			require.NoError(t, opts.fillDefaults())
+			found, cacheHit, err := opts.getOrDownloadBinPath(context.Background(), nil, memongolog.New(nil, memongolog.LogLevelSilent))
+			require.NoError(t, err)
+			assert.True(t, cacheHit)
+			assert.Equal(t, cached, found)
+		})
+	}
+}
+
+func TestImportIntoCacheRejects(t *testing.T) {
+	binPath := writeScript(t, `echo "db version v7.0.2"`)
+
+	cachePath := t.TempDir()
+	_, err := ImportIntoCache(cachePath, binPath, ImportMeta{Version: "8.0.0", DownloadURL: importURL})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "mongod reports version 7.0.2, not 8.0.0")
+
+	_, err = ImportIntoCache(cachePath, binPath, ImportMeta{Version: "7.0.2", DownloadURL: importURL, SHA256: "0123"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+
+	// Nothing was put in the cache
+	entries, err := os.ReadDir(cachePath)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	content, err := os.ReadFile(binPath)
+	require.NoError(t, err)
+	sum := sha256.Sum256(content)
+	_, err = ImportIntoCache(cachePath, binPath, ImportMeta{Version: "7.0.2", DownloadURL: importURL, SHA256: hex.EncodeToString(sum[:])})
+	require.NoError(t, err)
+}
+
+func TestImportIntoCacheNeedsVersionOrURL(t *testing.T) {
+	t.Setenv("MEMONGO_DOWNLOAD_URL", "")
+	_, err := ImportIntoCache(t.TempDir(), writeScript(t, "true"), ImportMeta{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,372 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// requireIndexesTimeout bounds how long RequireIndexes may take
+const requireIndexesTimeout = 30 * time.Second
+
+// namespaceNotFoundCode is the error code listIndexes fails with for a
+// collection that doesn't exist
+const namespaceNotFoundCode = 26
+
+// IndexSpec describes an index a collection is expected to have
+type IndexSpec struct {
+	// Keys are the indexed fields and their directions or types, in order,
+	// e.g. bson.D{{Key: "email", Value: 1}} or
+	// bson.D{{Key: "body", Value: "text"}}
+	Keys bson.D
+
+	// Name, if given, must match the index's name. Otherwise any name is
+	// accepted.
+	Name string
+
+	Unique bool
+	Sparse bool
+
+	// PartialFilterExpression is the filter of a partial index, or nil
+	PartialFilterExpression interface{}
+
+	// ExpireAfterSeconds makes the index a TTL index. nil means it isn't one.
+	ExpireAfterSeconds *int32
+}
+
+// IndexDiff is one way a collection's indexes differ from what was expected
+type IndexDiff struct {
+	// Namespace is the collection, as "database.collection"
+	Namespace string
+
+	// Kind is "missing" for an expected index that doesn't exist, "extra"
+	// for an index that wasn't expected, or "mismatched" for an index with
+	// the expected keys but different options
+	Kind string
+
+	// Index describes the index's keys and options
+	Index string
+
+	// Detail explains a mismatch
+	Detail string
+}
+
+func (d IndexDiff) String() string {
+	if d.Detail != "" {
+		return fmt.Sprintf("%s: %s index %s: %s", d.Namespace, d.Kind, d.Index, d.Detail)
+	}
+
+	return fmt.Sprintf("%s: %s index %s", d.Namespace, d.Kind, d.Index)
+}
+
+// IndexMismatchError is returned by AssertIndexes when indexes differ from
+// what was expected. It lists every difference.
+type IndexMismatchError struct {
+	Diffs []IndexDiff
+}
+
+func (e *IndexMismatchError) Error() string {
+	lines := make([]string, len(e.Diffs))
+	for i, d := range e.Diffs {
+		lines[i] = d.String()
+	}
+
+	return fmt.Sprintf("%d index differences:\n%s", len(e.Diffs), strings.Join(lines, "\n"))
+}
+
+// AssertIndexes compares the indexes of each collection, keyed by
+// "database.collection", with the expected ones, and returns an
+// *IndexMismatchError listing the missing, extra and mismatched indexes if
+// they differ. The order of indexes doesn't matter, and only the options
+// that change behavior are compared: unique, sparse, the partial filter and
+// the TTL. The _id index is ignored unless it's expected.
+func (s *Server) AssertIndexes(ctx context.Context, expected map[string][]IndexSpec) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+
+	namespaces := make([]string, 0, len(expected))
+	for ns := range expected {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	var diffs []IndexDiff
+	for _, ns := range namespaces {
+		actual, err := listIndexSpecs(ctx, client, ns)
+		if err != nil {
+			return err
+		}
+
+		diffs = append(diffs, diffIndexes(ns, expected[ns], actual)...)
+	}
+
+	if len(diffs) > 0 {
+		return &IndexMismatchError{Diffs: diffs}
+	}
+
+	return nil
+}
+
+// RequireIndexes fails the test unless AssertIndexes passes
+func (s *Server) RequireIndexes(tb testing.TB, expected map[string][]IndexSpec) {
+	tb.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requireIndexesTimeout)
+	defer cancel()
+
+	err := s.AssertIndexes(ctx, expected)
+	if err != nil {
+		tb.Fatalf("%s", err)
+	}
+}
+
+// listIndexSpecs returns the indexes of the collection ns, or none if it
+// doesn't exist
+func listIndexSpecs(ctx context.Context, client *mongo.Client, ns string) ([]IndexSpec, error) {
+	parts := strings.SplitN(ns, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid namespace %q: must be database.collection", ns)
+	}
+
+	cursor, err := client.Database(parts[0]).Collection(parts[1]).Indexes().List(ctx)
+	if err != nil {
+		if hasErrorCode(err, []int{namespaceNotFoundCode}) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error listing indexes of %s: %w", ns, err)
+	}
+
+	var docs []bson.Raw
+	err = cursor.All(ctx, &docs)
+	if err != nil {
+		return nil, fmt.Errorf("error listing indexes of %s: %w", ns, err)
+	}
+
+	specs := make([]IndexSpec, len(docs))
+	for i, doc := range docs {
+		specs[i], err = parseIndexSpec(doc)
+		if err != nil {
+			return nil, fmt.Errorf("error reading index of %s: %w", ns, err)
+		}
+	}
+
+	return specs, nil
+}
+
+// parseIndexSpec reads an index document as listIndexes reports it
+func parseIndexSpec(doc bson.Raw) (IndexSpec, error) {
+	var index struct {
+		Key                     bson.D      `bson:"key"`
+		Name                    string      `bson:"name"`
+		Unique                  bool        `bson:"unique"`
+		Sparse                  bool        `bson:"sparse"`
+		PartialFilterExpression interface{} `bson:"partialFilterExpression"`
+		ExpireAfterSeconds      *float64    `bson:"expireAfterSeconds"`
+		Weights                 bson.D      `bson:"weights"`
+	}
+	err := bson.Unmarshal(doc, &index)
+	if err != nil {
+		return IndexSpec{}, err
+	}
+
+	spec := IndexSpec{
+		Keys:                    textIndexKeys(index.Key, index.Weights),
+		Name:                    index.Name,
+		Unique:                  index.Unique,
+		Sparse:                  index.Sparse,
+		PartialFilterExpression: index.PartialFilterExpression,
+	}
+	if index.ExpireAfterSeconds != nil {
+		seconds := int32(*index.ExpireAfterSeconds)
+		spec.ExpireAfterSeconds = &seconds
+	}
+
+	return spec, nil
+}
+
+// textIndexKeys returns the keys of a text index as they're written when
+// creating it, e.g. {body: "text"}, rather than as the server reports them,
+// {_fts: "text", _ftsx: 1}. Other keys are returned as is.
+func textIndexKeys(keys bson.D, weights bson.D) bson.D {
+	normalized := make(bson.D, 0, len(keys))
+	for _, key := range keys {
+		switch key.Key {
+		case "_fts":
+			// The text fields are in weights, sorted by name
+			for _, weight := range weights {
+				normalized = append(normalized, bson.E{Key: weight.Key, Value: "text"})
+			}
+		case "_ftsx":
+		default:
+			normalized = append(normalized, key)
+		}
+	}
+
+	return normalized
+}
+
+// diffIndexes compares the indexes of the collection ns with the expected
+// ones
+func diffIndexes(ns string, expected []IndexSpec, actual []IndexSpec) []IndexDiff {
+	var diffs []IndexDiff
+	used := make([]bool, len(actual))
+
+	for _, want := range expected {
+		match := -1
+		for i, have := range actual {
+			if !used[i] && sameKeys(want.Keys, have.Keys) && (want.Name == "" || want.Name == have.Name) {
+				match = i
+				break
+			}
+		}
+		if match == -1 {
+			diffs = append(diffs, IndexDiff{Namespace: ns, Kind: "missing", Index: describeIndex(want)})
+			continue
+		}
+		used[match] = true
+
+		problems := indexOptionDiffs(want, actual[match])
+		if len(problems) > 0 {
+			diffs = append(diffs, IndexDiff{
+				Namespace: ns,
+				Kind:      "mismatched",
+				Index:     describeIndex(actual[match]),
+				Detail:    strings.Join(problems, "; "),
+			})
+		}
+	}
+
+	for i, have := range actual {
+		if used[i] || have.Name == "_id_" {
+			continue
+		}
+		diffs = append(diffs, IndexDiff{Namespace: ns, Kind: "extra", Index: describeIndex(have)})
+	}
+
+	return diffs
+}
+
+func indexOptionDiffs(want IndexSpec, have IndexSpec) []string {
+	var problems []string
+	if want.Unique != have.Unique {
+		problems = append(problems, fmt.Sprintf("expected unique %t, got %t", want.Unique, have.Unique))
+	}
+	if want.Sparse != have.Sparse {
+		problems = append(problems, fmt.Sprintf("expected sparse %t, got %t", want.Sparse, have.Sparse))
+	}
+	if !reflect.DeepEqual(normalizeIndexValue(want.PartialFilterExpression), normalizeIndexValue(have.PartialFilterExpression)) {
+		problems = append(problems, fmt.Sprintf("expected partial filter %s, got %s", formatIndexValue(want.PartialFilterExpression), formatIndexValue(have.PartialFilterExpression)))
+	}
+	if ttl(want) != ttl(have) {
+		problems = append(problems, fmt.Sprintf("expected TTL %s, got %s", ttl(want), ttl(have)))
+	}
+
+	return problems
+}
+
+func ttl(spec IndexSpec) string {
+	if spec.ExpireAfterSeconds == nil {
+		return "none"
+	}
+
+	return fmt.Sprintf("%ds", *spec.ExpireAfterSeconds)
+}
+
+func sameKeys(a bson.D, b bson.D) bool {
+	return reflect.DeepEqual(normalizeIndexValue(a), normalizeIndexValue(b))
+}
+
+// normalizeIndexValue converts a value to a form that compares equal with
+// reflect.DeepEqual however it was written: documents become bson.D, and
+// numbers become float64, since the server may report 1 as an int32, int64
+// or double depending on how the index was created
+func normalizeIndexValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case nil:
+		return nil
+	case int:
+		return float64(value)
+	case int32:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case float32:
+		return float64(value)
+	case bson.D:
+		normalized := make(bson.D, len(value))
+		for i, e := range value {
+			normalized[i] = bson.E{Key: e.Key, Value: normalizeIndexValue(e.Value)}
+		}
+		return normalized
+	case bson.A:
+		normalized := make(bson.A, len(value))
+		for i, e := range value {
+			normalized[i] = normalizeIndexValue(e)
+		}
+		return normalized
+	default:
+		// Maps and structs are converted through BSON. Use bson.D for
+		// documents with more than one field, since map order is random.
+		raw, err := bson.Marshal(value)
+		if err != nil {
+			return value
+		}
+		var doc bson.D
+		if bson.Unmarshal(raw, &doc) != nil {
+			return value
+		}
+		return normalizeIndexValue(doc)
+	}
+}
+
+// describeIndex formats an index like {email: 1} unique
+func describeIndex(spec IndexSpec) string {
+	keys := make([]string, len(spec.Keys))
+	for i, key := range spec.Keys {
+		keys[i] = fmt.Sprintf("%s: %v", key.Key, key.Value)
+	}
+
+	desc := "{" + strings.Join(keys, ", ") + "}"
+	if spec.Name != "" {
+		desc += " " + spec.Name
+	}
+	if spec.Unique {
+		desc += " unique"
+	}
+	if spec.Sparse {
+		desc += " sparse"
+	}
+	if spec.PartialFilterExpression != nil {
+		desc += " partial " + formatIndexValue(spec.PartialFilterExpression)
+	}
+	if spec.ExpireAfterSeconds != nil {
+		desc += " ttl " + ttl(spec)
+	}
+
+	return desc
+}
+
+func formatIndexValue(v interface{}) string {
+	if v == nil {
+		return "none"
+	}
+
+	out, err := bson.MarshalExtJSON(normalizeIndexValue(v), false, false)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+
+	return string(out)
+}
@@ -0,0 +1,73 @@
+package memongo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestParseIndexSpec(t *testing.T) {
+	spec, err := parseIndexSpec(mustMarshal(t, bson.D{
+		{Key: "v", Value: int32(2)},
+		{Key: "key", Value: bson.D{{Key: "createdAt", Value: int32(1)}}},
+		{Key: "name", Value: "createdAt_1"},
+		{Key: "expireAfterSeconds", Value: 3600.0},
+		{Key: "partialFilterExpression", Value: bson.D{{Key: "archived", Value: true}}},
+	}))
+	require.NoError(t, err)
+	ttl := int32(3600)
+	assert.Equal(t, IndexSpec{
+		Keys:                    bson.D{{Key: "createdAt", Value: int32(1)}},
+		Name:                    "createdAt_1",
+		PartialFilterExpression: bson.D{{Key: "archived", Value: true}},
+		ExpireAfterSeconds:      &ttl,
+	}, spec)
+
+	// Text indexes are reported with internal keys
+	spec, err = parseIndexSpec(mustMarshal(t, bson.D{
+		{Key: "v", Value: int32(2)},
+		{Key: "key", Value: bson.D{{Key: "author", Value: int32(1)}, {Key: "_fts", Value: "text"}, {Key: "_ftsx", Value: int32(1)}}},
+		{Key: "name", Value: "author_1_body_text_title_text"},
+		{Key: "weights", Value: bson.D{{Key: "body", Value: int32(1)}, {Key: "title", Value: int32(1)}}},
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, bson.D{{Key: "author", Value: int32(1)}, {Key: "body", Value: "text"}, {Key: "title", Value: "text"}}, spec.Keys)
+}
+
+func TestDiffIndexes(t *testing.T) {
+	ttl := int32(60)
+	actual := []IndexSpec{
+		{Keys: bson.D{{Key: "_id", Value: int32(1)}}, Name: "_id_"},
+		{Keys: bson.D{{Key: "email", Value: int32(1)}}, Name: "email_1", Unique: true},
+		{Keys: bson.D{{Key: "createdAt", Value: int64(-1)}}, Name: "createdAt_-1", ExpireAfterSeconds: &ttl},
+		{Keys: bson.D{{Key: "tenant", Value: 1.0}, {Key: "status", Value: 1.0}}, Name: "tenant_1_status_1", PartialFilterExpression: bson.D{{Key: "status", Value: bson.D{{Key: "$exists", Value: true}}}}},
+		{Keys: bson.D{{Key: "legacy", Value: int32(1)}}, Name: "legacy_1"},
+	}
+
+	// Order, number types and the _id index don't matter
+	assert.Empty(t, diffIndexes("app.users", []IndexSpec{
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "status", Value: 1}}, PartialFilterExpression: bson.M{"status": bson.M{"$exists": true}}},
+		{Keys: bson.D{{Key: "legacy", Value: 1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: -1}}, ExpireAfterSeconds: &ttl},
+		{Keys: bson.D{{Key: "email", Value: 1}}, Unique: true, Name: "email_1"},
+	}, actual))
+
+	diffs := diffIndexes("app.users", []IndexSpec{
+		{Keys: bson.D{{Key: "email", Value: 1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "tenant", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "status", Value: 1}}, PartialFilterExpression: bson.D{{Key: "status", Value: "active"}}},
+	}, actual)
+	assert.Equal(t, []IndexDiff{
+		{Namespace: "app.users", Kind: "mismatched", Index: "{email: 1} email_1 unique", Detail: "expected unique false, got true"},
+		{Namespace: "app.users", Kind: "mismatched", Index: "{createdAt: -1} createdAt_-1 ttl 60s", Detail: "expected TTL none, got 60s"},
+		{Namespace: "app.users", Kind: "missing", Index: "{status: 1, tenant: 1}"},
+		{Namespace: "app.users", Kind: "mismatched", Index: `{tenant: 1, status: 1} tenant_1_status_1 partial {"status":{"$exists":true}}`, Detail: `expected partial filter {"status":"active"}, got {"status":{"$exists":true}}`},
+		{Namespace: "app.users", Kind: "extra", Index: "{legacy: 1} legacy_1"},
+	}, diffs)
+
+	err := &IndexMismatchError{Diffs: diffs[2:3]}
+	assert.EqualError(t, err, "1 index differences:\napp.users: missing index {status: 1, tenant: 1}")
+}
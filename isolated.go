@@ -0,0 +1,108 @@
+package memongo
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// maxDatabaseNameLen is the longest database name the server accepts
+const maxDatabaseNameLen = 63
+
+// isolatedCleanupTimeout bounds how long dropping an isolated database may
+// take when the test finishes
+const isolatedCleanupTimeout = 30 * time.Second
+
+// IsolatedDatabase returns a database that only the calling test uses, on a
+// server shared with other tests, e.g. to construct an HTTP handler under
+// test. The database is named after the test, with a random suffix, so
+// leftovers can be traced back to it, and it's dropped when the test
+// finishes. It's safe to call from parallel tests.
+//
+// The test is failed if the server can't be reached.
+func IsolatedDatabase(tb testing.TB, server *Server) *mongo.Database {
+	tb.Helper()
+
+	ctx := context.Background()
+	client, err := server.Client(ctx)
+	if err != nil {
+		tb.Fatalf("error connecting to MongoDB: %s", err)
+	}
+
+	name := isolatedDatabaseName(tb.Name())
+	tb.Cleanup(func() {
+		dropIsolatedDatabase(tb, client, name)
+	})
+
+	return client.Database(name)
+}
+
+// NewDatabase is IsolatedDatabase on the server, returning the database's
+// name along with it
+func (s *Server) NewDatabase(tb testing.TB) (*mongo.Database, string) {
+	tb.Helper()
+
+	db := IsolatedDatabase(tb, s)
+	return db, db.Name()
+}
+
+// IsolatedClient is like IsolatedDatabase, but returns a client of its own
+// whose connection string names the database, for code that takes the
+// default database from its client's URI, along with the database's name.
+// The database is dropped and the client disconnected, ending any sessions,
+// when the test finishes.
+func IsolatedClient(tb testing.TB, server *Server) (*mongo.Client, string) {
+	tb.Helper()
+
+	name := isolatedDatabaseName(tb.Name())
+	uri := mongoURI(server.addr()).db(name).param("directConnection", "true").String()
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		tb.Fatalf("error connecting to MongoDB: %s", err)
+	}
+
+	tb.Cleanup(func() {
+		dropIsolatedDatabase(tb, client, name)
+
+		err := client.Disconnect(context.Background())
+		if err != nil {
+			tb.Errorf("error disconnecting from MongoDB: %s", err)
+		}
+	})
+
+	return client, name
+}
+
+func dropIsolatedDatabase(tb testing.TB, client *mongo.Client, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), isolatedCleanupTimeout)
+	defer cancel()
+
+	err := client.Database(name).Drop(ctx)
+	if err != nil {
+		tb.Errorf("error dropping isolated database %s: %s", name, err)
+	}
+}
+
+// isolatedDatabaseName returns a unique database name starting with the test
+// name, with the characters database names can't contain replaced
+func isolatedDatabaseName(testName string) string {
+	prefix := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, testName)
+
+	// Leave room for the separator and the random suffix
+	if maxPrefix := maxDatabaseNameLen - 1 - DBNameLen; len(prefix) > maxPrefix {
+		prefix = prefix[:maxPrefix]
+	}
+
+	return prefix + "_" + RandomDatabase()
+}
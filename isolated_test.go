@@ -0,0 +1,29 @@
+package memongo
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsolatedDatabaseName(t *testing.T) {
+	name := isolatedDatabaseName("TestHandler/creates a user.json")
+	assert.True(t, strings.HasPrefix(name, "TestHandler_creates_a_user_json_"), name)
+	assert.Len(t, name, len("TestHandler_creates_a_user_json_")+DBNameLen)
+
+	assert.NotEqual(t, name, isolatedDatabaseName("TestHandler/creates a user.json"))
+
+	long := isolatedDatabaseName(strings.Repeat("TestVeryLongName/", 10))
+	assert.Len(t, long, maxDatabaseNameLen)
+
+	// None of the characters database names can't contain get through
+	name = isolatedDatabaseName("Test/a.b c$d\\e\"f*g<h>i:j|k?l\x00m")
+	assert.True(t, strings.HasPrefix(name, "Test_a_b_c_d_e_f_g_h_i_j_k_l_m_"), name)
+
+	names := map[string]bool{}
+	for i := 0; i < 10000; i++ {
+		names[isolatedDatabaseName("TestParallel")] = true
+	}
+	assert.Len(t, names, 10000)
+}
@@ -0,0 +1,158 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/100mslive/memongo/v2/retry"
+)
+
+// leakCheckTimeout is how long VerifyNoLeaks waits for goroutines to finish
+// and files to be closed before reporting them. It's a variable so tests can
+// shorten it.
+var leakCheckTimeout = 5 * time.Second
+
+// errLeaked is returned by VerifyNoLeaks's checks while resources are left
+var errLeaked = errors.New("memongo resources are still in use")
+
+// resourceRegistry records the goroutines, files and temporary paths memongo
+// has open, so VerifyNoLeaks can report the ones still around after every
+// server was stopped
+type resourceRegistry struct {
+	mu   sync.Mutex
+	next int
+
+	// live maps a key identifying each resource to its description
+	live map[string]string
+}
+
+var resources = &resourceRegistry{live: map[string]string{}}
+
+func (r *resourceRegistry) track(key string, description string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.live[key] = description
+}
+
+func (r *resourceRegistry) untrack(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.live, key)
+}
+
+// goroutine registers a goroutine, and returns the function it must call
+// when it returns
+func (r *resourceRegistry) goroutine(label string) func() {
+	r.mu.Lock()
+	r.next++
+	key := fmt.Sprintf("goroutine %d", r.next)
+	r.mu.Unlock()
+
+	r.track(key, "goroutine: "+label)
+	return func() {
+		r.untrack(key)
+	}
+}
+
+// snapshot returns the descriptions of the live resources, sorted. Paths
+// that have been removed by someone else aren't included.
+func (r *resourceRegistry) snapshot() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var live []string
+	for key, description := range r.live {
+		if path := strings.TrimPrefix(key, "path "); path != key {
+			if _, err := os.Lstat(path); os.IsNotExist(err) {
+				continue
+			}
+		}
+		live = append(live, description)
+	}
+	sort.Strings(live)
+
+	return live
+}
+
+// goTracked runs f in a goroutine that VerifyNoLeaks reports until f returns
+func goTracked(label string, f func()) {
+	done := resources.goroutine(label)
+	go func() {
+		defer done()
+		f()
+	}()
+}
+
+// trackPath records a temporary file or directory memongo created, until
+// removePath removes it or untrackPath hands it over to the user
+func trackPath(path string, what string) {
+	resources.track("path "+path, what+": "+path)
+}
+
+func untrackPath(path string) {
+	resources.untrack("path " + path)
+}
+
+// removePath removes a path recorded with trackPath
+func removePath(path string) error {
+	err := os.RemoveAll(path)
+	if err == nil {
+		untrackPath(path)
+	}
+
+	return err
+}
+
+// mkdirTemp is os.MkdirTemp, recording the directory with trackPath
+func mkdirTemp(dir string, pattern string, what string) (string, error) {
+	path, err := os.MkdirTemp(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	trackPath(path, what)
+
+	return path, nil
+}
+
+// trackFile records a file memongo holds open, until untrackFile
+func trackFile(file *os.File, what string) {
+	resources.track(fmt.Sprintf("file %p", file), what+": "+file.Name())
+}
+
+func untrackFile(file *os.File) {
+	resources.untrack(fmt.Sprintf("file %p", file))
+}
+
+// VerifyNoLeaks fails tb if memongo still has goroutines running, files open
+// or temporary files and directories left behind. Call it once every server
+// has been stopped, for example deferred at the start of a test or at the
+// end of a package's tests. Goroutines get a few seconds to finish, since
+// some only return once mongod has exited.
+//
+// Data directories kept with Options.Cleanup aren't reported.
+func VerifyNoLeaks(tb testing.TB) {
+	tb.Helper()
+
+	var live []string
+	policy := retry.MaxElapsed{Policy: retry.Constant{Interval: 10 * time.Millisecond}, Limit: leakCheckTimeout}
+	_ = retry.Do(context.Background(), policy, func() error {
+		live = resources.snapshot()
+		if len(live) > 0 {
+			return errLeaked
+		}
+		return nil
+	})
+
+	if len(live) > 0 {
+		tb.Errorf("memongo leaked %d resources:\n  %s", len(live), strings.Join(live, "\n  "))
+	}
+}
@@ -0,0 +1,117 @@
+package memongo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// leakRecorder is a testing.TB that records the errors VerifyNoLeaks reports
+type leakRecorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *leakRecorder) Helper() {}
+
+func (r *leakRecorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func shortLeakCheck(t *testing.T) {
+	t.Helper()
+
+	previous := leakCheckTimeout
+	leakCheckTimeout = 100 * time.Millisecond
+	t.Cleanup(func() {
+		leakCheckTimeout = previous
+	})
+}
+
+func TestVerifyNoLeaksGoroutine(t *testing.T) {
+	shortLeakCheck(t)
+
+	release := make(chan struct{})
+	goTracked("test worker", func() {
+		<-release
+	})
+
+	recorder := &leakRecorder{TB: t}
+	VerifyNoLeaks(recorder)
+	require.Len(t, recorder.errors, 1)
+	assert.Contains(t, recorder.errors[0], "memongo leaked 1 resources:\n  goroutine: test worker")
+
+	// A goroutine that finishes while VerifyNoLeaks waits isn't reported
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	VerifyNoLeaks(t)
+}
+
+func TestVerifyNoLeaksPaths(t *testing.T) {
+	shortLeakCheck(t)
+
+	dir, err := mkdirTemp(t.TempDir(), "memongo", "data directory")
+	require.NoError(t, err)
+
+	recorder := &leakRecorder{TB: t}
+	VerifyNoLeaks(recorder)
+	require.Len(t, recorder.errors, 1)
+	assert.Contains(t, recorder.errors[0], "data directory: "+dir)
+
+	require.NoError(t, removePath(dir))
+	VerifyNoLeaks(t)
+
+	// Paths removed by someone else aren't leaks
+	file := filepath.Join(t.TempDir(), "keyfile")
+	require.NoError(t, os.WriteFile(file, nil, 0600))
+	trackPath(file, "keyfile")
+	require.NoError(t, os.Remove(file))
+	VerifyNoLeaks(t)
+	untrackPath(file)
+}
+
+func TestVerifyNoLeaksFiles(t *testing.T) {
+	shortLeakCheck(t)
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "recording"))
+	require.NoError(t, err)
+	trackFile(file, "command recording")
+
+	recorder := &leakRecorder{TB: t}
+	VerifyNoLeaks(recorder)
+	require.Len(t, recorder.errors, 1)
+	assert.Contains(t, recorder.errors[0], "command recording: "+file.Name())
+
+	require.NoError(t, file.Close())
+	untrackFile(file)
+	VerifyNoLeaks(t)
+}
+
+func TestOutputHandlersStopWhenClosed(t *testing.T) {
+	shortLeakCheck(t)
+
+	logger := memongolog.New(nil, memongolog.LogLevelSilent)
+	stdout, errCh, _, _, _ := stdoutHandler(logger, reReady)
+	stderr := stderrHandler(logger)
+
+	recorder := &leakRecorder{TB: t}
+	VerifyNoLeaks(recorder)
+	require.Len(t, recorder.errors, 1)
+	assert.Contains(t, recorder.errors[0], "goroutine: mongod stderr handler")
+	assert.Contains(t, recorder.errors[0], "goroutine: mongod stdout handler")
+
+	// Nobody reads errCh, but closing the output still lets the handler
+	// finish
+	require.NoError(t, stdout.Close())
+	require.NoError(t, stderr.Close())
+	VerifyNoLeaks(t)
+	assert.EqualError(t, <-errCh, "mongod exited before startup completed")
+}
@@ -0,0 +1,110 @@
+package memongo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// startLimiter bounds how many mongod processes start at once. Starts wait
+// for a slot in the order they asked for one.
+type startLimiter struct {
+	mu sync.Mutex
+
+	// max is the limit for starts that don't set their own, 0 for none
+	max     int
+	active  int
+	waiters []*startWaiter
+}
+
+type startWaiter struct {
+	// limit is the start's own limit, 0 to use the limiter's
+	limit int
+	ready chan struct{}
+}
+
+// starts is the limiter of every start in this process
+var starts = &startLimiter{}
+
+// SetMaxConcurrentStarts limits how many mongod processes may be starting
+// at once in this process, from being spawned until they accept
+// connections. Other starts wait for a slot, in the order they asked for
+// one. n <= 0 removes the limit, which is the default.
+// Options.StartConcurrency overrides it for a single server.
+func SetMaxConcurrentStarts(n int) {
+	if n < 0 {
+		n = 0
+	}
+
+	starts.mu.Lock()
+	defer starts.mu.Unlock()
+
+	starts.max = n
+	starts.grantLocked()
+}
+
+// acquire waits for a slot under limit, or the limiter's own limit if limit
+// is 0, and returns how long it waited. The slot must be given back with
+// release.
+func (l *startLimiter) acquire(ctx context.Context, limit int) (time.Duration, error) {
+	queued := time.Now()
+
+	l.mu.Lock()
+	w := &startWaiter{limit: limit, ready: make(chan struct{})}
+	l.waiters = append(l.waiters, w)
+	l.grantLocked()
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return time.Since(queued), nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	select {
+	case <-w.ready:
+		// The slot was granted as ctx was done
+		l.active--
+	default:
+		for i, waiter := range l.waiters {
+			if waiter == w {
+				l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+	// The next waiter may fit now
+	l.grantLocked()
+	l.mu.Unlock()
+
+	return time.Since(queued), ctx.Err()
+}
+
+// release gives back a slot taken by acquire
+func (l *startLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+	l.grantLocked()
+}
+
+// grantLocked gives slots to waiters from the front of the queue, for as
+// long as they fit
+func (l *startLimiter) grantLocked() {
+	for len(l.waiters) > 0 {
+		w := l.waiters[0]
+		limit := w.limit
+		if limit == 0 {
+			limit = l.max
+		}
+		if limit > 0 && l.active >= limit {
+			return
+		}
+
+		l.active++
+		l.waiters = l.waiters[1:]
+		close(w.ready)
+	}
+}
@@ -0,0 +1,183 @@
+package memongo
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitForWaiters waits until n starts are queued in l
+func waitForWaiters(t *testing.T, l *startLimiter, n int) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return len(l.waiters) == n
+	}, time.Second, time.Millisecond)
+}
+
+func TestStartLimiterFIFO(t *testing.T) {
+	l := &startLimiter{max: 1}
+	ctx := context.Background()
+
+	queued, err := l.acquire(ctx, 0)
+	require.NoError(t, err)
+	assert.Zero(t, queued.Round(time.Millisecond))
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := l.acquire(ctx, 0)
+			assert.NoError(t, err)
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			l.release()
+		}(i)
+		waitForWaiters(t, l, i+1)
+	}
+
+	l.release()
+	wg.Wait()
+
+	// With one slot, starts are granted one at a time, in order
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, order)
+	assert.Zero(t, l.active)
+}
+
+func TestStartLimiterContext(t *testing.T) {
+	l := &startLimiter{max: 1}
+	_, err := l.acquire(context.Background(), 0)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	queued, err := l.acquire(ctx, 0)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.GreaterOrEqual(t, queued, 50*time.Millisecond)
+	waitForWaiters(t, l, 0)
+
+	l.release()
+	_, err = l.acquire(context.Background(), 0)
+	require.NoError(t, err)
+	l.release()
+	assert.Zero(t, l.active)
+}
+
+func TestStartLimiterOwnLimit(t *testing.T) {
+	l := &startLimiter{}
+
+	// Unlimited starts still count toward a start with its own limit
+	for i := 0; i < 2; i++ {
+		_, err := l.acquire(context.Background(), 0)
+		require.NoError(t, err)
+	}
+
+	granted := make(chan struct{})
+	go func() {
+		_, err := l.acquire(context.Background(), 2)
+		assert.NoError(t, err)
+		close(granted)
+	}()
+	waitForWaiters(t, l, 1)
+
+	l.release()
+	<-granted
+	l.release()
+	l.release()
+	assert.Zero(t, l.active)
+}
+
+func TestStartLimiterStress(t *testing.T) {
+	l := &startLimiter{max: 3}
+
+	var running, peak int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			ctx := context.Background()
+			if i%5 == 0 {
+				// Some starts give up waiting
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, time.Millisecond)
+				defer cancel()
+			}
+			_, err := l.acquire(ctx, 0)
+			if err != nil {
+				return
+			}
+			defer l.release()
+
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("starts deadlocked")
+	}
+
+	assert.LessOrEqual(t, peak, int32(3))
+	assert.Zero(t, l.active)
+	assert.Empty(t, l.waiters)
+}
+
+func TestMaxConcurrentStartsFailures(t *testing.T) {
+	SetMaxConcurrentStarts(1)
+	defer SetMaxConcurrentStarts(0)
+
+	// Failed starts give their slot back
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := StartWithOptions(&Options{
+				MongodBin:      "/bin/false",
+				StartupTimeout: 100 * time.Millisecond,
+				LogLevel:       memongolog.LogLevelSilent,
+			})
+			assert.Error(t, err)
+		}()
+	}
+	wg.Wait()
+
+	starts.mu.Lock()
+	defer starts.mu.Unlock()
+	assert.Zero(t, starts.active)
+	assert.Empty(t, starts.waiters)
+}
+
+func TestValidateStartConcurrency(t *testing.T) {
+	assert.Error(t, (&Options{MongodBin: "/bin/mongod", StartConcurrency: -1}).Validate())
+	assert.NoError(t, (&Options{MongodBin: "/bin/mongod", StartConcurrency: 2}).Validate())
+}
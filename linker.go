@@ -0,0 +1,98 @@
+package memongo
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// mongodCommandLine returns the program and arguments that run the mongod at
+// binPath with args, through DynamicLinkerPath if it's set
+func (opts *Options) mongodCommandLine(binPath string, args ...string) (string, []string) {
+	if opts.DynamicLinkerPath == "" {
+		return binPath, args
+	}
+
+	return opts.DynamicLinkerPath, append([]string{binPath}, args...)
+}
+
+// checkDynamicLinker returns an error wrapping ErrMissingDynamicLinker if
+// binPath is a dynamically linked ELF binary whose interpreter doesn't
+// exist, as is the case for the official mongod builds on NixOS. Binaries
+// that aren't ELF, or are statically linked, pass.
+func checkDynamicLinker(binPath string) error {
+	interpreter, err := elfInterpreter(binPath)
+	if err != nil || interpreter == "" {
+		// Not something we can check; running it will tell
+		return nil
+	}
+
+	_, err = os.Stat(interpreter)
+	if os.IsNotExist(err) {
+		return missingDynamicLinkerError(binPath, interpreter)
+	}
+
+	return nil
+}
+
+// elfInterpreter returns the program interpreter (dynamic linker) of an ELF
+// binary, or "" if it's statically linked
+func elfInterpreter(binPath string) (string, error) {
+	f, err := elf.Open(binPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+
+		buf := make([]byte, prog.Filesz)
+		_, err := prog.ReadAt(buf, 0)
+		if err != nil {
+			return "", fmt.Errorf("error reading ELF interpreter: %w", err)
+		}
+
+		// The path is NUL-terminated
+		for i, b := range buf {
+			if b == 0 {
+				buf = buf[:i]
+				break
+			}
+		}
+
+		return string(buf), nil
+	}
+
+	return "", nil
+}
+
+// startError explains an error starting the mongod at binPath. Exec fails
+// with ENOENT if the binary's interpreter is missing, which is confusing when
+// the binary itself is there.
+func startError(binPath string, err error) error {
+	if runtime.GOOS != "linux" || !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if _, statErr := os.Stat(binPath); statErr != nil {
+		return err
+	}
+
+	interpreter, _ := elfInterpreter(binPath)
+	return missingDynamicLinkerError(binPath, interpreter)
+}
+
+func missingDynamicLinkerError(binPath string, interpreter string) error {
+	if interpreter == "" {
+		interpreter = "its dynamic linker"
+	}
+
+	return fmt.Errorf("%w: %s needs %s, which doesn't exist on this system (e.g. NixOS). "+
+		"Set MongodBin or MEMONGO_MONGOD_BIN to a mongod built for this system, "+
+		"or DynamicLinkerPath or MEMONGO_DYNAMIC_LINKER to a compatible dynamic linker",
+		ErrMissingDynamicLinker, binPath, interpreter)
+}
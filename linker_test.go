@@ -0,0 +1,62 @@
+package memongo
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestElfInterpreter(t *testing.T) {
+	interpreter, err := elfInterpreter(filepath.Join("testdata", "elf", "missing-interpreter"))
+	require.NoError(t, err)
+	assert.Equal(t, "/lib64/ld-memongo-missing.so.2", interpreter)
+
+	interpreter, err = elfInterpreter(filepath.Join("testdata", "elf", "static"))
+	require.NoError(t, err)
+	assert.Equal(t, "", interpreter)
+
+	_, err = elfInterpreter(filepath.Join("testdata", "seed", "app", "users.json"))
+	assert.Error(t, err)
+}
+
+func TestCheckDynamicLinker(t *testing.T) {
+	err := checkDynamicLinker(filepath.Join("testdata", "elf", "missing-interpreter"))
+	require.ErrorIs(t, err, ErrMissingDynamicLinker)
+	assert.Contains(t, err.Error(), "/lib64/ld-memongo-missing.so.2")
+	assert.Contains(t, err.Error(), "MEMONGO_MONGOD_BIN")
+
+	assert.NoError(t, checkDynamicLinker(filepath.Join("testdata", "elf", "static")))
+
+	// Not an ELF binary
+	assert.NoError(t, checkDynamicLinker(filepath.Join("testdata", "seed", "app", "users.json")))
+}
+
+func TestStartError(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("only Linux reports a missing interpreter as ENOENT")
+	}
+
+	execErr := &os.PathError{Op: "fork/exec", Path: "mongod", Err: syscall.ENOENT}
+
+	err := startError(filepath.Join("testdata", "elf", "missing-interpreter"), execErr)
+	assert.ErrorIs(t, err, ErrMissingDynamicLinker)
+
+	// The binary really is missing
+	err = startError(filepath.Join("testdata", "elf", "missing"), execErr)
+	assert.Equal(t, execErr, err)
+}
+
+func TestMongodCommandLine(t *testing.T) {
+	program, args := (&Options{}).mongodCommandLine("/bin/mongod", "--port", "1234")
+	assert.Equal(t, "/bin/mongod", program)
+	assert.Equal(t, []string{"--port", "1234"}, args)
+
+	program, args = (&Options{DynamicLinkerPath: "/nix/store/glibc/lib/ld-linux-x86-64.so.2"}).mongodCommandLine("/bin/mongod", "--port", "1234")
+	assert.Equal(t, "/nix/store/glibc/lib/ld-linux-x86-64.so.2", program)
+	assert.Equal(t, []string{"/bin/mongod", "--port", "1234"}, args)
+}
@@ -0,0 +1,142 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// memberStatusTimeout is how long Members waits for replSetGetStatus
+const memberStatusTimeout = 5 * time.Second
+
+// MemberInfo identifies a replica set member, or a standalone server
+type MemberInfo struct {
+	// Index is the member's index: 0 for the mongod memongo started, or the
+	// index AddReplicaMember returned
+	Index int
+
+	// Name identifies the member in log lines, its captured output and the
+	// name of its data directory, e.g. "rs0-m1" for member 1 of replica set
+	// rs0. It's empty for a standalone server.
+	Name string
+
+	// ID is the member's _id in the replica set configuration, or -1 if it
+	// isn't known
+	ID int
+
+	// Port is the port the member listens on
+	Port int
+
+	// DBPath is the member's data directory
+	DBPath string
+
+	// State is the member's replica set state, e.g. "PRIMARY" or
+	// "SECONDARY", as of the call to Members. It's empty for a standalone
+	// server, or if the replica set status couldn't be read.
+	State string
+}
+
+// memberName returns the name of member index of the replica set opts
+// starts, e.g. "rs0-m1", or "" for a standalone server
+func memberName(opts *Options, index int) string {
+	if !opts.ShouldUseReplica {
+		return ""
+	}
+
+	return fmt.Sprintf("%s-m%d", opts.ReplicaSetName, index)
+}
+
+// dbDirPattern returns the os.MkdirTemp pattern for the data directory of a
+// member named name, e.g. memongo-rs0-m1-123456
+func dbDirPattern(name string) string {
+	if name == "" {
+		return "memongo"
+	}
+
+	return "memongo-" + name + "-"
+}
+
+// memberLogger returns the server's logger, tagged with the name of member
+// index if the server is a replica set
+func (s *Server) memberLogger(index int) *memongolog.Logger {
+	name := memberName(&s.opts, index)
+	if name == "" {
+		return s.logger
+	}
+
+	return s.logger.With("member", name)
+}
+
+// Members returns the server and the replica set members added with
+// AddReplicaMember, by index. For a replica set, it runs replSetGetStatus to
+// report each member's _id and current state.
+func (s *Server) Members() []MemberInfo {
+	s.mu.Lock()
+	members := []MemberInfo{{Index: 0, Name: memberName(&s.opts, 0), ID: -1, Port: s.port, DBPath: s.dbDir}}
+	hosts := map[int]string{0: s.replicaHost()}
+	for index, proc := range s.members {
+		members = append(members, MemberInfo{Index: index, Name: proc.name, ID: -1, Port: proc.port, DBPath: proc.dbDir})
+		hosts[index] = s.memberHost(proc)
+	}
+	s.mu.Unlock()
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Index < members[j].Index
+	})
+
+	if !s.isReplicaSet {
+		return members
+	}
+
+	status, err := s.replicaSetStatus()
+	if err != nil {
+		s.logger.Debugf("Error getting the replica set status: %s", err)
+		return members
+	}
+	byHost := make(map[string]replicaSetMember, len(status))
+	for _, m := range status {
+		byHost[m.Name] = m
+	}
+	for i := range members {
+		if m, ok := byHost[hosts[members[i].Index]]; ok {
+			members[i].ID = m.ID
+			members[i].State = m.StateStr
+		}
+	}
+
+	return members
+}
+
+// replicaSetMember is a member in the output of replSetGetStatus
+type replicaSetMember struct {
+	ID       int    `bson:"_id"`
+	Name     string `bson:"name"`
+	StateStr string `bson:"stateStr"`
+}
+
+// replicaSetStatus returns the members replSetGetStatus reports
+func (s *Server) replicaSetStatus() ([]replicaSetMember, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), memberStatusTimeout)
+	defer cancel()
+
+	client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	var status struct {
+		Members []replicaSetMember `bson:"members"`
+	}
+	err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "replSetGetStatus", Value: 1}}).Decode(&status)
+	if err != nil {
+		return nil, err
+	}
+
+	return status.Members, nil
+}
@@ -0,0 +1,77 @@
+package memongo
+
+import (
+	"bytes"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemberName(t *testing.T) {
+	assert.Equal(t, "", memberName(&Options{ReplicaSetName: "rs0"}, 0))
+	assert.Equal(t, "rs0-m0", memberName(&Options{ShouldUseReplica: true, ReplicaSetName: "rs0"}, 0))
+	assert.Equal(t, "orders-m2", memberName(&Options{ShouldUseReplica: true, ReplicaSetName: "orders"}, 2))
+
+	base := t.TempDir()
+	dir, err := mkdirTemp(base, dbDirPattern("rs0-m1"), "data directory")
+	require.NoError(t, err)
+	defer untrackPath(dir)
+	assert.True(t, strings.HasPrefix(filepath.Base(dir), "memongo-rs0-m1-"), dir)
+
+	dir, err = mkdirTemp(base, dbDirPattern(""), "data directory")
+	require.NoError(t, err)
+	defer untrackPath(dir)
+	assert.True(t, strings.HasPrefix(filepath.Base(dir), "memongo"), dir)
+}
+
+func TestMembers(t *testing.T) {
+	s := &Server{
+		dbDir:        "/tmp/memongo-rs0-m0-1",
+		port:         27017,
+		logger:       memongolog.New(nil, memongolog.LogLevelSilent),
+		isReplicaSet: true,
+		opts:         Options{ShouldUseReplica: true, ReplicaSetName: "rs0"},
+		members: map[int]*mongodProcess{
+			3: {member: 3, name: "rs0-m3", dbDir: "/tmp/memongo-rs0-m3-1", port: 27019},
+			1: {member: 1, name: "rs0-m1", dbDir: "/tmp/memongo-rs0-m1-1", port: 27018},
+		},
+		stopped: make(chan struct{}),
+	}
+	close(s.stopped)
+
+	// The state can't be read from a stopped server
+	assert.Equal(t, []MemberInfo{
+		{Index: 0, Name: "rs0-m0", ID: -1, Port: 27017, DBPath: "/tmp/memongo-rs0-m0-1"},
+		{Index: 1, Name: "rs0-m1", ID: -1, Port: 27018, DBPath: "/tmp/memongo-rs0-m1-1"},
+		{Index: 3, Name: "rs0-m3", ID: -1, Port: 27019, DBPath: "/tmp/memongo-rs0-m3-1"},
+	}, s.Members())
+
+	standalone := &Server{dbDir: "/tmp/memongo1", port: 27017}
+	assert.Equal(t, []MemberInfo{{Index: 0, ID: -1, Port: 27017, DBPath: "/tmp/memongo1"}}, standalone.Members())
+}
+
+func TestMemberLogger(t *testing.T) {
+	var out bytes.Buffer
+	s := &Server{
+		logger: memongolog.New(log.New(&out, "", 0), memongolog.LogLevelDebug),
+		opts:   Options{ShouldUseReplica: true, ReplicaSetName: "rs0"},
+	}
+	s.memberLogger(2).Debugf("stopping")
+	assert.Contains(t, out.String(), "[member=rs0-m2] stopping")
+
+	out.Reset()
+	s.opts.ShouldUseReplica = false
+	s.memberLogger(0).Debugf("stopping")
+	assert.NotContains(t, out.String(), "member=")
+}
+
+func TestOutputTailName(t *testing.T) {
+	tail := &outputTail{name: "rs0-m1"}
+	_, _ = tail.Write([]byte("one\ntwo\n"))
+	assert.Equal(t, []string{"[rs0-m1] one", "[rs0-m1] two"}, tail.recent())
+}
@@ -0,0 +1,553 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/100mslive/memongo/v2/retry"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// MemberOptions configures a replica set member added with AddReplicaMember
+type MemberOptions struct {
+	// Priority is the member's election priority. Defaults to the server's
+	// default of 1, or 0 for hidden members.
+	Priority *float64
+
+	// Votes is the number of votes the member has, 0 or 1. Defaults to 1.
+	Votes *int
+
+	// Hidden hides the member from clients. Hidden members must have
+	// priority 0.
+	Hidden bool
+
+	// Tags are the member's tags, used by read preferences
+	Tags map[string]string
+
+	// WaitForSecondary makes AddReplicaMember wait until the member has
+	// finished its initial sync and become a secondary
+	WaitForSecondary bool
+
+	// AdvertisedHost is the host the replica set configuration names the
+	// member by, like Options.AdvertisedReplicaHost
+	AdvertisedHost string
+
+	// Version is the MongoDB version the member runs, downloaded if needed,
+	// for testing the mixed-version window of an upgrade. Defaults to the
+	// server's version. It can't be more than one major release apart from
+	// the other members' versions, and the feature compatibility version is
+	// lowered to the oldest release in the set before the member is added.
+	Version string
+}
+
+func (o MemberOptions) validate() error {
+	if o.Votes != nil && *o.Votes != 0 && *o.Votes != 1 {
+		return fmt.Errorf("invalid member votes %d: must be 0 or 1", *o.Votes)
+	}
+	if o.Priority != nil && *o.Priority < 0 {
+		return fmt.Errorf("invalid member priority %g: must not be negative", *o.Priority)
+	}
+	if o.Hidden && o.Priority != nil && *o.Priority != 0 {
+		return fmt.Errorf("hidden members must have priority 0, got %g", *o.Priority)
+	}
+	if o.Version != "" {
+		_, err := capabilitiesForVersion(o.Version)
+		if err != nil {
+			return fmt.Errorf("invalid member version: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// retryableReconfigCodes are the error codes replSetReconfig fails with while
+// a previous reconfiguration is still being applied
+var retryableReconfigCodes = []int{
+	109, // ConfigurationInProgress
+	308, // CurrentConfigNotCommittedYet
+}
+
+// memberStatePollInterval is how often AddReplicaMember checks whether a new
+// member has become a secondary
+const memberStatePollInterval = 100 * time.Millisecond
+
+// AddReplicaMember starts another mongod and adds it to the replica set. The
+// member gets its own data directory and port, and is stopped along with the
+// server. It returns the member's index, which identifies it in events,
+// MemberURIs and RemoveReplicaMember. The port is leased from
+// Options.PortReservationProvider if it's set.
+//
+// The reconfiguration is retried while another one is in progress, for up to
+// Options.ReplicaSetReadyTimeout in total. If waiting for the member to
+// become a secondary fails, the member is left in the replica set and its
+// index is returned along with the error.
+func (s *Server) AddReplicaMember(ctx context.Context, opts MemberOptions) (int, error) {
+	if err := s.checkRunning(); err != nil {
+		return 0, err
+	}
+	if !s.isReplicaSet {
+		return 0, fmt.Errorf("cannot add a replica set member: the server wasn't started with ShouldUseReplica")
+	}
+
+	err := opts.validate()
+	if err != nil {
+		return 0, err
+	}
+
+	// Downloading the member's version isn't bounded by
+	// ReplicaSetReadyTimeout
+	downloadCtx := ctx
+	ctx, cancel := context.WithTimeout(ctx, s.opts.ReplicaSetReadyTimeout)
+	defer cancel()
+
+	s.mu.Lock()
+	binPath, caps, version := s.binPath, s.caps, s.proc.version
+	var versions []string
+	if opts.Version != "" {
+		versions, err = s.memberVersions(nil, "")
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	if opts.Version != "" {
+		version = opts.Version
+		versions = append(versions, version)
+		err = validateVersionSkew(versions)
+		if err != nil {
+			return 0, err
+		}
+
+		binPath, caps, err = s.versionBinary(downloadCtx, version)
+		if err != nil {
+			return 0, err
+		}
+
+		// An older member can only sync from the set with its release's
+		// feature compatibility version
+		err = s.setFeatureCompatibility(ctx, versions)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	s.mu.Lock()
+	index := s.nextMember
+	s.nextMember++
+	s.mu.Unlock()
+	logger := s.memberLogger(index)
+
+	proc, err := s.launchMember(ctx, index, 0, opts.AdvertisedHost, binPath, caps)
+	if err != nil {
+		return 0, err
+	}
+	proc.hidden = opts.Hidden
+	proc.version = version
+
+	client, err := s.connect()
+	if err != nil {
+		proc.stop(logger, false)
+		return 0, err
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	host := s.memberHost(proc)
+	err = s.reconfigReplicaSet(ctx, client, func(config bson.D) (bson.D, error) {
+		return addConfigMember(config, memberDocument(host, opts))
+	})
+	if err != nil {
+		proc.stop(logger, false)
+		return 0, fmt.Errorf("error adding replica set member %s: %w", host, err)
+	}
+
+	s.mu.Lock()
+	s.members[index] = proc
+	s.mu.Unlock()
+
+	s.logger.Debugf("Added replica set member %d at %s", index, host)
+
+	if opts.WaitForSecondary {
+		state, err := waitForMemberState(ctx, client, host, "SECONDARY")
+		if err != nil {
+			return index, fmt.Errorf("error waiting for replica set member %s to become a secondary: %w", host, err)
+		}
+		s.events.emitState(index, state)
+	}
+
+	return index, nil
+}
+
+// launchMember starts mongod from binPath as member index, with its own data
+// directory, on port, or on a port from memberPort if port is 0
+func (s *Server) launchMember(ctx context.Context, index int, port int, advertisedHost string, binPath string, caps versionCapabilities) (*mongodProcess, error) {
+	env, err := s.opts.mongodEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	releasePort := func() {}
+	if port == 0 {
+		port, releasePort, err = s.memberPort()
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer releasePort()
+
+	name := memberName(&s.opts, index)
+	dbDir, err := mkdirTemp(s.opts.dataDirBase(), dbDirPattern(name), "data directory")
+	if err != nil {
+		return nil, err
+	}
+
+	args, _ := mongodArgs(&s.opts, caps, dbDir, port, s.keyFile, advertisedHost)
+	program, args := s.opts.mongodCommandLine(binPath, args...)
+	_, err = starts.acquire(ctx, s.opts.StartConcurrency)
+	if err != nil {
+		_ = removePath(dbDir)
+		return nil, err
+	}
+	proc, err := launchMongod(ctx, program, args, env, dbDir, false, index, name, caps.reReady, s.opts.startupWait(), s.logger, s.events)
+	starts.release()
+	if err != nil {
+		return nil, err
+	}
+	proc.advertisedHost = advertisedHost
+
+	return proc, nil
+}
+
+// RemoveReplicaMember removes a member added with AddReplicaMember from the
+// replica set, shuts it down cleanly and deletes its data directory
+func (s *Server) RemoveReplicaMember(ctx context.Context, index int) error {
+	if index == 0 {
+		return fmt.Errorf("cannot remove replica set member 0: only members added with AddReplicaMember can be removed")
+	}
+
+	s.mu.Lock()
+	proc, ok := s.members[index]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no replica set member %d", index)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.opts.ReplicaSetReadyTimeout)
+	defer cancel()
+
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	host := s.memberHost(proc)
+	err = s.reconfigReplicaSet(ctx, client, func(config bson.D) (bson.D, error) {
+		return removeConfigMember(config, host)
+	})
+	if err != nil {
+		return fmt.Errorf("error removing replica set member %s: %w", host, err)
+	}
+
+	s.mu.Lock()
+	before, versionErr := s.memberVersions(nil, "")
+	delete(s.members, index)
+	after, _ := s.memberVersions(nil, "")
+	s.mu.Unlock()
+
+	proc.stop(s.memberLogger(index), true)
+	s.logger.Debugf("Removed replica set member %d at %s", index, host)
+
+	// Without an older member, the feature compatibility version can be
+	// raised to the oldest release left
+	if versionErr == nil {
+		fcvBefore, _ := featureCompatibilityVersion(before)
+		fcvAfter, _ := featureCompatibilityVersion(after)
+		if fcvAfter != fcvBefore {
+			return s.setFeatureCompatibility(ctx, after)
+		}
+	}
+
+	return nil
+}
+
+// stopMembers stops the members added with AddReplicaMember or started for
+// Options.ReplicaMembers, leaving their data directories behind if keepDBDirs
+// is set. A graceful stop gives them until ctx is done to shut down. It
+// returns the errors stopping them.
+func (s *Server) stopMembers(ctx context.Context, keepDBDirs bool, graceful bool) []error {
+	s.mu.Lock()
+	members := s.members
+	s.members = map[int]*mongodProcess{}
+	s.mu.Unlock()
+
+	var errs []error
+	for _, member := range members {
+		member.keepDBDir = keepDBDirs
+		err := member.stopContext(ctx, s.memberLogger(member.member), graceful)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("replica set member %d: %w", member.member, err))
+		}
+	}
+
+	return errs
+}
+
+// memberURIs returns direct connection URIs for the members added with
+// AddReplicaMember, in index order
+func (s *Server) memberURIs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	indexes := make([]int, 0, len(s.members))
+	for index := range s.members {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	uris := make([]string, len(indexes))
+	for i, index := range indexes {
+		member := s.members[index]
+		uris[i] = directURI(s.hostPort(member.host, member.port))
+	}
+
+	return uris
+}
+
+// reconfigReplicaSet applies change to the current replica set configuration,
+// retrying while another reconfiguration is in progress
+func (s *Server) reconfigReplicaSet(ctx context.Context, client *mongo.Client, change func(bson.D) (bson.D, error)) error {
+	admin := client.Database("admin")
+
+	err := retry.Do(ctx, s.opts.replicaSetRetryPolicy(), func() error {
+		current, err := replicaSetGetConfig(ctx, client)
+		if err != nil {
+			return retry.Permanent(err)
+		}
+
+		config, err := change(current)
+		if err != nil {
+			return retry.Permanent(err)
+		}
+
+		err = admin.RunCommand(ctx, bson.D{{Key: "replSetReconfig", Value: config}}).Err()
+		if err == nil || !hasErrorCode(err, retryableReconfigCodes) {
+			return retry.Permanent(err)
+		}
+
+		s.logger.Debugf("replSetReconfig failed, retrying: %s", err)
+		return err
+	})
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return fmt.Errorf("gave up reconfiguring the replica set after %w", err)
+	}
+
+	return err
+}
+
+// replicaSetGetConfig returns the replica set's current configuration
+func replicaSetGetConfig(ctx context.Context, client *mongo.Client) (bson.D, error) {
+	var result struct {
+		Config bson.D `bson:"config"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "replSetGetConfig", Value: 1}}).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Config, nil
+}
+
+// waitForMemberState polls the replica set status until the member at host
+// is in one of states, or ctx is done, and returns the state it's in
+func waitForMemberState(ctx context.Context, client *mongo.Client, host string, states ...string) (string, error) {
+	var reached string
+	err := retry.Do(ctx, retry.Constant{Interval: memberStatePollInterval}, func() error {
+		var status struct {
+			Members []struct {
+				Name     string `bson:"name"`
+				StateStr string `bson:"stateStr"`
+			} `bson:"members"`
+		}
+		err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "replSetGetStatus", Value: 1}}).Decode(&status)
+		if err != nil {
+			return retry.Permanent(err)
+		}
+
+		for _, member := range status.Members {
+			if member.Name != host {
+				continue
+			}
+			for _, state := range states {
+				if member.StateStr == state {
+					reached = state
+					return nil
+				}
+			}
+		}
+
+		return fmt.Errorf("replica set member %s isn't %s yet", host, strings.Join(states, " or "))
+	})
+
+	return reached, err
+}
+
+func memberDocument(host string, opts MemberOptions) bson.D {
+	member := bson.D{{Key: "host", Value: host}}
+	if opts.Priority != nil {
+		member = append(member, bson.E{Key: "priority", Value: *opts.Priority})
+	} else if opts.Hidden {
+		member = append(member, bson.E{Key: "priority", Value: 0})
+	}
+	if opts.Votes != nil {
+		member = append(member, bson.E{Key: "votes", Value: *opts.Votes})
+	}
+	if opts.Hidden {
+		member = append(member, bson.E{Key: "hidden", Value: true})
+	}
+	if len(opts.Tags) > 0 {
+		member = append(member, bson.E{Key: "tags", Value: opts.Tags})
+	}
+
+	return member
+}
+
+// addConfigMember returns the next version of config, with member added under
+// an unused _id
+func addConfigMember(config bson.D, member bson.D) (bson.D, error) {
+	members, err := configMembers(config)
+	if err != nil {
+		return nil, err
+	}
+
+	nextID := int64(0)
+	for _, m := range members {
+		id, ok := configInt(configField(m, "_id"))
+		if !ok {
+			return nil, fmt.Errorf("replica set member has an invalid _id: %v", configField(m, "_id"))
+		}
+		if id >= nextID {
+			nextID = id + 1
+		}
+	}
+
+	member = append(bson.D{{Key: "_id", Value: nextID}}, member...)
+	members = append(members, member)
+
+	return nextConfigVersion(config, members)
+}
+
+// removeConfigMember returns the next version of config, without the member
+// at host
+func removeConfigMember(config bson.D, host string) (bson.D, error) {
+	members, err := configMembers(config)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := make(bson.A, 0, len(members))
+	for _, m := range members {
+		if configField(m, "host") != host {
+			kept = append(kept, m)
+		}
+	}
+	if len(kept) == len(members) {
+		return nil, fmt.Errorf("%s isn't a member of the replica set", host)
+	}
+
+	return nextConfigVersion(config, kept)
+}
+
+func configMembers(config bson.D) (bson.A, error) {
+	raw, ok := configField(config, "members").(bson.A)
+	if !ok {
+		return nil, errors.New("replica set config has no members")
+	}
+
+	members := make(bson.A, len(raw))
+	for i, m := range raw {
+		member, ok := m.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("replica set member %d isn't a document", i)
+		}
+		members[i] = member
+	}
+
+	return members, nil
+}
+
+// nextConfigVersion returns config with members replaced and its version
+// bumped. The term is dropped, since replSetReconfig sets it itself.
+func nextConfigVersion(config bson.D, members bson.A) (bson.D, error) {
+	version, ok := configInt(configField(config, "version"))
+	if !ok {
+		return nil, fmt.Errorf("replica set config has an invalid version: %v", configField(config, "version"))
+	}
+
+	next := make(bson.D, 0, len(config))
+	for _, e := range config {
+		switch e.Key {
+		case "term":
+			continue
+		case "version":
+			e.Value = version + 1
+		case "members":
+			e.Value = members
+		}
+		next = append(next, e)
+	}
+
+	return next, nil
+}
+
+func configField(doc interface{}, key string) interface{} {
+	d, ok := doc.(bson.D)
+	if !ok {
+		return nil
+	}
+	for _, e := range d {
+		if e.Key == key {
+			return e.Value
+		}
+	}
+
+	return nil
+}
+
+func configInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	default:
+		return 0, false
+	}
+}
+
+func hasErrorCode(err error, codes []int) bool {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+
+	for _, code := range codes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+
+	return false
+}
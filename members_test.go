@@ -0,0 +1,84 @@
+package memongo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func testReplicaSetConfig() bson.D {
+	return bson.D{
+		{Key: "_id", Value: "rs0"},
+		{Key: "version", Value: int32(3)},
+		{Key: "term", Value: int64(1)},
+		{Key: "members", Value: bson.A{
+			bson.D{{Key: "_id", Value: int32(0)}, {Key: "host", Value: "localhost:27017"}},
+			bson.D{{Key: "_id", Value: int32(4)}, {Key: "host", Value: "localhost:27018"}},
+		}},
+	}
+}
+
+func TestAddConfigMember(t *testing.T) {
+	config, err := addConfigMember(testReplicaSetConfig(), bson.D{{Key: "host", Value: "localhost:27019"}})
+	require.NoError(t, err)
+
+	assert.Equal(t, bson.D{
+		{Key: "_id", Value: "rs0"},
+		{Key: "version", Value: int64(4)},
+		{Key: "members", Value: bson.A{
+			bson.D{{Key: "_id", Value: int32(0)}, {Key: "host", Value: "localhost:27017"}},
+			bson.D{{Key: "_id", Value: int32(4)}, {Key: "host", Value: "localhost:27018"}},
+			bson.D{{Key: "_id", Value: int64(5)}, {Key: "host", Value: "localhost:27019"}},
+		}},
+	}, config)
+
+	_, err = addConfigMember(bson.D{{Key: "version", Value: int32(1)}}, bson.D{})
+	assert.Error(t, err)
+}
+
+func TestRemoveConfigMember(t *testing.T) {
+	config, err := removeConfigMember(testReplicaSetConfig(), "localhost:27018")
+	require.NoError(t, err)
+
+	assert.Equal(t, bson.D{
+		{Key: "_id", Value: "rs0"},
+		{Key: "version", Value: int64(4)},
+		{Key: "members", Value: bson.A{
+			bson.D{{Key: "_id", Value: int32(0)}, {Key: "host", Value: "localhost:27017"}},
+		}},
+	}, config)
+
+	_, err = removeConfigMember(testReplicaSetConfig(), "localhost:1")
+	assert.Error(t, err)
+}
+
+func TestMemberDocument(t *testing.T) {
+	assert.Equal(t, bson.D{{Key: "host", Value: "localhost:1"}}, memberDocument("localhost:1", MemberOptions{}))
+
+	votes := 0
+	assert.Equal(t, bson.D{
+		{Key: "host", Value: "localhost:1"},
+		{Key: "priority", Value: 0},
+		{Key: "votes", Value: 0},
+		{Key: "hidden", Value: true},
+		{Key: "tags", Value: map[string]string{"dc": "east"}},
+	}, memberDocument("localhost:1", MemberOptions{Votes: &votes, Hidden: true, Tags: map[string]string{"dc": "east"}}))
+}
+
+func TestMemberOptionsValidate(t *testing.T) {
+	priority := 2.0
+	votes := 3
+
+	assert.NoError(t, MemberOptions{}.validate())
+	assert.Error(t, MemberOptions{Votes: &votes}.validate())
+	assert.Error(t, MemberOptions{Hidden: true, Priority: &priority}.validate())
+}
+
+func TestIsRetryableReconfigError(t *testing.T) {
+	assert.True(t, hasErrorCode(mongo.CommandError{Code: 109, Name: "ConfigurationInProgress"}, retryableReconfigCodes))
+	assert.True(t, hasErrorCode(mongo.CommandError{Code: 308, Name: "CurrentConfigNotCommittedYet"}, retryableReconfigCodes))
+	assert.False(t, hasErrorCode(mongo.CommandError{Code: 103, Name: "NewReplicaSetConfigurationIncompatible"}, retryableReconfigCodes))
+}
@@ -3,35 +3,167 @@ package memongo
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"os"
-	"os/exec"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/100mslive/memongo/v2/memongolog"
-	"github.com/100mslive/memongo/v2/monitor"
+	"github.com/100mslive/memongo/v2/mongobin"
+	"github.com/100mslive/memongo/v2/retry"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
-const mongoConnectionTemplate = "mongodb://localhost:%d/?directConnection=true"
-
 // Server represents a running MongoDB server
 type Server struct {
-	cmd            *exec.Cmd
-	watcherCmd     *exec.Cmd
+	proc           *mongodProcess
 	dbDir          string
 	logger         *memongolog.Logger
 	port           int
 	isReplicaSet   bool
 	replicaSetName string
+	health         *healthServer
+	admin          *adminUI
+	events         *eventBus
+	opts           Options
+	storageEngine  string
+	capture        *commandCapture
+	binPath        string
+	caps           versionCapabilities
+	keyFile        string
+	host           string
+	srvDNS         *dnsServer
+	stopOnce       sync.Once
+	stopped        chan struct{}
+
+	// refs is how many references AddRef has taken beyond the starter's,
+	// guarded by mu
+	refs int
+
+	// stopErr is the first error stopping the server ran into, set by stop
+	stopErr error
+
+	// failed is set to 1 by MarkFailed
+	failed int32
+
+	// mu guards version, which is looked up from the server if it wasn't
+	// known at startup, buildInfo, which is looked up on first use, client,
+	// which is created on first use, credential, which StartAppStack sets
+	// once it creates the root user, the replica set members added with
+	// AddReplicaMember, startReport, which is completed by Stop, the
+	// envFiles written by WriteEnvFile, and proc, binPath and caps, which
+	// UpgradeMember changes
+	mu          sync.Mutex
+	version     string
+	buildInfo   *BuildInfo
+	client      *mongo.Client
+	credential  *options.Credential
+	members     map[int]*mongodProcess
+	nextMember  int
+	startReport StartReport
+	envFiles    []string
+
+	// cluster is the config server and shards behind the mongos of a
+	// server started with Options.Sharded
+	cluster *shardedCluster
+
+	// recordMu guards recorder, which is set while recording with RecordTo.
+	// It's separate from mu since commands are recorded while mu is held.
+	recordMu sync.Mutex
+	recorder *commandRecorder
+}
+
+// StartReport describes how a server was started
+type StartReport struct {
+	// Attempts is how many times starting mongod was tried. It's more than 1
+	// if Options.StartRetries allowed failed attempts to be retried.
+	Attempts int
+
+	// AttemptErrors are the errors of the failed attempts, in order
+	AttemptErrors []error
+
+	// Duration is how long starting took, including any download and
+	// retries, up to the replica set being initiated
+	Duration time.Duration
+
+	// DBPathBytes is the size of the data directory when the server was
+	// stopped. It's 0 until Stop is called.
+	DBPathBytes int64
+
+	// Version is the MongoDB version, if it was known at startup
+	Version string
+
+	// Binary records which mongod binary ran
+	Binary BinaryProvenance
+
+	// URI is the server's URI
+	URI string
+
+	// CacheHit is true if mongod was found in the download cache, and
+	// Downloaded if it was downloaded. Both are false if Options.MongodBin
+	// was given.
+	CacheHit   bool
+	Downloaded bool
+
+	// PortWaitAttempts is how many times memongo dialed mongod's port after
+	// it reported listening, until a connection succeeded, and PortWait how
+	// long that took
+	PortWaitAttempts int
+	PortWait         time.Duration
+
+	// QueueTime is how long starting waited for a slot under
+	// Options.StartConcurrency or SetMaxConcurrentStarts
+	QueueTime time.Duration
+
+	// SeedDocuments is how many documents were inserted from Options.Seed,
+	// Options.SeedDir and Options.SeedGenerated, in SeedDuration, at SeedDocumentsPerSecond
+	SeedDocuments          int
+	SeedDuration           time.Duration
+	SeedDocumentsPerSecond float64
+
+	// IgnoredOptions are the options that were given but have no effect
+	// with the others, which are logged as warnings. With Options.Strict,
+	// starting fails instead.
+	IgnoredOptions []IgnoredOption
+}
+
+// Summary describes the start in a line, e.g. "mongod 8.0.0 ready at
+// mongodb://127.0.0.1:54321 (cache hit, 1.8s)"
+func (r StartReport) Summary() string {
+	mongod := "mongod"
+	if r.Version != "" {
+		mongod += " " + r.Version
+	}
+
+	details := []string{"MongodBin"}
+	if r.CacheHit {
+		details[0] = "cache hit"
+	} else if r.Downloaded {
+		details[0] = "downloaded"
+	}
+	if r.Attempts > 1 {
+		details = append(details, fmt.Sprintf("%d attempts", r.Attempts))
+	}
+	if r.QueueTime >= 100*time.Millisecond {
+		details = append(details, fmt.Sprintf("queued %s", r.QueueTime.Round(100*time.Millisecond)))
+	}
+	details = append(details, r.Duration.Round(100*time.Millisecond).String())
+
+	return fmt.Sprintf("%s ready at %s (%s)", mongod, r.URI, strings.Join(details, ", "))
 }
 
+// serverCount numbers the servers started by this process, to tell their log
+// messages apart
+var serverCount uint64
+
 // Start runs a MongoDB server at a given MongoDB version using default options
 // and returns the Server.
 func Start(version string) (*Server, error) {
@@ -40,188 +172,476 @@ func Start(version string) (*Server, error) {
 	})
 }
 
-// StartWithOptions is like Start(), but accepts options.
+// StartWithOptions is like Start(), but accepts options. The options are
+// copied before defaults are applied, so the caller's value isn't modified
+// and may be reused, even concurrently; use Server.EffectiveOptions to see
+// the port and other values that were picked.
 func StartWithOptions(opts *Options) (*Server, error) {
-	err := opts.fillDefaults()
+	return StartWithContext(context.Background(), opts)
+}
+
+// StartWithContext is like StartWithOptions, but gives up once ctx is done:
+// while downloading and extracting mongod, waiting for it to start, and
+// initiating the replica set. Any mongod it started is then killed and its
+// data directory removed, and the error wraps ctx's. The download cache is
+// never left with a half-written binary.
+func StartWithContext(ctx context.Context, opts *Options) (server *Server, err error) {
+	err = ctx.Err()
 	if err != nil {
 		return nil, err
 	}
-
-	logger := opts.getLogger()
-
-	logger.Infof("Starting MongoDB with options %#v", opts)
-
-	binPath, err := opts.getOrDownloadBinPath()
+	opts = opts.clone()
+	err = checkTimeBudget()
 	if err != nil {
 		return nil, err
 	}
+	began := time.Now()
+	defer func() {
+		if err != nil {
+			recordFailedStart(err, time.Since(began))
+			return
+		}
+		recordStart(server.StartReport(), time.Since(began))
+	}()
+	defer func() {
+		err = opts.redactError(err)
+	}()
+	defer opts.releasePort()
 
-	logger.Debugf("Using binary %s", binPath)
-
-	// Create a db dir. Even the ephemeralForTest engine needs a dbpath.
-	dbDir, err := os.MkdirTemp("", "memongo")
+	err = opts.fillDefaults()
 	if err != nil {
 		return nil, err
 	}
 
-	// Construct the command and attach stdout/stderr handlers
-
-	engine := "ephemeralForTest"
-	args := []string{"--dbpath", dbDir, "--port", strconv.Itoa(opts.Port)}
-	if opts.ShouldUseReplica {
-		engine = "wiredTiger"
-		args = append(args, "--replSet", opts.ReplicaSetName)
-	} else if strings.HasPrefix(opts.MongoVersion, "7.") || strings.HasPrefix(opts.MongoVersion, "8.") {
-		engine = "wiredTiger"
-	}
-	if engine == "wiredTiger" {
-		args = append(args, "--bind_ip", "localhost")
-		// Apply WiredTiger cache size limit if specified
-		if opts.WiredTigerCacheSizeGB > 0 {
-			args = append(args, "--wiredTigerCacheSizeGB", strconv.FormatFloat(opts.WiredTigerCacheSizeGB, 'f', 2, 64))
-		}
-	}
-
-	if opts.Auth {
-		args = append(args, "--auth")
-		// A keyfile needs to be specified if auth and a replicaset are used
-		if opts.ShouldUseReplica {
-			tmpFile, err := os.CreateTemp("", "keyfile")
-			// This library is specifically intended for ephemeral mongo
-			// databases so we don't need a lot of security here, however
-			// if you're reading this file trying to figure out how to generate
-			// a keyfile, please see the official MongoDB documentation on how
-			// to do this correctly and securely for a production environment.
+	logger := opts.getLogger().With("server", strconv.FormatUint(atomic.AddUint64(&serverCount, 1), 10))
+	if opts.LogSummaryOnly {
+		logger = logger.Buffered()
+		defer func() {
 			if err != nil {
+				logger.Flush()
+				return
+			}
+			logger.Discard()
+			logger.Infof("%s", server.StartReport().Summary())
+		}()
+	}
+
+	logger.Infof("Starting MongoDB with options %#v", opts)
+	for _, ignored := range opts.ignored {
+		logger.Warnf("%s", ignored)
+	}
+
+	// Start the health listener first, so probes report "not ready" while
+	// mongod is starting up
+	var health *healthServer
+	if opts.HealthHTTPAddr != "" {
+		health, err = startHealthServer(opts.HealthHTTPAddr, logger)
+		if err != nil {
+			if opts.HealthHTTPStrict {
 				return nil, err
 			}
-			_, _ = tmpFile.Write([]byte("insecurekeyfile"))
-			_ = tmpFile.Chmod(0400) // MongoDB requires keyfile to be readable only by owner
-			_ = tmpFile.Close()
-			args = append(args, "--keyFile", tmpFile.Name())
+			logger.Warnf("%s; continuing without health probes", err)
 		}
 	}
 
-	args = append(args, []string{"--storageEngine", engine}...)
+	events := newEventBus(opts.EventSink)
 
-	//  Safe to pass binPath and dbDir
-	//nolint:gosec
-	cmd := exec.Command(binPath, args...)
+	server, err = startTopology(ctx, opts, logger, health, events)
+	if err != nil {
+		health.stop()
+		return nil, err
+	}
 
-	stdoutHandler, startupErrCh, startupPortCh := stdoutHandler(logger)
-	cmd.Stdout = stdoutHandler
-	cmd.Stderr = stderrHandler(logger)
+	// Catch a URI clients can't connect with now, rather than in the first
+	// test
+	pingCtx, cancel := context.WithTimeout(ctx, opts.StartupTimeout)
+	err = server.Ping(pingCtx)
+	cancel()
+	if err != nil {
+		health.stop()
+		server.Stop()
+		return nil, fmt.Errorf("mongod is listening, but connecting with %s failed: %w", server.DirectURI(), err)
+	}
 
-	logger.Debugf("Starting mongod")
+	if opts.SRVDomain != "" {
+		err := server.startSRV()
+		if err != nil {
+			health.stop()
+			server.Stop()
+			return nil, err
+		}
+	}
 
-	// Run the server
-	err = cmd.Start()
-	if err != nil {
-		remErr := os.RemoveAll(dbDir)
-		if remErr != nil {
-			logger.Warnf("error removing data directory: %s", remErr)
+	if len(opts.Seed) > 0 || opts.SeedDir != "" || len(opts.SeedGenerated) > 0 {
+		err := server.seedFromOptions(ctx, opts)
+		if err != nil {
+			health.stop()
+			server.Stop()
+			return nil, err
 		}
+	}
 
-		return nil, err
+	if opts.ReadOnly {
+		ctx, cancel := context.WithTimeout(ctx, opts.ReplicaSetReadyTimeout)
+		err := server.makeReadOnly(ctx)
+		cancel()
+		if err != nil {
+			health.stop()
+			server.Stop()
+			return nil, err
+		}
+	}
+
+	server.health = health
+	if opts.AdminUIAddr != "" {
+		server.admin, err = startAdminUI(server, opts.AdminUIAddr)
+		if err != nil {
+			server.Stop()
+			return nil, err
+		}
+	}
+	if opts.MaxDBPathBytes > 0 {
+		goTracked("disk quota watcher", func() {
+			server.watchDiskQuota(server.stopped)
+		})
+	}
+	if opts.CompactOnInterval > 0 {
+		goTracked("compactor", func() {
+			server.compactPeriodically(server.stopped)
+		})
+	}
+	trackServer(server)
+	health.setReady(healthInfo{
+		URI:        server.URI(),
+		Version:    opts.MongoVersion,
+		ReplicaSet: server.ReplicaSetName(),
+	})
+
+	return server, nil
+}
+
+// startTopology starts the sharded cluster or the standalone server or
+// replica set opts describe
+func startTopology(ctx context.Context, opts *Options, logger *memongolog.Logger, health *healthServer, events *eventBus) (*Server, error) {
+	if opts.Sharded {
+		return startSharded(ctx, opts, logger, health, events)
 	}
 
-	logger.Debugf("Started mongod; starting watcher")
+	return startWithRetries(ctx, opts, logger, health, events)
+}
+
+// startWithRetries calls start, retrying transient failures up to
+// opts.StartRetries times, as opts.StartRetryPolicy says
+func startWithRetries(ctx context.Context, opts *Options, logger *memongolog.Logger, health *healthServer, events *eventBus) (*Server, error) {
+	startTime := time.Now()
 
-	// Start a watcher: the watcher is a subprocess that ensure if this process
-	// dies, the mongo server will be killed (and not reparented under init)
-	watcherCmd, err := monitor.RunMonitor(os.Getpid(), cmd.Process.Pid)
+	if opts.Port == 0 && opts.PortReservationProvider != nil {
+		err := opts.leasePort()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var server *Server
+	var attemptErrs []error
+	err := retry.Do(ctx, opts.startRetryPolicy(), func() error {
+		if len(attemptErrs) > 0 {
+			logger.Warnf("Starting mongod failed, retrying (attempt %d of %d): %s", len(attemptErrs)+1, opts.StartRetries+1, attemptErrs[len(attemptErrs)-1])
+
+			err := opts.replacePort()
+			if err != nil {
+				return retry.Permanent(err)
+			}
+		}
+
+		var err error
+		server, err = start(ctx, opts, logger, health, events)
+		if err == nil {
+			return nil
+		}
+
+		attemptErrs = append(attemptErrs, err)
+		if !isRetryableStartError(err) {
+			return retry.Permanent(err)
+		}
+		return err
+	})
 	if err != nil {
-		killErr := cmd.Process.Kill()
-		if killErr != nil {
-			logger.Warnf("error stopping mongo process: %s", killErr)
+		if len(attemptErrs) == 0 || err != attemptErrs[len(attemptErrs)-1] {
+			return nil, err
 		}
+		return nil, startFailure(attemptErrs)
+	}
+
+	server.startReport.Attempts = len(attemptErrs) + 1
+	server.startReport.AttemptErrors = attemptErrs
+	server.startReport.Duration = time.Since(startTime)
+	return server, nil
+}
 
-		remErr := os.RemoveAll(dbDir)
-		if remErr != nil {
-			logger.Warnf("error removing data directory: %s", remErr)
+// startRetryPolicy returns the policy startWithRetries retries with
+func (opts *Options) startRetryPolicy() retry.Policy {
+	policy := opts.StartRetryPolicy
+	if policy == nil {
+		policy = retry.Constant{}
+	}
+
+	return retry.MaxRetries{Policy: policy, Retries: opts.StartRetries}
+}
+
+// replacePort replaces a port memongo picked or leased for a failed start
+// with another one
+func (opts *Options) replacePort() error {
+	if opts.portAllocated {
+		port, err := allocatePort(opts.PortRange)
+		if err != nil {
+			return fmt.Errorf("error finding a free port: %w", err)
 		}
+		opts.Port = port
+	}
+	if opts.portLease != nil && opts.portLease.provided {
+		opts.releasePort()
+		return opts.leasePort()
+	}
+
+	return nil
+}
+
+// isRetryableStartError reports whether a failed start may succeed if it's
+// tried again
+func isRetryableStartError(err error) bool {
+	return errors.Is(err, ErrStartupTimeout) ||
+		errors.Is(err, ErrPortInUse) ||
+		errors.Is(err, mongobin.ErrTransientDownload)
+}
 
+// startFailure returns the error for a start that failed after the given
+// attempts. It wraps the last attempt's error, and includes the others in its
+// message.
+func startFailure(attemptErrs []error) error {
+	last := attemptErrs[len(attemptErrs)-1]
+	if len(attemptErrs) == 1 {
+		return last
+	}
+
+	earlier := make([]string, len(attemptErrs)-1)
+	for i, err := range attemptErrs[:len(attemptErrs)-1] {
+		earlier[i] = fmt.Sprintf("attempt %d: %s", i+1, err)
+	}
+
+	return fmt.Errorf("mongod failed to start after %d attempts (%s): %w", len(attemptErrs), strings.Join(earlier, "; "), last)
+}
+
+func start(ctx context.Context, opts *Options, logger *memongolog.Logger, health *healthServer, events *eventBus) (*Server, error) {
+	binPath, cacheHit, err := opts.getOrDownloadBinPath(ctx, events, logger)
+	if err != nil {
 		return nil, err
 	}
 
-	logger.Debugf("Started watcher; waiting for mongod to report port number")
-	startupTime := time.Now()
+	logger.Debugf("Using binary %s", binPath)
 
-	// Wait for the stdout handler to report the server's port number (or a
-	// startup error)
-	var port int
-	select {
-	case p := <-startupPortCh:
-		port = p
-	case err := <-startupErrCh:
-		killErr := cmd.Process.Kill()
-		if killErr != nil {
-			logger.Warnf("error stopping mongo process: %s", killErr)
+	if runtime.GOOS == "linux" && opts.DynamicLinkerPath == "" {
+		err := checkDynamicLinker(binPath)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		remErr := os.RemoveAll(dbDir)
-		if remErr != nil {
-			logger.Warnf("error removing data directory: %s", remErr)
-		}
+	caps, version, err := opts.capabilities(binPath, logger)
+	if err != nil {
+		return nil, err
+	}
 
+	provenance, err := opts.binaryProvenance(binPath, cacheHit, version)
+	if err != nil {
 		return nil, err
-	case <-time.After(opts.StartupTimeout):
-		killErr := cmd.Process.Kill()
-		if killErr != nil {
-			logger.Warnf("error stopping mongo process: %s", killErr)
-		}
+	}
 
-		remErr := os.RemoveAll(dbDir)
-		if remErr != nil {
-			logger.Warnf("error removing data directory: %s", remErr)
+	// Validate could only check the options that depend on the version if
+	// it was given
+	ignored := opts.ignored
+	if opts.MongoVersion == "" {
+		versionIgnored := opts.ignoredOptions(&caps, true)
+		if opts.Strict {
+			err := ignoredOptionsError(versionIgnored)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, o := range versionIgnored {
+			logger.Warnf("%s", o)
 		}
+		ignored = append(append([]IgnoredOption(nil), ignored...), versionIgnored...)
+	}
+
+	err = opts.resolveWiredTigerCacheSize(caps, logger)
+	if err != nil {
+		return nil, err
+	}
 
-		return nil, fmt.Errorf("timed out waiting for mongod to start")
+	env, err := opts.mongodEnv()
+	if err != nil {
+		return nil, err
 	}
 
-	logger.Debugf("mongod started up and reported a port number after %s", time.Since(startupTime).String())
+	opts.resolveDiskless(binPath, logger)
 
-	// ---------- START OF REPLICA CODE ----------
-	if opts.ShouldUseReplica {
-		ctx := context.Background()
-		connectionURL := fmt.Sprintf(mongoConnectionTemplate, opts.Port)
-		client, err := mongo.Connect(options.Client().ApplyURI(connectionURL))
+	// Create a db dir. Even the ephemeralForTest engine needs a dbpath.
+	dbDir, err := mkdirTemp(opts.dataDirBase(), dbDirPattern(memberName(opts, 0)), "data directory")
+	if err != nil {
+		return nil, err
+	}
+	if !opts.SkipFilesystemCheck {
+		err = checkFilesystem(dbDir, logger)
 		if err != nil {
-			logger.Warnf("error while connect to localhost database: %w", err)
+			_ = removePath(dbDir)
 			return nil, err
 		}
+	}
 
-		if err := client.Ping(ctx, nil); err != nil {
-			logger.Warnf("error while ping to localhost database: %w", err)
+	// A keyfile needs to be specified if auth and a replicaset are used
+	var keyFile string
+	if opts.Auth && opts.ShouldUseReplica {
+		keyFile, err = keyFileFor(opts)
+		if err != nil {
+			_ = removePath(dbDir)
 			return nil, err
 		}
+	}
+
+	args, engine := mongodArgs(opts, caps, dbDir, opts.Port, keyFile, opts.AdvertisedReplicaHost)
+
+	program, args := opts.mongodCommandLine(binPath, args...)
+	queueTime, err := starts.acquire(ctx, opts.StartConcurrency)
+	if err != nil {
+		removeKeyFile(opts, keyFile, logger)
+		_ = removePath(dbDir)
+		return nil, err
+	}
+	if queueTime > 0 {
+		logger.Debugf("Waited %s for a start slot", queueTime)
+	}
+	launched := time.Now()
+	proc, err := launchMongod(ctx, program, args, env, dbDir, false, 0, memberName(opts, 0), caps.reReady, opts.startupWait(), logger, events)
+	starts.release()
+	if err != nil {
+		removeKeyFile(opts, keyFile, logger)
+		if errors.Is(err, ErrPortInUse) {
+			err = fmt.Errorf("%w%s", err, describePortOwner(opts.Port))
+		}
+		return nil, err
+	}
+	proc.advertisedHost = opts.AdvertisedReplicaHost
+	proc.version = version
+	health.setProcess(proc.exited)
+
+	var capture *commandCapture
+	if opts.CaptureCommands {
+		capture = newCommandCapture()
+	}
+
+	server := &Server{
+		proc:           proc,
+		dbDir:          dbDir,
+		logger:         logger,
+		port:           proc.port,
+		isReplicaSet:   opts.ShouldUseReplica,
+		replicaSetName: opts.ReplicaSetName,
+		events:         events,
+		opts:           *opts,
+		storageEngine:  engine,
+		version:        version,
+		capture:        capture,
+		binPath:        binPath,
+		caps:           caps,
+		keyFile:        keyFile,
+		host:           proc.host,
+		members:        map[int]*mongodProcess{},
+		nextMember:     1,
+		stopped:        make(chan struct{}),
+	}
+	server.startReport = StartReport{
+		Version:          version,
+		URI:              server.URI(),
+		CacheHit:         cacheHit,
+		Downloaded:       !cacheHit && opts.MongodBin == "",
+		Binary:           provenance,
+		PortWaitAttempts: proc.portWait.attempts,
+		PortWait:         proc.portWait.waited,
+		QueueTime:        queueTime,
+		IgnoredOptions:   ignored,
+	}
 
-		var result bson.M
-		err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "replSetInitiate", Value: nil}}).Decode(&result)
+	if opts.ShouldUseReplica {
+		err := server.launchReplicaMembers(ctx, time.Since(launched))
 		if err != nil {
-			logger.Warnf("error while init replica set: %w", err)
+			proc.stop(logger, false)
+			removeKeyFile(opts, keyFile, logger)
 			return nil, err
 		}
+		server.startReport.URI = server.URI()
+	}
 
-		if err := client.Disconnect(ctx); err != nil {
-			logger.Warnf("error while disconnect from localhost database: %w", err)
+	if opts.ShouldUseReplica && !opts.DeferReplicaSetInitiation {
+		err := server.InitiateReplicaSet(ctx)
+		if err != nil {
+			// Don't leave running mongods behind
+			server.stopMembers(context.Background(), false, false)
+			proc.stop(logger, false)
+			removeKeyFile(opts, keyFile, logger)
 			return nil, err
 		}
 
 		logger.Debugf("Started mongo replica")
 	}
-	// ---------- END OF REPLICA CODE ----------
 
-	// Return a Memongo server
-	return &Server{
-		cmd:            cmd,
-		watcherCmd:     watcherCmd,
-		dbDir:          dbDir,
-		logger:         logger,
-		port:           port,
-		isReplicaSet:   opts.ShouldUseReplica,
-		replicaSetName: opts.ReplicaSetName,
-	}, nil
+	return server, nil
+}
+
+// removeKeyFile removes a keyfile written by writeKeyFile. The user's
+// Options.ReplicaSetKeyFile is left alone.
+func removeKeyFile(opts *Options, keyFile string, logger *memongolog.Logger) {
+	if keyFile == "" || keyFile == opts.ReplicaSetKeyFile {
+		return
+	}
+
+	err := removePath(keyFile)
+	if err != nil {
+		logger.Warnf("error removing keyfile: %s", err)
+	}
+}
+
+// errNotPrimary is returned by waitForPrimary's attempts until the server is
+// primary
+var errNotPrimary = errors.New("the server isn't primary yet")
+
+// primaryPollInterval is how often waitForPrimary checks the server
+const primaryPollInterval = 100 * time.Millisecond
+
+// waitForPrimary polls the server until it reports itself as the primary of
+// its replica set, or ctx is done
+func waitForPrimary(ctx context.Context, client *mongo.Client) error {
+	err := retry.Do(ctx, retry.Constant{Interval: primaryPollInterval}, func() error {
+		var result struct {
+			IsMaster bool `bson:"ismaster"`
+		}
+		// hello was added in 4.4.2; older versions only have isMaster, which
+		// newer versions still accept
+		err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&result)
+		if err != nil {
+			return retry.Permanent(err)
+		}
+		if !result.IsMaster {
+			return errNotPrimary
+		}
+
+		return nil
+	})
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("timed out waiting for a primary to be elected")
+	}
+
+	return err
 }
 
 // Port returns the port the server is listening on.
@@ -229,50 +649,347 @@ func (s *Server) Port() int {
 	return s.port
 }
 
-// URI returns a mongodb:// URI to connect to
+// URI returns a mongodb:// URI to connect to. Its host is the literal
+// address mongod listens on, unless Options.PreferHostname is set; see Host.
+// For a replica set of more than one of Options.ReplicaMembers, it names
+// every member that isn't hidden, and the set. For a sharded cluster of more
+// than one of Options.MongosCount, it names every mongos.
 func (s *Server) URI() string {
-	return fmt.Sprintf("mongodb://localhost:%d", s.port)
+	if s.opts.replicaMemberCount() > 1 {
+		return mongoURI(s.uriHosts()...).param("replicaSet", s.replicaSetName).String()
+	}
+	if s.opts.mongosCount() > 1 {
+		return mongoURI(s.mongosHosts()...).String()
+	}
+
+	return mongoURI(s.addr()).String()
+}
+
+// String describes the server by its URI and data directory. It's safe to
+// print: credentials are redacted.
+func (s *Server) String() string {
+	return fmt.Sprintf("memongo server at %s (dbpath %s)", RedactURI(s.URI()), s.dbDir)
+}
+
+// DirectURI returns a mongodb:// URI with directConnection=true, which
+// connects to this server without replica set discovery. The driver
+// requires either this or the replicaSet parameter to connect sensibly to a
+// single-node replica set.
+func (s *Server) DirectURI() string {
+	return directURI(s.addr())
+}
+
+// URIWithReadPreference returns a mongodb:// URI with the given read
+// preference mode (e.g. "nearest") and tags, which select members by their
+// ReplicaMemberTags. For a replica set, the URI names the set rather than
+// using a direct connection, since direct connections ignore read preference.
+func (s *Server) URIWithReadPreference(mode string, tags map[string]string) string {
+	uri := mongoURI(s.addr())
+	if s.isReplicaSet {
+		uri.param("replicaSet", s.replicaSetName)
+	}
+
+	return uri.param("readPreference", mode).readPreferenceTags(tags).String()
+}
+
+// MemberURIs returns a direct connection URI for each replica set member, in
+// member order. For a server that isn't a replica set, it returns DirectURI.
+func (s *Server) MemberURIs() []string {
+	return append([]string{s.DirectURI()}, s.memberURIs()...)
+}
+
+// CheckURI reports problems with a connection string that's meant to connect
+// to this server which would otherwise surface as server selection timeouts:
+// for a replica set, a replicaSet parameter naming a different set, or
+// neither replicaSet nor directConnection=true, which leaves the driver to
+// discover the topology from whatever hostname the URI uses. Problems are
+// also logged as warnings.
+func (s *Server) CheckURI(uri string) error {
+	clientOpts := options.Client().ApplyURI(uri)
+	if err := clientOpts.Validate(); err != nil {
+		return fmt.Errorf("invalid connection string: %w", err)
+	}
+
+	if !s.isReplicaSet {
+		return nil
+	}
+
+	var err error
+	if clientOpts.ReplicaSet != nil && *clientOpts.ReplicaSet != s.replicaSetName {
+		err = fmt.Errorf("connection string names replica set %q, but the server's replica set is %q", *clientOpts.ReplicaSet, s.replicaSetName)
+	} else if clientOpts.ReplicaSet == nil && (clientOpts.Direct == nil || !*clientOpts.Direct) {
+		err = fmt.Errorf("connection string has neither replicaSet=%s nor directConnection=true, so connecting relies on replica set discovery; use DirectURI() or add one of them", s.replicaSetName)
+	}
+
+	if err != nil {
+		s.logger.Warnf("%s", err)
+	}
+
+	return err
 }
 
 // URIWithRandomDB returns a mongodb:// URI to connect to, with
-// a random database name (e.g. mongodb://localhost:1234/somerandomname)
+// a random database name (e.g. mongodb://127.0.0.1:1234/somerandomname)
 func (s *Server) URIWithRandomDB() string {
-	return fmt.Sprintf("mongodb://localhost:%d/%s", s.port, RandomDatabase())
+	return mongoURI(s.addr()).db(RandomDatabase()).String()
 }
 
-// Stop kills the mongo server
+// Stop stops the mongo server like StopWithContext, logging rather than
+// returning its error, so it can be deferred. It may be called more than
+// once. Once it's stopped, methods that need the server return
+// ErrServerStopped. A server shared with AddRef is stopped even if
+// references remain; use Release to stop it only once they're all released.
 func (s *Server) Stop() {
-	err := s.cmd.Process.Kill()
+	_ = s.stop(nil)
+}
+
+// StopWithContext stops the server: it asks mongod to shut down cleanly with
+// SIGTERM, kills it if it hasn't exited once ctx is done, or after 10
+// seconds if ctx has no deadline, and removes the data directory as
+// Options.Cleanup says. Replica set members and sharded cluster members are
+// stopped the same way. It returns the first error it ran into, such as a
+// mongod that didn't exit or a data directory that couldn't be removed; the
+// others are logged. Later calls, and calls after Stop, return the same
+// error.
+func (s *Server) StopWithContext(ctx context.Context) error {
+	return s.stopContext(ctx, nil)
+}
+
+// stop is StopWithContext without a deadline of its own, with reason as the
+// EventStopping error
+func (s *Server) stop(reason error) error {
+	return s.stopContext(context.Background(), reason)
+}
+
+// stopContext stops the server, with reason as the EventStopping error. It
+// returns the first error stopping the server ran into, which later calls
+// return too.
+func (s *Server) stopContext(ctx context.Context, reason error) error {
+	s.stopOnce.Do(func() {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, gracefulStopTimeout)
+			defer cancel()
+		}
+
+		failed := s.hasFailed(reason)
+		if failed && s.opts.DebugBundleOnFailure != "" {
+			s.writeFailureBundle()
+		}
+
+		untrackServer(s)
+		close(s.stopped)
+
+		s.events.emit(EventStopping, 0, reason)
+		defer func() {
+			s.events.emit(EventStopped, 0, nil)
+			s.events.close()
+		}()
+
+		keepDBDirs := !s.opts.Cleanup.RemoveDBPath.shouldRemove(failed)
+
+		s.health.stop()
+		s.admin.stop()
+		s.disconnectClient()
+
+		// Only the first error is returned; they're all logged
+		fail := func(err error) {
+			if s.stopErr == nil {
+				s.stopErr = err
+			}
+		}
+
+		err := s.StopRecording()
+		if err != nil {
+			s.logger.Warnf("error writing command recording: %s", err)
+			fail(fmt.Errorf("error writing command recording: %w", err))
+		}
+
+		usage, err := s.DiskUsage()
+		if err != nil {
+			s.logger.Warnf("%s", err)
+		}
+
+		s.resumeMembers()
+		s.mu.Lock()
+		proc := s.proc
+		s.startReport.DBPathBytes = usage
+		s.mu.Unlock()
+		for _, err := range s.stopMembers(ctx, keepDBDirs, true) {
+			fail(err)
+		}
+		for _, err := range s.stopRouters(ctx, keepDBDirs) {
+			fail(err)
+		}
+
+		proc.keepDBDir = keepDBDirs
+		err = proc.stopContext(ctx, s.memberLogger(0), true)
+		if err != nil {
+			fail(err)
+		}
+		for _, err := range s.cluster.stop(ctx, failed) {
+			fail(err)
+		}
+		removeKeyFile(&s.opts, s.keyFile, s.logger)
+		s.removeEnvFiles()
+		s.stopSRV()
+	})
+
+	return s.stopErr
+}
+
+// StartReport returns how the server was started
+func (s *Server) StartReport() StartReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.startReport
+}
+
+// Events returns a channel of lifecycle events for the server, starting with
+// the events emitted during startup. Events are dropped rather than blocking
+// the server when the channel is full; DroppedEvents counts them. The channel
+// is closed after EventStopped.
+func (s *Server) Events() <-chan Event {
+	return s.events.ch
+}
+
+// DroppedEvents returns the number of events that were dropped because the
+// Events channel was full.
+func (s *Server) DroppedEvents() uint64 {
+	return s.events.droppedCount()
+}
+
+// Ping checks if the MongoDB server is responsive, connecting with
+// DirectURI. It returns nil if the server is healthy, or an error if not.
+func (s *Server) Ping(ctx context.Context) error {
+	client, err := s.connect()
 	if err != nil {
-		s.logger.Warnf("error stopping mongod process: %s", err)
-		return
+		return err
 	}
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
 
-	err = s.watcherCmd.Process.Kill()
+	return client.Ping(ctx, nil)
+}
+
+// Client returns a client connected to the server with ClientOptions, which
+// is created and pinged on the first call and reused afterwards. It's
+// disconnected by Stop.
+func (s *Server) Client(ctx context.Context) (*mongo.Client, error) {
+	err := s.checkRunning()
 	if err != nil {
-		s.logger.Warnf("error stopping watcher process: %s", err)
-		return
+		return nil, err
 	}
+	clientOpts := s.ClientOptions()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	err = os.RemoveAll(s.dbDir)
+	// Stop closes stopped before disconnecting the client under mu, so a
+	// client created now would never be disconnected
+	err = s.checkRunning()
 	if err != nil {
-		s.logger.Warnf("error removing data directory: %s", err)
+		return nil, err
+	}
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	client, err := mongo.Connect(clientOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		_ = client.Disconnect(ctx)
+		return nil, fmt.Errorf("failed to ping: %w", err)
+	}
+
+	s.client = client
+	return client, nil
+}
+
+// ClientOptions returns the options Client connects with, for clients of
+// your own, e.g. with a different pool size. They connect directly to a
+// standalone server, a single-node replica set or a mongos, and name the
+// replica set of Options.ReplicaMembers, so the client follows the primary.
+// Once StartAppStack has created its root user, they authenticate as it.
+// With Options.CaptureCommands, the client's commands are recorded for
+// CapturedCommands.
+func (s *Server) ClientOptions() *options.ClientOptions {
+	clientOpts := options.Client().ApplyURI(s.clientURI()).SetMonitor(s.clientMonitor())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.credential != nil {
+		clientOpts.SetAuth(*s.credential)
+	}
+
+	return clientOpts
+}
+
+// clientURI returns the URI of ClientOptions, without credentials
+func (s *Server) clientURI() string {
+	return s.clientConnString().String()
+}
+
+// clientConnString returns the connection string of clientURI: URI if it
+// names several hosts, and DirectURI otherwise
+func (s *Server) clientConnString() *connString {
+	if s.opts.replicaMemberCount() > 1 {
+		return mongoURI(s.uriHosts()...).param("replicaSet", s.replicaSetName)
+	}
+	if s.opts.mongosCount() > 1 {
+		return mongoURI(s.mongosHosts()...)
+	}
+
+	return mongoURI(s.addr()).param("directConnection", "true")
+}
+
+// setCredential makes clients from ClientOptions authenticate as user, and
+// disconnects the client Client returned, which didn't
+func (s *Server) setCredential(user string, password string) {
+	s.mu.Lock()
+	s.credential = &options.Credential{Username: user, Password: password, AuthSource: "admin"}
+	s.mu.Unlock()
+
+	s.disconnectClient()
+}
+
+func (s *Server) disconnectClient() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
 		return
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := s.client.Disconnect(ctx)
+	if err != nil {
+		s.logger.Warnf("error disconnecting client: %s", err)
+	}
+	s.client = nil
 }
 
-// Ping checks if the MongoDB server is responsive.
-// It returns nil if the server is healthy, or an error if not.
-func (s *Server) Ping(ctx context.Context) error {
-	client, err := mongo.Connect(options.Client().ApplyURI(s.URI()))
+// connect returns a client connected directly to the server
+func (s *Server) connect() (*mongo.Client, error) {
+	err := s.checkRunning()
 	if err != nil {
-		return fmt.Errorf("failed to connect: %w", err)
+		return nil, err
 	}
-	defer func() {
-		_ = client.Disconnect(ctx)
-	}()
 
-	return client.Ping(ctx, nil)
+	client, err := mongo.Connect(options.Client().ApplyURI(s.DirectURI()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	return client, nil
 }
 
 // IsReplicaSet returns true if the server was started as a replica set.
@@ -289,8 +1006,57 @@ func (s *Server) ReplicaSetName() string {
 	return ""
 }
 
-// DBPath returns the path to the database directory.
-// This can be useful for debugging or diagnostics.
+// IsAuthEnabled returns true if the server was started with --auth.
+func (s *Server) IsAuthEnabled() bool {
+	return s.opts.Auth
+}
+
+// IsTLSEnabled returns true if the server accepts TLS connections. memongo
+// doesn't configure TLS, so this is currently always false.
+func (s *Server) IsTLSEnabled() bool {
+	return false
+}
+
+// StorageEngine returns the storage engine the server was started with,
+// "ephemeralForTest" or "wiredTiger", or "inMemory" with Options.Diskless on
+// MongoDB Enterprise.
+func (s *Server) StorageEngine() string {
+	return s.storageEngine
+}
+
+// EffectiveOptions returns a copy of the options the server was started with,
+// with environment variables and defaults applied.
+func (s *Server) EffectiveOptions() *Options {
+	return s.opts.clone()
+}
+
+// Capabilities returns the MongoDB features the server supports, based on
+// its version and whether it's a replica set. If the version wasn't given and
+// couldn't be detected from the binary, it's looked up from the running
+// server; otherwise ctx isn't used and this works after Stop too.
+func (s *Server) Capabilities(ctx context.Context) (Capabilities, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.version == "" {
+		info, err := s.cachedBuildInfo(ctx)
+		if err != nil {
+			return Capabilities{}, fmt.Errorf("error looking up the server version: %w", err)
+		}
+		s.version = formatVersion(info.VersionArray)
+	}
+
+	return featureCapabilities(s.version, s.isReplicaSet)
+}
+
+// DBPath returns the path to the database directory of the mongod memongo
+// started, the primary of a replica set. Members added with
+// AddReplicaMember have their own; DBPaths lists them all. With
+// Options.Diskless, its contents don't outlive the server, or the machine
+// for a tmpfs; see IsDiskless. For a sharded
+// cluster, it's an empty directory of the mongos, and DBPaths lists the
+// shards' and the config server's. This can be useful for debugging or
+// diagnostics.
 func (s *Server) DBPath() string {
 	return s.dbDir
 }
@@ -306,28 +1072,58 @@ var (
 )
 
 // The stdout handler relays lines from mongod's stout to our logger, and also
-// watches during startup for error or success messages.
+// watches during startup for error or success messages. reReady must match
+// the line mongod logs once it's listening, capturing the port number.
 //
-// It returns two channels: an error channel and a port channel. Only one
-// message will be sent to one of these two channels. A port number will
-// be sent to the port channel if the server start up correctly, and an
-// error will be send to the error channel if the server does not start up
-// correctly.
-func stdoutHandler(log *memongolog.Logger) (io.Writer, <-chan error, <-chan int) {
-	errChan := make(chan error)
-	portChan := make(chan int)
+// It returns two channels: an error channel and a ready channel. Only one
+// message will be sent to one of these two channels. The port number and
+// the addresses mongod listens on will be sent to the ready channel if the
+// server start up correctly, and an error will be send to the error channel
+// if the server does not start up correctly.
+//
+// The third channel receives the replica set names if mongod reports that
+// its stored configuration is for a different set than --replSet. mongod
+// keeps running in that case, so it's buffered and only read on failure.
+func stdoutHandler(log *memongolog.Logger, reReady *regexp.Regexp) (io.WriteCloser, <-chan error, <-chan listening, <-chan [2]string, <-chan string) {
+	// Buffered, so the handler doesn't block if startup already timed out
+	errChan := make(chan error, 1)
+	readyChan := make(chan listening, 1)
+	mismatchChan := make(chan [2]string, 1)
+	progressChan := make(chan string, 1)
 
 	reader, writer := io.Pipe()
 
-	go func() {
+	goTracked("mongod stdout handler", func() {
 		scanner := bufio.NewScanner(reader)
 		haveSentMessage := false
+		var addresses []string
 
 		for scanner.Scan() {
 			line := scanner.Text()
 
 			log.Debugf("[Mongod stdout] %s", line)
 
+			if names, ok := parseReplicaSetNameMismatch(line); ok {
+				select {
+				case mismatchChan <- names:
+				default:
+				}
+			}
+
+			if phase, ok := parseStartupPhase(line); ok && !haveSentMessage {
+				// Only the latest phase matters, so replace any that
+				// hasn't been received yet
+				select {
+				case <-progressChan:
+				default:
+				}
+				progressChan <- phase
+			}
+
+			if address, ok := parseListeningAddress(line); ok && !haveSentMessage {
+				addresses = append(addresses, address)
+			}
+
 			if !haveSentMessage {
 				downcaseLine := strings.ToLower(line)
 
@@ -336,15 +1132,18 @@ func stdoutHandler(log *memongolog.Logger) (io.Writer, <-chan error, <-chan int)
 					if err != nil {
 						errChan <- fmt.Errorf("could not parse port from mongod log line: %s", downcaseLine)
 					} else {
-						portChan <- port
+						readyChan <- listening{port: port, addresses: addresses}
 					}
 					haveSentMessage = true
 				} else if reAlreadyInUse.MatchString(downcaseLine) {
-					errChan <- fmt.Errorf("mongod startup failed, address in use")
+					errChan <- fmt.Errorf("mongod startup failed: %w", ErrPortInUse)
 					haveSentMessage = true
 				} else if reAlreadyRunning.MatchString(downcaseLine) {
 					errChan <- fmt.Errorf("mongod startup failed, already running")
 					haveSentMessage = true
+				} else if reFilesystemLock.MatchString(downcaseLine) {
+					errChan <- fmt.Errorf("mongod startup failed: %w", unsuitableFilesystemError("", ""))
+					haveSentMessage = true
 				} else if rePermissionDenied.MatchString(downcaseLine) {
 					errChan <- fmt.Errorf("mongod startup failed, permission denied")
 					haveSentMessage = true
@@ -365,16 +1164,45 @@ func stdoutHandler(log *memongolog.Logger) (io.Writer, <-chan error, <-chan int)
 		if !haveSentMessage {
 			errChan <- fmt.Errorf("mongod exited before startup completed")
 		}
-	}()
+	})
 
-	return writer, errChan, portChan
+	return writer, errChan, readyChan, mismatchChan, progressChan
+}
+
+var (
+	reReplicaSetNameMismatchLegacy     = regexp.MustCompile(`reports set name of (\S+), but command line reports (\S+);`)
+	reReplicaSetNameMismatchStructured = regexp.MustCompile(`"localConfigSetName":"([^"]*)","commandLineSetName":"([^"]*)"`)
+)
+
+// parseReplicaSetNameMismatch returns the stored and command-line replica
+// set names if line is mongod's warning that they differ
+func parseReplicaSetNameMismatch(line string) ([2]string, bool) {
+	for _, re := range []*regexp.Regexp{reReplicaSetNameMismatchStructured, reReplicaSetNameMismatchLegacy} {
+		if match := re.FindStringSubmatch(line); match != nil {
+			return [2]string{match[1], match[2]}, true
+		}
+	}
+
+	return [2]string{}, false
+}
+
+// replicaSetNameMismatch returns an ErrReplicaSetNameMismatch error if mongod
+// reported one during startup
+func replicaSetNameMismatch(ch <-chan [2]string, dbDir string) error {
+	select {
+	case names := <-ch:
+		return fmt.Errorf("%w: the data directory %s holds replica set %q, but ReplicaSetName is %q; set ReplicaSetName to %q or remove the data directory",
+			ErrReplicaSetNameMismatch, dbDir, names[0], names[1], names[0])
+	default:
+		return nil
+	}
 }
 
 // The stderr handler just relays messages from stderr to our logger
-func stderrHandler(log *memongolog.Logger) io.Writer {
+func stderrHandler(log *memongolog.Logger) io.WriteCloser {
 	reader, writer := io.Pipe()
 
-	go func() {
+	goTracked("mongod stderr handler", func() {
 		scanner := bufio.NewScanner(reader)
 
 		for scanner.Scan() {
@@ -384,7 +1212,7 @@ func stderrHandler(log *memongolog.Logger) io.Writer {
 		if err := scanner.Err(); err != nil {
 			log.Warnf("reading mongod stdin failed: %s", err)
 		}
-	}()
+	})
 
 	return writer
 }
@@ -1,13 +1,34 @@
 package memongo_test
 
 import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/100mslive/memongo/v2"
+	"github.com/100mslive/memongo/v2/datagen"
+	"github.com/100mslive/memongo/v2/doclimit"
 	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/100mslive/memongo/v2/mongobin"
+	"github.com/100mslive/memongo/v2/replay"
 
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/v2/bson"
@@ -17,7 +38,7 @@ import (
 )
 
 func TestDefaultOptions(t *testing.T) {
-	versions := []string{"6.0.0", "7.0.0", "8.0.0"}
+	versions := []string{"4.4.0", "5.0.0", "6.0.0", "7.0.0", "8.0.0"}
 
 	for _, version := range versions {
 		t.Run(version, func(t *testing.T) {
@@ -37,7 +58,7 @@ func TestDefaultOptions(t *testing.T) {
 }
 
 func TestWithReplica(t *testing.T) {
-	versions := []string{"6.0.0", "7.0.0", "8.0.0"}
+	versions := []string{"4.4.0", "5.0.0", "6.0.0", "7.0.0", "8.0.0"}
 
 	for _, version := range versions {
 		t.Run(version, func(t *testing.T) {
@@ -49,7 +70,7 @@ func TestWithReplica(t *testing.T) {
 			require.NoError(t, err)
 			defer server.Stop()
 
-			uri := fmt.Sprintf("%s%s", server.URI(), "/retryWrites=false")
+			uri := server.ConnectionString(memongo.URIParam("retryWrites", "false"))
 			client, err := mongo.Connect(options.Client().ApplyURI(uri))
 			if err != nil {
 				t.Logf("err Connect: %v", err)
@@ -62,7 +83,7 @@ func TestWithReplica(t *testing.T) {
 }
 
 func TestWithAuth(t *testing.T) {
-	versions := []string{"6.0.0", "7.0.0", "8.0.0"}
+	versions := []string{"4.4.0", "5.0.0", "6.0.0", "7.0.0", "8.0.0"}
 
 	for _, version := range versions {
 		t.Run(version, func(t *testing.T) {
@@ -120,7 +141,7 @@ func TestWithAuth(t *testing.T) {
 }
 
 func TestWithReplicaAndAuth(t *testing.T) {
-	versions := []string{"6.0.0", "7.0.0", "8.0.0"}
+	versions := []string{"4.4.0", "5.0.0", "6.0.0", "7.0.0", "8.0.0"}
 
 	for _, version := range versions {
 		t.Run(version, func(t *testing.T) {
@@ -133,7 +154,7 @@ func TestWithReplicaAndAuth(t *testing.T) {
 			require.NoError(t, err)
 			defer server.Stop()
 
-			uri := fmt.Sprintf("%s%s", server.URI(), "/retryWrites=false")
+			uri := server.ConnectionString(memongo.URIParam("retryWrites", "false"))
 			client, err := mongo.Connect(options.Client().ApplyURI(uri))
 			if err != nil {
 				t.Logf("err Connect: %v", err)
@@ -216,6 +237,162 @@ func TestServerHelperMethods(t *testing.T) {
 	require.Contains(t, server.DBPath(), "memongo")
 }
 
+func TestDirectConnection(t *testing.T) {
+	versions := []string{"6.0.0", "7.0.0", "8.0.0"}
+
+	for _, version := range versions {
+		t.Run(version, func(t *testing.T) {
+			server, err := memongo.StartWithOptions(&memongo.Options{
+				MongoVersion:     version,
+				LogLevel:         memongolog.LogLevelWarn,
+				ShouldUseReplica: true,
+			})
+			require.NoError(t, err)
+			defer server.Stop()
+
+			require.Contains(t, server.DirectURI(), "directConnection=true")
+			require.NoError(t, server.CheckURI(server.DirectURI()))
+			require.NoError(t, server.CheckURI(server.URI()+"/?replicaSet=rs0"))
+			require.Error(t, server.CheckURI(server.URI()))
+			require.Error(t, server.CheckURI(server.URI()+"/?replicaSet=other"))
+
+			// Discovery works too, because the member is named with the same address
+			for _, uri := range []string{server.DirectURI(), server.URI(), server.URI() + "/?replicaSet=rs0"} {
+				client, err := mongo.Connect(options.Client().ApplyURI(uri))
+				require.NoError(t, err)
+				require.NoError(t, client.Ping(context.Background(), readpref.Primary()), uri)
+				require.NoError(t, client.Disconnect(context.Background()))
+			}
+
+			require.NoError(t, server.Ping(context.Background()))
+		})
+	}
+}
+
+func TestServerIntrospection(t *testing.T) {
+	tests := map[string]struct {
+		opts memongo.Options
+
+		expectedEngine string
+		expectedCaps   memongo.Capabilities
+	}{
+		"4.4 standalone": {
+			opts:           memongo.Options{MongoVersion: "4.4.0"},
+			expectedEngine: "ephemeralForTest",
+			expectedCaps:   memongo.Capabilities{Version: "4.4.0"},
+		},
+		"8.0 standalone with auth": {
+			opts:           memongo.Options{MongoVersion: "8.0.0", Auth: true},
+			expectedEngine: "wiredTiger",
+			expectedCaps:   memongo.Capabilities{Version: "8.0.0", TimeSeries: true},
+		},
+		"8.0 replica set": {
+			opts:           memongo.Options{MongoVersion: "8.0.0", ShouldUseReplica: true},
+			expectedEngine: "wiredTiger",
+			expectedCaps:   memongo.Capabilities{Version: "8.0.0", Transactions: true, ChangeStreams: true, TimeSeries: true},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			opts := test.opts
+			opts.LogLevel = memongolog.LogLevelWarn
+			server, err := memongo.StartWithOptions(&opts)
+			require.NoError(t, err)
+			server.Stop()
+
+			// Everything is recorded, so it works after Stop
+			require.Equal(t, test.opts.Auth, server.IsAuthEnabled())
+			require.False(t, server.IsTLSEnabled())
+			require.Equal(t, test.expectedEngine, server.StorageEngine())
+
+			caps, err := server.Capabilities(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, test.expectedCaps, caps)
+
+			effective := server.EffectiveOptions()
+			require.Equal(t, test.opts.MongoVersion, effective.MongoVersion)
+			require.Equal(t, server.Port(), effective.Port)
+			effective.Port = 1
+			require.NotEqual(t, 1, server.EffectiveOptions().Port)
+		})
+	}
+}
+
+func TestReplicaMemberPorts(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	require.NoError(t, l.Close())
+
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:       "8.0.0",
+		LogLevel:           memongolog.LogLevelWarn,
+		ShouldUseReplica:   true,
+		ReplicaMemberPorts: []int{port},
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	require.Equal(t, port, server.Port())
+	require.Equal(t, fmt.Sprintf("mongodb://127.0.0.1:%d", port), server.URI())
+	require.Equal(t, []string{fmt.Sprintf("mongodb://127.0.0.1:%d/?directConnection=true", port)}, server.MemberURIs())
+
+	// The pinned port is taken now
+	_, err = memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:       "8.0.0",
+		LogLevel:           memongolog.LogLevelWarn,
+		ShouldUseReplica:   true,
+		ReplicaMemberPorts: []int{port},
+	})
+	require.ErrorIs(t, err, memongo.ErrPortInUse)
+}
+
+func TestReadPreferenceTags(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:      "8.0.0",
+		LogLevel:          memongolog.LogLevelWarn,
+		ShouldUseReplica:  true,
+		ReplicaMemberTags: []map[string]string{{"workload": "analytics", "dc": "east"}},
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+
+	uri := server.URIWithReadPreference("nearest", map[string]string{"workload": "analytics", "dc": "east"})
+	require.Equal(t, fmt.Sprintf("mongodb://127.0.0.1:%d/?replicaSet=rs0&readPreference=nearest&readPreferenceTags=dc:east,workload:analytics", server.Port()), uri)
+
+	client, err := mongo.Connect(options.Client().ApplyURI(server.DirectURI()))
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+	_, err = client.Database("test").Collection("docs").InsertOne(ctx, bson.M{"_id": 1})
+	require.NoError(t, err)
+
+	// The tagged member serves reads that ask for its tags...
+	tagged, err := mongo.Connect(options.Client().ApplyURI(server.URIWithReadPreference("nearest", map[string]string{"workload": "analytics"})))
+	require.NoError(t, err)
+	defer func() {
+		_ = tagged.Disconnect(ctx)
+	}()
+	count, err := tagged.Database("test").Collection("docs").CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	// ...but not reads that ask for other tags
+	untagged, err := mongo.Connect(options.Client().
+		ApplyURI(server.URIWithReadPreference("nearest", map[string]string{"workload": "oltp"})).
+		SetServerSelectionTimeout(time.Second))
+	require.NoError(t, err)
+	defer func() {
+		_ = untagged.Disconnect(ctx)
+	}()
+	_, err = untagged.Database("test").Collection("docs").CountDocuments(ctx, bson.M{})
+	require.Error(t, err)
+}
+
 func TestServerNotReplicaSet(t *testing.T) {
 	server, err := memongo.StartWithOptions(&memongo.Options{
 		MongoVersion: "8.0.0",
@@ -243,4 +420,2285 @@ func TestWiredTigerCacheSize(t *testing.T) {
 	// Verify server starts successfully with cache size limit
 	err = server.Ping(context.Background())
 	require.NoError(t, err)
+
+	require.Equal(t, int64(256<<20), wiredTigerCacheMaxBytes(t, server))
+}
+
+func TestWiredTigerCacheSizePct(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:           "7.0.2",
+		LogLevel:               memongolog.LogLevelWarn,
+		WiredTigerCacheSizePct: 1,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	// 7.0 doesn't take a percentage, so the size is computed, no smaller
+	// than mongod's minimum
+	require.GreaterOrEqual(t, wiredTigerCacheMaxBytes(t, server), int64(256<<20))
+}
+
+func wiredTigerCacheMaxBytes(t *testing.T, server *memongo.Server) int64 {
+	ctx := context.Background()
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+
+	var status struct {
+		WiredTiger struct {
+			Cache struct {
+				MaxBytes int64 `bson:"maximum bytes configured"`
+			} `bson:"cache"`
+		} `bson:"wiredTiger"`
+	}
+	err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "serverStatus", Value: 1}}).Decode(&status)
+	require.NoError(t, err)
+
+	return status.WiredTiger.Cache.MaxBytes
+}
+
+func TestHealthHTTP(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:   "8.0.0",
+		LogLevel:       memongolog.LogLevelWarn,
+		HealthHTTPAddr: "localhost:18081",
+	})
+	require.NoError(t, err)
+
+	resp, err := http.Get("http://localhost:18081/ready")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	server.Stop()
+
+	// The listener is shut down with the server
+	_, err = http.Get("http://localhost:18081/ready")
+	require.Error(t, err)
+}
+
+func TestEvents(t *testing.T) {
+	var sunk []memongo.EventType
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:     "8.0.0",
+		LogLevel:         memongolog.LogLevelWarn,
+		ShouldUseReplica: true,
+		EventSink: func(e memongo.Event) {
+			sunk = append(sunk, e.Type)
+		},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, server.PauseMember(0))
+	require.NoError(t, server.ResumeMember(0))
+	server.Stop()
+
+	var received []memongo.EventType
+	for e := range server.Events() {
+		require.False(t, e.Time.IsZero())
+		require.Equal(t, 0, e.Member)
+		if e.Type == memongo.EventDownloadStarted || e.Type == memongo.EventDownloadFinished {
+			// Depends on the state of the cache
+			continue
+		}
+		if e.Type == memongo.EventMemberStateChanged {
+			require.Equal(t, "PRIMARY", e.State)
+		}
+		received = append(received, e.Type)
+	}
+
+	expected := []memongo.EventType{
+		memongo.EventStarting,
+		memongo.EventListening,
+		memongo.EventMemberStateChanged,
+		memongo.EventPrimaryElected,
+		memongo.EventPaused,
+		memongo.EventResumed,
+		memongo.EventStopping,
+		memongo.EventStopped,
+	}
+	require.Equal(t, expected, received)
+	require.Zero(t, server.DroppedEvents())
+	require.Subset(t, sunk, expected)
+}
+
+func TestSeed(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+		Seed: []memongo.SeedCollection{
+			{
+				Database:   "app",
+				Collection: "settings",
+				Documents:  []interface{}{bson.M{"_id": "theme", "value": "dark"}},
+			},
+		},
+		SeedDir: "testdata/seed",
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	client, err := mongo.Connect(options.Client().ApplyURI(server.URI()))
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+	db := client.Database("app")
+
+	count, err := db.Collection("settings").CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	specs, err := db.ListCollectionSpecifications(ctx, bson.M{})
+	require.NoError(t, err)
+	types := map[string]string{}
+	for _, spec := range specs {
+		types[spec.Name] = spec.Type
+	}
+	require.Equal(t, "timeseries", types["metrics"])
+
+	// The collation is case insensitive
+	count, err = db.Collection("users").CountDocuments(ctx, bson.M{"name": "BOB"})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	// The validator rejects documents without an email
+	_, err = db.Collection("users").InsertOne(ctx, bson.M{"name": "Eve"})
+	require.Error(t, err)
+
+	var stats struct {
+		Capped bool `bson:"capped"`
+	}
+	require.NoError(t, db.RunCommand(ctx, bson.D{{Key: "collStats", Value: "events"}}).Decode(&stats))
+	require.True(t, stats.Capped)
+}
+
+func TestSeedTimeSeriesNeedsNewerVersion(t *testing.T) {
+	_, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "4.4.0",
+		LogLevel:     memongolog.LogLevelWarn,
+		Seed: []memongo.SeedCollection{
+			{
+				Database:   "app",
+				Collection: "metrics",
+				Options: &memongo.SeedCollectionOptions{
+					TimeSeries: &memongo.SeedTimeSeries{TimeField: "ts"},
+				},
+			},
+		},
+	})
+	require.EqualError(t, err, "error creating collection app.metrics: timeseries requires MongoDB 5.0 or later, but the server is running 4.4.0")
+}
+
+func TestSeedGridFS(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	client, err := mongo.Connect(options.Client().ApplyURI(server.URI()))
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+
+	ids, err := server.SeedGridFS(ctx, "files", "uploads", map[string]io.Reader{
+		"a.bin": bytes.NewReader([]byte{0, 1, 2, 255}),
+	})
+	require.NoError(t, err)
+
+	bucket := client.Database("files").GridFSBucket(options.GridFSBucket().SetName("uploads"))
+	var buf bytes.Buffer
+	_, err = bucket.DownloadToStream(ctx, ids["a.bin"], &buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0, 1, 2, 255}, buf.Bytes())
+
+	dirIDs, err := server.SeedGridFSDir(ctx, "files", "testdata/gridfs")
+	require.NoError(t, err)
+	require.Len(t, dirIDs["attachments"], 2)
+
+	expected, err := os.ReadFile("testdata/gridfs/attachments/images/pixel.png")
+	require.NoError(t, err)
+	bucket = client.Database("files").GridFSBucket(options.GridFSBucket().SetName("attachments"))
+	buf.Reset()
+	_, err = bucket.DownloadToStream(ctx, dirIDs["attachments"]["images/pixel.png"], &buf)
+	require.NoError(t, err)
+	require.Equal(t, expected, buf.Bytes())
+
+	var file struct {
+		Filename string `bson:"filename"`
+		Metadata bson.M `bson:"metadata"`
+	}
+	err = bucket.GetFilesCollection().FindOne(ctx, bson.M{"_id": dirIDs["attachments"]["hello.txt"]}).Decode(&file)
+	require.NoError(t, err)
+	require.Equal(t, "hello.txt", file.Filename)
+	require.Equal(t, "ada", file.Metadata["owner"])
+}
+
+func TestCaptureCommands(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:    "8.0.0",
+		LogLevel:        memongolog.LogLevelWarn,
+		CaptureCommands: true,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+
+	again, err := server.Client(ctx)
+	require.NoError(t, err)
+	require.Same(t, client, again)
+
+	server.ResetCapturedCommands()
+
+	coll := client.Database("app").Collection("users")
+	for i := 0; i < 3; i++ {
+		_, err := coll.InsertOne(ctx, bson.M{"n": i})
+		require.NoError(t, err)
+	}
+	_, err = coll.Find(ctx, bson.M{"n": 1})
+	require.NoError(t, err)
+
+	server.AssertCommandCount(t, "insert", 3)
+	server.AssertCommandCount(t, "find", 1)
+
+	finds := server.CapturedCommands(memongo.CommandFilter{Name: "find", Database: "app"})
+	require.Len(t, finds, 1)
+	require.True(t, finds[0].Finished)
+	require.Equal(t, int32(1), finds[0].Command.Lookup("filter", "n").Int32())
+}
+
+func TestInjections(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:       "8.0.0",
+		LogLevel:           memongolog.LogLevelWarn,
+		EnableTestCommands: true,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	coll := client.Database("app").Collection("users")
+
+	// Errors are returned exactly times times
+	require.NoError(t, server.InjectCommandError(ctx, []string{"find"}, 11600, 2))
+	for i := 0; i < 2; i++ {
+		err := coll.FindOne(ctx, bson.M{}).Err()
+		var serverErr mongo.ServerError
+		require.ErrorAs(t, err, &serverErr)
+		require.True(t, serverErr.HasErrorCode(11600))
+	}
+	require.ErrorIs(t, coll.FindOne(ctx, bson.M{}).Err(), mongo.ErrNoDocuments)
+
+	// Delayed commands take longer
+	require.NoError(t, server.InjectCommandDelay(ctx, []string{"find"}, 500*time.Millisecond, 1))
+	start := time.Now()
+	require.ErrorIs(t, coll.FindOne(ctx, bson.M{}).Err(), mongo.ErrNoDocuments)
+	require.GreaterOrEqual(t, time.Since(start), 500*time.Millisecond)
+
+	// Injections can be filtered and cleared
+	require.NoError(t, server.InjectCommandError(ctx, []string{"find"}, 11600, 5, memongo.InjectionFilter{AppName: "other"}))
+	require.ErrorIs(t, coll.FindOne(ctx, bson.M{}).Err(), mongo.ErrNoDocuments)
+	require.NoError(t, server.InjectCommandError(ctx, []string{"find"}, 11600, 5))
+	require.NoError(t, server.ClearInjections(ctx))
+	require.ErrorIs(t, coll.FindOne(ctx, bson.M{}).Err(), mongo.ErrNoDocuments)
+}
+
+func TestCursorTimeout(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:  "8.0.0",
+		LogLevel:      memongolog.LogLevelWarn,
+		CursorTimeout: 500 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	timeout, err := server.CursorTimeout(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 500*time.Millisecond, timeout)
+
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	coll := client.Database("app").Collection("docs")
+	_, err = coll.InsertMany(ctx, []interface{}{bson.M{"n": 1}, bson.M{"n": 2}, bson.M{"n": 3}})
+	require.NoError(t, err)
+
+	cursor, err := coll.Find(ctx, bson.M{}, options.Find().SetBatchSize(1))
+	require.NoError(t, err)
+	require.True(t, cursor.Next(ctx))
+
+	// The cursor is reaped once it's been idle past the timeout
+	time.Sleep(2500 * time.Millisecond)
+	for cursor.Next(ctx) {
+	}
+	var serverErr mongo.ServerError
+	require.ErrorAs(t, cursor.Err(), &serverErr)
+	require.True(t, serverErr.HasErrorCode(43), "expected CursorNotFound, got %s", cursor.Err())
+}
+
+func TestEnforceMaxTimeMS(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:     "8.0.0",
+		LogLevel:         memongolog.LogLevelWarn,
+		EnforceMaxTimeMS: true,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	enforced, err := server.IsMaxTimeMSEnforced(ctx)
+	require.NoError(t, err)
+	require.True(t, enforced)
+
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	coll := client.Database("app").Collection("docs")
+
+	// Operations without maxTimeMS are unaffected
+	require.ErrorIs(t, coll.FindOne(ctx, bson.M{}).Err(), mongo.ErrNoDocuments)
+
+	err = client.Database("app").RunCommand(ctx, bson.D{{Key: "find", Value: "docs"}, {Key: "maxTimeMS", Value: 60000}}).Err()
+	var serverErr mongo.ServerError
+	require.ErrorAs(t, err, &serverErr)
+	require.True(t, serverErr.HasErrorCode(50), "expected MaxTimeMSExpired, got %s", err)
+}
+
+func TestTriggerTTLPass(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:       "8.0.0",
+		LogLevel:           memongolog.LogLevelWarn,
+		TTLMonitorInterval: time.Second,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	coll := client.Database("app").Collection("sessions")
+	_, err = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	require.NoError(t, err)
+	_, err = coll.InsertOne(ctx, bson.M{"expiresAt": time.Now().Add(-time.Minute)})
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, server.TriggerTTLPass(ctx))
+	require.Less(t, time.Since(start), 3*time.Second)
+
+	count, err := coll.CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	require.Zero(t, count, "the expired document should have been deleted")
+}
+
+func TestBuildInfo(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+		Auth:         true,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	info, err := server.BuildInfo(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "8.0.0", info.Version)
+	require.Equal(t, []int{8, 0, 0}, info.VersionArray[:3])
+	require.NotEmpty(t, info.GitVersion)
+	require.NotEmpty(t, info.Allocator)
+	require.False(t, info.Debug)
+	require.False(t, info.IsEnterprise())
+
+	size, err := server.MaxBSONObjectSize(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 16*1024*1024, size)
+}
+
+func TestIsolatedDatabase(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	const n = 200
+	t.Run("parallel", func(t *testing.T) {
+		for i := 0; i < n; i++ {
+			i := i
+			t.Run(fmt.Sprintf("test%d", i), func(t *testing.T) {
+				t.Parallel()
+				ctx := context.Background()
+
+				var db *mongo.Database
+				switch i % 3 {
+				case 0:
+					db = memongo.IsolatedDatabase(t, server)
+				case 1:
+					client, name := memongo.IsolatedClient(t, server)
+					db = client.Database(name)
+				default:
+					var name string
+					db, name = server.NewDatabase(t)
+					require.Equal(t, db.Name(), name)
+				}
+				require.True(t, strings.HasPrefix(db.Name(), "TestIsolatedDatabase_parallel_test"), db.Name())
+
+				_, err := db.Collection("docs").InsertOne(ctx, bson.M{"test": i})
+				require.NoError(t, err)
+
+				// Nothing from other tests is visible
+				count, err := db.Collection("docs").CountDocuments(ctx, bson.M{})
+				require.NoError(t, err)
+				require.Equal(t, int64(1), count)
+			})
+		}
+	})
+
+	client, err := server.Client(context.Background())
+	require.NoError(t, err)
+	names, err := client.ListDatabaseNames(context.Background(), bson.M{"name": bson.M{"$regex": "^TestIsolatedDatabase"}})
+	require.NoError(t, err)
+	require.Empty(t, names, "isolated databases should be dropped")
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	require.NoError(t, server.RecordTo(path))
+
+	commands := []bson.D{
+		{{Key: "insert", Value: "users"}, {Key: "documents", Value: bson.A{bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "ada"}}}}},
+		{{Key: "find", Value: "users"}, {Key: "filter", Value: bson.D{{Key: "name", Value: "ada"}}}},
+		{{Key: "insert", Value: "users"}, {Key: "documents", Value: bson.A{bson.D{{Key: "_id", Value: 1}}}}},
+	}
+	recorded := make([]bson.Raw, len(commands))
+	for i, cmd := range commands {
+		recorded[i], _ = client.Database("app").RunCommand(ctx, cmd).Raw()
+		recorded[i], err = replay.NormalizeReply(recorded[i])
+		require.NoError(t, err)
+	}
+	require.NoError(t, server.StopRecording())
+
+	player, err := replay.Load(path)
+	require.NoError(t, err)
+	db := player.Database("app")
+	for i, cmd := range commands {
+		reply, _ := db.RunCommand(ctx, cmd).Raw()
+		require.Equal(t, recorded[i], reply, "reply %d should replay byte for byte", i)
+	}
+	require.Zero(t, player.Remaining())
+}
+
+func TestAssertIndexes(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	_, err = client.Database("app").Collection("users").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "createdAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(3600)},
+		{Keys: bson.D{{Key: "bio", Value: "text"}}},
+	})
+	require.NoError(t, err)
+
+	ttl := int32(3600)
+	expected := map[string][]memongo.IndexSpec{
+		"app.users": {
+			{Keys: bson.D{{Key: "bio", Value: "text"}}},
+			{Keys: bson.D{{Key: "createdAt", Value: 1}}, ExpireAfterSeconds: &ttl},
+			{Keys: bson.D{{Key: "email", Value: 1}}, Unique: true},
+		},
+	}
+	server.RequireIndexes(t, expected)
+
+	expected["app.users"] = expected["app.users"][1:]
+	expected["app.orders"] = []memongo.IndexSpec{{Keys: bson.D{{Key: "userId", Value: 1}}}}
+	err = server.AssertIndexes(ctx, expected)
+	var mismatch *memongo.IndexMismatchError
+	require.ErrorAs(t, err, &mismatch)
+	require.Equal(t, []memongo.IndexDiff{
+		{Namespace: "app.orders", Kind: "missing", Index: "{userId: 1}"},
+		{Namespace: "app.users", Kind: "extra", Index: "{bio: text} bio_text"},
+	}, mismatch.Diffs)
+}
+
+func TestDocumentLimits(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	coll := client.Database("app").Collection("blobs")
+
+	require.NoError(t, doclimit.InsertAtLimit(ctx, server, coll))
+	require.Error(t, doclimit.InsertOverLimit(ctx, server, coll))
+
+	// Inserts larger than maxWriteBatchSize are split by the driver
+	result, err := coll.InsertMany(ctx, doclimit.GenerateDocuments(100001, doclimit.MinDocumentSize))
+	require.NoError(t, err)
+	require.Len(t, result.InsertedIDs, 100001)
+}
+
+func TestReadOnly(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+		ReadOnly:     true,
+		Seed: []memongo.SeedCollection{
+			{Database: "app", Collection: "users", Documents: []interface{}{bson.M{"name": "ada"}}},
+		},
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	require.True(t, server.IsReadOnly())
+	require.True(t, server.IsReplicaSet())
+
+	ctx := context.Background()
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	coll := client.Database("app").Collection("users")
+
+	// Seeded data can be read
+	count, err := coll.CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	_, err = coll.InsertOne(ctx, bson.M{"name": "grace"})
+	var serverErr mongo.ServerError
+	require.ErrorAs(t, err, &serverErr)
+	require.True(t, serverErr.HasErrorCode(10107), "expected NotWritablePrimary, got %s", err)
+}
+
+func TestEnvExport(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:     "8.0.0",
+		LogLevel:         memongolog.LogLevelWarn,
+		ShouldUseReplica: true,
+		EnvDatabase:      "app",
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	// Connect the way a process given the environment would
+	env := map[string]string{}
+	for _, v := range server.Env("WORKER_") {
+		parts := strings.SplitN(v, "=", 2)
+		env[parts[0]] = parts[1]
+	}
+	require.Equal(t, "app", env["WORKER_MONGODB_DATABASE"])
+
+	ctx := context.Background()
+	client, err := mongo.Connect(options.Client().ApplyURI(env["WORKER_MONGODB_URI"]))
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+	_, err = client.Database(env["WORKER_MONGODB_DATABASE"]).Collection("jobs").InsertOne(ctx, bson.M{"name": "resize"})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), ".env")
+	require.NoError(t, server.WriteEnvFile(path, "WORKER_"))
+	require.FileExists(t, path)
+
+	server.Stop()
+	require.NoFileExists(t, path)
+}
+
+func TestEnvExportWithAuth(t *testing.T) {
+	ctx := context.Background()
+	stack, err := memongo.StartAppStack(ctx, memongo.AppStackConfig{
+		Version:     "8.0.0",
+		AppDBName:   "orders",
+		AppUser:     "svc",
+		AppPassword: "secret",
+		Options:     &memongo.Options{LogLevel: memongolog.LogLevelWarn, EnvDatabase: "orders"},
+	})
+	require.NoError(t, err)
+	defer stack.Stop()
+	require.True(t, stack.Server.IsAuthEnabled())
+
+	env := map[string]string{}
+	for _, v := range stack.Server.Env("WORKER_") {
+		parts := strings.SplitN(v, "=", 2)
+		env[parts[0]] = parts[1]
+	}
+	require.NotEmpty(t, env["WORKER_MONGODB_USERNAME"])
+	require.NotEmpty(t, env["WORKER_MONGODB_PASSWORD"])
+
+	// A process given the environment logs in the way Server.Client does
+	clientOpts := options.Client().ApplyURI(env["WORKER_MONGODB_URI"]).SetAuth(options.Credential{
+		Username:   env["WORKER_MONGODB_USERNAME"],
+		Password:   env["WORKER_MONGODB_PASSWORD"],
+		AuthSource: "admin",
+	})
+	client, err := mongo.Connect(clientOpts)
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+	_, err = client.Database(env["WORKER_MONGODB_DATABASE"]).Collection("jobs").InsertOne(ctx, bson.M{"name": "resize"})
+	require.NoError(t, err)
+
+	// Without the credentials, it can't
+	anonymous, err := mongo.Connect(options.Client().ApplyURI(env["WORKER_MONGODB_URI"]))
+	require.NoError(t, err)
+	defer func() {
+		_ = anonymous.Disconnect(ctx)
+	}()
+	_, err = anonymous.Database("orders").Collection("jobs").InsertOne(ctx, bson.M{"name": "resize"})
+	require.Error(t, err)
+}
+
+func TestCausalConsistency(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:     "8.0.0",
+		LogLevel:         memongolog.LogLevelWarn,
+		ShouldUseReplica: true,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	coll := client.Database("app").Collection("orders")
+
+	err = server.WithCausalSession(ctx, func(ctx context.Context) error {
+		_, err := coll.InsertOne(ctx, bson.M{"_id": 1})
+		if err != nil {
+			return err
+		}
+		return coll.FindOne(ctx, bson.M{"_id": 1}).Err()
+	})
+	require.NoError(t, err)
+
+	write := func(ctx context.Context) error {
+		_, err := coll.InsertOne(ctx, bson.M{"_id": 2})
+		return err
+	}
+	read := func(ctx context.Context) (bool, error) {
+		n, err := coll.CountDocuments(ctx, bson.M{"_id": 2})
+		return n == 1, err
+	}
+	require.NoError(t, server.AssertCausalOrder(ctx, write, read))
+
+	// A read that doesn't see the write is reported
+	err = server.AssertCausalOrder(ctx, func(ctx context.Context) error {
+		_, err := coll.InsertOne(ctx, bson.M{"_id": 3})
+		return err
+	}, func(ctx context.Context) (bool, error) {
+		return false, coll.FindOne(ctx, bson.M{"_id": 3}).Err()
+	})
+	var causalErr *memongo.CausalOrderError
+	require.ErrorAs(t, err, &causalErr)
+	require.Equal(t, "rs0", causalErr.ReplicaSetName)
+}
+
+func TestLogSummaryOnly(t *testing.T) {
+	out := &bytes.Buffer{}
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:   "8.0.0",
+		Logger:         log.New(out, "", 0),
+		LogLevel:       memongolog.LogLevelDebug,
+		LogSummaryOnly: true,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 1, out.String())
+	require.Contains(t, lines[0], "mongod 8.0.0 ready at "+server.URI())
+}
+
+func TestListenAddress(t *testing.T) {
+	for _, ipv6 := range []bool{false, true} {
+		t.Run(fmt.Sprintf("EnableIPv6=%t", ipv6), func(t *testing.T) {
+			server, err := memongo.StartWithOptions(&memongo.Options{
+				MongoVersion:     "8.0.0",
+				LogLevel:         memongolog.LogLevelWarn,
+				ShouldUseReplica: true,
+				EnableIPv6:       ipv6,
+			})
+			require.NoError(t, err)
+			defer server.Stop()
+
+			// URIs use the address mongod listens on, whatever localhost
+			// resolves to
+			require.Equal(t, "127.0.0.1", server.Host())
+			require.Equal(t, fmt.Sprintf("mongodb://127.0.0.1:%d", server.Port()), server.URI())
+			require.NoError(t, server.Ping(context.Background()))
+
+			conn, err := net.DialTimeout("tcp", fmt.Sprintf("[::1]:%d", server.Port()), time.Second)
+			if ipv6 {
+				require.NoError(t, err)
+				conn.Close()
+			} else if err == nil {
+				conn.Close()
+				t.Fatal("mongod listens on ::1 without EnableIPv6")
+			}
+		})
+	}
+
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:   "8.0.0",
+		LogLevel:       memongolog.LogLevelWarn,
+		PreferHostname: true,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+	require.Equal(t, fmt.Sprintf("mongodb://localhost:%d", server.Port()), server.URI())
+}
+
+func TestConcurrentStart(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts 50 servers")
+	}
+
+	const n = 50
+	cachePath := t.TempDir()
+
+	var downloads int32
+	sink := func(e memongo.Event) {
+		if e.Type == memongo.EventDownloadStarted {
+			atomic.AddInt32(&downloads, 1)
+		}
+	}
+
+	servers := make([]*memongo.Server, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			servers[i], errs[i] = memongo.StartWithOptions(&memongo.Options{
+				MongoVersion:   "8.0.0",
+				LogLevel:       memongolog.LogLevelWarn,
+				CachePath:      cachePath,
+				StartupTimeout: time.Minute,
+				EventSink:      sink,
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	for _, server := range servers {
+		if server != nil {
+			defer server.Stop()
+		}
+	}
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+
+	ports := map[int]bool{}
+	dbPaths := map[string]bool{}
+	for _, server := range servers {
+		require.False(t, ports[server.Port()])
+		ports[server.Port()] = true
+		require.False(t, dbPaths[server.DBPath()])
+		dbPaths[server.DBPath()] = true
+
+		require.NoError(t, server.Ping(context.Background()))
+	}
+}
+
+func TestSharedOptions(t *testing.T) {
+	const n = 10
+
+	// One Options value for every server; each must get its own port
+	opts := &memongo.Options{
+		MongoVersion:   "8.0.0",
+		LogLevel:       memongolog.LogLevelWarn,
+		StartupTimeout: time.Minute,
+	}
+
+	servers := make([]*memongo.Server, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			servers[i], errs[i] = memongo.StartWithOptions(opts)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, server := range servers {
+		if server != nil {
+			defer server.Stop()
+		}
+	}
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	require.Zero(t, opts.Port, "the shared options should not be modified")
+
+	ports := map[int]bool{}
+	for _, server := range servers {
+		require.False(t, ports[server.Port()])
+		ports[server.Port()] = true
+		require.Equal(t, server.Port(), server.EffectiveOptions().Port)
+	}
+}
+
+func TestDeferReplicaSetInitiation(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:              "8.0.0",
+		LogLevel:                  memongolog.LogLevelWarn,
+		ShouldUseReplica:          true,
+		DeferReplicaSetInitiation: true,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	client, err := mongo.Connect(options.Client().ApplyURI(server.DirectURI()))
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+
+	// Not initiated yet
+	err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "replSetGetStatus", Value: 1}}).Err()
+	require.Error(t, err)
+
+	err = server.InitiateReplicaSet(ctx, memongo.ReplicaSetConfig{
+		Settings: bson.D{{Key: "electionTimeoutMillis", Value: 500}},
+	})
+	require.NoError(t, err)
+
+	var config struct {
+		Config struct {
+			Settings struct {
+				ElectionTimeoutMillis int `bson:"electionTimeoutMillis"`
+			} `bson:"settings"`
+		} `bson:"config"`
+	}
+	err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "replSetGetConfig", Value: 1}}).Decode(&config)
+	require.NoError(t, err)
+	require.Equal(t, 500, config.Config.Settings.ElectionTimeoutMillis)
+
+	// Initiating again fails
+	require.Error(t, server.InitiateReplicaSet(ctx))
+}
+
+// boundCheckingPorts leases free ports, recording whether each one was
+// bound when it was released
+type boundCheckingPorts struct {
+	mu       sync.Mutex
+	released map[int]bool
+}
+
+func (p *boundCheckingPorts) Reserve() (memongo.PortReservation, error) {
+	reservation, err := memongo.FreePorts{}.Reserve()
+	if err != nil {
+		return nil, err
+	}
+	return &boundCheckingReservation{PortReservation: reservation, ports: p}, nil
+}
+
+type boundCheckingReservation struct {
+	memongo.PortReservation
+	ports *boundCheckingPorts
+}
+
+func (r *boundCheckingReservation) Release() {
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(r.Port())))
+	if err == nil {
+		_ = l.Close()
+	}
+
+	r.ports.mu.Lock()
+	defer r.ports.mu.Unlock()
+	if _, ok := r.ports.released[r.Port()]; ok {
+		panic(fmt.Sprintf("port %d released twice", r.Port()))
+	}
+	r.ports.released[r.Port()] = err != nil
+	r.PortReservation.Release()
+}
+
+func TestPortReservationProvider(t *testing.T) {
+	ports := &boundCheckingPorts{released: map[int]bool{}}
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:            "8.0.0",
+		LogLevel:                memongolog.LogLevelWarn,
+		ShouldUseReplica:        true,
+		PortReservationProvider: ports,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	_, err = server.AddReplicaMember(context.Background(), memongo.MemberOptions{})
+	require.NoError(t, err)
+
+	// Both ports were released once mongod was listening on them
+	ports.mu.Lock()
+	defer ports.mu.Unlock()
+	require.Len(t, ports.released, 2)
+	require.Contains(t, ports.released, server.Port())
+	for port, bound := range ports.released {
+		require.True(t, bound, "port %d was released before mongod bound it", port)
+	}
+}
+
+func TestBinaryProvenance(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	provenance := server.BinaryProvenance()
+	require.Equal(t, server.StartReport().Binary, provenance)
+	require.Contains(t, []memongo.BinarySource{memongo.BinarySourceCache, memongo.BinarySourceDownload}, provenance.Source)
+	require.Contains(t, provenance.URL, "8.0.0")
+	require.Equal(t, "8.0.0", provenance.Version)
+
+	content, err := os.ReadFile(provenance.Path)
+	require.NoError(t, err)
+	sum := sha256.Sum256(content)
+	require.Equal(t, hex.EncodeToString(sum[:]), provenance.SHA256)
+
+	// The same binary given as MongodBin
+	local, err := memongo.StartWithOptions(&memongo.Options{
+		MongodBin: provenance.Path,
+		LogLevel:  memongolog.LogLevelWarn,
+	})
+	require.NoError(t, err)
+	defer local.Stop()
+
+	require.Equal(t, memongo.BinaryProvenance{
+		Source:  memongo.BinarySourceMongodBin,
+		Path:    provenance.Path,
+		SHA256:  provenance.SHA256,
+		Version: "8.0.0",
+	}, local.BinaryProvenance())
+}
+
+func TestAddReplicaMember(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:     "8.0.0",
+		LogLevel:         memongolog.LogLevelWarn,
+		ShouldUseReplica: true,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	priority := 0.0
+	index, err := server.AddReplicaMember(ctx, memongo.MemberOptions{
+		Priority:         &priority,
+		Tags:             map[string]string{"dc": "west"},
+		WaitForSecondary: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, index)
+	require.Len(t, server.MemberURIs(), 2)
+
+	// The new member has the data written to the primary
+	client, err := mongo.Connect(options.Client().ApplyURI(server.URI()))
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+	_, err = client.Database("test").Collection("things").InsertOne(ctx, bson.D{{Key: "a", Value: 1}})
+	require.NoError(t, err)
+
+	member, err := mongo.Connect(options.Client().ApplyURI(server.MemberURIs()[1]).SetReadPreference(readpref.SecondaryPreferred()))
+	require.NoError(t, err)
+	defer func() {
+		_ = member.Disconnect(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		n, err := member.Database("test").Collection("things").CountDocuments(ctx, bson.D{})
+		return err == nil && n == 1
+	}, 10*time.Second, 100*time.Millisecond)
+
+	require.NoError(t, server.RemoveReplicaMember(ctx, index))
+	require.Len(t, server.MemberURIs(), 1)
+	require.Error(t, server.RemoveReplicaMember(ctx, index))
+	require.Error(t, server.RemoveReplicaMember(ctx, 0))
+}
+
+func TestMemberIdentityAfterStepdown(t *testing.T) {
+	out := &bytes.Buffer{}
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:     "8.0.0",
+		Logger:           log.New(out, "", 0),
+		LogLevel:         memongolog.LogLevelDebug,
+		ShouldUseReplica: true,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	index, err := server.AddReplicaMember(ctx, memongo.MemberOptions{WaitForSecondary: true})
+	require.NoError(t, err)
+
+	members := server.Members()
+	require.Len(t, members, 2)
+	require.Equal(t, memongo.MemberInfo{Index: 0, Name: "rs0-m0", ID: 0, Port: server.Port(), DBPath: server.DBPath(), State: "PRIMARY"}, members[0])
+	require.Equal(t, index, members[1].Index)
+	require.Equal(t, "rs0-m1", members[1].Name)
+	require.Equal(t, 1, members[1].ID)
+	require.Equal(t, "SECONDARY", members[1].State)
+	require.True(t, strings.HasPrefix(filepath.Base(members[0].DBPath), "memongo-rs0-m0-"), members[0].DBPath)
+	require.True(t, strings.HasPrefix(filepath.Base(members[1].DBPath), "memongo-rs0-m1-"), members[1].DBPath)
+
+	// Stepping the primary down elects the other member, which Members
+	// reports on the next call
+	client, err := mongo.Connect(options.Client().ApplyURI(server.DirectURI()))
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+	_ = client.Database("admin").RunCommand(ctx, bson.D{{Key: "replSetStepDown", Value: 60}, {Key: "secondaryCatchUpPeriodSecs", Value: 10}}).Err()
+	require.Eventually(t, func() bool {
+		members := server.Members()
+		return members[0].State == "SECONDARY" && members[1].State == "PRIMARY"
+	}, 30*time.Second, 200*time.Millisecond)
+
+	server.Stop()
+	require.Contains(t, out.String(), " member=rs0-m0] [Mongod stdout]")
+	require.Contains(t, out.String(), " member=rs0-m1] [Mongod stdout]")
+}
+
+func TestReplicaMembersFailover(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:      "8.0.0",
+		LogLevel:          memongolog.LogLevelWarn,
+		ShouldUseReplica:  true,
+		ReplicaMembers:    3,
+		ReplicaMemberTags: []map[string]string{{"dc": "a"}, {"dc": "b"}, {"dc": "c"}},
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	members := server.Members()
+	require.Len(t, members, 3)
+	require.Equal(t, "PRIMARY", members[0].State)
+	var hosts []string
+	for _, member := range members {
+		hosts = append(hosts, net.JoinHostPort(server.Host(), strconv.Itoa(member.Port)))
+	}
+	require.Equal(t, "mongodb://"+strings.Join(hosts, ",")+"/?replicaSet=rs0", server.URI())
+
+	ctx := context.Background()
+	client, err := mongo.Connect(options.Client().ApplyURI(server.URI()))
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+	_, err = client.Database("test").Collection("things").InsertOne(ctx, bson.D{{Key: "a", Value: 1}})
+	require.NoError(t, err)
+
+	// Another member takes over when the primary steps down, and the URI
+	// follows it
+	_ = client.Database("admin").RunCommand(ctx, bson.D{{Key: "replSetStepDown", Value: 60}, {Key: "secondaryCatchUpPeriodSecs", Value: 10}}).Err()
+	require.Eventually(t, func() bool {
+		members := server.Members()
+		return members[0].State == "SECONDARY" && (members[1].State == "PRIMARY" || members[2].State == "PRIMARY")
+	}, 30*time.Second, 200*time.Millisecond)
+	_, err = client.Database("test").Collection("things").InsertOne(ctx, bson.D{{Key: "a", Value: 2}})
+	require.NoError(t, err)
+
+	server.Stop()
+	for _, member := range members {
+		require.NoDirExists(t, member.DBPath)
+	}
+}
+
+func TestShardedCluster(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+		Sharded:      true,
+		NumShards:    2,
+
+		AllowDirectShardAccess: true,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+	require.True(t, server.IsSharded())
+
+	ctx := context.Background()
+	require.NoError(t, server.ShardCollection(ctx, "app.orders", bson.D{{Key: "customerId", Value: "hashed"}}))
+
+	client, err := mongo.Connect(options.Client().ApplyURI(server.URI()))
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+
+	var shards struct {
+		Shards []bson.M `bson:"shards"`
+	}
+	require.NoError(t, client.Database("admin").RunCommand(ctx, bson.D{{Key: "listShards", Value: 1}}).Decode(&shards))
+	require.Len(t, shards.Shards, 2)
+
+	for i := 0; i < 100; i++ {
+		_, err = client.Database("app").Collection("orders").InsertOne(ctx, bson.D{{Key: "customerId", Value: i}})
+		require.NoError(t, err)
+	}
+	count, err := client.Database("app").Collection("orders").CountDocuments(ctx, bson.D{})
+	require.NoError(t, err)
+	require.Equal(t, int64(100), count)
+
+	// The balancer is off, so chunks only move when asked to
+	var balancer struct {
+		Mode string `bson:"mode"`
+	}
+	require.NoError(t, client.Database("admin").RunCommand(ctx, bson.D{{Key: "balancerStatus", Value: 1}}).Decode(&balancer))
+	require.Equal(t, "off", balancer.Mode)
+
+	require.NoError(t, server.ShardCollection(ctx, "app.invoices", bson.D{{Key: "number", Value: 1}}))
+	require.NoError(t, server.SplitAt(ctx, "app.invoices", bson.D{{Key: "number", Value: 50}}))
+
+	// The chunks start on the database's primary shard
+	var database struct {
+		Primary string `bson:"primary"`
+	}
+	require.NoError(t, client.Database("config").Collection("databases").FindOne(ctx, bson.D{{Key: "_id", Value: "app"}}).Decode(&database))
+	other := "shard0"
+	if database.Primary == other {
+		other = "shard1"
+	}
+	require.NoError(t, server.MoveChunk(ctx, "app.invoices", bson.D{{Key: "number", Value: 50}}, other))
+	err = server.SplitAt(ctx, "app.missing", bson.D{{Key: "number", Value: 1}})
+	require.Error(t, err)
+
+	// The config server and the shards can be read directly
+	configClient, err := server.ConfigClient(ctx)
+	require.NoError(t, err)
+	chunks, err := configClient.Database("config").Collection("chunks").CountDocuments(ctx, bson.D{})
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, chunks, int64(2))
+	configURI, err := server.ConfigServerURI()
+	require.NoError(t, err)
+	require.Contains(t, configURI, "replicaSet=cfg")
+
+	var total int64
+	require.NoError(t, server.RunOnAllShards(ctx, func(shard *memongo.Server) error {
+		shardClient, err := shard.Client(ctx)
+		if err != nil {
+			return err
+		}
+		n, err := shardClient.Database("app").Collection("orders").CountDocuments(ctx, bson.D{})
+		total += n
+		return err
+	}))
+	require.Equal(t, int64(100), total)
+
+	paths := server.DBPaths()
+	require.Len(t, paths, 4)
+	server.Stop()
+	for _, path := range paths {
+		require.NoDirExists(t, path.Path)
+	}
+}
+
+func TestShardedClusterMongosFailover(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+		Sharded:      true,
+		MongosCount:  2,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	uris := server.MongosURIs()
+	require.Len(t, uris, 2)
+	require.Len(t, strings.Split(strings.TrimPrefix(server.URI(), "mongodb://"), ","), 2)
+
+	ctx := context.Background()
+	client, err := mongo.Connect(options.Client().ApplyURI(server.URI()))
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+	_, err = client.Database("app").Collection("orders").InsertOne(ctx, bson.D{{Key: "n", Value: 1}})
+	require.NoError(t, err)
+
+	// The client carries on through the first mongos while the second is down
+	require.NoError(t, server.StopMongos(ctx, 1))
+	_, err = client.Database("app").Collection("orders").InsertOne(ctx, bson.D{{Key: "n", Value: 2}})
+	require.NoError(t, err)
+
+	require.NoError(t, server.StartMongos(ctx, 1))
+	require.Equal(t, uris, server.MongosURIs())
+	direct, err := mongo.Connect(options.Client().ApplyURI(uris[1]))
+	require.NoError(t, err)
+	defer func() {
+		_ = direct.Disconnect(ctx)
+	}()
+	count, err := direct.Database("app").Collection("orders").CountDocuments(ctx, bson.D{})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), count)
+
+	paths := server.DBPaths()
+	require.Len(t, paths, 4)
+	server.Stop()
+	for _, path := range paths {
+		require.NoDirExists(t, path.Path)
+	}
+}
+
+func TestStopReplicaSetGracefully(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:     "8.0.0",
+		LogLevel:         memongolog.LogLevelWarn,
+		ShouldUseReplica: true,
+		ReplicaMembers:   3,
+	})
+	require.NoError(t, err)
+	dbPaths := server.DBPaths()
+
+	// The members shut down cleanly without waiting out the quiesce period
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	start := time.Now()
+	require.NoError(t, server.StopWithContext(ctx))
+	require.Less(t, time.Since(start), 10*time.Second)
+
+	for _, path := range dbPaths {
+		_, err := os.Stat(path.Path)
+		require.True(t, os.IsNotExist(err), path.Path)
+	}
+	require.NoError(t, server.StopWithContext(ctx))
+}
+
+func TestStartForTest(t *testing.T) {
+	var mu sync.Mutex
+	var ports []int
+	t.Run("group", func(t *testing.T) {
+		for _, name := range []string{"first", "second"} {
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				server := memongo.StartForTest(t, &memongo.Options{MongoVersion: "8.0.0"})
+				require.NoError(t, server.Ping(context.Background()))
+				mu.Lock()
+				ports = append(ports, server.Port())
+				mu.Unlock()
+			})
+		}
+	})
+
+	require.Len(t, ports, 2)
+	require.NotEqual(t, ports[0], ports[1])
+}
+
+func TestDiskless(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:     "8.0.0",
+		LogLevel:         memongolog.LogLevelWarn,
+		ShouldUseReplica: true,
+		Diskless:         true,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	// The community build has no inMemory engine, so it's a tmpfs if there
+	// is one
+	require.NotEqual(t, memongo.DisklessInMemory, server.IsDiskless())
+	if server.IsDiskless() == memongo.DisklessTmpfs {
+		require.Equal(t, "/dev/shm", filepath.Dir(server.DBPath()))
+	}
+	require.NoError(t, server.Ping(context.Background()))
+}
+
+func TestTailOplog(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:     "8.0.0",
+		LogLevel:         memongolog.LogLevelWarn,
+		ShouldUseReplica: true,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	entries, stop, err := server.TailOplog(ctx, time.Now())
+	require.NoError(t, err)
+
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	_, err = client.Database("cdc").Collection("orders").InsertOne(ctx, bson.D{{Key: "sku", Value: "abc"}})
+	require.NoError(t, err)
+
+	for entry := range entries {
+		if entry.Lookup("ns").StringValue() == "cdc.orders" {
+			require.Equal(t, "i", entry.Lookup("op").StringValue())
+			require.Equal(t, "abc", entry.Lookup("o", "sku").StringValue())
+			break
+		}
+	}
+
+	require.NoError(t, stop())
+	require.NoError(t, stop())
+
+	_, _, err = (&memongo.Server{}).TailOplog(ctx, time.Now())
+	require.Error(t, err)
+}
+
+func TestStartRetries(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+		StartRetries: 2,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+	require.Equal(t, 1, server.StartReport().Attempts)
+
+	// Every attempt times out
+	_, err = memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:   "8.0.0",
+		LogLevel:       memongolog.LogLevelWarn,
+		StartupTimeout: time.Nanosecond,
+		StartRetries:   2,
+	})
+	require.ErrorIs(t, err, memongo.ErrStartupTimeout)
+	require.Contains(t, err.Error(), "after 3 attempts")
+}
+
+func TestDiskQuota(t *testing.T) {
+	exceeded := make(chan error, 1)
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:        "8.0.0",
+		LogLevel:            memongolog.LogLevelWarn,
+		MaxDBPathBytes:      1,
+		StopOnQuotaExceeded: true,
+		OnQuotaExceeded: func(err error) {
+			exceeded <- err
+		},
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	usage, err := server.DiskUsage()
+	require.NoError(t, err)
+	require.Greater(t, usage, int64(1))
+
+	select {
+	case err := <-exceeded:
+		require.ErrorIs(t, err, memongo.ErrDiskQuotaExceeded)
+	case <-time.After(10 * time.Second):
+		t.Fatal("quota wasn't enforced")
+	}
+
+	for event := range server.Events() {
+		if event.Type == memongo.EventStopping {
+			require.ErrorIs(t, event.Err, memongo.ErrDiskQuotaExceeded)
+		}
+	}
+	require.Greater(t, server.StartReport().DBPathBytes, int64(1))
+}
+
+func TestDataFiles(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	layout, err := server.DataFiles(ctx)
+	require.NoError(t, err)
+	require.Equal(t, server.DBPath(), layout.Root)
+	require.FileExists(t, layout.LockFile)
+	require.NotEmpty(t, layout.WiredTigerFiles)
+	for _, file := range layout.WiredTigerFiles {
+		require.FileExists(t, file)
+	}
+	require.False(t, layout.SafeToCopyWhileRunning())
+
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	require.NoError(t, client.Database("admin").RunCommand(ctx, bson.D{{Key: "fsync", Value: 1}, {Key: "lock", Value: true}}).Err())
+
+	layout, err = server.DataFiles(ctx)
+	require.NoError(t, err)
+	require.True(t, layout.SafeToCopyWhileRunning())
+
+	require.NoError(t, client.Database("admin").RunCommand(ctx, bson.D{{Key: "fsyncUnlock", Value: 1}}).Err())
+}
+
+func TestImportIntoCacheThenStartOffline(t *testing.T) {
+	// Download mongod the usual way, then import it into an empty cache
+	effective, err := (&memongo.Options{MongoVersion: "8.0.0"}).EffectiveOptions()
+	require.NoError(t, err)
+	binPath, err := mongobin.GetOrDownloadMongod(effective.DownloadURL, effective.CachePath, memongolog.New(nil, memongolog.LogLevelWarn))
+	require.NoError(t, err)
+
+	cachePath := t.TempDir()
+	cached, err := memongo.ImportIntoCache(cachePath, binPath, memongo.ImportMeta{Version: "8.0.0"})
+	require.NoError(t, err)
+
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		CachePath:    cachePath,
+		Offline:      true,
+		LogLevel:     memongolog.LogLevelWarn,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	require.True(t, server.StartReport().CacheHit)
+	require.True(t, strings.HasPrefix(cached, cachePath))
+	require.NoError(t, server.Ping(context.Background()))
+}
+
+func TestSRVURI(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:     "8.0.0",
+		LogLevel:         memongolog.LogLevelWarn,
+		ShouldUseReplica: true,
+		SRVDomain:        "memongo.test",
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		_, err := server.AddReplicaMember(ctx, memongo.MemberOptions{WaitForSecondary: true})
+		require.NoError(t, err)
+	}
+	require.Equal(t, "mongodb+srv://memongo.test/?tls=false", server.SRVURI())
+
+	client, err := mongo.Connect(server.SRVClientOptions())
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+
+	// The client finds all three members from the SRV records, and reads from
+	// secondaries
+	_, err = client.Database("app").Collection("users").InsertOne(ctx, bson.M{"name": "Alice"})
+	require.NoError(t, err)
+
+	var hello struct {
+		Hosts []string `bson:"hosts"`
+	}
+	require.NoError(t, client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello))
+	require.Len(t, hello.Hosts, 3)
+
+	secondary := client.Database("app", options.Database().SetReadPreference(readpref.Secondary()))
+	require.Eventually(t, func() bool {
+		n, err := secondary.Collection("users").CountDocuments(ctx, bson.M{})
+		return err == nil && n == 1
+	}, 10*time.Second, 100*time.Millisecond)
+}
+
+func TestSeedBulk(t *testing.T) {
+	const n = 100000
+	docs := make([]interface{}, n)
+	for i := range docs {
+		docs[i] = bson.D{{Key: "_id", Value: i}, {Key: "email", Value: fmt.Sprintf("user%d@example.com", i)}}
+	}
+
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+		Seed: []memongo.SeedCollection{{
+			Database:   "app",
+			Collection: "users",
+			Documents:  docs,
+			Indexes:    []mongo.IndexModel{{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)}},
+		}},
+		SeedBulkOptions: &memongo.SeedBulkOptions{Unordered: true, BatchSize: 5000, Workers: 4, DeferIndexes: true},
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	report := server.StartReport()
+	require.Equal(t, n, report.SeedDocuments)
+	require.Greater(t, report.SeedDocumentsPerSecond, 0.0)
+
+	ctx := context.Background()
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	users := client.Database("app").Collection("users")
+
+	count, err := users.CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	require.Equal(t, int64(n), count)
+
+	for _, i := range []int{0, 1, 4999, 5000, 54321, n - 1} {
+		var user struct {
+			Email string `bson:"email"`
+		}
+		require.NoError(t, users.FindOne(ctx, bson.M{"_id": i}).Decode(&user))
+		require.Equal(t, fmt.Sprintf("user%d@example.com", i), user.Email)
+	}
+
+	// The deferred index was created after loading
+	specs, err := users.Indexes().ListSpecifications(ctx)
+	require.NoError(t, err)
+	require.Len(t, specs, 2)
+}
+
+// leakRecorder is a testing.TB that records what VerifyNoLeaks reports
+type leakRecorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *leakRecorder) Helper() {}
+
+func (r *leakRecorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestVerifyNoLeaksAfterStop(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:     "8.0.0",
+		LogLevel:         memongolog.LogLevelWarn,
+		ShouldUseReplica: true,
+		Auth:             true,
+	})
+	require.NoError(t, err)
+
+	// Without stopping the server, mongod, its data directory and keyfile
+	// are all still around
+	recorder := &leakRecorder{TB: t}
+	memongo.VerifyNoLeaks(recorder)
+	require.Len(t, recorder.errors, 1)
+	require.Contains(t, recorder.errors[0], "goroutine: mongod process")
+	require.Contains(t, recorder.errors[0], "data directory: "+server.DBPath())
+	require.Contains(t, recorder.errors[0], "keyfile: ")
+
+	server.Stop()
+	memongo.VerifyNoLeaks(t)
+}
+
+func TestSeedCancelled(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	docs := make([]interface{}, 200000)
+	for i := range docs {
+		docs[i] = bson.M{"_id": i, "name": fmt.Sprintf("user%d", i)}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = server.SeedBulk(ctx, []memongo.SeedCollection{{
+		Database:   "app",
+		Collection: "users",
+		Documents:  docs,
+	}}, memongo.SeedBulkOptions{BatchSize: 1000, Workers: 1})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	// The half-filled collection was dropped
+	client, err := server.Client(context.Background())
+	require.NoError(t, err)
+	names, err := client.Database("app").ListCollectionNames(context.Background(), bson.M{})
+	require.NoError(t, err)
+	require.NotContains(t, names, "users")
+}
+
+func TestAddReplicaMemberCancelled(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:     "8.0.0",
+		LogLevel:         memongolog.LogLevelWarn,
+		ShouldUseReplica: true,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = server.AddReplicaMember(ctx, memongo.MemberOptions{})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	// The member's mongod was stopped, and the replica set is unchanged
+	require.Equal(t, []string{server.DirectURI()}, server.MemberURIs())
+}
+
+func TestWithClient(t *testing.T) {
+	var dbPath string
+	err := memongo.WithServer(context.Background(), &memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+	}, func(ctx context.Context, s *memongo.Server) error {
+		dbPath = s.DBPath()
+		return s.Ping(ctx)
+	})
+	require.NoError(t, err)
+	_, err = os.Stat(dbPath)
+	require.True(t, os.IsNotExist(err))
+
+	err = memongo.WithClient(context.Background(), &memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+	}, func(ctx context.Context, client *mongo.Client) error {
+		_, err := client.Database("app").Collection("users").InsertOne(ctx, bson.M{"name": "alice"})
+		return err
+	})
+	require.NoError(t, err)
+}
+
+func TestAddExternalMember(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "keyfile")
+	require.NoError(t, os.WriteFile(keyFile, []byte("c2hhcmVka2V5\n"), 0400))
+
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:      "8.0.0",
+		LogLevel:          memongolog.LogLevelWarn,
+		ShouldUseReplica:  true,
+		Auth:              true,
+		ReplicaSetKeyFile: keyFile,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	// Stands in for a mongod started by another harness: it isn't initiated,
+	// and shares the replica set name and keyfile
+	external, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:              "8.0.0",
+		LogLevel:                  memongolog.LogLevelWarn,
+		ShouldUseReplica:          true,
+		DeferReplicaSetInitiation: true,
+		Auth:                      true,
+		ReplicaSetKeyFile:         keyFile,
+	})
+	require.NoError(t, err)
+	defer external.Stop()
+	externalHost := net.JoinHostPort(external.Host(), fmt.Sprint(external.Port()))
+
+	ctx := context.Background()
+	otherKeyFile := filepath.Join(t.TempDir(), "other")
+	require.NoError(t, os.WriteFile(otherKeyFile, []byte("b3RoZXJrZXk="), 0400))
+	err = server.AddExternalMember(ctx, externalHost, memongo.ExternalMemberOptions{KeyFile: otherKeyFile})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "doesn't hold the replica set's key")
+
+	err = server.AddExternalMember(ctx, externalHost, memongo.ExternalMemberOptions{KeyFile: keyFile})
+	require.NoError(t, err)
+
+	config, err := server.ReplicaSetConfigDocument(ctx)
+	require.NoError(t, err)
+	var parsed struct {
+		Members []struct {
+			Host string `bson:"host"`
+		} `bson:"members"`
+	}
+	raw, err := bson.Marshal(config)
+	require.NoError(t, err)
+	require.NoError(t, bson.Unmarshal(raw, &parsed))
+	require.Len(t, parsed.Members, 2)
+	require.Equal(t, externalHost, parsed.Members[1].Host)
+
+	server.Stop()
+	require.FileExists(t, keyFile)
+}
+
+func TestRollbackPerTest(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:     "8.0.0",
+		LogLevel:         memongolog.LogLevelWarn,
+		ShouldUseReplica: true,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	// Seeded once, outside any test's transaction
+	client, err := server.Client(context.Background())
+	require.NoError(t, err)
+	_, err = client.Database("app").Collection("users").InsertOne(context.Background(), bson.M{"_id": "seed"})
+	require.NoError(t, err)
+
+	// Each subtest inserts the same document; it only succeeds if the
+	// previous subtest's insert was rolled back
+	for _, name := range []string{"first", "second"} {
+		t.Run(name, func(t *testing.T) {
+			db, ctx := memongo.RollbackPerTest(t, server, "app")
+			users := db.Collection("users")
+
+			count, err := users.CountDocuments(ctx, bson.M{})
+			require.NoError(t, err)
+			require.EqualValues(t, 1, count)
+
+			_, err = users.InsertOne(ctx, bson.M{"_id": "alice"})
+			require.NoError(t, err)
+			count, err = users.CountDocuments(ctx, bson.M{})
+			require.NoError(t, err)
+			require.EqualValues(t, 2, count)
+		})
+	}
+
+	count, err := client.Database("app").Collection("users").CountDocuments(context.Background(), bson.M{})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+}
+
+func TestRollingUpgrade(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		ReplicaMemberVersions: []string{"7.0.0"},
+		LogLevel:              memongolog.LogLevelWarn,
+		ShouldUseReplica:      true,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	fcv := func() string {
+		var result struct {
+			FCV struct {
+				Version string `bson:"version"`
+			} `bson:"featureCompatibilityVersion"`
+		}
+		client, err := server.Client(ctx)
+		require.NoError(t, err)
+		err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "getParameter", Value: 1}, {Key: "featureCompatibilityVersion", Value: 1}}).Decode(&result)
+		require.NoError(t, err)
+		return result.FCV.Version
+	}
+
+	_, err = server.AddReplicaMember(ctx, memongo.MemberOptions{Version: "6.0.0", WaitForSecondary: true})
+	require.NoError(t, err)
+	require.Equal(t, "6.0", fcv())
+
+	// 6.0 and 8.0 can't run in one replica set
+	_, err = server.AddReplicaMember(ctx, memongo.MemberOptions{Version: "8.0.0"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "more than one major release apart")
+
+	// Upgrade the 6.0 member, then the set is on 7.0
+	require.NoError(t, server.UpgradeMember(ctx, 1, "7.0.0"))
+	require.Equal(t, "7.0", fcv())
+
+	index, err := server.AddReplicaMember(ctx, memongo.MemberOptions{WaitForSecondary: true})
+	require.NoError(t, err)
+	require.NoError(t, server.UpgradeMember(ctx, index, "8.0.0"))
+	require.Equal(t, "7.0", fcv())
+	require.NoError(t, server.UpgradeMember(ctx, 1, "8.0.0"))
+	require.NoError(t, server.UpgradeMember(ctx, 0, "8.0.0"))
+	require.Equal(t, "8.0", fcv())
+
+	caps, err := server.Capabilities(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "8.0.0", caps.Version)
+}
+
+func TestStartAppStack(t *testing.T) {
+	versions := []string{"4.4.0", "5.0.0", "6.0.0", "7.0.0", "8.0.0"}
+
+	for _, version := range versions {
+		t.Run(version, func(t *testing.T) {
+			ctx := context.Background()
+			stack, err := memongo.StartAppStack(ctx, memongo.AppStackConfig{
+				Version:     version,
+				AppDBName:   "orders",
+				AppUser:     "svc",
+				AppPassword: "secret",
+				Options:     &memongo.Options{LogLevel: memongolog.LogLevelWarn},
+			})
+			require.NoError(t, err)
+			defer stack.Stop()
+
+			require.True(t, stack.Server.IsAuthEnabled())
+			require.True(t, stack.Server.IsReplicaSet())
+
+			// The app user can write to its database in a transaction
+			coll := stack.Client.Database("orders").Collection("items")
+			_, err = coll.InsertOne(ctx, bson.D{{Key: "sku", Value: "a"}})
+			require.NoError(t, err)
+
+			sess, err := stack.Client.StartSession()
+			require.NoError(t, err)
+			defer sess.EndSession(ctx)
+			_, err = sess.WithTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+				return coll.InsertOne(ctx, bson.D{{Key: "sku", Value: "b"}})
+			})
+			require.NoError(t, err)
+
+			// but not to others
+			_, err = stack.Client.Database("other").Collection("items").InsertOne(ctx, bson.D{})
+			require.Error(t, err)
+
+			// The root user can
+			admin, err := mongo.Connect(options.Client().ApplyURI(stack.AdminURI))
+			require.NoError(t, err)
+			defer func() {
+				_ = admin.Disconnect(ctx)
+			}()
+			_, err = admin.Database("other").Collection("items").InsertOne(ctx, bson.D{})
+			require.NoError(t, err)
+
+			// Unauthenticated clients can't
+			anonymous, err := mongo.Connect(options.Client().ApplyURI(stack.Server.DirectURI()))
+			require.NoError(t, err)
+			defer func() {
+				_ = anonymous.Disconnect(ctx)
+			}()
+			_, err = anonymous.Database("orders").Collection("items").CountDocuments(ctx, bson.D{})
+			require.Error(t, err)
+
+			// The server's own client authenticates as root
+			client, err := stack.Server.Client(ctx)
+			require.NoError(t, err)
+			count, err := client.Database("other").Collection("items").CountDocuments(ctx, bson.D{})
+			require.NoError(t, err)
+			require.Equal(t, int64(1), count)
+
+			// The transaction check cleans up after itself
+			names, err := admin.Database("orders").ListCollectionNames(ctx, bson.D{})
+			require.NoError(t, err)
+			require.Equal(t, []string{"items"}, names)
+
+			stack.Stop()
+			stack.Stop()
+		})
+	}
+}
+
+func TestRedactionWithAuth(t *testing.T) {
+	const sentinel = "s3ntinel-hunter2"
+
+	var out bytes.Buffer
+	ctx := context.Background()
+	stack, err := memongo.StartAppStack(ctx, memongo.AppStackConfig{
+		Version:      "8.0.0",
+		AppDBName:    "orders",
+		AppUser:      "svc",
+		AppPassword:  sentinel,
+		RootPassword: sentinel + "-root",
+		Options: &memongo.Options{
+			Logger:   log.New(&out, "", 0),
+			LogLevel: memongolog.LogLevelDebug,
+		},
+	})
+	require.NoError(t, err)
+	defer stack.Stop()
+
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	require.NoError(t, stack.Server.RecordTo(path))
+	client, err := stack.Server.Client(ctx)
+	require.NoError(t, err)
+	// server.Client isn't authenticated, so this fails, but it's recorded
+	_ = client.Database("orders").RunCommand(ctx, bson.D{
+		{Key: "createUser", Value: "reporter"},
+		{Key: "pwd", Value: sentinel},
+		{Key: "roles", Value: bson.A{"read"}},
+	}).Err()
+	require.NoError(t, stack.Server.StopRecording())
+
+	recording, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(recording), "reporter")
+	require.NotContains(t, string(recording), sentinel)
+	require.NotContains(t, out.String(), sentinel)
+	require.NotContains(t, stack.Server.String(), sentinel)
+	require.NotContains(t, memongo.RedactURI(stack.AdminURI), sentinel)
+}
+
+func TestCompactReclaimsSpace(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	ctx := context.Background()
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	coll := client.Database("app").Collection("events")
+
+	payload := strings.Repeat("x", 4096)
+	docs := make([]interface{}, 1000)
+	for i := range docs {
+		docs[i] = bson.D{{Key: "payload", Value: payload}}
+	}
+	for i := 0; i < 10; i++ {
+		_, err = coll.InsertMany(ctx, docs)
+		require.NoError(t, err)
+	}
+	_, err = coll.DeleteMany(ctx, bson.D{})
+	require.NoError(t, err)
+
+	// Checkpoint, so the deleted documents' space is free on disk
+	require.NoError(t, client.Database("admin").RunCommand(ctx, bson.D{{Key: "fsync", Value: 1}}).Err())
+	before, err := server.DatabaseSizes(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, server.Compact(ctx, "app", "events"))
+	require.NoError(t, client.Database("admin").RunCommand(ctx, bson.D{{Key: "fsync", Value: 1}}).Err())
+
+	after, err := server.DatabaseSizes(ctx)
+	require.NoError(t, err)
+	require.Less(t, after["app"], before["app"]/2, "compact should free most of the %d bytes", before["app"])
+}
+
+func TestSeedGenerated(t *testing.T) {
+	const users, orders = 200000, 50000
+	generated := []memongo.GeneratedCollection{
+		{
+			Database:   "app",
+			Collection: "users",
+			Count:      users,
+			Schema: datagen.Schema{
+				{Name: "_id", Spec: datagen.ObjectID{}},
+				{Name: "email", Spec: datagen.StringPattern{Pattern: "user-********@example.com"}},
+				{Name: "age", Spec: datagen.IntRange{Min: 18, Max: 90}},
+			},
+			Indexes: []mongo.IndexModel{{Keys: bson.D{{Key: "email", Value: 1}}}},
+		},
+		{
+			Database:   "app",
+			Collection: "orders",
+			Count:      orders,
+			Schema: datagen.Schema{
+				{Name: "_id", Spec: datagen.Sequence{Start: 1}},
+				{Name: "user", Spec: datagen.Ref{Collection: "app.users"}},
+			},
+		},
+	}
+
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:    "8.0.0",
+		LogLevel:        memongolog.LogLevelWarn,
+		SeedGenerated:   generated,
+		GeneratorSeed:   42,
+		SeedBulkOptions: &memongo.SeedBulkOptions{Unordered: true, DeferIndexes: true},
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	require.Equal(t, users+orders, server.StartReport().SeedDocuments)
+
+	ctx := context.Background()
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	db := client.Database("app")
+
+	count, err := db.Collection("users").CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	require.Equal(t, int64(users), count)
+	count, err = db.Collection("orders").CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	require.Equal(t, int64(orders), count)
+
+	indexes, err := db.Collection("users").Indexes().ListSpecifications(ctx)
+	require.NoError(t, err)
+	require.Len(t, indexes, 2)
+
+	// Every order refers to a generated user
+	missing, err := db.Collection("orders").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "users"}, {Key: "localField", Value: "user"}, {Key: "foreignField", Value: "_id"}, {Key: "as", Value: "users"}}}},
+		{{Key: "$match", Value: bson.D{{Key: "users", Value: bson.A{}}}}},
+	})
+	require.NoError(t, err)
+	require.False(t, missing.Next(ctx))
+
+	// Seeding again from the same seed gives the same documents
+	require.NoError(t, server.SeedGenerated(ctx, 42, []memongo.GeneratedCollection{{
+		Database:   "again",
+		Collection: "users",
+		Count:      1000,
+		Schema:     generated[0].Schema,
+	}}))
+	cursor, err := client.Database("again").Collection("users").Find(ctx, bson.M{})
+	require.NoError(t, err)
+	var again []bson.M
+	require.NoError(t, cursor.All(ctx, &again))
+	require.Len(t, again, 1000)
+	for _, user := range again {
+		var original bson.M
+		require.NoError(t, db.Collection("users").FindOne(ctx, bson.M{"_id": user["_id"]}).Decode(&original))
+		require.Equal(t, original, user)
+	}
+}
+
+func TestSubtestServer(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{MongoVersion: "8.0.0", LogLevel: memongolog.LogLevelWarn})
+	require.NoError(t, err)
+
+	t.Run("parent", func(t *testing.T) {
+		// Runs before the parallel subtests
+		defer func() {
+			require.NoError(t, server.Release())
+		}()
+
+		for i := 0; i < 4; i++ {
+			i := i
+			t.Run(fmt.Sprint(i), func(t *testing.T) {
+				server := memongo.SubtestServer(t, server)
+				t.Parallel()
+
+				ctx := context.Background()
+				client, err := server.Client(ctx)
+				require.NoError(t, err)
+				_, err = client.Database("app").Collection("items").InsertOne(ctx, bson.M{"subtest": i})
+				require.NoError(t, err)
+			})
+		}
+	})
+
+	_, err = server.Client(context.Background())
+	require.ErrorIs(t, err, memongo.ErrServerStopped)
+	require.ErrorIs(t, server.Ping(context.Background()), memongo.ErrServerStopped)
+}
+
+func TestDBPaths(t *testing.T) {
+	for _, policy := range []memongo.CleanupPolicy{memongo.CleanupAlways, memongo.CleanupNever} {
+		server, err := memongo.StartWithOptions(&memongo.Options{
+			MongoVersion:     "8.0.0",
+			LogLevel:         memongolog.LogLevelWarn,
+			ShouldUseReplica: true,
+			Cleanup:          memongo.Cleanup{RemoveDBPath: policy},
+		})
+		require.NoError(t, err)
+
+		ctx := context.Background()
+		_, err = server.AddReplicaMember(ctx, memongo.MemberOptions{WaitForSecondary: true})
+		require.NoError(t, err)
+		_, err = server.AddReplicaMember(ctx, memongo.MemberOptions{Hidden: true, WaitForSecondary: true})
+		require.NoError(t, err)
+
+		paths := server.DBPaths()
+		require.Len(t, paths, 3)
+		require.Equal(t, server.DBPath(), paths[0].Path)
+		require.Equal(t, []string{"primary", "secondary", "hidden"}, []string{paths[0].Role, paths[1].Role, paths[2].Role})
+		for _, p := range paths {
+			require.DirExists(t, p.Path)
+		}
+
+		server.Stop()
+		for _, p := range paths {
+			if policy == memongo.CleanupNever {
+				require.DirExists(t, p.Path)
+				require.NoError(t, os.RemoveAll(p.Path))
+			} else {
+				require.NoDirExists(t, p.Path)
+			}
+		}
+	}
+}
+
+// TestMongodSignalHelper runs in a child process for
+// TestHandleSignalsWithMongod: it starts a server, prints its port and waits
+// to be interrupted
+func TestMongodSignalHelper(t *testing.T) {
+	if os.Getenv("MEMONGO_MONGOD_SIGNAL_HELPER") == "" {
+		t.Skip("only runs as TestHandleSignalsWithMongod's child process")
+	}
+
+	memongo.HandleSignals()
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+	})
+	require.NoError(t, err)
+
+	fmt.Printf("port=%d\n", server.Port())
+	time.Sleep(time.Minute)
+	t.Fatal("not interrupted")
+}
+
+func TestHandleSignalsWithMongod(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run", "^TestMongodSignalHelper$")
+	cmd.Env = append(os.Environ(), "MEMONGO_MONGOD_SIGNAL_HELPER=1")
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	defer func() {
+		_ = cmd.Process.Kill()
+	}()
+
+	port := ""
+	scanner := bufio.NewScanner(stdout)
+	for port == "" && scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "port=") {
+			port = strings.TrimPrefix(scanner.Text(), "port=")
+		}
+	}
+	require.NotEmpty(t, port)
+
+	require.NoError(t, cmd.Process.Signal(os.Interrupt))
+	require.Error(t, cmd.Wait())
+
+	// mongod was stopped before the child died
+	_, err = net.DialTimeout("tcp", "localhost:"+port, time.Second)
+	require.Error(t, err)
+}
+
+func TestAdminUI(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion: "8.0.0",
+		LogLevel:     memongolog.LogLevelWarn,
+		AdminUIAddr:  "127.0.0.1:0",
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	client, err := server.Client(context.Background())
+	require.NoError(t, err)
+	_, err = client.Database("shop").Collection("orders").InsertOne(context.Background(), bson.D{{Key: "item", Value: "pen"}})
+	require.NoError(t, err)
+
+	adminURL := server.AdminUIURL()
+	require.Contains(t, adminURL, "token=")
+
+	resp, err := http.Get(adminURL)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, string(body), "orders: 1 documents")
+
+	// Without the token
+	resp, err = http.Get(strings.Split(adminURL, "?")[0])
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestDebugBundleWithMongod(t *testing.T) {
+	server, err := memongo.StartWithOptions(&memongo.Options{
+		MongoVersion:     "8.0.0",
+		LogLevel:         memongolog.LogLevelWarn,
+		ShouldUseReplica: true,
+		CaptureCommands:  true,
+	})
+	require.NoError(t, err)
+	defer server.Stop()
+
+	client, err := server.Client(context.Background())
+	require.NoError(t, err)
+	err = client.Database("admin").RunCommand(context.Background(), bson.D{
+		{Key: "createUser", Value: "ada"},
+		{Key: "pwd", Value: "bundle-hunter2"},
+		{Key: "roles", Value: bson.A{}},
+	}).Err()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	require.NoError(t, server.WriteDebugBundle(context.Background(), path))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	files := map[string]string{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[header.Name] = string(data)
+	}
+
+	for _, name := range []string{"options.txt", "start-report.json", "mongod.log", "startup-warnings.json", "server-status.json", "repl-set-status.json", "commands.jsonl"} {
+		require.Contains(t, files, name)
+	}
+	require.NotContains(t, files, "errors.txt")
+	require.Contains(t, files["commands.jsonl"], "createUser")
+	for name, data := range files {
+		require.NotContains(t, data, "bundle-hunter2", name)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	for _, replicaSet := range []bool{false, true} {
+		t.Run(fmt.Sprintf("replica set %t", replicaSet), func(t *testing.T) {
+			server := memongo.StartForTest(t, &memongo.Options{
+				MongoVersion:     "8.0.0",
+				ShouldUseReplica: replicaSet,
+			})
+			ctx := context.Background()
+			uri := server.ConnectionString()
+			client, err := mongo.Connect(options.Client().ApplyURI(uri))
+			require.NoError(t, err)
+			defer func() {
+				_ = client.Disconnect(ctx)
+			}()
+
+			_, err = client.Database("app").Collection("orders").InsertOne(ctx, bson.D{{Key: "_id", Value: 1}})
+			require.NoError(t, err)
+
+			require.NoError(t, server.Restart(ctx))
+			require.Equal(t, uri, server.ConnectionString())
+
+			// The existing client reconnects, and the data is still there
+			count, err := client.Database("app").Collection("orders").CountDocuments(ctx, bson.D{})
+			require.NoError(t, err)
+			require.Equal(t, int64(1), count)
+			require.NoError(t, server.Ping(ctx))
+		})
+	}
+}
+
+func TestDataDir(t *testing.T) {
+	dir := t.TempDir()
+	server := memongo.StartForTest(t, &memongo.Options{MongoVersion: "8.0.0", DataDir: dir})
+	require.Equal(t, dir, filepath.Dir(server.DBPath()))
+	require.NoError(t, server.Ping(context.Background()))
+
+	_, err := memongo.StartWithOptions(&memongo.Options{MongoVersion: "8.0.0", DataDir: filepath.Join(dir, "missing")})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "doesn't exist")
 }
@@ -0,0 +1,67 @@
+package memongolog
+
+import "sync"
+
+// buffer holds the lines written by a buffered logger, and the loggers
+// derived from it, until they're flushed or discarded
+type buffer struct {
+	mu      sync.Mutex
+	lines   []string
+	holding bool
+}
+
+// hold keeps line if the buffer is still holding lines, and returns whether
+// it did
+func (b *buffer) hold(line string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.holding {
+		return false
+	}
+
+	b.lines = append(b.lines, line)
+	return true
+}
+
+// release stops holding lines, and returns the ones held
+func (b *buffer) release() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lines := b.lines
+	b.lines = nil
+	b.holding = false
+
+	return lines
+}
+
+// Buffered returns a logger that holds its messages, and those of the loggers
+// derived from it with With or WithServer, instead of writing them, until Flush or
+// Discard is called. Messages are formatted when they're logged, so their
+// timestamps aren't affected.
+func (l *Logger) Buffered() *Logger {
+	c := *l
+	c.buffer = &buffer{holding: true}
+	return &c
+}
+
+// Flush writes the messages held by a buffered logger, and makes it write
+// messages straight away from then on
+func (l *Logger) Flush() {
+	if l.buffer == nil {
+		return
+	}
+
+	l.out.print(l.buffer.release()...)
+}
+
+// Discard drops the messages held by a buffered logger, and makes it write
+// messages straight away from then on
+func (l *Logger) Discard() {
+	if l.buffer == nil {
+		return
+	}
+
+	l.buffer.release()
+}
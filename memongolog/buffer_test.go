@@ -0,0 +1,50 @@
+package memongolog
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferedFlush(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := New(log.New(out, "", 0), LogLevelDebug).Buffered()
+	server := logger.WithServer("1")
+
+	logger.Infof("starting")
+	server.Debugf("waiting")
+	assert.Empty(t, out.String())
+
+	logger.Flush()
+	assert.Equal(t, "[memongo] [INFO]  starting\n[memongo] [DEBUG] [server=1] waiting\n", out.String())
+
+	// Once flushed, messages are written straight away
+	server.Warnf("late")
+	assert.Equal(t, "[memongo] [INFO]  starting\n[memongo] [DEBUG] [server=1] waiting\n[memongo] [WARN]  [server=1] late\n", out.String())
+}
+
+func TestBufferedDiscard(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := New(log.New(out, "", 0), LogLevelInfo).Buffered()
+
+	logger.Infof("starting")
+	logger.Debugf("below the level")
+	logger.Discard()
+	assert.Empty(t, out.String())
+
+	logger.Infof("ready")
+	logger.Flush()
+	assert.Equal(t, "[memongo] [INFO]  ready\n", out.String())
+}
+
+func TestUnbufferedFlush(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := New(log.New(out, "", 0), LogLevelInfo)
+
+	logger.Flush()
+	logger.Discard()
+	logger.Infof("message")
+	assert.Equal(t, "[memongo] [INFO]  message\n", out.String())
+}
@@ -0,0 +1,172 @@
+package memongolog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Format is how a Logger writes messages
+type Format int
+
+const (
+	// FormatAuto is FormatPretty when logging to a terminal, and FormatText
+	// otherwise
+	FormatAuto Format = iota
+
+	// FormatText writes plain lines like "[memongo] [INFO]  message"
+	FormatText
+
+	// FormatPretty writes compact, colored lines with timestamps relative to
+	// when the logger was created, for reading in a terminal
+	FormatPretty
+
+	// FormatJSON writes one JSON object per line, with the fields time,
+	// level, component, server and msg, for CI logs
+	FormatJSON
+)
+
+// ParseFormat parses a format name: "auto", "text", "pretty" or "json"
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "auto":
+		return FormatAuto, nil
+	case "text":
+		return FormatText, nil
+	case "pretty":
+		return FormatPretty, nil
+	case "json":
+		return FormatJSON, nil
+	default:
+		return 0, fmt.Errorf("unknown log format %q: must be auto, text, pretty or json", s)
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// levelNames are the names of the levels in the pretty and JSON formats
+var levelNames = map[LogLevel]string{
+	LogLevelDebug: "debug",
+	LogLevelInfo:  "info",
+	LogLevelWarn:  "warn",
+}
+
+// levelColors are the ANSI colors of the levels in the pretty format
+var levelColors = map[LogLevel]string{
+	LogLevelDebug: "\x1b[90m",
+	LogLevelInfo:  "\x1b[36m",
+	LogLevelWarn:  "\x1b[33m",
+}
+
+const colorReset = "\x1b[0m"
+
+// jsonTimeFormat is RFC 3339 with a fixed number of fractional digits, so
+// times line up and sort as strings
+const jsonTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// jsonLine is a message in the JSON format. The field order is part of the
+// format.
+type jsonLine struct {
+	Time      string            `json:"time"`
+	Level     string            `json:"level"`
+	Component string            `json:"component"`
+	Server    string            `json:"server"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	Msg       string            `json:"msg"`
+}
+
+func (l *Logger) write(level LogLevel, msg string) {
+	if l.redact != nil {
+		msg = l.redact(msg)
+	}
+
+	var line string
+	switch l.format {
+	case FormatPretty:
+		line = l.pretty(level, msg)
+	case FormatJSON:
+		line = l.json(level, msg)
+	default:
+		line = l.text(level, msg)
+	}
+
+	if l.buffer != nil && l.buffer.hold(line) {
+		return
+	}
+	l.out.print(line)
+}
+
+// fieldsText returns the logger's server and fields as "key=value" pairs
+func (l *Logger) fieldsText() string {
+	pairs := make([]string, 0, len(l.fields)+1)
+	if l.server != "" {
+		pairs = append(pairs, "server="+l.server)
+	}
+	for _, f := range l.fields {
+		pairs = append(pairs, f.key+"="+f.value)
+	}
+
+	return strings.Join(pairs, " ")
+}
+
+func (l *Logger) text(level LogLevel, msg string) string {
+	if fields := l.fieldsText(); fields != "" {
+		msg = "[" + fields + "] " + msg
+	}
+
+	switch level {
+	case LogLevelDebug:
+		return "[memongo] [DEBUG] " + msg
+	case LogLevelInfo:
+		return "[memongo] [INFO]  " + msg
+	default:
+		return "[memongo] [WARN]  " + msg
+	}
+}
+
+func (l *Logger) pretty(level LogLevel, msg string) string {
+	elapsed := l.now().Sub(l.start).Truncate(time.Millisecond)
+
+	source := l.component
+	if l.server != "" {
+		source += "#" + l.server
+	}
+	for _, f := range l.fields {
+		source += " " + f.key + "=" + f.value
+	}
+
+	return fmt.Sprintf("%s%-5s%s +%.3fs %s %s", levelColors[level], strings.ToUpper(levelNames[level]), colorReset, elapsed.Seconds(), source, msg)
+}
+
+func (l *Logger) json(level LogLevel, msg string) string {
+	var fields map[string]string
+	if len(l.fields) > 0 {
+		fields = make(map[string]string, len(l.fields))
+		for _, f := range l.fields {
+			fields[f.key] = f.value
+		}
+	}
+
+	line, err := json.Marshal(jsonLine{
+		Time:      l.now().UTC().Format(jsonTimeFormat),
+		Level:     levelNames[level],
+		Component: l.component,
+		Server:    l.server,
+		Fields:    fields,
+		Msg:       msg,
+	})
+	if err != nil {
+		return l.text(level, msg)
+	}
+
+	return string(line)
+}
@@ -0,0 +1,87 @@
+package memongolog
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// fixedClockLogger returns a logger writing to out whose clock starts at a
+// fixed time and advances by 1.5s per message
+func fixedClockLogger(out *bytes.Buffer, format Format) *Logger {
+	logger := NewWithFormat(log.New(out, "", 0), LogLevelDebug, format)
+
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	logger.start = now
+	logger.now = func() time.Time {
+		now = now.Add(1500 * time.Millisecond)
+		return now
+	}
+
+	return logger
+}
+
+func logSamples(logger *Logger) {
+	logger.Debugf("Using binary %s", "/cache/mongod")
+	logger.WithServer("1").Infof("Started on port %d", 27017)
+	logger.WithServer("1").Warnf("error removing data directory: %s", `"quoted" path`)
+	logger.WithServer("2").With("download", "mongodb.tgz").Infof("Downloading")
+}
+
+func TestFormatJSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	logSamples(fixedClockLogger(out, FormatJSON))
+
+	golden := filepath.Join("testdata", "json.golden")
+	if *update {
+		require.NoError(t, os.WriteFile(golden, out.Bytes(), 0600))
+	}
+
+	expected, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), out.String())
+}
+
+func TestFormatPretty(t *testing.T) {
+	out := &bytes.Buffer{}
+	logSamples(fixedClockLogger(out, FormatPretty))
+
+	assert.Equal(t, ""+
+		"\x1b[90mDEBUG\x1b[0m +1.500s memongo Using binary /cache/mongod\n"+
+		"\x1b[36mINFO \x1b[0m +3.000s memongo#1 Started on port 27017\n"+
+		"\x1b[33mWARN \x1b[0m +4.500s memongo#1 error removing data directory: \"quoted\" path\n"+
+		"\x1b[36mINFO \x1b[0m +6.000s memongo#2 download=mongodb.tgz Downloading\n",
+		out.String())
+}
+
+func TestFormatText(t *testing.T) {
+	out := &bytes.Buffer{}
+	logSamples(fixedClockLogger(out, FormatAuto))
+
+	assert.Equal(t, ""+
+		"[memongo] [DEBUG] Using binary /cache/mongod\n"+
+		"[memongo] [INFO]  [server=1] Started on port 27017\n"+
+		"[memongo] [WARN]  [server=1] error removing data directory: \"quoted\" path\n"+
+		"[memongo] [INFO]  [server=2 download=mongodb.tgz] Downloading\n",
+		out.String())
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, format := range map[string]Format{"auto": FormatAuto, "text": FormatText, "Pretty": FormatPretty, " json ": FormatJSON} {
+		parsed, err := ParseFormat(name)
+		require.NoError(t, err)
+		assert.Equal(t, format, parsed)
+	}
+
+	_, err := ParseFormat("xml")
+	assert.Error(t, err)
+}
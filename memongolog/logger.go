@@ -1,8 +1,11 @@
 package memongolog
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"sync"
+	"time"
 )
 
 // LogLevel is a logging vebosity level
@@ -24,16 +27,75 @@ const (
 
 const defaultLogLevel = LogLevelInfo
 
-// Logger is a logger that filters by log level
+// sink is where a logger, and the loggers derived from it, write their
+// messages. mu keeps the messages released by Flush together.
+type sink struct {
+	mu  sync.Mutex
+	out *log.Logger
+}
+
+func (s *sink) print(lines ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, line := range lines {
+		s.out.Print(line)
+	}
+}
+
+// stdout is the sink of loggers created without an output, shared so that
+// the messages of several servers logging to stdout don't interleave
+var stdout = &sink{out: log.New(os.Stdout, "", 0)}
+
+// field is a key and value added to messages by With
+type field struct {
+	key   string
+	value string
+}
+
+// Logger is a logger that filters by log level. It's safe for concurrent
+// use, and each message is written as a single line with a single Write, so
+// the messages of loggers sharing an output never interleave.
 type Logger struct {
-	level LogLevel
-	out   *log.Logger
+	level  LogLevel
+	out    *sink
+	format Format
+
+	// component, server and fields identify where messages come from
+	component string
+	server    string
+	fields    []field
+
+	// start is what pretty timestamps are relative to
+	start time.Time
+	now   func() time.Time
+
+	// buffer, if set, holds messages until they're flushed or discarded
+	buffer *buffer
+
+	// redact, if set, rewrites messages before they're written
+	redact func(string) string
 }
 
 // New constructs a new logger
 func New(out *log.Logger, level LogLevel) *Logger {
-	if out == nil {
-		out = log.New(os.Stdout, "", 0)
+	return NewWithFormat(out, level, FormatAuto)
+}
+
+// NewWithFormat constructs a new logger that writes messages in the given
+// format. FormatAuto picks FormatPretty if out is nil and stdout is a
+// terminal, and FormatText otherwise.
+func NewWithFormat(out *log.Logger, level LogLevel, format Format) *Logger {
+	if format == FormatAuto {
+		format = FormatText
+		if out == nil && isTerminal(os.Stdout) {
+			format = FormatPretty
+		}
+	}
+
+	dest := stdout
+	if out != nil {
+		dest = &sink{out: out}
 	}
 
 	if level == 0 {
@@ -41,28 +103,62 @@ func New(out *log.Logger, level LogLevel) *Logger {
 	}
 
 	return &Logger{
-		level: level,
-		out:   out,
+		level:     level,
+		out:       dest,
+		format:    format,
+		component: "memongo",
+		start:     time.Now(),
+		now:       time.Now,
+	}
+}
+
+// With returns a logger that adds key=value to its messages, e.g. to tell
+// apart the output of concurrent downloads. It writes to the same output as
+// l, and holds its messages while l does.
+func (l *Logger) With(key string, value string) *Logger {
+	c := *l
+	if key == "server" {
+		c.server = value
+		return &c
 	}
+
+	c.fields = append(l.fields[:len(l.fields):len(l.fields)], field{key: key, value: value})
+	return &c
+}
+
+// WithRedaction returns a logger that passes its messages through redact
+// before writing them, e.g. to mask passwords. It writes to the same output
+// as l, and holds its messages while l does.
+func (l *Logger) WithRedaction(redact func(string) string) *Logger {
+	c := *l
+	c.redact = redact
+	return &c
+}
+
+// WithServer returns a logger that tags its messages with a server ID, so
+// the output of several servers can be told apart. It's the same as
+// With("server", id).
+func (l *Logger) WithServer(id string) *Logger {
+	return l.With("server", id)
 }
 
 // Debugf logs at the debug level
 func (l *Logger) Debugf(format string, v ...interface{}) {
 	if l.level <= LogLevelDebug {
-		l.out.Printf("[memongo] [DEBUG] "+format, v...)
+		l.write(LogLevelDebug, fmt.Sprintf(format, v...))
 	}
 }
 
 // Infof logs at the info level
 func (l *Logger) Infof(format string, v ...interface{}) {
 	if l.level <= LogLevelInfo {
-		l.out.Printf("[memongo] [INFO]  "+format, v...)
+		l.write(LogLevelInfo, fmt.Sprintf(format, v...))
 	}
 }
 
 // Warnf logs at the warning level
 func (l *Logger) Warnf(format string, v ...interface{}) {
 	if l.level <= LogLevelWarn {
-		l.out.Printf("[memongo] [WARN]  "+format, v...)
+		l.write(LogLevelWarn, fmt.Sprintf(format, v...))
 	}
 }
@@ -3,6 +3,10 @@ package memongolog
 import (
 	"bytes"
 	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -188,3 +192,70 @@ func TestLogger(t *testing.T) {
 		})
 	}
 }
+
+// writeRecorder records each Write separately
+type writeRecorder struct {
+	mu     sync.Mutex
+	writes []string
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func TestConcurrentLogging(t *testing.T) {
+	rec := &writeRecorder{}
+	logger := New(log.New(rec, "", log.Lmicroseconds), LogLevelInfo)
+	buffered := logger.Buffered()
+
+	const servers, messages = 20, 50
+	var wg sync.WaitGroup
+	for i := 0; i < servers; i++ {
+		parent := logger
+		if i%2 == 0 {
+			parent = buffered
+		}
+		server := parent.With("server", strconv.Itoa(i)).With("download", "mongodb.tgz")
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				server.Infof("message %d from %d", j, i)
+			}
+		}(i)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buffered.Flush()
+	}()
+	wg.Wait()
+	buffered.Flush()
+
+	reLine := regexp.MustCompile(`^\d\d:\d\d:\d\d\.\d{6} \[memongo\] \[INFO\]  \[server=(\d+) download=mongodb\.tgz\] message \d+ from (\d+)\n$`)
+	assert.Len(t, rec.writes, servers*messages)
+	for _, write := range rec.writes {
+		// Every message is a whole line, written at once, with the fields of
+		// the server that logged it
+		match := reLine.FindStringSubmatch(write)
+		if assert.NotNil(t, match, "garbled line %q", write) {
+			assert.Equal(t, match[1], match[2])
+		}
+	}
+}
+
+func TestWithRedaction(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := New(log.New(out, "", 0), LogLevelInfo).WithRedaction(func(msg string) string {
+		return strings.ReplaceAll(msg, "hunter2", "<redacted>")
+	})
+
+	logger.With("download", "mongodb.tgz").Infof("password is %s", "hunter2")
+	assert.Equal(t, "[memongo] [INFO]  [download=mongodb.tgz] password is <redacted>\n", out.String())
+}
@@ -0,0 +1,45 @@
+package memongotest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFakeClock(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	assert.Equal(t, epoch, clock.Now())
+
+	immediate := clock.After(0)
+	assert.Equal(t, epoch, <-immediate)
+
+	late := clock.After(time.Minute)
+	early := clock.After(time.Second)
+	assert.Equal(t, 2, clock.Waiters())
+
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, epoch.Add(30*time.Second), <-early)
+	assert.Empty(t, late)
+	assert.Equal(t, 1, clock.Waiters())
+
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, epoch.Add(time.Minute), <-late)
+	assert.Equal(t, 0, clock.Waiters())
+	assert.Equal(t, epoch.Add(time.Minute), clock.Now())
+}
+
+func TestFakeClockBlockUntil(t *testing.T) {
+	clock := NewFakeClock(epoch)
+
+	fired := make(chan time.Time)
+	go func() {
+		fired <- <-clock.After(time.Hour)
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Hour)
+	assert.Equal(t, epoch.Add(time.Hour), <-fired)
+}
@@ -0,0 +1,6 @@
+// Package memongotest has fakes for testing code built on memongo against
+// memongo's failures, without breaking the network or waiting on real
+// timeouts: FaultyDownloader makes downloads fail, return a corrupt archive
+// or stall, and FakeClock decides when startup timeouts expire. Pass them in
+// memongo.DownloadOptions.Downloader and memongo.Options.Clock.
+package memongotest
@@ -0,0 +1,130 @@
+package memongotest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/100mslive/memongo/v2"
+	"github.com/100mslive/memongo/v2/mongobin"
+	"github.com/100mslive/memongo/v2/retry"
+)
+
+// corruptArchive is what FaultyDownloader downloads with Corrupt
+const corruptArchive = "this is not a MongoDB archive"
+
+// FaultyDownloader is a mongobin.Downloader that fails in the ways real
+// downloads do. Its faults apply in order: the first FailTimes downloads
+// fail, then every download stalls with Stall, or is corrupt with Corrupt,
+// or is passed to Downloader. It's safe for concurrent use, and mustn't be
+// copied after first use.
+type FaultyDownloader struct {
+	// Downloader does the downloads that aren't faked. Defaults to
+	// mongobin.HTTPDownloader.
+	Downloader mongobin.Downloader
+
+	// FailTimes is how many downloads fail with an error wrapping
+	// mongobin.ErrTransientDownload, like a dropped connection, which
+	// memongo.Options.StartRetries retries
+	FailTimes int
+
+	// Corrupt makes downloads return bytes that aren't an archive, which
+	// fails extracting mongod
+	Corrupt bool
+
+	// Stall makes reading downloads block, like a connection that stopped
+	// sending, until StallFor has passed on Clock or Unstall is called. The
+	// read then fails with an error wrapping mongobin.ErrTransientDownload.
+	// Without StallFor, only Unstall ends the stall.
+	Stall    bool
+	StallFor time.Duration
+
+	// Clock times StallFor. Defaults to the system clock.
+	Clock memongo.Clock
+
+	mu          sync.Mutex
+	attempts    int
+	unstall     chan struct{}
+	unstallOnce sync.Once
+}
+
+// Download fakes downloading urlStr, or passes it to Downloader once the
+// faults are used up
+func (d *FaultyDownloader) Download(urlStr string) (io.ReadCloser, error) {
+	d.mu.Lock()
+	d.attempts++
+	attempt := d.attempts
+	d.mu.Unlock()
+
+	switch {
+	case attempt <= d.FailTimes:
+		return nil, fmt.Errorf("%w: fake failure %d of %d downloading %s", mongobin.ErrTransientDownload, attempt, d.FailTimes, urlStr)
+	case d.Stall:
+		return &stalledReader{d: d, urlStr: urlStr}, nil
+	case d.Corrupt:
+		return io.NopCloser(strings.NewReader(corruptArchive)), nil
+	}
+
+	downloader := d.Downloader
+	if downloader == nil {
+		downloader = mongobin.HTTPDownloader{}
+	}
+
+	return downloader.Download(urlStr)
+}
+
+// Attempts returns how many downloads have been tried
+func (d *FaultyDownloader) Attempts() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.attempts
+}
+
+// Unstall ends stalled downloads, current and future, which then fail
+func (d *FaultyDownloader) Unstall() {
+	d.unstallOnce.Do(func() {
+		close(d.unstalled())
+	})
+}
+
+func (d *FaultyDownloader) unstalled() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.unstall == nil {
+		d.unstall = make(chan struct{})
+	}
+
+	return d.unstall
+}
+
+// stalledReader is a download that never sends anything
+type stalledReader struct {
+	d      *FaultyDownloader
+	urlStr string
+}
+
+func (r *stalledReader) Read([]byte) (int, error) {
+	var timeout <-chan time.Time
+	if r.d.StallFor > 0 {
+		clock := r.d.Clock
+		if clock == nil {
+			clock = retry.SystemClock{}
+		}
+		timeout = clock.After(r.d.StallFor)
+	}
+
+	select {
+	case <-timeout:
+		return 0, fmt.Errorf("%w: fake download of %s stalled for %s", mongobin.ErrTransientDownload, r.urlStr, r.d.StallFor)
+	case <-r.d.unstalled():
+		return 0, fmt.Errorf("%w: fake download of %s stalled until it was unstalled", mongobin.ErrTransientDownload, r.urlStr)
+	}
+}
+
+func (r *stalledReader) Close() error {
+	return nil
+}
@@ -0,0 +1,138 @@
+package memongotest
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/100mslive/memongo/v2"
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/100mslive/memongo/v2/mongobin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testURL = "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-ubuntu2204-8.0.0.tgz"
+
+// contentDownloader downloads content
+type contentDownloader string
+
+func (c contentDownloader) Download(string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(string(c))), nil
+}
+
+func TestFaultyDownloaderFailTimes(t *testing.T) {
+	d := &FaultyDownloader{Downloader: contentDownloader("archive"), FailTimes: 2}
+
+	for i := 0; i < 2; i++ {
+		_, err := d.Download(testURL)
+		assert.ErrorIs(t, err, mongobin.ErrTransientDownload)
+	}
+
+	body, err := d.Download(testURL)
+	require.NoError(t, err)
+	content, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "archive", string(content))
+	assert.Equal(t, 3, d.Attempts())
+}
+
+func TestFaultyDownloaderCorrupt(t *testing.T) {
+	d := &FaultyDownloader{Downloader: contentDownloader("archive"), Corrupt: true}
+
+	body, err := d.Download(testURL)
+	require.NoError(t, err)
+	content, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, corruptArchive, string(content))
+}
+
+func TestFaultyDownloaderStall(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	d := &FaultyDownloader{Stall: true, StallFor: time.Minute, Clock: clock}
+
+	body, err := d.Download(testURL)
+	require.NoError(t, err)
+	errCh := make(chan error)
+	go func() {
+		_, err := body.Read(make([]byte, 1))
+		errCh <- err
+	}()
+
+	clock.BlockUntil(1)
+	select {
+	case err := <-errCh:
+		t.Fatalf("the read didn't stall: %v", err)
+	default:
+	}
+	clock.Advance(time.Minute)
+	assert.ErrorIs(t, <-errCh, mongobin.ErrTransientDownload)
+
+	// Without StallFor, only Unstall ends the stall
+	d = &FaultyDownloader{Stall: true}
+	body, err = d.Download(testURL)
+	require.NoError(t, err)
+	go func() {
+		_, err := body.Read(make([]byte, 1))
+		errCh <- err
+	}()
+	d.Unstall()
+	assert.ErrorIs(t, <-errCh, mongobin.ErrTransientDownload)
+}
+
+func TestStartWithFaultyDownloader(t *testing.T) {
+	d := &FaultyDownloader{FailTimes: 2, Corrupt: true}
+
+	_, err := memongo.StartWithOptions(&memongo.Options{
+		DownloadOptions: memongo.DownloadOptions{
+			DownloadURL: testURL,
+			CachePath:   t.TempDir(),
+			Downloader:  d,
+		},
+		StartRetries: 3,
+		LogLevel:     memongolog.LogLevelSilent,
+	})
+	require.Error(t, err)
+
+	// The failures are retried, but not the corrupt archive
+	assert.Equal(t, 3, d.Attempts())
+	assert.False(t, errors.Is(err, mongobin.ErrTransientDownload), err)
+}
+
+func TestStartupTimeoutWithFakeClock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake mongod is a shell script")
+	}
+
+	// A mongod that reports its version, then never starts listening
+	bin := filepath.Join(t.TempDir(), "mongod")
+	script := "#!/bin/sh\nif [ \"$1\" = \"--version\" ]; then echo 'db version v8.0.0'; exit 0; fi\nexec sleep 600\n"
+	require.NoError(t, os.WriteFile(bin, []byte(script), 0700))
+
+	clock := NewFakeClock(epoch)
+	errCh := make(chan error)
+	go func() {
+		_, err := memongo.StartWithOptions(&memongo.Options{
+			MongodBin:      bin,
+			StartupTimeout: time.Hour,
+			Clock:          clock,
+			LogLevel:       memongolog.LogLevelSilent,
+		})
+		errCh <- err
+	}()
+
+	// Startup only times out once the clock is advanced
+	clock.BlockUntil(1)
+	select {
+	case err := <-errCh:
+		t.Fatalf("start returned before the timeout: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	clock.Advance(time.Hour)
+	assert.ErrorIs(t, <-errCh, memongo.ErrStartupTimeout)
+}
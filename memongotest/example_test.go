@@ -0,0 +1,55 @@
+package memongotest_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/100mslive/memongo/v2"
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/100mslive/memongo/v2/memongotest"
+	"github.com/100mslive/memongo/v2/mongobin"
+)
+
+// Check that transient download failures are retried, without touching the
+// network
+func ExampleFaultyDownloader() {
+	cachePath, err := os.MkdirTemp("", "memongotest")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(cachePath)
+
+	downloader := &memongotest.FaultyDownloader{FailTimes: 3}
+	_, err = memongo.StartWithOptions(&memongo.Options{
+		DownloadOptions: memongo.DownloadOptions{
+			DownloadURL: "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-ubuntu2204-8.0.0.tgz",
+			CachePath:   cachePath,
+			Downloader:  downloader,
+		},
+		StartRetries: 2,
+		LogLevel:     memongolog.LogLevelSilent,
+	})
+
+	fmt.Println("transient:", errors.Is(err, mongobin.ErrTransientDownload))
+	fmt.Println("attempts:", downloader.Attempts())
+	// Output:
+	// transient: true
+	// attempts: 3
+}
+
+// Expire a timeout exactly when the test decides
+func ExampleFakeClock() {
+	clock := memongotest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	expired := make(chan time.Time)
+	go func() {
+		expired <- <-clock.After(10 * time.Second)
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(10 * time.Second)
+	fmt.Println((<-expired).Format(time.RFC3339))
+	// Output: 2024-01-01T00:00:10Z
+}
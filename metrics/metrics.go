@@ -0,0 +1,199 @@
+// Package metrics exports Prometheus metrics about memongo servers, for
+// long-lived servers shared by a development environment.
+//
+// It's a separate module so that the core memongo module never depends on
+// the Prometheus client.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/100mslive/memongo/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// serverStatusTimeout bounds how long a scrape waits for a tracked server to
+// report its memory use
+const serverStatusTimeout = time.Second
+
+// Collector is a prometheus.Collector of memongo server metrics. Servers
+// report their lifecycle to it through Instrument, and their memory use
+// through Track.
+type Collector struct {
+	running         prometheus.Gauge
+	starts          prometheus.Counter
+	restarts        prometheus.Counter
+	unexpectedExits prometheus.Counter
+	downloads       prometheus.Counter
+	residentDesc    *prometheus.Desc
+
+	mu      sync.Mutex
+	tracked map[*memongo.Server]struct{}
+}
+
+// NewCollector returns a collector with all counts at zero
+func NewCollector() *Collector {
+	return &Collector{
+		running: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "memongo_servers_running",
+			Help: "Number of memongo servers that are listening and haven't been stopped.",
+		}),
+		starts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "memongo_server_starts_total",
+			Help: "Number of memongo servers that started listening.",
+		}),
+		restarts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "memongo_mongod_restarts_total",
+			Help: "Number of times a mongod listened again after it was restarted.",
+		}),
+		unexpectedExits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "memongo_server_unexpected_exits_total",
+			Help: "Number of times a mongod process exited without being stopped.",
+		}),
+		downloads: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "memongo_downloads_total",
+			Help: "Number of mongod downloads.",
+		}),
+		residentDesc: prometheus.NewDesc(
+			"memongo_mongod_resident_bytes",
+			"Resident memory of a tracked mongod, as reported by serverStatus.",
+			[]string{"uri"}, nil,
+		),
+		tracked: map[*memongo.Server]struct{}{},
+	}
+}
+
+// Instrument makes the server started with opts report its lifecycle events
+// to the collector, before calling any EventSink already set. Call it once
+// for each server's Options.
+func (c *Collector) Instrument(opts *memongo.Options) {
+	next := opts.EventSink
+
+	var mu sync.Mutex
+	listening := false
+	// Members that have listened, so that listening again is a restart.
+	// Added members never reuse an index.
+	listened := map[int]bool{}
+
+	opts.EventSink = func(event memongo.Event) {
+		mu.Lock()
+		switch event.Type {
+		case memongo.EventDownloadFinished:
+			if event.Err == nil {
+				c.downloads.Inc()
+			}
+		case memongo.EventListening:
+			if listened[event.Member] {
+				c.restarts.Inc()
+			}
+			listened[event.Member] = true
+			// Added replica set members report as other members
+			if event.Member == 0 && !listening {
+				listening = true
+				c.starts.Inc()
+				c.running.Inc()
+			}
+		case memongo.EventUnexpectedExit:
+			c.unexpectedExits.Inc()
+		case memongo.EventStopped:
+			if listening {
+				listening = false
+				c.running.Dec()
+			}
+		}
+		mu.Unlock()
+
+		if next != nil {
+			next(event)
+		}
+	}
+}
+
+// Track reports the resident memory of the server's mongod until it stops
+func (c *Collector) Track(s *memongo.Server) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tracked[s] = struct{}{}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.running.Describe(ch)
+	c.starts.Describe(ch)
+	c.restarts.Describe(ch)
+	c.unexpectedExits.Describe(ch)
+	c.downloads.Describe(ch)
+	ch <- c.residentDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.running.Collect(ch)
+	c.starts.Collect(ch)
+	c.restarts.Collect(ch)
+	c.unexpectedExits.Collect(ch)
+	c.downloads.Collect(ch)
+
+	c.mu.Lock()
+	servers := make([]*memongo.Server, 0, len(c.tracked))
+	for s := range c.tracked {
+		servers = append(servers, s)
+	}
+	c.mu.Unlock()
+
+	for _, s := range servers {
+		resident, err := residentBytes(s)
+		if errors.Is(err, memongo.ErrServerStopped) {
+			c.mu.Lock()
+			delete(c.tracked, s)
+			c.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			// The server is still tracked, e.g. if it's paused or restarting,
+			// and reports again once it answers
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.residentDesc, prometheus.GaugeValue, float64(resident), s.URI())
+	}
+}
+
+func residentBytes(s *memongo.Server) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), serverStatusTimeout)
+	defer cancel()
+
+	client, err := s.Client(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var status struct {
+		Mem struct {
+			// Resident is in MiB
+			Resident int64 `bson:"resident"`
+		} `bson:"mem"`
+	}
+	err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "serverStatus", Value: 1}}).Decode(&status)
+	if err != nil {
+		return 0, err
+	}
+
+	return status.Mem.Resident << 20, nil
+}
+
+// Handler returns an http.Handler serving the collector's metrics in the
+// Prometheus exposition format, e.g. for a /metrics endpoint
+func Handler(c *Collector) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/100mslive/memongo/v2"
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, c *Collector) string {
+	t.Helper()
+
+	server := httptest.NewServer(Handler(c))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func TestCollectorEvents(t *testing.T) {
+	c := NewCollector()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var forwarded []memongo.EventType
+			opts := &memongo.Options{EventSink: func(event memongo.Event) {
+				forwarded = append(forwarded, event.Type)
+			}}
+			c.Instrument(opts)
+
+			opts.EventSink(memongo.Event{Type: memongo.EventStarting})
+			opts.EventSink(memongo.Event{Type: memongo.EventListening})
+			// Added members don't count as servers
+			opts.EventSink(memongo.Event{Type: memongo.EventListening, Member: 1})
+			// Listening again after Restart
+			opts.EventSink(memongo.Event{Type: memongo.EventListening})
+			if i%2 == 0 {
+				opts.EventSink(memongo.Event{Type: memongo.EventUnexpectedExit})
+				opts.EventSink(memongo.Event{Type: memongo.EventStopping})
+				opts.EventSink(memongo.Event{Type: memongo.EventStopped})
+			}
+
+			assert.Len(t, forwarded, 4+3*(1-i%2))
+		}(i)
+	}
+	wg.Wait()
+
+	// A start that failed before listening
+	opts := &memongo.Options{}
+	c.Instrument(opts)
+	opts.EventSink(memongo.Event{Type: memongo.EventStarting})
+	opts.EventSink(memongo.Event{Type: memongo.EventStopped})
+
+	body := scrape(t, c)
+	for _, line := range []string{
+		"memongo_servers_running 10",
+		"memongo_server_starts_total 20",
+		"memongo_mongod_restarts_total 20",
+		"memongo_server_unexpected_exits_total 10",
+		"memongo_downloads_total 0",
+	} {
+		assert.Contains(t, strings.Split(body, "\n"), line)
+	}
+}
+
+func TestCollectorTrack(t *testing.T) {
+	c := NewCollector()
+
+	opts := &memongo.Options{MongoVersion: "8.0.0", LogLevel: memongolog.LogLevelWarn}
+	c.Instrument(opts)
+
+	server, err := memongo.StartWithOptions(opts)
+	require.NoError(t, err)
+	c.Track(server)
+
+	body := scrape(t, c)
+	assert.Contains(t, body, "memongo_servers_running 1")
+	assert.Contains(t, body, `memongo_mongod_resident_bytes{uri="`+server.URI()+`"}`)
+
+	// A server that doesn't answer is skipped, but still tracked
+	require.NoError(t, server.PauseMember(0))
+	body = scrape(t, c)
+	assert.NotContains(t, body, "memongo_mongod_resident_bytes{")
+	require.NoError(t, server.ResumeMember(0))
+
+	require.NoError(t, server.Restart(context.Background()))
+	body = scrape(t, c)
+	assert.Contains(t, body, "memongo_mongod_restarts_total 1")
+	assert.Contains(t, body, `memongo_mongod_resident_bytes{uri="`+server.URI()+`"}`)
+
+	server.Stop()
+
+	body = scrape(t, c)
+	assert.Contains(t, body, "memongo_servers_running 0")
+	assert.NotContains(t, body, "memongo_mongod_resident_bytes{")
+}
@@ -0,0 +1,114 @@
+package mongobin
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// ErrUnsupportedArchive is wrapped by the error returned when a downloaded
+// archive is compressed in a format memongo can't extract
+var ErrUnsupportedArchive = errors.New("unsupported archive format")
+
+// archiveFormat is a compression format archives may be downloaded in. The
+// formats without an open function are only recognized, to name them in
+// errors.
+type archiveFormat struct {
+	name       string
+	magic      []byte
+	extensions []string
+	open       func(io.Reader) (io.ReadCloser, error)
+}
+
+var archiveFormats = []archiveFormat{
+	{name: "gzip", magic: []byte{0x1f, 0x8b}, extensions: []string{".tgz", ".gz"}, open: openGzip},
+	{name: "zstd", magic: []byte{0x28, 0xb5, 0x2f, 0xfd}, extensions: []string{".tzst", ".zst"}, open: openZstd},
+	{name: "xz", magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, extensions: []string{".txz", ".xz"}, open: openXz},
+	{name: "bzip2", magic: []byte("BZh"), extensions: []string{".tbz2", ".bz2"}},
+	{name: "zip", magic: []byte("PK\x03\x04"), extensions: []string{".zip"}},
+}
+
+// maxMagicLength is the length of the longest magic number in archiveFormats
+const maxMagicLength = 6
+
+// openArchive returns a reader of the decompressed contents of the archive
+// read from r. The format is detected from the archive's magic number, or
+// from the extension of name, the archive's file name, if the magic number
+// isn't recognized. The archive is read as it's decompressed, so r may be a
+// stream.
+func openArchive(r io.Reader, name string) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(maxMagicLength)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading archive %s: %w", name, err)
+	}
+
+	format, ok := formatForMagic(head)
+	if !ok {
+		format, ok = formatForName(name)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s isn't a gzip, zstd or xz archive", ErrUnsupportedArchive, name)
+	}
+	if format.open == nil {
+		return nil, fmt.Errorf("%w: %s is a %s archive; only gzip, zstd and xz are supported", ErrUnsupportedArchive, name, format.name)
+	}
+
+	rc, err := format.open(br)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing %s reader for %s: %w", format.name, name, err)
+	}
+
+	return rc, nil
+}
+
+func formatForMagic(head []byte) (archiveFormat, bool) {
+	for _, format := range archiveFormats {
+		if bytes.HasPrefix(head, format.magic) {
+			return format, true
+		}
+	}
+
+	return archiveFormat{}, false
+}
+
+func formatForName(name string) (archiveFormat, bool) {
+	for _, format := range archiveFormats {
+		for _, ext := range format.extensions {
+			if strings.HasSuffix(name, ext) {
+				return format, true
+			}
+		}
+	}
+
+	return archiveFormat{}, false
+}
+
+func openGzip(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func openZstd(r io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return decoder.IOReadCloser(), nil
+}
+
+func openXz(r io.Reader) (io.ReadCloser, error) {
+	reader, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(reader), nil
+}
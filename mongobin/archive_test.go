@@ -0,0 +1,172 @@
+package mongobin
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fakeMongod = "#!/bin/sh\necho fake mongod\n"
+
+var archiveFixtures = map[string]string{
+	"gzip": "testdata/archives/mongodb-test.tgz",
+	"zstd": "testdata/archives/mongodb-test.tar.zst",
+	"xz":   "testdata/archives/mongodb-test.tar.xz",
+}
+
+func TestOpenArchiveStreaming(t *testing.T) {
+	for format, fixture := range archiveFixtures {
+		t.Run(format, func(t *testing.T) {
+			f, err := os.Open(fixture)
+			require.NoError(t, err)
+			defer f.Close()
+
+			// Feed the archive through a pipe, so it can't be seeked
+			pr, pw := io.Pipe()
+			go func() {
+				_, err := io.Copy(pw, f)
+				_ = pw.CloseWithError(err)
+			}()
+
+			archive, err := openArchive(pr, "download")
+			require.NoError(t, err)
+			defer archive.Close()
+
+			tarReader := tar.NewReader(archive)
+			var names []string
+			for {
+				header, err := tarReader.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				names = append(names, header.Name)
+			}
+			assert.Contains(t, names, "mongodb-test/bin/mongod")
+		})
+	}
+}
+
+func TestOpenArchiveUnsupported(t *testing.T) {
+	f, err := os.Open("testdata/archives/mongodb-test.tar.bz2")
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = openArchive(f, "mongodb-test.tar.bz2")
+	assert.True(t, errors.Is(err, ErrUnsupportedArchive))
+	assert.EqualError(t, err, "unsupported archive format: mongodb-test.tar.bz2 is a bzip2 archive; only gzip, zstd and xz are supported")
+
+	_, err = openArchive(io.LimitReader(zeroReader{}, 100), "mongodb-test.bin")
+	assert.EqualError(t, err, "unsupported archive format: mongodb-test.bin isn't a gzip, zstd or xz archive")
+}
+
+func TestFormatForName(t *testing.T) {
+	tests := map[string]string{
+		"mongodb-linux-x86_64-ubuntu2204-7.0.0.tgz": "gzip",
+		"mongodb.tar.gz":  "gzip",
+		"mongodb.tar.zst": "zstd",
+		"mongodb.tar.xz":  "xz",
+		"mongodb.zip":     "zip",
+	}
+	for name, expected := range tests {
+		format, ok := formatForName(name)
+		require.True(t, ok, name)
+		assert.Equal(t, expected, format.name, name)
+	}
+
+	_, ok := formatForName("mongodb.tar")
+	assert.False(t, ok)
+}
+
+func TestGetOrDownloadArchiveFormats(t *testing.T) {
+	Afs = afero.Afero{Fs: afero.NewOsFs()}
+
+	server := httptest.NewServer(http.StripPrefix("/", http.FileServer(http.Dir("testdata/archives"))))
+	defer server.Close()
+
+	for format, fixture := range archiveFixtures {
+		t.Run(format, func(t *testing.T) {
+			mongodPath, err := GetOrDownloadMongod(server.URL+"/"+filepath.Base(fixture), t.TempDir(), memongolog.New(nil, memongolog.LogLevelSilent))
+			require.NoError(t, err)
+
+			contents, err := os.ReadFile(mongodPath)
+			require.NoError(t, err)
+			assert.Equal(t, fakeMongod, string(contents))
+		})
+	}
+
+	_, err := GetOrDownloadMongod(server.URL+"/mongodb-test.tar.bz2", t.TempDir(), memongolog.New(nil, memongolog.LogLevelSilent))
+	assert.True(t, errors.Is(err, ErrUnsupportedArchive))
+	assert.Contains(t, err.Error(), "bzip2")
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestGetOrDownloadMongos(t *testing.T) {
+	Afs = afero.Afero{Fs: afero.NewOsFs()}
+	logger := memongolog.New(nil, memongolog.LogLevelSilent)
+
+	// An archive with both binaries
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "mongodb-sharded.tgz"))
+	require.NoError(t, err)
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, name := range []string{"mongod", "mongos"} {
+		contents := "#!/bin/sh\necho fake " + name + "\n"
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "mongodb-sharded/bin/" + name, Mode: 0o755, Size: int64(len(contents))}))
+		_, err = tw.Write([]byte(contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	require.NoError(t, f.Close())
+	fixture, err := os.ReadFile("testdata/archives/mongodb-test.tgz")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mongodb-test.tgz"), fixture, 0o644))
+
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+
+	opts := DownloadOptions{CachePath: t.TempDir(), DownloadURL: server.URL + "/mongodb-sharded.tgz"}
+	mongosPath, err := GetOrDownloadMongos(opts, logger)
+	require.NoError(t, err)
+	contents, err := os.ReadFile(mongosPath)
+	require.NoError(t, err)
+	assert.Equal(t, "#!/bin/sh\necho fake mongos\n", string(contents))
+
+	// mongod came from the same download
+	opts.Offline = true
+	mongodPath, err := GetOrDownload(opts, logger)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Dir(mongosPath), filepath.Dir(mongodPath))
+
+	// An archive without mongos still provides mongod
+	opts = DownloadOptions{CachePath: t.TempDir(), DownloadURL: server.URL + "/mongodb-test.tgz"}
+	_, err = GetOrDownload(opts, logger)
+	require.NoError(t, err)
+	_, err = GetOrDownloadMongos(opts, logger)
+	assert.EqualError(t, err, "did not find a mongos binary in the tar from "+opts.DownloadURL)
+
+	opts.Offline = true
+	_, err = GetOrDownloadMongos(opts, logger)
+	assert.True(t, errors.Is(err, ErrOffline))
+}
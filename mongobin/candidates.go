@@ -0,0 +1,210 @@
+package mongobin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acobaugh/osrelease"
+)
+
+// ErrNoBuildFound is returned by Resolver.Resolve when none of the candidate
+// builds exist on the download server
+var ErrNoBuildFound = errors.New("no MongoDB build found")
+
+// Candidate is a build of MongoDB that may run on this machine
+type Candidate struct {
+	Spec *DownloadSpec
+	URL  string
+
+	// Fallback explains why the build isn't the exact match for this
+	// machine, e.g. "ubuntu2204 build for ubuntu 24", or is "" if it is
+	Fallback string
+}
+
+// DownloadCandidates returns the builds of version that may run on this
+// machine, best first: the build for the exact distro release, then builds
+// for older releases of the same distro, then the generic Linux build for
+// versions that have one. Which of them exist is checked with a Resolver.
+func DownloadCandidates(version string) ([]Candidate, error) {
+	id, release := detectOSRelease()
+	return DownloadCandidatesFor(version, GoOS, GoArch, id, release)
+}
+
+// DownloadCandidatesFor returns the candidate builds of version for the given
+// platform. id and release are the os-release ID and major VERSION_ID of the
+// Linux distro, or "" and 0 for macOS or an unknown distro.
+func DownloadCandidatesFor(version string, goos string, goarch string, id string, release int) ([]Candidate, error) {
+	parsedVersion, err := ParseVersion(version)
+	if err != nil {
+		return nil, err
+	}
+
+	if goos != "linux" || id == "" {
+		spec, err := MakeDownloadSpecFor(version, goos, goarch, "")
+		if err != nil {
+			return nil, err
+		}
+		candidate := Candidate{Spec: spec, URL: spec.GetDownloadURL()}
+		if goos == "linux" {
+			candidate.Fallback = "generic Linux build for an unknown distro"
+		}
+		return []Candidate{candidate}, nil
+	}
+
+	var candidates []Candidate
+	for _, d := range distroTable {
+		if !hasID(d.ids, id) || release < d.minRelease || (d.maxRelease != 0 && release > d.maxRelease) {
+			continue
+		}
+		spec, err := MakeDownloadSpecFor(version, goos, goarch, d.osName)
+		if err != nil {
+			// There's no build of this version or architecture for it
+			continue
+		}
+
+		candidate := Candidate{Spec: spec, URL: spec.GetDownloadURL()}
+		if release != d.minRelease && d.maxRelease == 0 {
+			candidate.Fallback = fmt.Sprintf("%s build for %s %d", d.osName, id, release)
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	if !versionGTE(parsedVersion, maxGenericLinuxVersion) {
+		spec, err := MakeDownloadSpecFor(version, goos, goarch, "")
+		if err == nil {
+			candidates = append(candidates, Candidate{
+				Spec:     spec,
+				URL:      spec.GetDownloadURL(),
+				Fallback: fmt.Sprintf("generic Linux build for %s %d", id, release),
+			})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, &UnsupportedSystemError{msg: fmt.Sprintf("MongoDB doesn't publish builds of version %s for %s %d on %s", version, id, release, goarch)}
+	}
+
+	return candidates, nil
+}
+
+// detectOSRelease returns the os-release ID and major VERSION_ID of the
+// Linux distro we're running on, or "" and 0 if it's unknown or we're not on
+// Linux
+func detectOSRelease() (string, int) {
+	if GoOS != "linux" {
+		return "", 0
+	}
+
+	osRelease, err := osrelease.ReadFile(EtcOsRelease)
+	if err == nil {
+		release, err := strconv.Atoi(strings.Split(osRelease["VERSION_ID"], ".")[0])
+		if err != nil {
+			return "", 0
+		}
+		return osRelease["ID"], release
+	}
+
+	// We control EtcRedhatRelease
+	//nolint:gosec
+	redhatRelease, err := os.ReadFile(EtcRedhatRelease)
+	if err == nil && osNameFromRedhatRelease(string(redhatRelease)) != "" {
+		return "rhel", 6
+	}
+
+	return "", 0
+}
+
+// Resolver picks the first candidate build that exists on the download
+// server, checking with HEAD requests. Results are cached, so each URL is
+// only checked once. It's safe for concurrent use.
+type Resolver struct {
+	// Client makes the HEAD requests. Defaults to a client with a 10 second
+	// timeout.
+	Client *http.Client
+
+	mu     sync.Mutex
+	exists map[string]bool
+}
+
+// DefaultResolver is the Resolver memongo uses
+var DefaultResolver = &Resolver{}
+
+// Resolve returns the first of candidates that exists. If none does, it
+// returns an error wrapping ErrNoBuildFound that lists them all. Network
+// errors and 5xx responses wrap ErrTransientDownload instead.
+func (r *Resolver) Resolve(candidates []Candidate) (Candidate, error) {
+	return r.ResolveContext(context.Background(), candidates)
+}
+
+// ResolveContext is Resolve, giving up with ctx's error once ctx is done
+func (r *Resolver) ResolveContext(ctx context.Context, candidates []Candidate) (Candidate, error) {
+	tried := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		exists, err := r.check(ctx, c.URL)
+		if err != nil {
+			return Candidate{}, err
+		}
+		if exists {
+			return c, nil
+		}
+		tried = append(tried, c.URL)
+	}
+
+	return Candidate{}, fmt.Errorf("%w: none of these exist: %s", ErrNoBuildFound, strings.Join(tried, ", "))
+}
+
+// check returns whether url exists, from the cache if it was checked before
+func (r *Resolver) check(ctx context.Context, url string) (bool, error) {
+	r.mu.Lock()
+	exists, ok := r.exists[url]
+	r.mu.Unlock()
+	if ok {
+		return exists, nil
+	}
+
+	client := r.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return false, fmt.Errorf("error checking %s: %w", url, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, fmt.Errorf("error checking %s: %w", url, ctx.Err())
+		}
+		return false, fmt.Errorf("%w: error checking %s: %s", ErrTransientDownload, url, err)
+	}
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		exists = true
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden:
+		// The download server answers 403 for missing files
+		exists = false
+	case resp.StatusCode >= 500:
+		return false, fmt.Errorf("%w: checking %s failed with status code %d", ErrTransientDownload, url, resp.StatusCode)
+	default:
+		return false, fmt.Errorf("checking %s failed with status code %d", url, resp.StatusCode)
+	}
+
+	r.mu.Lock()
+	if r.exists == nil {
+		r.exists = map[string]bool{}
+	}
+	r.exists[url] = exists
+	r.mu.Unlock()
+
+	return exists, nil
+}
@@ -0,0 +1,199 @@
+package mongobin_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/mongobin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadCandidatesFor(t *testing.T) {
+	tests := map[string]struct {
+		version string
+		goos    string
+		goarch  string
+		id      string
+		release int
+
+		// expected are the first candidates' URL suffixes and fallbacks
+		expected      [][2]string
+		expectedError string
+	}{
+		"exact": {
+			version: "8.0.0", goos: "linux", goarch: "amd64", id: "ubuntu", release: 22,
+			expected: [][2]string{
+				{"ubuntu2204-8.0.0.tgz", ""},
+				{"ubuntu2004-8.0.0.tgz", "ubuntu2004 build for ubuntu 22"},
+			},
+		},
+		"newer release": {
+			version: "8.0.0", goos: "linux", goarch: "amd64", id: "ubuntu", release: 24,
+			expected: [][2]string{
+				{"ubuntu2204-8.0.0.tgz", "ubuntu2204 build for ubuntu 24"},
+				{"ubuntu2004-8.0.0.tgz", "ubuntu2004 build for ubuntu 24"},
+			},
+		},
+		"version older than the release's builds": {
+			version: "5.0.0", goos: "linux", goarch: "amd64", id: "ubuntu", release: 22,
+			expected: [][2]string{
+				{"ubuntu2004-5.0.0.tgz", "ubuntu2004 build for ubuntu 22"},
+			},
+		},
+		"release with an upper bound": {
+			version: "4.4.0", goos: "linux", goarch: "amd64", id: "rhel", release: 7,
+			expected: [][2]string{
+				{"rhel70-4.4.0.tgz", ""},
+			},
+		},
+		"arm64": {
+			version: "6.0.4", goos: "linux", goarch: "arm64", id: "ubuntu", release: 22,
+			expected: [][2]string{
+				{"aarch64-ubuntu2204-6.0.4.tgz", ""},
+			},
+		},
+		"generic linux last": {
+			version: "4.0.5", goos: "linux", goarch: "amd64", id: "ubuntu", release: 18,
+			expected: [][2]string{
+				{"ubuntu1804-4.0.5.tgz", ""},
+				{"ubuntu1604-4.0.5.tgz", "ubuntu1604 build for ubuntu 18"},
+			},
+		},
+		"unknown distro": {
+			version: "4.0.5", goos: "linux", goarch: "amd64",
+			expected: [][2]string{
+				{"linux-x86_64-4.0.5.tgz", "generic Linux build for an unknown distro"},
+			},
+		},
+		"unknown distro without generic builds": {
+			version: "8.0.0", goos: "linux", goarch: "amd64",
+			expectedError: "MongoDB 4.2 removed support for generic linux tarballs",
+		},
+		"unsupported distro": {
+			version: "8.0.0", goos: "linux", goarch: "amd64", id: "arch", release: 0,
+			expectedError: "MongoDB doesn't publish builds of version 8.0.0 for arch 0 on amd64",
+		},
+		"mac": {
+			version: "8.0.0", goos: "darwin", goarch: "amd64",
+			expected: [][2]string{
+				{"macos-x86_64-8.0.0.tgz", ""},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			candidates, err := mongobin.DownloadCandidatesFor(test.version, test.goos, test.goarch, test.id, test.release)
+			if test.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.GreaterOrEqual(t, len(candidates), len(test.expected))
+			for i, expected := range test.expected {
+				assert.True(t, strings.HasSuffix(candidates[i].URL, expected[0]), "candidate %d is %s", i, candidates[i].URL)
+				assert.Equal(t, expected[1], candidates[i].Fallback)
+			}
+		})
+	}
+
+	// The generic build is the last resort
+	candidates, err := mongobin.DownloadCandidatesFor("4.0.5", "linux", "amd64", "ubuntu", 18)
+	require.NoError(t, err)
+	last := candidates[len(candidates)-1]
+	assert.Equal(t, "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-4.0.5.tgz", last.URL)
+	assert.Equal(t, "generic Linux build for ubuntu 18", last.Fallback)
+}
+
+// fakeDownloadServer answers HEAD requests with 200 for the paths in exist,
+// statusFor others, or 404, and counts the requests
+func fakeDownloadServer(t *testing.T, exist []string, statusFor map[string]int) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		assert.Equal(t, http.MethodHead, r.Method)
+		for _, p := range exist {
+			if r.URL.Path == p {
+				return
+			}
+		}
+		if status, ok := statusFor[r.URL.Path]; ok {
+			w.WriteHeader(status)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+// candidatesAt returns the candidates for names on server
+func candidatesAt(server *httptest.Server, names ...string) []mongobin.Candidate {
+	candidates := make([]mongobin.Candidate, len(names))
+	for i, name := range names {
+		candidates[i] = mongobin.Candidate{URL: server.URL + "/" + name}
+		if i > 0 {
+			candidates[i].Fallback = "fallback " + name
+		}
+	}
+
+	return candidates
+}
+
+func TestResolverResolve(t *testing.T) {
+	server, requests := fakeDownloadServer(t, []string{"/b.tgz", "/c.tgz"}, map[string]int{"/forbidden.tgz": http.StatusForbidden})
+	r := &mongobin.Resolver{Client: server.Client()}
+
+	chosen, err := r.Resolve(candidatesAt(server, "a.tgz", "forbidden.tgz", "b.tgz", "c.tgz"))
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/b.tgz", chosen.URL)
+	assert.Equal(t, "fallback b.tgz", chosen.Fallback)
+	assert.Equal(t, int32(3), atomic.LoadInt32(requests), "c.tgz isn't checked")
+
+	// The results are cached
+	_, err = r.Resolve(candidatesAt(server, "a.tgz", "forbidden.tgz", "b.tgz"))
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(requests))
+}
+
+func TestResolverNoneExist(t *testing.T) {
+	server, _ := fakeDownloadServer(t, nil, nil)
+	r := &mongobin.Resolver{Client: server.Client()}
+
+	_, err := r.Resolve(candidatesAt(server, "a.tgz", "b.tgz"))
+	assert.ErrorIs(t, err, mongobin.ErrNoBuildFound)
+	assert.EqualError(t, err, "no MongoDB build found: none of these exist: "+server.URL+"/a.tgz, "+server.URL+"/b.tgz")
+}
+
+func TestResolverContext(t *testing.T) {
+	server, requests := fakeDownloadServer(t, []string{"/a.tgz"}, nil)
+	r := &mongobin.Resolver{Client: server.Client()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := r.ResolveContext(ctx, candidatesAt(server, "a.tgz"))
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.False(t, errors.Is(err, mongobin.ErrTransientDownload))
+	assert.Equal(t, int32(0), atomic.LoadInt32(requests))
+
+	// The canceled check isn't cached
+	chosen, err := r.ResolveContext(context.Background(), candidatesAt(server, "a.tgz"))
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/a.tgz", chosen.URL)
+}
+
+func TestResolverServerError(t *testing.T) {
+	server, _ := fakeDownloadServer(t, []string{"/b.tgz"}, map[string]int{"/a.tgz": http.StatusServiceUnavailable})
+	r := &mongobin.Resolver{Client: server.Client()}
+
+	_, err := r.Resolve(candidatesAt(server, "a.tgz", "b.tgz"))
+	assert.ErrorIs(t, err, mongobin.ErrTransientDownload)
+}
@@ -0,0 +1,59 @@
+package mongobin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// checksumSuffix is the suffix of the file next to a cached mongod that
+// holds its sha256 checksum, so it's hashed once rather than on every start
+const checksumSuffix = ".sha256"
+
+// CachedSHA256 returns the hex-encoded sha256 checksum of the cached mongod
+// at mongodPath, as recorded when it was downloaded or imported. A binary
+// cached before checksums were recorded is hashed, and its checksum recorded.
+func CachedSHA256(mongodPath string) (string, error) {
+	content, err := Afs.ReadFile(mongodPath + checksumSuffix)
+	if err == nil {
+		sum := strings.TrimSpace(string(content))
+		if len(sum) == sha256.Size*2 {
+			return sum, nil
+		}
+	}
+
+	return recordSHA256(mongodPath)
+}
+
+// recordSHA256 hashes the mongod at mongodPath and records its checksum next
+// to it
+func recordSHA256(mongodPath string) (string, error) {
+	sum, err := hashFile(mongodPath)
+	if err != nil {
+		return "", err
+	}
+
+	// The checksum only saves hashing again, so a read-only cache is fine
+	_ = Afs.WriteFile(mongodPath+checksumSuffix, []byte(sum+"\n"), 0644)
+
+	return sum, nil
+}
+
+// hashFile returns the hex-encoded sha256 checksum of the file at path
+func hashFile(path string) (string, error) {
+	f, err := Afs.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("error opening %s to hash it: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", fmt.Errorf("error hashing %s: %w", path, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,67 @@
+package mongobin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedSHA256(t *testing.T) {
+	Afs = afero.Afero{Fs: afero.NewOsFs()}
+	logger := memongolog.New(nil, memongolog.LogLevelSilent)
+
+	server := httptest.NewServer(http.StripPrefix("/", http.FileServer(http.Dir("testdata/archives"))))
+	defer server.Close()
+
+	sum := sha256.Sum256([]byte(fakeMongod))
+	expected := hex.EncodeToString(sum[:])
+
+	mongodPath, err := GetOrDownload(DownloadOptions{CachePath: t.TempDir(), DownloadURL: server.URL + "/mongodb-test.tgz"}, logger)
+	require.NoError(t, err)
+
+	// The checksum is recorded when mongod is downloaded
+	recorded, err := os.ReadFile(mongodPath + checksumSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, expected+"\n", string(recorded))
+
+	// and read back rather than hashed again
+	fake := "0000000000000000000000000000000000000000000000000000000000000000"
+	require.NoError(t, os.WriteFile(mongodPath+checksumSuffix, []byte(fake), 0644))
+	got, err := CachedSHA256(mongodPath)
+	require.NoError(t, err)
+	assert.Equal(t, fake, got)
+
+	// A binary cached without a checksum, or with a damaged one, is hashed
+	for _, content := range []string{"", "truncat"} {
+		require.NoError(t, os.WriteFile(mongodPath+checksumSuffix, []byte(content), 0644))
+		got, err = CachedSHA256(mongodPath)
+		require.NoError(t, err)
+		assert.Equal(t, expected, got)
+	}
+	require.NoError(t, os.Remove(mongodPath+checksumSuffix))
+	got, err = CachedSHA256(mongodPath)
+	require.NoError(t, err)
+	assert.Equal(t, expected, got)
+	assert.FileExists(t, mongodPath+checksumSuffix)
+
+	// Imports record it too
+	rawPath := filepath.Join(t.TempDir(), "mongod")
+	require.NoError(t, os.WriteFile(rawPath, []byte(fakeMongod), 0700))
+	mongodPath, err = Import(rawPath, DownloadOptions{CachePath: t.TempDir(), DownloadURL: server.URL + "/mongodb-test.tgz"}, nil, logger)
+	require.NoError(t, err)
+	recorded, err = os.ReadFile(mongodPath + checksumSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, expected+"\n", string(recorded))
+
+	_, err = CachedSHA256(filepath.Join(t.TempDir(), "mongod"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,96 @@
+package mongobin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stalledDownloader returns an archive that never finishes, and has no
+// DownloadContext
+type stalledDownloader struct {
+	closed chan struct{}
+}
+
+func (d stalledDownloader) Download(string) (io.ReadCloser, error) {
+	r, w := io.Pipe()
+	go func() {
+		_, _ = w.Write([]byte("partial archive"))
+		<-d.closed
+	}()
+
+	return &closeNotifier{PipeReader: r, closed: d.closed}, nil
+}
+
+type closeNotifier struct {
+	*io.PipeReader
+	closed chan struct{}
+}
+
+func (c *closeNotifier) Close() error {
+	select {
+	case <-c.closed:
+	default:
+		close(c.closed)
+	}
+	return c.PipeReader.Close()
+}
+
+func TestGetOrDownloadContextCanceled(t *testing.T) {
+	Afs = afero.Afero{Fs: afero.NewOsFs()}
+	logger := memongolog.New(nil, memongolog.LogLevelSilent)
+
+	server := httptest.NewServer(http.StripPrefix("/", http.FileServer(http.Dir("testdata/archives"))))
+	defer server.Close()
+	opts := DownloadOptions{
+		CachePath:   t.TempDir(),
+		DownloadURL: server.URL + "/mongodb-test.tgz",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := GetOrDownloadContext(ctx, opts, logger)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, ErrTransientDownload)
+
+	// A download that stalls is given up on, without a Downloader that takes
+	// a context
+	opts.Downloader = stalledDownloader{closed: make(chan struct{})}
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = GetOrDownloadContext(ctx, opts, logger)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotErrorIs(t, err, ErrTransientDownload)
+
+	entries, err := os.ReadDir(opts.CachePath)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestHTTPDownloaderContext(t *testing.T) {
+	stalled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-stalled:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(stalled)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := HTTPDownloader{}.DownloadContext(ctx, server.URL+"/mongodb-test.tgz")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.NotErrorIs(t, err, ErrTransientDownload)
+}
@@ -0,0 +1,71 @@
+package mongobin
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrOffline is wrapped by the error GetOrDownload returns when mongod isn't
+// in the cache and DownloadOptions.Offline forbids downloading it
+var ErrOffline = errors.New("downloads are disabled by Offline")
+
+// DownloadOptions configures where mongod is downloaded from and where it's
+// cached. memongo.Options embeds it, and GetOrDownload, IsCached and Import
+// take it, so every way of getting mongod agrees on the cache entry.
+type DownloadOptions struct {
+	// CachePath is the directory downloads are cached in. memongo defaults it
+	// to the user's cache directory; the functions of this package need it
+	// given.
+	CachePath string
+
+	// DownloadURL is where mongod is downloaded from. memongo defaults it to
+	// the build of Options.MongoVersion for this platform; the functions of
+	// this package need it given.
+	DownloadURL string
+
+	// If set, never download mongod: it must already be in the cache
+	Offline bool
+
+	// ReadOnlyCachePaths are caches shared between machines, such as a
+	// read-only network mount populated ahead of time, which are looked in,
+	// in order, before CachePath. A binary found in one is run from there,
+	// unless CopyFromReadOnlyCache is set or it's mounted noexec, and
+	// downloads are only saved to CachePath. A binary that doesn't match the
+	// checksum recorded next to it is skipped.
+	ReadOnlyCachePaths []string
+
+	// CopyFromReadOnlyCache copies a binary found in ReadOnlyCachePaths into
+	// CachePath and runs the copy, for mounts that are slow or forbidden to
+	// execute from. Binaries on noexec mounts are copied regardless.
+	CopyFromReadOnlyCache bool
+
+	// Downloader downloads mongod. Defaults to HTTPDownloader.
+	Downloader Downloader
+
+	// RequireExactDistroMatch makes picking the build for this platform fail
+	// if MongoDB doesn't publish one for this exact distro release, rather
+	// than falling back to the build for an older release, or the generic
+	// Linux build
+	RequireExactDistroMatch bool
+}
+
+// downloader returns the Downloader, or HTTPDownloader if none is given
+func (opts DownloadOptions) downloader() Downloader {
+	if opts.Downloader == nil {
+		return HTTPDownloader{}
+	}
+
+	return opts.Downloader
+}
+
+// validate checks that the cache entry can be found
+func (opts DownloadOptions) validate() error {
+	if opts.CachePath == "" {
+		return fmt.Errorf("DownloadOptions.CachePath must be given")
+	}
+	if opts.DownloadURL == "" {
+		return fmt.Errorf("DownloadOptions.DownloadURL must be given")
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package mongobin
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOrDownloadOffline(t *testing.T) {
+	Afs = afero.Afero{Fs: afero.NewOsFs()}
+	logger := memongolog.New(nil, memongolog.LogLevelSilent)
+
+	server := httptest.NewServer(http.StripPrefix("/", http.FileServer(http.Dir("testdata/archives"))))
+	defer server.Close()
+
+	opts := DownloadOptions{
+		CachePath:   t.TempDir(),
+		DownloadURL: server.URL + "/mongodb-test.tgz",
+		Offline:     true,
+	}
+
+	_, err := GetOrDownload(opts, logger)
+	assert.True(t, errors.Is(err, ErrOffline))
+	assert.EqualError(t, err, "mongod from "+opts.DownloadURL+" is not in the cache at "+opts.CachePath+", and downloads are disabled by Offline")
+
+	// Once it's cached, Offline doesn't matter
+	opts.Offline = false
+	_, err = GetOrDownload(opts, logger)
+	require.NoError(t, err)
+	opts.Offline = true
+	mongodPath, err := GetOrDownload(opts, logger)
+	require.NoError(t, err)
+
+	contents, err := os.ReadFile(mongodPath)
+	require.NoError(t, err)
+	assert.Equal(t, fakeMongod, string(contents))
+}
+
+func TestDownloadOptionsValidate(t *testing.T) {
+	logger := memongolog.New(nil, memongolog.LogLevelSilent)
+
+	_, err := GetOrDownload(DownloadOptions{DownloadURL: "https://example.com/mongodb.tgz"}, logger)
+	assert.EqualError(t, err, "DownloadOptions.CachePath must be given")
+
+	_, err = IsCached(DownloadOptions{CachePath: t.TempDir()})
+	assert.EqualError(t, err, "DownloadOptions.DownloadURL must be given")
+
+	_, err = Import("mongod", DownloadOptions{CachePath: t.TempDir()}, nil, logger)
+	assert.EqualError(t, err, "DownloadOptions.DownloadURL must be given")
+}
+
+// The deprecated functions must behave as they did before DownloadOptions
+func TestDeprecatedFunctions(t *testing.T) {
+	Afs = afero.Afero{Fs: afero.NewOsFs()}
+	logger := memongolog.New(nil, memongolog.LogLevelSilent)
+
+	server := httptest.NewServer(http.StripPrefix("/", http.FileServer(http.Dir("testdata/archives"))))
+	defer server.Close()
+	urlStr := server.URL + "/mongodb-test.tgz"
+
+	tests := map[string]func(cachePath string) (string, error){
+		"GetOrDownloadMongod": func(cachePath string) (string, error) {
+			return GetOrDownloadMongod(urlStr, cachePath, logger)
+		},
+		"GetOrDownloadMongodWith": func(cachePath string) (string, error) {
+			return GetOrDownloadMongodWith(urlStr, cachePath, HTTPDownloader{}, logger)
+		},
+		"ImportMongod": func(cachePath string) (string, error) {
+			return ImportMongod(archiveFixtures["gzip"], urlStr, cachePath, nil, logger)
+		},
+	}
+
+	for name, get := range tests {
+		t.Run(name, func(t *testing.T) {
+			cachePath := t.TempDir()
+			opts := DownloadOptions{CachePath: cachePath, DownloadURL: urlStr}
+
+			cached, err := IsMongodCached(urlStr, cachePath)
+			require.NoError(t, err)
+			assert.False(t, cached)
+
+			mongodPath, err := get(cachePath)
+			require.NoError(t, err)
+
+			// The new functions find the same cache entry
+			cached, err = IsCached(opts)
+			require.NoError(t, err)
+			assert.True(t, cached)
+			opts.Offline = true
+			cachedPath, err := GetOrDownload(opts, logger)
+			require.NoError(t, err)
+			assert.Equal(t, mongodPath, cachedPath)
+		})
+	}
+}
@@ -1,7 +1,6 @@
 package mongobin
 
 import (
-	"fmt"
 	"os"
 	"runtime"
 	"strconv"
@@ -55,12 +54,24 @@ type DownloadSpec struct {
 
 // MakeDownloadSpec returns a DownloadSpec for the current operating system
 func MakeDownloadSpec(version string) (*DownloadSpec, error) {
-	parsedVersion, versionErr := parseVersion(version)
+	parsedVersion, versionErr := ParseVersion(version)
 	if versionErr != nil {
 		return nil, versionErr
 	}
 
-	platform, platformErr := detectPlatform()
+	return MakeDownloadSpecFor(version, GoOS, GoArch, detectOSName(parsedVersion))
+}
+
+// MakeDownloadSpecFor returns a DownloadSpec for the given platform. osName
+// is the Linux distro, as in DownloadSpec.OSName, or "" for macOS and generic
+// Linux builds.
+func MakeDownloadSpecFor(version string, goos string, goarch string, osName string) (*DownloadSpec, error) {
+	parsedVersion, versionErr := ParseVersion(version)
+	if versionErr != nil {
+		return nil, versionErr
+	}
+
+	platform, platformErr := detectPlatform(goos)
 	if platformErr != nil {
 		return nil, platformErr
 	}
@@ -71,12 +82,21 @@ func MakeDownloadSpec(version string) (*DownloadSpec, error) {
 		ssl = true
 	}
 
-	osName := detectOSName(parsedVersion)
-	if platform == "linux" && osName == "" && versionGTE(parsedVersion, []int{4, 2, 0}) {
+	if osName != "" {
+		distro, ok := findDistro(osName)
+		if !ok || platform != "linux" {
+			return nil, &UnsupportedSystemError{msg: "MongoDB doesn't publish builds for " + osName + " on " + goos}
+		}
+		if !versionGTE(parsedVersion, distro.minVersion) {
+			return nil, &UnsupportedSystemError{msg: "MongoDB doesn't publish builds of version " + version + " for " + osName + ", the first one is " + formatVersion(distro.minVersion)}
+		}
+	}
+
+	if platform == "linux" && osName == "" && versionGTE(parsedVersion, maxGenericLinuxVersion) {
 		return nil, &UnsupportedSystemError{msg: "MongoDB 4.2 removed support for generic linux tarballs. Specify the download URL manually or use a supported distro. See: https://www.mongodb.com/blog/post/a-proposal-to-endoflife-our-generic-linux-tar-packages"}
 	}
 
-	arch, archErr := detectArch(platform, osName, parsedVersion)
+	arch, archErr := detectArch(goarch, platform, osName, parsedVersion)
 	if archErr != nil {
 		return nil, archErr
 	}
@@ -90,7 +110,9 @@ func MakeDownloadSpec(version string) (*DownloadSpec, error) {
 	}, nil
 }
 
-func parseVersion(version string) ([]int, error) {
+// ParseVersion parses a MongoDB version number in the form x.y.z into its
+// major, minor, and patch components
+func ParseVersion(version string) ([]int, error) {
 	versionParts := strings.Split(version, ".")
 	if len(versionParts) < 3 {
 		return nil, &UnsupportedMongoVersionError{
@@ -133,61 +155,42 @@ func parseVersion(version string) ([]int, error) {
 	return []int{majorVersion, minorVersion, patchVersion}, nil
 }
 
-func detectPlatform() (string, error) {
-	switch GoOS {
+func detectPlatform(goos string) (string, error) {
+	switch goos {
 	case "darwin":
 		return "osx", nil
 	case "linux":
 		return "linux", nil
 	default:
-		return "", &UnsupportedSystemError{msg: "your platform, " + GoOS + ", is not supported"}
+		return "", &UnsupportedSystemError{msg: "your platform, " + goos + ", is not supported"}
 	}
 }
 
-func detectArch(platform string, osName string, mongoVersion []int) (string, error) {
-	switch GoArch {
+func detectArch(goarch string, platform string, osName string, mongoVersion []int) (string, error) {
+	switch goarch {
 	case "amd64":
 		return "x86_64", nil
 	case "arm64":
 		return arm64ArchFromOSNameAndVersion(platform, osName, mongoVersion)
 	default:
-		return "", &UnsupportedSystemError{msg: "your architecture, " + GoArch + ", is not supported"}
+		return "", &UnsupportedSystemError{msg: "your architecture, " + goarch + ", is not supported"}
 	}
 }
 
 func arm64ArchFromOSNameAndVersion(platform string, osName string, mongoVersion []int) (string, error) {
 	// version numbers extracted from https://www.mongodb.com/download-center/community/releases/archive
-	if !versionGTE(mongoVersion, []int{3, 4, 0}) {
+	if !versionGTE(mongoVersion, minARM64Version) {
 		return "", &UnsupportedSystemError{msg: "arm64 support was introduced in Mongo 3.4.0"}
 	}
 
-	// ubuntu1604 arm support was introduced in version 3.4.0 and removed in version 4.0.27
-	if osName == "ubuntu1604" && !versionGTE(mongoVersion, []int{4, 0, 27}) {
-		return "arm64", nil
-	}
-
-	if osName == "ubuntu1804" && versionGTE(mongoVersion, []int{4, 2, 0}) {
-		return "aarch64", nil
-	}
-
-	if osName == "ubuntu2004" && versionGTE(mongoVersion, []int{4, 4, 0}) {
-		return "aarch64", nil
-	}
-
-	if osName == "ubuntu2204" && versionGTE(mongoVersion, []int{6, 0, 4}) {
-		return "aarch64", nil
-	}
-
-	if osName == "amazon2" && versionGTE(mongoVersion, []int{4, 2, 13}) {
-		return "aarch64", nil
-	}
-
-	// TODO: "rhel82" isn't a value that osName can have yet as osNameFromOsRelease doesn't support this version
-	if osName == "rhel82" && versionGTE(mongoVersion, []int{4, 4, 4}) {
-		return "aarch64", nil
+	if distro, ok := findDistro(osName); ok && distro.arm64 != "" {
+		if versionGTE(mongoVersion, distro.arm64MinVersion) &&
+			(distro.arm64MaxVersion == nil || !versionGTE(mongoVersion, distro.arm64MaxVersion)) {
+			return distro.arm64, nil
+		}
 	}
 
-	if platform == "osx" && versionGTE(mongoVersion, []int{6, 0, 0}) {
+	if platform == "osx" && versionGTE(mongoVersion, minMacARM64Version) {
 		return "arm64", nil
 	}
 
@@ -196,8 +199,7 @@ func arm64ArchFromOSNameAndVersion(platform string, osName string, mongoVersion
 		os = platform
 	}
 
-	versionString := fmt.Sprintf("%d.%d.%d", mongoVersion[0], mongoVersion[1], mongoVersion[2])
-	return "", &UnsupportedSystemError{msg: "Mongo doesn't support your environment, " + os + "/" + GoArch + ", on version " + versionString}
+	return "", &UnsupportedSystemError{msg: "Mongo doesn't support your environment, " + os + "/arm64, on version " + formatVersion(mongoVersion)}
 }
 
 func detectOSName(mongoVersion []int) string {
@@ -242,78 +244,13 @@ func versionGTE(a []int, b []int) bool {
 }
 
 func osNameFromOsRelease(osRelease map[string]string, mongoVersion []int) string {
-	id := osRelease["ID"]
-
 	majorVersionString := strings.Split(osRelease["VERSION_ID"], ".")[0]
 	majorVersion, err := strconv.Atoi(majorVersionString)
 	if err != nil {
 		return ""
 	}
 
-	switch id {
-	case "ubuntu":
-		return osNameFromUbuntuRelease(majorVersion, mongoVersion)
-	case "sles":
-		if majorVersion >= 12 {
-			return "suse12"
-		}
-	case "centos", "rhel":
-		if majorVersion >= 8 {
-			return "rhel80"
-		}
-		if majorVersion == 7 {
-			return "rhel70"
-		}
-	case "debian":
-		return osNameFromDebianRelease(majorVersion, mongoVersion)
-	case "amzn":
-		return osNameFromAmznRelease(majorVersion, mongoVersion)
-	}
-
-	return ""
-}
-func osNameFromUbuntuRelease(majorVersion int, mongoVersion []int) string {
-	if majorVersion >= 22 && versionGTE(mongoVersion, []int{6, 0, 4}) {
-		return "ubuntu2204"
-	}
-	if majorVersion >= 20 && versionGTE(mongoVersion, []int{4, 4, 0}) {
-		return "ubuntu2004"
-	}
-	if majorVersion >= 18 && versionGTE(mongoVersion, []int{4, 0, 1}) {
-		return "ubuntu1804"
-	}
-	if majorVersion >= 16 && versionGTE(mongoVersion, []int{3, 2, 7}) {
-		return "ubuntu1604"
-	}
-	if majorVersion >= 14 {
-		return "ubuntu1404"
-	}
-	return ""
-}
-
-func osNameFromDebianRelease(majorVersion int, mongoVersion []int) string {
-	if majorVersion >= 11 && versionGTE(mongoVersion, []int{5, 0, 8}) {
-		return "debian11"
-	}
-	if majorVersion >= 10 && versionGTE(mongoVersion, []int{4, 2, 1}) {
-		return "debian10"
-	}
-	if majorVersion >= 9 && versionGTE(mongoVersion, []int{3, 6, 5}) {
-		return "debian92"
-	}
-	if majorVersion >= 8 && versionGTE(mongoVersion, []int{3, 2, 8}) {
-		return "debian81"
-	}
-	return ""
-}
-
-func osNameFromAmznRelease(majorVersion int, mongoVersion []int) string {
-	if majorVersion == 2 && versionGTE(mongoVersion, []int{4, 0, 0}) {
-		return "amazon2"
-	}
-
-	// Version before 2 has the release date, not a real version number
-	return "amazon"
+	return osNameFromRelease(osRelease["ID"], majorVersion, mongoVersion)
 }
 
 func osNameFromRedhatRelease(redhatRelease string) string {
@@ -2,7 +2,7 @@ package mongobin
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
@@ -15,6 +15,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/100mslive/memongo/v2/memongolog"
@@ -23,49 +24,217 @@ import (
 
 var Afs afero.Afero
 
+// ErrTransientDownload is wrapped by download errors that may go away if the
+// download is tried again, such as network errors and 5xx responses
+var ErrTransientDownload = errors.New("transient download error")
+
 func init() {
 	Afs = afero.Afero{
 		Fs: afero.NewOsFs(),
 	}
 }
 
-// GetOrDownloadMongod returns the path to the mongod binary from the tarball
-// at the given URL. If the URL has not yet been downloaded, it's downloaded
-// and saved the the cache. If it has been downloaded, the existing mongod
-// path is returned.
-func GetOrDownloadMongod(urlStr string, cachePath string, logger *memongolog.Logger) (string, error) {
-	dirname, dirErr := directoryNameForURL(urlStr)
-	if dirErr != nil {
-		return "", dirErr
+// Downloader fetches the archive at a download URL. Errors that may go away
+// if the download is tried again should wrap ErrTransientDownload, as
+// should errors reading the archive.
+type Downloader interface {
+	Download(urlStr string) (io.ReadCloser, error)
+}
+
+// ContextDownloader is a Downloader that can stop downloading when a context
+// is done. GetOrDownloadContext uses DownloadContext if the Downloader has
+// it; otherwise it closes the archive Download returned once the context is
+// done.
+type ContextDownloader interface {
+	Downloader
+	DownloadContext(ctx context.Context, urlStr string) (io.ReadCloser, error)
+}
+
+// HTTPDownloader downloads archives with an HTTP GET. It's the Downloader
+// GetOrDownload uses by default.
+type HTTPDownloader struct{}
+
+// Download GETs urlStr, returning the response body if the status is 200.
+// Network errors and 5xx responses are transient.
+func (d HTTPDownloader) Download(urlStr string) (io.ReadCloser, error) {
+	return d.DownloadContext(context.Background(), urlStr)
+}
+
+// DownloadContext is Download with a request that's canceled when ctx is
+// done
+func (HTTPDownloader) DownloadContext(ctx context.Context, urlStr string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting tarball from %s: %s", urlStr, err)
 	}
 
-	dirPath := path.Join(cachePath, dirname)
-	mongodPath := path.Join(dirPath, "mongod")
+	// nolint:gosec
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("error getting tarball from %s: %w", urlStr, ctx.Err())
+		}
+		return nil, fmt.Errorf("%w: error getting tarball from %s: %s", ErrTransientDownload, urlStr, err)
+	}
 
-	// Check the cache
-	existsInCache, existsErr := Afs.Exists(mongodPath)
-	if existsErr != nil {
-		return "", fmt.Errorf("error while checking for mongod in cache: %s", existsErr)
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return nil, fmt.Errorf("%w: HTTP request failed with status code %d", ErrTransientDownload, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("HTTP request failed with status code %d", resp.StatusCode)
+	}
+
+	return resp.Body, nil
+}
+
+// download starts downloading urlStr with downloader, stopping once ctx is
+// done
+func download(ctx context.Context, downloader Downloader, urlStr string) (io.ReadCloser, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error getting tarball from %s: %w", urlStr, err)
+	}
+
+	if d, ok := downloader.(ContextDownloader); ok {
+		return d.DownloadContext(ctx, urlStr)
+	}
+
+	body, err := downloader.Download(urlStr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Closing the body interrupts a read that's blocked on the network
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = body.Close()
+		case <-done:
+		}
+	}()
+
+	return &cancelableBody{ReadCloser: body, done: done}, nil
+}
+
+// cancelableBody is the body of a download that's closed when its context
+// is done, unless it's been closed first
+type cancelableBody struct {
+	io.ReadCloser
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (b *cancelableBody) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+
+	return b.ReadCloser.Close()
+}
+
+// contextReader reads from r until ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	err := r.ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	return r.r.Read(p)
+}
+
+// GetOrDownload returns the path to the mongod binary from the archive at
+// opts.DownloadURL. If it hasn't been downloaded yet, it's downloaded with
+// opts.Downloader and saved to the cache at opts.CachePath, unless
+// opts.Offline is set, in which case it returns an error wrapping
+// ErrOffline. If it has, the existing mongod path is returned. The
+// read-only caches at opts.ReadOnlyCachePaths are looked in first.
+func GetOrDownload(opts DownloadOptions, logger *memongolog.Logger) (string, error) {
+	return GetOrDownloadContext(context.Background(), opts, logger)
+}
+
+// GetOrDownloadContext is GetOrDownload, giving up on downloading and
+// extracting the archive once ctx is done, with an error wrapping ctx's.
+// Binaries are moved into the cache once they're complete, so nothing is
+// left half-written there.
+func GetOrDownloadContext(ctx context.Context, opts DownloadOptions, logger *memongolog.Logger) (string, error) {
+	err := opts.validate()
+	if err != nil {
+		return "", err
 	}
+
+	return getOrDownloadBinary(ctx, opts, "mongod", logger)
+}
+
+// GetOrDownloadMongos is like GetOrDownload, for the mongos binary from the
+// same archive, which sharded clusters route through. It's extracted along
+// with mongod, but an archive cached before mongos was extracted is
+// downloaded again, unless opts.Offline is set.
+func GetOrDownloadMongos(opts DownloadOptions, logger *memongolog.Logger) (string, error) {
+	return GetOrDownloadMongosContext(context.Background(), opts, logger)
+}
+
+// GetOrDownloadMongosContext is GetOrDownloadMongos, giving up once ctx is
+// done like GetOrDownloadContext
+func GetOrDownloadMongosContext(ctx context.Context, opts DownloadOptions, logger *memongolog.Logger) (string, error) {
+	err := opts.validate()
+	if err != nil {
+		return "", err
+	}
+
+	return getOrDownloadBinary(ctx, opts, "mongos", logger)
+}
+
+// getOrDownload is GetOrDownload without the validation, which the
+// deprecated functions skip to behave as they always have
+func getOrDownload(opts DownloadOptions, logger *memongolog.Logger) (string, error) {
+	return getOrDownloadBinary(context.Background(), opts, "mongod", logger)
+}
+
+// getOrDownloadBinary returns the path to the binary called name from the
+// archive at opts.DownloadURL, downloading the archive if it isn't cached
+func getOrDownloadBinary(ctx context.Context, opts DownloadOptions, name string, logger *memongolog.Logger) (string, error) {
+	urlStr, cachePath := opts.DownloadURL, opts.CachePath
+	logger = logger.With("download", path.Base(urlStr))
+
+	sharedPath, err := readOnlyCached(opts, name, logger)
+	if err != nil {
+		return "", err
+	}
+	if sharedPath != "" {
+		return useReadOnlyCached(opts, sharedPath, name, logger)
+	}
+
+	binPath, existsInCache, err := cachedBinaryPath(urlStr, cachePath, name)
+	if err != nil {
+		return "", err
+	}
+	dirPath := filepath.Dir(binPath)
+
 	if existsInCache {
-		logger.Debugf("mongod from %s exists in cache at %s", urlStr, mongodPath)
-		return mongodPath, nil
+		logger.Debugf("%s from %s exists in cache at %s", name, urlStr, binPath)
+		return binPath, nil
 	}
 
-	logger.Infof("mongod from %s does not exist in cache, downloading to %s", urlStr, mongodPath)
+	if opts.Offline {
+		return "", fmt.Errorf("%s from %s is not in the cache at %s, and %w", name, urlStr, cachePath, ErrOffline)
+	}
+
+	logger.Infof("%s from %s does not exist in cache, downloading to %s", name, urlStr, binPath)
 	downloadStartTime := time.Now()
 
 	// Download the file
-	// nolint:gosec
-	resp, httpGetErr := http.Get(urlStr)
-	if httpGetErr != nil {
-		return "", fmt.Errorf("error getting tarball from %s: %s", urlStr, httpGetErr)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP request failed with status code %d", resp.StatusCode)
+	body, err := download(ctx, opts.downloader(), urlStr)
+	if err != nil {
+		return "", err
 	}
+	defer body.Close()
 
 	tgzTempFile, tmpFileErr := Afs.TempFile("", "")
 	if tmpFileErr != nil {
@@ -76,9 +245,12 @@ func GetOrDownloadMongod(urlStr string, cachePath string, logger *memongolog.Log
 		_ = Afs.Remove(tgzTempFile.Name())
 	}()
 
-	_, copyErr := io.Copy(tgzTempFile, resp.Body)
+	_, copyErr := io.Copy(tgzTempFile, body)
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("error downloading tarball from %s: %w", urlStr, ctx.Err())
+	}
 	if copyErr != nil {
-		return "", fmt.Errorf("error downloading tarball from %s: %s", urlStr, copyErr)
+		return "", fmt.Errorf("%w: error downloading tarball from %s: %s", ErrTransientDownload, urlStr, copyErr)
 	}
 
 	_, seekErr := tgzTempFile.Seek(0, 0)
@@ -86,41 +258,151 @@ func GetOrDownloadMongod(urlStr string, cachePath string, logger *memongolog.Log
 		return "", fmt.Errorf("error seeking back to start of file: %s", seekErr)
 	}
 
-	// Extract mongod
-	gzReader, gzErr := gzip.NewReader(tgzTempFile)
-	if gzErr != nil {
-		return "", fmt.Errorf("error initializing gzip reader from %s: %w, %s", tgzTempFile.Name(), gzErr, urlStr)
+	// Extract mongod and mongos
+	extracted, err := extractBinaries(contextReader{ctx: ctx, r: tgzTempFile}, urlStr, dirPath, name, logger)
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("error extracting %s from %s: %w", name, urlStr, ctx.Err())
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, extractedPath := range extracted {
+		_, err = recordSHA256(extractedPath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	logger.Infof("finished downloading %s to %s in %s", name, binPath, time.Since(downloadStartTime).String())
+
+	return binPath, nil
+}
+
+// archiveBinaries are the binaries extracted from an archive
+var archiveBinaries = []string{"mongod", "mongos"}
+
+// extractBinaries extracts the mongod and mongos binaries from the compressed
+// tar read from r, which was downloaded from urlStr, into dirPath, and
+// returns their paths. It's an error if mongod or required isn't in the
+// archive.
+func extractBinaries(r io.Reader, urlStr string, dirPath string, required string, logger *memongolog.Logger) ([]string, error) {
+	name := urlStr
+	if urlParsed, err := url.Parse(urlStr); err == nil {
+		name = path.Base(urlParsed.Path)
+	}
+
+	archive, err := openArchive(r, name)
+	if err != nil {
+		return nil, fmt.Errorf("%w (from %s)", err, urlStr)
 	}
-	defer gzReader.Close()
+	defer archive.Close()
 
-	tarReader := tar.NewReader(gzReader)
-	for {
+	found := map[string]bool{}
+	var extracted []string
+	tarReader := tar.NewReader(archive)
+	for len(found) < len(archiveBinaries) {
 		nextFile, tarErr := tarReader.Next()
 		if tarErr == io.EOF {
-			return "", fmt.Errorf("did not find a mongod binary in the tar from %s", urlStr)
+			break
 		}
 		if tarErr != nil {
-			return "", fmt.Errorf("error reading from tar: %s", tarErr)
+			return nil, fmt.Errorf("error reading from tar: %s", tarErr)
 		}
 
-		if strings.HasSuffix(nextFile.Name, "bin/mongod") {
-			err := saveFile(path.Join(dirPath, filepath.Base(nextFile.Name)), tarReader, logger)
-			if err != nil {
-				return "", err
+		for _, binary := range archiveBinaries {
+			if !found[binary] && strings.HasSuffix(nextFile.Name, "bin/"+binary) {
+				binPath := filepath.Join(dirPath, binary)
+				err := saveFile(binPath, tarReader, logger)
+				if err != nil {
+					return nil, err
+				}
+				found[binary] = true
+				extracted = append(extracted, binPath)
 			}
-			break
 		}
 	}
 
-	logger.Infof("finished downloading mongod to %s in %s", mongodPath, time.Since(downloadStartTime).String())
+	for _, binary := range []string{"mongod", required} {
+		if !found[binary] {
+			return nil, fmt.Errorf("did not find a %s binary in the tar from %s", binary, urlStr)
+		}
+	}
+
+	return extracted, nil
+}
+
+// IsCached returns true if the mongod binary from the archive at
+// opts.DownloadURL has already been downloaded to the cache at
+// opts.CachePath, or is in one of opts.ReadOnlyCachePaths
+func IsCached(opts DownloadOptions) (bool, error) {
+	err := opts.validate()
+	if err != nil {
+		return false, err
+	}
+
+	for _, cachePath := range append(append([]string(nil), opts.ReadOnlyCachePaths...), opts.CachePath) {
+		_, existsInCache, err := cachedMongodPath(opts.DownloadURL, cachePath)
+		if err != nil || existsInCache {
+			return existsInCache, err
+		}
+	}
+
+	return false, nil
+}
+
+// GetOrDownloadMongod returns the path to the mongod binary from the tarball
+// at the given URL. If the URL has not yet been downloaded, it's downloaded
+// and saved the the cache. If it has been downloaded, the existing mongod
+// path is returned.
+//
+// Deprecated: use GetOrDownload.
+func GetOrDownloadMongod(urlStr string, cachePath string, logger *memongolog.Logger) (string, error) {
+	return getOrDownload(DownloadOptions{DownloadURL: urlStr, CachePath: cachePath}, logger)
+}
+
+// GetOrDownloadMongodWith is like GetOrDownloadMongod, but downloads with
+// downloader
+//
+// Deprecated: use GetOrDownload with DownloadOptions.Downloader.
+func GetOrDownloadMongodWith(urlStr string, cachePath string, downloader Downloader, logger *memongolog.Logger) (string, error) {
+	return getOrDownload(DownloadOptions{DownloadURL: urlStr, CachePath: cachePath, Downloader: downloader}, logger)
+}
+
+// IsMongodCached returns true if the mongod binary from the tarball at the
+// given URL has already been downloaded to the cache
+//
+// Deprecated: use IsCached.
+func IsMongodCached(urlStr string, cachePath string) (bool, error) {
+	_, existsInCache, err := cachedMongodPath(urlStr, cachePath)
+	return existsInCache, err
+}
+
+func cachedMongodPath(urlStr string, cachePath string) (string, bool, error) {
+	return cachedBinaryPath(urlStr, cachePath, "mongod")
+}
+
+// cachedBinaryPath returns where the binary called name from the archive at
+// urlStr is cached, and whether it's there
+func cachedBinaryPath(urlStr string, cachePath string, name string) (string, bool, error) {
+	dirname, dirErr := directoryNameForURL(urlStr)
+	if dirErr != nil {
+		return "", false, dirErr
+	}
+
+	binPath := filepath.Join(cachePath, dirname, name)
 
-	return mongodPath, nil
+	existsInCache, existsErr := Afs.Exists(binPath)
+	if existsErr != nil {
+		return "", false, fmt.Errorf("error while checking for %s in cache: %s", name, existsErr)
+	}
+
+	return binPath, existsInCache, nil
 }
 
-func saveFile(mongodPath string, tarReader *tar.Reader, logger *memongolog.Logger) error {
-	mkdirErr := Afs.MkdirAll(path.Dir(mongodPath), 0755)
+func saveFile(mongodPath string, r io.Reader, logger *memongolog.Logger) error {
+	mkdirErr := Afs.MkdirAll(filepath.Dir(mongodPath), 0755)
 	if mkdirErr != nil {
-		return fmt.Errorf("error creating directory %s: %s", path.Dir(mongodPath), mkdirErr)
+		return fmt.Errorf("error creating directory %s: %s", filepath.Dir(mongodPath), mkdirErr)
 	}
 
 	// Extract to a temp file first, then copy to the destination, so we get
@@ -133,8 +415,10 @@ func saveFile(mongodPath string, tarReader *tar.Reader, logger *memongolog.Logge
 		_ = mongodTmpFile.Close()
 	}()
 
-	_, writeErr := io.Copy(mongodTmpFile, tarReader)
+	_, writeErr := io.Copy(mongodTmpFile, r)
 	if writeErr != nil {
+		_ = mongodTmpFile.Close()
+		_ = Afs.Remove(mongodTmpFile.Name())
 		return fmt.Errorf("error writing mongod binary at %s: %s", mongodTmpFile.Name(), writeErr)
 	}
 
@@ -143,23 +427,19 @@ func saveFile(mongodPath string, tarReader *tar.Reader, logger *memongolog.Logge
 	renameErr := Afs.Rename(mongodTmpFile.Name(), mongodPath)
 	if renameErr != nil {
 		linkErr := &os.LinkError{}
-		if errors.As(renameErr, &linkErr) {
-			// If /tmp is on another filesystem, we have to copy the file instead.
-			logger.Debugf("Unable to move %s to %s, copying instead", mongodTmpFile.Name(), mongodPath)
-			mongodFile, err := Afs.Create(mongodPath)
-			if err != nil {
-				return fmt.Errorf("creating mongod binary at %s: %s", mongodTmpFile, err)
-			}
-			defer mongodFile.Close()
+		if !errors.As(renameErr, &linkErr) {
+			_ = Afs.Remove(mongodTmpFile.Name())
+			return fmt.Errorf("error moving mongod binary to %s: %s", mongodPath, renameErr)
+		}
 
-			content, err := Afs.ReadFile(mongodTmpFile.Name())
-			if err != nil {
-				return fmt.Errorf("read file err: %w", err)
-			}
-			_, copyErr := mongodFile.Write(content)
-			if copyErr != nil {
-				return fmt.Errorf("error copying mongod binary from %s to %s: %w", mongodTmpFile.Name(), mongodPath, copyErr)
-			}
+		// If /tmp is on another filesystem, we have to copy the file instead.
+		// Copy it next to the destination and rename it from there, so other
+		// processes never see a partially written binary.
+		logger.Debugf("Unable to move %s to %s, copying instead", mongodTmpFile.Name(), mongodPath)
+		err := copyIntoPlace(mongodTmpFile.Name(), mongodPath)
+		_ = Afs.Remove(mongodTmpFile.Name())
+		if err != nil {
+			return err
 		}
 	}
 
@@ -171,6 +451,37 @@ func saveFile(mongodPath string, tarReader *tar.Reader, logger *memongolog.Logge
 	return nil
 }
 
+// copyIntoPlace copies src to a temp file in the directory of dst, then
+// renames it to dst
+func copyIntoPlace(src string, dst string) error {
+	content, err := Afs.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("read file err: %w", err)
+	}
+
+	dstTmpFile, err := Afs.TempFile(filepath.Dir(dst), "mongod")
+	if err != nil {
+		return fmt.Errorf("creating mongod binary at %s: %s", dst, err)
+	}
+	defer func() {
+		_ = dstTmpFile.Close()
+		_ = Afs.Remove(dstTmpFile.Name())
+	}()
+
+	_, copyErr := dstTmpFile.Write(content)
+	if copyErr != nil {
+		return fmt.Errorf("error copying mongod binary from %s to %s: %w", src, dst, copyErr)
+	}
+	_ = dstTmpFile.Close()
+
+	renameErr := Afs.Rename(dstTmpFile.Name(), dst)
+	if renameErr != nil {
+		return fmt.Errorf("error moving mongod binary to %s: %s", dst, renameErr)
+	}
+
+	return nil
+}
+
 // After the download a tarball, we extract it to a directory in the cache.
 // We want the name of this directory to be both human-redable, and also
 // unique (no two URLs should have the same directory name). We can't just
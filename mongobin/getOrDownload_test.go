@@ -59,7 +59,9 @@ func TestGetOrDownloadDifferentFilesystems(t *testing.T) {
 	defer ctrl.Finish()
 	m := mockAfero.NewMockFs(ctrl)
 
-	m.EXPECT().Rename(gomock.Any(), gomock.Any()).Return(&os.LinkError{"rename", "oldname", "newname", errors.New("rename error")}).Times(1)
+	m.EXPECT().Rename(gomock.Any(), gomock.Any()).Return(&os.LinkError{Op: "rename", Old: "oldname", New: "newname", Err: errors.New("rename error")}).Times(1)
+	// The copy is renamed into place from the cache directory
+	m.EXPECT().Rename(gomock.Any(), gomock.Any()).DoAndReturn(func(oldname string, newname string) error { return FS.Rename(oldname, newname) }).Times(1)
 
 	// General mock faking :)
 	m.EXPECT().Mkdir(gomock.Any(), gomock.Any()).DoAndReturn(func(dir string, perm fs.FileMode) error { return FS.Mkdir(dir, perm) }).AnyTimes()
@@ -0,0 +1,108 @@
+package mongobin
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+)
+
+// Import puts the mongod binary from artifact, either an archive like the
+// ones GetOrDownload downloads or a mongod binary, in the cache where
+// GetOrDownload looks for the download from opts.DownloadURL. check is
+// called with the path of the extracted binary before it's put in place,
+// and an error from it rejects the artifact. It returns the binary's path in
+// the cache.
+func Import(artifact string, opts DownloadOptions, check func(string) error, logger *memongolog.Logger) (string, error) {
+	err := opts.validate()
+	if err != nil {
+		return "", err
+	}
+
+	return importMongod(artifact, opts, check, logger)
+}
+
+// importMongod is Import without the validation, which ImportMongod skips
+// to behave as it always has
+func importMongod(artifact string, opts DownloadOptions, check func(string) error, logger *memongolog.Logger) (string, error) {
+	logger = logger.With("import", filepath.Base(artifact))
+
+	mongodPath, _, err := cachedMongodPath(opts.DownloadURL, opts.CachePath)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := Afs.Open(artifact)
+	if err != nil {
+		return "", fmt.Errorf("error opening %s: %w", artifact, err)
+	}
+	defer f.Close()
+
+	tmpDir, err := Afs.TempDir("", "memongo-import")
+	if err != nil {
+		return "", fmt.Errorf("error creating temp directory for mongod: %s", err)
+	}
+	defer func() {
+		_ = Afs.RemoveAll(tmpDir)
+	}()
+
+	// Extract the binary next to the archive's, so check sees what the cache
+	// will hold
+	br := bufio.NewReader(f)
+	head, err := br.Peek(maxMagicLength)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("error reading %s: %w", artifact, err)
+	}
+	_, isArchive := formatForMagic(head)
+	if !isArchive {
+		_, isArchive = formatForName(filepath.Base(artifact))
+	}
+
+	tmpPath := filepath.Join(tmpDir, "mongod")
+	if isArchive {
+		_, err = extractBinaries(br, artifact, tmpDir, "mongod", logger)
+	} else {
+		err = saveFile(tmpPath, br, logger)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if check != nil {
+		err = check(tmpPath)
+		if err != nil {
+			return "", fmt.Errorf("rejected %s: %w", artifact, err)
+		}
+	}
+
+	err = Afs.MkdirAll(filepath.Dir(mongodPath), 0755)
+	if err != nil {
+		return "", fmt.Errorf("error creating directory %s: %s", filepath.Dir(mongodPath), err)
+	}
+	err = copyIntoPlace(tmpPath, mongodPath)
+	if err != nil {
+		return "", err
+	}
+	err = Afs.Chmod(mongodPath, 0755)
+	if err != nil {
+		return "", fmt.Errorf("error chmod-ing mongodb binary at %s: %s", mongodPath, err)
+	}
+	_, err = recordSHA256(mongodPath)
+	if err != nil {
+		return "", err
+	}
+
+	logger.Infof("imported mongod from %s to %s", artifact, mongodPath)
+
+	return mongodPath, nil
+}
+
+// ImportMongod is like Import, for the download from urlStr into the cache
+// at cachePath
+//
+// Deprecated: use Import.
+func ImportMongod(artifact string, urlStr string, cachePath string, check func(string) error, logger *memongolog.Logger) (string, error) {
+	return importMongod(artifact, DownloadOptions{DownloadURL: urlStr, CachePath: cachePath}, check, logger)
+}
@@ -0,0 +1,67 @@
+package mongobin
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportMongod(t *testing.T) {
+	Afs = afero.Afero{Fs: afero.NewOsFs()}
+	logger := memongolog.New(nil, memongolog.LogLevelSilent)
+
+	rawPath := filepath.Join(t.TempDir(), "mongod")
+	require.NoError(t, os.WriteFile(rawPath, []byte(fakeMongod), 0700))
+
+	artifacts := map[string]string{"binary": rawPath}
+	for format, fixture := range archiveFixtures {
+		artifacts[format] = fixture
+	}
+
+	for name, artifact := range artifacts {
+		t.Run(name, func(t *testing.T) {
+			cachePath := t.TempDir()
+			urlStr := "https://fastdl.mongodb.org/linux/mongodb-test.tgz"
+
+			var checked string
+			mongodPath, err := ImportMongod(artifact, urlStr, cachePath, func(binPath string) error {
+				content, err := os.ReadFile(binPath)
+				checked = string(content)
+				return err
+			}, logger)
+			require.NoError(t, err)
+			assert.Equal(t, fakeMongod, checked)
+
+			content, err := os.ReadFile(mongodPath)
+			require.NoError(t, err)
+			assert.Equal(t, fakeMongod, string(content))
+
+			cached, err := IsMongodCached(urlStr, cachePath)
+			require.NoError(t, err)
+			assert.True(t, cached)
+		})
+	}
+}
+
+func TestImportMongodRejected(t *testing.T) {
+	Afs = afero.Afero{Fs: afero.NewOsFs()}
+	logger := memongolog.New(nil, memongolog.LogLevelSilent)
+
+	cachePath := t.TempDir()
+	urlStr := "https://fastdl.mongodb.org/linux/mongodb-test.tgz"
+	_, err := ImportMongod(archiveFixtures["gzip"], urlStr, cachePath, func(string) error {
+		return errors.New("not a mongod")
+	}, logger)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not a mongod")
+
+	cached, err := IsMongodCached(urlStr, cachePath)
+	require.NoError(t, err)
+	assert.False(t, cached)
+}
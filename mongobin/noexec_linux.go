@@ -0,0 +1,19 @@
+//go:build linux
+// +build linux
+
+package mongobin
+
+import "syscall"
+
+// isMountedNoexec reports whether dir is on a filesystem mounted noexec
+func isMountedNoexec(dir string) (bool, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(dir, &st)
+	if err != nil {
+		return false, err
+	}
+
+	// statfs reports mount flags with the same values as mount(2)
+	//nolint:unconvert // the type of Flags differs between architectures
+	return int64(st.Flags)&syscall.MS_NOEXEC != 0, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package mongobin
+
+// isMountedNoexec isn't implemented outside Linux; running a binary from a
+// noexec mount there fails when mongod is started
+func isMountedNoexec(dir string) (bool, error) {
+	return false, nil
+}
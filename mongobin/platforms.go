@@ -0,0 +1,179 @@
+package mongobin
+
+import (
+	"fmt"
+)
+
+// distroBuild describes a Linux distro MongoDB publishes builds for. The
+// table drives both detecting the distro we're running on and listing the
+// builds that exist, so the two can't disagree.
+type distroBuild struct {
+	// osName is the distro's name in download URLs
+	osName string
+
+	// ids are the os-release IDs the build is used for. Distros with no ids
+	// are never detected from os-release.
+	ids []string
+
+	// minRelease and maxRelease are the os-release major versions the build
+	// is used for. A maxRelease of 0 means there's no upper bound.
+	minRelease int
+	maxRelease int
+
+	// minVersion is the first MongoDB version built for the distro
+	minVersion []int
+
+	// arm64 is the architecture name of the distro's arm64 builds, or "" if
+	// there are none
+	arm64 string
+
+	// arm64MinVersion is the first MongoDB version with an arm64 build, and
+	// arm64MaxVersion the first one without (nil if it's still built)
+	arm64MinVersion []int
+	arm64MaxVersion []int
+}
+
+// minSupportedVersion is the oldest MongoDB version we know how to download
+var minSupportedVersion = []int{3, 2, 0}
+
+// minARM64Version is the first MongoDB version with any arm64 build
+var minARM64Version = []int{3, 4, 0}
+
+// minMacARM64Version is the first MongoDB version with a native macOS arm64
+// build
+var minMacARM64Version = []int{6, 0, 0}
+
+// maxGenericLinuxVersion is the first MongoDB version without generic linux
+// tarballs
+var maxGenericLinuxVersion = []int{4, 2, 0}
+
+// distroTable is ordered newest first within each distro, since the first
+// matching entry wins
+var distroTable = []distroBuild{
+	{osName: "ubuntu2204", ids: []string{"ubuntu"}, minRelease: 22, minVersion: []int{6, 0, 4}, arm64: "aarch64", arm64MinVersion: []int{6, 0, 4}},
+	{osName: "ubuntu2004", ids: []string{"ubuntu"}, minRelease: 20, minVersion: []int{4, 4, 0}, arm64: "aarch64", arm64MinVersion: []int{4, 4, 0}},
+	{osName: "ubuntu1804", ids: []string{"ubuntu"}, minRelease: 18, minVersion: []int{4, 0, 1}, arm64: "aarch64", arm64MinVersion: []int{4, 2, 0}},
+	{osName: "ubuntu1604", ids: []string{"ubuntu"}, minRelease: 16, minVersion: []int{3, 2, 7}, arm64: "arm64", arm64MinVersion: minARM64Version, arm64MaxVersion: []int{4, 0, 27}},
+	{osName: "ubuntu1404", ids: []string{"ubuntu"}, minRelease: 14, minVersion: minSupportedVersion},
+	{osName: "suse12", ids: []string{"sles"}, minRelease: 12, minVersion: minSupportedVersion},
+	{osName: "rhel80", ids: []string{"centos", "rhel"}, minRelease: 8, minVersion: minSupportedVersion},
+	{osName: "rhel70", ids: []string{"centos", "rhel"}, minRelease: 7, maxRelease: 7, minVersion: minSupportedVersion},
+	// TODO: rhel82 isn't detected from os-release yet
+	{osName: "rhel82", minVersion: []int{4, 4, 4}, arm64: "aarch64", arm64MinVersion: []int{4, 4, 4}},
+	// RHEL 6 has no os-release, it's detected from /etc/redhat-release
+	{osName: "rhel62", ids: []string{"centos", "rhel"}, minRelease: 6, maxRelease: 6, minVersion: minSupportedVersion},
+	{osName: "debian11", ids: []string{"debian"}, minRelease: 11, minVersion: []int{5, 0, 8}},
+	{osName: "debian10", ids: []string{"debian"}, minRelease: 10, minVersion: []int{4, 2, 1}},
+	{osName: "debian92", ids: []string{"debian"}, minRelease: 9, minVersion: []int{3, 6, 5}},
+	{osName: "debian81", ids: []string{"debian"}, minRelease: 8, minVersion: []int{3, 2, 8}},
+	{osName: "amazon2", ids: []string{"amzn"}, minRelease: 2, maxRelease: 2, minVersion: []int{4, 0, 0}, arm64: "aarch64", arm64MinVersion: []int{4, 2, 13}},
+	// Releases before Amazon Linux 2 have the release date, not a real
+	// version number
+	{osName: "amazon", ids: []string{"amzn"}, minVersion: minSupportedVersion},
+}
+
+// findDistro returns the table entry for osName
+func findDistro(osName string) (distroBuild, bool) {
+	for _, d := range distroTable {
+		if d.osName == osName {
+			return d, true
+		}
+	}
+
+	return distroBuild{}, false
+}
+
+// osNameFromRelease returns the name of the build for the os-release ID and
+// major version, or "" if there isn't one for mongoVersion
+func osNameFromRelease(id string, majorVersion int, mongoVersion []int) string {
+	for _, d := range distroTable {
+		if !hasID(d.ids, id) {
+			continue
+		}
+		if majorVersion < d.minRelease || (d.maxRelease != 0 && majorVersion > d.maxRelease) {
+			continue
+		}
+		if versionGTE(mongoVersion, d.minVersion) {
+			return d.osName
+		}
+	}
+
+	return ""
+}
+
+func hasID(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Build is a platform MongoDB publishes builds for, and the versions it
+// publishes them for
+type Build struct {
+	// GOOS and GOARCH are the Go names of the platform
+	GOOS   string
+	GOARCH string
+
+	// OSName is the Linux distro, as in DownloadSpec.OSName, or "" for macOS
+	// and generic Linux builds
+	OSName string
+
+	// Platform and Arch are the names used in download URLs
+	Platform string
+	Arch     string
+
+	// MinVersion is the first version built, and MaxVersion the first
+	// version that isn't, or "" if it's still built
+	MinVersion string
+	MaxVersion string
+}
+
+// SupportedBuilds returns the builds MakeDownloadSpec can choose from
+func SupportedBuilds() []Build {
+	builds := []Build{
+		{GOOS: "darwin", GOARCH: "amd64", Platform: "osx", Arch: "x86_64", MinVersion: formatVersion(minSupportedVersion)},
+		{GOOS: "darwin", GOARCH: "arm64", Platform: "osx", Arch: "arm64", MinVersion: formatVersion(minMacARM64Version)},
+		{GOOS: "linux", GOARCH: "amd64", Platform: "linux", Arch: "x86_64", MinVersion: formatVersion(minSupportedVersion), MaxVersion: formatVersion(maxGenericLinuxVersion)},
+	}
+
+	for _, d := range distroTable {
+		builds = append(builds, Build{
+			GOOS:       "linux",
+			GOARCH:     "amd64",
+			OSName:     d.osName,
+			Platform:   "linux",
+			Arch:       "x86_64",
+			MinVersion: formatVersion(d.minVersion),
+		})
+
+		if d.arm64 != "" {
+			min := d.arm64MinVersion
+			if !versionGTE(min, d.minVersion) {
+				min = d.minVersion
+			}
+			builds = append(builds, Build{
+				GOOS:       "linux",
+				GOARCH:     "arm64",
+				OSName:     d.osName,
+				Platform:   "linux",
+				Arch:       d.arm64,
+				MinVersion: formatVersion(min),
+				MaxVersion: formatVersion(d.arm64MaxVersion),
+			})
+		}
+	}
+
+	return builds
+}
+
+func formatVersion(v []int) string {
+	if v == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
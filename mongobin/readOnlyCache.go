@@ -0,0 +1,110 @@
+package mongobin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+)
+
+// mountedNoexec reports whether the filesystem dir is on forbids executing
+// files. It's a variable so tests can simulate a noexec mount.
+var mountedNoexec = isMountedNoexec
+
+// readOnlyCached returns the path to the binary called name from the archive
+// at opts.DownloadURL in the first of opts.ReadOnlyCachePaths that has it,
+// or "" if none do. A binary that doesn't match the checksum recorded next to
+// it is skipped with a warning.
+func readOnlyCached(opts DownloadOptions, name string, logger *memongolog.Logger) (string, error) {
+	for _, cachePath := range opts.ReadOnlyCachePaths {
+		binPath, exists, err := cachedBinaryPath(opts.DownloadURL, cachePath, name)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			continue
+		}
+
+		err = verifySHA256(binPath)
+		if err != nil {
+			logger.Warnf("Skipping %s in read-only cache %s: %s", name, cachePath, err)
+			continue
+		}
+
+		return binPath, nil
+	}
+
+	return "", nil
+}
+
+// useReadOnlyCached returns the path to run the binary called name found in
+// a read-only cache at sharedPath from: sharedPath itself, or a copy in
+// opts.CachePath if opts.CopyFromReadOnlyCache is set or sharedPath is on a
+// noexec mount
+func useReadOnlyCached(opts DownloadOptions, sharedPath string, name string, logger *memongolog.Logger) (string, error) {
+	if !opts.CopyFromReadOnlyCache {
+		noexec, err := mountedNoexec(filepath.Dir(sharedPath))
+		if err != nil {
+			logger.Debugf("Couldn't check whether %s is mounted noexec: %s", sharedPath, err)
+		}
+		if !noexec {
+			logger.Debugf("%s from %s exists in read-only cache at %s", name, opts.DownloadURL, sharedPath)
+			return sharedPath, nil
+		}
+
+		logger.Warnf("%s is on a filesystem mounted noexec, so it's copied to %s to run; set CopyFromReadOnlyCache to always copy it", sharedPath, opts.CachePath)
+	}
+
+	binPath, existsInCache, err := cachedBinaryPath(opts.DownloadURL, opts.CachePath, name)
+	if err != nil {
+		return "", err
+	}
+	if existsInCache {
+		logger.Debugf("%s from read-only cache at %s was already copied to %s", name, sharedPath, binPath)
+		return binPath, nil
+	}
+
+	f, err := Afs.Open(sharedPath)
+	if err != nil {
+		return "", fmt.Errorf("error opening %s to copy it: %w", sharedPath, err)
+	}
+	defer f.Close()
+
+	err = saveFile(binPath, f, logger)
+	if err != nil {
+		return "", err
+	}
+	_, err = recordSHA256(binPath)
+	if err != nil {
+		return "", err
+	}
+	logger.Infof("Copied %s from read-only cache at %s to %s", name, sharedPath, binPath)
+
+	return binPath, nil
+}
+
+// verifySHA256 returns an error if the binary at binPath doesn't match the
+// checksum recorded next to it. A binary without a recorded checksum passes.
+func verifySHA256(binPath string) error {
+	content, err := Afs.ReadFile(binPath + checksumSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading the checksum of %s: %w", binPath, err)
+	}
+
+	want := strings.TrimSpace(string(content))
+	got, err := hashFile(binPath)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("checksum mismatch: %s has sha256 %s, but %s%s records %s", binPath, got, filepath.Base(binPath), checksumSuffix, want)
+	}
+
+	return nil
+}
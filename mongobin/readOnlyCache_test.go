@@ -0,0 +1,151 @@
+package mongobin
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readOnlyCache populates a cache with the test archive's mongod, then makes
+// it read-only. It returns the cache and the download URL.
+func readOnlyCache(t *testing.T) (string, string) {
+	Afs = afero.Afero{Fs: afero.NewOsFs()}
+
+	server := httptest.NewServer(http.StripPrefix("/", http.FileServer(http.Dir("testdata/archives"))))
+	t.Cleanup(server.Close)
+	urlStr := server.URL + "/mongodb-test.tgz"
+
+	shared := t.TempDir()
+	_, err := GetOrDownload(DownloadOptions{CachePath: shared, DownloadURL: urlStr}, memongolog.New(nil, memongolog.LogLevelSilent))
+	require.NoError(t, err)
+
+	setMode := func(mode os.FileMode) {
+		err := filepath.Walk(shared, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.IsDir() {
+				return err
+			}
+			return os.Chmod(path, mode)
+		})
+		require.NoError(t, err)
+	}
+	setMode(0o555)
+	t.Cleanup(func() {
+		setMode(0o755)
+	})
+
+	return shared, urlStr
+}
+
+// cacheFiles lists the files in a cache
+func cacheFiles(t *testing.T, cachePath string) []string {
+	var files []string
+	err := filepath.Walk(cachePath, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, strings.TrimPrefix(path, cachePath))
+		}
+		return err
+	})
+	require.NoError(t, err)
+
+	return files
+}
+
+func TestReadOnlyCache(t *testing.T) {
+	shared, urlStr := readOnlyCache(t)
+	sharedFiles := cacheFiles(t, shared)
+	logger := memongolog.New(nil, memongolog.LogLevelSilent)
+
+	// Offline proves nothing is downloaded
+	opts := DownloadOptions{
+		CachePath:          t.TempDir(),
+		DownloadURL:        urlStr,
+		Offline:            true,
+		ReadOnlyCachePaths: []string{filepath.Join(t.TempDir(), "missing"), shared},
+	}
+	cached, err := IsCached(opts)
+	require.NoError(t, err)
+	assert.True(t, cached)
+
+	mongodPath, err := GetOrDownload(opts, logger)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(mongodPath, shared+string(filepath.Separator)), mongodPath)
+
+	// It's used in place: nothing was written to either cache
+	assert.Empty(t, cacheFiles(t, opts.CachePath))
+	assert.Equal(t, sharedFiles, cacheFiles(t, shared))
+
+	// Copying puts it in the writable cache, with its checksum
+	opts.CopyFromReadOnlyCache = true
+	copiedPath, err := GetOrDownload(opts, logger)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(copiedPath, opts.CachePath+string(filepath.Separator)), copiedPath)
+	contents, err := os.ReadFile(copiedPath)
+	require.NoError(t, err)
+	assert.Equal(t, fakeMongod, string(contents))
+
+	sharedSum, err := CachedSHA256(mongodPath)
+	require.NoError(t, err)
+	copiedSum, err := os.ReadFile(copiedPath + checksumSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, sharedSum+"\n", string(copiedSum))
+	assert.Equal(t, sharedFiles, cacheFiles(t, shared))
+}
+
+func TestReadOnlyCacheNoexec(t *testing.T) {
+	shared, urlStr := readOnlyCache(t)
+
+	orig := mountedNoexec
+	defer func() {
+		mountedNoexec = orig
+	}()
+	var checked string
+	mountedNoexec = func(dir string) (bool, error) {
+		checked = dir
+		return true, nil
+	}
+
+	out := &bytes.Buffer{}
+	logger := memongolog.New(log.New(out, "", 0), memongolog.LogLevelWarn)
+	opts := DownloadOptions{CachePath: t.TempDir(), DownloadURL: urlStr, Offline: true, ReadOnlyCachePaths: []string{shared}}
+	mongodPath, err := GetOrDownload(opts, logger)
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(checked, shared), checked)
+	assert.True(t, strings.HasPrefix(mongodPath, opts.CachePath+string(filepath.Separator)), mongodPath)
+	assert.Contains(t, out.String(), "mounted noexec")
+}
+
+func TestReadOnlyCacheChecksumMismatch(t *testing.T) {
+	shared, urlStr := readOnlyCache(t)
+	logger := memongolog.New(nil, memongolog.LogLevelSilent)
+
+	mongodPath, err := GetOrDownload(DownloadOptions{CachePath: shared, DownloadURL: urlStr, Offline: true}, logger)
+	require.NoError(t, err)
+	require.NoError(t, os.Chmod(filepath.Dir(mongodPath), 0o755))
+	require.NoError(t, os.WriteFile(mongodPath+checksumSuffix, []byte(strings.Repeat("0", 64)+"\n"), 0o644))
+
+	err = verifySHA256(mongodPath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+
+	// The tampered binary is skipped, so it has to be downloaded
+	opts := DownloadOptions{CachePath: t.TempDir(), DownloadURL: urlStr, Offline: true, ReadOnlyCachePaths: []string{shared}}
+	_, err = GetOrDownload(opts, logger)
+	assert.True(t, errors.Is(err, ErrOffline))
+
+	opts.Offline = false
+	downloadedPath, err := GetOrDownload(opts, logger)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(downloadedPath, opts.CachePath+string(filepath.Separator)), downloadedPath)
+}
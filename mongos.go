@@ -0,0 +1,287 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/100mslive/memongo/v2/retry"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// startRouters starts the mongos of Options.MongosCount other than the
+// server itself
+func (s *Server) startRouters(ctx context.Context) error {
+	for index := 1; index < s.opts.mongosCount(); index++ {
+		proc, err := s.launchRouter(ctx, index, 0, "")
+		if err != nil {
+			return fmt.Errorf("error starting mongos %d: %w", index, err)
+		}
+
+		s.cluster.mu.Lock()
+		s.cluster.routers = append(s.cluster.routers, proc)
+		s.cluster.mu.Unlock()
+	}
+
+	return nil
+}
+
+// launchRouter starts mongos index, routing to the cluster's config server,
+// with a directory of its own on a port from memberPort. If port and dbDir
+// are given, it's started again on them instead, and dbDir is kept if it
+// fails.
+func (s *Server) launchRouter(ctx context.Context, index int, port int, dbDir string) (*mongodProcess, error) {
+	env, err := s.opts.mongodEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	releasePort := func() {}
+	if port == 0 {
+		port, releasePort, err = s.memberPort()
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer releasePort()
+
+	keepDBDir := dbDir != ""
+	if dbDir == "" {
+		dbDir, err = mkdirTemp(s.opts.dataDirBase(), "memongo-"+mongosName(index)+"-", "mongos directory")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	binPath, caps := s.binPath, s.caps
+	s.mu.Unlock()
+
+	program, args := s.opts.mongodCommandLine(binPath, mongosArgs(&s.opts, caps, s.cluster.configServer.replicaHost(), port)...)
+	_, err = starts.acquire(ctx, s.opts.StartConcurrency)
+	if err != nil {
+		if !keepDBDir {
+			_ = removePath(dbDir)
+		}
+		return nil, err
+	}
+	proc, err := launchMongod(ctx, program, args, env, dbDir, keepDBDir, index, mongosName(index), caps.reReady, s.opts.startupWait(), s.logger, s.events)
+	starts.release()
+	if errors.Is(err, ErrPortInUse) {
+		err = fmt.Errorf("%w%s", err, describePortOwner(port))
+	}
+	if err != nil {
+		return nil, err
+	}
+	proc.version = s.version
+
+	return proc, nil
+}
+
+// mongosName names mongos index in logs and directory names
+func mongosName(index int) string {
+	return "mongos" + strconv.Itoa(index)
+}
+
+// mongosHosts returns the addresses of every mongos, in index order,
+// including those stopped with StopMongos
+func (s *Server) mongosHosts() []string {
+	hosts := []string{s.addr()}
+	if s.cluster == nil {
+		return hosts
+	}
+
+	s.cluster.mu.Lock()
+	defer s.cluster.mu.Unlock()
+	for _, router := range s.cluster.routers {
+		hosts = append(hosts, s.hostPort(router.host, router.port))
+	}
+
+	return hosts
+}
+
+// MongosURIs returns a direct connection URI for each mongos of a sharded
+// cluster, in index order: DirectURI, then one for each other mongos of
+// Options.MongosCount. URI names them all. For a server that isn't a
+// sharded cluster, it returns DirectURI.
+func (s *Server) MongosURIs() []string {
+	hosts := s.mongosHosts()
+	uris := make([]string, len(hosts))
+	for i, host := range hosts {
+		uris[i] = directURI(host)
+	}
+
+	return uris
+}
+
+// router returns mongos index, which must be one started for
+// Options.MongosCount other than the server itself
+func (s *Server) router(verb string, index int) (*mongodProcess, error) {
+	if err := s.checkRunning(); err != nil {
+		return nil, err
+	}
+	if s.cluster == nil {
+		return nil, fmt.Errorf("cannot %s mongos %d: %w", verb, index, ErrNotSharded)
+	}
+	if index == 0 {
+		return nil, fmt.Errorf("cannot %s mongos 0: it's the server itself", verb)
+	}
+
+	s.cluster.mu.Lock()
+	defer s.cluster.mu.Unlock()
+	if index < 0 || index > len(s.cluster.routers) {
+		return nil, fmt.Errorf("no mongos %d", index)
+	}
+
+	return s.cluster.routers[index-1], nil
+}
+
+// StopMongos shuts down mongos index of a sharded cluster started with
+// Options.MongosCount, for testing how clients fail over to the others. Like
+// StopWithContext, it kills mongos if it hasn't shut down once ctx is done,
+// or after 10 seconds if ctx has no deadline. Its port and directory are
+// kept for StartMongos, and URI still names it. Mongos 0, the server
+// itself, can't be stopped this way.
+func (s *Server) StopMongos(ctx context.Context, index int) error {
+	proc, err := s.router("stop", index)
+	if err != nil {
+		return err
+	}
+	if proc.hasExited() {
+		return fmt.Errorf("mongos %d is already stopped", index)
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, gracefulStopTimeout)
+		defer cancel()
+	}
+
+	s.logger.Debugf("Stopping mongos %d on port %d", index, proc.port)
+	proc.keepDBDir = true
+	err = proc.stopContext(ctx, s.logger.With("member", mongosName(index)), true)
+	trackPath(proc.dbDir, "mongos directory")
+	if err != nil {
+		return fmt.Errorf("error stopping mongos %d: %w", index, err)
+	}
+
+	return nil
+}
+
+// StartMongos starts mongos index, stopped with StopMongos, again on the
+// same port, and waits for it to answer isMaster. If something else took
+// the port while it was stopped, it returns ErrPortInUse.
+func (s *Server) StartMongos(ctx context.Context, index int) error {
+	proc, err := s.router("start", index)
+	if err != nil {
+		return err
+	}
+	if !proc.hasExited() {
+		return fmt.Errorf("mongos %d is running", index)
+	}
+
+	portTaken := func(err error) error {
+		return fmt.Errorf("error starting mongos %d: port %d was taken while it was stopped: %w", index, proc.port, err)
+	}
+	err = checkPortFree(proc.port)
+	if err != nil {
+		return portTaken(err)
+	}
+	restarted, err := s.launchRouter(ctx, index, proc.port, proc.dbDir)
+	if errors.Is(err, ErrPortInUse) {
+		return portTaken(err)
+	}
+	if err != nil {
+		return fmt.Errorf("error starting mongos %d: %w", index, err)
+	}
+
+	// The server may have been stopped while mongos was starting, and its
+	// routers with it
+	s.cluster.mu.Lock()
+	err = s.checkRunning()
+	if err == nil {
+		s.cluster.routers[index-1] = restarted
+	}
+	s.cluster.mu.Unlock()
+	if err != nil {
+		restarted.keepDBDir = true
+		_ = restarted.stop(s.logger, false)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.opts.ReplicaSetReadyTimeout)
+	defer cancel()
+
+	return waitForMongos(ctx, directURI(s.hostPort(restarted.host, restarted.port)))
+}
+
+// stopRouters stops the mongos started for Options.MongosCount other than
+// the server itself, leaving their directories behind if keepDBDirs is set,
+// and giving them until ctx is done to shut down. It returns the errors
+// stopping them.
+func (s *Server) stopRouters(ctx context.Context, keepDBDirs bool) []error {
+	if s.cluster == nil {
+		return nil
+	}
+
+	s.cluster.mu.Lock()
+	routers := s.cluster.routers
+	s.cluster.routers = nil
+	s.cluster.mu.Unlock()
+
+	var errs []error
+	for i, router := range routers {
+		router.keepDBDir = keepDBDirs
+		err := router.stopContext(ctx, s.logger.With("member", mongosName(i+1)), true)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("mongos %d: %w", i+1, err))
+		}
+	}
+
+	return errs
+}
+
+// waitForRouters waits for every mongos to answer isMaster, or for ctx to
+// be done
+func (s *Server) waitForRouters(ctx context.Context) error {
+	for index, uri := range s.MongosURIs() {
+		err := waitForMongos(ctx, uri)
+		if err != nil {
+			return fmt.Errorf("error waiting for mongos %d: %w", index, err)
+		}
+	}
+
+	return nil
+}
+
+// waitForMongos polls the mongos at uri until it answers isMaster as a
+// mongos, or ctx is done
+func waitForMongos(ctx context.Context, uri string) error {
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	return retry.Do(ctx, retry.Constant{Interval: primaryPollInterval}, func() error {
+		var result struct {
+			Msg string `bson:"msg"`
+		}
+		// hello was added in 4.4.2; older versions only have isMaster, which
+		// newer versions still accept
+		err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&result)
+		if err != nil {
+			return err
+		}
+		if result.Msg != "isdbgrid" {
+			return retry.Permanent(fmt.Errorf("%s isn't a mongos", RedactURI(uri)))
+		}
+
+		return nil
+	})
+}
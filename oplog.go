@@ -0,0 +1,146 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/100mslive/memongo/v2/retry"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// oplogReopenDelay is how long TailOplog waits before reopening a cursor the
+// server closed, which happens when there were no entries to return yet
+const oplogReopenDelay = 100 * time.Millisecond
+
+// oplogMaxAwaitTime is how long the server waits for new entries before
+// answering a getMore on the oplog cursor
+const oplogMaxAwaitTime = time.Second
+
+// TailOplog streams the entries of local.oplog.rs written at or after
+// startAt, and any written later, like a change-data-capture process tailing
+// the oplog would. Entries are sent until ctx is done or the returned stop
+// function is called. The channel is closed when tailing ends.
+//
+// The stop function waits for tailing to end, and returns the cursor error
+// that ended it, if any. It may be called more than once.
+func (s *Server) TailOplog(ctx context.Context, startAt time.Time) (<-chan bson.Raw, func() error, error) {
+	if !s.isReplicaSet {
+		return nil, nil, fmt.Errorf("cannot tail the oplog: the server wasn't started with ShouldUseReplica")
+	}
+
+	client, err := s.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	oplog := client.Database("local").Collection("oplog.rs")
+
+	// Open the first cursor before returning, so that problems such as the
+	// oplog not existing yet are reported straight away
+	start := bson.Timestamp{T: uint32(startAt.Unix())}
+	cursor, err := openOplogCursor(ctx, oplog, oplogFilter(start, true))
+	if err != nil {
+		cancel()
+		_ = client.Disconnect(context.Background())
+		return nil, nil, fmt.Errorf("error opening oplog cursor: %w", err)
+	}
+
+	entries := make(chan bson.Raw)
+	done := make(chan struct{})
+	var tailErr error
+
+	goTracked("oplog tail", func() {
+		defer close(done)
+		defer close(entries)
+		defer func() {
+			_ = client.Disconnect(context.Background())
+		}()
+
+		tailErr = tailOplog(ctx, oplog, cursor, start, entries)
+	})
+
+	stop := func() error {
+		cancel()
+		<-done
+		return tailErr
+	}
+
+	return entries, stop, nil
+}
+
+// oplogFilter matches the entries after ts, or at or after it if inclusive
+func oplogFilter(ts bson.Timestamp, inclusive bool) bson.D {
+	op := "$gt"
+	if inclusive {
+		op = "$gte"
+	}
+
+	return bson.D{{Key: "ts", Value: bson.D{{Key: op, Value: ts}}}}
+}
+
+func openOplogCursor(ctx context.Context, oplog *mongo.Collection, filter bson.D) (*mongo.Cursor, error) {
+	return oplog.Find(ctx, filter, options.Find().
+		SetCursorType(options.TailableAwait).
+		SetMaxAwaitTime(oplogMaxAwaitTime).
+		SetSort(bson.D{{Key: "$natural", Value: 1}}))
+}
+
+// errOplogCursorDead is returned by tailOplog's attempts when the server
+// closed the cursor, so it's reopened
+var errOplogCursorDead = errors.New("the oplog cursor was closed")
+
+// tailOplog sends the entries from cursor to entries until ctx is done. If
+// the server closes the cursor, it's reopened after the last entry sent, or
+// at start if there wasn't one.
+func tailOplog(ctx context.Context, oplog *mongo.Collection, cursor *mongo.Cursor, start bson.Timestamp, entries chan<- bson.Raw) error {
+	last := start
+	sent := false
+	err := retry.Do(ctx, retry.Constant{Interval: oplogReopenDelay}, func() error {
+		if cursor == nil {
+			var err error
+			cursor, err = openOplogCursor(ctx, oplog, oplogFilter(last, !sent))
+			if err != nil {
+				return retry.Permanent(fmt.Errorf("error reopening oplog cursor: %w", err))
+			}
+		}
+		defer func() {
+			_ = cursor.Close(context.Background())
+			cursor = nil
+		}()
+
+		for cursor.Next(ctx) {
+			entry := append(bson.Raw(nil), cursor.Current...)
+			if t, i, ok := entry.Lookup("ts").TimestampOK(); ok {
+				last = bson.Timestamp{T: t, I: i}
+				sent = true
+			}
+
+			select {
+			case entries <- entry:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		err := cursor.Err()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return retry.Permanent(fmt.Errorf("error tailing the oplog: %w", err))
+		}
+
+		// The cursor is dead, e.g. because nothing matched the filter yet
+		return errOplogCursorDead
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,15 @@
+package memongo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestOplogFilter(t *testing.T) {
+	ts := bson.Timestamp{T: 100, I: 2}
+
+	assert.Equal(t, bson.D{{Key: "ts", Value: bson.D{{Key: "$gte", Value: ts}}}}, oplogFilter(ts, true))
+	assert.Equal(t, bson.D{{Key: "ts", Value: bson.D{{Key: "$gt", Value: ts}}}}, oplogFilter(ts, false))
+}
@@ -0,0 +1,76 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// GetParameter returns the value of a server parameter, read with the
+// getParameter command
+func (s *Server) GetParameter(ctx context.Context, name string) (bson.RawValue, error) {
+	client, err := s.connect()
+	if err != nil {
+		return bson.RawValue{}, err
+	}
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+
+	result, err := client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "getParameter", Value: 1},
+		{Key: name, Value: 1},
+	}).Raw()
+	if err != nil {
+		return bson.RawValue{}, fmt.Errorf("error getting parameter %s: %w", name, err)
+	}
+
+	value, err := result.LookupErr(name)
+	if err != nil {
+		return bson.RawValue{}, fmt.Errorf("getParameter did not return %s", name)
+	}
+
+	return value, nil
+}
+
+// CursorTimeout returns how long idle cursors live on the server, as set by
+// Options.CursorTimeout
+func (s *Server) CursorTimeout(ctx context.Context) (time.Duration, error) {
+	value, err := s.GetParameter(ctx, "cursorTimeoutMillis")
+	if err != nil {
+		return 0, err
+	}
+
+	millis, ok := value.AsInt64OK()
+	if !ok {
+		return 0, fmt.Errorf("cursorTimeoutMillis is not a number: %s", value)
+	}
+
+	return time.Duration(millis) * time.Millisecond, nil
+}
+
+// IsMaxTimeMSEnforced returns true if every operation with maxTimeMS fails,
+// as set by Options.EnforceMaxTimeMS
+func (s *Server) IsMaxTimeMSEnforced(ctx context.Context) (bool, error) {
+	if !s.testCommandsEnabled() {
+		// Failpoints can't be set without test commands
+		return false, nil
+	}
+
+	value, err := s.GetParameter(ctx, "failpoint.maxTimeAlwaysTimeOut")
+	if err != nil {
+		return false, err
+	}
+
+	var failPoint struct {
+		Mode int `bson:"mode"`
+	}
+	err = value.Unmarshal(&failPoint)
+	if err != nil {
+		return false, fmt.Errorf("error reading failpoint.maxTimeAlwaysTimeOut: %w", err)
+	}
+
+	return failPoint.Mode != 0, nil
+}
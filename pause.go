@@ -0,0 +1,109 @@
+package memongo
+
+import (
+	"fmt"
+	"sync/atomic"
+	"syscall"
+)
+
+// PauseMember suspends the mongod of replica set member index, 0 for the
+// server itself, with SIGSTOP, so it stops answering without closing its
+// connections, as if it hung or the network to it went away. The rest of the
+// replica set carries on without it, and may elect another primary.
+// ResumeMember resumes it, and Stop resumes paused members before shutting
+// them down. It emits EventPaused.
+func (s *Server) PauseMember(index int) error {
+	proc, err := s.memberProcess(index)
+	if err != nil {
+		return err
+	}
+	if !atomic.CompareAndSwapInt32(&proc.paused, 0, 1) {
+		return fmt.Errorf("replica set member %d is already paused", index)
+	}
+
+	err = proc.cmd.Process.Signal(syscall.SIGSTOP)
+	if err != nil {
+		atomic.StoreInt32(&proc.paused, 0)
+		return fmt.Errorf("error pausing replica set member %d: %w", index, err)
+	}
+	s.logger.Debugf("Paused replica set member %d", index)
+	s.events.emit(EventPaused, index, nil)
+
+	return nil
+}
+
+// ResumeMember resumes the mongod of replica set member index, which
+// PauseMember suspended, with SIGCONT. It emits EventResumed.
+func (s *Server) ResumeMember(index int) error {
+	proc, err := s.memberProcess(index)
+	if err != nil {
+		return err
+	}
+	if atomic.LoadInt32(&proc.paused) == 0 {
+		return fmt.Errorf("replica set member %d isn't paused", index)
+	}
+
+	err = s.resume(proc, index)
+	if err != nil {
+		return fmt.Errorf("error resuming replica set member %d: %w", index, err)
+	}
+
+	return nil
+}
+
+// resume resumes proc, member index, if it's paused
+func (s *Server) resume(proc *mongodProcess, index int) error {
+	if !atomic.CompareAndSwapInt32(&proc.paused, 1, 0) {
+		return nil
+	}
+
+	err := proc.cmd.Process.Signal(syscall.SIGCONT)
+	if err != nil {
+		return err
+	}
+	s.logger.Debugf("Resumed replica set member %d", index)
+	s.events.emit(EventResumed, index, nil)
+
+	return nil
+}
+
+// resumeMembers resumes the server and the members PauseMember suspended, so
+// they can shut down cleanly
+func (s *Server) resumeMembers() {
+	s.mu.Lock()
+	procs := map[int]*mongodProcess{0: s.proc}
+	for index, member := range s.members {
+		procs[index] = member
+	}
+	s.mu.Unlock()
+
+	for index, proc := range procs {
+		err := s.resume(proc, index)
+		if err != nil {
+			s.logger.Warnf("error resuming replica set member %d: %s", index, err)
+		}
+	}
+}
+
+// memberProcess returns the mongod of replica set member index, 0 for the
+// server itself, if it's running
+func (s *Server) memberProcess(index int) (*mongodProcess, error) {
+	if err := s.checkRunning(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	proc, ok := s.proc, index == 0
+	if index != 0 {
+		proc, ok = s.members[index]
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no replica set member %d", index)
+	}
+	if proc.hasExited() {
+		return nil, fmt.Errorf("replica set member %d has exited", index)
+	}
+
+	return proc, nil
+}
@@ -0,0 +1,60 @@
+package memongo
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPauseMember(t *testing.T) {
+	server := fakeServer(t, Cleanup{})
+	defer server.Stop()
+	member := fakeProcess(t)
+	server.members[1] = member
+
+	assert.EqualError(t, server.PauseMember(2), "no replica set member 2")
+	assert.EqualError(t, server.ResumeMember(1), "replica set member 1 isn't paused")
+
+	require.NoError(t, server.PauseMember(1))
+	assert.EqualError(t, server.PauseMember(1), "replica set member 1 is already paused")
+	require.NoError(t, server.ResumeMember(1))
+	require.NoError(t, server.PauseMember(0))
+
+	var events []Event
+	for len(server.events.ch) > 0 {
+		events = append(events, <-server.events.ch)
+	}
+	require.Len(t, events, 3)
+	assert.Equal(t, EventPaused, events[0].Type)
+	assert.Equal(t, 1, events[0].Member)
+	assert.Equal(t, EventResumed, events[1].Type)
+	assert.Equal(t, 1, events[1].Member)
+	assert.Equal(t, EventPaused, events[2].Type)
+	assert.Equal(t, 0, events[2].Member)
+
+	// Stop resumes the paused server, rather than waiting out the graceful
+	// stop of a process that can't act on SIGTERM
+	start := time.Now()
+	server.Stop()
+	assert.Less(t, time.Since(start), gracefulStopTimeout)
+	assert.True(t, server.proc.hasExited())
+
+	var types []EventType
+	for e := range server.events.ch {
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []EventType{EventStopping, EventResumed, EventStopped}, types)
+
+	assert.True(t, errors.Is(server.PauseMember(0), ErrServerStopped))
+}
+
+func TestPauseExitedMember(t *testing.T) {
+	server := fakeServer(t, Cleanup{})
+	defer server.Stop()
+	killMongod(t, server)
+
+	assert.EqualError(t, server.PauseMember(0), "replica set member 0 has exited")
+}
@@ -0,0 +1,208 @@
+package memongo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// tcpListenState is the state of listening sockets in /proc/net/tcp
+const tcpListenState = "0A"
+
+// portOwner is a process listening on a port
+type portOwner struct {
+	pid  int
+	name string
+	// args is the process's command line, if it could be read
+	args []string
+}
+
+// describePortOwner returns a description of the process listening on port,
+// to add to ErrPortInUse, or "" if it can't be found out, e.g. because the
+// process belongs to another user
+func describePortOwner(port int) string {
+	owner, ok := findPortOwner(port)
+	if !ok {
+		return ""
+	}
+
+	desc := fmt.Sprintf("; port %d is held by %s (pid %d)", port, owner.name, owner.pid)
+	if dbPath := orphanDBPath(owner); dbPath != "" {
+		desc += fmt.Sprintf(", which looks like a mongod left behind by memongo (dbpath %s); stop it with kill %d", dbPath, owner.pid)
+	}
+
+	return desc
+}
+
+// orphanDBPath returns the dbpath of a mongod started by memongo, which are
+// temporary directories named memongo*, or "" if owner isn't one
+func orphanDBPath(owner portOwner) string {
+	if owner.name != "mongod" {
+		return ""
+	}
+
+	for i, arg := range owner.args {
+		if arg == "--dbpath" && i+1 < len(owner.args) && strings.HasPrefix(filepath.Base(owner.args[i+1]), "memongo") {
+			return owner.args[i+1]
+		}
+	}
+
+	return ""
+}
+
+func findPortOwner(port int) (portOwner, bool) {
+	if runtime.GOOS == "linux" {
+		return findPortOwnerProc("/proc", port)
+	}
+
+	return findPortOwnerLsof(port)
+}
+
+// findPortOwnerProc finds the process listening on port from the socket
+// tables and file descriptors under procRoot
+func findPortOwnerProc(procRoot string, port int) (portOwner, bool) {
+	inodes := map[string]bool{}
+	for _, table := range []string{"net/tcp", "net/tcp6"} {
+		for _, inode := range listeningInodes(filepath.Join(procRoot, table), port) {
+			inodes[inode] = true
+		}
+	}
+	if len(inodes) == 0 {
+		return portOwner{}, false
+	}
+
+	fds, err := filepath.Glob(filepath.Join(procRoot, "[0-9]*", "fd", "*"))
+	if err != nil {
+		return portOwner{}, false
+	}
+	for _, fd := range fds {
+		// Other users' file descriptors can't be read; skip them
+		link, err := os.Readlink(fd)
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		if !inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] {
+			continue
+		}
+
+		procDir := filepath.Dir(filepath.Dir(fd))
+		pid, err := strconv.Atoi(filepath.Base(procDir))
+		if err != nil {
+			continue
+		}
+
+		return procOwner(procDir, pid), true
+	}
+
+	return portOwner{}, false
+}
+
+// listeningInodes returns the inodes of the sockets listening on port in a
+// /proc/net/tcp table
+func listeningInodes(table string, port int) []string {
+	//nolint:gosec
+	f, err := os.Open(table)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var inodes []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when
+		// retrnsmt uid timeout inode ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != tcpListenState {
+			continue
+		}
+
+		i := strings.LastIndex(fields[1], ":")
+		if i == -1 {
+			continue
+		}
+		localPort, err := strconv.ParseUint(fields[1][i+1:], 16, 16)
+		if err != nil || int(localPort) != port {
+			continue
+		}
+
+		inodes = append(inodes, fields[9])
+	}
+
+	return inodes
+}
+
+// procOwner describes the process in procDir
+func procOwner(procDir string, pid int) portOwner {
+	owner := portOwner{pid: pid}
+
+	exe, err := os.Readlink(filepath.Join(procDir, "exe"))
+	if err == nil {
+		owner.name = filepath.Base(strings.TrimSuffix(exe, " (deleted)"))
+	} else if comm, err := os.ReadFile(filepath.Join(procDir, "comm")); err == nil {
+		owner.name = strings.TrimSpace(string(comm))
+	} else {
+		owner.name = "an unknown process"
+	}
+
+	cmdline, err := os.ReadFile(filepath.Join(procDir, "cmdline"))
+	if err == nil {
+		owner.args = strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	}
+
+	return owner
+}
+
+// findPortOwnerLsof finds the process listening on port with lsof, on
+// systems without /proc
+func findPortOwnerLsof(port int) (portOwner, bool) {
+	out, err := exec.Command("lsof", "-nP", fmt.Sprintf("-iTCP:%d", port), "-sTCP:LISTEN", "-Fpc").Output()
+	if err != nil {
+		return portOwner{}, false
+	}
+
+	owner, ok := parseLsof(out)
+	if !ok {
+		return portOwner{}, false
+	}
+
+	args, err := exec.Command("ps", "-o", "args=", "-p", strconv.Itoa(owner.pid)).Output()
+	if err == nil {
+		owner.args = strings.Fields(string(args))
+	}
+
+	return owner, true
+}
+
+// parseLsof reads the first process from lsof -Fpc output, which has a line
+// per field: "p1234" for the PID and "cmongod" for the command name
+func parseLsof(out []byte) (portOwner, bool) {
+	var owner portOwner
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		if len(line) < 2 {
+			continue
+		}
+
+		switch line[0] {
+		case 'p':
+			if owner.pid != 0 {
+				return owner, owner.name != ""
+			}
+			pid, err := strconv.Atoi(string(line[1:]))
+			if err != nil {
+				return portOwner{}, false
+			}
+			owner.pid = pid
+		case 'c':
+			owner.name = string(line[1:])
+		}
+	}
+
+	return owner, owner.pid != 0 && owner.name != ""
+}
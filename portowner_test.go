@@ -0,0 +1,65 @@
+package memongo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPortFreeNamesOwner(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		if _, err := exec.LookPath("lsof"); err != nil {
+			t.Skip("needs /proc or lsof")
+		}
+	}
+
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	err = checkPortFree(port)
+	require.True(t, errors.Is(err, ErrPortInUse))
+	assert.Contains(t, err.Error(), fmt.Sprintf("port %d is held by %s (pid %d)", port, filepath.Base(os.Args[0]), os.Getpid()))
+}
+
+func TestListeningInodes(t *testing.T) {
+	table := filepath.Join(t.TempDir(), "tcp")
+	require.NoError(t, os.WriteFile(table, []byte(
+		"  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"+
+			"   0: 0100007F:6989 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 123456 1 0000000000000000 100 0 0 10 0\n"+
+			"   1: 0100007F:6989 0100007F:D2F0 01 00000000:00000000 00:00000000 00000000  1000        0 123457 1 0000000000000000 20 4 30 10 -1\n"+
+			"   2: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 22222 1 0000000000000000 100 0 0 10 0\n",
+	), 0600))
+
+	// Only listening sockets count, not connections to the port
+	assert.Equal(t, []string{"123456"}, listeningInodes(table, 27017))
+	assert.Equal(t, []string{"22222"}, listeningInodes(table, 22))
+	assert.Empty(t, listeningInodes(table, 8080))
+	assert.Empty(t, listeningInodes(filepath.Join(t.TempDir(), "missing"), 22))
+}
+
+func TestParseLsof(t *testing.T) {
+	owner, ok := parseLsof([]byte("p4242\ncmongod\nf7\np4343\ncother\n"))
+	require.True(t, ok)
+	assert.Equal(t, portOwner{pid: 4242, name: "mongod"}, owner)
+
+	_, ok = parseLsof(nil)
+	assert.False(t, ok)
+}
+
+func TestOrphanDBPath(t *testing.T) {
+	orphan := portOwner{pid: 1, name: "mongod", args: []string{"/cache/mongod", "--dbpath", "/tmp/memongo123", "--port", "27017"}}
+	assert.Equal(t, "/tmp/memongo123", orphanDBPath(orphan))
+
+	assert.Empty(t, orphanDBPath(portOwner{pid: 1, name: "mongod", args: []string{"mongod", "--dbpath", "/var/lib/mongodb"}}))
+	assert.Empty(t, orphanDBPath(portOwner{pid: 1, name: "nginx"}))
+}
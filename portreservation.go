@@ -0,0 +1,135 @@
+package memongo
+
+import (
+	"fmt"
+	"sync"
+)
+
+// PortReservation is a port leased from a port manager, such as a broker
+// that hands out ports to the machines sharing a network namespace. memongo
+// starts mongod on Port, and calls Release exactly once: after mongod has
+// bound the port, or once starting it has failed.
+type PortReservation interface {
+	Port() int
+	Release()
+}
+
+// PortReservationProvider leases ports for the mongod processes memongo
+// starts, one reservation each. Each reservation is released like a
+// PortReservation.
+type PortReservationProvider interface {
+	Reserve() (PortReservation, error)
+}
+
+// FreePorts is a PortReservationProvider that finds free ports the way
+// memongo does without one: it asks the OS for a free port, or probes
+// PortRange if it's set, and skips ports recently handed out to other servers
+// in this process. It doesn't coordinate with other processes.
+type FreePorts struct {
+	PortRange [2]int
+}
+
+// Reserve finds a free port
+func (f FreePorts) Reserve() (PortReservation, error) {
+	if f.PortRange != [2]int{} {
+		err := validatePortRange(f.PortRange)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	port, err := allocatePort(f.PortRange)
+	if err != nil {
+		return nil, err
+	}
+
+	return freePort(port), nil
+}
+
+// freePort is a port reserved by allocatePort
+type freePort int
+
+func (p freePort) Port() int {
+	return int(p)
+}
+
+// Release lets allocatePort hand the port out again, rather than waiting for
+// its reservation to expire
+func (p freePort) Release() {
+	reservedPortsMu.Lock()
+	defer reservedPortsMu.Unlock()
+
+	delete(reservedPorts, int(p))
+}
+
+// portLease is the reservation a server's port was leased with
+type portLease struct {
+	reservation PortReservation
+
+	// provided is set if the reservation came from
+	// Options.PortReservationProvider, rather than Options.PortReservation,
+	// so a retried start can lease another one
+	provided bool
+
+	once sync.Once
+}
+
+// release releases the reservation, if it hasn't been already. l may be nil.
+func (l *portLease) release() {
+	if l == nil {
+		return
+	}
+
+	l.once.Do(l.reservation.Release)
+}
+
+// leasePort sets Port to a port leased from PortReservationProvider
+func (opts *Options) leasePort() error {
+	reservation, err := opts.PortReservationProvider.Reserve()
+	if err != nil {
+		return fmt.Errorf("error leasing a port: %w", err)
+	}
+
+	opts.portLease = &portLease{reservation: reservation, provided: true}
+	opts.Port = reservation.Port()
+
+	return nil
+}
+
+// releasePort releases the reservation Port was leased with, if any
+func (opts *Options) releasePort() {
+	opts.portLease.release()
+}
+
+// memberPort returns a port for a replica set member added to the server,
+// and a function to call once mongod has bound it or failed to start
+func (s *Server) memberPort() (int, func(), error) {
+	if s.opts.PortReservationProvider == nil {
+		port, err := allocatePort(s.opts.PortRange)
+		return port, func() {}, err
+	}
+
+	reservation, err := s.opts.PortReservationProvider.Reserve()
+	if err != nil {
+		return 0, nil, fmt.Errorf("error leasing a port: %w", err)
+	}
+	lease := &portLease{reservation: reservation, provided: true}
+
+	return reservation.Port(), lease.release, nil
+}
+
+func (opts *Options) validatePortReservation() error {
+	if len(opts.ReplicaMemberPorts) > 0 {
+		return fmt.Errorf("cannot use PortReservation with ReplicaMemberPorts")
+	}
+
+	port := opts.PortReservation.Port()
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("invalid PortReservation port %d: must be within 1-65535", port)
+	}
+	if opts.Port != 0 && opts.Port != port {
+		return fmt.Errorf("port %d conflicts with PortReservation's port %d", opts.Port, port)
+	}
+
+	return nil
+}
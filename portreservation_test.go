@@ -0,0 +1,200 @@
+package memongo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/100mslive/memongo/v2/mongobin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePortBroker leases made-up ports, recording each lease and release in
+// order
+type fakePortBroker struct {
+	mu     sync.Mutex
+	next   int
+	events []string
+}
+
+func (b *fakePortBroker) Reserve() (PortReservation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.next++
+	port := 20000 + b.next
+	b.events = append(b.events, fmt.Sprintf("reserve %d", port))
+	return &fakeReservation{broker: b, port: port}, nil
+}
+
+func (b *fakePortBroker) Events() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]string(nil), b.events...)
+}
+
+type fakeReservation struct {
+	broker *fakePortBroker
+	port   int
+}
+
+func (r *fakeReservation) Port() int {
+	return r.port
+}
+
+func (r *fakeReservation) Release() {
+	r.broker.mu.Lock()
+	defer r.broker.mu.Unlock()
+
+	r.broker.events = append(r.broker.events, fmt.Sprintf("release %d", r.port))
+}
+
+// transientDownloader fails every download with a transient error
+type transientDownloader struct{}
+
+func (transientDownloader) Download(string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("%w: connection reset", mongobin.ErrTransientDownload)
+}
+
+func TestFreePorts(t *testing.T) {
+	reservation, err := FreePorts{}.Reserve()
+	require.NoError(t, err)
+	port := reservation.Port()
+	require.NotZero(t, port)
+
+	// The port isn't handed out again until it's released
+	assert.False(t, reservePort(port))
+	reservation.Release()
+	assert.True(t, reservePort(port))
+
+	free, err := getFreePort()
+	require.NoError(t, err)
+	reservation, err = FreePorts{PortRange: [2]int{free, free}}.Reserve()
+	require.NoError(t, err)
+	assert.Equal(t, free, reservation.Port())
+	reservation.Release()
+
+	_, err = FreePorts{PortRange: [2]int{20100, 20000}}.Reserve()
+	assert.Error(t, err)
+}
+
+func TestValidatePortReservation(t *testing.T) {
+	broker := &fakePortBroker{}
+	reservation, err := broker.Reserve()
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		opts     *Options
+		expected string
+	}{
+		"alone": {
+			opts: &Options{MongodBin: "/bin/true", PortReservation: reservation},
+		},
+		"with the same Port": {
+			opts: &Options{MongodBin: "/bin/true", PortReservation: reservation, Port: 20001},
+		},
+		"with another Port": {
+			opts:     &Options{MongodBin: "/bin/true", PortReservation: reservation, Port: 27017},
+			expected: "port 27017 conflicts with PortReservation's port 20001",
+		},
+		"with ReplicaMemberPorts": {
+			opts:     &Options{MongodBin: "/bin/true", PortReservation: reservation, ShouldUseReplica: true, ReplicaMemberPorts: []int{20001}},
+			expected: "cannot use PortReservation with ReplicaMemberPorts",
+		},
+		"with an invalid port": {
+			opts:     &Options{MongodBin: "/bin/true", PortReservation: &fakeReservation{broker: broker, port: 70000}},
+			expected: "invalid PortReservation port 70000: must be within 1-65535",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.opts.Validate()
+			if test.expected == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.expected)
+			}
+		})
+	}
+}
+
+func TestEffectiveOptionsPortReservation(t *testing.T) {
+	broker := &fakePortBroker{}
+	reservation, err := broker.Reserve()
+	require.NoError(t, err)
+
+	effective, err := (&Options{MongodBin: "/bin/true", PortReservation: reservation}).EffectiveOptions()
+	require.NoError(t, err)
+	assert.Equal(t, 20001, effective.Port)
+	assert.False(t, effective.portAllocated)
+
+	// Nothing is leased until the server starts
+	effective, err = (&Options{MongodBin: "/bin/true", PortReservationProvider: broker}).EffectiveOptions()
+	require.NoError(t, err)
+	assert.Zero(t, effective.Port)
+	assert.Equal(t, []string{"reserve 20001"}, broker.Events())
+}
+
+func TestPortReservationReleasedOnFailure(t *testing.T) {
+	broker := &fakePortBroker{}
+	reservation, err := broker.Reserve()
+	require.NoError(t, err)
+
+	_, err = StartWithOptions(&Options{
+		DownloadOptions: DownloadOptions{
+			DownloadURL: "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-ubuntu2204-8.0.0.tgz",
+			CachePath:   t.TempDir(),
+			Downloader:  transientDownloader{},
+		},
+		PortReservation: reservation,
+		StartRetries:    2,
+		LogLevel:        memongolog.LogLevelSilent,
+	})
+	require.True(t, errors.Is(err, mongobin.ErrTransientDownload), err)
+
+	// The reservation is kept across retries, and released once
+	assert.Equal(t, []string{"reserve 20001", "release 20001"}, broker.Events())
+}
+
+func TestPortReservationProviderRetries(t *testing.T) {
+	broker := &fakePortBroker{}
+
+	_, err := StartWithOptions(&Options{
+		DownloadOptions: DownloadOptions{
+			DownloadURL: "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-ubuntu2204-8.0.0.tgz",
+			CachePath:   t.TempDir(),
+			Downloader:  transientDownloader{},
+		},
+		PortReservationProvider: broker,
+		StartRetries:            2,
+		LogLevel:                memongolog.LogLevelSilent,
+	})
+	require.True(t, errors.Is(err, mongobin.ErrTransientDownload), err)
+
+	// Each attempt leases a port, and releases it before the next lease
+	assert.Equal(t, []string{
+		"reserve 20001", "release 20001",
+		"reserve 20002", "release 20002",
+		"reserve 20003", "release 20003",
+	}, broker.Events())
+}
+
+func TestPortLeaseReleasedOnce(t *testing.T) {
+	broker := &fakePortBroker{}
+	reservation, err := broker.Reserve()
+	require.NoError(t, err)
+
+	lease := &portLease{reservation: reservation}
+	lease.release()
+	lease.release()
+	assert.Equal(t, []string{"reserve 20001", "release 20001"}, broker.Events())
+
+	var none *portLease
+	none.release()
+}
@@ -0,0 +1,399 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/100mslive/memongo/v2/monitor"
+)
+
+// mongodProcess is a running mongod, along with the watcher that kills it if
+// this process dies
+type mongodProcess struct {
+	// member is the index of the replica set member the process runs, or 0
+	member     int
+	name       string
+	cmd        *exec.Cmd
+	watcherCmd *exec.Cmd
+	dbDir      string
+	port       int
+	host       string
+	exited     chan struct{}
+	stopping   *int32
+
+	// keepDBDir makes stop leave the data directory behind
+	keepDBDir bool
+
+	// advertisedHost is the host the replica set configuration names the
+	// process by, if it isn't the one clients connect to
+	advertisedHost string
+
+	// hidden is set if the process is a hidden replica set member
+	hidden bool
+
+	// paused is 1 while PauseMember has the process suspended
+	paused int32
+
+	// version is the MongoDB version the process runs, if it's known
+	version string
+
+	// portWait is how long connecting to port took once mongod reported it
+	portWait portWait
+
+	// mismatch receives the stored and configured replica set names if
+	// mongod reports they differ
+	mismatch <-chan [2]string
+
+	// output keeps mongod's last lines of output
+	output *outputTail
+}
+
+// mongodArgs returns the command line arguments for a mongod serving dbDir
+// on port, and the storage engine they select. mongod also listens on
+// advertisedHost, if it's given.
+func mongodArgs(opts *Options, caps versionCapabilities, dbDir string, port int, keyFile string, advertisedHost string) ([]string, string) {
+	// Replica sets need wiredTiger, and ephemeralForTest isn't available in
+	// newer versions
+	engine := "ephemeralForTest"
+	args := []string{"--dbpath", dbDir, "--port", strconv.Itoa(port)}
+	if opts.ShouldUseReplica {
+		engine = "wiredTiger"
+		args = append(args, "--replSet", opts.ReplicaSetName)
+		if opts.clusterRole != "" {
+			args = append(args, "--"+opts.clusterRole)
+		}
+	} else if !caps.ephemeralForTest {
+		engine = "wiredTiger"
+	}
+	if opts.disklessStrategy == DisklessInMemory {
+		engine = "inMemory"
+	}
+	if engine != "ephemeralForTest" || opts.EnableIPv6 {
+		bindIP := "localhost"
+		if advertisedHost != "" && advertisedHost != "localhost" {
+			bindIP += "," + advertisedHost
+		}
+		args = append(args, "--bind_ip", bindIP)
+	}
+	if opts.EnableIPv6 {
+		args = append(args, "--ipv6")
+	}
+	if engine == "wiredTiger" {
+		// Journaling can't be used with replica sets, and slows down
+		// standalone servers for no benefit
+		if !opts.ShouldUseReplica && caps.noJournal {
+			args = append(args, "--nojournal")
+		}
+		args = append(args, wiredTigerCacheArgs(opts, caps)...)
+	}
+
+	if opts.Auth {
+		args = append(args, "--auth")
+		if keyFile != "" {
+			args = append(args, "--keyFile", keyFile)
+		}
+	}
+
+	args = append(args, []string{"--storageEngine", engine}...)
+
+	if opts.EnableTestCommands || opts.EnforceMaxTimeMS {
+		args = append(args, "--setParameter", "enableTestCommands=1")
+	}
+	if opts.EnforceMaxTimeMS {
+		args = append(args, "--setParameter", `failpoint.maxTimeAlwaysTimeOut={"mode":"alwaysOn"}`)
+	}
+	if opts.CursorTimeout > 0 {
+		args = append(args,
+			"--setParameter", fmt.Sprintf("cursorTimeoutMillis=%d", opts.CursorTimeout.Milliseconds()),
+			"--setParameter", "clientCursorMonitorFrequencySecs=1")
+	}
+	if opts.TTLMonitorInterval > 0 {
+		args = append(args, "--setParameter", fmt.Sprintf("ttlMonitorSleepSecs=%d", int64(opts.TTLMonitorInterval/time.Second)))
+	}
+	if opts.ShouldUseReplica && caps.quiesce {
+		args = append(args, "--setParameter", fmt.Sprintf("shutdownTimeoutMillisForSignaledShutdown=%d", quiescePeriod.Milliseconds()))
+	}
+
+	return args, engine
+}
+
+// keyFileFor returns the keyfile replica set members use to authenticate to
+// each other when auth is enabled: Options.ReplicaSetKeyFile, or one written
+// by writeKeyFile
+func keyFileFor(opts *Options) (string, error) {
+	if opts.ReplicaSetKeyFile != "" {
+		return opts.ReplicaSetKeyFile, nil
+	}
+
+	return writeKeyFile(opts)
+}
+
+// writeKeyFile writes a keyfile for the replica set members
+func writeKeyFile(opts *Options) (string, error) {
+	tmpFile, err := os.CreateTemp(opts.TempDirBase, "keyfile")
+	// This library is specifically intended for ephemeral mongo
+	// databases so we don't need a lot of security here, however
+	// if you're reading this file trying to figure out how to generate
+	// a keyfile, please see the official MongoDB documentation on how
+	// to do this correctly and securely for a production environment.
+	if err != nil {
+		return "", err
+	}
+	trackPath(tmpFile.Name(), "keyfile")
+	_, _ = tmpFile.Write([]byte("insecurekeyfile"))
+	_ = tmpFile.Chmod(0400) // MongoDB requires keyfile to be readable only by owner
+	_ = tmpFile.Close()
+
+	return tmpFile.Name(), nil
+}
+
+// readKeyFile returns the key in the keyfile at path, checking it the way
+// mongod does: it must only be readable by its owner, and the key, without
+// whitespace, must be 6 to 1024 base64 characters
+func readKeyFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("permissions on %s are too open: mongod requires it to be readable only by its owner", path)
+	}
+
+	//  Safe to pass the keyfile path
+	//nolint:gosec
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	key := strings.Join(strings.Fields(string(contents)), "")
+	if len(key) < 6 || len(key) > 1024 {
+		return "", fmt.Errorf("the key in %s must be 6 to 1024 characters long, not %d", path, len(key))
+	}
+	if !reKeyFileKey.MatchString(key) {
+		return "", fmt.Errorf("the key in %s may only contain base64 characters", path)
+	}
+
+	return key, nil
+}
+
+var reKeyFileKey = regexp.MustCompile(`^[A-Za-z0-9+/=]+$`)
+
+// launchMongod runs program, which is mongod or the dynamic linker running
+// it, with args and env, and waits for it to report that it's listening. On
+// failure, or if ctx is done first, the process is killed and dbDir is
+// removed, unless keepDBDir is set for a data directory that's being reused.
+// A member's name, if it has one, tags the messages logged about it
+// and each line of its output.
+func launchMongod(ctx context.Context, program string, args []string, env []string, dbDir string, keepDBDir bool, member int, name string, reReady *regexp.Regexp, wait startupWait, logger *memongolog.Logger, events *eventBus) (*mongodProcess, error) {
+	//  Safe to pass program and dbDir
+	//nolint:gosec
+	cmd := exec.Command(program, args...)
+	cmd.Env = env
+	if name != "" {
+		logger = logger.With("member", name)
+	}
+
+	stdout, startupErrCh, startupReadyCh, startupMismatchCh, startupProgressCh := stdoutHandler(logger, reReady)
+	stderr := stderrHandler(logger)
+	output := &outputTail{name: name}
+	cmd.Stdout = io.MultiWriter(stdout, output)
+	cmd.Stderr = stderr
+
+	logger.Debugf("Starting mongod")
+	events.emit(EventStarting, member, nil)
+
+	// Run the server
+	err := cmd.Start()
+	if err != nil {
+		_ = stdout.Close()
+		_ = stderr.Close()
+		if !keepDBDir {
+			remErr := removePath(dbDir)
+			if remErr != nil {
+				logger.Warnf("error removing data directory: %s", remErr)
+			}
+		}
+
+		return nil, startError(program, err)
+	}
+
+	exited := make(chan struct{})
+	stopping := new(int32)
+	goTracked(fmt.Sprintf("mongod process %d", cmd.Process.Pid), func() {
+		err := cmd.Wait()
+		// Wait has copied all of mongod's output, so the handlers can stop
+		_ = stdout.Close()
+		_ = stderr.Close()
+		if atomic.LoadInt32(stopping) == 0 {
+			events.emit(EventUnexpectedExit, member, err)
+		}
+		close(exited)
+	})
+
+	proc := &mongodProcess{
+		member:    member,
+		name:      name,
+		cmd:       cmd,
+		dbDir:     dbDir,
+		keepDBDir: keepDBDir,
+		exited:    exited,
+		stopping:  stopping,
+		mismatch:  startupMismatchCh,
+		output:    output,
+	}
+
+	logger.Debugf("Started mongod; starting watcher")
+
+	// Start a watcher: the watcher is a subprocess that ensure if this process
+	// dies, the mongo server will be killed (and not reparented under init)
+	watcherCmd, err := monitor.RunMonitor(os.Getpid(), cmd.Process.Pid)
+	if err != nil {
+		proc.stop(logger, false)
+		return nil, err
+	}
+	proc.watcherCmd = watcherCmd
+
+	logger.Debugf("Started watcher; waiting for mongod to report port number")
+	startupTime := time.Now()
+
+	// Wait for the stdout handler to report the server's port number and
+	// addresses (or a startup error)
+	ready, err := waitForStartup(ctx, wait, startupReadyCh, startupErrCh, startupProgressCh)
+	if err != nil {
+		proc.stop(logger, false)
+		return nil, err
+	}
+	proc.port = ready.port
+	proc.host = listenHost(ready.addresses)
+
+	logger.Debugf("mongod started up and reported a port number after %s", time.Since(startupTime).String())
+
+	proc.portWait, err = waitForPort(ctx, wait, net.JoinHostPort(proc.host, strconv.Itoa(proc.port)))
+	if err != nil {
+		proc.stop(logger, false)
+		return nil, err
+	}
+	logger.Debugf("mongod accepted a connection after %d attempts in %s", proc.portWait.attempts, proc.portWait.waited)
+	events.emit(EventListening, member, nil)
+
+	// From now on the data directory is removed as Options.Cleanup says
+	proc.keepDBDir = false
+
+	return proc, nil
+}
+
+// hasExited returns whether mongod has exited
+func (p *mongodProcess) hasExited() bool {
+	select {
+	case <-p.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// quiescePeriod replaces the 15 second quiesce period replica set members
+// and mongos wait for before shutting down on SIGTERM, which would make
+// stopping them gracefully slow
+const quiescePeriod = 100 * time.Millisecond
+
+// gracefulStopTimeout is how long a graceful stop waits for mongod to shut
+// down cleanly before killing it, unless its context says otherwise
+const gracefulStopTimeout = 10 * time.Second
+
+// stop stops mongod and its watcher, and removes the data directory unless
+// keepDBDir is set. A graceful stop asks mongod to shut down cleanly, and
+// only kills it if it hasn't exited after gracefulStopTimeout. It returns an
+// error if mongod didn't exit or its data directory couldn't be removed.
+func (p *mongodProcess) stop(logger *memongolog.Logger, graceful bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), gracefulStopTimeout)
+	defer cancel()
+
+	return p.stopContext(ctx, logger, graceful)
+}
+
+// stopContext is stop, with a graceful stop waiting for mongod to shut down
+// until ctx is done rather than for gracefulStopTimeout
+func (p *mongodProcess) stopContext(ctx context.Context, logger *memongolog.Logger, graceful bool) error {
+	atomic.StoreInt32(p.stopping, 1)
+
+	// stopErr is the first reason the process may not have been cleaned up
+	var stopErr error
+
+	// A paused mongod can't act on SIGTERM until it's resumed
+	if atomic.CompareAndSwapInt32(&p.paused, 1, 0) {
+		_ = p.cmd.Process.Signal(syscall.SIGCONT)
+	}
+
+	// killed is true once the process has exited
+	killed := false
+	if graceful && !p.hasExited() {
+		err := p.cmd.Process.Signal(syscall.SIGTERM)
+		if err != nil {
+			logger.Warnf("error signalling mongod process: %s", err)
+		} else {
+			select {
+			case <-p.exited:
+				killed = true
+			case <-ctx.Done():
+				logger.Warnf("timed out waiting for mongod process to shut down; killing it")
+			}
+		}
+	}
+
+	select {
+	case <-p.exited:
+		killed = true
+	default:
+	}
+
+	if !killed {
+		err := p.cmd.Process.Kill()
+		if err != nil {
+			logger.Warnf("error stopping mongod process: %s", err)
+		}
+
+		select {
+		case <-p.exited:
+		case <-time.After(5 * time.Second):
+			logger.Warnf("timed out waiting for mongod process to exit")
+			stopErr = fmt.Errorf("mongod process %d didn't exit after being killed", p.cmd.Process.Pid)
+		}
+	}
+
+	if p.watcherCmd != nil {
+		err := p.watcherCmd.Process.Kill()
+		if err != nil {
+			logger.Warnf("error stopping watcher process: %s", err)
+		}
+	}
+
+	if p.keepDBDir {
+		logger.Infof("Keeping data directory %s", p.dbDir)
+		untrackPath(p.dbDir)
+		return stopErr
+	}
+
+	err := removePath(p.dbDir)
+	if err != nil {
+		logger.Warnf("error removing data directory: %s", err)
+		if stopErr == nil {
+			stopErr = fmt.Errorf("error removing data directory: %w", err)
+		}
+	}
+
+	return stopErr
+}
@@ -0,0 +1,127 @@
+package memongo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/100mslive/memongo/v2/mongobin"
+)
+
+// BinarySource is where the mongod binary a server ran came from
+type BinarySource string
+
+const (
+	// BinarySourceCache is a binary found in the download cache
+	BinarySourceCache BinarySource = "cache"
+
+	// BinarySourceDownload is a binary downloaded by this start
+	BinarySourceDownload BinarySource = "download"
+
+	// BinarySourceMongodBin is a binary given as Options.MongodBin
+	BinarySourceMongodBin BinarySource = "MongodBin"
+
+	// BinarySourceEnv is a binary given by MEMONGO_MONGOD_BIN
+	BinarySourceEnv BinarySource = "MEMONGO_MONGOD_BIN"
+)
+
+// BinaryProvenance records exactly which mongod binary a server ran, e.g.
+// for a compliance record of each test run
+type BinaryProvenance struct {
+	Source BinarySource
+
+	// URL is the download URL of a binary from the cache or a download,
+	// with any password redacted unless Options.DisableRedaction is set
+	URL string
+
+	// Path is the binary that ran
+	Path string
+
+	// SHA256 is the binary's hex-encoded sha256 checksum
+	SHA256 string
+
+	// Version is the version mongod reported, e.g. "8.0.0"
+	Version string
+}
+
+// binaryProvenance returns the provenance of the mongod at binPath, which
+// reported version
+func (opts *Options) binaryProvenance(binPath string, cacheHit bool, version string) (BinaryProvenance, error) {
+	provenance := BinaryProvenance{Path: binPath, Version: version}
+
+	var err error
+	if opts.MongodBin != "" {
+		provenance.Source = BinarySourceMongodBin
+		if opts.mongodBinFromEnv {
+			provenance.Source = BinarySourceEnv
+		}
+		provenance.SHA256, err = hashBinary(binPath)
+	} else {
+		provenance.Source = BinarySourceDownload
+		if cacheHit {
+			provenance.Source = BinarySourceCache
+		}
+		provenance.URL = opts.downloadOptions().DownloadURL
+		if !opts.DisableRedaction {
+			provenance.URL = RedactURI(provenance.URL)
+		}
+		provenance.SHA256, err = mongobin.CachedSHA256(binPath)
+	}
+	if err != nil {
+		return BinaryProvenance{}, fmt.Errorf("error hashing mongod: %w", err)
+	}
+
+	return provenance, nil
+}
+
+// binaryHashKey identifies a version of a local file. A changed file almost
+// always has a new modification time or size.
+type binaryHashKey struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+var (
+	binaryHashesMu sync.Mutex
+	binaryHashes   = map[binaryHashKey]string{}
+)
+
+// hashBinary returns the hex-encoded sha256 checksum of the file at path,
+// hashing it only once per path, modification time and size
+func hashBinary(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	key := binaryHashKey{path: abs, modTime: info.ModTime(), size: info.Size()}
+
+	binaryHashesMu.Lock()
+	hash, ok := binaryHashes[key]
+	binaryHashesMu.Unlock()
+	if ok {
+		return hash, nil
+	}
+
+	hash, err = hashFile(abs)
+	if err != nil {
+		return "", err
+	}
+
+	binaryHashesMu.Lock()
+	binaryHashes[key] = hash
+	binaryHashesMu.Unlock()
+
+	return hash, nil
+}
+
+// BinaryProvenance returns which mongod binary the server was started with
+func (s *Server) BinaryProvenance() BinaryProvenance {
+	return s.StartReport().Binary
+}
@@ -0,0 +1,120 @@
+package memongo
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// diskQuotaCheckInterval is how often the data directory's size is checked
+// against Options.MaxDBPathBytes
+var diskQuotaCheckInterval = time.Second
+
+// DiskUsage returns the total size in bytes of the files in the data
+// directories of the server and its replica set members (see DBPaths)
+func (s *Server) DiskUsage() (int64, error) {
+	return dirsSize(s.dbPathList())
+}
+
+// dirsSize returns the total size of the files in dirs
+func dirsSize(dirs []string) (int64, error) {
+	var size int64
+	for _, dir := range dirs {
+		n, err := dirSize(dir)
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+
+	return size, nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// mongod creates and removes files while it runs
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error measuring data directory: %w", err)
+	}
+
+	return size, nil
+}
+
+// checkDiskQuota returns an error wrapping ErrDiskQuotaExceeded if the data
+// directories are larger than maxBytes in total
+func checkDiskQuota(dirs []string, maxBytes int64) error {
+	usage, err := dirsSize(dirs)
+	if err != nil {
+		return err
+	}
+	if usage > maxBytes {
+		what := "data directory uses"
+		if len(dirs) > 1 {
+			what = fmt.Sprintf("%d data directories use", len(dirs))
+		}
+		return fmt.Errorf("%w: %s %d bytes, more than the limit of %d", ErrDiskQuotaExceeded, what, usage, maxBytes)
+	}
+
+	return nil
+}
+
+// watchDiskQuota checks the data directories' size until done is closed. The
+// first time it's over Options.MaxDBPathBytes, OnQuotaExceeded is called, and
+// the server is stopped if StopOnQuotaExceeded is set, with the quota error as
+// the EventStopping error.
+func (s *Server) watchDiskQuota(done <-chan struct{}) {
+	ticker := time.NewTicker(diskQuotaCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		err := checkDiskQuota(s.dbPathList(), s.opts.MaxDBPathBytes)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrDiskQuotaExceeded) {
+			s.logger.Warnf("error checking disk quota: %s", err)
+			continue
+		}
+
+		s.logger.Warnf("%s", err)
+		if s.opts.OnQuotaExceeded != nil {
+			s.opts.OnQuotaExceeded(err)
+		}
+		if s.opts.StopOnQuotaExceeded {
+			s.stop(err)
+		}
+
+		return
+	}
+}
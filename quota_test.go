@@ -0,0 +1,42 @@
+package memongo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSize(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), make([]byte, 100), 0600))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "journal"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "journal", "b"), make([]byte, 50), 0600))
+
+	size, err := dirSize(dir)
+	require.NoError(t, err)
+	assert.Equal(t, int64(150), size)
+
+	assert.NoError(t, checkDiskQuota([]string{dir}, 150))
+	err = checkDiskQuota([]string{dir}, 149)
+	assert.ErrorIs(t, err, ErrDiskQuotaExceeded)
+	assert.Contains(t, err.Error(), "data directory uses 150 bytes")
+
+	// Members' data directories count towards the quota
+	other := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(other, "c"), make([]byte, 10), 0600))
+	err = checkDiskQuota([]string{dir, other}, 159)
+	assert.ErrorIs(t, err, ErrDiskQuotaExceeded)
+	assert.Contains(t, err.Error(), "2 data directories use 160 bytes")
+
+	_, err = dirSize(filepath.Join(dir, "missing"))
+	assert.NoError(t, err)
+}
+
+func TestValidateDiskQuota(t *testing.T) {
+	assert.Error(t, (&Options{MongodBin: "/bin/mongod", MaxDBPathBytes: -1}).Validate())
+	assert.Error(t, (&Options{MongodBin: "/bin/mongod", StopOnQuotaExceeded: true}).Validate())
+	assert.NoError(t, (&Options{MongodBin: "/bin/mongod", MaxDBPathBytes: 1 << 20, StopOnQuotaExceeded: true}).Validate())
+}
@@ -0,0 +1,54 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// readOnlyStepDown is how long a read-only server's member is kept from
+// becoming primary again. It's longer than any test should run.
+const readOnlyStepDown = 24 * time.Hour
+
+// IsReadOnly returns true if the server was started with Options.ReadOnly
+func (s *Server) IsReadOnly() bool {
+	return s.opts.ReadOnly
+}
+
+// makeReadOnly steps the replica set's only member down and keeps it from
+// being elected again, so it stays a secondary: reads work with a direct
+// connection, and writes fail with NotWritablePrimary. Unlike read-only
+// users or queryableBackupMode, this works on every supported version
+// without auth and on an empty data directory.
+func (s *Server) makeReadOnly(ctx context.Context) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Disconnect(ctx)
+	}()
+
+	// force steps down without waiting for a secondary to catch up, since
+	// there isn't one
+	err = client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "replSetStepDown", Value: int64(readOnlyStepDown / time.Second)},
+		{Key: "force", Value: true},
+	}).Err()
+	// Older versions close every connection when stepping down
+	if err != nil && !mongo.IsNetworkError(err) {
+		return fmt.Errorf("error stepping down to make the server read-only: %w", err)
+	}
+
+	state, err := waitForMemberState(ctx, client, s.replicaHost(), "SECONDARY")
+	if err != nil {
+		return fmt.Errorf("error waiting for the server to become read-only: %w", err)
+	}
+	s.events.emitState(0, state)
+
+	s.logger.Debugf("Stepped down for %s; the server is read-only", readOnlyStepDown)
+	return nil
+}
@@ -0,0 +1,207 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/100mslive/memongo/v2/replay"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/event"
+	"go.mongodb.org/mongo-driver/v2/x/mongo/driver"
+)
+
+// commandRecorder writes the commands seen by a CommandMonitor and their
+// replies to a recording
+type commandRecorder struct {
+	file   *os.File
+	writer *replay.Writer
+
+	// redact masks secrets in the recorded commands
+	redact bool
+
+	mu sync.Mutex
+	// pending maps request IDs of running commands to what was sent
+	pending map[int64]replay.Entry
+	// err is the first error writing the recording
+	err error
+}
+
+func newCommandRecorder(file *os.File, redact bool) *commandRecorder {
+	return &commandRecorder{
+		file:    file,
+		writer:  replay.NewWriter(file),
+		pending: map[int64]replay.Entry{},
+		redact:  redact,
+	}
+}
+
+func (r *commandRecorder) started(e *event.CommandStartedEvent) {
+	command := e.Command
+	if r.redact {
+		command = RedactCommand(command)
+	}
+	cmd, err := replay.NormalizeCommand(command)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		r.fail(fmt.Errorf("error normalizing %s command: %w", e.CommandName, err))
+		return
+	}
+	r.pending[e.RequestID] = replay.Entry{Database: e.DatabaseName, Command: cmd}
+}
+
+func (r *commandRecorder) succeeded(e *event.CommandSucceededEvent) {
+	r.finished(e.RequestID, e.Reply)
+}
+
+func (r *commandRecorder) failed(e *event.CommandFailedEvent) {
+	r.finished(e.RequestID, failureReply(e.Failure))
+}
+
+func (r *commandRecorder) finished(requestID int64, reply bson.Raw) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.pending[requestID]
+	if !ok {
+		return
+	}
+	delete(r.pending, requestID)
+
+	normalized, err := replay.NormalizeReply(reply)
+	if err != nil {
+		r.fail(fmt.Errorf("error normalizing reply: %w", err))
+		return
+	}
+	entry.Reply = normalized
+
+	err = r.writer.Write(entry)
+	if err != nil {
+		r.fail(err)
+	}
+}
+
+// fail records the first error. r.mu must be held.
+func (r *commandRecorder) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// close closes the recording, returning the first error writing it
+func (r *commandRecorder) close() error {
+	err := r.file.Close()
+	untrackFile(r.file)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	return err
+}
+
+// failureReply returns the server's reply for a failed command, or a reply
+// describing the error if it didn't come from the server
+func failureReply(failure error) bson.Raw {
+	var driverErr driver.Error
+	if errors.As(failure, &driverErr) && len(driverErr.Raw) > 0 {
+		return bson.Raw(driverErr.Raw)
+	}
+
+	reply, err := bson.Marshal(bson.D{{Key: "ok", Value: 0.0}, {Key: "errmsg", Value: failure.Error()}})
+	if err != nil {
+		return nil
+	}
+
+	return reply
+}
+
+// clientMonitor returns the command monitor of the client returned by
+// Client, which feeds command capture and recording
+func (s *Server) clientMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			if s.capture != nil {
+				s.capture.started(e)
+			}
+			if r := s.activeRecorder(); r != nil {
+				r.started(e)
+			}
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			if s.capture != nil {
+				s.capture.finished(e.RequestID, e.Duration, "")
+			}
+			if r := s.activeRecorder(); r != nil {
+				r.succeeded(e)
+			}
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			if s.capture != nil {
+				s.capture.finished(e.RequestID, e.Duration, e.Failure.Error())
+			}
+			if r := s.activeRecorder(); r != nil {
+				r.failed(e)
+			}
+		},
+	}
+}
+
+func (s *Server) activeRecorder() *commandRecorder {
+	s.recordMu.Lock()
+	defer s.recordMu.Unlock()
+
+	return s.recorder
+}
+
+// RecordTo records the commands sent by the client returned by Client, and
+// the server's replies, to a file at path, for replaying without a server
+// with the replay package. Fields that differ between runs, such as session
+// IDs and cluster times, are left out, so the same test run twice records
+// the same file. Passwords are redacted, unless Options.DisableRedaction is
+// set, so users created while recording get a different password when the
+// recording is replayed. Recording stops with StopRecording or Stop.
+func (s *Server) RecordTo(path string) error {
+	s.recordMu.Lock()
+	defer s.recordMu.Unlock()
+
+	err := s.checkRunning()
+	if err != nil {
+		return err
+	}
+	if s.recorder != nil {
+		return fmt.Errorf("already recording to %s", s.recorder.file.Name())
+	}
+
+	//nolint:gosec
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating recording: %w", err)
+	}
+
+	trackFile(file, "command recording")
+	s.recorder = newCommandRecorder(file, !s.opts.DisableRedaction)
+	return nil
+}
+
+// StopRecording stops recording commands and closes the recording, returning
+// any error writing it. It does nothing if the server isn't recording.
+func (s *Server) StopRecording() error {
+	s.recordMu.Lock()
+	r := s.recorder
+	s.recorder = nil
+	s.recordMu.Unlock()
+
+	if r == nil {
+		return nil
+	}
+
+	return r.close()
+}
@@ -0,0 +1,115 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/replay"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/event"
+	"go.mongodb.org/mongo-driver/v2/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/v2/x/mongo/driver"
+)
+
+func TestRecordTo(t *testing.T) {
+	s := &Server{}
+	monitor := s.clientMonitor()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+
+	session := bson.E{Key: "lsid", Value: bson.D{{Key: "id", Value: "session"}}}
+	clusterTime := bson.E{Key: "$clusterTime", Value: bson.D{{Key: "clusterTime", Value: bson.Timestamp{T: 1}}}}
+	insert := bson.D{{Key: "insert", Value: "users"}, {Key: "documents", Value: bson.A{bson.D{{Key: "_id", Value: 1}}}}}
+	find := bson.D{{Key: "find", Value: "users"}, {Key: "filter", Value: bson.D{}}}
+	insertReply := mustMarshal(t, bson.D{{Key: "n", Value: int32(1)}, {Key: "ok", Value: 1.0}})
+	findReply := mustMarshal(t, bson.D{
+		{Key: "cursor", Value: bson.D{{Key: "firstBatch", Value: bson.A{bson.D{{Key: "_id", Value: int32(1)}}}}, {Key: "id", Value: int64(0)}, {Key: "ns", Value: "app.users"}}},
+		{Key: "ok", Value: 1.0},
+	})
+	failureReply := mustMarshal(t, bson.D{{Key: "ok", Value: 0.0}, {Key: "errmsg", Value: "ns does not exist"}, {Key: "code", Value: int32(26)}})
+
+	// Commands sent before recording starts aren't recorded
+	monitor.Started(ctx, &event.CommandStartedEvent{Command: mustMarshal(t, bson.D{{Key: "ping", Value: 1}}), DatabaseName: "admin", CommandName: "ping", RequestID: 1})
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: event.CommandFinishedEvent{RequestID: 1}, Reply: mustMarshal(t, bson.D{{Key: "ok", Value: 1.0}})})
+
+	require.NoError(t, s.RecordTo(path))
+	require.Error(t, s.RecordTo(path), "recording twice at once should fail")
+
+	monitor.Started(ctx, &event.CommandStartedEvent{Command: mustMarshal(t, append(append(bson.D{}, insert...), session, clusterTime)), DatabaseName: "app", CommandName: "insert", RequestID: 2})
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: event.CommandFinishedEvent{RequestID: 2}, Reply: mustMarshal(t, bson.D{{Key: "n", Value: int32(1)}, {Key: "ok", Value: 1.0}, clusterTime})})
+	monitor.Started(ctx, &event.CommandStartedEvent{Command: mustMarshal(t, append(append(bson.D{}, find...), session)), DatabaseName: "app", CommandName: "find", RequestID: 3})
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: event.CommandFinishedEvent{RequestID: 3}, Reply: findReply})
+	monitor.Started(ctx, &event.CommandStartedEvent{Command: mustMarshal(t, bson.D{{Key: "drop", Value: "missing"}}), DatabaseName: "app", CommandName: "drop", RequestID: 4})
+	monitor.Failed(ctx, &event.CommandFailedEvent{CommandFinishedEvent: event.CommandFinishedEvent{RequestID: 4}, Failure: driver.Error{Code: 26, Message: "ns does not exist", Raw: bsoncore.Document(failureReply)}})
+
+	require.NoError(t, s.StopRecording())
+	require.NoError(t, s.StopRecording(), "stopping twice should do nothing")
+
+	player, err := replay.Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, player.Remaining())
+
+	db := player.Database("app")
+	reply, err := db.RunCommand(ctx, insert).Raw()
+	require.NoError(t, err)
+	assert.Equal(t, insertReply, reply, "cluster times should be left out of replies")
+
+	reply, err = db.RunCommand(ctx, find).Raw()
+	require.NoError(t, err)
+	assert.Equal(t, findReply, reply)
+
+	reply, err = db.RunCommand(ctx, bson.D{{Key: "drop", Value: "missing"}}).Raw()
+	var cmdErr replay.CommandError
+	require.True(t, errors.As(err, &cmdErr), "expected a CommandError, got %v", err)
+	assert.Equal(t, int32(26), cmdErr.Code)
+	assert.Equal(t, failureReply, reply)
+
+	assert.ErrorIs(t, player.Database("admin").RunCommand(ctx, bson.D{{Key: "ping", Value: 1}}).Err(), replay.ErrUnknownCommand)
+}
+
+// Redacting recordings mustn't touch the fields commands are matched by,
+// such as the keys of distinct and createIndexes
+func TestRecordToReplaysKeys(t *testing.T) {
+	s := &Server{}
+	monitor := s.clientMonitor()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+
+	createIndexes := bson.D{
+		{Key: "createIndexes", Value: "users"},
+		{Key: "indexes", Value: bson.A{bson.D{{Key: "key", Value: bson.D{{Key: "email", Value: 1}}}, {Key: "name", Value: "email_1"}}}},
+	}
+	distinct := bson.D{{Key: "distinct", Value: "users"}, {Key: "key", Value: "email"}}
+	createIndexesReply := mustMarshal(t, bson.D{{Key: "numIndexesAfter", Value: int32(2)}, {Key: "ok", Value: 1.0}})
+	distinctReply := mustMarshal(t, bson.D{{Key: "values", Value: bson.A{"ada@example.com"}}, {Key: "ok", Value: 1.0}})
+
+	require.NoError(t, s.RecordTo(path))
+	monitor.Started(ctx, &event.CommandStartedEvent{Command: mustMarshal(t, createIndexes), DatabaseName: "app", CommandName: "createIndexes", RequestID: 1})
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: event.CommandFinishedEvent{RequestID: 1}, Reply: createIndexesReply})
+	monitor.Started(ctx, &event.CommandStartedEvent{Command: mustMarshal(t, distinct), DatabaseName: "app", CommandName: "distinct", RequestID: 2})
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: event.CommandFinishedEvent{RequestID: 2}, Reply: distinctReply})
+	require.NoError(t, s.StopRecording())
+
+	player, err := replay.Load(path)
+	require.NoError(t, err)
+	db := player.Database("app")
+
+	reply, err := db.RunCommand(ctx, createIndexes).Raw()
+	require.NoError(t, err)
+	assert.Equal(t, createIndexesReply, reply)
+
+	reply, err = db.RunCommand(ctx, distinct).Raw()
+	require.NoError(t, err)
+	assert.Equal(t, distinctReply, reply)
+	assert.Zero(t, player.Remaining())
+}
+
+func TestFailureReply(t *testing.T) {
+	reply := failureReply(errors.New("connection reset"))
+	assert.Equal(t, "connection reset", reply.Lookup("errmsg").StringValue())
+	assert.Equal(t, 0.0, reply.Lookup("ok").Double())
+}
@@ -0,0 +1,86 @@
+package memongo
+
+import "testing"
+
+// AddRef records another user of the server, such as a parallel subtest, so
+// that Release only stops it once every user is done. The server is stopped
+// by the last Release, or at once by Stop. It returns ErrServerStopped if the
+// server has already been stopped.
+func (s *Server) AddRef() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkRunning()
+	if err != nil {
+		return err
+	}
+	s.refs++
+
+	return nil
+}
+
+// Release gives up a reference to the server: the one its starter holds, or
+// one taken with AddRef. The last Release stops the server, returning any
+// error stopping it, as later calls do too.
+func (s *Server) Release() error {
+	s.mu.Lock()
+	last := s.refs == 0
+	if !last {
+		s.refs--
+	}
+	s.mu.Unlock()
+
+	if !last {
+		return nil
+	}
+
+	return stopServer(s)
+}
+
+// SubtestServer shares server with tb, which is usually a subtest that calls
+// t.Parallel: it takes a reference with AddRef and releases it when tb
+// finishes. Call it before t.Parallel, while the parent test still holds its
+// own reference, and have the parent call Release rather than Stop:
+//
+//	server, err := memongo.Start("8.0.0")
+//	...
+//	defer server.Release()
+//	for _, tc := range cases {
+//		t.Run(tc.name, func(t *testing.T) {
+//			server := memongo.SubtestServer(t, server)
+//			t.Parallel()
+//			...
+//		})
+//	}
+//
+// The parent's deferred Release runs as soon as it returns, before its
+// parallel subtests, and the server is stopped once the last of them
+// finishes. tb fails at once if the server is already stopped.
+func SubtestServer(tb testing.TB, server *Server) *Server {
+	tb.Helper()
+
+	err := server.AddRef()
+	if err != nil {
+		tb.Fatalf("error sharing the server: %s", err)
+	}
+	tb.Cleanup(func() {
+		err := server.Release()
+		if err != nil {
+			tb.Errorf("error stopping the server: %s", err)
+		}
+	})
+
+	return server
+}
+
+// checkRunning returns ErrServerStopped once the server has been stopped, so
+// methods fail fast rather than waiting for a server that's gone, or
+// starting processes that would never be stopped
+func (s *Server) checkRunning() error {
+	select {
+	case <-s.stopped:
+		return ErrServerStopped
+	default:
+		return nil
+	}
+}
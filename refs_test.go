@@ -0,0 +1,88 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeReleasableServer returns a server whose stop is faked, and the number
+// of times it was stopped
+func fakeReleasableServer(t *testing.T) (*Server, *int32) {
+	t.Helper()
+
+	var stops int32
+	origStop := stopServer
+	stopServer = func(s *Server) error {
+		if atomic.AddInt32(&stops, 1) == 1 {
+			close(s.stopped)
+		}
+		return nil
+	}
+	t.Cleanup(func() {
+		stopServer = origStop
+	})
+
+	return &Server{stopped: make(chan struct{})}, &stops
+}
+
+func TestSubtestServerParallelChildren(t *testing.T) {
+	server, stops := fakeReleasableServer(t)
+
+	t.Run("parent", func(t *testing.T) {
+		// The parent's deferred Release runs when it returns, before the
+		// parallel children, as a deferred Stop would; the children's
+		// references keep the server running until they finish
+		defer func() {
+			assert.NoError(t, server.Release())
+		}()
+
+		for i := 0; i < 3; i++ {
+			t.Run(fmt.Sprint(i), func(t *testing.T) {
+				s := SubtestServer(t, server)
+				t.Parallel()
+
+				assert.NoError(t, s.checkRunning())
+				assert.Equal(t, int32(0), atomic.LoadInt32(stops))
+			})
+		}
+	})
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(stops), "the last child's release stops the server")
+	assert.ErrorIs(t, server.checkRunning(), ErrServerStopped)
+}
+
+func TestReleaseWithoutRefs(t *testing.T) {
+	server, stops := fakeReleasableServer(t)
+
+	require.NoError(t, server.AddRef())
+	require.NoError(t, server.Release())
+	assert.Equal(t, int32(0), *stops)
+
+	require.NoError(t, server.Release())
+	assert.Equal(t, int32(1), *stops)
+
+	assert.ErrorIs(t, server.AddRef(), ErrServerStopped)
+}
+
+func TestMethodsAfterStop(t *testing.T) {
+	server := &Server{stopped: make(chan struct{}), isReplicaSet: true}
+	close(server.stopped)
+	ctx := context.Background()
+
+	_, err := server.Client(ctx)
+	assert.ErrorIs(t, err, ErrServerStopped)
+	assert.ErrorIs(t, server.Ping(ctx), ErrServerStopped)
+	_, err = server.BuildInfo(ctx)
+	assert.ErrorIs(t, err, ErrServerStopped)
+	assert.ErrorIs(t, server.Seed(ctx, nil), ErrServerStopped)
+	_, err = server.AddReplicaMember(ctx, MemberOptions{})
+	assert.ErrorIs(t, err, ErrServerStopped)
+	assert.ErrorIs(t, server.UpgradeMember(ctx, 0, "8.0.0"), ErrServerStopped)
+	assert.ErrorIs(t, server.RecordTo(t.TempDir()+"/recording"), ErrServerStopped)
+	assert.ErrorIs(t, server.WriteEnvFile(t.TempDir()+"/.env", ""), ErrServerStopped)
+}
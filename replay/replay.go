@@ -0,0 +1,307 @@
+// Package replay records the commands a client sends to MongoDB along with
+// the server's replies, and plays the replies back without a server, for CI
+// lanes where mongod can't run. Recordings are made with
+// memongo.Server.RecordTo during a run against a real server.
+//
+// Commands are matched by their normalized shape: the fields that change
+// from run to run (see IgnoredFields) are removed before comparing. A
+// command that wasn't recorded is an error.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// IgnoredFields are the top-level command fields left out when recording and
+// matching commands: the session and cluster time, which differ between
+// otherwise identical runs, and the fields the driver adds to every command
+// run through mongo.Database.RunCommand
+var IgnoredFields = []string{"lsid", "$clusterTime", "txnNumber", "$db", "$readPreference"}
+
+// ignoredReplyFields are the top-level reply fields left out of recordings,
+// since they differ between otherwise identical runs
+var ignoredReplyFields = []string{"$clusterTime", "operationTime"}
+
+// ErrUnknownCommand is returned when replaying a command that wasn't
+// recorded, or was replayed more times than it was recorded
+var ErrUnknownCommand = errors.New("command not in recording")
+
+// Entry is a recorded command and the server's reply to it
+type Entry struct {
+	// Database is the database the command ran against
+	Database string `bson:"database"`
+
+	// Command is the normalized command document
+	Command bson.Raw `bson:"command"`
+
+	// Reply is the server's reply, also for commands that failed
+	Reply bson.Raw `bson:"reply"`
+}
+
+// NormalizeCommand returns cmd without the IgnoredFields
+func NormalizeCommand(cmd bson.Raw) (bson.Raw, error) {
+	return withoutFields(cmd, IgnoredFields)
+}
+
+// NormalizeReply returns reply without the cluster time fields that differ
+// between runs
+func NormalizeReply(reply bson.Raw) (bson.Raw, error) {
+	return withoutFields(reply, ignoredReplyFields)
+}
+
+func withoutFields(doc bson.Raw, fields []string) (bson.Raw, error) {
+	elems, err := doc.Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	kept := make(bson.D, 0, len(elems))
+	for _, elem := range elems {
+		if !contains(fields, elem.Key()) {
+			kept = append(kept, bson.E{Key: elem.Key(), Value: elem.Value()})
+		}
+	}
+
+	return bson.Marshal(kept)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Writer writes a recording, one entry per line as canonical extended JSON
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriter returns a Writer writing to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write appends an entry to the recording
+func (w *Writer) Write(entry Entry) error {
+	line, err := bson.MarshalExtJSON(entry, true, false)
+	if err != nil {
+		return fmt.Errorf("error encoding recorded command: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err = w.w.Write(append(line, '\n'))
+	return err
+}
+
+// Player replays a recording. It's safe for concurrent use.
+type Player struct {
+	mu sync.Mutex
+	// replies are the recorded replies of each command, keyed by database
+	// and normalized command, in the order they were recorded
+	replies map[string][]bson.Raw
+}
+
+// Load reads the recording at path
+func Load(path string) (*Player, error) {
+	//nolint:gosec
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Read(f)
+}
+
+// Read reads a recording from r
+func Read(r io.Reader) (*Player, error) {
+	p := &Player{replies: map[string][]bson.Raw{}}
+
+	scanner := bufio.NewScanner(r)
+	// Replies can be as large as a batch of documents
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var entry Entry
+		err := bson.UnmarshalExtJSON(scanner.Bytes(), true, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("error reading recording line %d: %w", line, err)
+		}
+
+		key, err := commandKey(entry.Database, entry.Command)
+		if err != nil {
+			return nil, fmt.Errorf("error reading recording line %d: %w", line, err)
+		}
+		p.replies[key] = append(p.replies[key], entry.Reply)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// commandKey identifies a command by its database and normalized shape
+func commandKey(database string, cmd bson.Raw) (string, error) {
+	normalized, err := NormalizeCommand(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	shape, err := bson.MarshalExtJSON(normalized, true, false)
+	if err != nil {
+		return "", err
+	}
+
+	return database + "\x00" + string(shape), nil
+}
+
+// Reply returns the next recorded reply to cmd run against database. Replies
+// to a command that was recorded more than once are returned in the order
+// they were recorded. It returns an error wrapping ErrUnknownCommand if
+// there's no reply left.
+func (p *Player) Reply(database string, cmd interface{}) (bson.Raw, error) {
+	raw, err := bson.Marshal(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding command: %w", err)
+	}
+
+	key, err := commandKey(database, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	replies := p.replies[key]
+	if len(replies) == 0 {
+		return nil, fmt.Errorf("%w: %s on %s", ErrUnknownCommand, bson.Raw(raw), database)
+	}
+	p.replies[key] = replies[1:]
+
+	return replies[0], nil
+}
+
+// Remaining returns how many recorded replies haven't been replayed
+func (p *Player) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for _, replies := range p.replies {
+		n += len(replies)
+	}
+
+	return n
+}
+
+// Database returns a handle to run commands against database, like
+// mongo.Client.Database
+func (p *Player) Database(name string) *Database {
+	return &Database{player: p, name: name}
+}
+
+// Database replays commands run against one database. Its RunCommand has
+// the same shape as mongo.Database.RunCommand, so code that only runs
+// commands can accept either through a small interface of its own.
+type Database struct {
+	player *Player
+	name   string
+}
+
+// Name returns the database's name
+func (d *Database) Name() string {
+	return d.name
+}
+
+// RunCommand replays the recorded reply to cmd. Replies the server failed
+// with (ok: 0) are returned as an error by the result's Err and Decode.
+func (d *Database) RunCommand(_ context.Context, cmd interface{}) *SingleResult {
+	reply, err := d.player.Reply(d.name, cmd)
+	if err != nil {
+		return &SingleResult{err: err}
+	}
+
+	return &SingleResult{reply: reply, err: commandError(reply)}
+}
+
+// SingleResult is the result of Database.RunCommand, like mongo.SingleResult
+type SingleResult struct {
+	reply bson.Raw
+	err   error
+}
+
+// Err returns the error the command failed with, if any
+func (r *SingleResult) Err() error {
+	return r.err
+}
+
+// Raw returns the recorded reply, byte for byte, and the error the command
+// failed with, if any
+func (r *SingleResult) Raw() (bson.Raw, error) {
+	return r.reply, r.err
+}
+
+// Decode unmarshals the recorded reply into v, unless the command failed
+func (r *SingleResult) Decode(v interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	return bson.Unmarshal(r.reply, v)
+}
+
+// CommandError is a failed command's recorded reply
+type CommandError struct {
+	Code    int32
+	Name    string
+	Message string
+}
+
+func (e CommandError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("(%s) %s", e.Name, e.Message)
+	}
+
+	return e.Message
+}
+
+// commandError returns the error a reply reports, or nil if it reports
+// success
+func commandError(reply bson.Raw) error {
+	var status struct {
+		OK       float64 `bson:"ok"`
+		Code     int32   `bson:"code"`
+		CodeName string  `bson:"codeName"`
+		ErrMsg   string  `bson:"errmsg"`
+	}
+	err := bson.Unmarshal(reply, &status)
+	if err != nil {
+		return fmt.Errorf("error decoding recorded reply: %w", err)
+	}
+	if status.OK != 0 {
+		return nil
+	}
+
+	return CommandError{Code: status.Code, Name: status.CodeName, Message: status.ErrMsg}
+}
@@ -0,0 +1,101 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func mustMarshal(t *testing.T, doc interface{}) bson.Raw {
+	raw, err := bson.Marshal(doc)
+	require.NoError(t, err)
+	return raw
+}
+
+func TestNormalizeCommand(t *testing.T) {
+	cmd := mustMarshal(t, bson.D{
+		{Key: "find", Value: "users"},
+		{Key: "filter", Value: bson.D{{Key: "lsid", Value: "kept"}}},
+		{Key: "lsid", Value: bson.D{{Key: "id", Value: "session"}}},
+		{Key: "$clusterTime", Value: bson.D{{Key: "clusterTime", Value: bson.Timestamp{T: 1}}}},
+		{Key: "txnNumber", Value: int64(3)},
+		{Key: "$db", Value: "app"},
+	})
+
+	normalized, err := NormalizeCommand(cmd)
+	require.NoError(t, err)
+	assert.Equal(t, mustMarshal(t, bson.D{
+		{Key: "find", Value: "users"},
+		{Key: "filter", Value: bson.D{{Key: "lsid", Value: "kept"}}},
+	}), normalized)
+}
+
+func TestReplay(t *testing.T) {
+	find := bson.D{{Key: "find", Value: "users"}, {Key: "filter", Value: bson.D{{Key: "name", Value: "ada"}}}}
+	firstReply := mustMarshal(t, bson.D{
+		{Key: "cursor", Value: bson.D{{Key: "firstBatch", Value: bson.A{}}, {Key: "id", Value: int64(0)}, {Key: "ns", Value: "app.users"}}},
+		{Key: "ok", Value: 1.0},
+	})
+	secondReply := mustMarshal(t, bson.D{
+		{Key: "cursor", Value: bson.D{{Key: "firstBatch", Value: bson.A{bson.D{{Key: "name", Value: "ada"}, {Key: "n", Value: int32(1)}}}}, {Key: "id", Value: int64(0)}, {Key: "ns", Value: "app.users"}}},
+		{Key: "ok", Value: 1.0},
+	})
+	failedReply := mustMarshal(t, bson.D{
+		{Key: "ok", Value: 0.0},
+		{Key: "errmsg", Value: "E11000 duplicate key error"},
+		{Key: "code", Value: int32(11000)},
+		{Key: "codeName", Value: "DuplicateKey"},
+	})
+
+	var recording bytes.Buffer
+	w := NewWriter(&recording)
+	require.NoError(t, w.Write(Entry{Database: "app", Command: mustMarshal(t, find), Reply: firstReply}))
+	require.NoError(t, w.Write(Entry{Database: "app", Command: mustMarshal(t, find), Reply: secondReply}))
+	require.NoError(t, w.Write(Entry{Database: "app", Command: mustMarshal(t, bson.D{{Key: "create", Value: "users"}}), Reply: failedReply}))
+
+	player, err := Read(&recording)
+	require.NoError(t, err)
+	assert.Equal(t, 3, player.Remaining())
+
+	ctx := context.Background()
+	db := player.Database("app")
+
+	// Session fields don't matter, and repeated commands get their replies
+	// in order, byte for byte
+	withSession := append(bson.D{}, find...)
+	withSession = append(withSession, bson.E{Key: "lsid", Value: bson.D{{Key: "id", Value: "other"}}})
+	reply, err := db.RunCommand(ctx, withSession).Raw()
+	require.NoError(t, err)
+	assert.Equal(t, firstReply, reply)
+
+	var result struct {
+		Cursor struct {
+			FirstBatch []bson.M `bson:"firstBatch"`
+		} `bson:"cursor"`
+	}
+	require.NoError(t, db.RunCommand(ctx, find).Decode(&result))
+	assert.Equal(t, []bson.M{{"name": "ada", "n": int32(1)}}, result.Cursor.FirstBatch)
+
+	// Failed commands fail again
+	err = db.RunCommand(ctx, bson.D{{Key: "create", Value: "users"}}).Err()
+	var cmdErr CommandError
+	require.True(t, errors.As(err, &cmdErr), "expected a CommandError, got %v", err)
+	assert.Equal(t, int32(11000), cmdErr.Code)
+	assert.EqualError(t, err, "(DuplicateKey) E11000 duplicate key error")
+
+	// Commands that weren't recorded, or are replayed too often, fail
+	assert.ErrorIs(t, db.RunCommand(ctx, find).Err(), ErrUnknownCommand)
+	assert.ErrorIs(t, player.Database("other").RunCommand(ctx, bson.D{{Key: "create", Value: "users"}}).Err(), ErrUnknownCommand)
+	assert.Zero(t, player.Remaining())
+}
+
+func TestReadInvalid(t *testing.T) {
+	_, err := Read(bytes.NewBufferString("{\"database\": \"app\"}\nnot json\n"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+}
@@ -0,0 +1,192 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// setStartupTimeout returns how long starting every member of the replica
+// set may take altogether
+func (opts *Options) setStartupTimeout() time.Duration {
+	if opts.AdaptiveStartupTimeout {
+		return opts.StartupHardTimeout
+	}
+
+	return opts.StartupTimeout
+}
+
+// launchReplicaMembers starts members 1 and up of Options.ReplicaMembers, all
+// at once, before the replica set is initiated. elapsed is how long member 0
+// took to start, which counts against the set's startup timeout. If any
+// member fails to start, or ctx is done first, the others are stopped.
+func (s *Server) launchReplicaMembers(ctx context.Context, elapsed time.Duration) error {
+	count := s.opts.replicaMemberCount()
+	if count < 2 {
+		return nil
+	}
+	if count%2 == 0 {
+		s.logger.Warnf("A replica set of %d members can't elect a primary once %d of them are down, no better than one of %d; consider an odd number of members",
+			count, count/2, count-1)
+	}
+
+	// Versions are downloaded before the timeout starts
+	binaries := make([]memberBinary, count)
+	for index := 1; index < count; index++ {
+		binary, err := s.initialMemberBinary(ctx, index)
+		if err != nil {
+			return err
+		}
+		binaries[index] = binary
+	}
+
+	timeout := s.opts.setStartupTimeout()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timedOut := new(int32)
+	timer := s.opts.startupWait().timeSource().After(timeout - elapsed)
+	goTracked("replica set startup timer", func() {
+		select {
+		case <-timer:
+			atomic.StoreInt32(timedOut, 1)
+			cancel()
+		case <-ctx.Done():
+		}
+	})
+
+	procs := make([]*mongodProcess, count)
+	errs := make([]error, count)
+	var wg sync.WaitGroup
+	for index := 1; index < count; index++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			port := 0
+			if len(s.opts.ReplicaMemberPorts) > 0 {
+				port = s.opts.ReplicaMemberPorts[index]
+			}
+			procs[index], errs[index] = s.launchMember(ctx, index, port, s.opts.AdvertisedReplicaHost, binaries[index].path, binaries[index].caps)
+			if errs[index] == nil {
+				procs[index].version = binaries[index].version
+			}
+		}(index)
+	}
+	wg.Wait()
+
+	var err error
+	for index := 1; index < count && err == nil; index++ {
+		if errs[index] != nil {
+			err = fmt.Errorf("error starting replica set member %d: %w", index, errs[index])
+		}
+	}
+	if err != nil {
+		for index, proc := range procs {
+			if proc != nil {
+				proc.stop(s.memberLogger(index), false)
+			}
+		}
+		if atomic.LoadInt32(timedOut) == 1 {
+			return fmt.Errorf("%w: the replica set's %d members didn't all start within %s: %s", ErrStartupTimeout, count, timeout, err)
+		}
+		return err
+	}
+
+	s.mu.Lock()
+	for index := 1; index < count; index++ {
+		s.members[index] = procs[index]
+	}
+	s.nextMember = count
+	s.mu.Unlock()
+
+	return nil
+}
+
+// memberBinary is the mongod a replica set member runs
+type memberBinary struct {
+	path    string
+	caps    versionCapabilities
+	version string
+}
+
+// initialMemberBinary returns the mongod member index of
+// Options.ReplicaMembers runs: the server's, or its ReplicaMemberVersions
+// version
+func (s *Server) initialMemberBinary(ctx context.Context, index int) (memberBinary, error) {
+	binary := memberBinary{path: s.binPath, caps: s.caps, version: s.version}
+	if len(s.opts.ReplicaMemberVersions) == 0 || s.opts.ReplicaMemberVersions[index] == s.version {
+		return binary, nil
+	}
+
+	var err error
+	binary.version = s.opts.ReplicaMemberVersions[index]
+	binary.path, binary.caps, err = s.versionBinary(ctx, binary.version)
+	if err != nil {
+		return memberBinary{}, err
+	}
+
+	return binary, nil
+}
+
+// initialMemberConfig returns the replica set configuration documents of
+// members 1 and up, in index order, for initiating the set
+func (s *Server) initialMemberConfig() []interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	indexes := make([]int, 0, len(s.members))
+	for index := range s.members {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	members := make([]interface{}, len(indexes))
+	for i, index := range indexes {
+		member := memberDocument(s.memberHost(s.members[index]), s.initialMemberOptions(index))
+		members[i] = append(bson.D{{Key: "_id", Value: index}}, member...)
+	}
+
+	return members
+}
+
+// initialMemberOptions returns the options of member index of
+// Options.ReplicaMembers
+func (s *Server) initialMemberOptions(index int) MemberOptions {
+	var opts MemberOptions
+	if len(s.opts.ReplicaMemberTags) > 0 {
+		opts.Tags = s.opts.ReplicaMemberTags[index]
+	}
+	if index >= maxVotingMembers {
+		priority, votes := 0.0, 0
+		opts.Priority, opts.Votes = &priority, &votes
+	}
+
+	return opts
+}
+
+// uriHosts returns the addresses of the replica set members clients may
+// connect to: every member but the hidden ones, in index order
+func (s *Server) uriHosts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	indexes := make([]int, 0, len(s.members))
+	for index, proc := range s.members {
+		if !proc.hidden {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+
+	hosts := []string{s.addr()}
+	for _, index := range indexes {
+		member := s.members[index]
+		hosts = append(hosts, s.hostPort(member.host, member.port))
+	}
+
+	return hosts
+}
@@ -0,0 +1,172 @@
+package memongo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestInitialMemberConfig(t *testing.T) {
+	server := &Server{
+		host:           "127.0.0.1",
+		port:           27017,
+		replicaSetName: "rs0",
+		opts:           Options{ShouldUseReplica: true, ReplicaMembers: 8},
+		members:        map[int]*mongodProcess{},
+	}
+	for index := 1; index < 8; index++ {
+		server.members[index] = &mongodProcess{member: index, host: "127.0.0.1", port: 27017 + index}
+	}
+
+	config := server.replicaSetConfig(nil)
+	members := configField(config, "members").(bson.A)
+	require.Len(t, members, 8)
+	assert.Equal(t, bson.D{{Key: "_id", Value: 0}, {Key: "host", Value: "127.0.0.1:27017"}}, members[0])
+	assert.Equal(t, bson.D{{Key: "_id", Value: 1}, {Key: "host", Value: "127.0.0.1:27018"}}, members[1])
+
+	// Only seven members may vote
+	assert.Equal(t, bson.D{{Key: "_id", Value: 7}, {Key: "host", Value: "127.0.0.1:27024"}, {Key: "priority", Value: 0.0}, {Key: "votes", Value: 0}}, members[7])
+
+	server.opts.ReplicaMemberTags = []map[string]string{{"dc": "a"}, {"dc": "b"}, {"dc": "c"}}
+	server.opts.ReplicaMembers = 3
+	server.members = map[int]*mongodProcess{
+		2: {member: 2, host: "127.0.0.1", port: 27019},
+		1: {member: 1, host: "127.0.0.1", port: 27018},
+	}
+	members = configField(server.replicaSetConfig(nil), "members").(bson.A)
+	assert.Equal(t, bson.D{{Key: "_id", Value: 2}, {Key: "host", Value: "127.0.0.1:27019"}, {Key: "tags", Value: map[string]string{"dc": "c"}}}, members[2])
+}
+
+func TestReplicaMembersURI(t *testing.T) {
+	server := &Server{
+		host:           "127.0.0.1",
+		port:           27017,
+		isReplicaSet:   true,
+		replicaSetName: "rs0",
+		opts:           Options{ShouldUseReplica: true},
+		members: map[int]*mongodProcess{
+			2: {member: 2, host: "127.0.0.1", port: 27019},
+			1: {member: 1, host: "127.0.0.1", port: 27018},
+			3: {member: 3, host: "127.0.0.1", port: 27020, hidden: true},
+		},
+	}
+	assert.Equal(t, "mongodb://127.0.0.1:27017", server.URI())
+
+	server.opts.ReplicaMembers = 3
+	assert.Equal(t, "mongodb://127.0.0.1:27017,127.0.0.1:27018,127.0.0.1:27019/?replicaSet=rs0", server.URI())
+}
+
+func TestClientOptions(t *testing.T) {
+	server := &Server{
+		host:           "127.0.0.1",
+		port:           27017,
+		isReplicaSet:   true,
+		replicaSetName: "rs0",
+		opts:           Options{ShouldUseReplica: true},
+		members: map[int]*mongodProcess{
+			1: {member: 1, host: "127.0.0.1", port: 27018},
+		},
+	}
+
+	// A single-node replica set is connected to directly
+	clientOpts := server.ClientOptions()
+	require.NoError(t, clientOpts.Validate())
+	assert.Equal(t, []string{"127.0.0.1:27017"}, clientOpts.Hosts)
+	assert.True(t, *clientOpts.Direct)
+	assert.Nil(t, clientOpts.ReplicaSet)
+	assert.Nil(t, clientOpts.Auth)
+	assert.NotNil(t, clientOpts.Monitor)
+
+	server.opts.ReplicaMembers = 2
+	clientOpts = server.ClientOptions()
+	require.NoError(t, clientOpts.Validate())
+	assert.Equal(t, []string{"127.0.0.1:27017", "127.0.0.1:27018"}, clientOpts.Hosts)
+	assert.Nil(t, clientOpts.Direct)
+	assert.Equal(t, "rs0", *clientOpts.ReplicaSet)
+
+	server.setCredential("root", "pw")
+	clientOpts = server.ClientOptions()
+	assert.Equal(t, "root", clientOpts.Auth.Username)
+	assert.Equal(t, "pw", clientOpts.Auth.Password)
+	assert.Equal(t, "admin", clientOpts.Auth.AuthSource)
+
+	// Changing the options changes neither the server's nor later ones
+	clientOpts.SetMaxPoolSize(5).Auth.Username = "app"
+	assert.Equal(t, "root", server.ClientOptions().Auth.Username)
+	assert.Nil(t, server.ClientOptions().MaxPoolSize)
+}
+
+func TestLaunchReplicaMembersTimeout(t *testing.T) {
+	shortLeakCheck(t)
+
+	// The script never reports that it's listening
+	script := filepath.Join(t.TempDir(), "mongod")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\nexec sleep 30\n"), 0o755))
+	base := t.TempDir()
+
+	server := &Server{
+		binPath: script,
+		caps:    versionCapabilities{reReady: reReady},
+		logger:  memongolog.New(nil, memongolog.LogLevelSilent),
+		events:  newEventBus(nil),
+		opts: Options{
+			ShouldUseReplica: true,
+			ReplicaSetName:   "rs0",
+			ReplicaMembers:   3,
+			StartupTimeout:   30 * time.Second,
+			TempDirBase:      base,
+		},
+		members:    map[int]*mongodProcess{},
+		nextMember: 1,
+	}
+
+	// Member 0 took all but 200ms of the set's timeout, which each member
+	// would have had to itself
+	start := time.Now()
+	err := server.launchReplicaMembers(context.Background(), 30*time.Second-200*time.Millisecond)
+	require.ErrorIs(t, err, ErrStartupTimeout)
+	assert.Contains(t, err.Error(), "the replica set's 3 members didn't all start within 30s")
+	assert.Less(t, time.Since(start), 10*time.Second)
+
+	// Every member was stopped and its data directory removed
+	assert.Empty(t, server.members)
+	entries, err := os.ReadDir(base)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	VerifyNoLeaks(t)
+}
+
+func TestLaunchReplicaMembersFailure(t *testing.T) {
+	base := t.TempDir()
+	server := &Server{
+		binPath: filepath.Join(t.TempDir(), "missing"),
+		caps:    versionCapabilities{reReady: reReady},
+		logger:  memongolog.New(nil, memongolog.LogLevelSilent),
+		events:  newEventBus(nil),
+		opts: Options{
+			ShouldUseReplica: true,
+			ReplicaSetName:   "rs0",
+			ReplicaMembers:   2,
+			StartupTimeout:   30 * time.Second,
+			TempDirBase:      base,
+		},
+		members:    map[int]*mongodProcess{},
+		nextMember: 1,
+	}
+
+	err := server.launchReplicaMembers(context.Background(), 0)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error starting replica set member 1")
+	assert.NotErrorIs(t, err, ErrStartupTimeout)
+	assert.Empty(t, server.members)
+	entries, err := os.ReadDir(base)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
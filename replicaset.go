@@ -0,0 +1,145 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/100mslive/memongo/v2/retry"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// ReplicaSetConfig customizes the configuration InitiateReplicaSet initiates
+// the replica set with
+type ReplicaSetConfig struct {
+	// Members replaces the member list. Each member needs at least _id and
+	// host. Defaults to this server, at the address its URIs use (see
+	// Server.Host), with the tags from
+	// Options.ReplicaMemberTags.
+	Members []bson.D
+
+	// Settings is the configuration's settings document, e.g.
+	// bson.D{{Key: "electionTimeoutMillis", Value: 500}}
+	Settings bson.D
+}
+
+// retryableInitiateCodes are the error codes replSetInitiate can fail with
+// transiently right after mongod starts listening
+var retryableInitiateCodes = []int{
+	74,    // NodeNotFound
+	94,    // NotYetInitialized
+	11602, // InterruptedDueToReplStateChange
+}
+
+// defaultReplicaSetRetryPolicy is the default Options.ReplicaSetRetryPolicy
+var defaultReplicaSetRetryPolicy = retry.Exponential{Initial: 50 * time.Millisecond, Max: time.Second}
+
+// replicaSetRetryPolicy returns the policy replica set commands are retried
+// with
+func (opts *Options) replicaSetRetryPolicy() retry.Policy {
+	if opts.ReplicaSetRetryPolicy == nil {
+		return defaultReplicaSetRetryPolicy
+	}
+
+	return opts.ReplicaSetRetryPolicy
+}
+
+// InitiateReplicaSet initiates the replica set and waits for this server to
+// become primary. StartWithOptions calls it unless
+// Options.DeferReplicaSetInitiation is set; call it yourself in that case,
+// optionally with a ReplicaSetConfig.
+//
+// Transient failures are retried as Options.ReplicaSetRetryPolicy says, with
+// exponential backoff by default, for up to Options.ReplicaSetReadyTimeout in
+// total.
+func (s *Server) InitiateReplicaSet(ctx context.Context, cfg ...ReplicaSetConfig) error {
+	if !s.isReplicaSet {
+		return fmt.Errorf("cannot initiate a replica set: the server wasn't started with ShouldUseReplica")
+	}
+	if len(cfg) > 1 {
+		return fmt.Errorf("at most one ReplicaSetConfig may be given")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.opts.ReplicaSetReadyTimeout)
+	defer cancel()
+
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	config := s.replicaSetConfig(cfg)
+
+	err = retry.Do(ctx, s.opts.replicaSetRetryPolicy(), func() error {
+		err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "replSetInitiate", Value: config}}).Err()
+		if err == nil || !isRetryableInitiateError(err) {
+			return retry.Permanent(err)
+		}
+
+		s.logger.Debugf("replSetInitiate failed, retrying: %s", err)
+		return err
+	})
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return fmt.Errorf("gave up initiating the replica set after %w", err)
+	}
+	if err != nil {
+		if mismatchErr := replicaSetNameMismatch(s.proc.mismatch, s.dbDir); mismatchErr != nil {
+			err = mismatchErr
+		}
+		s.logger.Warnf("error while init replica set: %s", err)
+		return err
+	}
+
+	err = waitForPrimary(ctx, client)
+	if err != nil {
+		if mismatchErr := replicaSetNameMismatch(s.proc.mismatch, s.dbDir); mismatchErr != nil {
+			err = mismatchErr
+		}
+		s.logger.Warnf("error while waiting for a primary: %s", err)
+		return err
+	}
+	s.events.emitState(0, "PRIMARY")
+	s.events.emit(EventPrimaryElected, 0, nil)
+
+	return nil
+}
+
+func (s *Server) replicaSetConfig(cfg []ReplicaSetConfig) bson.D {
+	var members bson.A
+	if len(cfg) == 1 && len(cfg[0].Members) > 0 {
+		for _, member := range cfg[0].Members {
+			members = append(members, member)
+		}
+	} else {
+		// Name the member by the address clients connect to, or
+		// AdvertisedReplicaHost. By default mongod uses the machine's
+		// hostname, which clients doing replica set discovery then switch to
+		// and may not be able to resolve.
+		member := bson.D{{Key: "_id", Value: 0}, {Key: "host", Value: s.replicaHost()}}
+		if len(s.opts.ReplicaMemberTags) > 0 {
+			member = append(member, bson.E{Key: "tags", Value: s.opts.ReplicaMemberTags[0]})
+		}
+		members = append(bson.A{member}, s.initialMemberConfig()...)
+	}
+
+	config := bson.D{
+		{Key: "_id", Value: s.replicaSetName},
+		{Key: "members", Value: members},
+	}
+	if s.opts.clusterRole == roleConfigServer {
+		config = append(config, bson.E{Key: "configsvr", Value: true})
+	}
+	if len(cfg) == 1 && cfg[0].Settings != nil {
+		config = append(config, bson.E{Key: "settings", Value: cfg[0].Settings})
+	}
+
+	return config
+}
+
+func isRetryableInitiateError(err error) bool {
+	return hasErrorCode(err, retryableInitiateCodes)
+}
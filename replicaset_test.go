@@ -0,0 +1,58 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestIsRetryableInitiateError(t *testing.T) {
+	assert.True(t, isRetryableInitiateError(mongo.CommandError{Code: 74, Name: "NodeNotFound"}))
+	assert.True(t, isRetryableInitiateError(mongo.CommandError{Code: 94, Name: "NotYetInitialized"}))
+	assert.True(t, isRetryableInitiateError(mongo.CommandError{Code: 11602, Name: "InterruptedDueToReplStateChange"}))
+	assert.False(t, isRetryableInitiateError(mongo.CommandError{Code: 23, Name: "AlreadyInitialized"}))
+	assert.False(t, isRetryableInitiateError(errors.New("connection refused")))
+}
+
+func TestReplicaSetConfig(t *testing.T) {
+	server := &Server{
+		port:           27017,
+		replicaSetName: "rs0",
+		opts:           Options{ReplicaMemberTags: []map[string]string{{"dc": "east"}}},
+	}
+
+	assert.Equal(t, bson.D{
+		{Key: "_id", Value: "rs0"},
+		{Key: "members", Value: bson.A{
+			bson.D{{Key: "_id", Value: 0}, {Key: "host", Value: "localhost:27017"}, {Key: "tags", Value: map[string]string{"dc": "east"}}},
+		}},
+	}, server.replicaSetConfig(nil))
+
+	custom := ReplicaSetConfig{
+		Members:  []bson.D{{{Key: "_id", Value: 5}, {Key: "host", Value: "localhost:27017"}, {Key: "priority", Value: 2}}},
+		Settings: bson.D{{Key: "electionTimeoutMillis", Value: 500}},
+	}
+	assert.Equal(t, bson.D{
+		{Key: "_id", Value: "rs0"},
+		{Key: "members", Value: bson.A{custom.Members[0]}},
+		{Key: "settings", Value: custom.Settings},
+	}, server.replicaSetConfig([]ReplicaSetConfig{custom}))
+}
+
+func TestInitiateReplicaSetErrors(t *testing.T) {
+	ctx := context.Background()
+
+	err := (&Server{}).InitiateReplicaSet(ctx)
+	assert.EqualError(t, err, "cannot initiate a replica set: the server wasn't started with ShouldUseReplica")
+
+	err = (&Server{isReplicaSet: true}).InitiateReplicaSet(ctx, ReplicaSetConfig{}, ReplicaSetConfig{})
+	assert.EqualError(t, err, "at most one ReplicaSetConfig may be given")
+
+	require.EqualError(t, (&Options{MongodBin: "/bin/true", DeferReplicaSetInitiation: true}).Validate(),
+		"cannot use DeferReplicaSetInitiation without ShouldUseReplica")
+}
@@ -0,0 +1,151 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/100mslive/memongo/v2/retry"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// errNotRejoined is returned by waitForRejoin's attempts until the server is
+// back in its replica set
+var errNotRejoined = errors.New("the server hasn't rejoined its replica set yet")
+
+// Restart stops mongod and starts it again on the same data directory and
+// port, so the server's URIs don't change and clients connected to it can
+// reconnect. It's for testing how an application copes with the server going
+// away: the data is still there afterwards, unless the storage engine keeps
+// it in memory (see StorageEngine). Restart waits for mongod to listen again
+// and, for a replica set, to rejoin the set once it has a primary. Like
+// StopWithContext, it kills mongod if it hasn't shut down once ctx is done,
+// or after 10 seconds if ctx has no deadline.
+//
+// Only the server itself is restarted; other replica set members keep
+// running. Sharded clusters can't be restarted. If something else took the
+// port while mongod was stopped, Restart returns ErrPortInUse rather than
+// moving to another port. If mongod doesn't start again, the server must be
+// stopped.
+func (s *Server) Restart(ctx context.Context) error {
+	if err := s.checkRunning(); err != nil {
+		return err
+	}
+	if s.cluster != nil {
+		return fmt.Errorf("cannot restart a sharded cluster")
+	}
+
+	s.mu.Lock()
+	proc, binPath, caps := s.proc, s.binPath, s.caps
+	s.mu.Unlock()
+
+	s.logger.Debugf("Restarting mongod on port %d", proc.port)
+	stopCtx := ctx
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		stopCtx, cancel = context.WithTimeout(ctx, gracefulStopTimeout)
+		defer cancel()
+	}
+	proc.keepDBDir = true
+	err := proc.stopContext(stopCtx, s.memberLogger(0), true)
+	trackPath(proc.dbDir, "data directory")
+	if err != nil {
+		return fmt.Errorf("error stopping mongod: %w", err)
+	}
+
+	portTaken := func(err error) error {
+		return fmt.Errorf("error restarting mongod: port %d was taken while it was stopped: %w", proc.port, err)
+	}
+	err = checkPortFree(proc.port)
+	if err != nil {
+		return portTaken(err)
+	}
+	restarted, err := s.relaunch(ctx, proc, 0, binPath, caps)
+	if errors.Is(err, ErrPortInUse) {
+		return portTaken(fmt.Errorf("%w%s", err, describePortOwner(proc.port)))
+	}
+	if err != nil {
+		return fmt.Errorf("error restarting mongod: %w", err)
+	}
+	restarted.version = proc.version
+	err = s.swapProcess(restarted)
+	if err != nil {
+		return err
+	}
+
+	if !s.isReplicaSet {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.opts.ReplicaSetReadyTimeout)
+	defer cancel()
+
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	state, err := waitForRejoin(ctx, client)
+	if err != nil {
+		return fmt.Errorf("error waiting for mongod to rejoin the replica set: %w", err)
+	}
+	s.events.emitState(0, state)
+
+	return nil
+}
+
+// swapProcess makes restarted the server's mongod, unless the server was
+// stopped while it was starting, in which case restarted is stopped too,
+// leaving the data directory to what Stop did with it
+func (s *Server) swapProcess(restarted *mongodProcess) error {
+	s.mu.Lock()
+	err := s.checkRunning()
+	if err == nil {
+		s.proc = restarted
+	}
+	s.mu.Unlock()
+	if err != nil {
+		restarted.keepDBDir = true
+		_ = restarted.stop(s.memberLogger(0), false)
+		return err
+	}
+
+	s.health.setProcess(restarted.exited)
+
+	return nil
+}
+
+// waitForRejoin polls the server until it's the primary or a secondary of its
+// replica set and knows which member is the primary, or ctx is done, and
+// returns which of the two it is
+func waitForRejoin(ctx context.Context, client *mongo.Client) (string, error) {
+	state := "SECONDARY"
+	err := retry.Do(ctx, retry.Constant{Interval: primaryPollInterval}, func() error {
+		var result struct {
+			IsMaster  bool   `bson:"ismaster"`
+			Secondary bool   `bson:"secondary"`
+			Primary   string `bson:"primary"`
+		}
+		err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&result)
+		if err != nil {
+			return retry.Permanent(err)
+		}
+		if !(result.IsMaster || result.Secondary) || result.Primary == "" {
+			return errNotRejoined
+		}
+		if result.IsMaster {
+			state = "PRIMARY"
+		}
+
+		return nil
+	})
+	if err != nil && ctx.Err() != nil {
+		return "", fmt.Errorf("timed out waiting for a primary to be elected")
+	}
+
+	return state, err
+}
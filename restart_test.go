@@ -0,0 +1,65 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestartPortTaken(t *testing.T) {
+	server := fakeServer(t, Cleanup{})
+	defer server.Stop()
+	proc := server.proc
+
+	// Something else takes the port while mongod is stopped
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer l.Close()
+	proc.port = l.Addr().(*net.TCPAddr).Port
+
+	err = server.Restart(context.Background())
+	require.ErrorIs(t, err, ErrPortInUse)
+	assert.Contains(t, err.Error(), "was taken while it was stopped")
+	assert.True(t, proc.hasExited())
+	assert.DirExists(t, proc.dbDir)
+
+	// The data directory is still removed by Stop
+	server.Stop()
+	_, err = os.Stat(proc.dbDir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRestartFailureKeepsData(t *testing.T) {
+	server := fakeServer(t, Cleanup{})
+	defer server.Stop()
+	proc := server.proc
+	require.NoError(t, os.WriteFile(filepath.Join(proc.dbDir, "collection-0.wt"), nil, 0o600))
+	server.binPath = filepath.Join(t.TempDir(), "missing")
+
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	proc.port = l.Addr().(*net.TCPAddr).Port
+	require.NoError(t, l.Close())
+
+	err = server.Restart(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error restarting mongod")
+	assert.FileExists(t, filepath.Join(proc.dbDir, "collection-0.wt"))
+}
+
+func TestRestartUnsupported(t *testing.T) {
+	server := fakeCluster(t, Cleanup{}, 1)
+	defer server.Stop()
+	assert.EqualError(t, server.Restart(context.Background()), "cannot restart a sharded cluster")
+	assert.False(t, server.proc.hasExited())
+
+	server = fakeServer(t, Cleanup{})
+	server.Stop()
+	assert.True(t, errors.Is(server.Restart(context.Background()), ErrServerStopped))
+}
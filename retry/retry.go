@@ -0,0 +1,192 @@
+// Package retry calls a function until it succeeds, waiting between attempts
+// as a Policy says. memongo uses it for every retry and poll loop, and its
+// Options take Policies, so custom readiness checks can wait the same way.
+package retry
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+)
+
+// Policy decides how long to wait before each retry, and when to give up
+type Policy interface {
+	// Next returns how long to wait before the next attempt, after retries
+	// failed attempts (1 after the first) and elapsed since the first one
+	// started, or false to give up
+	Next(retries int, elapsed time.Duration) (time.Duration, bool)
+}
+
+// Clock is the time source Do measures elapsed time and waits with.
+// memongo.Clock is the same interface, so memongotest.FakeClock is one.
+type Clock interface {
+	Now() time.Time
+
+	// After returns a channel that receives the time once d has passed
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the Clock of the time package
+type SystemClock struct{}
+
+// Now returns time.Now()
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// After returns time.After(d)
+func (SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// permanentError marks an error that isn't worth retrying
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps err so Do returns it at once instead of retrying. Do
+// returns err itself, not the wrapper. Permanent(nil) is nil.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+// Do calls fn until it returns nil, returns an error wrapped with Permanent,
+// or policy gives up, and returns fn's last error. If ctx is done while
+// waiting, it returns an error wrapping ctx.Err() that includes the last
+// error's message.
+func Do(ctx context.Context, policy Policy, fn func() error) error {
+	return DoWithClock(ctx, SystemClock{}, policy, fn)
+}
+
+// DoWithClock is like Do, but measures elapsed time and waits with clock
+func DoWithClock(ctx context.Context, clock Clock, policy Policy, fn func() error) error {
+	start := clock.Now()
+	for retries := 1; ; retries++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+
+		wait, ok := policy.Next(retries, clock.Now().Sub(start))
+		if !ok {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w", err, ctx.Err())
+		case <-clock.After(wait):
+		}
+	}
+}
+
+// Constant waits Interval between attempts, and never gives up
+type Constant struct {
+	Interval time.Duration
+}
+
+// Next returns Interval
+func (c Constant) Next(int, time.Duration) (time.Duration, bool) {
+	return c.Interval, true
+}
+
+// Exponential waits Initial before the first retry, multiplying the wait by
+// Multiplier after each one, up to Max. It never gives up.
+type Exponential struct {
+	Initial time.Duration
+
+	// Max caps the wait. Zero means no cap.
+	Max time.Duration
+
+	// Multiplier defaults to 2
+	Multiplier float64
+
+	// Jitter randomizes each wait by up to this fraction of it either way,
+	// e.g. 0.2 for ±20%, so concurrent callers don't retry in lockstep.
+	// The jittered wait may exceed Max by the same fraction. Values above 1
+	// count as 1.
+	Jitter float64
+}
+
+// Next returns the wait before retry number retries
+func (e Exponential) Next(retries int, _ time.Duration) (time.Duration, bool) {
+	multiplier := e.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	wait := float64(e.Initial) * math.Pow(multiplier, float64(retries-1))
+	if e.Max > 0 && wait > float64(e.Max) {
+		wait = float64(e.Max)
+	}
+	if e.Jitter > 0 {
+		jitter := math.Min(e.Jitter, 1)
+		wait *= 1 + jitter*(2*randomFraction()-1)
+	}
+
+	return time.Duration(wait), true
+}
+
+// randomFraction returns a random number in [0, 1]
+func randomFraction() float64 {
+	const precision = 1 << 30
+	n, err := rand.Int(rand.Reader, big.NewInt(precision+1))
+	if err != nil {
+		panic(fmt.Errorf("error getting a random int: %s", err))
+	}
+
+	return float64(n.Int64()) / precision
+}
+
+// MaxElapsed waits as Policy does, but gives up once the next attempt would
+// start more than Limit after the first one
+type MaxElapsed struct {
+	Policy Policy
+	Limit  time.Duration
+}
+
+// Next returns Policy's wait, or false if it would end past Limit
+func (m MaxElapsed) Next(retries int, elapsed time.Duration) (time.Duration, bool) {
+	wait, ok := m.Policy.Next(retries, elapsed)
+	if !ok || elapsed+wait > m.Limit {
+		return 0, false
+	}
+
+	return wait, true
+}
+
+// MaxRetries waits as Policy does, but gives up after Retries retries
+type MaxRetries struct {
+	Policy  Policy
+	Retries int
+}
+
+// Next returns Policy's wait, or false once Retries retries have been made
+func (m MaxRetries) Next(retries int, elapsed time.Duration) (time.Duration, bool) {
+	if retries > m.Retries {
+		return 0, false
+	}
+
+	return m.Policy.Next(retries, elapsed)
+}
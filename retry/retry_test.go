@@ -0,0 +1,166 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongotest"
+	"github.com/100mslive/memongo/v2/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var errTransient = errors.New("transient")
+
+func TestDoWaitsOnTheClock(t *testing.T) {
+	clock := memongotest.NewFakeClock(epoch)
+	attempts := 0
+	errCh := make(chan error)
+	go func() {
+		errCh <- retry.DoWithClock(context.Background(), clock, retry.Constant{Interval: time.Second}, func() error {
+			attempts++
+			if attempts < 3 {
+				return errTransient
+			}
+			return nil
+		})
+	}()
+
+	// Each retry waits for the clock to advance by the interval
+	for i := 0; i < 2; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(time.Second - time.Millisecond)
+		assert.Equal(t, 1, clock.Waiters())
+		clock.Advance(time.Millisecond)
+	}
+	require.NoError(t, <-errCh)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestDoPermanent(t *testing.T) {
+	errFatal := errors.New("fatal")
+	attempts := 0
+	err := retry.Do(context.Background(), retry.Constant{}, func() error {
+		attempts++
+		return retry.Permanent(errFatal)
+	})
+	assert.Equal(t, errFatal, err)
+	assert.Equal(t, 1, attempts)
+
+	assert.NoError(t, retry.Permanent(nil))
+}
+
+func TestDoMaxRetries(t *testing.T) {
+	attempts := 0
+	err := retry.Do(context.Background(), retry.MaxRetries{Policy: retry.Constant{}, Retries: 2}, func() error {
+		attempts++
+		return errTransient
+	})
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = retry.Do(context.Background(), retry.MaxRetries{Policy: retry.Constant{}}, func() error {
+		attempts++
+		return errTransient
+	})
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestDoContextDone(t *testing.T) {
+	clock := memongotest.NewFakeClock(epoch)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error)
+	go func() {
+		errCh <- retry.DoWithClock(ctx, clock, retry.Constant{Interval: time.Hour}, func() error {
+			return errTransient
+		})
+	}()
+
+	clock.BlockUntil(1)
+	cancel()
+	err := <-errCh
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.EqualError(t, err, "transient: context canceled")
+}
+
+func TestMaxElapsed(t *testing.T) {
+	clock := memongotest.NewFakeClock(epoch)
+	policy := retry.MaxElapsed{Policy: retry.Constant{Interval: 4 * time.Second}, Limit: 10 * time.Second}
+	attempts := 0
+	errCh := make(chan error)
+	go func() {
+		errCh <- retry.DoWithClock(context.Background(), clock, policy, func() error {
+			attempts++
+			return errTransient
+		})
+	}()
+
+	// Attempts start at 0s, 4s and 8s; one at 12s would be past the limit
+	for i := 0; i < 2; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(4 * time.Second)
+	}
+	assert.Equal(t, errTransient, <-errCh)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestExponential(t *testing.T) {
+	policy := retry.Exponential{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+
+	var waits []time.Duration
+	for retries := 1; retries <= 5; retries++ {
+		wait, ok := policy.Next(retries, 0)
+		require.True(t, ok)
+		waits = append(waits, wait)
+	}
+	assert.Equal(t, []time.Duration{
+		10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond,
+	}, waits)
+
+	policy = retry.Exponential{Initial: time.Second, Multiplier: 3}
+	wait, _ := policy.Next(3, 0)
+	assert.Equal(t, 9*time.Second, wait)
+}
+
+func TestExponentialJitterBounds(t *testing.T) {
+	withinBounds := func(initialMS uint16, retries uint8, jitterPct uint8) bool {
+		jitter := float64(jitterPct) / 100
+		policy := retry.Exponential{
+			Initial: time.Duration(initialMS) * time.Millisecond,
+			Max:     time.Minute,
+			Jitter:  jitter,
+		}
+		n := int(retries%20) + 1
+
+		base := float64(policy.Initial) * math.Pow(2, float64(n-1))
+		if base > float64(policy.Max) {
+			base = float64(policy.Max)
+		}
+		jitter = math.Min(jitter, 1)
+
+		wait, ok := policy.Next(n, 0)
+		low, high := base*(1-jitter), base*(1+jitter)
+		return ok && float64(wait) >= math.Floor(low) && float64(wait) <= math.Ceil(high)
+	}
+
+	require.NoError(t, quick.Check(withinBounds, &quick.Config{MaxCount: 1000}))
+}
+
+func TestExponentialJitterVaries(t *testing.T) {
+	policy := retry.Exponential{Initial: time.Second, Jitter: 0.5}
+
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 20; i++ {
+		wait, _ := policy.Next(1, 0)
+		seen[wait] = true
+	}
+	assert.Greater(t, len(seen), 1)
+}
@@ -0,0 +1,159 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/event"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/x/mongo/driver"
+)
+
+// RollbackFallback is what RollbackPerTest does when a test's writes can't
+// be rolled back
+type RollbackFallback int
+
+const (
+	// RollbackSkip skips the test if the server can't run transactions, and
+	// logs if the test committed the transaction itself
+	RollbackSkip RollbackFallback = iota
+
+	// RollbackFail fails the test in both cases
+	RollbackFail
+)
+
+// RollbackOptions configures RollbackPerTestWithOptions
+type RollbackOptions struct {
+	// Fallback is what to do when writes can't be rolled back. Defaults to
+	// RollbackSkip.
+	Fallback RollbackFallback
+}
+
+// Error codes of commands that can't run in the rollback transaction
+const (
+	codeNoSuchTransaction                  = 251
+	codeOperationNotSupportedInTransaction = 263
+)
+
+// RollbackPerTest runs the calling test in a transaction that's aborted when
+// the test finishes, so a server seeded once can be shared by tests that
+// write to a fixed database without seeing each other's writes. It returns
+// the database db, and a context carrying the transaction's session: only
+// operations run with that context, or one derived from it, are rolled back.
+//
+// Transactions need a replica set and MongoDB 4.0; on other servers the test
+// is skipped. Some operations can't run in a transaction, such as building
+// indexes on existing collections, or creating collections before MongoDB
+// 4.4; when one fails, the reason is logged. Transactions are also aborted by
+// the server after transactionLifetimeLimitSeconds, 60 by default. If the
+// test commits the transaction, its writes persist, which is logged.
+func RollbackPerTest(tb testing.TB, server *Server, db string) (*mongo.Database, context.Context) {
+	tb.Helper()
+
+	return RollbackPerTestWithOptions(tb, server, db, RollbackOptions{})
+}
+
+// RollbackPerTestWithOptions is like RollbackPerTest, with opts controlling
+// what happens when writes can't be rolled back
+func RollbackPerTestWithOptions(tb testing.TB, server *Server, db string, opts RollbackOptions) (*mongo.Database, context.Context) {
+	tb.Helper()
+
+	ctx := context.Background()
+	caps, err := server.Capabilities(ctx)
+	if err != nil {
+		tb.Fatalf("error checking whether the server supports transactions: %s", err)
+	}
+	if !caps.Transactions {
+		topology := "standalone server"
+		if server.IsReplicaSet() {
+			topology = "replica set"
+		}
+		reason := fmt.Sprintf("can't roll back writes: transactions need a replica set running MongoDB 4.0 or later, and the server is a MongoDB %s %s",
+			caps.Version, topology)
+		if opts.Fallback == RollbackFail {
+			tb.Fatal(reason)
+		}
+		tb.Skip(reason)
+	}
+
+	var committed int32
+	monitor := &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			if e.CommandName == "commitTransaction" {
+				atomic.StoreInt32(&committed, 1)
+			}
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			if limitation := rollbackLimitation(e.CommandName, e.Failure); limitation != "" {
+				tb.Log(limitation)
+			}
+		},
+	}
+
+	client, err := mongo.Connect(options.Client().ApplyURI(server.DirectURI()).SetMonitor(monitor))
+	if err != nil {
+		tb.Fatalf("error connecting to MongoDB: %s", err)
+	}
+
+	sess, err := client.StartSession()
+	if err != nil {
+		_ = client.Disconnect(ctx)
+		tb.Fatalf("error starting session: %s", err)
+	}
+
+	err = sess.StartTransaction()
+	if err != nil {
+		sess.EndSession(ctx)
+		_ = client.Disconnect(ctx)
+		tb.Fatalf("error starting transaction: %s", err)
+	}
+
+	tb.Cleanup(func() {
+		if atomic.LoadInt32(&committed) == 1 {
+			msg := "the test committed its transaction, so its writes weren't rolled back"
+			if opts.Fallback == RollbackFail {
+				tb.Error(msg)
+			} else {
+				tb.Log(msg)
+			}
+		}
+
+		// Aborting fails if the test already aborted or committed the
+		// transaction; a commit is reported above
+		_ = sess.AbortTransaction(ctx)
+		sess.EndSession(ctx)
+
+		err := client.Disconnect(ctx)
+		if err != nil {
+			tb.Errorf("error disconnecting from MongoDB: %s", err)
+		}
+	})
+
+	return client.Database(db), mongo.NewSessionContext(ctx, sess)
+}
+
+// rollbackLimitation explains why a command failed in the rollback
+// transaction, if it's because of what transactions allow, or returns ""
+func rollbackLimitation(command string, failure error) string {
+	var driverErr driver.Error
+	if !errors.As(failure, &driverErr) {
+		return ""
+	}
+
+	switch driverErr.Code {
+	case codeOperationNotSupportedInTransaction:
+		return fmt.Sprintf("%s can't run in the transaction RollbackPerTest rolls back writes with: %s. "+
+			"Index builds on existing collections and, before MongoDB 4.4, creating collections aren't allowed in transactions; "+
+			"do them when seeding the server instead", command, driverErr.Message)
+	case codeNoSuchTransaction:
+		return fmt.Sprintf("%s failed because the transaction RollbackPerTest rolls back writes with was aborted: %s. "+
+			"The server aborts transactions that run longer than transactionLifetimeLimitSeconds, 60 by default, "+
+			"and after errors such as write conflicts", command, driverErr.Message)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,82 @@
+package memongo
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/x/mongo/driver"
+)
+
+func TestRollbackLimitation(t *testing.T) {
+	msg := rollbackLimitation("createIndexes", driver.Error{
+		Code:    codeOperationNotSupportedInTransaction,
+		Message: "Cannot create new indexes on existing collection app.users in a multi-document transaction.",
+	})
+	assert.Contains(t, msg, "createIndexes can't run in the transaction RollbackPerTest rolls back writes with: Cannot create new indexes")
+	assert.Contains(t, msg, "do them when seeding the server instead")
+
+	msg = rollbackLimitation("insert", driver.Error{Code: codeNoSuchTransaction, Message: "Transaction with txnNumber 1 has been aborted."})
+	assert.Contains(t, msg, "transactionLifetimeLimitSeconds")
+
+	assert.Empty(t, rollbackLimitation("insert", driver.Error{Code: 11000, Message: "duplicate key"}))
+	assert.Empty(t, rollbackLimitation("insert", errors.New("connection reset")))
+}
+
+// stoppedTB is a testing.TB that records how a test was stopped by Skip or
+// Fatal, which must be called from its own goroutine
+type stoppedTB struct {
+	testing.TB
+	skipped string
+	fatal   string
+}
+
+func (r *stoppedTB) Helper() {}
+
+func (r *stoppedTB) Skip(args ...interface{}) {
+	r.skipped = fmt.Sprint(args...)
+	runtime.Goexit()
+}
+
+func (r *stoppedTB) Fatal(args ...interface{}) {
+	r.fatal = fmt.Sprint(args...)
+	runtime.Goexit()
+}
+
+// runStopped runs f with a stoppedTB in its own goroutine
+func runStopped(t *testing.T, f func(tb testing.TB)) *stoppedTB {
+	tb := &stoppedTB{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f(tb)
+	}()
+	<-done
+
+	return tb
+}
+
+func TestRollbackPerTestStandalone(t *testing.T) {
+	server := &Server{version: "8.0.0"}
+
+	tb := runStopped(t, func(tb testing.TB) {
+		RollbackPerTest(tb, server, "app")
+		t.Error("RollbackPerTest must skip the test")
+	})
+	assert.Equal(t, "can't roll back writes: transactions need a replica set running MongoDB 4.0 or later, and the server is a MongoDB 8.0.0 standalone server", tb.skipped)
+
+	tb = runStopped(t, func(tb testing.TB) {
+		RollbackPerTestWithOptions(tb, server, "app", RollbackOptions{Fallback: RollbackFail})
+		t.Error("RollbackPerTest must fail the test")
+	})
+	assert.Empty(t, tb.skipped)
+	assert.Contains(t, tb.fatal, "standalone server")
+
+	server = &Server{version: "3.6.0", isReplicaSet: true}
+	tb = runStopped(t, func(tb testing.TB) {
+		RollbackPerTest(tb, server, "app")
+	})
+	assert.Contains(t, tb.skipped, "the server is a MongoDB 3.6.0 replica set")
+}
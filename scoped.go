@@ -0,0 +1,136 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// ServerError is returned by WithServer and WithClient when the server they
+// manage fails, rather than the function they call
+type ServerError struct {
+	// Op is what failed: "start", "connect" or "stop"
+	Op string
+
+	Err error
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("failed to %s the server: %s", e.Op, e.Err)
+}
+
+func (e *ServerError) Unwrap() error {
+	return e.Err
+}
+
+// startServer and stopServer start and stop the servers of WithServer, and
+// stopServer those released with Release. They're variables so tests can
+// fake them.
+var (
+	startServer = StartWithOptions
+	stopServer  = func(s *Server) error {
+		return s.stop(nil)
+	}
+)
+
+// WithServer starts a server with opts, calls fn with it, and stops it once
+// fn returns, even if fn panics, in which case the panic carries on after the
+// server is stopped. It returns fn's error. If the server fails to start, or
+// fn succeeded but the server failed to stop, it returns a *ServerError
+// instead.
+func WithServer(ctx context.Context, opts *Options, fn func(ctx context.Context, s *Server) error) (err error) {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return &ServerError{Op: "start", Err: ctxErr}
+	}
+
+	s, startErr := startServer(opts)
+	if startErr != nil {
+		return &ServerError{Op: "start", Err: startErr}
+	}
+	defer func() {
+		stopErr := stopServer(s)
+		if err == nil && stopErr != nil {
+			err = &ServerError{Op: "stop", Err: stopErr}
+		}
+	}()
+
+	return fn(ctx, s)
+}
+
+// defaultServer is the server of the running RunWithServer
+var defaultServer struct {
+	mu     sync.Mutex
+	server *Server
+}
+
+// RunWithServer starts a server with opts to share across a package's tests,
+// runs them, and stops the server, even if they panic. It's meant to be
+// called from TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(memongo.RunWithServer(m, &memongo.Options{MongoVersion: "8.0.0"}, nil))
+//	}
+//
+// Tests get the server from Default. If fn is given, it's called with the
+// server instead of m.Run, for setup before the tests, and should return
+// m.Run()'s exit code.
+//
+// RunWithServer returns the tests' exit code. If the server fails to start,
+// or the tests passed but the server failed to stop, it prints the error to
+// stderr and returns 1.
+func RunWithServer(m *testing.M, opts *Options, fn func(*Server) int) (code int) {
+	s, err := startServer(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "memongo: error starting MongoDB: %s\n", err)
+		return 1
+	}
+	setDefault(s)
+	defer func() {
+		setDefault(nil)
+		err := stopServer(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "memongo: error stopping MongoDB: %s\n", err)
+			if code == 0 {
+				code = 1
+			}
+		}
+	}()
+
+	if fn == nil {
+		return m.Run()
+	}
+	return fn(s)
+}
+
+// Default returns the server started by RunWithServer, or nil outside of it
+func Default() *Server {
+	defaultServer.mu.Lock()
+	defer defaultServer.mu.Unlock()
+
+	return defaultServer.server
+}
+
+func setDefault(s *Server) {
+	defaultServer.mu.Lock()
+	defer defaultServer.mu.Unlock()
+
+	defaultServer.server = s
+}
+
+// WithClient is like WithServer, but calls fn with the client returned by
+// Server.Client. If the client can't connect, it returns a *ServerError with
+// Op "connect".
+func WithClient(ctx context.Context, opts *Options, fn func(ctx context.Context, client *mongo.Client) error) error {
+	return WithServer(ctx, opts, func(ctx context.Context, s *Server) error {
+		client, err := s.Client(ctx)
+		if err != nil {
+			return &ServerError{Op: "connect", Err: err}
+		}
+
+		return fn(ctx, client)
+	})
+}
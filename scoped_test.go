@@ -0,0 +1,143 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServers replaces startServer and stopServer, returning the number of
+// servers stopped so far
+func fakeServers(t *testing.T, startErr error, stopErr error) *int {
+	t.Helper()
+
+	stops := 0
+	origStart, origStop := startServer, stopServer
+	startServer = func(*Options) (*Server, error) {
+		if startErr != nil {
+			return nil, startErr
+		}
+		return &Server{}, nil
+	}
+	stopServer = func(*Server) error {
+		stops++
+		return stopErr
+	}
+	t.Cleanup(func() {
+		startServer, stopServer = origStart, origStop
+	})
+
+	return &stops
+}
+
+func TestWithServer(t *testing.T) {
+	stops := fakeServers(t, nil, nil)
+
+	called := false
+	err := WithServer(context.Background(), &Options{}, func(ctx context.Context, s *Server) error {
+		called = true
+		assert.NotNil(t, s)
+		assert.Equal(t, 0, *stops, "the server is stopped after fn returns")
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, called)
+	assert.Equal(t, 1, *stops)
+}
+
+func TestWithServerPanic(t *testing.T) {
+	stops := fakeServers(t, nil, nil)
+
+	assert.PanicsWithValue(t, "boom", func() {
+		_ = WithServer(context.Background(), &Options{}, func(context.Context, *Server) error {
+			panic("boom")
+		})
+	})
+	assert.Equal(t, 1, *stops)
+}
+
+func TestWithServerStopError(t *testing.T) {
+	stopErr := errors.New("error removing data directory")
+	stops := fakeServers(t, nil, stopErr)
+
+	err := WithServer(context.Background(), &Options{}, func(context.Context, *Server) error {
+		return nil
+	})
+	var serverErr *ServerError
+	require.ErrorAs(t, err, &serverErr)
+	assert.Equal(t, "stop", serverErr.Op)
+	assert.ErrorIs(t, err, stopErr)
+	assert.Equal(t, "failed to stop the server: error removing data directory", err.Error())
+	assert.Equal(t, 1, *stops)
+
+	// fn's error takes precedence
+	fnErr := errors.New("assertion failed")
+	err = WithServer(context.Background(), &Options{}, func(context.Context, *Server) error {
+		return fnErr
+	})
+	assert.Equal(t, fnErr, err)
+	assert.Equal(t, 2, *stops)
+}
+
+func TestWithServerStartError(t *testing.T) {
+	startErr := errors.New("no mongod")
+	stops := fakeServers(t, startErr, nil)
+
+	err := WithServer(context.Background(), &Options{}, func(context.Context, *Server) error {
+		t.Error("fn must not be called")
+		return nil
+	})
+	var serverErr *ServerError
+	require.ErrorAs(t, err, &serverErr)
+	assert.Equal(t, "start", serverErr.Op)
+	assert.ErrorIs(t, err, startErr)
+	assert.Equal(t, 0, *stops)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = WithServer(ctx, &Options{}, func(context.Context, *Server) error {
+		t.Error("fn must not be called")
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestRunWithServer(t *testing.T) {
+	stops := fakeServers(t, nil, nil)
+
+	var running *Server
+	code := RunWithServer(nil, &Options{}, func(s *Server) int {
+		running = Default()
+		assert.Equal(t, 0, *stops, "the server is stopped after the tests")
+		return 3
+	})
+	assert.Equal(t, 3, code)
+	assert.NotNil(t, running)
+	assert.Nil(t, Default())
+	assert.Equal(t, 1, *stops)
+
+	assert.PanicsWithValue(t, "boom", func() {
+		RunWithServer(nil, &Options{}, func(*Server) int {
+			panic("boom")
+		})
+	})
+	assert.Nil(t, Default())
+	assert.Equal(t, 2, *stops)
+}
+
+func TestRunWithServerErrors(t *testing.T) {
+	fakeServers(t, errors.New("no mongod"), nil)
+	code := RunWithServer(nil, &Options{}, func(*Server) int {
+		t.Error("the tests must not be run")
+		return 0
+	})
+	assert.Equal(t, 1, code)
+
+	stops := fakeServers(t, nil, errors.New("error removing data directory"))
+	assert.Equal(t, 1, RunWithServer(nil, &Options{}, func(*Server) int { return 0 }))
+	assert.Equal(t, 2, RunWithServer(nil, &Options{}, func(*Server) int { return 2 }))
+	assert.Equal(t, 2, *stops)
+}
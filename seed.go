@@ -0,0 +1,467 @@
+package memongo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// SeedCollection is a collection to create and fill with documents when the
+// server starts (see Options.Seed) or with Server.Seed.
+type SeedCollection struct {
+	// Database and Collection name the collection
+	Database   string
+	Collection string
+
+	// Documents are inserted in order. They can be anything the driver can
+	// marshal, e.g. bson.D or structs.
+	Documents []interface{}
+
+	// Options are used to create the collection before any documents are
+	// inserted. Without them, the collection is created by the first insert.
+	Options *SeedCollectionOptions
+
+	// Indexes are created before the documents are inserted, or after with
+	// SeedBulkOptions.DeferIndexes
+	Indexes []mongo.IndexModel
+}
+
+// SeedCollectionOptions are the options a seeded collection is created with.
+// Any combination the server accepts may be given.
+type SeedCollectionOptions struct {
+	// TimeSeries makes a time series collection. It requires MongoDB 5.0.
+	TimeSeries *SeedTimeSeries `bson:"timeseries"`
+
+	// Capped makes a capped collection
+	Capped *SeedCapped `bson:"capped"`
+
+	// Validator is the collection's validator, e.g.
+	// bson.M{"$jsonSchema": ...}
+	Validator interface{} `bson:"validator"`
+
+	// Collation is the collection's default collation
+	Collation *options.Collation `bson:"-"`
+}
+
+// SeedTimeSeries are the options of a time series collection
+type SeedTimeSeries struct {
+	// TimeField is the name of the field holding each document's date. It's
+	// required.
+	TimeField string `bson:"timeField"`
+
+	// MetaField is the name of the field holding each document's metadata
+	MetaField string `bson:"metaField"`
+
+	// Granularity is "seconds", "minutes" or "hours"
+	Granularity string `bson:"granularity"`
+}
+
+// SeedCapped are the options of a capped collection
+type SeedCapped struct {
+	// Size is the maximum size of the collection in bytes. It's required.
+	Size int64 `bson:"size"`
+
+	// Max is the maximum number of documents, or 0 for no limit
+	Max int64 `bson:"max"`
+}
+
+// Seed creates and fills the given collections, in order. Collections with
+// Options are created with them first, so they fail if the collection already
+// exists.
+//
+// If ctx is cancelled or expires, the collections Seed created are dropped
+// again. Documents already inserted into collections that existed before are
+// left in place.
+func (s *Server) Seed(ctx context.Context, collections []SeedCollection) error {
+	_, err := s.seed(ctx, collections, nil)
+	return err
+}
+
+// SeedBulk is like Seed, but inserts the documents of each collection in
+// batches from parallel workers, as configured by bulk. It's much faster for
+// large seeds.
+func (s *Server) SeedBulk(ctx context.Context, collections []SeedCollection, bulk SeedBulkOptions) error {
+	_, err := s.seed(ctx, collections, &bulk)
+	return err
+}
+
+// seed seeds collections, with bulk inserts if bulk is given, and returns
+// how many documents were inserted
+func (s *Server) seed(ctx context.Context, collections []SeedCollection, bulk *SeedBulkOptions) (int, error) {
+	if bulk != nil {
+		err := bulk.validate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	client, err := s.connect()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	var created []*mongo.Collection
+	inserted, err := s.seedCollections(ctx, client, collections, bulk, &created)
+	if err != nil && ctx.Err() != nil {
+		s.dropSeededCollections(created)
+	}
+
+	return inserted, err
+}
+
+// seedCollections seeds collections with client, adding the ones that
+// didn't exist yet to created
+func (s *Server) seedCollections(ctx context.Context, client *mongo.Client, collections []SeedCollection, bulk *SeedBulkOptions, created *[]*mongo.Collection) (int, error) {
+	inserted := 0
+	for _, seed := range collections {
+		if seed.Database == "" || seed.Collection == "" {
+			return inserted, fmt.Errorf("seed collections must have a Database and a Collection, got %q.%q", seed.Database, seed.Collection)
+		}
+
+		coll := client.Database(seed.Database).Collection(seed.Collection)
+		existing, err := coll.Database().ListCollectionNames(ctx, bson.D{{Key: "name", Value: seed.Collection}})
+		if err != nil {
+			return inserted, fmt.Errorf("error seeding collection %s.%s: %w", seed.Database, seed.Collection, err)
+		}
+		if len(existing) == 0 {
+			*created = append(*created, coll)
+		}
+
+		if seed.Options != nil {
+			err := s.createSeedCollection(ctx, client, seed)
+			if err != nil {
+				return inserted, err
+			}
+		}
+
+		deferIndexes := bulk != nil && bulk.DeferIndexes
+		if !deferIndexes {
+			err := createSeedIndexes(ctx, client, seed)
+			if err != nil {
+				return inserted, err
+			}
+		}
+
+		if bulk != nil {
+			err = bulkInsert(ctx, coll, seed.Documents, *bulk)
+		} else if len(seed.Documents) > 0 {
+			_, err = coll.InsertMany(ctx, seed.Documents)
+		}
+		if err != nil {
+			return inserted, fmt.Errorf("error seeding collection %s.%s: %w", seed.Database, seed.Collection, err)
+		}
+		inserted += len(seed.Documents)
+
+		if deferIndexes {
+			err := createSeedIndexes(ctx, client, seed)
+			if err != nil {
+				return inserted, err
+			}
+		}
+
+		s.logger.Debugf("Seeded %d documents into %s.%s", len(seed.Documents), seed.Database, seed.Collection)
+	}
+
+	return inserted, nil
+}
+
+// dropSeededCollections drops the collections a cancelled seed created, so
+// it doesn't leave them half filled
+func (s *Server) dropSeededCollections(created []*mongo.Collection) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, coll := range created {
+		err := coll.Drop(ctx)
+		if err != nil {
+			s.logger.Warnf("error dropping partially seeded collection %s.%s: %s", coll.Database().Name(), coll.Name(), err)
+		}
+	}
+}
+
+// SeedDir seeds the server from a directory of JSON files (see LoadSeedDir)
+func (s *Server) SeedDir(ctx context.Context, dir string) error {
+	collections, err := LoadSeedDir(dir)
+	if err != nil {
+		return err
+	}
+
+	return s.Seed(ctx, collections)
+}
+
+// seedFromOptions seeds the server from Options.Seed, Options.SeedDir and
+// Options.SeedGenerated, and records how fast it went in the StartReport
+func (s *Server) seedFromOptions(ctx context.Context, opts *Options) error {
+	start := time.Now()
+
+	collections := opts.Seed
+	if opts.SeedDir != "" {
+		fromDir, err := LoadSeedDir(opts.SeedDir)
+		if err != nil {
+			return err
+		}
+		collections = append(append([]SeedCollection(nil), collections...), fromDir...)
+	}
+
+	inserted, err := s.seed(ctx, collections, opts.SeedBulkOptions)
+	if err != nil {
+		return err
+	}
+
+	if len(opts.SeedGenerated) > 0 {
+		generated, err := s.seedGenerated(ctx, opts.GeneratorSeed, opts.SeedGenerated, opts.SeedBulkOptions)
+		inserted += generated
+		if err != nil {
+			return err
+		}
+	}
+
+	elapsed := time.Since(start)
+	rate := float64(inserted) / elapsed.Seconds()
+	s.logger.Infof("Seeded %d documents in %s (%.0f documents/s)", inserted, elapsed.Round(time.Millisecond), rate)
+
+	s.mu.Lock()
+	s.startReport.SeedDocuments = inserted
+	s.startReport.SeedDuration = elapsed
+	s.startReport.SeedDocumentsPerSecond = rate
+	s.mu.Unlock()
+
+	return nil
+}
+
+func createSeedIndexes(ctx context.Context, client *mongo.Client, seed SeedCollection) error {
+	if len(seed.Indexes) == 0 {
+		return nil
+	}
+
+	_, err := client.Database(seed.Database).Collection(seed.Collection).Indexes().CreateMany(ctx, seed.Indexes)
+	if err != nil {
+		return fmt.Errorf("error creating indexes on %s.%s: %w", seed.Database, seed.Collection, err)
+	}
+
+	return nil
+}
+
+func (s *Server) createSeedCollection(ctx context.Context, client *mongo.Client, seed SeedCollection) error {
+	seedOpts := seed.Options
+	createOpts := options.CreateCollection()
+	var given []string
+
+	if seedOpts.TimeSeries != nil {
+		caps, err := s.Capabilities(ctx)
+		if err != nil {
+			return err
+		}
+		if !caps.TimeSeries {
+			return fmt.Errorf("error creating collection %s.%s: timeseries requires MongoDB 5.0 or later, but the server is running %s", seed.Database, seed.Collection, caps.Version)
+		}
+
+		timeSeries := options.TimeSeries().SetTimeField(seedOpts.TimeSeries.TimeField)
+		if seedOpts.TimeSeries.MetaField != "" {
+			timeSeries.SetMetaField(seedOpts.TimeSeries.MetaField)
+		}
+		if seedOpts.TimeSeries.Granularity != "" {
+			timeSeries.SetGranularity(seedOpts.TimeSeries.Granularity)
+		}
+		createOpts.SetTimeSeriesOptions(timeSeries)
+		given = append(given, "timeseries")
+	}
+
+	if seedOpts.Capped != nil {
+		if seedOpts.Capped.Size <= 0 {
+			return fmt.Errorf("error creating collection %s.%s: capped.size must be positive", seed.Database, seed.Collection)
+		}
+
+		createOpts.SetCapped(true).SetSizeInBytes(seedOpts.Capped.Size)
+		if seedOpts.Capped.Max > 0 {
+			createOpts.SetMaxDocuments(seedOpts.Capped.Max)
+		}
+		given = append(given, "capped")
+	}
+
+	if seedOpts.Validator != nil {
+		createOpts.SetValidator(seedOpts.Validator)
+		given = append(given, "validator")
+	}
+
+	if seedOpts.Collation != nil {
+		createOpts.SetCollation(seedOpts.Collation)
+		given = append(given, "collation")
+	}
+
+	err := client.Database(seed.Database).CreateCollection(ctx, seed.Collection, createOpts)
+	if err != nil {
+		return fmt.Errorf("error creating collection %s.%s with options %s: %w", seed.Database, seed.Collection, strings.Join(given, ", "), err)
+	}
+
+	return nil
+}
+
+// seedOptionFields are the fields allowed in a .options.json file
+var seedOptionFields = map[string]bool{
+	"timeseries": true,
+	"capped":     true,
+	"validator":  true,
+	"collation":  true,
+}
+
+// seedCollation mirrors options.Collation with the field names used in
+// MongoDB commands
+type seedCollation struct {
+	Locale          string `bson:"locale"`
+	CaseLevel       bool   `bson:"caseLevel"`
+	CaseFirst       string `bson:"caseFirst"`
+	Strength        int    `bson:"strength"`
+	NumericOrdering bool   `bson:"numericOrdering"`
+	Alternate       string `bson:"alternate"`
+	MaxVariable     string `bson:"maxVariable"`
+	Normalization   bool   `bson:"normalization"`
+	Backwards       bool   `bson:"backwards"`
+}
+
+// LoadSeedDir reads seed collections from a directory laid out as
+// <dir>/<database>/<collection>.json. Each file holds a JSON array of
+// documents in MongoDB Extended JSON. An optional
+// <dir>/<database>/<collection>.options.json holds the collection's creation
+// options, with the fields timeseries, capped, validator and collation as
+// they're written in MongoDB's create command, e.g.
+//
+//	{"timeseries": {"timeField": "ts", "granularity": "hours"}}
+func LoadSeedDir(dir string) ([]SeedCollection, error) {
+	dbEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading seed directory: %w", err)
+	}
+
+	var collections []SeedCollection
+	for _, dbEntry := range dbEntries {
+		if !dbEntry.IsDir() {
+			continue
+		}
+
+		database := dbEntry.Name()
+		dbDir := filepath.Join(dir, database)
+		entries, err := os.ReadDir(dbDir)
+		if err != nil {
+			return nil, fmt.Errorf("error reading seed directory: %w", err)
+		}
+
+		// Collect the collection names from both document and options files
+		names := map[string]bool{}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+				continue
+			}
+			names[strings.TrimSuffix(strings.TrimSuffix(name, ".json"), ".options")] = true
+		}
+
+		sorted := make([]string, 0, len(names))
+		for name := range names {
+			sorted = append(sorted, name)
+		}
+		sort.Strings(sorted)
+
+		for _, collection := range sorted {
+			seed, err := loadSeedCollection(dbDir, database, collection)
+			if err != nil {
+				return nil, err
+			}
+			collections = append(collections, seed)
+		}
+	}
+
+	return collections, nil
+}
+
+func loadSeedCollection(dbDir string, database string, collection string) (SeedCollection, error) {
+	seed := SeedCollection{Database: database, Collection: collection}
+
+	docsPath := filepath.Join(dbDir, collection+".json")
+	//nolint:gosec
+	contents, err := os.ReadFile(docsPath)
+	if err == nil {
+		seed.Documents, err = parseSeedDocuments(contents)
+		if err != nil {
+			return SeedCollection{}, fmt.Errorf("error parsing %s: %w", docsPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return SeedCollection{}, fmt.Errorf("error reading seed file: %w", err)
+	}
+
+	optsPath := filepath.Join(dbDir, collection+".options.json")
+	//nolint:gosec
+	contents, err = os.ReadFile(optsPath)
+	if err == nil {
+		seed.Options, err = parseSeedOptions(contents)
+		if err != nil {
+			return SeedCollection{}, fmt.Errorf("error parsing %s: %w", optsPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return SeedCollection{}, fmt.Errorf("error reading seed file: %w", err)
+	}
+
+	return seed, nil
+}
+
+func parseSeedDocuments(contents []byte) ([]interface{}, error) {
+	var raw []json.RawMessage
+	err := json.Unmarshal(contents, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("seed files must hold a JSON array of documents: %w", err)
+	}
+
+	docs := make([]interface{}, len(raw))
+	for i, r := range raw {
+		var doc bson.D
+		err := bson.UnmarshalExtJSON(r, false, &doc)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		docs[i] = doc
+	}
+
+	return docs, nil
+}
+
+func parseSeedOptions(contents []byte) (*SeedCollectionOptions, error) {
+	var fields bson.M
+	err := bson.UnmarshalExtJSON(contents, false, &fields)
+	if err != nil {
+		return nil, err
+	}
+	for field := range fields {
+		if !seedOptionFields[field] {
+			return nil, fmt.Errorf("unknown collection option %q", field)
+		}
+	}
+
+	var file struct {
+		SeedCollectionOptions `bson:",inline"`
+		Collation             *seedCollation `bson:"collation"`
+	}
+	err = bson.UnmarshalExtJSON(contents, false, &file)
+	if err != nil {
+		return nil, err
+	}
+
+	seedOpts := file.SeedCollectionOptions
+	if file.Collation != nil {
+		c := options.Collation(*file.Collation)
+		seedOpts.Collation = &c
+	}
+
+	return &seedOpts, nil
+}
@@ -0,0 +1,51 @@
+package memongo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestLoadSeedDir(t *testing.T) {
+	collections, err := LoadSeedDir("testdata/seed")
+	require.NoError(t, err)
+	require.Len(t, collections, 3)
+
+	events := collections[0]
+	assert.Equal(t, "app", events.Database)
+	assert.Equal(t, "events", events.Collection)
+	assert.Empty(t, events.Documents)
+	require.NotNil(t, events.Options)
+	assert.Equal(t, &SeedCapped{Size: 65536, Max: 100}, events.Options.Capped)
+
+	metrics := collections[1]
+	assert.Equal(t, "metrics", metrics.Collection)
+	assert.Len(t, metrics.Documents, 2)
+	assert.Equal(t, &SeedTimeSeries{TimeField: "ts", MetaField: "meta", Granularity: "hours"}, metrics.Options.TimeSeries)
+
+	users := collections[2]
+	assert.Equal(t, "users", users.Collection)
+	require.Len(t, users.Documents, 2)
+	assert.Equal(t, bson.E{Key: "name", Value: "Ada"}, users.Documents[0].(bson.D)[1])
+	require.NotNil(t, users.Options.Collation)
+	assert.Equal(t, "en", users.Options.Collation.Locale)
+	assert.Equal(t, 2, users.Options.Collation.Strength)
+	assert.NotNil(t, users.Options.Validator)
+	assert.Nil(t, users.Options.TimeSeries)
+}
+
+func TestLoadSeedDirErrors(t *testing.T) {
+	_, err := LoadSeedDir("testdata/seed-bad")
+	assert.EqualError(t, err, `error parsing testdata/seed-bad/app/events.options.json: unknown collection option "cappd"`)
+
+	_, err = LoadSeedDir("testdata/nonexistent")
+	assert.Error(t, err)
+
+	_, err = parseSeedDocuments([]byte(`{"not": "an array"}`))
+	assert.Error(t, err)
+
+	_, err = parseSeedDocuments([]byte(`[{"_id": {"$oid": "nope"}}]`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,121 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// defaultSeedBatchSize is the default SeedBulkOptions.BatchSize
+const defaultSeedBatchSize = 1000
+
+// SeedBulkOptions configures bulk seeding, with Server.SeedBulk or
+// Options.SeedBulkOptions
+type SeedBulkOptions struct {
+	// Unordered inserts the documents of each batch in any order, which lets
+	// the server insert them faster. An unordered batch continues past a
+	// failed document.
+	Unordered bool
+
+	// BatchSize is how many documents are inserted at a time. Defaults to
+	// 1000.
+	BatchSize int
+
+	// Workers is how many batches are inserted at once. Defaults to
+	// GOMAXPROCS. With more than one worker, documents are inserted out of
+	// order even if Unordered isn't set.
+	Workers int
+
+	// DeferIndexes creates the seed collections' Indexes after their
+	// documents are inserted, which is faster than keeping the indexes up to
+	// date while inserting
+	DeferIndexes bool
+}
+
+func (o SeedBulkOptions) validate() error {
+	if o.BatchSize < 0 {
+		return fmt.Errorf("invalid SeedBulkOptions.BatchSize %d: must not be negative", o.BatchSize)
+	}
+	if o.Workers < 0 {
+		return fmt.Errorf("invalid SeedBulkOptions.Workers %d: must not be negative", o.Workers)
+	}
+
+	return nil
+}
+
+func (o SeedBulkOptions) withDefaults() SeedBulkOptions {
+	if o.BatchSize == 0 {
+		o.BatchSize = defaultSeedBatchSize
+	}
+	if o.Workers == 0 {
+		o.Workers = runtime.GOMAXPROCS(0)
+	}
+
+	return o
+}
+
+// seedBatches splits docs into batches of up to size documents
+func seedBatches(docs []interface{}, size int) [][]interface{} {
+	batches := make([][]interface{}, 0, (len(docs)+size-1)/size)
+	for start := 0; start < len(docs); start += size {
+		end := start + size
+		if end > len(docs) {
+			end = len(docs)
+		}
+		batches = append(batches, docs[start:end])
+	}
+
+	return batches
+}
+
+// bulkInsert inserts docs into coll in batches from parallel workers. The
+// first error stops the other workers.
+func bulkInsert(ctx context.Context, coll *mongo.Collection, docs []interface{}, bulk SeedBulkOptions) error {
+	bulk = bulk.withDefaults()
+	batches := seedBatches(docs, bulk.BatchSize)
+	insertOpts := options.InsertMany().SetOrdered(!bulk.Unordered)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	work := make(chan []interface{})
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	for i := 0; i < bulk.Workers && i < len(batches); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range work {
+				_, err := coll.InsertMany(ctx, batch, insertOpts)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+send:
+	for _, batch := range batches {
+		select {
+		case work <- batch:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return ctx.Err()
+}
@@ -0,0 +1,41 @@
+package memongo
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeedBatches(t *testing.T) {
+	docs := make([]interface{}, 2500)
+	for i := range docs {
+		docs[i] = i
+	}
+
+	batches := seedBatches(docs, 1000)
+	assert.Len(t, batches, 3)
+	assert.Len(t, batches[0], 1000)
+	assert.Len(t, batches[2], 500)
+	assert.Equal(t, 1000, batches[1][0])
+	assert.Equal(t, 2499, batches[2][499])
+
+	assert.Empty(t, seedBatches(nil, 1000))
+	assert.Len(t, seedBatches(docs[:1000], 1000), 1)
+}
+
+func TestSeedBulkOptionsDefaults(t *testing.T) {
+	bulk := SeedBulkOptions{}.withDefaults()
+	assert.Equal(t, defaultSeedBatchSize, bulk.BatchSize)
+	assert.Equal(t, runtime.GOMAXPROCS(0), bulk.Workers)
+
+	bulk = SeedBulkOptions{BatchSize: 10, Workers: 2}.withDefaults()
+	assert.Equal(t, 10, bulk.BatchSize)
+	assert.Equal(t, 2, bulk.Workers)
+}
+
+func TestValidateSeedBulkOptions(t *testing.T) {
+	assert.Error(t, (&Options{MongodBin: "/bin/mongod", SeedBulkOptions: &SeedBulkOptions{BatchSize: -1}}).Validate())
+	assert.Error(t, (&Options{MongodBin: "/bin/mongod", SeedBulkOptions: &SeedBulkOptions{Workers: -1}}).Validate())
+	assert.NoError(t, (&Options{MongodBin: "/bin/mongod", SeedBulkOptions: &SeedBulkOptions{Unordered: true, Workers: 4}}).Validate())
+}
@@ -0,0 +1,155 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+
+	"github.com/100mslive/memongo/v2/datagen"
+)
+
+// generatedChunkSize is how many generated documents are held in memory and
+// inserted at a time
+const generatedChunkSize = 100000
+
+// GeneratedCollection is a collection to create and fill with documents
+// generated by the datagen package (see Options.SeedGenerated)
+type GeneratedCollection struct {
+	// Database and Collection name the collection
+	Database   string
+	Collection string
+
+	// Count is how many documents to generate
+	Count int
+
+	// Schema describes the documents. A datagen.Ref names collections as
+	// "database.collection", and must refer to a collection generated
+	// earlier in the same list.
+	Schema datagen.Schema
+
+	// Indexes are created before the documents are inserted, or after with
+	// SeedBulkOptions.DeferIndexes
+	Indexes []mongo.IndexModel
+}
+
+// generatorName is the name the generator knows the collection by, and
+// datagen.Ref refers to it by
+func (c GeneratedCollection) generatorName() string {
+	return c.Database + "." + c.Collection
+}
+
+// validateGeneratedCollections checks collections before anything is
+// generated, so a bad schema doesn't leave a half-seeded server
+func validateGeneratedCollections(collections []GeneratedCollection) error {
+	generated := map[string]bool{}
+	for _, c := range collections {
+		if c.Database == "" || c.Collection == "" {
+			return fmt.Errorf("generated collections must have a Database and a Collection, got %q.%q", c.Database, c.Collection)
+		}
+		if c.Count < 0 {
+			return fmt.Errorf("invalid Count %d for generated collection %s: must not be negative", c.Count, c.generatorName())
+		}
+
+		err := c.Schema.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid schema for generated collection %s: %w", c.generatorName(), err)
+		}
+		for _, field := range c.Schema {
+			ref, ok := field.Spec.(datagen.Ref)
+			if ok && !generated[ref.Collection] {
+				return fmt.Errorf("invalid schema for generated collection %s: field %s refers to %s, which isn't generated before it", c.generatorName(), field.Name, ref.Collection)
+			}
+		}
+
+		generated[c.generatorName()] = true
+	}
+
+	return nil
+}
+
+// SeedGenerated creates the given collections and fills them with documents
+// generated from seed, in order, inserting them in bulk. The same seed and
+// collections always give the same documents, so a failing test can be
+// reproduced from its seed. Like Seed, it drops the collections it created
+// if ctx is cancelled or expires.
+func (s *Server) SeedGenerated(ctx context.Context, seed int64, collections []GeneratedCollection) error {
+	_, err := s.seedGenerated(ctx, seed, collections, nil)
+	return err
+}
+
+// seedGenerated seeds collections with documents generated from seed, with
+// bulk or the default SeedBulkOptions, and returns how many documents were
+// inserted
+func (s *Server) seedGenerated(ctx context.Context, seed int64, collections []GeneratedCollection, bulk *SeedBulkOptions) (int, error) {
+	err := validateGeneratedCollections(collections)
+	if err != nil {
+		return 0, err
+	}
+	if bulk == nil {
+		bulk = &SeedBulkOptions{}
+	}
+	err = bulk.validate()
+	if err != nil {
+		return 0, err
+	}
+
+	client, err := s.connect()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	var created []*mongo.Collection
+	inserted, err := s.seedGeneratedCollections(ctx, client, datagen.New(seed), collections, bulk, &created)
+	if err != nil && ctx.Err() != nil {
+		s.dropSeededCollections(created)
+	}
+
+	return inserted, err
+}
+
+// seedGeneratedCollections generates each collection's documents in chunks,
+// inserting each chunk before generating the next, so large collections
+// needn't fit in memory
+func (s *Server) seedGeneratedCollections(ctx context.Context, client *mongo.Client, gen *datagen.Generator, collections []GeneratedCollection, bulk *SeedBulkOptions, created *[]*mongo.Collection) (int, error) {
+	inserted := 0
+	for _, c := range collections {
+		chunk := SeedCollection{Database: c.Database, Collection: c.Collection}
+		first := true
+		flush := func(last bool) error {
+			// The indexes are created with the first chunk, or with the last
+			// with DeferIndexes
+			chunk.Indexes = nil
+			if (first && !bulk.DeferIndexes) || (last && bulk.DeferIndexes) {
+				chunk.Indexes = c.Indexes
+			}
+			first = false
+
+			n, err := s.seedCollections(ctx, client, []SeedCollection{chunk}, bulk, created)
+			inserted += n
+			chunk.Documents = chunk.Documents[:0]
+			return err
+		}
+
+		err := gen.Generate(c.generatorName(), c.Schema, c.Count, func(doc bson.D) error {
+			chunk.Documents = append(chunk.Documents, doc)
+			if len(chunk.Documents) < generatedChunkSize {
+				return nil
+			}
+			return flush(false)
+		})
+		if err != nil {
+			return inserted, err
+		}
+		err = flush(true)
+		if err != nil {
+			return inserted, err
+		}
+	}
+
+	return inserted, nil
+}
@@ -0,0 +1,46 @@
+package memongo
+
+import (
+	"testing"
+
+	"github.com/100mslive/memongo/v2/datagen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateGeneratedCollections(t *testing.T) {
+	users := GeneratedCollection{
+		Database:   "app",
+		Collection: "users",
+		Count:      10,
+		Schema:     datagen.Schema{{Name: "_id", Spec: datagen.ObjectID{}}},
+	}
+	orders := GeneratedCollection{
+		Database:   "app",
+		Collection: "orders",
+		Count:      10,
+		Schema:     datagen.Schema{{Name: "user", Spec: datagen.Ref{Collection: "app.users"}}},
+	}
+
+	assert.NoError(t, validateGeneratedCollections(nil))
+	assert.NoError(t, validateGeneratedCollections([]GeneratedCollection{users, orders}))
+
+	assert.EqualError(t, validateGeneratedCollections([]GeneratedCollection{orders, users}),
+		"invalid schema for generated collection app.orders: field user refers to app.users, which isn't generated before it")
+	assert.EqualError(t, validateGeneratedCollections([]GeneratedCollection{{Collection: "users"}}),
+		`generated collections must have a Database and a Collection, got ""."users"`)
+
+	negative := users
+	negative.Count = -1
+	assert.EqualError(t, validateGeneratedCollections([]GeneratedCollection{negative}),
+		"invalid Count -1 for generated collection app.users: must not be negative")
+
+	invalid := users
+	invalid.Schema = datagen.Schema{{Name: "n", Spec: datagen.IntRange{Min: 1, Max: 0}}}
+	assert.EqualError(t, validateGeneratedCollections([]GeneratedCollection{invalid}),
+		"invalid schema for generated collection app.users: invalid spec for schema field n: invalid IntRange: Max 0 is less than Min 1")
+
+	// Options are validated too
+	assert.Error(t, (&Options{MongodBin: "/bin/mongod", SeedGenerated: []GeneratedCollection{orders}}).Validate())
+	assert.NoError(t, (&Options{MongodBin: "/bin/mongod", SeedGenerated: []GeneratedCollection{users, orders}}).Validate())
+}
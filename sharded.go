@@ -0,0 +1,616 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/100mslive/memongo/v2/mongobin"
+	"github.com/100mslive/memongo/v2/retry"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// maxShards is the most shards Options.NumShards may ask for
+const maxShards = 16
+
+// maxMongos is the most routers Options.MongosCount may ask for
+const maxMongos = 8
+
+// configServerName is the replica set name of a sharded cluster's config
+// server
+const configServerName = "cfg"
+
+// The cluster roles of the mongods in a sharded cluster, named after their
+// command line flags
+const (
+	roleConfigServer = "configsvr"
+	roleShard        = "shardsvr"
+)
+
+// retryableAddShardCodes are the error codes addShard can fail with while
+// the config server or a shard is still electing a primary
+var retryableAddShardCodes = []int{
+	6,     // HostUnreachable
+	133,   // FailedToSatisfyReadPreference
+	202,   // NetworkInterfaceExceededTimeLimit
+	11602, // InterruptedDueToReplStateChange
+}
+
+// shardedCluster is the config server and shards behind the mongos of a
+// server started with Options.Sharded. Each of them is a single-member
+// replica set.
+type shardedCluster struct {
+	configServer *Server
+	shards       []*Server
+
+	// mu guards routers
+	mu sync.Mutex
+
+	// routers are the mongos started for Options.MongosCount other than the
+	// server itself, which is mongos 0, so routers[0] is mongos 1. Routers
+	// stopped with StopMongos stay, to be started again on the same port.
+	routers []*mongodProcess
+}
+
+// shardCount returns the number of shards opts start
+func (opts *Options) shardCount() int {
+	if opts.NumShards == 0 {
+		return 1
+	}
+
+	return opts.NumShards
+}
+
+// mongosCount returns the number of mongos opts start
+func (opts *Options) mongosCount() int {
+	if opts.MongosCount == 0 {
+		return 1
+	}
+
+	return opts.MongosCount
+}
+
+// shardName returns the replica set name of shard index
+func shardName(index int) string {
+	return "shard" + strconv.Itoa(index)
+}
+
+func (opts *Options) validateSharded() error {
+	if opts.NumShards != 0 && !opts.Sharded {
+		return fmt.Errorf("cannot use NumShards without Sharded")
+	}
+
+	if opts.NumShards < 0 || opts.NumShards > maxShards {
+		return fmt.Errorf("invalid NumShards %d: must be within 1-%d", opts.NumShards, maxShards)
+	}
+
+	if opts.MongosCount != 0 && !opts.Sharded {
+		return fmt.Errorf("cannot use MongosCount without Sharded")
+	}
+
+	if opts.MongosCount < 0 || opts.MongosCount > maxMongos {
+		return fmt.Errorf("invalid MongosCount %d: must be within 1-%d", opts.MongosCount, maxMongos)
+	}
+
+	if opts.EnableBalancer && !opts.Sharded {
+		return fmt.Errorf("cannot use EnableBalancer without Sharded")
+	}
+
+	if opts.AllowDirectShardAccess && !opts.Sharded {
+		return fmt.Errorf("cannot use AllowDirectShardAccess without Sharded")
+	}
+
+	if !opts.Sharded {
+		return nil
+	}
+
+	// The config server and each shard are replica sets of their own
+	if opts.ShouldUseReplica {
+		return fmt.Errorf("cannot use Sharded with ShouldUseReplica")
+	}
+
+	// mongos and the shards would need a keyfile in common to authenticate
+	// to each other
+	if opts.Auth {
+		return fmt.Errorf("cannot use Sharded with Auth")
+	}
+
+	if opts.ReadOnly {
+		return fmt.Errorf("cannot use Sharded with ReadOnly")
+	}
+
+	// compact can't be run through mongos
+	if opts.CompactOnInterval > 0 {
+		return fmt.Errorf("cannot use Sharded with CompactOnInterval")
+	}
+
+	return nil
+}
+
+// clusterMemberOptions returns the options the config server or a shard of
+// the sharded cluster opts start is started with: a single-member replica
+// set called name, with the given cluster role, on a port of its own. The
+// mongos takes Port and everything clients use.
+func (opts *Options) clusterMemberOptions(name string, role string) (*Options, error) {
+	member := opts.clone()
+	member.Sharded, member.NumShards, member.MongosCount, member.EnableBalancer = false, 0, 0, false
+	member.AllowDirectShardAccess = false
+	member.ShouldUseReplica, member.ReplicaSetName, member.clusterRole = true, name, role
+	member.Port, member.portAllocated, member.portLease, member.PortReservation = 0, false, nil, nil
+	member.CaptureCommands = false
+	member.ignored = nil
+
+	// A port from PortReservationProvider is leased by startWithRetries.
+	// Otherwise, one is picked here, so fillDefaults doesn't give every
+	// member MEMONGO_MONGOD_PORT.
+	if member.PortReservationProvider == nil {
+		port, err := allocatePort(member.PortRange)
+		if err != nil {
+			return nil, fmt.Errorf("error finding a free port: %w", err)
+		}
+		member.Port, member.portAllocated = port, true
+	}
+
+	return member, nil
+}
+
+// startSharded starts the sharded cluster opts describe: the config server
+// and the shards, all at once, then a mongos on opts.Port, which the
+// returned server is
+func startSharded(ctx context.Context, opts *Options, logger *memongolog.Logger, health *healthServer, events *eventBus) (*Server, error) {
+	startTime := time.Now()
+
+	if opts.Port == 0 && opts.PortReservationProvider != nil {
+		err := opts.leasePort()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cluster, err := startClusterMembers(ctx, opts, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := cluster.startMongos(ctx, opts, logger, health, events)
+	if err != nil {
+		cluster.stop(context.Background(), false)
+		return nil, err
+	}
+
+	err = server.startRouters(ctx)
+	if err != nil {
+		server.Stop()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.ReplicaSetReadyTimeout)
+	err = server.addShards(ctx)
+	if err == nil && !opts.EnableBalancer {
+		err = server.DisableBalancer(ctx)
+	}
+	if err == nil {
+		err = server.waitForRouters(ctx)
+	}
+	cancel()
+	if err != nil {
+		server.Stop()
+		return nil, err
+	}
+
+	server.startReport.Attempts = 1
+	server.startReport.Duration = time.Since(startTime)
+	return server, nil
+}
+
+// startClusterMembers starts the config server and the shards of the sharded
+// cluster opts describe, in parallel. If any of them fails to start, the
+// others are stopped.
+func startClusterMembers(ctx context.Context, opts *Options, logger *memongolog.Logger) (*shardedCluster, error) {
+	names := []string{configServerName}
+	for index := 0; index < opts.shardCount(); index++ {
+		names = append(names, shardName(index))
+	}
+
+	servers := make([]*Server, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		role := roleShard
+		if i == 0 {
+			role = roleConfigServer
+		}
+
+		wg.Add(1)
+		go func(i int, name string, role string) {
+			defer wg.Done()
+			servers[i], errs[i] = startClusterMember(ctx, opts, name, role, logger)
+		}(i, name, role)
+	}
+	wg.Wait()
+
+	cluster := &shardedCluster{configServer: servers[0], shards: servers[1:]}
+	for i, err := range errs {
+		if err != nil {
+			cluster.stop(context.Background(), false)
+			return nil, fmt.Errorf("error starting %s: %w", clusterMemberDescription(names[i]), err)
+		}
+	}
+
+	return cluster, nil
+}
+
+// clusterMemberDescription describes the cluster member called name in
+// errors
+func clusterMemberDescription(name string) string {
+	if name == configServerName {
+		return "the config server"
+	}
+
+	return "shard " + name
+}
+
+// startClusterMember starts the config server or a shard
+func startClusterMember(ctx context.Context, opts *Options, name string, role string, logger *memongolog.Logger) (*Server, error) {
+	member, err := opts.clusterMemberOptions(name, role)
+	if err != nil {
+		return nil, err
+	}
+	defer member.releasePort()
+
+	err = member.fillDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	return startWithRetries(ctx, member, logger, nil, newEventBus(nil))
+}
+
+// startMongos starts a mongos routing to the cluster on opts.Port, and
+// returns it as the server
+func (c *shardedCluster) startMongos(ctx context.Context, opts *Options, logger *memongolog.Logger, health *healthServer, events *eventBus) (*Server, error) {
+	cfg := c.configServer
+	mongosPath, err := cfg.opts.getOrDownloadMongosPath(ctx, cfg.binPath, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("Using mongos %s", mongosPath)
+
+	env, err := opts.mongodEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	// mongos has no data, but a directory of its own keeps it like any other
+	// server for Stop and the debug bundle
+	dbDir, err := mkdirTemp(opts.dataDirBase(), "memongo-mongos-", "mongos directory")
+	if err != nil {
+		return nil, err
+	}
+
+	program, args := opts.mongodCommandLine(mongosPath, mongosArgs(opts, cfg.caps, cfg.replicaHost(), opts.Port)...)
+	proc, err := launchMongod(ctx, program, args, env, dbDir, false, 0, "", cfg.caps.reReady, opts.startupWait(), logger, events)
+	if err != nil {
+		if errors.Is(err, ErrPortInUse) {
+			err = fmt.Errorf("%w%s", err, describePortOwner(opts.Port))
+		}
+		return nil, fmt.Errorf("error starting mongos: %w", err)
+	}
+	proc.version = cfg.version
+	health.setProcess(proc.exited)
+
+	var capture *commandCapture
+	if opts.CaptureCommands {
+		capture = newCommandCapture()
+	}
+
+	server := &Server{
+		proc:          proc,
+		dbDir:         dbDir,
+		logger:        logger,
+		port:          proc.port,
+		events:        events,
+		opts:          *opts,
+		storageEngine: cfg.storageEngine,
+		version:       cfg.version,
+		capture:       capture,
+		binPath:       mongosPath,
+		caps:          cfg.caps,
+		host:          proc.host,
+		members:       map[int]*mongodProcess{},
+		nextMember:    1,
+		stopped:       make(chan struct{}),
+		cluster:       c,
+	}
+	cfgReport := cfg.StartReport()
+	server.startReport = StartReport{
+		Version:          cfg.version,
+		URI:              server.URI(),
+		CacheHit:         cfgReport.CacheHit,
+		Downloaded:       cfgReport.Downloaded,
+		Binary:           cfgReport.Binary,
+		PortWaitAttempts: proc.portWait.attempts,
+		PortWait:         proc.portWait.waited,
+		IgnoredOptions:   opts.ignored,
+	}
+
+	return server, nil
+}
+
+// mongosArgs returns the command line arguments of a mongos for opts, of a
+// version with caps, routing to the config server at configHost and
+// listening on port
+func mongosArgs(opts *Options, caps versionCapabilities, configHost string, port int) []string {
+	args := []string{
+		"--configdb", configServerName + "/" + configHost,
+		"--port", strconv.Itoa(port),
+		"--bind_ip", "localhost",
+	}
+	if opts.EnableIPv6 {
+		args = append(args, "--ipv6")
+	}
+	if opts.EnableTestCommands {
+		args = append(args, "--setParameter", "enableTestCommands=1")
+	}
+	if caps.quiesce {
+		args = append(args, "--setParameter", fmt.Sprintf("mongosShutdownTimeoutMillisForSignaledShutdown=%d", quiescePeriod.Milliseconds()))
+	}
+
+	return args
+}
+
+// getOrDownloadMongosPath returns the path to mongos for the mongod at
+// mongodPath: next to MongodBin, or from the same download
+func (opts *Options) getOrDownloadMongosPath(ctx context.Context, mongodPath string, logger *memongolog.Logger) (string, error) {
+	if opts.MongodBin != "" {
+		mongosPath := filepath.Join(filepath.Dir(mongodPath), "mongos")
+		_, err := os.Stat(mongosPath)
+		if err != nil {
+			return "", fmt.Errorf("sharded clusters need mongos next to MongodBin: %w", err)
+		}
+
+		return mongosPath, nil
+	}
+
+	d := opts.downloadOptions()
+	unlock, err := lockDownload(ctx, d)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	return mongobin.GetOrDownloadMongosContext(ctx, d, logger)
+}
+
+// addShards adds each shard to the cluster through mongos, retrying while
+// the replica sets elect their primaries
+func (s *Server) addShards(ctx context.Context) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	for _, shard := range s.cluster.shards {
+		seed := shard.replicaSetName + "/" + shard.replicaHost()
+		err := retry.Do(ctx, s.opts.replicaSetRetryPolicy(), func() error {
+			err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "addShard", Value: seed}}).Err()
+			if err == nil || !hasErrorCode(err, retryableAddShardCodes) {
+				return retry.Permanent(err)
+			}
+
+			s.logger.Debugf("addShard %s failed, retrying: %s", seed, err)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error adding shard %s: %w", shard.replicaSetName, err)
+		}
+	}
+
+	return nil
+}
+
+// servers returns the config server and the shards that started, in that
+// order
+func (c *shardedCluster) servers() []*Server {
+	if c == nil {
+		return nil
+	}
+
+	var servers []*Server
+	for _, server := range append([]*Server{c.configServer}, c.shards...) {
+		if server != nil {
+			servers = append(servers, server)
+		}
+	}
+
+	return servers
+}
+
+// hasFailed returns whether a mongod of the cluster, or a mongos that
+// wasn't stopped with StopMongos, has exited
+func (c *shardedCluster) hasFailed() bool {
+	for _, server := range c.servers() {
+		if server.hasFailed(nil) {
+			return true
+		}
+	}
+
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, router := range c.routers {
+		if router.hasExited() && atomic.LoadInt32(router.stopping) == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stop stops the shards, then the config server, once mongos has stopped.
+// If failed is set, they're stopped as failed, so they keep what
+// Options.Cleanup says to keep on failure.
+func (c *shardedCluster) stop(ctx context.Context, failed bool) []error {
+	servers := c.servers()
+	var errs []error
+	for i := len(servers) - 1; i >= 0; i-- {
+		if failed {
+			servers[i].MarkFailed()
+		}
+		err := servers[i].stopContext(ctx, nil)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// ShardCollection enables sharding for the database of the namespace ns,
+// e.g. "app.orders", and shards the collection with the shard key key, e.g.
+// bson.D{{Key: "customerId", Value: "hashed"}}. It returns once
+// config.collections lists the collection. It returns an error wrapping
+// ErrNotSharded unless the server was started with Options.Sharded.
+func (s *Server) ShardCollection(ctx context.Context, ns string, key bson.D) error {
+	if s.cluster == nil {
+		return fmt.Errorf("cannot shard %s: %w", ns, ErrNotSharded)
+	}
+
+	db, err := splitNamespace(ns)
+	if err != nil {
+		return err
+	}
+
+	// Sharding a collection enables sharding for its database from 6.0, but
+	// earlier versions need it enabled first
+	err = s.EnableSharding(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	client, err := s.Client(ctx)
+	if err != nil {
+		return err
+	}
+	err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "shardCollection", Value: ns}, {Key: "key", Value: key}}).Err()
+	if err != nil {
+		return fmt.Errorf("error sharding %s: %w", ns, err)
+	}
+
+	_, err = chunksFilter(ctx, client, ns)
+	return err
+}
+
+// splitNamespace returns the database of the namespace ns, which must be
+// <database>.<collection>
+func splitNamespace(ns string) (string, error) {
+	parts := strings.SplitN(ns, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid namespace %q: must be <database>.<collection>", ns)
+	}
+
+	return parts[0], nil
+}
+
+// IsSharded returns true if the server is the mongos of a sharded cluster
+// started with Options.Sharded.
+func (s *Server) IsSharded() bool {
+	return s.cluster != nil
+}
+
+// directShardAccess returns the cluster of a sharded cluster started with
+// Options.AllowDirectShardAccess, for reaching the config server or shards
+// to do what
+func (s *Server) directShardAccess(what string) (*shardedCluster, error) {
+	if s.cluster == nil {
+		return nil, fmt.Errorf("cannot %s: %w", what, ErrNotSharded)
+	}
+	if !s.opts.AllowDirectShardAccess {
+		return nil, fmt.Errorf("cannot %s: %w", what, ErrDirectShardAccess)
+	}
+	if err := s.checkRunning(); err != nil {
+		return nil, err
+	}
+
+	return s.cluster, nil
+}
+
+// ConfigServerURI returns the URI of the config server of a sharded
+// cluster, a single-member replica set called "cfg". Writing to it directly
+// can corrupt the cluster's sharding metadata. It returns an error wrapping
+// ErrDirectShardAccess unless Options.AllowDirectShardAccess is set.
+func (s *Server) ConfigServerURI() (string, error) {
+	cluster, err := s.directShardAccess("connect to the config server")
+	if err != nil {
+		return "", err
+	}
+
+	return cluster.configServer.ConnectionString(), nil
+}
+
+// ConfigClient returns the Client of the config server of a sharded
+// cluster, e.g. to read config.chunks. Like Client, it's reused across calls
+// and disconnected by Stop. Writing to the config server directly can
+// corrupt the cluster's sharding metadata. It returns an error wrapping
+// ErrDirectShardAccess unless Options.AllowDirectShardAccess is set.
+func (s *Server) ConfigClient(ctx context.Context) (*mongo.Client, error) {
+	cluster, err := s.directShardAccess("connect to the config server")
+	if err != nil {
+		return nil, err
+	}
+
+	return cluster.configServer.Client(ctx)
+}
+
+// ShardReplicaSets returns the shards of a sharded cluster, in order, each a
+// single-member replica set with a Server of its own, so replica set helpers
+// such as Members, TailOplog and DataFiles work on a shard. Stopping the
+// cluster stops them, so they mustn't be stopped themselves. Writing to a
+// shard directly bypasses mongos and can corrupt the cluster's sharding
+// metadata. It returns an error wrapping ErrDirectShardAccess unless
+// Options.AllowDirectShardAccess is set.
+func (s *Server) ShardReplicaSets() ([]*Server, error) {
+	cluster, err := s.directShardAccess("access the shards")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]*Server(nil), cluster.shards...), nil
+}
+
+// RunOnAllShards calls fn with each shard of ShardReplicaSets in turn, and
+// returns the first error, naming the shard, without going on to the next.
+// It returns ctx's error if ctx is done before every shard has had its turn.
+func (s *Server) RunOnAllShards(ctx context.Context, fn func(*Server) error) error {
+	shards, err := s.ShardReplicaSets()
+	if err != nil {
+		return err
+	}
+
+	for _, shard := range shards {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := fn(shard)
+		if err != nil {
+			return fmt.Errorf("shard %s: %w", shard.replicaSetName, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,398 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestValidateSharded(t *testing.T) {
+	tests := map[string]struct {
+		opts          Options
+		expectedError string
+	}{
+		"shards without Sharded": {
+			opts:          Options{NumShards: 2},
+			expectedError: "cannot use NumShards without Sharded",
+		},
+		"too many shards": {
+			opts:          Options{Sharded: true, NumShards: 17},
+			expectedError: "invalid NumShards 17: must be within 1-16",
+		},
+		"replica set": {
+			opts:          Options{Sharded: true, ShouldUseReplica: true},
+			expectedError: "cannot use Sharded with ShouldUseReplica",
+		},
+		"auth": {
+			opts:          Options{Sharded: true, Auth: true},
+			expectedError: "cannot use Sharded with Auth",
+		},
+		"read only": {
+			opts:          Options{Sharded: true, ReadOnly: true},
+			expectedError: "cannot use Sharded with ReadOnly",
+		},
+		"mongos without Sharded": {
+			opts:          Options{MongosCount: 2},
+			expectedError: "cannot use MongosCount without Sharded",
+		},
+		"too many mongos": {
+			opts:          Options{Sharded: true, MongosCount: 9},
+			expectedError: "invalid MongosCount 9: must be within 1-8",
+		},
+		"direct shard access without Sharded": {
+			opts:          Options{AllowDirectShardAccess: true},
+			expectedError: "cannot use AllowDirectShardAccess without Sharded",
+		},
+		"balancer without Sharded": {
+			opts:          Options{EnableBalancer: true},
+			expectedError: "cannot use EnableBalancer without Sharded",
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			opts := test.opts
+			opts.MongodBin = "/bin/true"
+			assert.EqualError(t, opts.Validate(), test.expectedError)
+		})
+	}
+
+	opts := &Options{MongodBin: "/bin/true", Sharded: true, NumShards: 3}
+	require.NoError(t, opts.Validate())
+	assert.Equal(t, 3, opts.shardCount())
+	assert.Equal(t, 1, (&Options{Sharded: true}).shardCount())
+	assert.Equal(t, 1, (&Options{Sharded: true}).mongosCount())
+}
+
+func TestClusterMemberOptions(t *testing.T) {
+	os.Setenv("MEMONGO_MONGOD_PORT", "27017")
+	defer os.Unsetenv("MEMONGO_MONGOD_PORT")
+
+	opts := &Options{MongodBin: "/bin/true", Sharded: true, NumShards: 2, MongosCount: 2, CaptureCommands: true, EnableBalancer: true}
+	require.NoError(t, opts.fillDefaults())
+	assert.Equal(t, 27017, opts.Port)
+
+	member, err := opts.clusterMemberOptions("shard1", roleShard)
+	require.NoError(t, err)
+	require.NoError(t, member.fillDefaults())
+	assert.True(t, member.ShouldUseReplica)
+	assert.False(t, member.Sharded)
+	assert.False(t, member.CaptureCommands)
+	assert.False(t, member.EnableBalancer)
+	assert.Zero(t, member.MongosCount)
+	assert.False(t, member.AllowDirectShardAccess)
+	assert.Equal(t, "shard1", member.ReplicaSetName)
+	assert.NotEqual(t, 27017, member.Port)
+	assert.True(t, member.portAllocated)
+
+	// The options it came from are unchanged
+	assert.True(t, opts.Sharded)
+	assert.Equal(t, 27017, opts.Port)
+
+	args, engine := mongodArgs(member, versionCapabilities{}, "/tmp/db", member.Port, "", "")
+	assert.Equal(t, "wiredTiger", engine)
+	assert.Contains(t, args, "--shardsvr")
+	assert.NotContains(t, args, "--configsvr")
+}
+
+func TestMongosArgs(t *testing.T) {
+	opts := &Options{Port: 27017, EnableIPv6: true, EnableTestCommands: true}
+	assert.Equal(t, []string{
+		"--configdb", "cfg/127.0.0.1:27018",
+		"--port", "27017",
+		"--bind_ip", "localhost",
+		"--ipv6",
+		"--setParameter", "enableTestCommands=1",
+	}, mongosArgs(opts, versionCapabilities{}, "127.0.0.1:27018", opts.Port))
+
+	args := mongosArgs(&Options{Port: 27017}, versionCapabilities{quiesce: true}, "127.0.0.1:27018", 27019)
+	assert.Equal(t, "27019", args[3])
+	assert.Equal(t, "mongosShutdownTimeoutMillisForSignaledShutdown=100", args[len(args)-1])
+}
+
+func TestConfigServerReplicaSetConfig(t *testing.T) {
+	server := &Server{
+		host:           "127.0.0.1",
+		port:           27018,
+		replicaSetName: configServerName,
+		opts:           Options{ShouldUseReplica: true, clusterRole: roleConfigServer},
+	}
+	assert.Equal(t, true, configField(server.replicaSetConfig(nil), "configsvr"))
+
+	server.opts.clusterRole = roleShard
+	assert.Nil(t, configField(server.replicaSetConfig(nil), "configsvr"))
+}
+
+func TestGetOrDownloadMongosPath(t *testing.T) {
+	dir := t.TempDir()
+	mongodPath := filepath.Join(dir, "mongod")
+	opts := &Options{MongodBin: mongodPath}
+	logger := memongolog.New(nil, memongolog.LogLevelSilent)
+
+	_, err := opts.getOrDownloadMongosPath(context.Background(), mongodPath, logger)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sharded clusters need mongos next to MongodBin")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mongos"), nil, 0o755))
+	mongosPath, err := opts.getOrDownloadMongosPath(context.Background(), mongodPath, logger)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "mongos"), mongosPath)
+}
+
+func TestStartClusterMembersFailure(t *testing.T) {
+	base := t.TempDir()
+	opts := &Options{
+		MongodBin:   filepath.Join(t.TempDir(), "missing"),
+		Sharded:     true,
+		NumShards:   2,
+		TempDirBase: base,
+	}
+
+	_, err := startClusterMembers(context.Background(), opts, memongolog.New(nil, memongolog.LogLevelSilent))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error starting the config server")
+
+	entries, err := os.ReadDir(base)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+// fakeCluster returns a fake mongos server in front of a fake config server
+// and shards
+func fakeCluster(t *testing.T, cleanup Cleanup, shards int) *Server {
+	server := fakeServer(t, cleanup)
+	server.cluster = &shardedCluster{configServer: fakeClusterMember(t, cleanup, configServerName, roleConfigServer)}
+	for index := 0; index < shards; index++ {
+		server.cluster.shards = append(server.cluster.shards, fakeClusterMember(t, cleanup, shardName(index), roleShard))
+	}
+
+	return server
+}
+
+func fakeClusterMember(t *testing.T, cleanup Cleanup, name string, role string) *Server {
+	member := fakeServer(t, cleanup)
+	member.isReplicaSet = true
+	member.replicaSetName = name
+	member.opts.clusterRole = role
+
+	return member
+}
+
+// fakeRouters adds n fake mongos to the fake cluster server, on ports 2 and
+// up
+func fakeRouters(t *testing.T, server *Server, n int) {
+	server.opts.Sharded, server.opts.MongosCount = true, n+1
+	for i := 0; i < n; i++ {
+		router := fakeProcess(t)
+		router.host, router.port = "127.0.0.1", i+2
+		server.cluster.routers = append(server.cluster.routers, router)
+	}
+}
+
+func TestShardedDBPaths(t *testing.T) {
+	server := fakeCluster(t, Cleanup{}, 2)
+	defer server.Stop()
+	fakeRouters(t, server, 1)
+
+	assert.Equal(t, []MemberPath{
+		{Member: 0, Role: "mongos", Path: server.dbDir},
+		{Member: 1, Role: "mongos", Path: server.cluster.routers[0].dbDir},
+		{Member: 0, Role: "configsvr", ReplicaSet: "cfg", Path: server.cluster.configServer.dbDir},
+		{Member: 0, Role: "shardsvr", ReplicaSet: "shard0", Path: server.cluster.shards[0].dbDir},
+		{Member: 0, Role: "shardsvr", ReplicaSet: "shard1", Path: server.cluster.shards[1].dbDir},
+	}, server.DBPaths())
+}
+
+func TestStopShardedCluster(t *testing.T) {
+	server := fakeCluster(t, Cleanup{}, 2)
+	servers := append([]*Server{server}, server.cluster.servers()...)
+	server.Stop()
+
+	for i, s := range servers {
+		assert.True(t, s.proc.hasExited(), "server %d", i)
+		_, err := os.Stat(s.dbDir)
+		assert.True(t, os.IsNotExist(err), "data directory %d wasn't removed", i)
+	}
+
+	// A shard that exited fails the cluster, so every data directory is kept
+	server = fakeCluster(t, Cleanup{RemoveDBPath: CleanupOnSuccess}, 1)
+	killMongod(t, server.cluster.shards[0])
+	assert.True(t, server.hasFailed(nil))
+	servers = append([]*Server{server}, server.cluster.servers()...)
+	server.Stop()
+
+	for i, s := range servers {
+		_, err := os.Stat(s.dbDir)
+		assert.NoError(t, err, "data directory %d was removed", i)
+	}
+}
+
+func TestMongosURIs(t *testing.T) {
+	server := fakeCluster(t, Cleanup{}, 1)
+	defer server.Stop()
+	assert.Equal(t, "mongodb://127.0.0.1:1", server.URI())
+	assert.Equal(t, []string{"mongodb://127.0.0.1:1/?directConnection=true"}, server.MongosURIs())
+
+	fakeRouters(t, server, 2)
+	assert.Equal(t, "mongodb://127.0.0.1:1,127.0.0.1:2,127.0.0.1:3", server.URI())
+	assert.Equal(t, "mongodb://127.0.0.1:1,127.0.0.1:2,127.0.0.1:3", server.ConnectionString())
+	assert.Equal(t, server.URI(), server.clientURI())
+	assert.Equal(t, []string{
+		"mongodb://127.0.0.1:1/?directConnection=true",
+		"mongodb://127.0.0.1:2/?directConnection=true",
+		"mongodb://127.0.0.1:3/?directConnection=true",
+	}, server.MongosURIs())
+}
+
+func TestStopMongos(t *testing.T) {
+	ctx := context.Background()
+	server := fakeCluster(t, Cleanup{RemoveDBPath: CleanupOnSuccess}, 1)
+	defer server.Stop()
+	fakeRouters(t, server, 2)
+	router := server.cluster.routers[0]
+
+	assert.EqualError(t, server.StopMongos(ctx, 0), "cannot stop mongos 0: it's the server itself")
+	assert.EqualError(t, server.StopMongos(ctx, 3), "no mongos 3")
+	assert.EqualError(t, server.StartMongos(ctx, 1), "mongos 1 is running")
+
+	// A stopped mongos keeps its directory, and doesn't fail the cluster
+	require.NoError(t, server.StopMongos(ctx, 1))
+	assert.True(t, router.hasExited())
+	assert.DirExists(t, router.dbDir)
+	assert.False(t, server.hasFailed(nil))
+	assert.EqualError(t, server.StopMongos(ctx, 1), "mongos 1 is already stopped")
+	assert.Len(t, server.MongosURIs(), 3)
+
+	// One that exits by itself does
+	require.NoError(t, server.cluster.routers[1].cmd.Process.Kill())
+	<-server.cluster.routers[1].exited
+	assert.True(t, server.hasFailed(nil))
+
+	server.MarkFailed()
+	server.Stop()
+	assert.DirExists(t, router.dbDir)
+	assert.Empty(t, server.cluster.routers)
+	assert.True(t, errors.Is(server.StartMongos(ctx, 1), ErrServerStopped))
+}
+
+func TestStopShardedClusterRouters(t *testing.T) {
+	server := fakeCluster(t, Cleanup{}, 1)
+	fakeRouters(t, server, 2)
+	routers := server.cluster.routers
+	server.Stop()
+
+	for i, router := range routers {
+		assert.True(t, router.hasExited(), "mongos %d", i+1)
+		_, err := os.Stat(router.dbDir)
+		assert.True(t, os.IsNotExist(err), "directory of mongos %d wasn't removed", i+1)
+	}
+}
+
+func TestMongosNotSharded(t *testing.T) {
+	ctx := context.Background()
+	server := fakeServer(t, Cleanup{})
+	defer server.Stop()
+
+	assert.True(t, errors.Is(server.StopMongos(ctx, 1), ErrNotSharded))
+	assert.True(t, errors.Is(server.StartMongos(ctx, 1), ErrNotSharded))
+	assert.Equal(t, []string{server.DirectURI()}, server.MongosURIs())
+}
+
+func TestDirectShardAccess(t *testing.T) {
+	ctx := context.Background()
+	server := fakeCluster(t, Cleanup{}, 2)
+	defer server.Stop()
+
+	_, err := server.ConfigServerURI()
+	assert.True(t, errors.Is(err, ErrDirectShardAccess))
+	_, err = server.ConfigClient(ctx)
+	assert.True(t, errors.Is(err, ErrDirectShardAccess))
+	_, err = server.ShardReplicaSets()
+	assert.True(t, errors.Is(err, ErrDirectShardAccess))
+	err = server.RunOnAllShards(ctx, func(*Server) error { return nil })
+	assert.True(t, errors.Is(err, ErrDirectShardAccess))
+
+	server.opts.AllowDirectShardAccess = true
+	uri, err := server.ConfigServerURI()
+	require.NoError(t, err)
+	assert.Equal(t, "mongodb://127.0.0.1:1/?replicaSet=cfg", uri)
+
+	shards, err := server.ShardReplicaSets()
+	require.NoError(t, err)
+	assert.Equal(t, server.cluster.shards, shards)
+
+	var visited []string
+	err = server.RunOnAllShards(ctx, func(shard *Server) error {
+		visited = append(visited, shard.ReplicaSetName())
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shard0", "shard1"}, visited)
+
+	// The first error stops it, naming the shard
+	visited = nil
+	errFailed := errors.New("failed")
+	err = server.RunOnAllShards(ctx, func(shard *Server) error {
+		visited = append(visited, shard.ReplicaSetName())
+		return errFailed
+	})
+	assert.True(t, errors.Is(err, errFailed))
+	assert.EqualError(t, err, "shard shard0: failed")
+	assert.Equal(t, []string{"shard0"}, visited)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = server.RunOnAllShards(canceled, func(*Server) error { return nil })
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	server.Stop()
+	_, err = server.ShardReplicaSets()
+	assert.True(t, errors.Is(err, ErrServerStopped))
+}
+
+func TestDirectShardAccessNotSharded(t *testing.T) {
+	server := &Server{opts: Options{AllowDirectShardAccess: true}}
+	_, err := server.ConfigServerURI()
+	assert.True(t, errors.Is(err, ErrNotSharded))
+	_, err = server.ConfigClient(context.Background())
+	assert.True(t, errors.Is(err, ErrNotSharded))
+	_, err = server.ShardReplicaSets()
+	assert.True(t, errors.Is(err, ErrNotSharded))
+}
+
+func TestShardCollectionNotSharded(t *testing.T) {
+	server := &Server{}
+	err := server.ShardCollection(context.Background(), "app.orders", bson.D{{Key: "_id", Value: "hashed"}})
+	assert.True(t, errors.Is(err, ErrNotSharded))
+	assert.False(t, server.IsSharded())
+
+	server.cluster = &shardedCluster{}
+	assert.True(t, server.IsSharded())
+	for _, ns := range []string{"app", ".orders", "app."} {
+		err = server.ShardCollection(context.Background(), ns, bson.D{{Key: "_id", Value: 1}})
+		assert.EqualError(t, err, "invalid namespace "+strconv.Quote(ns)+": must be <database>.<collection>")
+	}
+}
+
+func TestChunksNotSharded(t *testing.T) {
+	ctx := context.Background()
+	server := &Server{}
+	assert.True(t, errors.Is(server.DisableBalancer(ctx), ErrNotSharded))
+	assert.True(t, errors.Is(server.EnableSharding(ctx, "app"), ErrNotSharded))
+	assert.True(t, errors.Is(server.MoveChunk(ctx, "app.orders", bson.D{{Key: "_id", Value: 1}}, "shard1"), ErrNotSharded))
+	assert.True(t, errors.Is(server.SplitAt(ctx, "app.orders", bson.D{{Key: "_id", Value: 1}}), ErrNotSharded))
+
+	server.cluster = &shardedCluster{}
+	err := server.MoveChunk(ctx, "app", bson.D{{Key: "_id", Value: 1}}, "shard1")
+	assert.EqualError(t, err, `invalid namespace "app": must be <database>.<collection>`)
+	err = server.SplitAt(ctx, "app.", bson.D{{Key: "_id", Value: 1}})
+	assert.EqualError(t, err, `invalid namespace "app.": must be <database>.<collection>`)
+}
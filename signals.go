@@ -0,0 +1,89 @@
+package memongo
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// liveServers are the servers that have started and haven't been stopped,
+// for HandleSignals to stop. Servers are only held while they're running:
+// Stop removes them, and a running server's mongod outlives its Server value
+// anyway.
+var liveServers = struct {
+	sync.Mutex
+	m map[*Server]struct{}
+}{m: map[*Server]struct{}{}}
+
+func trackServer(s *Server) {
+	liveServers.Lock()
+	defer liveServers.Unlock()
+
+	liveServers.m[s] = struct{}{}
+}
+
+func untrackServer(s *Server) {
+	liveServers.Lock()
+	defer liveServers.Unlock()
+
+	delete(liveServers.m, s)
+}
+
+// stopLiveServers stops every live server in parallel, and returns once
+// they've all stopped
+func stopLiveServers() {
+	liveServers.Lock()
+	servers := make([]*Server, 0, len(liveServers.m))
+	for s := range liveServers.m {
+		servers = append(servers, s)
+	}
+	liveServers.Unlock()
+
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s *Server) {
+			defer wg.Done()
+			s.Stop()
+		}(s)
+	}
+	wg.Wait()
+}
+
+// HandleSignals makes the process stop every running server when it
+// receives one of signals, os.Interrupt and SIGTERM by default, so that
+// interrupting a test run with Ctrl-C doesn't leave mongod processes behind
+// when deferred Stops never run. The servers are stopped in parallel, as
+// Stop would, and then the signal is raised again with its default handling,
+// so the process still dies with the usual exit status. That resets any
+// other handlers for the signal, which os/signal can't tell apart.
+//
+// Servers still starting when the signal arrives aren't stopped. It's
+// opt-in, and the returned function undoes it.
+func HandleSignals(signals ...os.Signal) (stop func()) {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, signals...)
+	go func() {
+		select {
+		case sig := <-ch:
+			stopLiveServers()
+			signal.Reset(sig)
+			raise(sig)
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package memongo
+
+import (
+	"os"
+)
+
+// raise exits with status 1, since signals can't be re-raised here
+func raise(os.Signal) {
+	os.Exit(1)
+}
@@ -0,0 +1,102 @@
+package memongo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLiveServers(t *testing.T) {
+	server := fakeServer(t, Cleanup{})
+	trackServer(server)
+
+	liveServers.Lock()
+	_, tracked := liveServers.m[server]
+	liveServers.Unlock()
+	assert.True(t, tracked)
+
+	server.Stop()
+	liveServers.Lock()
+	_, tracked = liveServers.m[server]
+	liveServers.Unlock()
+	assert.False(t, tracked)
+
+	// Stopping again doesn't track it again
+	server.Stop()
+	liveServers.Lock()
+	_, tracked = liveServers.m[server]
+	liveServers.Unlock()
+	assert.False(t, tracked)
+}
+
+func TestHandleSignalsStop(t *testing.T) {
+	stop := HandleSignals(syscall.SIGUSR1)
+	stop()
+	stop()
+}
+
+const signalHelperEnv = "MEMONGO_SIGNAL_HELPER"
+
+// TestSignalHelper runs in a child process for TestHandleSignals: it handles
+// signals with a running server, prints its data directory and waits to be
+// interrupted
+func TestSignalHelper(t *testing.T) {
+	if os.Getenv(signalHelperEnv) == "" {
+		t.Skip("only runs as TestHandleSignals's child process")
+	}
+
+	server := fakeServer(t, Cleanup{})
+	trackServer(server)
+	HandleSignals()
+
+	fmt.Printf("dbdir=%s\n", server.dbDir)
+	time.Sleep(time.Minute)
+	t.Fatal("not interrupted")
+}
+
+func TestHandleSignals(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("signals can only be re-raised on linux and darwin")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run", "^TestSignalHelper$")
+	cmd.Env = append(os.Environ(), signalHelperEnv+"=1", "TMPDIR="+t.TempDir())
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	defer func() {
+		_ = cmd.Process.Kill()
+	}()
+
+	var dbDir string
+	scanner := bufio.NewScanner(stdout)
+	for dbDir == "" && scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "dbdir=") {
+			dbDir = strings.TrimPrefix(scanner.Text(), "dbdir=")
+		}
+	}
+	require.NotEmpty(t, dbDir)
+	require.DirExists(t, dbDir)
+
+	require.NoError(t, cmd.Process.Signal(os.Interrupt))
+	err = cmd.Wait()
+
+	// The child dies of the signal, after stopping the server
+	var exitErr *exec.ExitError
+	require.True(t, errors.As(err, &exitErr), "%v", err)
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	require.True(t, ok)
+	assert.True(t, status.Signaled())
+	assert.Equal(t, syscall.SIGINT, status.Signal())
+	assert.NoDirExists(t, dbDir)
+}
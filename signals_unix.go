@@ -0,0 +1,24 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package memongo
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// raise sends sig to the process, whose handler for it has been reset, so
+// it dies with the status of being killed by sig. It exits with the shell's
+// convention of 128 plus the signal number in case sig doesn't kill it.
+func raise(sig os.Signal) {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		os.Exit(1)
+	}
+
+	_ = syscall.Kill(os.Getpid(), s)
+	time.Sleep(time.Second)
+	os.Exit(128 + int(s))
+}
@@ -0,0 +1,346 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/100mslive/memongo/v2/mongobin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// mongoReleases are the major MongoDB releases, oldest first. A replica set
+// can only mix a release with the ones next to it, during an upgrade or a
+// downgrade.
+var mongoReleases = [][]int{
+	{3, 2}, {3, 4}, {3, 6}, {4, 0}, {4, 2}, {4, 4}, {5, 0}, {6, 0}, {7, 0}, {8, 0},
+}
+
+// firstFCVRelease is the index in mongoReleases of 3.4, the first release
+// with a feature compatibility version
+const firstFCVRelease = 1
+
+// releaseIndex returns the index in mongoReleases of the release version
+// belongs to. Rapid releases, such as 7.1, belong to the major release before
+// them.
+func releaseIndex(version string) (int, error) {
+	parsed, err := mongobin.ParseVersion(version)
+	if err != nil {
+		return 0, err
+	}
+
+	index := -1
+	for i, release := range mongoReleases {
+		if versionAtLeast(parsed, []int{release[0], release[1], 0}) {
+			index = i
+		}
+	}
+	if index < 0 {
+		return 0, fmt.Errorf("MongoDB version %s is not supported, the minimum is %s", version, releaseName(0))
+	}
+
+	return index, nil
+}
+
+// releaseRange returns the indexes of the oldest and newest releases among
+// versions
+func releaseRange(versions []string) (int, int, error) {
+	oldest, newest := len(mongoReleases), -1
+	for _, version := range versions {
+		index, err := releaseIndex(version)
+		if err != nil {
+			return 0, 0, err
+		}
+		if index < oldest {
+			oldest = index
+		}
+		if index > newest {
+			newest = index
+		}
+	}
+
+	return oldest, newest, nil
+}
+
+// validateVersionSkew returns an error if versions can't run in one replica
+// set because they're more than one major release apart
+func validateVersionSkew(versions []string) error {
+	oldest, newest, err := releaseRange(versions)
+	if err != nil {
+		return err
+	}
+
+	if newest-oldest > 1 {
+		return fmt.Errorf("MongoDB %s and %s are more than one major release apart: replica sets can only mix adjacent releases, such as 7.0 and 8.0",
+			releaseName(oldest), releaseName(newest))
+	}
+
+	return nil
+}
+
+// featureCompatibilityVersion returns the feature compatibility version a
+// replica set running versions must use: the oldest release among them. It
+// returns "" if that release has no feature compatibility version.
+func featureCompatibilityVersion(versions []string) (string, error) {
+	oldest, _, err := releaseRange(versions)
+	if err != nil {
+		return "", err
+	}
+	if oldest < firstFCVRelease {
+		return "", nil
+	}
+
+	return releaseName(oldest), nil
+}
+
+// releaseName returns the name of the release at index in mongoReleases,
+// e.g. "7.0"
+func releaseName(index int) string {
+	release := mongoReleases[index]
+	return fmt.Sprintf("%d.%d", release[0], release[1])
+}
+
+// memberVersions returns the MongoDB version of each replica set member,
+// with proc's replaced by version if proc isn't nil, or an error if one isn't
+// known. s.mu must be held.
+func (s *Server) memberVersions(proc *mongodProcess, version string) ([]string, error) {
+	procs := []*mongodProcess{s.proc}
+	for _, member := range s.members {
+		procs = append(procs, member)
+	}
+
+	versions := make([]string, len(procs))
+	for i, p := range procs {
+		versions[i] = p.version
+		if p == proc {
+			versions[i] = version
+		}
+		if versions[i] == "" {
+			return nil, fmt.Errorf("the MongoDB version of every replica set member must be known; set MongoVersion")
+		}
+	}
+
+	return versions, nil
+}
+
+// versionBinary returns mongod for version and its capabilities, downloading
+// it if needed, unless ctx is done first
+func (s *Server) versionBinary(ctx context.Context, version string) (string, versionCapabilities, error) {
+	caps, err := capabilitiesForVersion(version)
+	if err != nil {
+		return "", versionCapabilities{}, err
+	}
+
+	s.mu.Lock()
+	binPath := s.binPath
+	current := s.proc.version
+	s.mu.Unlock()
+	if version == current {
+		return binPath, caps, nil
+	}
+
+	opts := s.opts.clone()
+	opts.MongodBin = ""
+	err = opts.useDownloadCandidates(version)
+	if err != nil {
+		return "", versionCapabilities{}, err
+	}
+	binPath, _, err = opts.getOrDownloadBinPath(ctx, s.events, s.logger)
+	if err != nil {
+		return "", versionCapabilities{}, fmt.Errorf("error getting MongoDB %s: %w", version, err)
+	}
+
+	return binPath, caps, nil
+}
+
+// setFeatureCompatibility sets the replica set's feature compatibility
+// version to the one versions need, if it isn't already
+func (s *Server) setFeatureCompatibility(ctx context.Context, versions []string) error {
+	fcv, err := featureCompatibilityVersion(versions)
+	if err != nil || fcv == "" {
+		return err
+	}
+
+	client, err := mongo.Connect(options.Client().ApplyURI(s.URIWithReadPreference("primary", nil)))
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+	admin := client.Database("admin")
+
+	var current struct {
+		FCV struct {
+			Version string `bson:"version"`
+		} `bson:"featureCompatibilityVersion"`
+	}
+	err = admin.RunCommand(ctx, bson.D{{Key: "getParameter", Value: 1}, {Key: "featureCompatibilityVersion", Value: 1}}).Decode(&current)
+	if err != nil {
+		return fmt.Errorf("error getting the feature compatibility version: %w", err)
+	}
+	if current.FCV.Version == fcv {
+		return nil
+	}
+
+	cmd := bson.D{{Key: "setFeatureCompatibilityVersion", Value: fcv}}
+	raw, err := admin.RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Raw()
+	if err != nil {
+		return fmt.Errorf("error running buildInfo: %w", err)
+	}
+	primary, err := decodeBuildInfo(raw)
+	if err != nil {
+		return err
+	}
+	// 7.0 added confirm, and requires it
+	if primary.VersionArray[0] >= 7 {
+		cmd = append(cmd, bson.E{Key: "confirm", Value: true})
+	}
+
+	err = admin.RunCommand(ctx, cmd).Err()
+	if err != nil {
+		return fmt.Errorf("error setting the feature compatibility version to %s: %w", fcv, err)
+	}
+	s.logger.Debugf("Set the feature compatibility version from %s to %s", current.FCV.Version, fcv)
+
+	return nil
+}
+
+// UpgradeMember shuts a replica set member down cleanly, restarts it on
+// version with the same data directory and port, and waits for it to rejoin
+// the set, so a test can script a rolling upgrade or downgrade. index is 0
+// for the server itself, or an index returned by AddReplicaMember. version is
+// downloaded if needed.
+//
+// Versions more than one major release apart from the other members' are
+// rejected. The feature compatibility version is kept at the oldest release
+// in the set: it's lowered before a member is downgraded, and raised once
+// every member runs a newer release.
+//
+// If the member fails to restart, it's removed from the server, or for the
+// server itself, the server must be stopped.
+func (s *Server) UpgradeMember(ctx context.Context, index int, version string) error {
+	if err := s.checkRunning(); err != nil {
+		return err
+	}
+	if !s.isReplicaSet {
+		return fmt.Errorf("cannot upgrade a replica set member: %w", ErrNotReplicaSet)
+	}
+
+	s.mu.Lock()
+	proc, ok := s.proc, index == 0
+	if index != 0 {
+		proc, ok = s.members[index]
+	}
+	var current, upgraded []string
+	var err error
+	if ok {
+		current, err = s.memberVersions(nil, "")
+		if err == nil {
+			upgraded, err = s.memberVersions(proc, version)
+		}
+	}
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no replica set member %d", index)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = validateVersionSkew(upgraded)
+	if err != nil {
+		return err
+	}
+
+	// The download isn't bounded by ReplicaSetReadyTimeout
+	binPath, caps, err := s.versionBinary(ctx, version)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.opts.ReplicaSetReadyTimeout)
+	defer cancel()
+
+	// Downgrading needs the older release's feature compatibility version
+	// before the member restarts
+	err = s.setFeatureCompatibility(ctx, append(current, version))
+	if err != nil {
+		return err
+	}
+
+	host := s.memberHost(proc)
+	s.logger.Debugf("Restarting replica set member %d at %s on MongoDB %s", index, host, version)
+	proc.keepDBDir = true
+	_ = proc.stop(s.memberLogger(index), true)
+	trackPath(proc.dbDir, "data directory")
+
+	restarted, err := s.relaunch(ctx, proc, index, binPath, caps)
+	if err != nil {
+		if index != 0 {
+			s.mu.Lock()
+			delete(s.members, index)
+			s.mu.Unlock()
+			_ = removePath(proc.dbDir)
+		}
+		return fmt.Errorf("error restarting replica set member %s on MongoDB %s: %w", host, version, err)
+	}
+	restarted.version = version
+
+	s.mu.Lock()
+	if index == 0 {
+		s.proc = restarted
+		s.binPath = binPath
+		s.caps = caps
+		s.version = version
+		s.buildInfo = nil
+	} else {
+		s.members[index] = restarted
+	}
+	s.mu.Unlock()
+	if index == 0 {
+		s.health.setProcess(restarted.exited)
+	}
+
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	state, err := waitForMemberState(ctx, client, host, "SECONDARY", "PRIMARY")
+	if err != nil {
+		return fmt.Errorf("error waiting for replica set member %s to rejoin: %w", host, err)
+	}
+	s.events.emitState(index, state)
+
+	return s.setFeatureCompatibility(ctx, upgraded)
+}
+
+// relaunch starts mongod from binPath on the data directory and port of
+// proc, which has stopped. The data directory is kept if it fails.
+func (s *Server) relaunch(ctx context.Context, proc *mongodProcess, index int, binPath string, caps versionCapabilities) (*mongodProcess, error) {
+	env, err := s.opts.mongodEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	args, _ := mongodArgs(&s.opts, caps, proc.dbDir, proc.port, s.keyFile, proc.advertisedHost)
+	program, args := s.opts.mongodCommandLine(binPath, args...)
+	_, err = starts.acquire(ctx, s.opts.StartConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	restarted, err := launchMongod(ctx, program, args, env, proc.dbDir, true, index, proc.name, caps.reReady, s.opts.startupWait(), s.logger, s.events)
+	starts.release()
+	if err != nil {
+		return nil, err
+	}
+	restarted.advertisedHost = proc.advertisedHost
+	restarted.hidden = proc.hidden
+
+	return restarted, nil
+}
@@ -0,0 +1,133 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReleaseIndex(t *testing.T) {
+	tests := map[string]string{
+		"3.2.0":  "3.2",
+		"4.0.27": "4.0",
+		"5.0.8":  "5.0",
+		"6.1.0":  "6.0",
+		"7.3.2":  "7.0",
+		"8.0.0":  "8.0",
+	}
+
+	for version, release := range tests {
+		index, err := releaseIndex(version)
+		require.NoError(t, err)
+		assert.Equal(t, release, releaseName(index), version)
+	}
+
+	_, err := releaseIndex("3.0.0")
+	assert.Error(t, err)
+}
+
+func TestValidateVersionSkew(t *testing.T) {
+	assert.NoError(t, validateVersionSkew([]string{"8.0.0"}))
+	assert.NoError(t, validateVersionSkew([]string{"7.0.2", "8.0.0", "8.0.4"}))
+	assert.NoError(t, validateVersionSkew([]string{"4.4.0", "5.0.8"}))
+	assert.NoError(t, validateVersionSkew([]string{"6.0.0", "6.1.0", "7.0.0"}))
+
+	err := validateVersionSkew([]string{"8.0.0", "6.0.0", "7.0.0"})
+	assert.EqualError(t, err, "MongoDB 6.0 and 8.0 are more than one major release apart: replica sets can only mix adjacent releases, such as 7.0 and 8.0")
+}
+
+func TestFeatureCompatibilityVersion(t *testing.T) {
+	fcv, err := featureCompatibilityVersion([]string{"8.0.0", "7.0.2"})
+	require.NoError(t, err)
+	assert.Equal(t, "7.0", fcv)
+
+	fcv, err = featureCompatibilityVersion([]string{"8.0.0", "8.0.4"})
+	require.NoError(t, err)
+	assert.Equal(t, "8.0", fcv)
+
+	fcv, err = featureCompatibilityVersion([]string{"4.4.0", "5.0.8"})
+	require.NoError(t, err)
+	assert.Equal(t, "4.4", fcv)
+
+	// 3.2 has no feature compatibility version
+	fcv, err = featureCompatibilityVersion([]string{"3.2.0", "3.4.0"})
+	require.NoError(t, err)
+	assert.Empty(t, fcv)
+}
+
+func TestMemberVersions(t *testing.T) {
+	member := &mongodProcess{version: "7.0.2"}
+	server := &Server{
+		proc:    &mongodProcess{version: "8.0.0"},
+		members: map[int]*mongodProcess{1: member},
+	}
+
+	versions, err := server.memberVersions(nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"8.0.0", "7.0.2"}, versions)
+
+	versions, err = server.memberVersions(member, "8.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"8.0.0", "8.0.0"}, versions)
+
+	server.proc.version = ""
+	_, err = server.memberVersions(nil, "")
+	assert.Error(t, err)
+}
+
+func TestReplicaMemberVersions(t *testing.T) {
+	opts := &Options{ShouldUseReplica: true, ReplicaMemberVersions: []string{"8.0.0"}}
+	require.NoError(t, opts.validateSettings())
+
+	tests := map[string]struct {
+		opts          Options
+		expectedError string
+	}{
+		"not a replica set": {
+			opts:          Options{ReplicaMemberVersions: []string{"8.0.0"}},
+			expectedError: "cannot use ReplicaMemberVersions without ShouldUseReplica",
+		},
+		"too many versions": {
+			opts:          Options{ShouldUseReplica: true, ReplicaMemberVersions: []string{"8.0.0", "7.0.0"}},
+			expectedError: "the replica set has 1 members, but ReplicaMemberVersions has 2 versions",
+		},
+		"with MongodBin": {
+			opts:          Options{ShouldUseReplica: true, ReplicaMemberVersions: []string{"8.0.0"}, MongodBin: "/bin/true"},
+			expectedError: "cannot use ReplicaMemberVersions with MongodBin or DownloadURL: each version is downloaded",
+		},
+		"unsupported version": {
+			opts:          Options{ShouldUseReplica: true, ReplicaMemberVersions: []string{"2.6.0"}},
+			expectedError: "invalid ReplicaMemberVersions: memongo does not support MongoDB version \"2.6.0\": Only Mongo version 3.2 and above are supported",
+		},
+		"conflicting MongoVersion": {
+			opts:          Options{ShouldUseReplica: true, ReplicaMemberVersions: []string{"8.0.0"}, MongoVersion: "7.0.0"},
+			expectedError: "MongoVersion 7.0.0 conflicts with ReplicaMemberVersions [8.0.0]",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.EqualError(t, test.opts.validateSettings(), test.expectedError)
+		})
+	}
+
+	assert.Error(t, MemberOptions{Version: "2.6.0"}.validate())
+	assert.NoError(t, MemberOptions{Version: "7.0.2"}.validate())
+}
+
+func TestVersionBinaryContext(t *testing.T) {
+	server := fakeServer(t, Cleanup{})
+	defer server.Stop()
+	server.proc.version = "7.0.2"
+	server.opts.CachePath = t.TempDir()
+
+	// Downloading another version gives up once ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := server.versionBinary(ctx, "8.0.0")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
@@ -0,0 +1,237 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/x/mongo/driver/dns"
+)
+
+// srvLookupTimeout bounds the SRV and TXT lookups the driver makes through
+// a server's DNS server
+const srvLookupTimeout = 5 * time.Second
+
+// reSRVMember matches the names the SRV records point at, e.g.
+// member0.memongo.test
+var reSRVMember = regexp.MustCompile(`^member(\d+)\.`)
+
+// reDomainLabel matches a label of a valid SRVDomain
+var reDomainLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+func validateSRVDomain(domain string) error {
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("invalid SRVDomain %q: must have at least two labels, e.g. memongo.test", domain)
+	}
+	for _, label := range labels {
+		if !reDomainLabel.MatchString(label) {
+			return fmt.Errorf("invalid SRVDomain %q: %q isn't a valid lowercase DNS label", domain, label)
+		}
+	}
+
+	return nil
+}
+
+// srvResolvers routes the driver's SRV and TXT lookups for the domains of
+// running servers to their DNS servers
+var srvResolvers = struct {
+	sync.Mutex
+	byDomain map[string]*net.Resolver
+	install  sync.Once
+}{byDomain: map[string]*net.Resolver{}}
+
+// srvResolverFor returns the resolver of the running server whose SRVDomain
+// name is in, or nil
+func srvResolverFor(name string) *net.Resolver {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	srvResolvers.Lock()
+	defer srvResolvers.Unlock()
+
+	for domain, resolver := range srvResolvers.byDomain {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return resolver
+		}
+	}
+
+	return nil
+}
+
+// installSRVLookups makes the driver, which resolves mongodb+srv URIs with
+// net.LookupSRV and net.LookupTXT and has no option to change that, look up
+// names in the SRVDomain of a running server through its DNS server. Other
+// names are looked up as before.
+func installSRVLookups() {
+	srvResolvers.install.Do(func() {
+		lookupSRV := dns.DefaultResolver.LookupSRV
+		lookupTXT := dns.DefaultResolver.LookupTXT
+
+		dns.DefaultResolver.LookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+			resolver := srvResolverFor(name)
+			if resolver == nil {
+				return lookupSRV(service, proto, name)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), srvLookupTimeout)
+			defer cancel()
+			return resolver.LookupSRV(ctx, service, proto, name)
+		}
+		dns.DefaultResolver.LookupTXT = func(name string) ([]string, error) {
+			resolver := srvResolverFor(name)
+			if resolver == nil {
+				return lookupTXT(name)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), srvLookupTimeout)
+			defer cancel()
+			return resolver.LookupTXT(ctx, name)
+		}
+	})
+}
+
+// startSRV starts the DNS server for Options.SRVDomain
+func (s *Server) startSRV() error {
+	domain := strings.ToLower(s.opts.SRVDomain)
+	srvDNS, err := newDNSServer(s.opts.SRVDNSPort, domain, func(name string) ([]dnsRecord, bool) {
+		return s.srvLookup(domain, name)
+	})
+	if err != nil {
+		return fmt.Errorf("error starting the DNS server for %s: %w", domain, err)
+	}
+	s.srvDNS = srvDNS
+
+	installSRVLookups()
+	srvResolvers.Lock()
+	srvResolvers.byDomain[domain] = s.SRVResolver()
+	srvResolvers.Unlock()
+
+	s.logger.Debugf("Serving SRV records for %s on %s", domain, srvDNS.addr())
+
+	return nil
+}
+
+// stopSRV stops the DNS server started by startSRV, if any
+func (s *Server) stopSRV() {
+	if s.srvDNS == nil {
+		return
+	}
+
+	srvResolvers.Lock()
+	delete(srvResolvers.byDomain, s.srvDNS.domain)
+	srvResolvers.Unlock()
+
+	s.srvDNS.close()
+}
+
+// srvMember is a replica set member named in the SRV records
+type srvMember struct {
+	index int
+	host  string
+	port  int
+}
+
+// srvMembers returns the server's members, in index order
+func (s *Server) srvMembers() []srvMember {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members := []srvMember{{index: 0, host: s.host, port: s.port}}
+	for index, proc := range s.members {
+		members = append(members, srvMember{index: index, host: proc.host, port: proc.port})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].index < members[j].index
+	})
+
+	return members
+}
+
+// srvLookup returns the records of name, a name in domain, the server's
+// SRVDomain: an SRV record for each member under _mongodb._tcp.<domain>, a TXT record
+// naming the replica set under <domain>, and the address of each member
+// under member<index>.<domain>
+func (s *Server) srvLookup(domain string, name string) ([]dnsRecord, bool) {
+	members := s.srvMembers()
+
+	switch name {
+	case "_mongodb._tcp." + domain:
+		records := make([]dnsRecord, len(members))
+		for i, member := range members {
+			records[i] = srvRecord(fmt.Sprintf("member%d.%s", member.index, domain), member.port)
+		}
+		return records, true
+	case domain:
+		if !s.isReplicaSet {
+			return nil, true
+		}
+		return []dnsRecord{txtRecord("replicaSet=" + s.replicaSetName)}, true
+	}
+
+	match := reSRVMember.FindStringSubmatch(name)
+	if match == nil || name != match[0]+domain {
+		return nil, false
+	}
+	index, _ := strconv.Atoi(match[1])
+	for _, member := range members {
+		if member.index != index {
+			continue
+		}
+
+		ip := net.ParseIP(member.host)
+		if ip == nil {
+			// PreferHostname members are on localhost
+			ip = net.IPv4(127, 0, 0, 1)
+		}
+		return []dnsRecord{addressRecord(ip)}, true
+	}
+
+	return nil, false
+}
+
+// SRVURI returns a mongodb+srv URI for the server, which resolves through
+// the DNS server memongo runs for Options.SRVDomain. It returns "" without
+// SRVDomain. Clients must resolve the member names the SRV records point at
+// with SRVResolver; SRVClientOptions sets that up.
+func (s *Server) SRVURI() string {
+	if s.srvDNS == nil {
+		return ""
+	}
+
+	return srvURI(s.srvDNS.domain).param("tls", "false").String()
+}
+
+// SRVResolver returns a resolver that looks up names through the DNS server
+// memongo runs for Options.SRVDomain, or nil without SRVDomain
+func (s *Server) SRVResolver() *net.Resolver {
+	if s.srvDNS == nil {
+		return nil
+	}
+
+	addr := s.srvDNS.addr()
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", addr)
+		},
+	}
+}
+
+// SRVClientOptions returns client options that connect with SRVURI,
+// resolving the member names in the SRV records with SRVResolver. It returns
+// nil without Options.SRVDomain.
+func (s *Server) SRVClientOptions() *options.ClientOptions {
+	if s.srvDNS == nil {
+		return nil
+	}
+
+	return options.Client().ApplyURI(s.SRVURI()).SetDialer(&net.Dialer{Resolver: s.SRVResolver()})
+}
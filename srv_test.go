@@ -0,0 +1,108 @@
+package memongo
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/x/mongo/driver/dns"
+)
+
+// fakeSRVServer returns a server serving SRV records for domain, without
+// mongod
+func fakeSRVServer(t *testing.T, domain string, replicaSet bool) *Server {
+	t.Helper()
+
+	s := &Server{
+		host:           "127.0.0.1",
+		port:           27017,
+		isReplicaSet:   replicaSet,
+		replicaSetName: "rs0",
+		members:        map[int]*mongodProcess{2: {host: "127.0.0.1", port: 27019}},
+		logger:         memongolog.New(nil, memongolog.LogLevelSilent),
+		opts:           Options{SRVDomain: domain},
+	}
+	require.NoError(t, s.startSRV())
+	t.Cleanup(s.stopSRV)
+
+	return s
+}
+
+func TestSRVRecords(t *testing.T) {
+	s := fakeSRVServer(t, "memongo.test", true)
+	resolver := s.SRVResolver()
+	ctx := context.Background()
+
+	_, addrs, err := resolver.LookupSRV(ctx, "mongodb", "tcp", "memongo.test")
+	require.NoError(t, err)
+	require.Len(t, addrs, 2)
+	assert.Equal(t, &net.SRV{Target: "member0.memongo.test.", Port: 27017}, addrs[0])
+	assert.Equal(t, &net.SRV{Target: "member2.memongo.test.", Port: 27019}, addrs[1])
+
+	txt, err := resolver.LookupTXT(ctx, "memongo.test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"replicaSet=rs0"}, txt)
+
+	hosts, err := resolver.LookupHost(ctx, "member2.memongo.test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1"}, hosts)
+
+	_, err = resolver.LookupHost(ctx, "member5.memongo.test")
+	var dnsErr *net.DNSError
+	require.ErrorAs(t, err, &dnsErr)
+	assert.True(t, dnsErr.IsNotFound)
+
+	// Names outside the domain are refused
+	_, err = resolver.LookupHost(ctx, "example.invalid")
+	assert.Error(t, err)
+}
+
+func TestSRVStandalone(t *testing.T) {
+	s := fakeSRVServer(t, "standalone.memongo.test", false)
+
+	txt, err := s.SRVResolver().LookupTXT(context.Background(), "standalone.memongo.test")
+	assert.Error(t, err)
+	assert.Empty(t, txt)
+}
+
+func TestSRVDriverLookups(t *testing.T) {
+	s := fakeSRVServer(t, "memongo.test", true)
+	assert.Equal(t, "mongodb+srv://memongo.test/?tls=false", s.SRVURI())
+
+	// The driver's lookups go through the server's DNS server
+	hosts, err := dns.DefaultResolver.ParseHosts("memongo.test", "mongodb", true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"member0.memongo.test:27017", "member2.memongo.test:27019"}, hosts)
+
+	clientOpts := s.SRVClientOptions()
+	require.NoError(t, clientOpts.Validate())
+	assert.Equal(t, hosts, clientOpts.Hosts)
+	require.NotNil(t, clientOpts.ReplicaSet)
+	assert.Equal(t, "rs0", *clientOpts.ReplicaSet)
+
+	// Once stopped, the domain is looked up as before
+	s.stopSRV()
+	assert.Nil(t, srvResolverFor("memongo.test"))
+}
+
+func TestSRVDisabled(t *testing.T) {
+	s := &Server{}
+	assert.Empty(t, s.SRVURI())
+	assert.Nil(t, s.SRVResolver())
+	assert.Nil(t, s.SRVClientOptions())
+}
+
+func TestValidateSRVDomain(t *testing.T) {
+	for _, domain := range []string{"memongo.test", "db.memongo.test", "a-b.test"} {
+		assert.NoError(t, (&Options{MongodBin: "/bin/mongod", SRVDomain: domain}).Validate(), domain)
+	}
+	for _, domain := range []string{"localhost", "Memongo.test", "memongo..test", "-a.test", "memongo.test."} {
+		assert.Error(t, (&Options{MongodBin: "/bin/mongod", SRVDomain: domain}).Validate(), domain)
+	}
+
+	assert.Error(t, (&Options{MongodBin: "/bin/mongod", SRVDNSPort: 5353}).Validate())
+	assert.Error(t, (&Options{MongodBin: "/bin/mongod", SRVDomain: "memongo.test", SRVDNSPort: 70000}).Validate())
+}
@@ -0,0 +1,188 @@
+package memongo
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DNS record types and response codes the SRV simulation uses
+const (
+	dnsTypeA    = 1
+	dnsTypeTXT  = 16
+	dnsTypeAAAA = 28
+	dnsTypeSRV  = 33
+
+	dnsClassIN = 1
+
+	dnsRcodeNameError = 3
+	dnsRcodeRefused   = 5
+
+	// dnsTTL is the TTL of every record, in seconds. It's short since the
+	// members may change.
+	dnsTTL = 1
+)
+
+// dnsRecord is a record the SRV simulation serves. rdata is encoded.
+type dnsRecord struct {
+	rtype uint16
+	rdata []byte
+}
+
+// dnsServer answers DNS queries over UDP for names under a domain, from the
+// records returned by lookup
+type dnsServer struct {
+	conn   net.PacketConn
+	domain string
+	lookup func(name string) ([]dnsRecord, bool)
+}
+
+// newDNSServer listens for DNS queries on 127.0.0.1:port, or a free port if
+// port is 0
+func newDNSServer(port int, domain string, lookup func(string) ([]dnsRecord, bool)) (*dnsServer, error) {
+	conn, err := net.ListenPacket("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+
+	d := &dnsServer{conn: conn, domain: strings.ToLower(domain), lookup: lookup}
+	goTracked("SRV DNS server on "+conn.LocalAddr().String(), d.serve)
+
+	return d, nil
+}
+
+func (d *dnsServer) addr() string {
+	return d.conn.LocalAddr().String()
+}
+
+func (d *dnsServer) close() {
+	_ = d.conn.Close()
+}
+
+func (d *dnsServer) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		resp, err := d.respond(buf[:n])
+		if err != nil {
+			continue
+		}
+		_, _ = d.conn.WriteTo(resp, addr)
+	}
+}
+
+// respond returns the response to the query in msg
+func (d *dnsServer) respond(msg []byte) ([]byte, error) {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg[4:6]) != 1 {
+		return nil, errors.New("expected a query with one question")
+	}
+
+	name, end, err := readDNSName(msg, 12)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) < end+4 {
+		return nil, errors.New("truncated question")
+	}
+	qtype := binary.BigEndian.Uint16(msg[end : end+2])
+	question := msg[12 : end+4]
+
+	var answers []dnsRecord
+	rcode := 0
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name != d.domain && !strings.HasSuffix(name, "."+d.domain) {
+		rcode = dnsRcodeRefused
+	} else if records, ok := d.lookup(name); !ok {
+		rcode = dnsRcodeNameError
+	} else {
+		for _, r := range records {
+			if r.rtype == qtype {
+				answers = append(answers, r)
+			}
+		}
+	}
+
+	// The response echoes the query's ID, opcode, RD flag and question, and
+	// is authoritative
+	flags := 0x8000 | 0x0400 | binary.BigEndian.Uint16(msg[2:4])&0x7900 | uint16(rcode)
+	resp := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(resp[0:2], binary.BigEndian.Uint16(msg[0:2]))
+	binary.BigEndian.PutUint16(resp[2:4], flags)
+	binary.BigEndian.PutUint16(resp[4:6], 1)
+	binary.BigEndian.PutUint16(resp[6:8], uint16(len(answers)))
+	resp = append(resp, question...)
+
+	for _, r := range answers {
+		// The name is a pointer to the question's
+		resp = append(resp, 0xc0, 12)
+		resp = appendUint16(resp, r.rtype)
+		resp = appendUint16(resp, dnsClassIN)
+		resp = append(resp, 0, 0, 0, dnsTTL)
+		resp = appendUint16(resp, uint16(len(r.rdata)))
+		resp = append(resp, r.rdata...)
+	}
+
+	return resp, nil
+}
+
+// readDNSName reads the uncompressed name at offset in msg, and returns it
+// and the offset after it
+func readDNSName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	for {
+		if offset >= len(msg) {
+			return "", 0, errors.New("truncated name")
+		}
+		n := int(msg[offset])
+		offset++
+		if n == 0 {
+			return strings.Join(labels, "."), offset, nil
+		}
+		if n > 63 || offset+n > len(msg) {
+			return "", 0, errors.New("invalid name")
+		}
+		labels = append(labels, string(msg[offset:offset+n]))
+		offset += n
+	}
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendDNSName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+
+	return append(b, 0)
+}
+
+func srvRecord(target string, port int) dnsRecord {
+	// Priority and weight are 0
+	rdata := []byte{0, 0, 0, 0}
+	rdata = appendUint16(rdata, uint16(port))
+	rdata = appendDNSName(rdata, target)
+
+	return dnsRecord{rtype: dnsTypeSRV, rdata: rdata}
+}
+
+func txtRecord(text string) dnsRecord {
+	return dnsRecord{rtype: dnsTypeTXT, rdata: append([]byte{byte(len(text))}, text...)}
+}
+
+// addressRecord returns the A or AAAA record of ip
+func addressRecord(ip net.IP) dnsRecord {
+	if ip4 := ip.To4(); ip4 != nil {
+		return dnsRecord{rtype: dnsTypeA, rdata: ip4}
+	}
+
+	return dnsRecord{rtype: dnsTypeAAAA, rdata: ip.To16()}
+}
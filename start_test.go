@@ -0,0 +1,150 @@
+package memongo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/100mslive/memongo/v2/mongobin"
+	"github.com/100mslive/memongo/v2/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryableStartError(t *testing.T) {
+	assert.True(t, isRetryableStartError(fmt.Errorf("%w after 10s", ErrStartupTimeout)))
+	assert.True(t, isRetryableStartError(fmt.Errorf("mongod startup failed: %w", ErrPortInUse)))
+	assert.True(t, isRetryableStartError(fmt.Errorf("%w: connection reset", mongobin.ErrTransientDownload)))
+
+	assert.False(t, isRetryableStartError(errors.New("invalid replica set name")))
+	assert.False(t, isRetryableStartError(fmt.Errorf("%w: rs0 != rs1", ErrReplicaSetNameMismatch)))
+}
+
+func TestStartFailure(t *testing.T) {
+	first := fmt.Errorf("%w after 1s", ErrStartupTimeout)
+	assert.Equal(t, first, startFailure([]error{first}))
+
+	last := fmt.Errorf("mongod startup failed: %w", ErrPortInUse)
+	err := startFailure([]error{first, last})
+	assert.EqualError(t, err, "mongod failed to start after 2 attempts (attempt 1: timed out waiting for mongod to start after 1s): mongod startup failed: port in use")
+	assert.ErrorIs(t, err, ErrPortInUse)
+}
+
+// recordingPolicy retries at once until giveUpAfter retries, recording the
+// retries it's asked about
+type recordingPolicy struct {
+	giveUpAfter int
+	asked       []int
+}
+
+func (p *recordingPolicy) Next(retries int, _ time.Duration) (time.Duration, bool) {
+	p.asked = append(p.asked, retries)
+	return 0, retries <= p.giveUpAfter
+}
+
+func TestStartRetryPolicy(t *testing.T) {
+	start := func(retries int, policy retry.Policy) error {
+		_, err := StartWithOptions(&Options{
+			DownloadOptions: DownloadOptions{
+				DownloadURL: "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-ubuntu2204-8.0.0.tgz",
+				CachePath:   t.TempDir(),
+				Downloader:  transientDownloader{},
+			},
+			StartRetries:     retries,
+			StartRetryPolicy: policy,
+			LogLevel:         memongolog.LogLevelSilent,
+		})
+		return err
+	}
+
+	// The policy may give up before StartRetries
+	policy := &recordingPolicy{giveUpAfter: 1}
+	err := start(3, policy)
+	assert.ErrorIs(t, err, mongobin.ErrTransientDownload)
+	assert.Contains(t, err.Error(), "after 2 attempts")
+	assert.Equal(t, []int{1, 2}, policy.asked)
+
+	// but not retry more often
+	policy = &recordingPolicy{giveUpAfter: 10}
+	err = start(2, policy)
+	assert.Contains(t, err.Error(), "after 3 attempts")
+	assert.Equal(t, []int{1, 2}, policy.asked)
+}
+
+func TestValidateStartRetries(t *testing.T) {
+	opts := &Options{MongodBin: "/bin/mongod", StartRetries: -1}
+	assert.Error(t, opts.Validate())
+}
+
+func TestStartReportSummary(t *testing.T) {
+	report := StartReport{
+		Attempts: 1,
+		Duration: 1830 * time.Millisecond,
+		Version:  "8.0.0",
+		URI:      "mongodb://localhost:54321",
+		CacheHit: true,
+	}
+	assert.Equal(t, "mongod 8.0.0 ready at mongodb://localhost:54321 (cache hit, 1.8s)", report.Summary())
+
+	report = StartReport{Attempts: 2, Duration: 12 * time.Second, URI: "mongodb://localhost:1234", Downloaded: true}
+	assert.Equal(t, "mongod ready at mongodb://localhost:1234 (downloaded, 2 attempts, 12s)", report.Summary())
+
+	report = StartReport{Attempts: 1, Duration: 500 * time.Millisecond, Version: "7.0.0", URI: "mongodb://localhost:1234"}
+	assert.Equal(t, "mongod 7.0.0 ready at mongodb://localhost:1234 (MongodBin, 500ms)", report.Summary())
+
+	report = StartReport{Attempts: 1, Duration: 3 * time.Second, QueueTime: 2100 * time.Millisecond, URI: "mongodb://localhost:1234", CacheHit: true}
+	assert.Equal(t, "mongod ready at mongodb://localhost:1234 (cache hit, queued 2.1s, 3s)", report.Summary())
+}
+
+func TestLogSummaryOnlyFailure(t *testing.T) {
+	out := &bytes.Buffer{}
+	_, err := StartWithOptions(&Options{
+		MongodBin:      "/bin/false",
+		StartupTimeout: 200 * time.Millisecond,
+		Logger:         log.New(out, "", 0),
+		LogLevel:       memongolog.LogLevelDebug,
+		LogSummaryOnly: true,
+	})
+	require.Error(t, err)
+
+	// The messages held back while starting are written when it fails
+	assert.Contains(t, out.String(), "Starting MongoDB with options")
+}
+
+func TestStartWithContextCanceled(t *testing.T) {
+	shortLeakCheck(t)
+
+	// The script never reports that it's listening
+	binPath := writeScript(t, `if [ "$1" = "--version" ]; then echo "db version v8.0.0"; echo "Build Info: {}"; exit 0; fi
+exec sleep 30`)
+	base := t.TempDir()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := StartWithContext(ctx, &Options{
+		MongodBin:      binPath,
+		TempDirBase:    base,
+		StartupTimeout: 30 * time.Second,
+		StartRetries:   2,
+		LogLevel:       memongolog.LogLevelSilent,
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotErrorIs(t, err, ErrStartupTimeout)
+	assert.Less(t, time.Since(start), 10*time.Second)
+
+	// mongod was killed and its data directory removed
+	entries, err := os.ReadDir(base)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	VerifyNoLeaks(t)
+
+	_, err = StartWithContext(ctx, &Options{MongodBin: binPath})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
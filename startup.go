@@ -0,0 +1,205 @@
+package memongo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"time"
+
+	"github.com/100mslive/memongo/v2/retry"
+)
+
+// Clock is the time source of the startup timeouts. Options.Clock replaces
+// the system clock, so tests of how a wrapper handles a timeout don't depend
+// on timing; see memongotest.FakeClock. Only when timeouts expire is decided
+// by the Clock: polling still runs in real time. It's retry.Clock, so the
+// same Clock can time retry.DoWithClock.
+type Clock = retry.Clock
+
+// startupWait is how launchMongod waits for mongod to report that it's
+// listening
+type startupWait struct {
+	// clock decides when the timeouts expire. Defaults to the system clock.
+	clock Clock
+
+	// timeout is how long to wait. In adaptive mode, it's how long mongod
+	// may go without logging progress.
+	timeout time.Duration
+
+	// adaptive extends the deadline while mongod logs progress, up to
+	// hardTimeout
+	adaptive    bool
+	hardTimeout time.Duration
+
+	// pollInterval caps the backoff between attempts to connect to the port
+	// mongod reports, and dialTimeout bounds each attempt
+	pollInterval time.Duration
+	dialTimeout  time.Duration
+}
+
+func (opts *Options) startupWait() startupWait {
+	return startupWait{
+		clock:        opts.Clock,
+		timeout:      opts.StartupTimeout,
+		adaptive:     opts.AdaptiveStartupTimeout,
+		hardTimeout:  opts.StartupHardTimeout,
+		pollInterval: opts.StartupPollInterval,
+		dialTimeout:  opts.StartupDialTimeout,
+	}
+}
+
+// startupPhases names the startup phase mongod is in from the component of
+// its log lines
+var startupPhases = map[string]string{
+	"CONTROL":  "startup",
+	"STORAGE":  "storage engine startup",
+	"RECOVERY": "recovery",
+	"WTRECOV":  "recovery",
+	"INDEX":    "index builds",
+	"REPL":     "replication startup",
+	"INITSYNC": "initial sync",
+	"FTDC":     "diagnostics startup",
+}
+
+var (
+	// reLogComponent matches the component of a structured (4.4+) log line
+	reLogComponent = regexp.MustCompile(`"c":"([A-Z]+)\s*"`)
+
+	// reLegacyLogComponent matches the component of a plain text log line,
+	// e.g. "2020-01-01T00:00:00.000+0000 I  STORAGE  [initandlisten] ..."
+	reLegacyLogComponent = regexp.MustCompile(`^\S+\s+[A-Z]\s+([A-Z]+)\s+\[`)
+)
+
+// parseStartupPhase returns the startup phase a mongod log line shows
+// progress in, if it's one memongo recognizes
+func parseStartupPhase(line string) (string, bool) {
+	for _, re := range []*regexp.Regexp{reLogComponent, reLegacyLogComponent} {
+		if match := re.FindStringSubmatch(line); match != nil {
+			phase, ok := startupPhases[match[1]]
+			return phase, ok
+		}
+	}
+
+	return "", false
+}
+
+// waitForStartup waits for the port and addresses mongod reports once it's
+// listening, a startup error, the timeouts in w to expire, or ctx to be done.
+// progressCh receives the phase of each log line showing progress.
+func waitForStartup(ctx context.Context, w startupWait, readyCh <-chan listening, errCh <-chan error, progressCh <-chan string) (listening, error) {
+	clock := w.timeSource()
+	stalled := clock.After(w.timeout)
+
+	var hardDeadline <-chan time.Time
+	if w.adaptive {
+		hardDeadline = clock.After(w.hardTimeout)
+	}
+
+	// In adaptive mode, progress moves the stall deadline to lastProgress
+	// plus the timeout, which is checked when the timer fires
+	lastProgress := clock.Now()
+	phase := ""
+	for {
+		select {
+		case ready := <-readyCh:
+			return ready, nil
+		case err := <-errCh:
+			return listening{}, err
+		case phase = <-progressCh:
+			lastProgress = clock.Now()
+		case <-stalled:
+			if w.adaptive {
+				if remaining := w.timeout - clock.Now().Sub(lastProgress); remaining > 0 {
+					stalled = clock.After(remaining)
+					continue
+				}
+				return listening{}, fmt.Errorf("%w: no progress logged for %s during %s", ErrStartupTimeout, w.timeout, phaseOrUnknown(phase))
+			}
+			if phase != "" {
+				return listening{}, fmt.Errorf("%w after %s, during %s", ErrStartupTimeout, w.timeout, phase)
+			}
+			return listening{}, fmt.Errorf("%w after %s", ErrStartupTimeout, w.timeout)
+		case <-hardDeadline:
+			return listening{}, fmt.Errorf("%w: still in %s after StartupHardTimeout of %s", ErrStartupTimeout, phaseOrUnknown(phase), w.hardTimeout)
+		case <-ctx.Done():
+			return listening{}, fmt.Errorf("gave up waiting for mongod to start: %w", ctx.Err())
+		}
+	}
+}
+
+// timeSource returns w.clock, or the system clock if it isn't set
+func (w startupWait) timeSource() Clock {
+	if w.clock == nil {
+		return retry.SystemClock{}
+	}
+
+	return w.clock
+}
+
+func phaseOrUnknown(phase string) string {
+	if phase == "" {
+		return "an unknown phase"
+	}
+
+	return phase
+}
+
+// initialPortPollInterval is how long waitForPort waits after its first
+// failed attempt. The wait doubles after each attempt, up to
+// startupWait.pollInterval.
+const initialPortPollInterval = 5 * time.Millisecond
+
+// portWait is how waiting for mongod's port to accept connections went
+type portWait struct {
+	attempts int
+	waited   time.Duration
+}
+
+// waitForPort dials addr until a connection succeeds, w.timeout expires, or
+// ctx is done. The timeout is measured by w.clock, but the backoff between
+// attempts is real time, so a fake clock doesn't stall polling.
+func waitForPort(ctx context.Context, w startupWait, addr string) (portWait, error) {
+	clock := w.timeSource()
+	start := clock.Now()
+	initial := initialPortPollInterval
+	if initial > w.pollInterval {
+		initial = w.pollInterval
+	}
+	policy := retry.MaxElapsed{
+		Policy: retry.Exponential{Initial: initial, Max: w.pollInterval},
+		Limit:  w.timeout,
+	}
+
+	dialer := net.Dialer{Timeout: w.dialTimeout}
+	var result portWait
+	err := retry.DoWithClock(ctx, realTimeWaits{clock}, policy, func() error {
+		result.attempts++
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		_ = conn.Close()
+		return nil
+	})
+	result.waited = clock.Now().Sub(start)
+	if err == nil {
+		return result, nil
+	}
+	if ctx.Err() != nil {
+		return result, fmt.Errorf("gave up waiting for %s to accept connections: %w", addr, ctx.Err())
+	}
+
+	return result, fmt.Errorf("%w: %s wasn't accepting connections after %s (%d attempts): %s", ErrStartupTimeout, addr, result.waited.Round(time.Millisecond), result.attempts, err)
+}
+
+// realTimeWaits tells the time with a Clock, but waits in real time, so
+// a fake clock decides when polling times out without stalling it
+type realTimeWaits struct {
+	Clock
+}
+
+func (realTimeWaits) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,212 @@
+package memongo
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/100mslive/memongo/v2/memongolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStartupPhase(t *testing.T) {
+	phase, ok := parseStartupPhase(`{"t":{"$date":"2024-05-01T10:00:03.000+00:00"},"s":"I",  "c":"INDEX",    "id":20384, "msg":"Index build: starting"}`)
+	assert.True(t, ok)
+	assert.Equal(t, "index builds", phase)
+
+	phase, ok = parseStartupPhase(`2019-08-01T10:00:01.000+0000 I  RECOVERY [initandlisten] WiredTiger recoveryTimestamp. Ts: Timestamp(0, 0)`)
+	assert.True(t, ok)
+	assert.Equal(t, "recovery", phase)
+
+	_, ok = parseStartupPhase(`{"t":{"$date":"2024-05-01T10:00:06.000+00:00"},"s":"I",  "c":"NETWORK",  "id":23016, "msg":"Waiting for connections"}`)
+	assert.False(t, ok)
+
+	_, ok = parseStartupPhase("not a log line")
+	assert.False(t, ok)
+}
+
+// replayStartupLog writes the first n lines of a log fixture to w, one every
+// interval. n < 0 writes all of them.
+func replayStartupLog(t *testing.T, w io.Writer, name string, n int, interval time.Duration) {
+	t.Helper()
+
+	f, err := os.Open(filepath.Join("testdata", "startup", name))
+	require.NoError(t, err)
+
+	go func() {
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for i := 0; scanner.Scan() && (n < 0 || i < n); i++ {
+			time.Sleep(interval)
+			_, _ = w.Write([]byte(scanner.Text() + "\n"))
+		}
+	}()
+}
+
+func waitForReplayedStartup(t *testing.T, name string, n int, interval time.Duration, wait startupWait) (listening, error) {
+	t.Helper()
+
+	caps := capabilityTable[len(capabilityTable)-1]
+	if name == "legacy.log" {
+		caps = capabilityTable[0]
+	}
+
+	stdout, errCh, readyCh, _, progressCh := stdoutHandler(memongolog.New(nil, memongolog.LogLevelSilent), caps.reReady)
+	t.Cleanup(func() {
+		_ = stdout.Close()
+	})
+	replayStartupLog(t, stdout, name, n, interval)
+
+	return waitForStartup(context.Background(), wait, readyCh, errCh, progressCh)
+}
+
+func TestWaitForStartupAdaptive(t *testing.T) {
+	interval := 60 * time.Millisecond
+	adaptive := startupWait{timeout: 150 * time.Millisecond, adaptive: true, hardTimeout: 5 * time.Second}
+
+	for _, name := range []string{"recovery.log", "legacy.log"} {
+		t.Run(name, func(t *testing.T) {
+			// Startup takes longer than the timeout, but keeps making progress
+			ready, err := waitForReplayedStartup(t, name, -1, interval, adaptive)
+			require.NoError(t, err)
+			assert.Equal(t, 27017, ready.port)
+			assert.Equal(t, []string{"127.0.0.1"}, ready.addresses)
+
+			_, err = waitForReplayedStartup(t, name, -1, interval, startupWait{timeout: 150 * time.Millisecond})
+			assert.ErrorIs(t, err, ErrStartupTimeout)
+		})
+	}
+}
+
+func TestWaitForStartupStalled(t *testing.T) {
+	// Stop after the index build starts
+	_, err := waitForReplayedStartup(t, "recovery.log", 5, 20*time.Millisecond, startupWait{timeout: 150 * time.Millisecond, adaptive: true, hardTimeout: 5 * time.Second})
+	require.ErrorIs(t, err, ErrStartupTimeout)
+	assert.Contains(t, err.Error(), "no progress logged for 150ms during index builds")
+}
+
+func TestWaitForStartupHardTimeout(t *testing.T) {
+	_, err := waitForReplayedStartup(t, "recovery.log", 7, 60*time.Millisecond, startupWait{timeout: 150 * time.Millisecond, adaptive: true, hardTimeout: 200 * time.Millisecond})
+	require.ErrorIs(t, err, ErrStartupTimeout)
+	assert.Contains(t, err.Error(), "after StartupHardTimeout of 200ms")
+}
+
+func TestValidateStartupHardTimeout(t *testing.T) {
+	assert.Error(t, (&Options{MongodBin: "/bin/mongod", StartupHardTimeout: time.Minute}).Validate())
+	assert.Error(t, (&Options{MongodBin: "/bin/mongod", AdaptiveStartupTimeout: true, StartupTimeout: time.Minute, StartupHardTimeout: time.Second}).Validate())
+	assert.NoError(t, (&Options{MongodBin: "/bin/mongod", AdaptiveStartupTimeout: true, StartupHardTimeout: time.Minute}).Validate())
+}
+
+func TestValidateStartupPolling(t *testing.T) {
+	assert.Error(t, (&Options{MongodBin: "/bin/mongod", StartupPollInterval: -time.Second}).Validate())
+	assert.Error(t, (&Options{MongodBin: "/bin/mongod", StartupDialTimeout: -time.Second}).Validate())
+	assert.NoError(t, (&Options{MongodBin: "/bin/mongod", StartupPollInterval: time.Second, StartupDialTimeout: time.Second}).Validate())
+}
+
+// closedAddr returns an address on which nothing is listening
+func closedAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	return addr
+}
+
+func TestWaitForPortBackoff(t *testing.T) {
+	addr := closedAddr(t)
+
+	// Only start accepting connections after a delay
+	delay := 300 * time.Millisecond
+	go func() {
+		time.Sleep(delay)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() {
+			_ = l.Close()
+		})
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	wait := startupWait{timeout: 5 * time.Second, pollInterval: 50 * time.Millisecond, dialTimeout: time.Second}
+	result, err := waitForPort(context.Background(), wait, addr)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, result.waited, delay)
+
+	// 5, 10, 20 and 40ms, then every 50ms: about 10 attempts in 300ms. A
+	// fixed 5ms interval would take 60.
+	assert.Greater(t, result.attempts, 5)
+	assert.Less(t, result.attempts, 20)
+}
+
+func TestWaitForPortTimeout(t *testing.T) {
+	addr := closedAddr(t)
+
+	wait := startupWait{timeout: 200 * time.Millisecond, pollInterval: 50 * time.Millisecond, dialTimeout: time.Second}
+	result, err := waitForPort(context.Background(), wait, addr)
+	require.ErrorIs(t, err, ErrStartupTimeout)
+	assert.Contains(t, err.Error(), addr+" wasn't accepting connections after")
+	assert.Contains(t, err.Error(), "attempts): ")
+	assert.Greater(t, result.attempts, 1)
+	assert.Less(t, result.waited, 300*time.Millisecond)
+}
+
+func TestWaitForStartupCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := waitForStartup(ctx, startupWait{timeout: 10 * time.Second}, nil, nil, nil)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, ErrStartupTimeout)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestWaitForPortCancelled(t *testing.T) {
+	addr := closedAddr(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	wait := startupWait{timeout: 10 * time.Second, pollInterval: time.Second, dialTimeout: time.Second}
+	result, err := waitForPort(ctx, wait, addr)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, result.waited, time.Second)
+}
+
+func TestLaunchMongodCancelled(t *testing.T) {
+	shortLeakCheck(t)
+
+	dbDir, err := mkdirTemp(t.TempDir(), "memongo", "data directory")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	// sleep never reports that it's listening
+	start := time.Now()
+	_, err = launchMongod(ctx, "sleep", []string{"30"}, nil, dbDir, false, 0, "", reReady, startupWait{timeout: 30 * time.Second}, memongolog.New(nil, memongolog.LogLevelSilent), newEventBus(nil))
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), 10*time.Second)
+
+	// The process was killed and the data directory removed
+	assert.NoDirExists(t, dbDir)
+	VerifyNoLeaks(t)
+}
@@ -0,0 +1,124 @@
+package memongo
+
+import (
+	"github.com/100mslive/memongo/v2/mongobin"
+)
+
+// VersionRange is a range of MongoDB versions memongo runs the same way
+type VersionRange struct {
+	// Min is the first version in the range, and Max the first version after
+	// it, or "" for the newest range
+	Min string
+	Max string
+
+	// EphemeralForTest is true if the ephemeralForTest storage engine is
+	// available. It was removed in 6.1.
+	EphemeralForTest bool
+
+	// NoJournal is true if journaling can be turned off
+	NoJournal bool
+
+	// CacheSizePct is true if the WiredTiger cache can be sized as a
+	// percentage of memory
+	CacheSizePct bool
+
+	// StructuredLogs is true if mongod logs JSON
+	StructuredLogs bool
+}
+
+// PlatformSpec is a platform memongo can download mongod for, and the
+// versions it can download
+type PlatformSpec struct {
+	// GOOS and GOARCH are the Go names of the platform
+	GOOS   string
+	GOARCH string
+
+	// Distro is the Linux distro, as named in MongoDB's download URLs (for
+	// example "ubuntu2204"), or "" for macOS and generic Linux
+	Distro string
+
+	// Arch is the architecture of the build that's downloaded. Apple Silicon
+	// runs the x86_64 build under Rosetta 2.
+	Arch string
+
+	// MinVersion is the first version available, and MaxVersion the first
+	// one that isn't, or "" if there's no upper bound
+	MinVersion string
+	MaxVersion string
+}
+
+// SupportedMongoVersions returns the ranges of MongoDB versions memongo can
+// run, oldest first. Versions before the first range aren't supported.
+func SupportedMongoVersions() []VersionRange {
+	ranges := make([]VersionRange, len(capabilityTable))
+	for i, c := range capabilityTable {
+		ranges[i] = VersionRange{
+			Min:              formatVersion(c.minVersion),
+			EphemeralForTest: c.ephemeralForTest,
+			NoJournal:        c.noJournal,
+			CacheSizePct:     c.cacheSizePct,
+			StructuredLogs:   c.reReady == reReadyStructured,
+		}
+		if i+1 < len(capabilityTable) {
+			ranges[i].Max = formatVersion(capabilityTable[i+1].minVersion)
+		}
+	}
+
+	return ranges
+}
+
+// SupportedPlatforms returns the platforms memongo can download mongod for.
+// Other platforms need DownloadOptions.DownloadURL or Options.MongodBin.
+func SupportedPlatforms() []PlatformSpec {
+	var platforms []PlatformSpec
+	for _, b := range mongobin.SupportedBuilds() {
+		if b.GOOS == "darwin" && b.GOARCH == "arm64" {
+			// Apple Silicon uses the x86_64 build instead, below
+			continue
+		}
+
+		platforms = append(platforms, PlatformSpec{
+			GOOS:       b.GOOS,
+			GOARCH:     b.GOARCH,
+			Distro:     b.OSName,
+			Arch:       b.Arch,
+			MinVersion: b.MinVersion,
+			MaxVersion: b.MaxVersion,
+		})
+		if b.GOOS == "darwin" && b.GOARCH == "amd64" {
+			rosetta := platforms[len(platforms)-1]
+			rosetta.GOARCH = "arm64"
+			platforms = append(platforms, rosetta)
+		}
+	}
+
+	return platforms
+}
+
+// Supports returns whether memongo can download and run the MongoDB version
+// on the platform, and if it can't, why. distro is the Linux distro as in
+// PlatformSpec.Distro.
+func Supports(version string, goos string, goarch string, distro string) (bool, string) {
+	_, err := capabilitiesForVersion(version)
+	if err != nil {
+		return false, err.Error()
+	}
+
+	goos, goarch = downloadPlatform(goos, goarch)
+	_, err = mongobin.MakeDownloadSpecFor(version, goos, goarch, distro)
+	if err != nil {
+		return false, err.Error()
+	}
+
+	return true, ""
+}
+
+// downloadPlatform returns the platform whose build is downloaded for goos
+// and goarch. Apple Silicon uses the x86_64 build via Rosetta 2.
+func downloadPlatform(goos string, goarch string) (string, string) {
+	if goos == "darwin" && goarch == "arm64" {
+		return "darwin", "amd64"
+	}
+
+	return goos, goarch
+}
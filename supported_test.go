@@ -0,0 +1,177 @@
+package memongo
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/100mslive/memongo/v2/mongobin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var supportedVersionGrid = []string{
+	"3.2.0", "3.2.7", "3.4.0", "3.6.5", "4.0.0", "4.0.1", "4.0.26", "4.0.27",
+	"4.2.0", "4.2.1", "4.2.13", "4.4.0", "4.4.4", "5.0.8", "6.0.0", "6.0.4",
+	"6.1.0", "7.0.0", "8.0.0",
+}
+
+// distroReleases are os-release contents MakeDownloadSpec detects as each
+// distro
+var distroReleases = map[string]string{
+	"ubuntu2204": "ID=ubuntu\nVERSION_ID=\"22.04\"\n",
+	"ubuntu2004": "ID=ubuntu\nVERSION_ID=\"20.04\"\n",
+	"ubuntu1804": "ID=ubuntu\nVERSION_ID=\"18.04\"\n",
+	"ubuntu1604": "ID=ubuntu\nVERSION_ID=\"16.04\"\n",
+	"ubuntu1404": "ID=ubuntu\nVERSION_ID=\"14.04\"\n",
+	"suse12":     "ID=sles\nVERSION_ID=\"12.3\"\n",
+	"rhel80":     "ID=centos\nVERSION_ID=\"8\"\n",
+	"rhel70":     "ID=rhel\nVERSION_ID=\"7.9\"\n",
+	"debian11":   "ID=debian\nVERSION_ID=\"11\"\n",
+	"debian10":   "ID=debian\nVERSION_ID=\"10\"\n",
+	"debian92":   "ID=debian\nVERSION_ID=\"9\"\n",
+	"debian81":   "ID=debian\nVERSION_ID=\"8\"\n",
+	"amazon2":    "ID=amzn\nVERSION_ID=\"2\"\n",
+	"amazon":     "ID=amzn\nVERSION_ID=\"2018.03\"\n",
+	"":           "ID=arch\nVERSION_ID=\"1\"\n",
+}
+
+func inVersionRange(t *testing.T, version string, min string, max string) bool {
+	parsed, err := mongobin.ParseVersion(version)
+	require.NoError(t, err)
+	parsedMin, err := mongobin.ParseVersion(min)
+	require.NoError(t, err)
+	if !versionAtLeast(parsed, parsedMin) {
+		return false
+	}
+	if max == "" {
+		return true
+	}
+	parsedMax, err := mongobin.ParseVersion(max)
+	require.NoError(t, err)
+
+	return !versionAtLeast(parsed, parsedMax)
+}
+
+func TestSupportedPlatformsMatchDownloadSpec(t *testing.T) {
+	dir := t.TempDir()
+	defer func() {
+		mongobin.EtcOsRelease = "/etc/os-release"
+		mongobin.EtcRedhatRelease = "/etc/redhat-release"
+		mongobin.GoOS = runtime.GOOS
+		mongobin.GoArch = runtime.GOARCH
+	}()
+	mongobin.EtcRedhatRelease = filepath.Join(dir, "redhat-release")
+
+	for _, platform := range SupportedPlatforms() {
+		release, detectable := distroReleases[platform.Distro]
+
+		for _, version := range supportedVersionGrid {
+			name := platform.GOOS + "/" + platform.GOARCH + "/" + platform.Distro + "/" + version
+			want := inVersionRange(t, version, platform.MinVersion, platform.MaxVersion)
+
+			ok, reason := Supports(version, platform.GOOS, platform.GOARCH, platform.Distro)
+			assert.Equal(t, want, ok, "%s: %s", name, reason)
+			if ok {
+				assert.Empty(t, reason, name)
+			} else {
+				assert.NotEmpty(t, reason, name)
+			}
+
+			// On the platform itself, MakeDownloadSpec picks the same build
+			if !want || (platform.GOOS == "linux" && !detectable) {
+				continue
+			}
+			mongobin.GoOS, mongobin.GoArch = downloadPlatform(platform.GOOS, platform.GOARCH)
+			mongobin.EtcOsRelease = filepath.Join(dir, "os-release")
+			require.NoError(t, os.WriteFile(mongobin.EtcOsRelease, []byte(release), 0600))
+
+			spec, err := mongobin.MakeDownloadSpec(version)
+			if assert.NoError(t, err, name) {
+				assert.Equal(t, platform.Distro, spec.OSName, name)
+				assert.Equal(t, platform.Arch, spec.Arch, name)
+			}
+		}
+	}
+}
+
+func TestSupportedPlatformsAppleSilicon(t *testing.T) {
+	var found bool
+	for _, platform := range SupportedPlatforms() {
+		if platform.GOOS == "darwin" && platform.GOARCH == "arm64" {
+			found = true
+			assert.Equal(t, "x86_64", platform.Arch)
+			assert.Equal(t, "3.2.0", platform.MinVersion)
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestSupportedMongoVersionsMatchCapabilities(t *testing.T) {
+	ranges := SupportedMongoVersions()
+	require.NotEmpty(t, ranges)
+	assert.Empty(t, ranges[len(ranges)-1].Max)
+
+	for i, r := range ranges {
+		if i > 0 {
+			assert.Equal(t, ranges[i-1].Max, r.Min)
+		}
+
+		caps, err := capabilitiesForVersion(r.Min)
+		require.NoError(t, err)
+		assert.Equal(t, caps.ephemeralForTest, r.EphemeralForTest, r.Min)
+		assert.Equal(t, caps.noJournal, r.NoJournal, r.Min)
+		assert.Equal(t, caps.cacheSizePct, r.CacheSizePct, r.Min)
+	}
+
+	ok, reason := Supports("3.0.0", "linux", "amd64", "ubuntu1404")
+	assert.False(t, ok)
+	assert.Contains(t, reason, "3.2 and above")
+}
+
+func TestSupports(t *testing.T) {
+	tests := map[string]struct {
+		version string
+		goos    string
+		goarch  string
+		distro  string
+
+		expectedReason string
+	}{
+		"ubuntu 22.04": {
+			version: "8.0.0", goos: "linux", goarch: "amd64", distro: "ubuntu2204",
+		},
+		"apple silicon, before native builds": {
+			version: "4.4.0", goos: "darwin", goarch: "arm64",
+		},
+		"distro too new for the version": {
+			version: "4.4.0", goos: "linux", goarch: "amd64", distro: "ubuntu2204",
+			expectedReason: "memongo does not support automatic downloading on your system: MongoDB doesn't publish builds of version 4.4.0 for ubuntu2204, the first one is 6.0.4",
+		},
+		"unknown distro": {
+			version: "8.0.0", goos: "linux", goarch: "amd64", distro: "nixos",
+			expectedReason: "memongo does not support automatic downloading on your system: MongoDB doesn't publish builds for nixos on linux",
+		},
+		"distro on macOS": {
+			version: "8.0.0", goos: "darwin", goarch: "amd64", distro: "ubuntu2204",
+			expectedReason: "memongo does not support automatic downloading on your system: MongoDB doesn't publish builds for ubuntu2204 on darwin",
+		},
+		"windows": {
+			version: "8.0.0", goos: "windows", goarch: "amd64",
+			expectedReason: "memongo does not support automatic downloading on your system: your platform, windows, is not supported",
+		},
+		"arm64 debian": {
+			version: "6.0.0", goos: "linux", goarch: "arm64", distro: "debian11",
+			expectedReason: "memongo does not support automatic downloading on your system: Mongo doesn't support your environment, debian11/arm64, on version 6.0.0",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ok, reason := Supports(test.version, test.goos, test.goarch, test.distro)
+			assert.Equal(t, test.expectedReason == "", ok)
+			assert.Equal(t, test.expectedReason, reason)
+		})
+	}
+}
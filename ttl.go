@@ -0,0 +1,77 @@
+package memongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/100mslive/memongo/v2/retry"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// ttlPassPollInterval is how often TriggerTTLPass checks whether the TTL
+// monitor has run
+const ttlPassPollInterval = 100 * time.Millisecond
+
+// errNoTTLPass is returned by TriggerTTLPass's checks until a full pass has
+// run
+var errNoTTLPass = errors.New("the TTL monitor hasn't run a full pass yet")
+
+// TriggerTTLPass waits for the TTL monitor to run a full pass that started
+// after it was called, so documents that had expired by then are deleted
+// when it returns. The monitor runs every Options.TTLMonitorInterval; with
+// the server's default of 60 seconds this can take up to two minutes, so
+// set TTLMonitorInterval to a second to observe expiry promptly.
+func (s *Server) TriggerTTLPass(ctx context.Context) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+
+	start, err := ttlPasses(ctx, client.Database("admin"))
+	if err != nil {
+		return err
+	}
+
+	err = retry.Do(ctx, retry.Constant{Interval: ttlPassPollInterval}, func() error {
+		passes, err := ttlPasses(ctx, client.Database("admin"))
+		if err != nil {
+			return retry.Permanent(err)
+		}
+
+		// A pass in progress when we started may have begun before the
+		// documents expired, so wait for the one after it too
+		if passes < start+2 {
+			return errNoTTLPass
+		}
+
+		return nil
+	})
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return fmt.Errorf("error waiting for a TTL pass: %w", ctx.Err())
+	}
+
+	return err
+}
+
+// ttlPasses returns how many passes the TTL monitor has made
+func ttlPasses(ctx context.Context, admin *mongo.Database) (int64, error) {
+	var status struct {
+		Metrics struct {
+			TTL struct {
+				Passes int64 `bson:"passes"`
+			} `bson:"ttl"`
+		} `bson:"metrics"`
+	}
+	err := admin.RunCommand(ctx, bson.D{{Key: "serverStatus", Value: 1}}).Decode(&status)
+	if err != nil {
+		return 0, fmt.Errorf("error reading TTL monitor passes: %w", err)
+	}
+
+	return status.Metrics.TTL.Passes, nil
+}
@@ -0,0 +1,212 @@
+package memongo
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/x/mongo/driver/connstring"
+)
+
+// checkURIs makes every connection string memongo builds panic unless the
+// driver parses it, so a badly escaped one fails the test that built it
+// rather than a user's code at runtime. It's on in test binaries, and with
+// MEMONGO_CHECK_URIS=1.
+var checkURIs = isTestBinary() || os.Getenv("MEMONGO_CHECK_URIS") == "1"
+
+// isTestBinary returns whether the process was built by go test
+func isTestBinary() bool {
+	name := filepath.Base(os.Args[0])
+	return strings.HasSuffix(name, ".test") || strings.HasSuffix(name, ".test.exe")
+}
+
+// uriParam is a query parameter of a connection string
+type uriParam struct {
+	key   string
+	value string
+
+	// escaped is set if value is already escaped
+	escaped bool
+}
+
+// connString builds a connection string, escaping each part. Every URI
+// memongo returns is built with one.
+type connString struct {
+	srv      bool
+	hosts    []string
+	user     *url.Userinfo
+	database string
+	params   []uriParam
+}
+
+// mongoURI starts a mongodb:// connection string to hosts, which are
+// host:port addresses
+func mongoURI(hosts ...string) *connString {
+	return &connString{hosts: hosts}
+}
+
+// srvURI starts a mongodb+srv:// connection string for domain
+func srvURI(domain string) *connString {
+	return &connString{srv: true, hosts: []string{domain}}
+}
+
+// credentials authenticates as user with password
+func (c *connString) credentials(user string, password string) *connString {
+	c.user = url.UserPassword(user, password)
+	return c
+}
+
+// db sets the default database
+func (c *connString) db(name string) *connString {
+	c.database = name
+	return c
+}
+
+// param adds a query parameter. Parameters are written in the order they're
+// added.
+func (c *connString) param(key string, value string) *connString {
+	c.params = append(c.params, uriParam{key: key, value: value})
+	return c
+}
+
+// readPreferenceTags adds a readPreferenceTags parameter selecting members
+// with every one of tags, if there are any
+func (c *connString) readPreferenceTags(tags map[string]string) *connString {
+	if len(tags) == 0 {
+		return c
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// The colons and commas separating the tags stay unescaped
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = url.QueryEscape(k) + ":" + url.QueryEscape(tags[k])
+	}
+	c.params = append(c.params, uriParam{key: "readPreferenceTags", value: strings.Join(pairs, ","), escaped: true})
+
+	return c
+}
+
+// format returns the connection string
+func (c *connString) format() string {
+	var b strings.Builder
+	b.WriteString("mongodb")
+	if c.srv {
+		b.WriteString("+srv")
+	}
+	b.WriteString("://")
+	if c.user != nil {
+		b.WriteString(c.user.String())
+		b.WriteByte('@')
+	}
+	b.WriteString(strings.Join(c.hosts, ","))
+
+	if c.database == "" && len(c.params) == 0 {
+		return b.String()
+	}
+	b.WriteByte('/')
+	b.WriteString(url.QueryEscape(c.database))
+
+	for i, p := range c.params {
+		if i == 0 {
+			b.WriteByte('?')
+		} else {
+			b.WriteByte('&')
+		}
+		value := p.value
+		if !p.escaped {
+			value = url.QueryEscape(value)
+		}
+		b.WriteString(url.QueryEscape(p.key) + "=" + value)
+	}
+
+	return b.String()
+}
+
+// String returns the connection string. With checkURIs, it panics if the
+// driver can't parse it.
+func (c *connString) String() string {
+	uri := c.format()
+	if checkURIs {
+		if err := ValidateURI(uri); err != nil {
+			panic(fmt.Sprintf("memongo built an invalid connection string %s: %s", RedactURI(uri), err))
+		}
+	}
+
+	return uri
+}
+
+// srvOnlyParams are the parameters only mongodb+srv URIs can have
+var srvOnlyParams = map[string]bool{
+	"srvmaxhosts":    true,
+	"srvservicename": true,
+}
+
+// ValidateURI returns an error if the driver's connection string parser
+// rejects uri, for checking connection strings built by hand, such as from
+// a server's Host and Port. mongodb+srv URIs are checked without looking up
+// their DNS records: they're parsed as mongodb URIs, after checking they
+// name one host and no port.
+func ValidateURI(uri string) error {
+	if strings.HasPrefix(uri, "mongodb+srv://") {
+		var err error
+		uri, err = srvAsMongoURI(uri)
+		if err != nil {
+			return fmt.Errorf("invalid connection string: %w", err)
+		}
+	}
+
+	_, err := connstring.ParseAndValidate(uri)
+	if err != nil {
+		return fmt.Errorf("invalid connection string: %w", err)
+	}
+
+	return nil
+}
+
+// srvAsMongoURI checks the rules of mongodb+srv URIs the driver would check
+// after looking up uri's DNS records, and returns uri as a mongodb URI
+// without the parameters only mongodb+srv URIs may have
+func srvAsMongoURI(uri string) (string, error) {
+	rest := strings.TrimPrefix(uri, "mongodb+srv://")
+	query := ""
+	if i := strings.Index(rest, "?"); i >= 0 {
+		rest, query = rest[:i], rest[i+1:]
+	}
+	hosts := rest
+	if i := strings.Index(hosts, "/"); i >= 0 {
+		hosts = hosts[:i]
+	}
+	if i := strings.LastIndex(hosts, "@"); i >= 0 {
+		hosts = hosts[i+1:]
+	}
+
+	if strings.Contains(hosts, ",") {
+		return "", fmt.Errorf("a mongodb+srv URI must name exactly one host")
+	}
+	if _, _, err := net.SplitHostPort(hosts); err == nil {
+		return "", fmt.Errorf("a mongodb+srv URI can't have a port number")
+	}
+
+	var kept []string
+	for _, pair := range strings.Split(query, "&") {
+		key := strings.SplitN(pair, "=", 2)[0]
+		if pair != "" && !srvOnlyParams[strings.ToLower(key)] {
+			kept = append(kept, pair)
+		}
+	}
+	if len(kept) > 0 {
+		rest += "?" + strings.Join(kept, "&")
+	}
+
+	return "mongodb://" + rest, nil
+}
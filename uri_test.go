@@ -0,0 +1,122 @@
+package memongo
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/x/mongo/driver/connstring"
+)
+
+func TestConnStringFormat(t *testing.T) {
+	tests := map[string]struct {
+		uri      *connString
+		expected string
+	}{
+		"bare":      {mongoURI("127.0.0.1:27017"), "mongodb://127.0.0.1:27017"},
+		"ipv6":      {mongoURI("[::1]:27017").param("directConnection", "true"), "mongodb://[::1]:27017/?directConnection=true"},
+		"hosts":     {mongoURI("a:1", "b:2").param("replicaSet", "rs0"), "mongodb://a:1,b:2/?replicaSet=rs0"},
+		"database":  {mongoURI("a:1").db("orders"), "mongodb://a:1/orders"},
+		"escaped":   {mongoURI("a:1").credentials("ad@", "p@ss:word/").db("a+b").param("appName", "my app&co"), "mongodb://ad%40:p%40ss%3Aword%2F@a:1/a%2Bb?appName=my+app%26co"},
+		"tags":      {mongoURI("a:1").param("readPreference", "nearest").readPreferenceTags(map[string]string{"dc": "east", "a b": "c"}), "mongodb://a:1/?readPreference=nearest&readPreferenceTags=a+b:c,dc:east"},
+		"no tags":   {mongoURI("a:1").param("readPreference", "primary").readPreferenceTags(nil), "mongodb://a:1/?readPreference=primary"},
+		"srv":       {srvURI("memongo.test").param("tls", "false"), "mongodb+srv://memongo.test/?tls=false"},
+		"srv, auth": {srvURI("memongo.test").credentials("ada", "pw"), "mongodb+srv://ada:pw@memongo.test"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.uri.String())
+		})
+	}
+}
+
+// TestConnStringMatrix checks that every combination of options builds a
+// connection string the driver parses back to the same values
+func TestConnStringMatrix(t *testing.T) {
+	users := []struct{ user, password string }{{}, {"ada", "pw"}, {"ad a@x", "p@:/?#%+ &="}}
+	databases := []string{"", "orders", "a+b c"}
+	for _, creds := range users {
+		for _, tls := range []bool{false, true} {
+			for _, replica := range []bool{false, true} {
+				for _, database := range databases {
+					for _, extra := range []bool{false, true} {
+						uri := mongoURI("127.0.0.1:27017", "localhost:27018")
+						if creds.user != "" {
+							uri.credentials(creds.user, creds.password).param("authSource", "admin")
+						}
+						if tls {
+							uri.param("tls", "true")
+						}
+						if replica {
+							uri.param("replicaSet", "rs 0")
+						}
+						uri.db(database)
+						if extra {
+							uri.param("appName", "a&b=c").readPreferenceTags(map[string]string{"dc": "east"}).param("readPreference", "nearest")
+						}
+
+						name := fmt.Sprintf("%q %t %t %q %t", creds.user, tls, replica, database, extra)
+						t.Run(name, func(t *testing.T) {
+							s := uri.String()
+							require.NoError(t, ValidateURI(s))
+
+							parsed, err := connstring.ParseAndValidate(s)
+							require.NoError(t, err)
+							assert.Equal(t, []string{"127.0.0.1:27017", "localhost:27018"}, parsed.Hosts)
+							assert.Equal(t, creds.user, parsed.Username)
+							assert.Equal(t, creds.password, parsed.Password)
+							assert.Equal(t, tls, parsed.SSL)
+							assert.Equal(t, database, parsed.Database)
+							if replica {
+								assert.Equal(t, "rs 0", parsed.ReplicaSet)
+							}
+							if extra {
+								assert.Equal(t, "a&b=c", parsed.AppName)
+								assert.Equal(t, []map[string]string{{"dc": "east"}}, parsed.ReadPreferenceTagSets)
+							}
+						})
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestValidateURI(t *testing.T) {
+	for _, uri := range []string{
+		"mongodb://localhost",
+		"mongodb://ada:pw@localhost:27017/app?authSource=admin",
+		"mongodb+srv://memongo.test/?tls=false",
+		"mongodb+srv://memongo.test/?srvServiceName=db&srvMaxHosts=2",
+		"mongodb+srv://memongo.test",
+	} {
+		assert.NoError(t, ValidateURI(uri), uri)
+	}
+
+	for uri, message := range map[string]string{
+		"http://localhost":                        `scheme must be "mongodb" or "mongodb+srv"`,
+		"mongodb://ada:p:w@localhost":             "unescaped colon in password",
+		"mongodb://localhost:99999":               "port must be in the range",
+		"mongodb://localhost/?directConnection=1": "invalid 'directConnection' value",
+		"mongodb+srv://a.test,b.test":             "exactly one host",
+		"mongodb+srv://memongo.test:27017":        "port number",
+	} {
+		err := ValidateURI(uri)
+		if assert.Error(t, err, uri) {
+			assert.Contains(t, err.Error(), message, uri)
+		}
+	}
+}
+
+func TestConnStringCheck(t *testing.T) {
+	require.True(t, checkURIs, "URIs are checked in test binaries")
+
+	assert.Panics(t, func() {
+		_ = mongoURI("localhost:99999").String()
+	})
+
+	// format doesn't check
+	assert.Equal(t, "mongodb://localhost:99999", mongoURI("localhost:99999").format())
+}